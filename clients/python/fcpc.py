@@ -0,0 +1,892 @@
+# Code generated by src/cmd/gen from api.yml. DO NOT EDIT.
+# Needs Python 3.11 or later and nothing outside the standard library.
+
+from __future__ import annotations
+
+import datetime
+import json
+import re
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, NotRequired, Optional, TypedDict
+
+
+class SignedURLRequest(TypedDict):
+    # The account the receipt is credited to.
+    account: str
+    # How long the URL is valid, like "5m". Defaults to 5m, at most the configured maximum.
+    ttl: NotRequired[str]
+
+
+class SignedURL(TypedDict):
+    url: NotRequired[str]
+    expiresAt: NotRequired[str]
+
+
+class NotificationSettings(TypedDict):
+    # A plain address, empty for no notifications.
+    email: NotRequired[str]
+    optOut: NotRequired[bool]
+
+
+class AccountPreferences(TypedDict):
+    # An IANA timezone name; the account's days and months start at midnight there. UTC by default.
+    timezone: NotRequired[str]
+    # The language errors from /receipts/process are in, English by default.
+    locale: NotRequired[str]
+    # Event types set to false are not sent to the account.
+    notifications: NotRequired[dict[str, bool]]
+
+
+class Statement(TypedDict):
+    account: NotRequired[str]
+    month: NotRequired[str]
+    # Where the month starts and ends, the account's timezone.
+    timezone: NotRequired[str]
+    openingBalance: NotRequired[int]
+    earned: NotRequired[int]
+    # Points transferred away, including fees.
+    redeemed: NotRequired[int]
+    closingBalance: NotRequired[int]
+    # Points expiring at the end of the following month. Always 0 unless the config sets an expiry.
+    expiringSoon: NotRequired[int]
+    receipts: NotRequired[list[StatementReceipt]]
+    generatedAt: NotRequired[str]
+
+
+class StatementReceipt(TypedDict):
+    id: NotRequired[str]
+    retailer: NotRequired[str]
+    purchaseDate: NotRequired[str]
+    total: NotRequired[str]
+    points: NotRequired[int]
+    creditedAt: NotRequired[str]
+
+
+class PointsHold(TypedDict):
+    receiptId: NotRequired[str]
+    account: NotRequired[str]
+    # What the receipt earned when it was processed. It settles with what it earns then, in case it was amended.
+    points: NotRequired[int]
+    placedAt: NotRequired[str]
+    settlesAt: NotRequired[str]
+
+
+class Streak(TypedDict):
+    account: NotRequired[str]
+    # day or week.
+    unit: NotRequired[str]
+    current: NotRequired[int]
+    longest: NotRequired[int]
+    # The latest day (2022-01-02) or ISO week (2022-W01) with a receipt.
+    lastPeriod: NotRequired[str]
+    # False once a whole day or week went by without a receipt.
+    active: NotRequired[bool]
+
+
+class Transfer(TypedDict):
+    # The receiving account.
+    to: str
+    points: int
+
+
+TransferResult = TypedDict("TransferResult", {
+    "txId": NotRequired[str],
+    "from": NotRequired[str],
+    "to": NotRequired[str],
+    "points": NotRequired[int],
+    "fee": NotRequired[int],
+    # The sender's balance after the transfer.
+    "balance": NotRequired[int],
+    # Set when the Idempotency-Key matched an earlier transfer and no points were moved.
+    "replayed": NotRequired[bool],
+})
+
+
+class Score(TypedDict):
+    points: NotRequired[int]
+    # Set when the account is over its daily receipt limit, the breakdown then ends with a dailyReceiptCap rule cancelling out the others.
+    throttled: NotRequired[bool]
+    breakdown: NotRequired[list[RuleResult]]
+    warnings: NotRequired[list[Warning]]
+
+
+class CanonicalReceipt(TypedDict):
+    # The receipt with the retailer normalized, descriptions trimmed to single spaces, prices with two decimals, items ordered by description and then price, and transaction numbers and postal codes uppercase without separators.
+    receipt: Receipt
+    # The hex SHA-256 of the canonical receipt as compact JSON, with the fields in the order returned.
+    fingerprint: str
+
+
+class Warning(TypedDict):
+    """Something about an accepted receipt that was fixed up or looks off. Warnings never fail the request."""
+
+    field: str
+    message: str
+
+
+class RuleResult(TypedDict):
+    rule: NotRequired[str]
+    description: NotRequired[str]
+    points: NotRequired[int]
+    # For rules scoring items one by one, the items that earned points.
+    items: NotRequired[list[ItemPoints]]
+
+
+class CompareRequest(TypedDict):
+    # A receipt payload, or the ID of a stored receipt as a string.
+    a: Any
+    # A receipt payload, or the ID of a stored receipt as a string.
+    b: Any
+
+
+class ComparedReceipt(TypedDict):
+    # The ID of the stored receipt, if one was given.
+    id: NotRequired[str]
+    # The points the stored receipt was awarded, which differ from points once the rules changed.
+    storedPoints: NotRequired[int]
+    points: NotRequired[int]
+    breakdown: NotRequired[list[RuleResult]]
+
+
+class RuleDelta(TypedDict):
+    rule: NotRequired[str]
+    a: NotRequired[int]
+    b: NotRequired[int]
+    delta: NotRequired[int]
+
+
+class Comparison(TypedDict):
+    a: NotRequired[ComparedReceipt]
+    b: NotRequired[ComparedReceipt]
+    # The points of b minus the points of a.
+    delta: NotRequired[int]
+    rules: NotRequired[list[RuleDelta]]
+
+
+class ErasureCertificate(TypedDict):
+    id: NotRequired[str]
+    # The hex SHA-256 of the account ID. The ID itself isn't kept.
+    accountHash: NotRequired[str]
+    # scheduled, cancelled or completed.
+    status: NotRequired[str]
+    requestedAt: NotRequired[str]
+    executeAt: NotRequired[str]
+    completedAt: NotRequired[str]
+    receiptsErased: NotRequired[int]
+    entriesAnonymized: NotRequired[int]
+    auditRecordsErased: NotRequired[int]
+    aliasesErased: NotRequired[int]
+
+
+class ItemPoints(TypedDict):
+    # The index of the item in the receipt's items.
+    item: NotRequired[int]
+    shortDescription: NotRequired[str]
+    points: NotRequired[int]
+
+
+class Explanation(TypedDict):
+    points: int
+    explanation: list[str]
+
+
+class StoredReceipt(TypedDict):
+    id: NotRequired[str]
+    points: NotRequired[int]
+    receipt: NotRequired[Receipt]
+    createdAt: NotRequired[str]
+
+
+class Receipt(TypedDict):
+    # The name of the retailer or store the receipt is from.
+    retailer: str
+    # The date of the purchase printed on the receipt.
+    purchaseDate: str
+    # The time of the purchase printed on the receipt. 24-hour time expected.
+    purchaseTime: str
+    # At most 500 items unless the server is configured otherwise.
+    items: list[Item]
+    # The total amount paid on the receipt.
+    total: str
+    storeLocation: NotRequired[StoreLocation]
+    # The unique code the retailer printed on the receipt, often as a barcode. Optional; must match the retailer's configured format and check digit. A second receipt with the same number from the same retailer is rejected.
+    transactionNumber: NotRequired[str]
+    # How the receipt was paid. Optional.
+    paymentMethod: NotRequired[str]
+    # Partner data like correlation IDs, stored and returned as submitted and addressable by the metadata rule as key=value. Keys are letters, digits, underscores and hyphens, starting with a letter. Optional.
+    metadata: NotRequired[dict[str, str]]
+
+
+class StoreLocation(TypedDict):
+    """Where the receipt was issued, a postal code, coordinates or both. Optional."""
+
+    postalCode: NotRequired[str]
+    # Required with a longitude.
+    latitude: NotRequired[float]
+    # Required with a latitude.
+    longitude: NotRequired[float]
+
+
+class RegionStats(TypedDict):
+    # The region as configured, "other" for receipts outside every region and "unknown" for receipts without a location.
+    region: str
+    receipts: int
+    points: int
+    # The sum of the receipt totals.
+    total: str
+
+
+class EventSchema(TypedDict):
+    type: str
+    version: int
+    description: str
+    fields: list[EventField]
+
+
+class EventField(TypedDict):
+    # The name of the field in the JSON payload.
+    name: str
+    type: str
+    required: bool
+    description: str
+
+
+class AggregateBucket(TypedDict):
+    # The value of the groupBy field, empty for receipts without it and without groupBy.
+    group: str
+    # The day, the Monday of the week or the month (2022-01), empty without period.
+    period: str
+    # The number of receipts or points, or the total in dollars.
+    value: float
+
+
+class PaymentMethodStats(TypedDict):
+    # The payment method, "unknown" for receipts without one.
+    paymentMethod: str
+    receipts: int
+    points: int
+    # The sum of the receipt totals.
+    total: str
+
+
+class HourlyStats(TypedDict):
+    # The hour of the purchase time, 0 to 23.
+    hour: int
+    receipts: int
+    points: int
+
+
+class WeekdayStats(TypedDict):
+    weekday: str
+    receipts: int
+    points: int
+    hours: list[HourlyStats]
+
+
+class ReceiptGroup(TypedDict):
+    id: str
+    receiptIds: list[str]
+    createdAt: str
+
+
+class ReceiptTemplate(TypedDict):
+    id: NotRequired[str]
+    retailer: str
+    items: NotRequired[list[Item]]
+    storeLocation: NotRequired[StoreLocation]
+    paymentMethod: NotRequired[str]
+    createdAt: NotRequired[str]
+
+
+class ReceiptReturn(TypedDict):
+    id: str
+    receiptId: str
+    items: list[Item]
+    # The sum of the returned item prices.
+    amount: str
+    # The points taken back, 0 for receipts no account was credited for.
+    points: int
+    # The ledger transaction that took the points back.
+    txId: NotRequired[str]
+    createdAt: str
+
+
+class Amendment(TypedDict):
+    id: str
+    # The points of the receipt after the amendment.
+    points: int
+    previousPoints: int
+    # The sum of the item prices, to compare with the total.
+    itemsTotal: str
+    receipt: Receipt
+
+
+class Reprocessed(TypedDict):
+    id: str
+    # The points of the receipt after reprocessing.
+    points: int
+    previousPoints: int
+    breakdown: list[RuleResult]
+
+
+class Item(TypedDict):
+    # The Short Product Description for the item, at most 100 characters unless the server is configured otherwise.
+    shortDescription: str
+    # The total price payed for this item.
+    price: str
+
+
+class ListReceiptsResponse(TypedDict):
+    receipts: NotRequired[list[StoredReceipt]]
+    # Missing on the last page.
+    nextCursor: NotRequired[str]
+
+
+class ProcessReceiptResponse(TypedDict):
+    id: str
+    # Set when the account was over its daily receipt limit, the receipt earned no points.
+    throttled: NotRequired[bool]
+    # Set when the receipt was sampled for manual review, its points are credited once it's approved.
+    underReview: NotRequired[bool]
+    # Set when the receipt's points were placed on hold, they are credited once they settle.
+    held: NotRequired[bool]
+    warnings: NotRequired[list[Warning]]
+
+
+class SubmitSignedResponse(TypedDict):
+    id: str
+    throttled: NotRequired[bool]
+    underReview: NotRequired[bool]
+    held: NotRequired[bool]
+    warnings: NotRequired[list[Warning]]
+
+
+GetRegionStatsResponse = TypedDict("GetRegionStatsResponse", {
+    "from": NotRequired[str],
+    "to": NotRequired[str],
+    "regions": list[RegionStats],
+})
+
+
+GetPaymentMethodStatsResponse = TypedDict("GetPaymentMethodStatsResponse", {
+    "from": NotRequired[str],
+    "to": NotRequired[str],
+    "paymentMethods": list[PaymentMethodStats],
+})
+
+
+GetHourlyStatsResponse = TypedDict("GetHourlyStatsResponse", {
+    "from": NotRequired[str],
+    "to": NotRequired[str],
+    "hours": list[HourlyStats],
+    "weekdays": list[WeekdayStats],
+})
+
+
+GetAggregateResponse = TypedDict("GetAggregateResponse", {
+    "groupBy": NotRequired[str],
+    "metric": str,
+    "period": NotRequired[str],
+    "from": NotRequired[str],
+    "to": NotRequired[str],
+    "buckets": list[AggregateBucket],
+})
+
+
+class GetEventSchemasResponse(TypedDict):
+    schemas: list[EventSchema]
+
+
+class GetPointsResponse(TypedDict):
+    points: NotRequired[int]
+
+
+class ListReturnsResponse(TypedDict):
+    returns: list[ReceiptReturn]
+
+
+class GetReceiptGroupPointsResponse(TypedDict):
+    id: str
+    points: int
+    receipts: list[GetReceiptGroupPointsResponseReceiptsItem]
+
+
+class GetReceiptGroupPointsResponseReceiptsItem(TypedDict):
+    id: str
+    points: int
+
+
+class GetBalanceResponse(TypedDict):
+    account: NotRequired[str]
+    balance: NotRequired[int]
+    # Points on hold, not part of the balance until they settle.
+    held: NotRequired[int]
+
+
+class ListHoldsResponse(TypedDict):
+    holds: list[PointsHold]
+
+
+class ListAccountReceiptsResponse(TypedDict):
+    receipts: NotRequired[list[StoredReceipt]]
+    # Missing on the last page.
+    nextCursor: NotRequired[str]
+
+
+class ApiError(Exception):
+    def __init__(self, status: int, body: str) -> None:
+        super().__init__(f"request failed with status {status}: {body}")
+        self.status = status
+        self.body = body
+
+
+class ValidationError(Exception):
+    """Raised before sending a request the API would reject anyway."""
+
+    def __init__(self, errors: list[str]) -> None:
+        super().__init__("; ".join(errors))
+        self.errors = errors
+
+
+def _at(path: str, field: str) -> str:
+    return f"{path}.{field}" if path else field
+
+
+def _check_string(value: Any, path: str, errors: list[str], pattern: Optional[re.Pattern[str]], fmt: Optional[str]) -> None:
+    if not isinstance(value, str):
+        errors.append(f"{path}: must be a string")
+    elif pattern is not None and not pattern.fullmatch(value):
+        errors.append(f"{path}: must match {pattern.pattern}")
+    elif fmt == "date" and not _parses(value, "%Y-%m-%d", r"\d{4}-\d{2}-\d{2}"):
+        errors.append(f"{path}: want YYYY-MM-DD format")
+    elif fmt == "time" and not _parses(value, "%H:%M", r"\d{2}:\d{2}"):
+        errors.append(f"{path}: want HH:MM format")
+
+
+def _parses(value: str, layout: str, shape: str) -> bool:
+    if not re.fullmatch(shape, value, re.ASCII):
+        return False
+    try:
+        datetime.datetime.strptime(value, layout)
+    except ValueError:
+        return False
+    return True
+
+
+def _check_number(value: Any, path: str, errors: list[str], integer: bool, minimum: Optional[float], maximum: Optional[float]) -> None:
+    if isinstance(value, bool) or not isinstance(value, int if integer else (int, float)):
+        errors.append(f"{path}: must be {'an integer' if integer else 'a number'}")
+    elif minimum is not None and value < minimum:
+        errors.append(f"{path}: must be at least {minimum:g}")
+    elif maximum is not None and value > maximum:
+        errors.append(f"{path}: must be at most {maximum:g}")
+
+
+def _check_bool(value: Any, path: str, errors: list[str]) -> None:
+    if not isinstance(value, bool):
+        errors.append(f"{path}: must be a boolean")
+
+
+def _check_array(value: Any, path: str, errors: list[str], min_items: int) -> bool:
+    if not isinstance(value, list):
+        errors.append(f"{path}: must be an array")
+        return False
+    if len(value) < min_items:
+        errors.append(f"{path}: must contain at least {min_items} item(s)")
+    return True
+
+
+def _check_object(value: Any, path: str, errors: list[str]) -> bool:
+    if not isinstance(value, dict):
+        errors.append(f"{path}: must be an object")
+        return False
+    return True
+
+
+def validate_signed_url_request(value: Any, path: str = "") -> list[str]:
+    """Checks a SignedURLRequest against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("account") is None:
+        errors.append(f"{_at(path, 'account')}: is required")
+    else:
+        _check_string(value["account"], _at(path, 'account'), errors, None, None)
+    if value.get("ttl") is not None:
+        _check_string(value["ttl"], _at(path, 'ttl'), errors, None, None)
+    return errors
+
+
+def validate_notification_settings(value: Any, path: str = "") -> list[str]:
+    """Checks a NotificationSettings against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("email") is not None:
+        _check_string(value["email"], _at(path, 'email'), errors, None, None)
+    if value.get("optOut") is not None:
+        _check_bool(value["optOut"], _at(path, 'optOut'), errors)
+    return errors
+
+
+def validate_account_preferences(value: Any, path: str = "") -> list[str]:
+    """Checks a AccountPreferences against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("timezone") is not None:
+        _check_string(value["timezone"], _at(path, 'timezone'), errors, None, None)
+    if value.get("locale") is not None:
+        _check_string(value["locale"], _at(path, 'locale'), errors, None, None)
+    if value.get("notifications") is not None:
+        if _check_object(value["notifications"], _at(path, 'notifications'), errors):
+            for key0, item0 in value["notifications"].items():
+                _check_bool(item0, _at(_at(path, 'notifications'), key0), errors)
+    return errors
+
+
+def validate_transfer(value: Any, path: str = "") -> list[str]:
+    """Checks a Transfer against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("to") is None:
+        errors.append(f"{_at(path, 'to')}: is required")
+    else:
+        _check_string(value["to"], _at(path, 'to'), errors, None, None)
+    if value.get("points") is None:
+        errors.append(f"{_at(path, 'points')}: is required")
+    else:
+        _check_number(value["points"], _at(path, 'points'), errors, True, 1, None)
+    return errors
+
+
+def validate_compare_request(value: Any, path: str = "") -> list[str]:
+    """Checks a CompareRequest against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("a") is None:
+        errors.append(f"{_at(path, 'a')}: is required")
+    else:
+        pass
+    if value.get("b") is None:
+        errors.append(f"{_at(path, 'b')}: is required")
+    else:
+        pass
+    return errors
+
+
+def validate_receipt(value: Any, path: str = "") -> list[str]:
+    """Checks a Receipt against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("retailer") is None:
+        errors.append(f"{_at(path, 'retailer')}: is required")
+    else:
+        _check_string(value["retailer"], _at(path, 'retailer'), errors, re.compile(r"^[\w\s\-&]+$", re.ASCII), None)
+    if value.get("purchaseDate") is None:
+        errors.append(f"{_at(path, 'purchaseDate')}: is required")
+    else:
+        _check_string(value["purchaseDate"], _at(path, 'purchaseDate'), errors, None, "date")
+    if value.get("purchaseTime") is None:
+        errors.append(f"{_at(path, 'purchaseTime')}: is required")
+    else:
+        _check_string(value["purchaseTime"], _at(path, 'purchaseTime'), errors, None, "time")
+    if value.get("items") is None:
+        errors.append(f"{_at(path, 'items')}: is required")
+    else:
+        if _check_array(value["items"], _at(path, 'items'), errors, 1):
+            for i0, item0 in enumerate(value["items"]):
+                errors.extend(validate_item(item0, _at(_at(path, 'items'), str(i0))))
+    if value.get("total") is None:
+        errors.append(f"{_at(path, 'total')}: is required")
+    else:
+        _check_string(value["total"], _at(path, 'total'), errors, re.compile(r"^\d+\.\d{2}$", re.ASCII), None)
+    if value.get("storeLocation") is not None:
+        errors.extend(validate_store_location(value["storeLocation"], _at(path, 'storeLocation')))
+    if value.get("transactionNumber") is not None:
+        _check_string(value["transactionNumber"], _at(path, 'transactionNumber'), errors, None, None)
+    if value.get("paymentMethod") is not None:
+        _check_string(value["paymentMethod"], _at(path, 'paymentMethod'), errors, None, None)
+    if value.get("metadata") is not None:
+        if _check_object(value["metadata"], _at(path, 'metadata'), errors):
+            for key0, item0 in value["metadata"].items():
+                _check_string(item0, _at(_at(path, 'metadata'), key0), errors, None, None)
+    return errors
+
+
+def validate_store_location(value: Any, path: str = "") -> list[str]:
+    """Checks a StoreLocation against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("postalCode") is not None:
+        _check_string(value["postalCode"], _at(path, 'postalCode'), errors, re.compile(r"^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$", re.ASCII), None)
+    if value.get("latitude") is not None:
+        _check_number(value["latitude"], _at(path, 'latitude'), errors, False, -90, 90)
+    if value.get("longitude") is not None:
+        _check_number(value["longitude"], _at(path, 'longitude'), errors, False, -180, 180)
+    return errors
+
+
+def validate_receipt_template(value: Any, path: str = "") -> list[str]:
+    """Checks a ReceiptTemplate against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("id") is not None:
+        _check_string(value["id"], _at(path, 'id'), errors, None, None)
+    if value.get("retailer") is None:
+        errors.append(f"{_at(path, 'retailer')}: is required")
+    else:
+        _check_string(value["retailer"], _at(path, 'retailer'), errors, re.compile(r"^[\w\s\-&]+$", re.ASCII), None)
+    if value.get("items") is not None:
+        if _check_array(value["items"], _at(path, 'items'), errors, 0):
+            for i0, item0 in enumerate(value["items"]):
+                errors.extend(validate_item(item0, _at(_at(path, 'items'), str(i0))))
+    if value.get("storeLocation") is not None:
+        errors.extend(validate_store_location(value["storeLocation"], _at(path, 'storeLocation')))
+    if value.get("paymentMethod") is not None:
+        _check_string(value["paymentMethod"], _at(path, 'paymentMethod'), errors, None, None)
+    if value.get("createdAt") is not None:
+        _check_string(value["createdAt"], _at(path, 'createdAt'), errors, None, "date-time")
+    return errors
+
+
+def validate_item(value: Any, path: str = "") -> list[str]:
+    """Checks a Item against api.yml, returning one message per problem."""
+    if not isinstance(value, dict):
+        return [f"{path or 'value'}: must be an object"]
+    errors: list[str] = []
+    if value.get("shortDescription") is None:
+        errors.append(f"{_at(path, 'shortDescription')}: is required")
+    else:
+        _check_string(value["shortDescription"], _at(path, 'shortDescription'), errors, re.compile(r"^[\w\s\-]+$", re.ASCII), None)
+    if value.get("price") is None:
+        errors.append(f"{_at(path, 'price')}: is required")
+    else:
+        _check_string(value["price"], _at(path, 'price'), errors, re.compile(r"^\d+\.\d{2}$", re.ASCII), None)
+    return errors
+
+
+class Client:
+    def __init__(self, base_url: str, timeout: float = 10.0) -> None:
+        """base_url is e.g. "https://receipts.example.com", without a trailing path."""
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+
+    def _request(
+        self,
+        method: str,
+        path: str,
+        query: Optional[dict[str, Any]] = None,
+        body: Any = None,
+        headers: Optional[dict[str, Optional[str]]] = None,
+    ) -> Any:
+        url = self.base_url + path
+        params = {k: v for k, v in (query or {}).items() if v is not None}
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+
+        data, sent = None, {k: v for k, v in (headers or {}).items() if v is not None}
+        if body is not None:
+            data = json.dumps(body).encode()
+            sent["Content-Type"] = "application/json"
+
+        req = urllib.request.Request(url, data=data, headers=sent, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                text = resp.read().decode()
+        except urllib.error.HTTPError as e:
+            raise ApiError(e.code, e.read().decode()) from None
+        return json.loads(text) if text else None
+
+    def list_receipts(self, *, limit: Optional[int] = None, sort: Optional[str] = None, order: Optional[str] = None, cursor: Optional[str] = None, fields: Optional[str] = None, compact: Optional[bool] = None, transaction_number: Optional[str] = None) -> ListReceiptsResponse:
+        """Lists the most recently processed receipts."""
+        return self._request("GET", f"/receipts", {"limit": limit, "sort": sort, "order": order, "cursor": cursor, "fields": fields, "compact": compact, "transactionNumber": transaction_number}, None, None)
+
+    def process_receipt(self, body: Receipt, *, x_account_id: Optional[str] = None, x_priority: Optional[str] = None) -> ProcessReceiptResponse:
+        """Submits a receipt for processing."""
+        errors = validate_receipt(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/process", None, body, {"X-Account-ID": x_account_id, "X-Priority": x_priority})
+
+    def score_receipt(self, body: Receipt, *, x_account_id: Optional[str] = None) -> Score:
+        """Scores a receipt without storing it."""
+        errors = validate_receipt(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/score", None, body, {"X-Account-ID": x_account_id})
+
+    def compare_receipts(self, body: CompareRequest) -> Comparison:
+        """Compares the points of two receipts."""
+        errors = validate_compare_request(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/compare", None, body, None)
+
+    def canonicalize_receipt(self, body: Receipt) -> CanonicalReceipt:
+        """Returns the canonical form of a receipt and its fingerprint."""
+        errors = validate_receipt(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/canonicalize", None, body, None)
+
+    def create_signed_url(self, body: SignedURLRequest) -> SignedURL:
+        """Mints a one-time submission URL."""
+        errors = validate_signed_url_request(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/signed-urls", None, body, None)
+
+    def submit_signed(self, body: Receipt, *, account: str, expires: int, nonce: str, signature: str) -> SubmitSignedResponse:
+        """Submits a receipt through a signed URL."""
+        errors = validate_receipt(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipts/submit", {"account": account, "expires": expires, "nonce": nonce, "signature": signature}, body, None)
+
+    def get_region_stats(self, *, from_: Optional[str] = None, to: Optional[str] = None) -> GetRegionStatsResponse:
+        """Aggregates stored receipts by region."""
+        return self._request("GET", f"/stats/regions", {"from": from_, "to": to}, None, None)
+
+    def get_payment_method_stats(self, *, from_: Optional[str] = None, to: Optional[str] = None) -> GetPaymentMethodStatsResponse:
+        """Aggregates stored receipts by payment method."""
+        return self._request("GET", f"/stats/payment-methods", {"from": from_, "to": to}, None, None)
+
+    def get_hourly_stats(self, *, from_: Optional[str] = None, to: Optional[str] = None) -> GetHourlyStatsResponse:
+        """Aggregates stored receipts by hour of the day and day of the week."""
+        return self._request("GET", f"/stats/hourly", {"from": from_, "to": to}, None, None)
+
+    def get_aggregate(self, *, group_by: Optional[str] = None, metric: Optional[str] = None, period: Optional[str] = None, from_: Optional[str] = None, to: Optional[str] = None) -> GetAggregateResponse:
+        """Sums a metric of the stored receipts per group and period."""
+        return self._request("GET", f"/aggregate", {"groupBy": group_by, "metric": metric, "period": period, "from": from_, "to": to}, None, None)
+
+    def get_event_schemas(self) -> GetEventSchemasResponse:
+        """Lists the schemas of the events the service publishes."""
+        return self._request("GET", f"/events/schemas", None, None, None)
+
+    def get_erasure(self, id: str) -> ErasureCertificate:
+        """Returns an erasure certificate."""
+        return self._request("GET", f"/erasures/{urllib.parse.quote(id, safe='')}", None, None, None)
+
+    def cancel_erasure(self, id: str) -> ErasureCertificate:
+        """Cancels a scheduled erasure during its grace period."""
+        return self._request("DELETE", f"/erasures/{urllib.parse.quote(id, safe='')}", None, None, None)
+
+    def get_points(self, id: str) -> GetPointsResponse:
+        """Returns the points awarded for the receipt."""
+        return self._request("GET", f"/receipts/{urllib.parse.quote(id, safe='')}/points", None, None, None)
+
+    def explain_points(self, id: str) -> Explanation:
+        """Explains the points awarded for the receipt."""
+        return self._request("GET", f"/receipts/{urllib.parse.quote(id, safe='')}/points/explain", None, None, None)
+
+    def create_return(self, id: str, body: dict[str, Any]) -> ReceiptReturn:
+        """Records returned items of a receipt."""
+        return self._request("POST", f"/receipts/{urllib.parse.quote(id, safe='')}/returns", None, body, None)
+
+    def list_returns(self, id: str) -> ListReturnsResponse:
+        """Lists the returns of a receipt."""
+        return self._request("GET", f"/receipts/{urllib.parse.quote(id, safe='')}/returns", None, None, None)
+
+    def add_item(self, id: str, body: dict[str, Any]) -> Amendment:
+        """Adds an item to a receipt."""
+        return self._request("POST", f"/receipts/{urllib.parse.quote(id, safe='')}/items", None, body, None)
+
+    def delete_item(self, id: str, index: int, *, total: Optional[str] = None) -> Amendment:
+        """Removes an item from a receipt."""
+        return self._request("DELETE", f"/receipts/{urllib.parse.quote(id, safe='')}/items/{urllib.parse.quote(index, safe='')}", {"total": total}, None, None)
+
+    def reprocess_receipt(self, id: str, body: dict[str, Any]) -> Reprocessed:
+        """Scores a stored receipt again."""
+        return self._request("POST", f"/receipts/{urllib.parse.quote(id, safe='')}/reprocess", None, body, None)
+
+    def create_receipt_group(self, body: dict[str, Any]) -> ReceiptGroup:
+        """Links receipts of one shopping trip."""
+        return self._request("POST", f"/receipt-groups", None, body, None)
+
+    def get_receipt_group(self, id: str) -> ReceiptGroup:
+        """Returns a receipt group."""
+        return self._request("GET", f"/receipt-groups/{urllib.parse.quote(id, safe='')}", None, None, None)
+
+    def get_receipt_group_points(self, id: str) -> GetReceiptGroupPointsResponse:
+        """Returns the points awarded for a receipt group."""
+        return self._request("GET", f"/receipt-groups/{urllib.parse.quote(id, safe='')}/points", None, None, None)
+
+    def create_receipt_template(self, body: ReceiptTemplate) -> ReceiptTemplate:
+        """Registers a receipt template."""
+        errors = validate_receipt_template(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/receipt-templates", None, body, None)
+
+    def get_receipt_template(self, id: str) -> ReceiptTemplate:
+        """Returns a receipt template."""
+        return self._request("GET", f"/receipt-templates/{urllib.parse.quote(id, safe='')}", None, None, None)
+
+    def delete_receipt_template(self, id: str) -> None:
+        """Deletes a receipt template. Receipts submitted from it are kept."""
+        return self._request("DELETE", f"/receipt-templates/{urllib.parse.quote(id, safe='')}", None, None, None)
+
+    def get_balance(self, id: str) -> GetBalanceResponse:
+        """Returns the points balance of an account."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/balance", None, None, None)
+
+    def erase_account_data(self, id: str) -> ErasureCertificate:
+        """Schedules the erasure of all data of an account."""
+        return self._request("DELETE", f"/accounts/{urllib.parse.quote(id, safe='')}/data", None, None, None)
+
+    def list_holds(self, id: str) -> ListHoldsResponse:
+        """Lists the points of an account on hold."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/holds", None, None, None)
+
+    def get_notification_settings(self, id: str) -> NotificationSettings:
+        """Returns the notification settings of an account."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/notifications", None, None, None)
+
+    def put_notification_settings(self, id: str, body: NotificationSettings) -> NotificationSettings:
+        """Sets where an account's notifications go, or opts out of them."""
+        errors = validate_notification_settings(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("PUT", f"/accounts/{urllib.parse.quote(id, safe='')}/notifications", None, body, None)
+
+    def get_preferences(self, id: str) -> AccountPreferences:
+        """Returns the preferences of an account."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/preferences", None, None, None)
+
+    def put_preferences(self, id: str, body: AccountPreferences) -> AccountPreferences:
+        """Replaces the preferences of an account."""
+        errors = validate_account_preferences(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("PUT", f"/accounts/{urllib.parse.quote(id, safe='')}/preferences", None, body, None)
+
+    def delete_preferences(self, id: str) -> None:
+        """Puts an account back on the default preferences."""
+        return self._request("DELETE", f"/accounts/{urllib.parse.quote(id, safe='')}/preferences", None, None, None)
+
+    def list_account_receipts(self, id: str, *, limit: Optional[int] = None, sort: Optional[str] = None, order: Optional[str] = None, cursor: Optional[str] = None, fields: Optional[str] = None, compact: Optional[bool] = None) -> ListAccountReceiptsResponse:
+        """Lists an account's receipts, newest first."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/receipts", {"limit": limit, "sort": sort, "order": order, "cursor": cursor, "fields": fields, "compact": compact}, None, None)
+
+    def get_statement(self, id: str, *, month: str, format: Optional[str] = None) -> Statement:
+        """Returns the monthly statement of an account."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/statement", {"month": month, "format": format}, None, None)
+
+    def get_streak(self, id: str) -> Streak:
+        """Returns the receipt streak of an account."""
+        return self._request("GET", f"/accounts/{urllib.parse.quote(id, safe='')}/streak", None, None, None)
+
+    def transfer_points(self, id: str, body: Transfer, *, idempotency_key: str) -> TransferResult:
+        """Moves points to another account."""
+        errors = validate_transfer(body)
+        if errors:
+            raise ValidationError(errors)
+        return self._request("POST", f"/accounts/{urllib.parse.quote(id, safe='')}/transfer", None, body, {"Idempotency-Key": idempotency_key})
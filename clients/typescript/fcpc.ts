@@ -0,0 +1,983 @@
+// Code generated by src/cmd/gen from api.yml. DO NOT EDIT.
+
+export interface SignedURLRequest {
+    /** The account the receipt is credited to. */
+    account: string;
+    /** How long the URL is valid, like "5m". Defaults to 5m, at most the configured maximum. */
+    ttl?: string;
+}
+
+export interface SignedURL {
+    url?: string;
+    expiresAt?: string;
+}
+
+export interface NotificationSettings {
+    /** A plain address, empty for no notifications. */
+    email?: string;
+    optOut?: boolean;
+}
+
+export interface AccountPreferences {
+    /** An IANA timezone name; the account's days and months start at midnight there. UTC by default. */
+    timezone?: string;
+    /** The language errors from /receipts/process are in, English by default. */
+    locale?: string;
+    /** Event types set to false are not sent to the account. */
+    notifications?: Record<string, boolean>;
+}
+
+export interface Statement {
+    account?: string;
+    month?: string;
+    /** Where the month starts and ends, the account's timezone. */
+    timezone?: string;
+    openingBalance?: number;
+    earned?: number;
+    /** Points transferred away, including fees. */
+    redeemed?: number;
+    closingBalance?: number;
+    /** Points expiring at the end of the following month. Always 0 unless the config sets an expiry. */
+    expiringSoon?: number;
+    receipts?: StatementReceipt[];
+    generatedAt?: string;
+}
+
+export interface StatementReceipt {
+    id?: string;
+    retailer?: string;
+    purchaseDate?: string;
+    total?: string;
+    points?: number;
+    creditedAt?: string;
+}
+
+export interface PointsHold {
+    receiptId?: string;
+    account?: string;
+    /** What the receipt earned when it was processed. It settles with what it earns then, in case it was amended. */
+    points?: number;
+    placedAt?: string;
+    settlesAt?: string;
+}
+
+export interface Streak {
+    account?: string;
+    /** day or week. */
+    unit?: string;
+    current?: number;
+    longest?: number;
+    /** The latest day (2022-01-02) or ISO week (2022-W01) with a receipt. */
+    lastPeriod?: string;
+    /** False once a whole day or week went by without a receipt. */
+    active?: boolean;
+}
+
+export interface Transfer {
+    /** The receiving account. */
+    to: string;
+    points: number;
+}
+
+export interface TransferResult {
+    txId?: string;
+    from?: string;
+    to?: string;
+    points?: number;
+    fee?: number;
+    /** The sender's balance after the transfer. */
+    balance?: number;
+    /** Set when the Idempotency-Key matched an earlier transfer and no points were moved. */
+    replayed?: boolean;
+}
+
+export interface Score {
+    points?: number;
+    /** Set when the account is over its daily receipt limit, the breakdown then ends with a dailyReceiptCap rule cancelling out the others. */
+    throttled?: boolean;
+    breakdown?: RuleResult[];
+    warnings?: Warning[];
+}
+
+export interface CanonicalReceipt {
+    /** The receipt with the retailer normalized, descriptions trimmed to single spaces, prices with two decimals, items ordered by description and then price, and transaction numbers and postal codes uppercase without separators. */
+    receipt: Receipt;
+    /** The hex SHA-256 of the canonical receipt as compact JSON, with the fields in the order returned. */
+    fingerprint: string;
+}
+
+/** Something about an accepted receipt that was fixed up or looks off. Warnings never fail the request. */
+export interface Warning {
+    field: string;
+    message: string;
+}
+
+export interface RuleResult {
+    rule?: string;
+    description?: string;
+    points?: number;
+    /** For rules scoring items one by one, the items that earned points. */
+    items?: ItemPoints[];
+}
+
+export interface CompareRequest {
+    /** A receipt payload, or the ID of a stored receipt as a string. */
+    a: unknown;
+    /** A receipt payload, or the ID of a stored receipt as a string. */
+    b: unknown;
+}
+
+export interface ComparedReceipt {
+    /** The ID of the stored receipt, if one was given. */
+    id?: string;
+    /** The points the stored receipt was awarded, which differ from points once the rules changed. */
+    storedPoints?: number;
+    points?: number;
+    breakdown?: RuleResult[];
+}
+
+export interface RuleDelta {
+    rule?: string;
+    a?: number;
+    b?: number;
+    delta?: number;
+}
+
+export interface Comparison {
+    a?: ComparedReceipt;
+    b?: ComparedReceipt;
+    /** The points of b minus the points of a. */
+    delta?: number;
+    rules?: RuleDelta[];
+}
+
+export interface ErasureCertificate {
+    id?: string;
+    /** The hex SHA-256 of the account ID. The ID itself isn't kept. */
+    accountHash?: string;
+    /** scheduled, cancelled or completed. */
+    status?: string;
+    requestedAt?: string;
+    executeAt?: string;
+    completedAt?: string;
+    receiptsErased?: number;
+    entriesAnonymized?: number;
+    auditRecordsErased?: number;
+    aliasesErased?: number;
+}
+
+export interface ItemPoints {
+    /** The index of the item in the receipt's items. */
+    item?: number;
+    shortDescription?: string;
+    points?: number;
+}
+
+export interface Explanation {
+    points: number;
+    explanation: string[];
+}
+
+export interface StoredReceipt {
+    id?: string;
+    points?: number;
+    receipt?: Receipt;
+    createdAt?: string;
+}
+
+export interface Receipt {
+    /** The name of the retailer or store the receipt is from. */
+    retailer: string;
+    /** The date of the purchase printed on the receipt. */
+    purchaseDate: string;
+    /** The time of the purchase printed on the receipt. 24-hour time expected. */
+    purchaseTime: string;
+    /** At most 500 items unless the server is configured otherwise. */
+    items: Item[];
+    /** The total amount paid on the receipt. */
+    total: string;
+    storeLocation?: StoreLocation;
+    /** The unique code the retailer printed on the receipt, often as a barcode. Optional; must match the retailer's configured format and check digit. A second receipt with the same number from the same retailer is rejected. */
+    transactionNumber?: string;
+    /** How the receipt was paid. Optional. */
+    paymentMethod?: string;
+    /** Partner data like correlation IDs, stored and returned as submitted and addressable by the metadata rule as key=value. Keys are letters, digits, underscores and hyphens, starting with a letter. Optional. */
+    metadata?: Record<string, string>;
+}
+
+/** Where the receipt was issued, a postal code, coordinates or both. Optional. */
+export interface StoreLocation {
+    postalCode?: string;
+    /** Required with a longitude. */
+    latitude?: number;
+    /** Required with a latitude. */
+    longitude?: number;
+}
+
+export interface RegionStats {
+    /** The region as configured, "other" for receipts outside every region and "unknown" for receipts without a location. */
+    region: string;
+    receipts: number;
+    points: number;
+    /** The sum of the receipt totals. */
+    total: string;
+}
+
+export interface EventSchema {
+    type: string;
+    version: number;
+    description: string;
+    fields: EventField[];
+}
+
+export interface EventField {
+    /** The name of the field in the JSON payload. */
+    name: string;
+    type: string;
+    required: boolean;
+    description: string;
+}
+
+export interface AggregateBucket {
+    /** The value of the groupBy field, empty for receipts without it and without groupBy. */
+    group: string;
+    /** The day, the Monday of the week or the month (2022-01), empty without period. */
+    period: string;
+    /** The number of receipts or points, or the total in dollars. */
+    value: number;
+}
+
+export interface PaymentMethodStats {
+    /** The payment method, "unknown" for receipts without one. */
+    paymentMethod: string;
+    receipts: number;
+    points: number;
+    /** The sum of the receipt totals. */
+    total: string;
+}
+
+export interface HourlyStats {
+    /** The hour of the purchase time, 0 to 23. */
+    hour: number;
+    receipts: number;
+    points: number;
+}
+
+export interface WeekdayStats {
+    weekday: string;
+    receipts: number;
+    points: number;
+    hours: HourlyStats[];
+}
+
+export interface ReceiptGroup {
+    id: string;
+    receiptIds: string[];
+    createdAt: string;
+}
+
+export interface ReceiptTemplate {
+    id?: string;
+    retailer: string;
+    items?: Item[];
+    storeLocation?: StoreLocation;
+    paymentMethod?: string;
+    createdAt?: string;
+}
+
+export interface ReceiptReturn {
+    id: string;
+    receiptId: string;
+    items: Item[];
+    /** The sum of the returned item prices. */
+    amount: string;
+    /** The points taken back, 0 for receipts no account was credited for. */
+    points: number;
+    /** The ledger transaction that took the points back. */
+    txId?: string;
+    createdAt: string;
+}
+
+export interface Amendment {
+    id: string;
+    /** The points of the receipt after the amendment. */
+    points: number;
+    previousPoints: number;
+    /** The sum of the item prices, to compare with the total. */
+    itemsTotal: string;
+    receipt: Receipt;
+}
+
+export interface Reprocessed {
+    id: string;
+    /** The points of the receipt after reprocessing. */
+    points: number;
+    previousPoints: number;
+    breakdown: RuleResult[];
+}
+
+export interface Item {
+    /** The Short Product Description for the item, at most 100 characters unless the server is configured otherwise. */
+    shortDescription: string;
+    /** The total price payed for this item. */
+    price: string;
+}
+
+export interface ListReceiptsResponse {
+    receipts?: StoredReceipt[];
+    /** Missing on the last page. */
+    nextCursor?: string;
+}
+
+export interface ProcessReceiptResponse {
+    id: string;
+    /** Set when the account was over its daily receipt limit, the receipt earned no points. */
+    throttled?: boolean;
+    /** Set when the receipt was sampled for manual review, its points are credited once it's approved. */
+    underReview?: boolean;
+    /** Set when the receipt's points were placed on hold, they are credited once they settle. */
+    held?: boolean;
+    warnings?: Warning[];
+}
+
+export interface SubmitSignedResponse {
+    id: string;
+    throttled?: boolean;
+    underReview?: boolean;
+    held?: boolean;
+    warnings?: Warning[];
+}
+
+export interface GetRegionStatsResponse {
+    from?: string;
+    to?: string;
+    regions: RegionStats[];
+}
+
+export interface GetPaymentMethodStatsResponse {
+    from?: string;
+    to?: string;
+    paymentMethods: PaymentMethodStats[];
+}
+
+export interface GetHourlyStatsResponse {
+    from?: string;
+    to?: string;
+    hours: HourlyStats[];
+    weekdays: WeekdayStats[];
+}
+
+export interface GetAggregateResponse {
+    groupBy?: string;
+    metric: string;
+    period?: string;
+    from?: string;
+    to?: string;
+    buckets: AggregateBucket[];
+}
+
+export interface GetEventSchemasResponse {
+    schemas: EventSchema[];
+}
+
+export interface GetPointsResponse {
+    points?: number;
+}
+
+export interface ListReturnsResponse {
+    returns: ReceiptReturn[];
+}
+
+export interface GetReceiptGroupPointsResponse {
+    id: string;
+    points: number;
+    receipts: GetReceiptGroupPointsResponseReceiptsItem[];
+}
+
+export interface GetReceiptGroupPointsResponseReceiptsItem {
+    id: string;
+    points: number;
+}
+
+export interface GetBalanceResponse {
+    account?: string;
+    balance?: number;
+    /** Points on hold, not part of the balance until they settle. */
+    held?: number;
+}
+
+export interface ListHoldsResponse {
+    holds: PointsHold[];
+}
+
+export interface ListAccountReceiptsResponse {
+    receipts?: StoredReceipt[];
+    /** Missing on the last page. */
+    nextCursor?: string;
+}
+
+export class ApiError extends Error {
+    constructor(public readonly status: number, public readonly body: string) {
+        super(`request failed with status ${status}: ${body}`);
+    }
+}
+
+/** Thrown before sending a request the API would reject anyway. */
+export class ValidationError extends Error {
+    constructor(public readonly errors: string[]) {
+        super(errors.join("; "));
+    }
+}
+
+function at(path: string, field: string): string {
+    return path ? `${path}.${field}` : field;
+}
+
+function isValidDate(value: string): boolean {
+    if (!/^\d{4}-\d{2}-\d{2}$/.test(value)) {
+        return false;
+    }
+    const d = new Date(value + "T00:00:00Z");
+    return !isNaN(d.getTime()) && d.toISOString().startsWith(value);
+}
+
+function checkString(value: unknown, path: string, errors: string[], pattern?: RegExp, format?: string): void {
+    if (typeof value !== "string") {
+        errors.push(`${path}: must be a string`);
+    } else if (pattern && !pattern.test(value)) {
+        errors.push(`${path}: must match ${pattern.source}`);
+    } else if (format === "date" && !isValidDate(value)) {
+        errors.push(`${path}: want YYYY-MM-DD format`);
+    } else if (format === "time" && !/^([01]\d|2[0-3]):[0-5]\d$/.test(value)) {
+        errors.push(`${path}: want HH:MM format`);
+    }
+}
+
+function checkNumber(value: unknown, path: string, errors: string[], integer: boolean, min?: number, max?: number): void {
+    if (typeof value !== "number" || (integer && !Number.isInteger(value))) {
+        errors.push(`${path}: must be ${integer ? "an integer" : "a number"}`);
+    } else if (min !== undefined && value < min) {
+        errors.push(`${path}: must be at least ${min}`);
+    } else if (max !== undefined && value > max) {
+        errors.push(`${path}: must be at most ${max}`);
+    }
+}
+
+function checkBoolean(value: unknown, path: string, errors: string[]): void {
+    if (typeof value !== "boolean") {
+        errors.push(`${path}: must be a boolean`);
+    }
+}
+
+function checkArray(value: unknown, path: string, errors: string[], minItems: number): boolean {
+    if (!Array.isArray(value)) {
+        errors.push(`${path}: must be an array`);
+        return false;
+    }
+    if (value.length < minItems) {
+        errors.push(`${path}: must contain at least ${minItems} item(s)`);
+    }
+    return true;
+}
+
+function checkObject(value: unknown, path: string, errors: string[]): boolean {
+    if (typeof value !== "object" || value === null || Array.isArray(value)) {
+        errors.push(`${path}: must be an object`);
+        return false;
+    }
+    return true;
+}
+
+/** Checks a SignedURLRequest against api.yml, returning one message per problem. */
+export function validateSignedURLRequest(value: SignedURLRequest, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.account === undefined || value.account === null) {
+        errors.push(`${at(path, "account")}: is required`);
+    } else {
+        checkString(value.account, at(path, "account"), errors, undefined, undefined);
+    }
+    if (value.ttl !== undefined && value.ttl !== null) {
+        checkString(value.ttl, at(path, "ttl"), errors, undefined, undefined);
+    }
+    return errors;
+}
+
+/** Checks a NotificationSettings against api.yml, returning one message per problem. */
+export function validateNotificationSettings(value: NotificationSettings, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.email !== undefined && value.email !== null) {
+        checkString(value.email, at(path, "email"), errors, undefined, undefined);
+    }
+    if (value.optOut !== undefined && value.optOut !== null) {
+        checkBoolean(value.optOut, at(path, "optOut"), errors);
+    }
+    return errors;
+}
+
+/** Checks a AccountPreferences against api.yml, returning one message per problem. */
+export function validateAccountPreferences(value: AccountPreferences, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.timezone !== undefined && value.timezone !== null) {
+        checkString(value.timezone, at(path, "timezone"), errors, undefined, undefined);
+    }
+    if (value.locale !== undefined && value.locale !== null) {
+        checkString(value.locale, at(path, "locale"), errors, undefined, undefined);
+    }
+    if (value.notifications !== undefined && value.notifications !== null) {
+        if (checkObject(value.notifications, at(path, "notifications"), errors)) {
+            Object.entries(value.notifications as Record<string, unknown>).forEach(([key0, item0]) => {
+                checkBoolean(item0 as boolean, at(at(path, "notifications"), key0), errors);
+            });
+        }
+    }
+    return errors;
+}
+
+/** Checks a Transfer against api.yml, returning one message per problem. */
+export function validateTransfer(value: Transfer, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.to === undefined || value.to === null) {
+        errors.push(`${at(path, "to")}: is required`);
+    } else {
+        checkString(value.to, at(path, "to"), errors, undefined, undefined);
+    }
+    if (value.points === undefined || value.points === null) {
+        errors.push(`${at(path, "points")}: is required`);
+    } else {
+        checkNumber(value.points, at(path, "points"), errors, true, 1, undefined);
+    }
+    return errors;
+}
+
+/** Checks a CompareRequest against api.yml, returning one message per problem. */
+export function validateCompareRequest(value: CompareRequest, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.a === undefined || value.a === null) {
+        errors.push(`${at(path, "a")}: is required`);
+    } else {
+    }
+    if (value.b === undefined || value.b === null) {
+        errors.push(`${at(path, "b")}: is required`);
+    } else {
+    }
+    return errors;
+}
+
+/** Checks a Receipt against api.yml, returning one message per problem. */
+export function validateReceipt(value: Receipt, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.retailer === undefined || value.retailer === null) {
+        errors.push(`${at(path, "retailer")}: is required`);
+    } else {
+        checkString(value.retailer, at(path, "retailer"), errors, /^[\w\s\-&]+$/, undefined);
+    }
+    if (value.purchaseDate === undefined || value.purchaseDate === null) {
+        errors.push(`${at(path, "purchaseDate")}: is required`);
+    } else {
+        checkString(value.purchaseDate, at(path, "purchaseDate"), errors, undefined, "date");
+    }
+    if (value.purchaseTime === undefined || value.purchaseTime === null) {
+        errors.push(`${at(path, "purchaseTime")}: is required`);
+    } else {
+        checkString(value.purchaseTime, at(path, "purchaseTime"), errors, undefined, "time");
+    }
+    if (value.items === undefined || value.items === null) {
+        errors.push(`${at(path, "items")}: is required`);
+    } else {
+        if (checkArray(value.items, at(path, "items"), errors, 1)) {
+            (value.items as unknown[]).forEach((item0, i0) => {
+                errors.push(...validateItem(item0 as Item, at(at(path, "items"), String(i0))));
+            });
+        }
+    }
+    if (value.total === undefined || value.total === null) {
+        errors.push(`${at(path, "total")}: is required`);
+    } else {
+        checkString(value.total, at(path, "total"), errors, /^\d+\.\d{2}$/, undefined);
+    }
+    if (value.storeLocation !== undefined && value.storeLocation !== null) {
+        errors.push(...validateStoreLocation(value.storeLocation, at(path, "storeLocation")));
+    }
+    if (value.transactionNumber !== undefined && value.transactionNumber !== null) {
+        checkString(value.transactionNumber, at(path, "transactionNumber"), errors, undefined, undefined);
+    }
+    if (value.paymentMethod !== undefined && value.paymentMethod !== null) {
+        checkString(value.paymentMethod, at(path, "paymentMethod"), errors, undefined, undefined);
+    }
+    if (value.metadata !== undefined && value.metadata !== null) {
+        if (checkObject(value.metadata, at(path, "metadata"), errors)) {
+            Object.entries(value.metadata as Record<string, unknown>).forEach(([key0, item0]) => {
+                checkString(item0 as string, at(at(path, "metadata"), key0), errors, undefined, undefined);
+            });
+        }
+    }
+    return errors;
+}
+
+/** Checks a StoreLocation against api.yml, returning one message per problem. */
+export function validateStoreLocation(value: StoreLocation, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.postalCode !== undefined && value.postalCode !== null) {
+        checkString(value.postalCode, at(path, "postalCode"), errors, /^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$/, undefined);
+    }
+    if (value.latitude !== undefined && value.latitude !== null) {
+        checkNumber(value.latitude, at(path, "latitude"), errors, false, -90, 90);
+    }
+    if (value.longitude !== undefined && value.longitude !== null) {
+        checkNumber(value.longitude, at(path, "longitude"), errors, false, -180, 180);
+    }
+    return errors;
+}
+
+/** Checks a ReceiptTemplate against api.yml, returning one message per problem. */
+export function validateReceiptTemplate(value: ReceiptTemplate, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.id !== undefined && value.id !== null) {
+        checkString(value.id, at(path, "id"), errors, undefined, undefined);
+    }
+    if (value.retailer === undefined || value.retailer === null) {
+        errors.push(`${at(path, "retailer")}: is required`);
+    } else {
+        checkString(value.retailer, at(path, "retailer"), errors, /^[\w\s\-&]+$/, undefined);
+    }
+    if (value.items !== undefined && value.items !== null) {
+        if (checkArray(value.items, at(path, "items"), errors, 0)) {
+            (value.items as unknown[]).forEach((item0, i0) => {
+                errors.push(...validateItem(item0 as Item, at(at(path, "items"), String(i0))));
+            });
+        }
+    }
+    if (value.storeLocation !== undefined && value.storeLocation !== null) {
+        errors.push(...validateStoreLocation(value.storeLocation, at(path, "storeLocation")));
+    }
+    if (value.paymentMethod !== undefined && value.paymentMethod !== null) {
+        checkString(value.paymentMethod, at(path, "paymentMethod"), errors, undefined, undefined);
+    }
+    if (value.createdAt !== undefined && value.createdAt !== null) {
+        checkString(value.createdAt, at(path, "createdAt"), errors, undefined, "date-time");
+    }
+    return errors;
+}
+
+/** Checks a Item against api.yml, returning one message per problem. */
+export function validateItem(value: Item, path = ""): string[] {
+    const errors: string[] = [];
+    if (typeof value !== "object" || value === null) {
+        return [`${path || "value"}: must be an object`];
+    }
+    if (value.shortDescription === undefined || value.shortDescription === null) {
+        errors.push(`${at(path, "shortDescription")}: is required`);
+    } else {
+        checkString(value.shortDescription, at(path, "shortDescription"), errors, /^[\w\s\-]+$/, undefined);
+    }
+    if (value.price === undefined || value.price === null) {
+        errors.push(`${at(path, "price")}: is required`);
+    } else {
+        checkString(value.price, at(path, "price"), errors, /^\d+\.\d{2}$/, undefined);
+    }
+    return errors;
+}
+
+export class Client {
+    /**
+     * @param baseUrl e.g. "https://receipts.example.com", without a trailing path.
+     * @param fetchImpl defaults to the global fetch.
+     */
+    constructor(private readonly baseUrl: string, private readonly fetchImpl: typeof fetch = fetch) {}
+
+    private async request<T>(
+        method: string,
+        path: string,
+        query?: Record<string, unknown>,
+        body?: unknown,
+        headers?: Record<string, string | undefined>,
+    ): Promise<T> {
+        let url = this.baseUrl.replace(/\/+$/, "") + path;
+        const params = new URLSearchParams();
+        for (const [key, value] of Object.entries(query ?? {})) {
+            if (value !== undefined && value !== null) {
+                params.set(key, String(value));
+            }
+        }
+        if (params.toString()) {
+            url += "?" + params.toString();
+        }
+
+        const sent: Record<string, string> = body === undefined ? {} : {"Content-Type": "application/json"};
+        for (const [key, value] of Object.entries(headers ?? {})) {
+            if (value !== undefined) {
+                sent[key] = value;
+            }
+        }
+
+        const resp = await this.fetchImpl(url, {
+            method,
+            headers: sent,
+            body: body === undefined ? undefined : JSON.stringify(body),
+        });
+        const text = await resp.text();
+        if (!resp.ok) {
+            throw new ApiError(resp.status, text);
+        }
+        return (text ? JSON.parse(text) : undefined) as T;
+    }
+
+    /** Lists the most recently processed receipts. */
+    async listReceipts(query: {limit?: number; sort?: string; order?: string; cursor?: string; fields?: string; compact?: boolean; transactionNumber?: string} = {}): Promise<ListReceiptsResponse> {
+        return this.request<ListReceiptsResponse>("GET", `/receipts`, query, undefined, undefined);
+    }
+
+    /** Submits a receipt for processing. */
+    async processReceipt(body: Receipt, headers: {"X-Account-ID"?: string; "X-Priority"?: string} = {}): Promise<ProcessReceiptResponse> {
+        const errors = validateReceipt(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<ProcessReceiptResponse>("POST", `/receipts/process`, undefined, body, headers);
+    }
+
+    /** Scores a receipt without storing it. */
+    async scoreReceipt(body: Receipt, headers: {"X-Account-ID"?: string} = {}): Promise<Score> {
+        const errors = validateReceipt(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<Score>("POST", `/receipts/score`, undefined, body, headers);
+    }
+
+    /** Compares the points of two receipts. */
+    async compareReceipts(body: CompareRequest): Promise<Comparison> {
+        const errors = validateCompareRequest(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<Comparison>("POST", `/receipts/compare`, undefined, body, undefined);
+    }
+
+    /** Returns the canonical form of a receipt and its fingerprint. */
+    async canonicalizeReceipt(body: Receipt): Promise<CanonicalReceipt> {
+        const errors = validateReceipt(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<CanonicalReceipt>("POST", `/receipts/canonicalize`, undefined, body, undefined);
+    }
+
+    /** Mints a one-time submission URL. */
+    async createSignedURL(body: SignedURLRequest): Promise<SignedURL> {
+        const errors = validateSignedURLRequest(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<SignedURL>("POST", `/receipts/signed-urls`, undefined, body, undefined);
+    }
+
+    /** Submits a receipt through a signed URL. */
+    async submitSigned(body: Receipt, query: {account: string; expires: number; nonce: string; signature: string}): Promise<SubmitSignedResponse> {
+        const errors = validateReceipt(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<SubmitSignedResponse>("POST", `/receipts/submit`, query, body, undefined);
+    }
+
+    /** Aggregates stored receipts by region. */
+    async getRegionStats(query: {from?: string; to?: string} = {}): Promise<GetRegionStatsResponse> {
+        return this.request<GetRegionStatsResponse>("GET", `/stats/regions`, query, undefined, undefined);
+    }
+
+    /** Aggregates stored receipts by payment method. */
+    async getPaymentMethodStats(query: {from?: string; to?: string} = {}): Promise<GetPaymentMethodStatsResponse> {
+        return this.request<GetPaymentMethodStatsResponse>("GET", `/stats/payment-methods`, query, undefined, undefined);
+    }
+
+    /** Aggregates stored receipts by hour of the day and day of the week. */
+    async getHourlyStats(query: {from?: string; to?: string} = {}): Promise<GetHourlyStatsResponse> {
+        return this.request<GetHourlyStatsResponse>("GET", `/stats/hourly`, query, undefined, undefined);
+    }
+
+    /** Sums a metric of the stored receipts per group and period. */
+    async getAggregate(query: {groupBy?: string; metric?: string; period?: string; from?: string; to?: string} = {}): Promise<GetAggregateResponse> {
+        return this.request<GetAggregateResponse>("GET", `/aggregate`, query, undefined, undefined);
+    }
+
+    /** Lists the schemas of the events the service publishes. */
+    async getEventSchemas(): Promise<GetEventSchemasResponse> {
+        return this.request<GetEventSchemasResponse>("GET", `/events/schemas`, undefined, undefined, undefined);
+    }
+
+    /** Returns an erasure certificate. */
+    async getErasure(id: string): Promise<ErasureCertificate> {
+        return this.request<ErasureCertificate>("GET", `/erasures/${encodeURIComponent(id)}`, undefined, undefined, undefined);
+    }
+
+    /** Cancels a scheduled erasure during its grace period. */
+    async cancelErasure(id: string): Promise<ErasureCertificate> {
+        return this.request<ErasureCertificate>("DELETE", `/erasures/${encodeURIComponent(id)}`, undefined, undefined, undefined);
+    }
+
+    /** Returns the points awarded for the receipt. */
+    async getPoints(id: string): Promise<GetPointsResponse> {
+        return this.request<GetPointsResponse>("GET", `/receipts/${encodeURIComponent(id)}/points`, undefined, undefined, undefined);
+    }
+
+    /** Explains the points awarded for the receipt. */
+    async explainPoints(id: string): Promise<Explanation> {
+        return this.request<Explanation>("GET", `/receipts/${encodeURIComponent(id)}/points/explain`, undefined, undefined, undefined);
+    }
+
+    /** Records returned items of a receipt. */
+    async createReturn(id: string, body: Record<string, unknown>): Promise<ReceiptReturn> {
+        return this.request<ReceiptReturn>("POST", `/receipts/${encodeURIComponent(id)}/returns`, undefined, body, undefined);
+    }
+
+    /** Lists the returns of a receipt. */
+    async listReturns(id: string): Promise<ListReturnsResponse> {
+        return this.request<ListReturnsResponse>("GET", `/receipts/${encodeURIComponent(id)}/returns`, undefined, undefined, undefined);
+    }
+
+    /** Adds an item to a receipt. */
+    async addItem(id: string, body: Record<string, unknown>): Promise<Amendment> {
+        return this.request<Amendment>("POST", `/receipts/${encodeURIComponent(id)}/items`, undefined, body, undefined);
+    }
+
+    /** Removes an item from a receipt. */
+    async deleteItem(id: string, index: number, query: {total?: string} = {}): Promise<Amendment> {
+        return this.request<Amendment>("DELETE", `/receipts/${encodeURIComponent(id)}/items/${encodeURIComponent(index)}`, query, undefined, undefined);
+    }
+
+    /** Scores a stored receipt again. */
+    async reprocessReceipt(id: string, body: Record<string, unknown>): Promise<Reprocessed> {
+        return this.request<Reprocessed>("POST", `/receipts/${encodeURIComponent(id)}/reprocess`, undefined, body, undefined);
+    }
+
+    /** Links receipts of one shopping trip. */
+    async createReceiptGroup(body: Record<string, unknown>): Promise<ReceiptGroup> {
+        return this.request<ReceiptGroup>("POST", `/receipt-groups`, undefined, body, undefined);
+    }
+
+    /** Returns a receipt group. */
+    async getReceiptGroup(id: string): Promise<ReceiptGroup> {
+        return this.request<ReceiptGroup>("GET", `/receipt-groups/${encodeURIComponent(id)}`, undefined, undefined, undefined);
+    }
+
+    /** Returns the points awarded for a receipt group. */
+    async getReceiptGroupPoints(id: string): Promise<GetReceiptGroupPointsResponse> {
+        return this.request<GetReceiptGroupPointsResponse>("GET", `/receipt-groups/${encodeURIComponent(id)}/points`, undefined, undefined, undefined);
+    }
+
+    /** Registers a receipt template. */
+    async createReceiptTemplate(body: ReceiptTemplate): Promise<ReceiptTemplate> {
+        const errors = validateReceiptTemplate(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<ReceiptTemplate>("POST", `/receipt-templates`, undefined, body, undefined);
+    }
+
+    /** Returns a receipt template. */
+    async getReceiptTemplate(id: string): Promise<ReceiptTemplate> {
+        return this.request<ReceiptTemplate>("GET", `/receipt-templates/${encodeURIComponent(id)}`, undefined, undefined, undefined);
+    }
+
+    /** Deletes a receipt template. Receipts submitted from it are kept. */
+    async deleteReceiptTemplate(id: string): Promise<void> {
+        return this.request<void>("DELETE", `/receipt-templates/${encodeURIComponent(id)}`, undefined, undefined, undefined);
+    }
+
+    /** Returns the points balance of an account. */
+    async getBalance(id: string): Promise<GetBalanceResponse> {
+        return this.request<GetBalanceResponse>("GET", `/accounts/${encodeURIComponent(id)}/balance`, undefined, undefined, undefined);
+    }
+
+    /** Schedules the erasure of all data of an account. */
+    async eraseAccountData(id: string): Promise<ErasureCertificate> {
+        return this.request<ErasureCertificate>("DELETE", `/accounts/${encodeURIComponent(id)}/data`, undefined, undefined, undefined);
+    }
+
+    /** Lists the points of an account on hold. */
+    async listHolds(id: string): Promise<ListHoldsResponse> {
+        return this.request<ListHoldsResponse>("GET", `/accounts/${encodeURIComponent(id)}/holds`, undefined, undefined, undefined);
+    }
+
+    /** Returns the notification settings of an account. */
+    async getNotificationSettings(id: string): Promise<NotificationSettings> {
+        return this.request<NotificationSettings>("GET", `/accounts/${encodeURIComponent(id)}/notifications`, undefined, undefined, undefined);
+    }
+
+    /** Sets where an account's notifications go, or opts out of them. */
+    async putNotificationSettings(id: string, body: NotificationSettings): Promise<NotificationSettings> {
+        const errors = validateNotificationSettings(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<NotificationSettings>("PUT", `/accounts/${encodeURIComponent(id)}/notifications`, undefined, body, undefined);
+    }
+
+    /** Returns the preferences of an account. */
+    async getPreferences(id: string): Promise<AccountPreferences> {
+        return this.request<AccountPreferences>("GET", `/accounts/${encodeURIComponent(id)}/preferences`, undefined, undefined, undefined);
+    }
+
+    /** Replaces the preferences of an account. */
+    async putPreferences(id: string, body: AccountPreferences): Promise<AccountPreferences> {
+        const errors = validateAccountPreferences(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<AccountPreferences>("PUT", `/accounts/${encodeURIComponent(id)}/preferences`, undefined, body, undefined);
+    }
+
+    /** Puts an account back on the default preferences. */
+    async deletePreferences(id: string): Promise<void> {
+        return this.request<void>("DELETE", `/accounts/${encodeURIComponent(id)}/preferences`, undefined, undefined, undefined);
+    }
+
+    /** Lists an account's receipts, newest first. */
+    async listAccountReceipts(id: string, query: {limit?: number; sort?: string; order?: string; cursor?: string; fields?: string; compact?: boolean} = {}): Promise<ListAccountReceiptsResponse> {
+        return this.request<ListAccountReceiptsResponse>("GET", `/accounts/${encodeURIComponent(id)}/receipts`, query, undefined, undefined);
+    }
+
+    /** Returns the monthly statement of an account. */
+    async getStatement(id: string, query: {month: string; format?: string}): Promise<Statement> {
+        return this.request<Statement>("GET", `/accounts/${encodeURIComponent(id)}/statement`, query, undefined, undefined);
+    }
+
+    /** Returns the receipt streak of an account. */
+    async getStreak(id: string): Promise<Streak> {
+        return this.request<Streak>("GET", `/accounts/${encodeURIComponent(id)}/streak`, undefined, undefined, undefined);
+    }
+
+    /** Moves points to another account. */
+    async transferPoints(id: string, body: Transfer, headers: {"Idempotency-Key": string}): Promise<TransferResult> {
+        const errors = validateTransfer(body);
+        if (errors.length > 0) {
+            throw new ValidationError(errors);
+        }
+        return this.request<TransferResult>("POST", `/accounts/${encodeURIComponent(id)}/transfer`, undefined, body, headers);
+    }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var accountLockWaitSeconds = metrics.NewHistogram(prometheus.HistogramOpts{
+	Name:    "fcpc_account_lock_wait_seconds",
+	Help:    "How long work on an account waited for other work on the same account to finish.",
+	Buckets: prometheus.ExponentialBuckets(1e-5, 4, 10),
+})
+
+// accountLockSet serializes the work on an account. Each ledger call is atomic on its own, but crediting a receipt,
+// a transfer or a return checks limits, counters, streaks and balances across several calls, and two of them for the
+// same account must not interleave. Work on different accounts runs side by side.
+//
+// Locks are taken by the account an ID resolves to, so work on an account merged into another waits for the other
+// one too. Whoever holds returns.mu takes it before any account lock, and audits.mu only after one.
+type accountLockSet struct {
+	mu    sync.Mutex
+	locks map[string]*accountLock
+}
+
+// accountLock is freed once nobody holds or waits for it, so the set only grows with the accounts being worked on.
+type accountLock struct {
+	sync.Mutex
+	refs int
+}
+
+var accountLocks = &accountLockSet{locks: map[string]*accountLock{}}
+
+// lock locks the accounts, skipping empty IDs, and returns the function that unlocks them. Several accounts are
+// locked in order, so two transfers between the same accounts in opposite directions can't deadlock.
+func (s *accountLockSet) lock(accounts ...string) (unlock func()) {
+	start := time.Now()
+	defer func() { accountLockWaitSeconds.Observe(time.Since(start).Seconds()) }()
+	for {
+		resolved := s.resolve(accounts)
+		held := make([]*accountLock, len(resolved))
+		for i, account := range resolved {
+			held[i] = s.acquire(account)
+		}
+		unlock = func() {
+			for i := len(held) - 1; i >= 0; i-- {
+				s.release(resolved[i], held[i])
+			}
+		}
+		// a merge that finished while we waited moved the account, lock the one it was merged into instead.
+		if slices.Equal(s.resolve(accounts), resolved) {
+			return unlock
+		}
+		unlock()
+	}
+}
+
+func (s *accountLockSet) resolve(accounts []string) []string {
+	var resolved []string
+	for _, account := range accounts {
+		if account != "" {
+			resolved = append(resolved, pointsLedger.Resolve(account))
+		}
+	}
+	slices.Sort(resolved)
+	return slices.Compact(resolved)
+}
+
+func (s *accountLockSet) acquire(account string) *accountLock {
+	s.mu.Lock()
+	l := s.locks[account]
+	if l == nil {
+		l = &accountLock{}
+		s.locks[account] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+	l.Lock()
+	return l
+}
+
+func (s *accountLockSet) release(account string, l *accountLock) {
+	l.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(s.locks, account)
+	}
+}
+
+// lockReceiptAccount locks the account receiptID was credited to, if any, for work that takes back or adds to its
+// points.
+func lockReceiptAccount(receiptID string) (unlock func()) {
+	owner, _ := pointsLedger.Owner(receiptID)
+	return accountLocks.lock(owner)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+// locksWithin reports whether accountLocks.lock(accounts...) returns within a short wait, unlocking right away if it
+// does.
+func locksWithin(accounts ...string) bool {
+	done := make(chan struct{})
+	go func() {
+		accountLocks.lock(accounts...)()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestAccountLocks(t *testing.T) {
+	router := setup()
+	for _, account := range []string{"alice", "bob", "carol"} {
+		submitForAccount(t, router, account, receipttest.New().Build())
+	}
+	if _, err := pointsLedger.Merge("alice", "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		held     []string
+		accounts []string
+		wantWait bool
+	}{
+		{name: "same account", held: []string{"alice"}, accounts: []string{"alice"}, wantWait: true},
+		{name: "other account", held: []string{"alice"}, accounts: []string{"bob"}},
+		{name: "merged account", held: []string{"alice"}, accounts: []string{"carol"}, wantWait: true},
+		{name: "one of two", held: []string{"bob"}, accounts: []string{"alice", "bob"}, wantWait: true},
+		{name: "same account twice", held: nil, accounts: []string{"alice", "carol", "alice"}},
+		{name: "no account", held: []string{"alice"}, accounts: []string{""}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			unlock := accountLocks.lock(tc.held...)
+			waited := !locksWithin(tc.accounts...)
+			unlock()
+			if waited != tc.wantWait {
+				t.Errorf("lock(%q) waited = %v while %q was held, want %v", tc.accounts, waited, tc.held, tc.wantWait)
+			}
+			if !locksWithin(tc.accounts...) {
+				t.Fatalf("lock(%q) still waits after %q was unlocked", tc.accounts, tc.held)
+			}
+		})
+	}
+
+	// the waits that timed out above finish in the background.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		accountLocks.mu.Lock()
+		left := len(accountLocks.locks)
+		accountLocks.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d locks left over, want them freed", left)
+		}
+	}
+}
+
+func TestAccountLocksOpposingTransfers(t *testing.T) {
+	setup()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				accountLocks.lock("alice", "bob")()
+			} else {
+				accountLocks.lock("bob", "alice")()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking two accounts in opposite orders deadlocked")
+	}
+}
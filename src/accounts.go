@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func getBalance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	balance, err := pointsLedger.Balance(id)
+	if errors.Is(err, ledger.ErrUnknownAccount) {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load balance", zap.String("account", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(map[string]any{"account": id, "balance": balance, "held": holds.held(id)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+type accountReceiptsResponse struct {
+	Receipts []store.Record `json:"receipts"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// listAccountReceipts pages through an account's receipts newest first, or in the order of a sort, see listSort.
+// Unsorted, the cursor is the last receipt of the previous page, so receipts submitted while paging don't shift the
+// later pages.
+func listAccountReceipts(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	limit := store.DefaultListLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "The limit must be between 1 and "+strconv.Itoa(maxListLimit)+".", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	fields, err := receiptFieldSet(r)
+	if err != nil {
+		http.Error(w, "The fields are invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := pointsLedger.Balance(id); errors.Is(err, ledger.ErrUnknownAccount) {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+	ids := pointsLedger.Receipts(id)
+	slices.Reverse(ids)
+	if q := r.URL.Query(); q.Get("sort") != "" || q.Get("order") != "" {
+		writeSortedAccountReceipts(w, r, id, ids, limit, fields)
+		return
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		i := slices.Index(ids, string(after))
+		if err != nil || i < 0 {
+			http.Error(w, "The cursor is invalid.", http.StatusBadRequest)
+			return
+		}
+		ids = ids[i+1:]
+	}
+
+	response := accountReceiptsResponse{Receipts: []store.Record{}}
+	if len(ids) > limit {
+		ids = ids[:limit]
+		response.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(ids[limit-1]))
+	}
+	for _, receiptID := range ids {
+		rec, err := receiptStore.Get(r.Context(), receiptID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to load receipt", zap.String("account", id), zap.String("receiptID", receiptID), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		response.Receipts = append(response.Receipts, rec)
+	}
+
+	writeAccountReceipts(w, response, fields)
+}
+
+// writeSortedAccountReceipts serves listAccountReceipts with a sort. Sorting needs every receipt of the account, which
+// is fine for the receipts of one account.
+func writeSortedAccountReceipts(w http.ResponseWriter, r *http.Request, account string, ids []string, limit int, fields fieldSet) {
+	opts, err := listSort(r)
+	if err != nil {
+		http.Error(w, "The sort is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+	records := make([]store.Record, 0, len(ids))
+	for _, receiptID := range ids {
+		rec, err := receiptStore.Get(r.Context(), receiptID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to load receipt", zap.String("account", account), zap.String("receiptID", receiptID), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		records = append(records, rec)
+	}
+
+	opts.Limit = limit + 1
+	response := accountReceiptsResponse{Receipts: store.Page(records, opts)}
+	if len(response.Receipts) > limit {
+		response.Receipts = response.Receipts[:limit]
+		response.NextCursor = encodeCursor(opts, response.Receipts[limit-1])
+	}
+	writeAccountReceipts(w, response, fields)
+}
+
+func writeAccountReceipts(w http.ResponseWriter, response accountReceiptsResponse, fields fieldSet) {
+	var body any = response
+	if fields != nil {
+		projected, err := projectRecords(response.Receipts, fields)
+		if err != nil {
+			logger.Error("Failed to select fields", zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		body = struct {
+			Receipts   any    `json:"receipts"`
+			NextCursor string `json:"nextCursor,omitempty"`
+		}{projected, response.NextCursor}
+	}
+
+	jsonResponse, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+type mergeRequest struct {
+	From string `json:"from"`
+}
+
+// mergeAccounts folds the account in the body into the one in the path, e.g. after a user links devices.
+func mergeAccounts(w http.ResponseWriter, r *http.Request) {
+	into := mux.Vars(r)["id"]
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The merge request is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	unlock := accountLocks.lock(into, req.From)
+	defer unlock()
+	result, err := pointsLedger.Merge(into, req.From)
+	switch {
+	case errors.Is(err, ledger.ErrInvalidAccount), errors.Is(err, ledger.ErrSameAccount):
+		http.Error(w, "The merge request is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ledger.ErrUnknownAccount):
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error("Failed to merge accounts", zap.String("account", into), zap.String("from", req.From), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Merged accounts", zap.Any("result", result))
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// TransferConfig limits point transfers between accounts. The fee is Fee plus FeePercent of the points (rounded up),
+// charged to the sender on top of the points sent. MaxPoints of 0 means no limit.
+type TransferConfig struct {
+	Fee        int64   `json:"fee"`
+	FeePercent float64 `json:"feePercent"`
+	MaxPoints  int64   `json:"maxPoints"`
+}
+
+func (c TransferConfig) Validate() error {
+	if c.Fee < 0 || c.FeePercent < 0 || c.FeePercent > 100 || c.MaxPoints < 0 {
+		return fmt.Errorf("transfers: fee and maxPoints must not be negative and feePercent must be between 0 and 100")
+	}
+	return nil
+}
+
+func (c TransferConfig) fee(points int64) int64 {
+	return c.Fee + int64(math.Ceil(float64(points)*c.FeePercent/100))
+}
+
+type transferRequest struct {
+	To     string `json:"to"`
+	Points int64  `json:"points"`
+}
+
+// transferPoints moves points out of the account in the path. The Idempotency-Key header is required so a client
+// retrying after a timeout can't send the points twice.
+func transferPoints(w http.ResponseWriter, r *http.Request) {
+	from := mux.Vars(r)["id"]
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || len(key) > 255 {
+		http.Error(w, "An Idempotency-Key header of at most 255 characters is required.", http.StatusBadRequest)
+		return
+	}
+
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The transfer request is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	limits := currentConfig().Transfers
+	if limits.MaxPoints > 0 && req.Points > limits.MaxPoints {
+		http.Error(w, fmt.Sprintf("At most %d points can be transferred at once.", limits.MaxPoints), http.StatusBadRequest)
+		return
+	}
+
+	unlock := accountLocks.lock(from, req.To)
+	defer unlock()
+	result, err := pointsLedger.Transfer(ledger.TransferRequest{
+		From:           from,
+		To:             req.To,
+		Points:         req.Points,
+		Fee:            limits.fee(req.Points),
+		IdempotencyKey: key,
+	})
+	switch {
+	case errors.Is(err, ledger.ErrInvalidAccount), errors.Is(err, ledger.ErrSameAccount), errors.Is(err, ledger.ErrInvalidAmount):
+		http.Error(w, "The transfer request is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ledger.ErrUnknownAccount):
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	case errors.Is(err, ledger.ErrInsufficientPoints):
+		http.Error(w, "The account doesn't have enough points for this transfer and its fee.", http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrIdempotencyKeyReused):
+		http.Error(w, "That Idempotency-Key was already used for a different transfer.", http.StatusConflict)
+		return
+	case err != nil:
+		logger.Error("Failed to transfer points", zap.String("account", from), zap.String("to", req.To), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if !result.Replayed {
+		logger.Info("Transferred points", zap.Any("result", result))
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/gorilla/mux"
+)
+
+// submitForAccount posts a receipt for account and returns its points.
+func submitForAccount(t *testing.T, router *mux.Router, account string, receipt receipttest.Receipt) int64 {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON()))
+	req.Header.Set("X-Account-ID", account)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("submitting for %v: got status %v: %s", account, rr.Code, rr.Body)
+	}
+
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+resp["id"]+"/points", nil))
+	var points map[string]int64
+	json.Unmarshal(rr.Body.Bytes(), &points)
+	return points["points"]
+}
+
+func getBalanceOf(t *testing.T, router *mux.Router, account string) (int, int64) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/"+account+"/balance", nil))
+	var resp struct {
+		Balance int64 `json:"balance"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp.Balance
+}
+
+func TestSubmitForAccount(t *testing.T) {
+	router := setup()
+	gen := receipttest.NewGenerator(1)
+
+	var want int64
+	for i := 0; i < 3; i++ {
+		want += submitForAccount(t, router, "alice", gen.Valid())
+	}
+	if status, got := getBalanceOf(t, router, "alice"); status != http.StatusOK || got != want {
+		t.Errorf("balance = %v (status %v), want %v", got, status, want)
+	}
+	if status, _ := getBalanceOf(t, router, "bob"); status != http.StatusNotFound {
+		t.Errorf("unknown account status = %v, want %v", status, http.StatusNotFound)
+	}
+
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(gen.Valid().JSON()))
+	req.Header.Set("X-Account-ID", "fcpc:issued")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("system account status = %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMergeAccounts(t *testing.T) {
+	testCases := []struct {
+		name       string
+		into       string
+		body       string
+		wantStatus int
+	}{
+		{name: "merge", into: "phone", body: `{"from": "tablet"}`, wantStatus: http.StatusOK},
+		{name: "into itself", into: "phone", body: `{"from": "phone"}`, wantStatus: http.StatusBadRequest},
+		{name: "missing from", into: "phone", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", into: "phone", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "unknown source", into: "phone", body: `{"from": "laptop"}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			gen := receipttest.NewGenerator(2)
+			phone := submitForAccount(t, router, "phone", gen.Valid())
+			tablet := submitForAccount(t, router, "tablet", gen.Valid())
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/accounts/"+tc.into+"/merge", bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if _, got := getBalanceOf(t, router, "phone"); got != phone+tablet {
+				t.Errorf("merged balance = %v, want %v", got, phone+tablet)
+			}
+			// devices still using the old ID keep earning into the merged account.
+			more := submitForAccount(t, router, "tablet", gen.Valid())
+			if _, got := getBalanceOf(t, router, "phone"); got != phone+tablet+more {
+				t.Errorf("balance after submitting to the old ID = %v, want %v", got, phone+tablet+more)
+			}
+			if audit := pointsLedger.Audit(); len(audit) != 1 || audit[0].Action != "account.merge" {
+				t.Errorf("audit trail = %+v, want the merge", audit)
+			}
+		})
+	}
+}
+
+func TestTransferPoints(t *testing.T) {
+	testCases := []struct {
+		name        string
+		body        string
+		key         string
+		wantStatus  int
+		wantBalance int64
+	}{
+		{name: "transfer", body: `{"to": "bob", "points": 40}`, key: "k1", wantStatus: http.StatusOK, wantBalance: 100 - 10 - 3 - 40 - 6},
+		{name: "retried", body: `{"to": "bob", "points": 10}`, key: "used", wantStatus: http.StatusOK, wantBalance: 100 - 10 - 3},
+		{name: "key reused", body: `{"to": "bob", "points": 11}`, key: "used", wantStatus: http.StatusConflict, wantBalance: 100 - 10 - 3},
+		{name: "missing key", body: `{"to": "bob", "points": 10}`, wantStatus: http.StatusBadRequest, wantBalance: 100 - 10 - 3},
+		{name: "insufficient", body: `{"to": "bob", "points": 80}`, key: "k1", wantStatus: http.StatusConflict, wantBalance: 100 - 10 - 3},
+		{name: "over the limit", body: `{"to": "bob", "points": 81}`, key: "k1", wantStatus: http.StatusBadRequest, wantBalance: 100 - 10 - 3},
+		{name: "unknown recipient", body: `{"to": "carol", "points": 10}`, key: "k1", wantStatus: http.StatusNotFound, wantBalance: 100 - 10 - 3},
+		{name: "negative points", body: `{"to": "bob", "points": -10}`, key: "k1", wantStatus: http.StatusBadRequest, wantBalance: 100 - 10 - 3},
+		{name: "malformed", body: `{`, key: "k1", wantStatus: http.StatusBadRequest, wantBalance: 100 - 10 - 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.Transfers = TransferConfig{Fee: 2, FeePercent: 10, MaxPoints: 80}
+			liveConfig.Store(&live)
+			pointsLedger.Accrue("alice", "r1", 100)
+			pointsLedger.Accrue("bob", "r2", 5)
+
+			transfer := func(body, key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", "/accounts/alice/transfer", bytes.NewBufferString(body))
+				if key != "" {
+					req.Header.Set("Idempotency-Key", key)
+				}
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+			if rr := transfer(`{"to": "bob", "points": 10}`, "used"); rr.Code != http.StatusOK {
+				t.Fatalf("first transfer: got status %v: %s", rr.Code, rr.Body)
+			}
+
+			rr := transfer(tc.body, tc.key)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if _, got := getBalanceOf(t, router, "alice"); got != tc.wantBalance {
+				t.Errorf("balance = %v, want %v", got, tc.wantBalance)
+			}
+		})
+	}
+}
+
+func TestListAccountReceipts(t *testing.T) {
+	router := setup()
+	gen := receipttest.NewGenerator(4)
+	for i := 0; i < 5; i++ {
+		submitForAccount(t, router, "alice", gen.Valid())
+	}
+	submitForAccount(t, router, "bob", gen.Valid())
+	want := pointsLedger.Receipts("alice")
+
+	var got []string
+	cursor := ""
+	for page := 0; ; page++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/receipts?limit=2&cursor="+cursor, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var resp accountReceiptsResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		for _, rec := range resp.Receipts {
+			got = append(got, rec.ID)
+		}
+		if page == 0 {
+			// a receipt submitted while paging shows up on the next first page, not in the middle of this one.
+			submitForAccount(t, router, "alice", gen.Valid())
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	slices.Reverse(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+}
+
+func TestListAccountReceiptsSorted(t *testing.T) {
+	router := setup()
+	for _, price := range []string{"1.26", "12.25", "6.49"} {
+		submitForAccount(t, router, "alice", receipttest.New().Item("Gatorade", price).Build())
+	}
+	ids := pointsLedger.Receipts("alice")
+	want := []string{ids[1], ids[2], ids[0]}
+
+	var got []string
+	cursor := ""
+	for {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/receipts?sort=total&limit=2&cursor="+cursor, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var resp accountReceiptsResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		for _, rec := range resp.Receipts {
+			got = append(got, rec.ID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+}
+
+func TestListAccountReceiptsErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "unknown account", path: "/accounts/carol/receipts", wantStatus: http.StatusNotFound},
+		{name: "invalid limit", path: "/accounts/alice/receipts?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "garbage cursor", path: "/accounts/alice/receipts?cursor=!!", wantStatus: http.StatusBadRequest},
+		{name: "cursor of another account", path: "/accounts/alice/receipts?cursor=Ym9i", wantStatus: http.StatusBadRequest},
+		{name: "unknown sort", path: "/accounts/alice/receipts?sort=retailer", wantStatus: http.StatusBadRequest},
+		{name: "unsorted cursor with a sort", path: "/accounts/alice/receipts?sort=points&cursor=Ym9i", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			submitForAccount(t, router, "alice", receipttest.New().Build())
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
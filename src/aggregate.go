@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+var (
+	aggregateGroups  = []store.AggregateField{store.GroupByRetailer, store.GroupByPaymentMethod, store.GroupByPostalCode, groupByAccount}
+	aggregateMetrics = []store.AggregateMetric{store.MetricReceipts, store.MetricPoints, store.MetricTotal}
+	aggregatePeriods = []store.AggregatePeriod{store.PeriodDay, store.PeriodWeek, store.PeriodMonth}
+)
+
+// AggregateBucket is the metric of one group in one period, "" for receipts without the field and when the request
+// doesn't group or bucket by it. Value is a number of receipts or points, or dollars for
+// the total.
+type AggregateBucket struct {
+	Group  string      `json:"group"`
+	Period string      `json:"period"`
+	Value  json.Number `json:"value"`
+}
+
+type aggregateResponse struct {
+	GroupBy store.AggregateField  `json:"groupBy,omitempty"`
+	Metric  store.AggregateMetric `json:"metric"`
+	Period  store.AggregatePeriod `json:"period,omitempty"`
+	From    string                `json:"from,omitempty"`
+	To      string                `json:"to,omitempty"`
+	Buckets []AggregateBucket     `json:"buckets"`
+}
+
+// getAggregate serves GET /aggregate?groupBy=retailer&metric=points&period=week&from=&to=, summing the metric of the
+// stored receipts purchased between the two inclusive dates per group and period. Without groupBy or period there is
+// one group or period. Backends that aggregate themselves (postgres, in SQL) answer it; otherwise, and for the
+// accounts SQL doesn't know about, the store's daily aggregates do.
+func getAggregate(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := statsDates(w, r)
+	if !ok {
+		return
+	}
+	q := store.AggregateQuery{
+		GroupBy: store.AggregateField(r.URL.Query().Get("groupBy")),
+		Metric:  store.AggregateMetric(r.URL.Query().Get("metric")),
+		Period:  store.AggregatePeriod(r.URL.Query().Get("period")),
+		From:    from,
+		To:      to,
+	}
+	if q.Metric == "" {
+		q.Metric = store.MetricReceipts
+	}
+	if q.GroupBy != store.GroupByNone && !slices.Contains(aggregateGroups, q.GroupBy) {
+		http.Error(w, "The groupBy must be one of "+joinNames(aggregateGroups)+".", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(aggregateMetrics, q.Metric) {
+		http.Error(w, "The metric must be one of "+joinNames(aggregateMetrics)+".", http.StatusBadRequest)
+		return
+	}
+	if q.Period != store.PeriodNone && !slices.Contains(aggregatePeriods, q.Period) {
+		http.Error(w, "The period must be one of "+joinNames(aggregatePeriods)+".", http.StatusBadRequest)
+		return
+	}
+
+	var buckets []store.AggregateBucket
+	var err error
+	if aggregator, inStore := store.Unwrap(receiptStore).(store.Aggregator); inStore && q.GroupBy != groupByAccount {
+		buckets, err = aggregator.Aggregate(r.Context(), q)
+	} else {
+		buckets, err = aggregateDaily(r.Context(), q)
+	}
+	if err != nil {
+		logger.Error("Failed to aggregate receipts", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	response := aggregateResponse{GroupBy: q.GroupBy, Metric: q.Metric, Period: q.Period, From: from, To: to, Buckets: []AggregateBucket{}}
+	for _, b := range buckets {
+		value := json.Number(fmt.Sprint(b.Value))
+		if q.Metric == store.MetricTotal {
+			value = json.Number(statsTotals{cents: b.Value}.total())
+		}
+		response.Buckets = append(response.Buckets, AggregateBucket{Group: b.Group, Period: b.Period, Value: value})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// joinNames lists the names of a parameter's values for an error message.
+func joinNames[T ~string](values []T) string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = string(v)
+	}
+	return strings.Join(names, ", ")
+}
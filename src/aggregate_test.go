@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestGetAggregate(t *testing.T) {
+	router := setup()
+	for _, receipt := range []receipttest.Receipt{
+		receipttest.New().Retailer("Target").PurchaseDate("2022-01-03").Item("Dasani", "1.40").Build(),
+		receipttest.New().Retailer("Target").PurchaseDate("2022-01-09").Item("Dasani", "1.40").Build(),
+		receipttest.New().Retailer("Walgreens").PurchaseDate("2022-01-10").Item("Dasani", "2.25").Build(),
+		receipttest.New().Retailer("Target").PurchaseDate("2022-02-01").PaymentMethod("cash").Item("Dasani", "1.40").Build(),
+	} {
+		submitForAccount(t, router, "", receipt)
+	}
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []AggregateBucket
+	}{
+		{name: "receipts by default", query: "", wantStatus: http.StatusOK, want: []AggregateBucket{{Value: "4"}}},
+		{name: "total per retailer and week", query: "?groupBy=retailer&metric=total&period=week&to=2022-01-31", wantStatus: http.StatusOK, want: []AggregateBucket{
+			{Group: "Target", Period: "2022-01-03", Value: "2.80"},
+			{Group: "Walgreens", Period: "2022-01-10", Value: "2.25"},
+		}},
+		{name: "receipts per month", query: "?period=month", wantStatus: http.StatusOK, want: []AggregateBucket{
+			{Period: "2022-01", Value: "3"},
+			{Period: "2022-02", Value: "1"},
+		}},
+		{name: "without a payment method", query: "?groupBy=paymentMethod&from=2022-01-10", wantStatus: http.StatusOK, want: []AggregateBucket{
+			{Group: "", Value: "1"},
+			{Group: "cash", Value: "1"},
+		}},
+		{name: "unknown group", query: "?groupBy=state", wantStatus: http.StatusBadRequest},
+		{name: "by account", query: "?groupBy=account", wantStatus: http.StatusOK, want: []AggregateBucket{{Group: "", Value: "4"}}},
+		{name: "unknown metric", query: "?metric=items", wantStatus: http.StatusBadRequest},
+		{name: "unknown period", query: "?period=year", wantStatus: http.StatusBadRequest},
+		{name: "invalid date", query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/aggregate"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp aggregateResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if !reflect.DeepEqual(resp.Buckets, tc.want) {
+				t.Errorf("buckets = %+v, want %+v", resp.Buckets, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetAggregatePoints(t *testing.T) {
+	router := setup()
+	points := submitForAccount(t, router, "", receipttest.New().Build())
+	points += submitForAccount(t, router, "", receipttest.New().Build())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/aggregate?metric=points&groupBy=retailer", nil))
+	var resp aggregateResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	want := []AggregateBucket{{Group: "Target", Value: json.Number(strconv.FormatInt(points, 10))}}
+	if !reflect.DeepEqual(resp.Buckets, want) {
+		t.Errorf("buckets = %+v, want %+v", resp.Buckets, want)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// amendmentPath matches the item and reprocess endpoints support uses to fix receipts, which need the receipts:amend
+// scope.
+var amendmentPath = regexp.MustCompile(`^/receipts/[^/]+/(items(/[^/]+)?|reprocess)$`)
+
+// addItemRequest is the item to add, and optionally the corrected total of the receipt.
+type addItemRequest struct {
+	ItemDTO
+	Total string `json:"total"`
+}
+
+// amendment is the response of the item endpoints: the receipt as amended and its new points.
+type amendment struct {
+	ID             string     `json:"id"`
+	Points         int64      `json:"points"`
+	PreviousPoints int64      `json:"previousPoints"`
+	ItemsTotal     string     `json:"itemsTotal"`
+	Receipt        ReceiptDTO `json:"receipt"`
+}
+
+// addItem serves POST /receipts/{id}/items, appending an item to a stored receipt.
+func addItem(w http.ResponseWriter, r *http.Request) {
+	var req addItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	amendReceipt(w, r, "addItem", req.Total, func(dto *ReceiptDTO) error {
+		dto.Items = append(dto.Items, req.ItemDTO)
+		return nil
+	})
+}
+
+// deleteItem serves DELETE /receipts/{id}/items/{index}, removing the item at the zero-based index of a stored
+// receipt. The total can be corrected with the total query parameter.
+func deleteItem(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil {
+		http.Error(w, "The item index must be a number.", http.StatusBadRequest)
+		return
+	}
+	amendReceipt(w, r, "deleteItem", r.URL.Query().Get("total"), func(dto *ReceiptDTO) error {
+		if index < 0 || index >= len(dto.Items) {
+			return validation.Errors{"items": validation.NewError("validation_index", fmt.Sprintf("the receipt has no item %d", index))}
+		}
+		dto.Items = append(dto.Items[:index], dto.Items[index+1:]...)
+		return nil
+	})
+}
+
+// amendReceipt applies change to the stored receipt, validates the result like a new submission, scores it again
+// and credits or debits the difference to the account the receipt was credited to. Only requests with an API key
+// may amend receipts. Receipts with returns can't be amended, the returns were checked against their items.
+func amendReceipt(w http.ResponseWriter, r *http.Request, operation, total string, change func(*ReceiptDTO) error) {
+	id := mux.Vars(r)["id"]
+	key := apiKeyFrom(r.Context())
+	if key == "" {
+		http.Error(w, "An API key with the receipts:amend scope is required to amend receipts.", http.StatusUnauthorized)
+		return
+	}
+
+	// returns.mu also serializes amendments, against each other and against returns.
+	returns.mu.Lock()
+	defer returns.mu.Unlock()
+	unlock := lockReceiptAccount(id)
+	defer unlock()
+	if len(returns.byReceipt[id]) > 0 {
+		http.Error(w, "Receipts with returns can't be amended.", http.StatusConflict)
+		return
+	}
+
+	original, rec, err := loadReceipt(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	dto := original.ToDTO()
+	dto.Items = append([]ItemDTO(nil), dto.Items...)
+	if total != "" {
+		dto.Total = total
+	}
+	err = change(&dto)
+	if err == nil {
+		err = dto.Validate()
+	}
+	var amended Receipt
+	if err == nil {
+		amended, err = dto.ToReceipt()
+	}
+	if err != nil {
+		http.Error(w, "The amended receipt is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	points := amendedPoints(rec.Points, original, amended)
+	payload, err := json.Marshal(storedReceipt{ReceiptDTO: amended.ToDTO(), FraudCheck: storedFraudCheck(rec.Receipt)})
+	if err != nil {
+		logger.Error("Failed to marshal receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	err = receiptStore.Update(r.Context(), store.Record{ID: id, Points: points, Receipt: payload})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to update receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	// the points of a receipt under review or on hold are credited once it's approved or they settle, with what it
+	// earns then.
+	if delta := points - rec.Points; delta != 0 && !audits.pending(id) && !holds.pending(id) {
+		if _, _, err := pointsLedger.Rescore(id, delta); err != nil && !errors.Is(err, ledger.ErrUnownedReceipt) {
+			logger.Error("Failed to credit rescored points", zap.String("receiptID", id), zap.Int64("delta", delta), zap.Error(err))
+		}
+	}
+	pointsLedger.RecordAudit("receipt.amend", map[string]any{"receiptId": id, "operation": operation, "apiKey": key, "pointsBefore": rec.Points, "pointsAfter": points,
+		"diff": diffReceipts(original, amended, rec.Points, points)})
+	logger.Info("Amended receipt", zap.String("receiptID", id), zap.String("operation", operation), zap.String("apiKey", key), zap.Int64("points", points))
+
+	var itemsTotal float64
+	for _, item := range amended.Items {
+		itemsTotal += item.Price
+	}
+	response := amendment{ID: id, Points: points, PreviousPoints: rec.Points, ItemsTotal: strconv.FormatFloat(itemsTotal, 'f', 2, 64), Receipt: amended.ToDTO()}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// amendedPoints moves the points a receipt was awarded by what the amendment changes about its score under the live
+// rules, so a receipt scored under older rules keeps their effect. A receipt that earned nothing although its rules
+// award points was over its account's daily limit, and keeps earning nothing.
+func amendedPoints(awarded int64, original, amended Receipt) int64 {
+	before, after := int64(original.CalculatePoints()), int64(amended.CalculatePoints())
+	if awarded == 0 && before > 0 {
+		return 0
+	}
+	return max(0, awarded+after-before)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestAmendedPoints(t *testing.T) {
+	var original, amended Receipt
+	json.Unmarshal(receipttest.New().Item("Gatorade", "6.00").Build().JSON(), &original)
+	json.Unmarshal(receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build().JSON(), &amended)
+	diff := int64(amended.CalculatePoints() - original.CalculatePoints())
+
+	testCases := []struct {
+		name    string
+		awarded int64
+		want    int64
+	}{
+		{name: "scored under the live rules", awarded: int64(original.CalculatePoints()), want: int64(amended.CalculatePoints())},
+		{name: "scored under older rules", awarded: int64(original.CalculatePoints()) + 7, want: int64(amended.CalculatePoints()) + 7},
+		{name: "over the daily limit", awarded: 0, want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := amendedPoints(tc.awarded, original, amended); got != tc.want {
+				t.Errorf("amendedPoints() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if got := amendedPoints(1, amended, original); got != max(0, 1-diff) {
+		t.Errorf("amendedPoints() = %v, want it to never go below 0", got)
+	}
+}
+
+func TestAmendReceipt(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build()
+	points := submitForAccount(t, router, "alice", receipt)
+	var original Receipt
+	json.Unmarshal(receipt.JSON(), &original)
+	id := pointsLedger.Receipts("alice")[0]
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"support": "support-secret-0123456789"}}
+	liveConfig.Store(&live)
+
+	amend := func(method, path, body, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if secret != "" {
+			req.Header.Set("X-API-Key", secret)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		secret     string
+		wantStatus int
+		wantItems  int
+	}{
+		{name: "no API key", method: "POST", path: "/receipts/" + id + "/items", body: `{"shortDescription": "Doritos", "price": "3.35"}`, wantStatus: http.StatusUnauthorized},
+		{name: "add item", method: "POST", path: "/receipts/" + id + "/items", body: `{"shortDescription": "Doritos", "price": "3.35", "total": "13.35"}`, secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantItems: 3},
+		{name: "invalid item", method: "POST", path: "/receipts/" + id + "/items", body: `{"shortDescription": "Doritos", "price": "3"}`, secret: "support-secret-0123456789", wantStatus: http.StatusBadRequest},
+		{name: "invalid total", method: "POST", path: "/receipts/" + id + "/items", body: `{"shortDescription": "Doritos", "price": "3.35", "total": "lots"}`, secret: "support-secret-0123456789", wantStatus: http.StatusBadRequest},
+		{name: "delete item", method: "DELETE", path: "/receipts/" + id + "/items/0?total=7.35", secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantItems: 2},
+		{name: "index out of range", method: "DELETE", path: "/receipts/" + id + "/items/5", secret: "support-secret-0123456789", wantStatus: http.StatusBadRequest},
+		{name: "index not a number", method: "DELETE", path: "/receipts/" + id + "/items/first", secret: "support-secret-0123456789", wantStatus: http.StatusBadRequest},
+		{name: "unknown receipt", method: "DELETE", path: "/receipts/" + unknownReceiptID + "/items/0", secret: "support-secret-0123456789", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := amend(tc.method, tc.path, tc.body, tc.secret)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp amendment
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if len(resp.Receipt.Items) != tc.wantItems {
+				t.Errorf("items = %v, want %v", len(resp.Receipt.Items), tc.wantItems)
+			}
+			amended, _ := resp.Receipt.ToReceipt()
+			if want := points + int64(amended.CalculatePoints()-original.CalculatePoints()); resp.Points != want {
+				t.Errorf("points = %v, want %v", resp.Points, want)
+			}
+			if balance, _ := pointsLedger.Balance("alice"); balance != resp.Points {
+				t.Errorf("balance = %v, want %v", balance, resp.Points)
+			}
+			rec, _ := receiptStore.Get(t.Context(), id)
+			if rec.Points != resp.Points {
+				t.Errorf("stored points = %v, want %v", rec.Points, resp.Points)
+			}
+		})
+	}
+
+	t.Run("receipt with returns", func(t *testing.T) {
+		liveConfig.Store(&cfg)
+		body, _ := json.Marshal(map[string]any{"items": []receipttest.Item{{ShortDescription: "Dasani", Price: "4.00"}}})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+id+"/returns", bytes.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("return failed: %v %s", rr.Code, rr.Body)
+		}
+		liveConfig.Store(&live)
+		if rr := amend("DELETE", "/receipts/"+id+"/items/0", "", "support-secret-0123456789"); rr.Code != http.StatusConflict {
+			t.Errorf("got %v %s, want 409", rr.Code, rr.Body)
+		}
+	})
+}
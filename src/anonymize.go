@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// anonymizer turns stored receipts into records that can be handed to the data science team: retailers, descriptions,
+// transaction numbers and IDs become keyed hashes, store locations are dropped, purchase times move by up to jitter,
+// and only a sampleRate share of the receipts is kept. Prices, totals and points stay as they are, they are what the
+// models learn from.
+//
+// Everything is derived from the key and the receipt ID, so the same key gives the same sample, hashes and jitter on
+// every run, and the same description the same hash across receipts. Without the key a hash can't be tied back to a
+// name by hashing guesses.
+type anonymizer struct {
+	key        []byte
+	sampleRate float64
+	jitter     time.Duration
+}
+
+// anonymizedHashLength is how many hex characters of a hash are kept, enough to make collisions between the
+// descriptions of a store negligible.
+const anonymizedHashLength = 16
+
+func (a anonymizer) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "\n" + value))
+	return hex.EncodeToString(mac.Sum(nil))[:anonymizedHashLength]
+}
+
+// fraction maps kind and id to a number in [0, 1).
+func (a anonymizer) fraction(kind, id string) float64 {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "\n" + id))
+	return float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11) / (1 << 53)
+}
+
+// sampled reports whether the receipt with id is in the sample.
+func (a anonymizer) sampled(id string) bool {
+	return a.fraction("sample", id) < a.sampleRate
+}
+
+// shift is the jitter of the receipt with id, between -jitter and +jitter, rounded to minutes like purchase times.
+func (a anonymizer) shift(id string) time.Duration {
+	return (time.Duration((a.fraction("jitter", id)*2-1)*float64(a.jitter)) / time.Minute) * time.Minute
+}
+
+// anonymize returns the anonymized form of rec. Descriptions are trimmed and lowercased before hashing, so the
+// spellings of an item that differ only in case or padding share a hash.
+func (a anonymizer) anonymize(rec store.Record) (store.Record, error) {
+	var receipt ReceiptDTO
+	if err := json.Unmarshal(rec.Receipt, &receipt); err != nil {
+		return store.Record{}, err
+	}
+
+	receipt.Retailer = a.hash("retailer", strings.ToLower(strings.TrimSpace(receipt.Retailer)))
+	for i := range receipt.Items {
+		receipt.Items[i].ShortDescription = a.hash("description", strings.ToLower(strings.TrimSpace(receipt.Items[i].ShortDescription)))
+	}
+	// a store's location narrows down where its customers live.
+	receipt.StoreLocation = nil
+	// partners put their own identifiers there.
+	receipt.Metadata = nil
+	if receipt.TransactionNumber != "" {
+		receipt.TransactionNumber = a.hash("transaction", normalizeTransactionNumber(receipt.TransactionNumber))
+	}
+	shift := a.shift(rec.ID)
+	if purchased, err := time.Parse("2006-01-02 15:04", receipt.PurchaseDate+" "+receipt.PurchaseTime); err == nil {
+		purchased = purchased.Add(shift)
+		receipt.PurchaseDate, receipt.PurchaseTime = purchased.Format("2006-01-02"), purchased.Format("15:04")
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return store.Record{}, err
+	}
+	return store.Record{
+		ID:        a.hash("id", rec.ID),
+		Points:    rec.Points,
+		Receipt:   data,
+		CreatedAt: rec.CreatedAt.Add(shift).Truncate(time.Minute),
+	}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestAnonymize(t *testing.T) {
+	a := anonymizer{key: []byte("test key"), sampleRate: 1, jitter: 48 * time.Hour}
+	rec := store.Record{
+		ID:        "receipt-1",
+		Points:    28,
+		Receipt:   receipttest.New().Retailer("Target").PurchaseDate("2022-01-01").PurchaseTime("13:01").Item("Mountain Dew 12PK", "6.49").Item("  mountain dew 12pk ", "6.49").Build().JSON(),
+		CreatedAt: time.Date(2022, 1, 1, 13, 5, 0, 0, time.UTC),
+	}
+
+	got, err := a.anonymize(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"receipt-1", "Target", "Mountain"} {
+		if strings.Contains(string(got.Receipt), secret) || got.ID == secret {
+			t.Errorf("anonymized record still contains %q: %s", secret, got.Receipt)
+		}
+	}
+	var receipt ReceiptDTO
+	if err := json.Unmarshal(got.Receipt, &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Items[0].ShortDescription != receipt.Items[1].ShortDescription {
+		t.Errorf("descriptions differing in case and padding hashed to %s and %s, want the same", receipt.Items[0].ShortDescription, receipt.Items[1].ShortDescription)
+	}
+	if receipt.Items[0].Price != "6.49" || got.Points != 28 {
+		t.Errorf("prices and points changed: %s, %d points", got.Receipt, got.Points)
+	}
+	purchased, err := time.Parse("2006-01-02 15:04", receipt.PurchaseDate+" "+receipt.PurchaseTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shift := purchased.Sub(time.Date(2022, 1, 1, 13, 1, 0, 0, time.UTC)); shift.Abs() > 48*time.Hour {
+		t.Errorf("purchase time moved by %v, want at most 48h", shift)
+	}
+
+	again, err := a.anonymize(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Receipt, got.Receipt) || again.ID != got.ID {
+		t.Errorf("anonymizing twice gave %s and %s, want the same", got.Receipt, again.Receipt)
+	}
+}
+
+func TestAnonymizerSample(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rate     float64
+		min, max int
+	}{
+		{name: "everything", rate: 1, min: 1000, max: 1000},
+		{name: "a tenth", rate: 0.1, min: 50, max: 150},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := anonymizer{key: []byte("test key"), sampleRate: tc.rate}
+			sampled := 0
+			for i := range 1000 {
+				if a.sampled(fmt.Sprint(i)) {
+					sampled++
+				}
+			}
+			if sampled < tc.min || sampled > tc.max {
+				t.Errorf("sampled %d of 1000, want between %d and %d", sampled, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestExportRecordsAnonymized(t *testing.T) {
+	s := store.NewMemory()
+	body := receipttest.New().Retailer("Target").Build().JSON()
+	if err := s.Put(context.Background(), store.Record{ID: "receipt-1", Receipt: body}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := exportRecords(context.Background(), s, &out, anonymizer{key: []byte("k"), sampleRate: 1}, true); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "Target") || strings.Contains(out.String(), "receipt-1") {
+		t.Errorf("export = %s, want it anonymized", out.String())
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AuthConfig lists static API keys as key ID -> secret. Partners send the secret in the X-API-Key header and
+// everything they do is attributed to the key ID. Keys can also be given in the API_KEYS environment variable as
+// "id=secret,id2=secret2", which adds to the file. Keys created through /admin/keys live in the store instead and
+// can be scoped, expired, rotated and revoked. With Required, requests without a valid key are rejected; otherwise
+// requests without one are served anonymously. Admin routes always need a key with the admin scope, /metrics and the
+// UI are never checked.
+type AuthConfig struct {
+	Required bool              `json:"required"`
+	APIKeys  map[string]string `json:"apiKeys"`
+	// AdminKeys are the IDs of the static keys that may use the admin routes, also from ADMIN_API_KEYS as
+	// "id,id2". The other static keys have every scope but admin.
+	AdminKeys []string `json:"adminKeys"`
+}
+
+const minAPIKeySecretLength = 16
+
+var apiKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func (c AuthConfig) Validate() error {
+	secrets := map[string]bool{}
+	for id, secret := range c.APIKeys {
+		if !apiKeyIDPattern.MatchString(id) {
+			return fmt.Errorf("auth: API key ID %q must be 1-64 letters, digits, dashes or underscores", id)
+		}
+		if len(secret) < minAPIKeySecretLength {
+			return fmt.Errorf("auth: API key %q must be at least %d characters", id, minAPIKeySecretLength)
+		}
+		if secrets[secret] {
+			return fmt.Errorf("auth: API key %q reuses another key's secret", id)
+		}
+		secrets[secret] = true
+	}
+	for _, id := range c.AdminKeys {
+		if _, ok := c.APIKeys[id]; !ok {
+			return fmt.Errorf("auth: admin key %q is not one of the API keys", id)
+		}
+	}
+	return nil
+}
+
+// parseAPIKeys reads the API_KEYS format, "id=secret" pairs separated by commas.
+func parseAPIKeys(s string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("API_KEYS entries must look like id=secret")
+		}
+		keys[id] = secret
+	}
+	return keys, nil
+}
+
+// apiKeysFromEnv adds the keys in API_KEYS and the admin keys in ADMIN_API_KEYS to c.
+func apiKeysFromEnv(c *AuthConfig) error {
+	for _, id := range strings.Split(os.Getenv("ADMIN_API_KEYS"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !slices.Contains(c.AdminKeys, id) {
+			c.AdminKeys = append(c.AdminKeys, id)
+		}
+	}
+	env := os.Getenv("API_KEYS")
+	if env == "" {
+		return nil
+	}
+	keys, err := parseAPIKeys(env)
+	if err != nil {
+		return err
+	}
+	if c.APIKeys == nil {
+		c.APIKeys = map[string]string{}
+	}
+	for id, secret := range keys {
+		c.APIKeys[id] = secret
+	}
+	return nil
+}
+
+// staticScopes are the scopes of a static key: all of them for the admin keys, all but admin for the others.
+func (c AuthConfig) staticScopes(id string) []string {
+	if slices.Contains(c.AdminKeys, id) {
+		return apiKeyScopes
+	}
+	return slices.DeleteFunc(slices.Clone(apiKeyScopes), func(scope string) bool { return scope == adminScope })
+}
+
+// lookup returns the ID of the static key with the given secret. Every secret is compared in constant time so
+// response times don't hint at how close a guess was.
+func (c AuthConfig) lookup(secret string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	found := ""
+	for id, s := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(s), []byte(secret)) == 1 {
+			found = id
+		}
+	}
+	return found, found != ""
+}
+
+// adminScope allows the /admin routes, which also means minting keys with any scope.
+const adminScope = "admin"
+
+// apiKeyScopes are what a managed key can be limited to. GET requests need the read scope of the resource, anything
+// else the write scope, and amending receipts its own scope. The debug scope allows X-Debug. Static keys have every
+// scope but admin, unless they are admin keys.
+var apiKeyScopes = []string{"receipts:read", "receipts:write", "receipts:amend", "accounts:read", "accounts:write", debugScope, adminScope}
+
+// scopeResources maps the first path segment to the resource its scopes are named after.
+var scopeResources = map[string]string{
+	"receipts": "receipts", "receipt-groups": "receipts", "receipt-templates": "receipts", "ingest": "receipts",
+	"stats": "receipts", "aggregate": "receipts", "accounts": "accounts", "erasures": "accounts", "events": "accounts",
+}
+
+func requiredScope(r *http.Request) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if segment == "admin" {
+		return adminScope
+	}
+	resource, ok := scopeResources[segment]
+	if !ok {
+		return ""
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return resource + ":read"
+	}
+	if amendmentPath.MatchString(r.URL.Path) {
+		return "receipts:amend"
+	}
+	return resource + ":write"
+}
+
+var (
+	errAPIKeyInvalid = errors.New("invalid API key")
+	errAPIKeyExpired = errors.New("expired API key")
+	errAPIKeyRevoked = errors.New("revoked API key")
+)
+
+var apiKeyErrorMessages = map[error]string{
+	errAPIKeyInvalid: "The API key is not valid.",
+	errAPIKeyExpired: "The API key has expired.",
+	errAPIKeyRevoked: "The API key has been revoked.",
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyRing is the managed keys as the middleware sees them, indexed by secret hash. Looking up a hash leaks nothing
+// useful about the secret, so unlike static keys a map lookup is fine here.
+type keyRing struct {
+	bySecretHash map[string]store.APIKey
+}
+
+var managedKeys atomic.Pointer[keyRing]
+
+func newKeyRing(keys []store.APIKey) *keyRing {
+	ring := &keyRing{bySecretHash: map[string]store.APIKey{}}
+	for _, key := range keys {
+		ring.bySecretHash[key.SecretHash] = key
+		if key.PreviousSecretHash != "" {
+			ring.bySecretHash[key.PreviousSecretHash] = key
+		}
+	}
+	return ring
+}
+
+// authenticate returns the key ID and scopes for secret.
+func authenticate(secret string, now time.Time) (string, []string, error) {
+	auth := currentConfig().Auth
+	if id, ok := auth.lookup(secret); ok {
+		return id, auth.staticScopes(id), nil
+	}
+	ring := managedKeys.Load()
+	if ring == nil {
+		return "", nil, errAPIKeyInvalid
+	}
+	hash := hashAPIKeySecret(secret)
+	key, ok := ring.bySecretHash[hash]
+	switch {
+	case !ok:
+		return "", nil, errAPIKeyInvalid
+	case key.RevokedAt != nil:
+		return "", nil, errAPIKeyRevoked
+	case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+		return "", nil, errAPIKeyExpired
+	case hash == key.PreviousSecretHash && (key.PreviousExpiresAt == nil || !now.Before(*key.PreviousExpiresAt)):
+		// rotated away and past its grace period.
+		return "", nil, errAPIKeyInvalid
+	}
+	return key.ID, key.Scopes, nil
+}
+
+// refreshAPIKeys reloads the managed keys from the store into the middleware.
+func refreshAPIKeys(ctx context.Context) error {
+	ks, ok := receiptStore.(store.KeyStore)
+	if !ok {
+		managedKeys.Store(newKeyRing(nil))
+		return nil
+	}
+	keys, err := ks.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+	managedKeys.Store(newKeyRing(keys))
+	return nil
+}
+
+// startAPIKeyRefresh picks up keys changed through other replicas. Changes made through this one apply right away.
+func startAPIKeyRefresh(ctx context.Context) {
+	go runPeriodically(ctx, 30*time.Second, func(ctx context.Context) {
+		if err := refreshAPIKeys(ctx); err != nil {
+			logger.Error("Failed to refresh API keys, keeping the ones loaded before", zap.Error(err))
+		}
+	})
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFrom returns the ID of the API key the request was made with, if any.
+func apiKeyFrom(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return id
+}
+
+// apiKeyExempt paths don't take API keys. Signed submissions carry their own credentials in the URL.
+func apiKeyExempt(path string) bool {
+	return path == "/metrics" || path == "/ui" || strings.HasPrefix(path, "/ui/") || path == signedSubmissionPath
+}
+
+// statusRecorder remembers the status code a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// apiKeyMiddleware identifies the API key a request was made with, checks its scopes and records its usage. A key
+// that doesn't match is always rejected, even when keys aren't required, so a partner with a typo finds out instead
+// of going unbilled.
+func apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-API-Key")
+		debug := r.Header.Get("X-Debug") == "true"
+		if secret == "" {
+			if debug {
+				http.Error(w, "X-Debug needs an API key in the X-API-Key header.", http.StatusUnauthorized)
+				return
+			}
+			if requiredScope(r) == adminScope {
+				http.Error(w, "An API key with the admin scope is required in the X-API-Key header.", http.StatusUnauthorized)
+				return
+			}
+			// the canary comes from within, see runCanary.
+			if currentConfig().Auth.Required && !isCanary(r.Context()) {
+				http.Error(w, "An API key is required in the X-API-Key header.", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, scopes, err := authenticate(secret, time.Now())
+		if err != nil {
+			logger.Info("Rejected API key", zap.String("reason", apiKeyErrorMessages[err]), zap.String("path", r.URL.Path), zap.String("clientIP", clientIPFrom(r.Context())))
+			http.Error(w, apiKeyErrorMessages[err], http.StatusUnauthorized)
+			return
+		}
+
+		sw := &statusRecorder{ResponseWriter: w}
+		if scope := requiredScope(r); scope != "" && !slices.Contains(scopes, scope) {
+			http.Error(sw, "The API key doesn't have the "+scope+" scope.", http.StatusForbidden)
+		} else if debug && !slices.Contains(scopes, debugScope) {
+			http.Error(sw, "The API key doesn't have the "+debugScope+" scope.", http.StatusForbidden)
+		} else if debug {
+			serveDebug(sw, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, id)), id, next)
+		} else {
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, id)))
+		}
+		keyUsage.recordRequest(id, sw.status)
+	})
+}
+
+// APIKeyInfo is how a managed key is shown. Secret is only set in the response that created or rotated the key, it
+// can't be looked up again.
+type APIKeyInfo struct {
+	ID                      string     `json:"id"`
+	Secret                  string     `json:"secret,omitempty"`
+	Scopes                  []string   `json:"scopes"`
+	Status                  string     `json:"status"`
+	CreatedAt               time.Time  `json:"createdAt"`
+	ExpiresAt               *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt               *time.Time `json:"revokedAt,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previousSecretExpiresAt,omitempty"`
+}
+
+func apiKeyInfo(key store.APIKey, now time.Time) APIKeyInfo {
+	info := APIKeyInfo{ID: key.ID, Scopes: key.Scopes, Status: "active", CreatedAt: key.CreatedAt, ExpiresAt: key.ExpiresAt, RevokedAt: key.RevokedAt}
+	switch {
+	case key.RevokedAt != nil:
+		info.Status = "revoked"
+	case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+		info.Status = "expired"
+	}
+	if key.PreviousExpiresAt != nil && now.Before(*key.PreviousExpiresAt) {
+		info.PreviousSecretExpiresAt = key.PreviousExpiresAt
+	}
+	return info
+}
+
+// newAPIKeySecret returns 32 random bytes in a form that survives headers and shells.
+func newAPIKeySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "fcpc_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// keyAdminMu keeps rotations and revocations of the same key from overwriting each other on this replica.
+var keyAdminMu sync.Mutex
+
+// keyStoreFor writes the 501 response itself when the backend can't keep keys.
+func keyStoreFor(w http.ResponseWriter) (store.KeyStore, bool) {
+	ks, ok := receiptStore.(store.KeyStore)
+	if !ok {
+		http.Error(w, "The store backend doesn't keep API keys.", http.StatusNotImplemented)
+	}
+	return ks, ok
+}
+
+func writeAPIKeys(w http.ResponseWriter, status int, v any) {
+	jsonResponse, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// saveAPIKey stores key and applies it to the middleware right away.
+func saveAPIKey(ctx context.Context, ks store.KeyStore, key store.APIKey) error {
+	if err := ks.PutKey(ctx, key); err != nil {
+		return err
+	}
+	if err := refreshAPIKeys(ctx); err != nil {
+		// the key is stored, the next periodic refresh picks it up.
+		logger.Error("Failed to refresh API keys", zap.Error(err))
+	}
+	return nil
+}
+
+type createAPIKeyRequest struct {
+	ID        string     `json:"id"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// createAPIKey serves POST /admin/keys.
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	ks, ok := keyStoreFor(w)
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The API key is invalid.", http.StatusBadRequest)
+		return
+	}
+	if !apiKeyIDPattern.MatchString(req.ID) {
+		http.Error(w, "The API key ID must be 1-64 letters, digits, dashes or underscores.", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "The API key needs at least one scope of "+strings.Join(apiKeyScopes, ", ")+".", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			http.Error(w, "The scope "+scope+" is unknown, scopes are "+strings.Join(apiKeyScopes, ", ")+".", http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		http.Error(w, "The expiry must be in the future.", http.StatusBadRequest)
+		return
+	}
+
+	keyAdminMu.Lock()
+	defer keyAdminMu.Unlock()
+	if _, static := currentConfig().Auth.APIKeys[req.ID]; static {
+		http.Error(w, "An API key with that ID already exists.", http.StatusConflict)
+		return
+	}
+	if _, err := ks.GetKey(r.Context(), req.ID); !errors.Is(err, store.ErrKeyNotFound) {
+		if err != nil {
+			logger.Error("Failed to look up API key", zap.String("key", req.ID), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "An API key with that ID already exists.", http.StatusConflict)
+		return
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		logger.Error("Failed to generate API key", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	key := store.APIKey{ID: req.ID, SecretHash: hashAPIKeySecret(secret), Scopes: slices.Compact(slices.Sorted(slices.Values(req.Scopes))), CreatedAt: now, ExpiresAt: req.ExpiresAt}
+	if err := saveAPIKey(r.Context(), ks, key); err != nil {
+		logger.Error("Failed to store API key", zap.String("key", key.ID), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Created API key", zap.String("key", key.ID), zap.Strings("scopes", key.Scopes))
+
+	info := apiKeyInfo(key, now)
+	info.Secret = secret
+	writeAPIKeys(w, http.StatusCreated, info)
+}
+
+// listAPIKeys serves GET /admin/keys, the managed keys including revoked ones. Static keys are in the config.
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ks, ok := keyStoreFor(w)
+	if !ok {
+		return
+	}
+	keys, err := ks.ListKeys(r.Context())
+	if err != nil {
+		logger.Error("Failed to list API keys", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	infos := make([]APIKeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = apiKeyInfo(key, now)
+	}
+	writeAPIKeys(w, http.StatusOK, map[string][]APIKeyInfo{"keys": infos})
+}
+
+// getManagedKey writes the 404 or 500 response itself when the key can't be loaded.
+func getManagedKey(w http.ResponseWriter, r *http.Request, ks store.KeyStore) (store.APIKey, bool) {
+	id := mux.Vars(r)["key"]
+	key, err := ks.GetKey(r.Context(), id)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		http.Error(w, "No API key found for that ID.", http.StatusNotFound)
+		return store.APIKey{}, false
+	}
+	if err != nil {
+		logger.Error("Failed to look up API key", zap.String("key", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return store.APIKey{}, false
+	}
+	return key, true
+}
+
+// getAPIKey serves GET /admin/keys/{key}.
+func getAPIKey(w http.ResponseWriter, r *http.Request) {
+	ks, ok := keyStoreFor(w)
+	if !ok {
+		return
+	}
+	key, ok := getManagedKey(w, r, ks)
+	if !ok {
+		return
+	}
+	writeAPIKeys(w, http.StatusOK, apiKeyInfo(key, time.Now()))
+}
+
+type rotateAPIKeyRequest struct {
+	GracePeriod Duration `json:"gracePeriod"`
+}
+
+// rotateAPIKey serves POST /admin/keys/{key}/rotate. The old secret keeps working for the optional gracePeriod so
+// the partner can switch over without downtime; without one it stops working right away.
+func rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ks, ok := keyStoreFor(w)
+	if !ok {
+		return
+	}
+	var req rotateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "The rotation is invalid.", http.StatusBadRequest)
+		return
+	}
+	if req.GracePeriod < 0 {
+		http.Error(w, "The grace period must not be negative.", http.StatusBadRequest)
+		return
+	}
+
+	keyAdminMu.Lock()
+	defer keyAdminMu.Unlock()
+	key, ok := getManagedKey(w, r, ks)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	if key.RevokedAt != nil {
+		http.Error(w, "The API key has been revoked.", http.StatusConflict)
+		return
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		logger.Error("Failed to generate API key", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	key.PreviousSecretHash, key.PreviousExpiresAt = "", nil
+	if req.GracePeriod > 0 {
+		until := now.Add(time.Duration(req.GracePeriod))
+		key.PreviousSecretHash, key.PreviousExpiresAt = key.SecretHash, &until
+	}
+	key.SecretHash = hashAPIKeySecret(secret)
+	if err := saveAPIKey(r.Context(), ks, key); err != nil {
+		logger.Error("Failed to store API key", zap.String("key", key.ID), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Rotated API key", zap.String("key", key.ID), zap.Duration("gracePeriod", time.Duration(req.GracePeriod)))
+
+	info := apiKeyInfo(key, now)
+	info.Secret = secret
+	writeAPIKeys(w, http.StatusOK, info)
+}
+
+// revokeAPIKey serves DELETE /admin/keys/{key}. The key is kept, revoked, so its usage can still be reported and
+// its ID isn't handed out again.
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ks, ok := keyStoreFor(w)
+	if !ok {
+		return
+	}
+
+	keyAdminMu.Lock()
+	defer keyAdminMu.Unlock()
+	key, ok := getManagedKey(w, r, ks)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	if key.RevokedAt == nil {
+		key.RevokedAt = &now
+		if err := saveAPIKey(r.Context(), ks, key); err != nil {
+			logger.Error("Failed to store API key", zap.String("key", key.ID), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Revoked API key", zap.String("key", key.ID))
+	}
+	writeAPIKeys(w, http.StatusOK, apiKeyInfo(key, now))
+}
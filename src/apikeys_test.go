@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// testAdminSecret is a static key in API_KEYS for every test, so the tests can call the admin routes through
+// adminRequest.
+const testAdminSecret = "test-admin-secret-0123456789"
+
+func init() {
+	os.Setenv("API_KEYS", "test-admin="+testAdminSecret)
+	os.Setenv("ADMIN_API_KEYS", "test-admin")
+}
+
+// adminRequest is httptest.NewRequest with the test admin key.
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("X-API-Key", testAdminSecret)
+	return req
+}
+
+func adminKeyRequest(t *testing.T, router http.Handler, method, path, body string, wantStatus int) APIKeyInfo {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(method, path, bytes.NewBufferString(body)))
+	if rr.Code != wantStatus {
+		t.Fatalf("%v %v returned wrong status code: got %v want %v: %s", method, path, rr.Code, wantStatus, rr.Body)
+	}
+	var info APIKeyInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	return info
+}
+
+// submitWithKey posts a receipt with the given secret and returns the status code.
+func submitWithKey(router http.Handler, secret string) int {
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON()))
+	req.Header.Set("X-API-Key", secret)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"static": "static-secret-0123456789", "test-admin": testAdminSecret}, AdminKeys: []string{"test-admin"}}
+	liveConfig.Store(&live)
+	adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "taken", "scopes": ["receipts:read"]}`, http.StatusCreated)
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "ok", body: `{"id": "acme", "scopes": ["receipts:write", "receipts:read"]}`, wantStatus: http.StatusCreated},
+		{name: "with expiry", body: `{"id": "trial", "scopes": ["receipts:write"], "expiresAt": "2999-01-01T00:00:00Z"}`, wantStatus: http.StatusCreated},
+		{name: "expiry in the past", body: `{"id": "late", "scopes": ["receipts:write"], "expiresAt": "2020-01-01T00:00:00Z"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid ID", body: `{"id": "a b", "scopes": ["receipts:write"]}`, wantStatus: http.StatusBadRequest},
+		{name: "no scopes", body: `{"id": "none"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown scope", body: `{"id": "root", "scopes": ["superuser"]}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "managed ID taken", body: `{"id": "taken", "scopes": ["receipts:read"]}`, wantStatus: http.StatusConflict},
+		{name: "static ID taken", body: `{"id": "static", "scopes": ["receipts:read"]}`, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := adminKeyRequest(t, router, "POST", "/admin/keys", tc.body, tc.wantStatus)
+			if tc.wantStatus == http.StatusCreated && (info.Secret == "" || info.Status != "active") {
+				t.Errorf("created key = %+v, want an active key with its secret", info)
+			}
+		})
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	router := setup()
+
+	created := adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "acme", "scopes": ["receipts:write"]}`, http.StatusCreated)
+	if got := submitWithKey(router, created.Secret); got != http.StatusOK {
+		t.Fatalf("new key got status %v, want %v", got, http.StatusOK)
+	}
+	req := httptest.NewRequest("GET", "/receipts", nil)
+	req.Header.Set("X-API-Key", created.Secret)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("key without receipts:read got status %v, want %v", rr.Code, http.StatusForbidden)
+	}
+
+	got := adminKeyRequest(t, router, "GET", "/admin/keys/acme", "", http.StatusOK)
+	if got.Secret != "" {
+		t.Error("looking up a key returned its secret")
+	}
+	adminKeyRequest(t, router, "GET", "/admin/keys/nobody", "", http.StatusNotFound)
+
+	rotated := adminKeyRequest(t, router, "POST", "/admin/keys/acme/rotate", `{"gracePeriod": "1h"}`, http.StatusOK)
+	if rotated.PreviousSecretExpiresAt == nil {
+		t.Error("rotation with a grace period doesn't report when the old secret expires")
+	}
+	for name, secret := range map[string]string{"old": created.Secret, "new": rotated.Secret} {
+		if got := submitWithKey(router, secret); got != http.StatusOK {
+			t.Errorf("%v secret during the grace period got status %v, want %v", name, got, http.StatusOK)
+		}
+	}
+
+	again := adminKeyRequest(t, router, "POST", "/admin/keys/acme/rotate", "", http.StatusOK)
+	for name, secret := range map[string]string{"first": created.Secret, "second": rotated.Secret} {
+		if got := submitWithKey(router, secret); got != http.StatusUnauthorized {
+			t.Errorf("%v secret after rotating without a grace period got status %v, want %v", name, got, http.StatusUnauthorized)
+		}
+	}
+
+	revoked := adminKeyRequest(t, router, "DELETE", "/admin/keys/acme", "", http.StatusOK)
+	if revoked.Status != "revoked" {
+		t.Errorf("status after revoking = %q, want revoked", revoked.Status)
+	}
+	if got := submitWithKey(router, again.Secret); got != http.StatusUnauthorized {
+		t.Errorf("revoked key got status %v, want %v", got, http.StatusUnauthorized)
+	}
+	adminKeyRequest(t, router, "POST", "/admin/keys/acme/rotate", "", http.StatusConflict)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/admin/keys", nil))
+	var list map[string][]APIKeyInfo
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list["keys"]) != 1 || list["keys"][0].Status != "revoked" {
+		t.Errorf("keys = %+v, want the revoked key", list["keys"])
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	keys := map[string]string{"acme": "acme-secret-0123456789"}
+	if err := (AuthConfig{APIKeys: keys, AdminKeys: []string{"acme"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (AuthConfig{APIKeys: keys, AdminKeys: []string{"root"}}).Validate(); err == nil {
+		t.Error("Validate() accepted an admin key that isn't an API key")
+	}
+}
+
+func TestExpiredAPIKey(t *testing.T) {
+	router := setup()
+	expired := time.Now().Add(-time.Minute)
+	secret := "expired-secret-0123456789"
+	receiptStore.(store.KeyStore).PutKey(context.Background(), store.APIKey{ID: "old", SecretHash: hashAPIKeySecret(secret), Scopes: apiKeyScopes, ExpiresAt: &expired})
+	// stored behind the middleware's back, like another replica would.
+	refreshAPIKeys(context.Background())
+
+	if got := submitWithKey(router, secret); got != http.StatusUnauthorized {
+		t.Errorf("expired key got status %v, want %v", got, http.StatusUnauthorized)
+	}
+	if got := adminKeyRequest(t, router, "GET", "/admin/keys/old", "", http.StatusOK); got.Status != "expired" {
+		t.Errorf("status = %q, want expired", got.Status)
+	}
+}
+
+func TestAdminRoutesNeedAdminKey(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"static": "static-secret-0123456789", "test-admin": testAdminSecret}, AdminKeys: []string{"test-admin"}}
+	liveConfig.Store(&live)
+	partner := adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "partner", "scopes": ["receipts:write", "receipts:amend", "debug"]}`, http.StatusCreated)
+	operator := adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "operator", "scopes": ["admin"]}`, http.StatusCreated)
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		secret     string
+		wantStatus int
+	}{
+		{name: "create key without a key", method: "POST", path: "/admin/keys", body: `{"id": "mallory", "scopes": ["receipts:amend", "debug"]}`, wantStatus: http.StatusUnauthorized},
+		{name: "rotate key without a key", method: "POST", path: "/admin/keys/partner/rotate", wantStatus: http.StatusUnauthorized},
+		{name: "revoke key without a key", method: "DELETE", path: "/admin/keys/partner", wantStatus: http.StatusUnauthorized},
+		{name: "restore without a key", method: "POST", path: "/admin/restore", wantStatus: http.StatusUnauthorized},
+		{name: "merge without a key", method: "POST", path: "/admin/accounts/alice/merge", body: `{"from": "bob"}`, wantStatus: http.StatusUnauthorized},
+		{name: "sharding without a key", method: "POST", path: "/admin/sharding/records", body: `{"id": "x"}`, wantStatus: http.StatusUnauthorized},
+		{name: "create key with an invalid key", method: "POST", path: "/admin/keys", body: `{"id": "mallory", "scopes": ["debug"]}`, secret: "guessed-secret-0123456789", wantStatus: http.StatusUnauthorized},
+		{name: "create key without the admin scope", method: "POST", path: "/admin/keys", body: `{"id": "mallory", "scopes": ["debug"]}`, secret: partner.Secret, wantStatus: http.StatusForbidden},
+		{name: "create key with the admin scope", method: "POST", path: "/admin/keys", body: `{"id": "support", "scopes": ["debug"]}`, secret: operator.Secret, wantStatus: http.StatusCreated},
+		{name: "create key with a static key", method: "POST", path: "/admin/keys", body: `{"id": "mallory", "scopes": ["admin"]}`, secret: "static-secret-0123456789", wantStatus: http.StatusForbidden},
+		{name: "backup with a static key", method: "POST", path: "/admin/backup", secret: "static-secret-0123456789", wantStatus: http.StatusForbidden},
+		{name: "list receipts with a static key", method: "GET", path: "/receipts", secret: "static-secret-0123456789", wantStatus: http.StatusOK},
+		{name: "create key with a static admin key", method: "POST", path: "/admin/keys", body: `{"id": "ops", "scopes": ["debug"]}`, secret: testAdminSecret, wantStatus: http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			if tc.secret != "" {
+				req.Header.Set("X-API-Key", tc.secret)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("%s %s = %v %s, want %v", tc.method, tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+		})
+	}
+
+	if got := adminKeyRequest(t, router, "GET", "/admin/keys/partner", "", http.StatusOK); got.Status != "active" {
+		t.Errorf("partner key = %+v, want it left active", got)
+	}
+	adminKeyRequest(t, router, "GET", "/admin/keys/mallory", "", http.StatusNotFound)
+}
+
+func TestRequiredScope(t *testing.T) {
+	testCases := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: "GET", path: "/receipts/abc/points", want: "receipts:read"},
+		{method: "POST", path: "/receipt-groups", want: "receipts:write"},
+		{method: "POST", path: "/receipt-templates", want: "receipts:write"},
+		{method: "DELETE", path: "/receipt-templates/abc", want: "receipts:write"},
+		{method: "GET", path: "/aggregate", want: "receipts:read"},
+		{method: "GET", path: "/events/schemas", want: "accounts:read"},
+		{method: "POST", path: "/admin/keys", want: adminScope},
+		{method: "GET", path: "/health", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			if got := requiredScope(httptest.NewRequest(tc.method, tc.path, nil)); got != tc.want {
+				t.Errorf("requiredScope() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// backfillCheckpoint is where a backfill got to, so an interrupted one resumes after the last receipt it submitted
+// instead of submitting everything again. Files are processed in the order of their paths, Line counts the receipts
+// of a .jsonl file (a .json file has one, on line 1).
+type backfillCheckpoint struct {
+	Dir     string          `json:"dir"`
+	File    string          `json:"file"`
+	Line    int             `json:"line"`
+	Summary backfillSummary `json:"summary"`
+}
+
+// backfillSummary adds up a backfill across resumes. Errors counts the failed receipts by error.
+type backfillSummary struct {
+	Submitted int            `json:"submitted"`
+	Failed    int            `json:"failed"`
+	Points    int64          `json:"points"`
+	Errors    map[string]int `json:"errors,omitempty"`
+}
+
+const (
+	// maxReportedErrors caps the errors the summary lists, the checkpoint has all of them.
+	maxReportedErrors = 10
+	// maxBackfillLine caps a line of a .jsonl file, far above any receipt within the receipt limits.
+	maxBackfillLine = 4 << 20
+)
+
+// parseRate parses a rate like 500/s or 1000/m into the time between two receipts. A bare number is per second.
+func parseRate(rate string) (time.Duration, error) {
+	count, unit, _ := strings.Cut(rate, "/")
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("-rate must be a positive number of receipts per second or minute, like 500/s")
+	}
+	switch unit {
+	case "", "s":
+		return time.Second / time.Duration(n), nil
+	case "m":
+		return time.Minute / time.Duration(n), nil
+	default:
+		return 0, fmt.Errorf("-rate must be per second (/s) or per minute (/m)")
+	}
+}
+
+// backfillFiles returns the *.json and *.jsonl files under dir, in the order a backfill processes them.
+func backfillFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".jsonl")) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	slices.Sort(files)
+	return files, err
+}
+
+func loadCheckpoint(path, dir string) (backfillCheckpoint, error) {
+	checkpoint := backfillCheckpoint{Dir: dir}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	if checkpoint.Dir != dir {
+		return checkpoint, fmt.Errorf("checkpoint %s is of a backfill of %s, remove it or pass another -checkpoint", path, checkpoint.Dir)
+	}
+	return checkpoint, nil
+}
+
+// save writes the checkpoint to a temporary file first, so a crash never leaves half of one behind.
+func (c backfillCheckpoint) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// done reports whether the receipt on line of file was submitted by an earlier run.
+func (c backfillCheckpoint) done(file string, line int) bool {
+	return file < c.File || file == c.File && line <= c.Line
+}
+
+// backfillCommand ingests a directory of historical receipts through the same pipeline as /receipts/process, without
+// HTTP, at most -rate receipts at a time. The checkpoint is saved every second and when interrupted; running the same
+// backfill again resumes where it stopped. Score historical receipts under the rules of their time with the rule
+// history's scoreBy purchaseDate.
+func backfillCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	dir := flags.String("dir", "", "directory of receipt .json files and .jsonl files of one receipt per line")
+	rate := flags.String("rate", "500/s", "the most receipts to submit per second (/s) or minute (/m)")
+	checkpointPath := flags.String("checkpoint", "backfill-checkpoint.json", "file to save progress to and resume from")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *dir == "" || flags.NArg() != 0 {
+		fmt.Fprintln(flags.Output(), "usage: fcpc backfill -dir=... [flags]")
+		flags.PrintDefaults()
+		return errUsage
+	}
+	interval, err := parseRate(*rate)
+	if err != nil {
+		return err
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if c.Store.Backend == "" || c.Store.Backend == "memory" {
+		fmt.Fprintln(stdout, "the memory backend doesn't outlive the process, the receipts are only scored")
+	}
+
+	checkpoint, err := loadCheckpoint(*checkpointPath, *dir)
+	if err != nil {
+		return err
+	}
+	files, err := backfillFiles(*dir)
+	if err != nil {
+		return err
+	}
+	setupFrom(path)
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	resumed := checkpoint.Summary.Submitted + checkpoint.Summary.Failed
+	err = runBackfill(ctx, files, interval, &checkpoint, *checkpointPath, stdout)
+	if saveErr := checkpoint.save(*checkpointPath); saveErr != nil {
+		err = cmp.Or(err, fmt.Errorf("saving checkpoint: %w", saveErr))
+	}
+	printBackfillSummary(stdout, checkpoint.Summary, resumed, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("backfill stopped, run it again to resume: %w", err)
+	}
+	return nil
+}
+
+// runBackfill submits the receipts of files not yet in the checkpoint, one every interval.
+func runBackfill(ctx context.Context, files []string, interval time.Duration, checkpoint *backfillCheckpoint, checkpointPath string, stdout io.Writer) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	saved := time.Now()
+	for _, file := range files {
+		if file < checkpoint.File {
+			continue
+		}
+		err := eachReceipt(file, func(line int, data []byte) error {
+			if checkpoint.done(file, line) {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			result := processReceiptData(ctx, data)
+			if result.Error != "" {
+				checkpoint.Summary.Failed++
+				if checkpoint.Summary.Errors == nil {
+					checkpoint.Summary.Errors = map[string]int{}
+				}
+				checkpoint.Summary.Errors[result.Error]++
+			} else {
+				checkpoint.Summary.Submitted++
+				checkpoint.Summary.Points += int64(result.Points)
+			}
+			checkpoint.File, checkpoint.Line = file, line
+
+			if time.Since(saved) >= time.Second {
+				saved = time.Now()
+				fmt.Fprintf(stdout, "submitted %d, failed %d, at %s\n", checkpoint.Summary.Submitted, checkpoint.Summary.Failed, file)
+				return checkpoint.save(checkpointPath)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachReceipt calls fn with every receipt of a .json or .jsonl file and its line, skipping blank lines.
+func eachReceipt(file string, fn func(line int, data []byte) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(file, ".jsonl") {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		return fn(1, data)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxBackfillLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := fn(line, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	return nil
+}
+
+func printBackfillSummary(w io.Writer, s backfillSummary, resumed int, elapsed time.Duration) {
+	processed := s.Submitted + s.Failed - resumed
+	fmt.Fprintf(w, "submitted %d receipts earning %d points, %d failed", s.Submitted, s.Points, s.Failed)
+	if resumed > 0 {
+		fmt.Fprintf(w, ", %d of them in earlier runs", resumed)
+	}
+	fmt.Fprintf(w, "\n%d receipts in %s, %.0f receipts/s\n", processed, elapsed.Round(time.Millisecond), float64(processed)/max(elapsed.Seconds(), 0.001))
+
+	errs := make([]string, 0, len(s.Errors))
+	for err := range s.Errors {
+		errs = append(errs, err)
+	}
+	slices.SortFunc(errs, func(a, b string) int {
+		return cmp.Or(cmp.Compare(s.Errors[b], s.Errors[a]), strings.Compare(a, b))
+	})
+	for i, err := range errs {
+		if i == maxReportedErrors {
+			fmt.Fprintf(w, "and %d other errors, see the checkpoint\n", len(errs)-maxReportedErrors)
+			break
+		}
+		fmt.Fprintf(w, "%6d  %s\n", s.Errors[err], err)
+	}
+}
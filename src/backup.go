@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+// BackupConfig sets where the key encrypting backups comes from. The "static" provider (the default) takes a base64
+// encoded 32-byte EncryptionKey; providers fetching the key from a KMS register in keyProviders.
+type BackupConfig struct {
+	KeyProvider   string `json:"keyProvider"`
+	EncryptionKey string `json:"encryptionKey"`
+}
+
+func (c BackupConfig) Validate() error {
+	if c.KeyProvider == "" && c.EncryptionKey == "" {
+		return nil
+	}
+	if _, err := c.provider(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+func (c BackupConfig) provider() (keyProvider, error) {
+	name := c.KeyProvider
+	if name == "" {
+		name = "static"
+	}
+	newProvider, ok := keyProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyProvider %q", name)
+	}
+	return newProvider(c)
+}
+
+// keyProvider hands out the 32-byte key backups are encrypted with.
+type keyProvider interface {
+	BackupKey(ctx context.Context) ([]byte, error)
+}
+
+var keyProviders = map[string]func(BackupConfig) (keyProvider, error){
+	"static": newStaticKeyProvider,
+}
+
+type staticKeyProvider []byte
+
+func newStaticKeyProvider(c BackupConfig) (keyProvider, error) {
+	key, err := base64.StdEncoding.DecodeString(c.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("encryptionKey must be 32 bytes, base64 encoded")
+	}
+	return staticKeyProvider(key), nil
+}
+
+func (k staticKeyProvider) BackupKey(ctx context.Context) ([]byte, error) {
+	return k, nil
+}
+
+const (
+	backupMagic     = "FCPCBAK1"
+	backupSaltSize  = 32
+	backupChunkSize = 64 << 10
+)
+
+var errBadBackup = errors.New("backup can't be opened: wrong key, or corrupt or truncated")
+
+// An archive is backupMagic, a random salt, then gzipped JSON lines of store.Records sealed with AES-GCM in chunks,
+// each prefixed by its length. Every archive gets its own key derived from the backup key and the salt, so nonces
+// can simply count chunks. The last chunk is marked as such in its additional data, so a truncated archive fails to
+// open instead of restoring part of the store.
+type backupSealer struct {
+	aead   cipher.AEAD
+	header []byte
+	chunk  uint64
+}
+
+func newBackupSealer(key, salt []byte) (*backupSealer, error) {
+	archiveKey, err := hkdf.Key(sha256.New, key, salt, backupMagic, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(archiveKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &backupSealer{aead: aead, header: append([]byte(backupMagic), salt...)}, nil
+}
+
+func (s *backupSealer) next(final bool) (nonce, additionalData []byte) {
+	nonce = make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], s.chunk)
+	s.chunk++
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	return nonce, append(append([]byte{}, s.header...), flag)
+}
+
+// chunkWriter seals what is written to it in backupChunkSize chunks. Close seals the final chunk.
+type chunkWriter struct {
+	w      io.Writer
+	sealer *backupSealer
+	buf    []byte
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(backupChunkSize-len(c.buf), len(p))
+		c.buf = append(c.buf, p[:take]...)
+		p = p[take:]
+		if len(c.buf) == backupChunkSize {
+			if err := c.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *chunkWriter) seal(final bool) error {
+	nonce, additionalData := c.sealer.next(final)
+	sealed := c.sealer.aead.Seal(nil, nonce, c.buf, additionalData)
+	c.buf = c.buf[:0]
+	if err := binary.Write(c.w, binary.BigEndian, uint32(len(sealed))); err != nil {
+		return err
+	}
+	_, err := c.w.Write(sealed)
+	return err
+}
+
+func (c *chunkWriter) Close() error {
+	return c.seal(true)
+}
+
+// chunkReader opens the chunks written by chunkWriter, failing with errBadBackup on anything that doesn't verify.
+type chunkReader struct {
+	r      io.Reader
+	sealer *backupSealer
+	buf    []byte
+	done   bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkReader) open() error {
+	var size uint32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return errBadBackup
+	}
+	if size > backupChunkSize+uint32(c.sealer.aead.Overhead()) {
+		return errBadBackup
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(c.r, sealed); err != nil {
+		return errBadBackup
+	}
+
+	// a chunk only opens with the flag it was sealed with, so try it as the last one first.
+	for _, final := range []bool{true, false} {
+		sealer := *c.sealer
+		nonce, additionalData := sealer.next(final)
+		plain, err := sealer.aead.Open(nil, nonce, sealed, additionalData)
+		if err != nil {
+			continue
+		}
+		*c.sealer = sealer
+		c.buf, c.done = plain, final
+		if final {
+			// nothing may follow the last chunk.
+			if n, _ := c.r.Read(make([]byte, 1)); n != 0 {
+				return errBadBackup
+			}
+		}
+		return nil
+	}
+	return errBadBackup
+}
+
+// writeBackup writes every record in s to w as an encrypted archive and returns how many there were.
+func writeBackup(ctx context.Context, w io.Writer, s store.Store, key []byte) (int, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	sealer, err := newBackupSealer(key, salt)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(sealer.header); err != nil {
+		return 0, err
+	}
+
+	chunks := &chunkWriter{w: w, sealer: sealer}
+	gz := gzip.NewWriter(chunks)
+	enc := json.NewEncoder(gz)
+	count := 0
+	err = s.Scan(ctx, func(rec store.Record) error {
+		count++
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return count, chunks.Close()
+}
+
+// readBackup opens an archive written by writeBackup. The whole archive is verified before any record is returned.
+func readBackup(r io.Reader, key []byte) ([]store.Record, error) {
+	header := make([]byte, len(backupMagic)+backupSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, errBadBackup
+	}
+	sealer, err := newBackupSealer(key, header[len(backupMagic):])
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(&chunkReader{r: bufio.NewReader(r), sealer: sealer})
+	if err != nil {
+		return nil, errBadBackup
+	}
+	var records []store.Record
+	dec := json.NewDecoder(gz)
+	for {
+		var rec store.Record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			// errBadBackup from the chunks, or a corrupt payload that still authenticated (i.e. a bug).
+			return nil, errBadBackup
+		}
+		records = append(records, rec)
+	}
+}
+
+// backupKey returns the configured backup key, or nil if backups aren't configured.
+func backupKey(ctx context.Context) ([]byte, error) {
+	c := currentConfig().Backup
+	if c.KeyProvider == "" && c.EncryptionKey == "" {
+		return nil, nil
+	}
+	provider, err := c.provider()
+	if err != nil {
+		return nil, err
+	}
+	return provider.BackupKey(ctx)
+}
+
+// backupHandler serves POST /admin/backup, streaming an encrypted archive of the receipt store. A failure halfway
+// can't change the status any more, but it leaves the archive without its final chunk, which restore rejects.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := backupKey(r.Context())
+	if err != nil {
+		logger.Error("Failed to get the backup key", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		http.Error(w, "Backups need an encryption key, see backup in the config.", http.StatusNotImplemented)
+		return
+	}
+
+	extendDeadlines(w)
+	start := time.Now()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fcpc-%s.fcpcbak"`, start.UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	count, err := writeBackup(r.Context(), w, receiptStore, key)
+	if err != nil {
+		logger.Error("Failed to write backup", zap.Error(err))
+		return
+	}
+	logger.Info("Wrote backup", zap.Int("records", count), zap.Duration("took", time.Since(start)))
+}
+
+type restoreResult struct {
+	Records  int `json:"records"`
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+}
+
+// restoreHandler serves POST /admin/restore with an archive from backupHandler as the body. Records that already
+// exist are skipped, so restoring into a store that kept some of its data is fine.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := backupKey(r.Context())
+	if err != nil {
+		logger.Error("Failed to get the backup key", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		http.Error(w, "Backups need an encryption key, see backup in the config.", http.StatusNotImplemented)
+		return
+	}
+
+	extendDeadlines(w)
+	records, err := readBackup(r.Body, key)
+	if errors.Is(err, errBadBackup) {
+		http.Error(w, "The backup can't be opened: the key is wrong, or it is corrupt or truncated.", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to read backup", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	result := restoreResult{Records: len(records)}
+	for _, rec := range records {
+		err := receiptStore.Put(r.Context(), rec)
+		if errors.Is(err, store.ErrExists) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to restore record", zap.String("receiptID", rec.ID), zap.Int("restored", result.Restored), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		result.Restored++
+	}
+	logger.Info("Restored backup", zap.Int("records", result.Records), zap.Int("restored", result.Restored), zap.Int("skipped", result.Skipped))
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
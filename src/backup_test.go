@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+var testBackupKey = bytes.Repeat([]byte{7}, 32)
+
+func backupFixture(t *testing.T, n int) (*storetest.Fake, []byte) {
+	t.Helper()
+	s := storetest.NewFake()
+	for i := range n {
+		// random-ish payloads so the archive spans several chunks even after gzip.
+		s.Put(context.Background(), store.Record{
+			ID:        fmt.Sprintf("receipt-%d", i),
+			Points:    int64(i),
+			Receipt:   json.RawMessage(fmt.Sprintf(`{"retailer":"%x"}`, noise(i))),
+			CreatedAt: time.Date(2022, 1, 1, 0, 0, i, 0, time.UTC),
+		})
+	}
+	var archive bytes.Buffer
+	count, err := writeBackup(context.Background(), &archive, s, testBackupKey)
+	if err != nil || count != n {
+		t.Fatalf("writeBackup() = %v, %v, want %v records", count, err, n)
+	}
+	return s, archive.Bytes()
+}
+
+func noise(i int) []byte {
+	b := make([]byte, 32)
+	for j := range b {
+		b[j] = byte(i*31 + j*17 + i*j)
+	}
+	return b
+}
+
+func TestBackupArchive(t *testing.T) {
+	const n = 3000
+	_, archive := backupFixture(t, n)
+	otherKey := bytes.Repeat([]byte{8}, 32)
+
+	testCases := []struct {
+		name    string
+		archive []byte
+		key     []byte
+		wantErr bool
+	}{
+		{name: "round trip", archive: archive, key: testBackupKey},
+		{name: "wrong key", archive: archive, key: otherKey, wantErr: true},
+		{name: "flipped bit", archive: flipBit(archive, len(archive)/2), key: testBackupKey, wantErr: true},
+		{name: "truncated", archive: archive[:len(archive)-10], key: testBackupKey, wantErr: true},
+		{name: "last chunk dropped", archive: dropLastChunk(t, archive), key: testBackupKey, wantErr: true},
+		{name: "trailing data", archive: append(append([]byte{}, archive...), 0), key: testBackupKey, wantErr: true},
+		{name: "not a backup", archive: []byte("hello"), key: testBackupKey, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := readBackup(bytes.NewReader(tc.archive), tc.key)
+			if tc.wantErr {
+				if !errors.Is(err, errBadBackup) {
+					t.Errorf("readBackup() error = %v, want %v", err, errBadBackup)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readBackup() error = %v", err)
+			}
+			if len(records) != n {
+				t.Errorf("readBackup() returned %d records, want %d", len(records), n)
+			}
+		})
+	}
+}
+
+func flipBit(b []byte, i int) []byte {
+	flipped := append([]byte{}, b...)
+	flipped[i] ^= 1
+	return flipped
+}
+
+// dropLastChunk cuts the archive after its second to last chunk, which still opens on its own.
+func dropLastChunk(t *testing.T, archive []byte) []byte {
+	t.Helper()
+	offset := len(backupMagic) + backupSaltSize
+	var ends []int
+	for offset < len(archive) {
+		size := int(archive[offset])<<24 | int(archive[offset+1])<<16 | int(archive[offset+2])<<8 | int(archive[offset+3])
+		offset += 4 + size
+		ends = append(ends, offset)
+	}
+	if len(ends) < 2 {
+		t.Fatalf("archive has %d chunks, the fixture needs at least 2", len(ends))
+	}
+	return archive[:ends[len(ends)-2]]
+}
+
+func TestBackupRestore(t *testing.T) {
+	router := setup()
+	source, _ := backupFixture(t, 5)
+
+	testCases := []struct {
+		name        string
+		key         string
+		restoreInto *storetest.Fake
+		wantStatus  int
+		wantResult  restoreResult
+	}{
+		{name: "not configured", wantStatus: http.StatusNotImplemented},
+		{name: "into empty store", key: base64.StdEncoding.EncodeToString(testBackupKey), restoreInto: storetest.NewFake(), wantStatus: http.StatusOK, wantResult: restoreResult{Records: 5, Restored: 5}},
+		{name: "into the same store", key: base64.StdEncoding.EncodeToString(testBackupKey), restoreInto: source, wantStatus: http.StatusOK, wantResult: restoreResult{Records: 5, Skipped: 5}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.Backup = BackupConfig{EncryptionKey: tc.key}
+			liveConfig.Store(&live)
+			receiptStore = source
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/backup", nil))
+			if rr.Code != http.StatusOK {
+				if rr.Code != tc.wantStatus {
+					t.Errorf("backup returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+				}
+				return
+			}
+			if !strings.HasPrefix(rr.Header().Get("Content-Disposition"), `attachment; filename="fcpc-`) {
+				t.Errorf("Content-Disposition = %q", rr.Header().Get("Content-Disposition"))
+			}
+
+			receiptStore = tc.restoreInto
+			restore := httptest.NewRecorder()
+			router.ServeHTTP(restore, adminRequest("POST", "/admin/restore", rr.Body))
+			if restore.Code != tc.wantStatus {
+				t.Fatalf("restore returned wrong status code: got %v want %v: %s", restore.Code, tc.wantStatus, restore.Body)
+			}
+			var got restoreResult
+			json.Unmarshal(restore.Body.Bytes(), &got)
+			if got != tc.wantResult {
+				t.Errorf("restore = %+v, want %+v", got, tc.wantResult)
+			}
+			if tc.restoreInto.Len() != 5 {
+				t.Errorf("store has %d records after restore, want 5", tc.restoreInto.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultPointsMaxAge = 365 * 24 * time.Hour
+
+// CachingConfig sets the Cache-Control of GET /receipts/{id}/points. The points of a receipt waiting for review can
+// still change and are never cached. The points of any other receipt are final and can be cached for MaxAge (a year
+// by default) by clients and CDNs, marked immutable unless Revalidate is set. Amending a receipt changes its points, so
+// deployments that amend receipts should set Revalidate and a shorter MaxAge.
+type CachingConfig struct {
+	MaxAge     Duration `json:"maxAge"`
+	Revalidate bool     `json:"revalidate"`
+}
+
+func (c CachingConfig) Validate() error {
+	if c.MaxAge < 0 {
+		return fmt.Errorf("caching: maxAge must not be negative")
+	}
+	return nil
+}
+
+func (c CachingConfig) maxAge() time.Duration {
+	if c.MaxAge == 0 {
+		return defaultPointsMaxAge
+	}
+	return time.Duration(c.MaxAge)
+}
+
+// pointsCacheControl is the Cache-Control of the points of a receipt, final or not.
+func (c CachingConfig) pointsCacheControl(final bool) string {
+	if !final {
+		return "no-store"
+	}
+	value := "public, max-age=" + strconv.Itoa(int(c.maxAge().Seconds()))
+	if !c.Revalidate {
+		value += ", immutable"
+	}
+	return value
+}
+
+// setPointsCacheControl sets the Cache-Control of a points response for the receipt. A receipt that isn't found may
+// just not be stored yet, e.g. while it is spooled, so only found receipts can be final.
+func setPointsCacheControl(w http.ResponseWriter, receiptID string, found bool) {
+	w.Header().Set("Cache-Control", currentConfig().Caching.pointsCacheControl(found && !audits.pending(receiptID)))
+}
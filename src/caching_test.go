@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestPointsCacheControl(t *testing.T) {
+	testCases := []struct {
+		name       string
+		caching    CachingConfig
+		held       bool
+		unknown    bool
+		wantStatus int
+		want       string
+	}{
+		{name: "final", wantStatus: http.StatusOK, want: "public, max-age=31536000, immutable"},
+		{name: "waiting for review", held: true, wantStatus: http.StatusOK, want: "no-store"},
+		{name: "revalidated", caching: CachingConfig{MaxAge: Duration(time.Hour), Revalidate: true}, wantStatus: http.StatusOK, want: "public, max-age=3600"},
+		{name: "not stored yet", unknown: true, wantStatus: http.StatusNotFound, want: "no-store"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := *currentConfig()
+			live.Caching = tc.caching
+			liveConfig.Store(&live)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if tc.held {
+				audits.hold(resp.ID, "", 10, "test")
+			}
+			if tc.unknown {
+				resp.ID = unknownReceiptID
+			}
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+resp.ID+"/points", nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("GET /receipts/%v/points = %v, want %v", resp.ID, rr.Code, tc.wantStatus)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tc.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
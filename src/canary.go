@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// CanaryConfig submits a known receipt through the HTTP pipeline every Interval, off unless set, and checks it earns
+// Points. Receipt defaults to the self-check's receipt, which earns 109 points under the default rules; with another
+// receipt, or rules that change its points, set Points.
+type CanaryConfig struct {
+	Interval Duration        `json:"interval"`
+	Receipt  json.RawMessage `json:"receipt"`
+	Points   int             `json:"points"`
+}
+
+func (c CanaryConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("canary: interval must not be negative")
+	}
+	if len(c.Receipt) > 0 {
+		var receipt Receipt
+		if err := json.Unmarshal(c.Receipt, &receipt); err != nil {
+			return fmt.Errorf("canary: receipt: %w", err)
+		}
+		if c.Points == 0 {
+			return fmt.Errorf("canary: points is required with a receipt")
+		}
+	}
+	return nil
+}
+
+// receipt returns the canary's receipt and the points it must earn.
+func (c CanaryConfig) receipt() ([]byte, int) {
+	if len(c.Receipt) == 0 {
+		return []byte(checkReceipt), cmp.Or(c.Points, checkPoints)
+	}
+	return c.Receipt, c.Points
+}
+
+var (
+	canaryUp = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_canary_up",
+		Help: "1 if the last canary receipt was processed, stored and read back with the expected points, 0 if not.",
+	})
+
+	canaryRunsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_canary_runs_total",
+		Help: "Canary runs, by result (success or failure).",
+	}, []string{"result"})
+
+	canaryLastSuccess = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_canary_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful canary run. Alert when this falls too far behind.",
+	})
+)
+
+type canaryContextKey struct{}
+
+// isCanary reports whether ctx is of a canary request. Requests from outside can't be marked.
+func isCanary(ctx context.Context) bool {
+	canary, _ := ctx.Value(canaryContextKey{}).(bool)
+	return canary
+}
+
+// canaryRequest builds a request of the canary to handler, answered in process. It stays on this shard and doesn't
+// need an API key.
+func canaryRequest(ctx context.Context, method, path string, body []byte) *http.Request {
+	req := httptest.NewRequestWithContext(context.WithValue(ctx, canaryContextKey{}, true), method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(shardHopHeader, "canary")
+	return req
+}
+
+// runCanary submits the canary receipt to handler with POST /receipts/process, checks the points it earned with
+// GET /receipts/{id}/points, and deletes the receipt again. Canary receipts are marked in the store,
+// so the stats leave them out even before they are deleted, and they skip fraud checks, review and the rule and
+// ingestion metrics.
+func runCanary(ctx context.Context, handler http.Handler, c CanaryConfig) error {
+	body, want := c.receipt()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, canaryRequest(ctx, "POST", "/receipts/process", body))
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("POST /receipts/process answered %d: %s", rr.Code, bytes.TrimSpace(rr.Body.Bytes()))
+	}
+	var processed struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &processed); err != nil {
+		return fmt.Errorf("POST /receipts/process: %w", err)
+	}
+	defer func() {
+		if err := receiptStore.Delete(ctx, processed.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			logger.Error("Failed to delete canary receipt", zap.String("receiptID", processed.ID), zap.Error(err))
+		}
+	}()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, canaryRequest(ctx, "GET", "/receipts/"+processed.ID+"/points", nil))
+	var stored struct {
+		Points int `json:"points"`
+	}
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &stored) != nil {
+		return fmt.Errorf("GET /receipts/%s/points answered %d: %s", processed.ID, rr.Code, bytes.TrimSpace(rr.Body.Bytes()))
+	}
+	if stored.Points != want {
+		return fmt.Errorf("the receipt earned %d points, want %d", stored.Points, want)
+	}
+	return nil
+}
+
+// startCanary runs the canary every interval against handler, with the live canary config, and reports the outcome
+// on /metrics.
+func startCanary(ctx context.Context, interval time.Duration, handler http.Handler) {
+	go runPeriodically(ctx, interval, func(ctx context.Context) {
+		if err := runCanary(ctx, handler, currentConfig().Canary); err != nil {
+			canaryUp.Set(0)
+			canaryRunsTotal.WithLabelValues("failure").Inc()
+			logger.Error("Canary failed", zap.Error(err))
+			return
+		}
+		canaryUp.Set(1)
+		canaryRunsTotal.WithLabelValues("success").Inc()
+		canaryLastSuccess.SetToCurrentTime()
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestCanary(t *testing.T) {
+	custom := receipttest.New().Retailer("Target").Build()
+
+	testCases := []struct {
+		name    string
+		canary  CanaryConfig
+		rules   RulesConfig
+		auth    AuthConfig
+		wantErr bool
+	}{
+		{name: "default receipt"},
+		{name: "custom receipt", canary: CanaryConfig{Receipt: custom.JSON(), Points: 12}},
+		{name: "wrong points", canary: CanaryConfig{Receipt: custom.JSON(), Points: 13}, wantErr: true},
+		{name: "rules changed", rules: RulesConfig{"retailerName": {Multiplier: 2}}, wantErr: true},
+		{name: "rules changed and points set", canary: CanaryConfig{Points: 123}, rules: RulesConfig{"retailerName": {Multiplier: 2}}},
+		{name: "API keys required", auth: AuthConfig{Required: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.Rules = tc.rules
+			live.Auth = tc.auth
+			liveConfig.Store(&live)
+			t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+			err := runCanary(context.Background(), router, tc.canary)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("runCanary() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if left, _ := receiptStore.List(context.Background(), store.ListOptions{}); len(left) != 0 {
+				t.Errorf("%d receipts left in the store, want the canary's deleted", len(left))
+			}
+		})
+	}
+}
+
+func TestCanaryLeftOutOfStats(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "", receipttest.New().PurchaseTime("10:00").Build())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, canaryRequest(context.Background(), "POST", "/receipts/process", []byte(checkReceipt)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/process for the canary = %v %s", rr.Code, rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/stats/hourly", nil))
+	var resp hourlyStatsResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Hours[10].Receipts != 1 || resp.Hours[14].Receipts != 0 {
+		t.Errorf("GET /stats/hourly = %v %s, want only the receipt at 10:00", rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.uber.org/zap"
+)
+
+// canonicalResponse is a receipt in canonical form and its fingerprint, the hex SHA-256 of the canonical receipt
+// marshalled as compact JSON. Receipts that only differ in formatting have the same fingerprint.
+type canonicalResponse struct {
+	Receipt     ReceiptDTO `json:"receipt"`
+	Fingerprint string     `json:"fingerprint"`
+}
+
+// canonicalReceipt normalizes everything about a receipt that doesn't change what was bought: the retailer as
+// normalizeRetailer has it, descriptions with single spaces, prices with two decimals, items ordered by description and
+// then price, and transaction numbers and postal codes without separators or lowercase letters.
+func canonicalReceipt(r Receipt) ReceiptDTO {
+	r.Items = slices.Clone(r.Items)
+	for i := range r.Items {
+		r.Items[i].ShortDescription = strings.Join(strings.Fields(r.Items[i].ShortDescription), " ")
+	}
+	slices.SortStableFunc(r.Items, func(a, b Item) int {
+		return cmp.Or(strings.Compare(a.ShortDescription, b.ShortDescription), cmp.Compare(a.Price, b.Price))
+	})
+
+	dto := r.ToDTO()
+	dto.Retailer = normalizeRetailer(dto.Retailer)
+	dto.TransactionNumber = normalizeTransactionNumber(dto.TransactionNumber)
+	if dto.StoreLocation != nil {
+		location := *dto.StoreLocation
+		location.PostalCode = normalizeTransactionNumber(location.PostalCode)
+		dto.StoreLocation = &location
+	}
+	return dto
+}
+
+// fingerprint is the hex SHA-256 of a canonical receipt.
+func fingerprint(dto ReceiptDTO) string {
+	b, _ := json.Marshal(dto)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalizeReceipt serves POST /receipts/canonicalize, so clients can compute the same fingerprints as the service
+// when deduplicating receipts. Invalid receipts get their errors as json, like /receipts/score. Nothing is stored.
+func canonicalizeReceipt(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	var response any
+
+	var receipt Receipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		var errs validation.Errors
+		if !errors.As(err, &errs) {
+			errs = validation.Errors{"body": err}
+		}
+		status = http.StatusBadRequest
+		response = map[string]validation.Errors{"errors": errs}
+	} else {
+		canonical := canonicalReceipt(receipt)
+		response = canonicalResponse{Receipt: canonical, Fingerprint: fingerprint(canonical)}
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
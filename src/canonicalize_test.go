@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestCanonicalReceipt(t *testing.T) {
+	base := receipttest.New().Retailer("M&M Corner Market").Item("Gatorade", "2.25").Item("Emils Cheese Pizza", "6.75").TransactionNumber("TX-1001")
+	testCases := []struct {
+		name string
+		b    receipttest.Receipt
+		same bool
+	}{
+		{name: "identical", b: base.Build(), same: true},
+		{
+			name: "formatting only",
+			b:    receipttest.New().Retailer("m & m  Corner-Market").Item("Emils  Cheese Pizza ", "6.75").Item("Gatorade", "2.25").TransactionNumber("tx1001").Build(),
+			same: true,
+		},
+		{name: "another item", b: receipttest.New().Retailer("M&M Corner Market").Item("Gatorade", "2.25").Item("Emils Cheese Pizza", "6.76").TransactionNumber("TX-1001").Build()},
+		{name: "another retailer", b: receipttest.New().Retailer("Target").Item("Gatorade", "2.25").Item("Emils Cheese Pizza", "6.75").TransactionNumber("TX-1001").Build()},
+	}
+
+	var a Receipt
+	if err := json.Unmarshal(base.Build().JSON(), &a); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := fingerprint(canonicalReceipt(a))
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b Receipt
+			if err := json.Unmarshal(tc.b.JSON(), &b); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := fingerprint(canonicalReceipt(b)); (got == want) != tc.same {
+				t.Errorf("fingerprint() = %v, want same as %v: %v", got, want, tc.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ChaosConfig injects latency and errors so client teams can exercise their retry logic in staging. It must never be
+// enabled in production. Routes are keyed by their path template (e.g. "/receipts/{id}/points"), "*" matches any
+// route without its own entry.
+type ChaosConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Routes  map[string]ChaosRule `json:"routes"`
+}
+
+type ChaosRule struct {
+	// Latency is always added, plus a random amount up to Jitter.
+	Latency Duration `json:"latency"`
+	Jitter  Duration `json:"jitter"`
+	// ErrorRate is the fraction (0-1) of requests answered with ErrorStatus (default 503) instead of being handled.
+	ErrorRate   float64 `json:"errorRate"`
+	ErrorStatus int     `json:"errorStatus"`
+}
+
+func (c ChaosConfig) ruleFor(r *http.Request) (ChaosRule, bool) {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			if rule, ok := c.Routes[tmpl]; ok {
+				return rule, true
+			}
+		}
+	}
+	rule, ok := c.Routes["*"]
+	return rule, ok
+}
+
+// chaosMiddleware reads the live config on every request, so a reload can switch it off without a restart. Injected
+// responses carry X-Chaos-Injected so nobody mistakes them for real failures.
+func chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chaos := currentConfig().Chaos
+		if !chaos.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rule, ok := chaos.ruleFor(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := time.Duration(rule.Latency)
+		if rule.Jitter > 0 {
+			delay += rand.N(time.Duration(rule.Jitter))
+		}
+		if delay > 0 {
+			w.Header().Set("X-Chaos-Injected", "latency="+delay.String())
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if rule.ErrorRate > 0 && rand.Float64() < rule.ErrorRate {
+			status := rule.ErrorStatus
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			logger.Debug("Injecting chaos error", zap.String("path", r.URL.Path), zap.Int("status", status))
+			w.Header().Set("X-Chaos-Injected", "error="+strconv.Itoa(status))
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChaosMiddleware(t *testing.T) {
+	testCases := []struct {
+		name        string
+		chaos       ChaosConfig
+		path        string
+		wantStatus  int
+		wantMinTime time.Duration
+	}{
+		{
+			name:       "disabled",
+			chaos:      ChaosConfig{Enabled: false, Routes: map[string]ChaosRule{"*": {ErrorRate: 1}}},
+			path:       "/receipts/" + unknownReceiptID + "/points",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "route rule fails every request",
+			chaos:      ChaosConfig{Enabled: true, Routes: map[string]ChaosRule{"/receipts/{id}/points": {ErrorRate: 1, ErrorStatus: 500}}},
+			path:       "/receipts/" + unknownReceiptID + "/points",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "wildcard with default status",
+			chaos:      ChaosConfig{Enabled: true, Routes: map[string]ChaosRule{"*": {ErrorRate: 1}}},
+			path:       "/receipts/" + unknownReceiptID + "/points",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "route rule wins over wildcard",
+			chaos:      ChaosConfig{Enabled: true, Routes: map[string]ChaosRule{"*": {ErrorRate: 1}, "/receipts/{id}/points": {}}},
+			path:       "/receipts/" + unknownReceiptID + "/points",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:        "latency only",
+			chaos:       ChaosConfig{Enabled: true, Routes: map[string]ChaosRule{"*": {Latency: Duration(20 * time.Millisecond)}}},
+			path:        "/receipts/" + unknownReceiptID + "/points",
+			wantStatus:  http.StatusNotFound,
+			wantMinTime: 20 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.Chaos = tc.chaos
+			liveConfig.Store(&live)
+
+			start := time.Now()
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if elapsed := time.Since(start); elapsed < tc.wantMinTime {
+				t.Errorf("request took %v, want at least %v", elapsed, tc.wantMinTime)
+			}
+			if tc.chaos.Enabled && tc.wantStatus != http.StatusNotFound && rr.Header().Get("X-Chaos-Injected") == "" {
+				t.Errorf("injected response is missing X-Chaos-Injected")
+			}
+		})
+	}
+}
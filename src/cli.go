@@ -0,0 +1,519 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.uber.org/zap"
+)
+
+// command is a subcommand of the fcpc binary. run gets the arguments after the command's name and its own flag set,
+// which already has -config.
+type command struct {
+	summary string
+	run     func(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"serve":    {summary: "run the HTTP server (the default)", run: serveCommand},
+	"score":    {summary: "score receipt JSON files, or stdin, without storing them", run: scoreCommand},
+	"migrate":  {summary: "apply, revert or list the migrations of the postgres schema", run: migrateCommand},
+	"export":   {summary: "write every stored receipt as JSON lines", run: exportCommand},
+	"store":    {summary: "copy every receipt to another store backend", run: storeCommand},
+	"check":    {summary: "check the config, store and scoring, then exit non-zero on failure", run: checkCommand},
+	"backfill": {summary: "submit a directory of historical receipts, rate limited and resumable", run: backfillCommand},
+	"rules":    {summary: "run the rule tests of the config and of test files against its rules", run: rulesCommand},
+}
+
+var commandOrder = []string{"serve", "score", "migrate", "export", "store", "check", "backfill", "rules"}
+
+// errUsage is returned for bad arguments; the flag set has printed what is wrong already.
+var errUsage = errors.New("usage")
+
+// runCLI runs the subcommand named by args[0] and returns the exit code. Without a subcommand it serves, and the
+// --check flag of earlier versions still runs check.
+func runCLI(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && (args[0] == "-check" || args[0] == "--check") {
+		name, args = "check", args[1:]
+	} else if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+		printUsage(stderr)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("fcpc "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.String("config", os.Getenv("CONFIG_FILE"), "path of the JSON config file (default $CONFIG_FILE)")
+	err := cmd.run(ctx, flags, args, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: fcpc <command> [flags]")
+	fmt.Fprintln(w)
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "fcpc <command> -h" for the flags of a command.`)
+}
+
+// parseFlags parses args into flags and returns the config path.
+func parseFlags(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", err
+		}
+		return "", errUsage
+	}
+	return flags.Lookup("config").Value.String(), nil
+}
+
+// openStore loads the config at path and opens its store, for the commands that work on stored receipts.
+func openStore(ctx context.Context, path string) (store.Store, error) {
+	c, err := loadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	s, err := store.Open(ctx, c.Store.Backend, c.Store.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	return s, nil
+}
+
+func serveCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	router := setupFrom(path)
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go reloadOnSIGHUP(ctx, path)
+	if err := startReplication(ctx, cfg.Replication); err != nil {
+		logger.Fatal("Failed to start replication", zap.Error(err))
+	}
+	// a standby only writes what the primary did, anything it ingested or deleted on its own would never reach the
+	// primary.
+	standby := cfg.Replication.Role == replicationStandby
+	if !standby {
+		if cfg.Ingest.Dir != "" {
+			go watchDir(ctx, cfg.Ingest)
+		}
+		startConnectors(ctx, cfg.Connectors)
+		startErasures(ctx)
+		startHoldSettlement(ctx)
+		startRetention(ctx, time.Duration(cfg.Retention.Interval))
+		if cfg.Canary.Interval > 0 {
+			startCanary(ctx, time.Duration(cfg.Canary.Interval), router)
+		}
+		startSpoolDrainer(ctx, time.Duration(cfg.Spool.DrainInterval))
+	}
+	startReconciler(ctx)
+	startAggregatesRepair(ctx, time.Duration(cfg.Aggregates.RepairInterval))
+	startUsageSummaries(ctx)
+	startAPIKeyRefresh(ctx)
+	if cfg.Store.CompactInterval > 0 {
+		startCompaction(ctx, time.Duration(cfg.Store.CompactInterval))
+	}
+	if cfg.Notifications.Type != "" {
+		if err := startNotifications(ctx, cfg.Notifications); err != nil {
+			logger.Fatal("Failed to start notifications", zap.Error(err))
+		}
+		startExpiryWarnings(ctx, cfg.Notifications)
+	}
+	if cfg.IMAP.Address != "" && !standby {
+		go startIMAPIngester(ctx, cfg.IMAP)
+	}
+	if cfg.S3Ingest.QueueURL != "" && !standby {
+		if err := startS3Ingester(ctx, cfg.S3Ingest); err != nil {
+			logger.Fatal("Failed to start S3 ingester", zap.Error(err))
+		}
+	}
+
+	if cfg.Consumer.Type != "" && !standby {
+		if err := startConsumer(ctx, cfg.Consumer); err != nil {
+			logger.Fatal("Failed to start consumer", zap.Error(err))
+		}
+		if cfg.Consumer.DisableHTTP {
+			<-ctx.Done()
+			return nil
+		}
+	}
+
+	ln, where, err := listen(ctx, cfg.Server)
+	if err != nil {
+		logger.Fatal("Failed to listen", zap.Error(err))
+	}
+	logger.Info("Starting server on " + where)
+	if err := serveUntil(ctx, cfg.Server, newServer(cfg.Server, router), ln); err != nil {
+		logger.Error("Server stopped", zap.Error(err))
+	}
+	return nil
+}
+
+// scoredFile is a line of score's output.
+type scoredFile struct {
+	File      string            `json:"file"`
+	Points    int               `json:"points"`
+	Breakdown []RuleResult      `json:"breakdown,omitempty"`
+	Errors    validation.Errors `json:"errors,omitempty"`
+}
+
+// scoreCommand scores every file given, or stdin without any, with the rules of the config. Invalid receipts are
+// reported with what is wrong with them and make the command fail once every file is scored.
+func scoreCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	breakdown := flags.Bool("breakdown", false, "include the points of every rule")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	enc := json.NewEncoder(stdout)
+	invalid := 0
+	for _, file := range files {
+		data, err := readInput(file)
+		if err != nil {
+			return err
+		}
+		line := scoredFile{File: file}
+		var receipt Receipt
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			if !errors.As(err, &line.Errors) {
+				line.Errors = validation.Errors{"body": err}
+			}
+			invalid++
+		} else {
+			results := receipt.Score(c.Rules)
+			line.Points = totalPoints(results)
+			if *breakdown {
+				line.Breakdown = results
+			}
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d receipts are invalid", invalid, len(files))
+	}
+	return nil
+}
+
+// rulesCommand runs the config's ruleTests and those in the files given, JSON arrays of the same tests, against the
+// config's rules. Loading the config already fails on its own tests, the files are for tests kept out of it.
+func rulesCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: fcpc rules test [flags] [tests.json ...]")
+		flags.PrintDefaults()
+	}
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	action := flags.Arg(0)
+	// flags may come after the action too: rules test -config prod.json.
+	if flags.NArg() > 0 {
+		if path, err = parseFlags(flags, flags.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if action != "test" {
+		flags.Usage()
+		return errUsage
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	tests := c.RuleTests
+	for _, file := range flags.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var fileTests []RuleTest
+		if err := json.Unmarshal(data, &fileTests); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		tests = append(tests, fileTests...)
+	}
+
+	failures := runRuleTests(c, tests)
+	for _, failure := range failures {
+		fmt.Fprintln(stdout, failure)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d rule tests failed", len(failures), len(tests))
+	}
+	fmt.Fprintf(stdout, "%d rule tests passed\n", len(tests))
+	return nil
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
+
+// migrateCommand manages the schema of the postgres backend: up applies the pending migrations, down reverts the
+// last -steps, status lists them. Replicas apply pending migrations when they start too, migrate up lets a deploy do
+// it first. Migrations hold a lock, so running it while replicas start is safe.
+func migrateCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	steps := flags.Int("steps", 1, "how many migrations down reverts")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: fcpc migrate up|down|status [flags]")
+		flags.PrintDefaults()
+	}
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	action := flags.Arg(0)
+	// flags may come after the action too: migrate down -steps 2.
+	if flags.NArg() > 0 {
+		if _, err := parseFlags(flags, flags.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if flags.NArg() != 0 || (action != "up" && action != "down" && action != "status") {
+		flags.Usage()
+		return errUsage
+	}
+	if *steps < 1 {
+		return errors.New("-steps must be at least 1")
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if c.Store.Backend != "postgres" {
+		fmt.Fprintf(stdout, "the %s backend has no schema to migrate\n", cmp.Or(c.Store.Backend, "memory"))
+		return nil
+	}
+	p, err := store.ConnectPostgres(ctx, c.Store.DSN)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	defer p.Close()
+
+	switch action {
+	case "up":
+		done, err := p.MigrateUp(ctx)
+		for _, m := range done {
+			fmt.Fprintf(stdout, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(stdout, "schema is up to date")
+		}
+		return err
+	case "down":
+		done, err := p.MigrateDown(ctx, *steps)
+		for _, m := range done {
+			fmt.Fprintf(stdout, "reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(stdout, "no migration to revert")
+		}
+		return err
+	default:
+		status, err := p.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(stdout, "%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+}
+
+// exportCommand writes every stored receipt, as stored, one JSON object per line. Unlike /admin/backup the output is
+// neither compressed nor encrypted; it is meant for other tools. With -anonymize it is safe to hand to people who may
+// not see receipts, see anonymizer; -sample keeps a share of the receipts, the same one on every run with the same
+// key.
+func exportCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	output := flags.String("o", "-", "file to write to, - for stdout")
+	anonymize := flags.Bool("anonymize", false, "hash retailers, descriptions and IDs and jitter purchase times")
+	sampleRate := flags.Float64("sample", 1, "share of the receipts to export, between 0 and 1")
+	jitter := flags.Duration("jitter", 48*time.Hour, "how far -anonymize moves purchase times, at most")
+	key := flags.String("key", os.Getenv("EXPORT_KEY"), "secret for hashing and sampling (default $EXPORT_KEY, random if unset)")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		return errors.New("-sample must be above 0 and at most 1")
+	}
+	if *jitter < 0 {
+		return errors.New("-jitter must not be negative")
+	}
+	a := anonymizer{key: []byte(*key), sampleRate: *sampleRate, jitter: *jitter}
+	if *key == "" {
+		// hashes and the sample then differ between runs, which is fine for a one-off export.
+		a.key = make([]byte, 32)
+		rand.Read(a.key)
+	}
+	s, err := openStore(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if *output == "-" {
+		return exportRecords(ctx, s, stdout, a, *anonymize)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := exportRecords(ctx, s, f, a, *anonymize); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func exportRecords(ctx context.Context, s store.Store, w io.Writer, a anonymizer, anonymize bool) error {
+	enc := json.NewEncoder(w)
+	return s.Scan(ctx, func(rec store.Record) error {
+		if !a.sampled(rec.ID) {
+			return nil
+		}
+		if !anonymize {
+			return enc.Encode(rec)
+		}
+		anonymized, err := a.anonymize(rec)
+		if err != nil {
+			return fmt.Errorf("anonymizing receipt %s: %w", rec.ID, err)
+		}
+		return enc.Encode(anonymized)
+	})
+}
+
+// storeCommand copies the receipts, and API keys, of one backend to another, to move to a new backend without
+// downtime: copy while the old one still serves, switch the config over, then copy again for what came in between.
+// The source is the configured store unless -from says otherwise.
+func storeCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	from := flags.String("from", "", "backend to copy from (default the configured one)")
+	fromDSN := flags.String("from-dsn", "", "connection string of -from")
+	to := flags.String("to", "", "backend to copy to")
+	toDSN := flags.String("to-dsn", "", "connection string of -to")
+	every := flags.Duration("progress", 5*time.Second, "how often to report progress")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: fcpc store copy -to=postgres -to-dsn=... [flags]")
+		flags.PrintDefaults()
+	}
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	action := flags.Arg(0)
+	if flags.NArg() > 0 {
+		if _, err := parseFlags(flags, flags.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if flags.NArg() != 0 || action != "copy" || *to == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if *from == "" {
+		*from, *fromDSN = c.Store.Backend, c.Store.DSN
+	}
+	for _, backend := range []string{*from, *to} {
+		if backend == "" || backend == "memory" {
+			return errors.New("the memory backend doesn't outlive the process, there is nothing to copy from or to")
+		}
+	}
+	if *from == *to && *fromDSN == *toDSN {
+		return errors.New("-from and -to are the same store")
+	}
+
+	source, err := store.Open(ctx, *from, *fromDSN)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *from, err)
+	}
+	defer source.Close()
+	destination, err := store.Open(ctx, *to, *toDSN)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *to, err)
+	}
+	defer destination.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := func(p store.CopyProgress) {
+		rate := float64(p.Copied+p.Skipped) / max(p.Elapsed.Seconds(), 0.001)
+		fmt.Fprintf(stdout, "copied %d, skipped %d already there, %.0f receipts/s\n", p.Copied, p.Skipped, rate)
+	}
+	p, err := store.Copy(ctx, source, destination, *every, report)
+	report(p)
+	if err != nil {
+		return fmt.Errorf("copy stopped, run it again to resume: %w", err)
+	}
+	fmt.Fprintf(stdout, "done in %s, %d API keys copied\n", p.Elapsed.Round(time.Millisecond), p.Keys)
+	return nil
+}
+
+func checkCommand(ctx context.Context, flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := selfCheck(ctx, path); err != nil {
+		return fmt.Errorf("self-check failed: %w", err)
+	}
+	fmt.Fprintln(stdout, "self-check passed")
+	return nil
+}
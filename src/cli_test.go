@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCLI(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	config := write("config.json", `{}`)
+	valid := write("valid.json", checkReceipt)
+	invalid := write("invalid.json", `{"retailer": "Target"}`)
+	t.Setenv("CONFIG_FILE", config)
+
+	testCases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "score", args: []string{"score", valid}, wantCode: 0, wantStdout: `"points":109`},
+		{name: "score with breakdown", args: []string{"score", "-breakdown", valid}, wantCode: 0, wantStdout: `"breakdown":[`},
+		{name: "score invalid receipt", args: []string{"score", valid, invalid}, wantCode: 1, wantStdout: `"errors":{`, wantStderr: "1 of 2 receipts are invalid"},
+		{name: "score missing file", args: []string{"score", filepath.Join(dir, "missing.json")}, wantCode: 1, wantStderr: "no such file"},
+		{name: "check", args: []string{"check"}, wantCode: 0, wantStdout: "self-check passed"},
+		{name: "legacy check flag", args: []string{"--check"}, wantCode: 0, wantStdout: "self-check passed"},
+		{name: "check with other config", args: []string{"check", "-config", write("bad.json", `{`)}, wantCode: 1, wantStderr: "self-check failed"},
+		{name: "migrate without schema", args: []string{"migrate", "up"}, wantCode: 0, wantStdout: "memory backend has no schema"},
+		{name: "migrate without action", args: []string{"migrate"}, wantCode: 2, wantStderr: "usage: fcpc migrate up|down|status"},
+		{name: "migrate with flags after the action", args: []string{"migrate", "down", "-steps", "2"}, wantCode: 0, wantStdout: "memory backend has no schema"},
+		{name: "migrate with invalid steps", args: []string{"migrate", "down", "-steps", "0"}, wantCode: 1, wantStderr: "-steps must be at least 1"},
+		{name: "migrate unknown action", args: []string{"migrate", "sideways"}, wantCode: 2, wantStderr: "usage: fcpc migrate up|down|status"},
+		{name: "export", args: []string{"export"}, wantCode: 0},
+		{name: "store copy without destination", args: []string{"store", "copy"}, wantCode: 2, wantStderr: "usage: fcpc store copy"},
+		{name: "store copy from memory", args: []string{"store", "copy", "-to", "redis"}, wantCode: 1, wantStderr: "nothing to copy"},
+		{name: "store copy to itself", args: []string{"store", "copy", "-from", "redis", "-from-dsn", "redis://a", "-to", "redis", "-to-dsn", "redis://a"}, wantCode: 1, wantStderr: "same store"},
+		{name: "backfill without dir", args: []string{"backfill"}, wantCode: 2, wantStderr: "usage: fcpc backfill"},
+		{name: "backfill with invalid rate", args: []string{"backfill", "-dir", dir, "-rate", "fast"}, wantCode: 1, wantStderr: "-rate must be"},
+		{name: "rules test", args: []string{"rules", "test", write("passing.json", `[{"name": "challenge", "receipt": `+checkReceipt+`, "points": 109}]`)}, wantCode: 0, wantStdout: "1 rule tests passed"},
+		{name: "rules test failing", args: []string{"rules", "test", write("failing.json", `[{"name": "challenge", "receipt": `+checkReceipt+`, "points": 1}]`)}, wantCode: 1, wantStdout: "challenge: awarded 109 points, want 1", wantStderr: "1 of 1 rule tests failed"},
+		{name: "rules without action", args: []string{"rules"}, wantCode: 2, wantStderr: "usage: fcpc rules test"},
+		{name: "help", args: []string{"export", "-h"}, wantCode: 0, wantStderr: "-config"},
+		{name: "unknown flag", args: []string{"score", "-nope"}, wantCode: 2, wantStderr: "flag provided but not defined"},
+		{name: "unknown command", args: []string{"frobnicate"}, wantCode: 2, wantStderr: "usage: fcpc <command>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runCLI(context.Background(), tc.args, &stdout, &stderr)
+			if code != tc.wantCode {
+				t.Errorf("exit code = %d, want %d (stderr: %s)", code, tc.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tc.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout.String(), tc.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tc.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tc.wantStderr)
+			}
+		})
+	}
+}
+
+func TestExportCommandWritesFile(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	output := filepath.Join(t.TempDir(), "receipts.jsonl")
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI(context.Background(), []string{"export", "-o", output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr.String())
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("export didn't write %s: %v", output, err)
+	}
+}
+
+func TestBackfillCommand(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "corpus")
+	os.MkdirAll(filepath.Join(corpus, "2021"), 0o755)
+	os.WriteFile(filepath.Join(corpus, "a.json"), []byte(checkReceipt), 0o644)
+	os.WriteFile(filepath.Join(corpus, "2021", "b.jsonl"), []byte(strings.Join([]string{
+		strings.Join(strings.Fields(checkReceipt), " "),
+		"",
+		`{"retailer": "Target"}`,
+		strings.Join(strings.Fields(checkReceipt), " "),
+	}, "\n")), 0o644)
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	args := []string{"backfill", "-dir", corpus, "-rate", "1000/s", "-checkpoint", checkpoint}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "submitted 3 receipts earning 327 points, 1 failed\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("stdout = %q, want it to contain %q", stdout.String(), want)
+	}
+
+	// everything was submitted, running it again resumes at the end.
+	stdout.Reset()
+	if code := runCLI(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "1 failed, 4 of them in earlier runs\n0 receipts"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("stdout = %q, want it to contain %q", stdout.String(), want)
+	}
+}
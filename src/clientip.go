@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIPConfig lists the TrustedProxies, IP addresses or CIDR ranges, whose X-Forwarded-For and X-Real-IP headers
+// are believed. Requests from anywhere else, and all requests without it, are taken to come from their peer address.
+type ClientIPConfig struct {
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+func (c ClientIPConfig) Validate() error {
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			return fmt.Errorf("clientIp: trustedProxies: %q is neither an IP address nor a CIDR range", proxy)
+		}
+	}
+	return nil
+}
+
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		return netip.ParsePrefix(proxy)
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (c ClientIPConfig) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the address r came from. X-Forwarded-For is read from the right, skipping trusted proxies, so the
+// first address a trusted proxy didn't add is the client's; a client can put anything to the left of it. X-Real-IP is
+// only read without X-Forwarded-For. A peer on a Unix socket is a local proxy and trusted.
+func (c ClientIPConfig) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err == nil && !c.trusted(peer) {
+		return peer.Unmap().String()
+	}
+
+	client := host
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = hop.Unmap().String()
+			if !c.trusted(hop) {
+				break
+			}
+		}
+	} else if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		client = real.Unmap().String()
+	}
+	return client
+}
+
+type clientIPContextKey struct{}
+
+// clientIPFrom returns the address of the client behind ctx, empty for receipts that didn't come in over HTTP.
+func clientIPFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey{}).(string)
+	return ip
+}
+
+// clientIPMiddleware resolves the client's address once for the rest of the request, so nothing needs to look at
+// RemoteAddr.
+func clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := currentConfig().ClientIP.clientIP(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, ip)))
+	})
+}
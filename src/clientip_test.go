@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}}
+
+	testCases := []struct {
+		name       string
+		config     ClientIPConfig
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:51234", want: "203.0.113.7"},
+		{name: "headers from an untrusted peer", remoteAddr: "203.0.113.7:51234", header: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, want: "203.0.113.7"},
+		{name: "no proxies configured", config: ClientIPConfig{}, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "10.0.0.5"},
+		{name: "trusted proxy", config: trusted, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", config: trusted, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.1.2.3"}, want: "198.51.100.1"},
+		{name: "spoofed hop left of the client", config: trusted, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage hop", config: trusted, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Forwarded-For": "unknown, 10.1.2.3"}, want: "10.1.2.3"},
+		{name: "X-Real-IP", config: trusted, remoteAddr: "10.0.0.5:80", header: map[string]string{"X-Real-IP": "198.51.100.2"}, want: "198.51.100.2"},
+		{name: "IPv6 proxy", config: trusted, remoteAddr: "[2001:db8::1]:443", header: map[string]string{"X-Forwarded-For": "2001:db8::42"}, want: "2001:db8::42"},
+		{name: "unix socket", remoteAddr: "@", header: map[string]string{"X-Real-IP": "198.51.100.2"}, want: "198.51.100.2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/receipts", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.header {
+				r.Header.Set(name, value)
+			}
+			if got := tc.config.clientIP(r); got != tc.want {
+				t.Errorf("clientIP() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientIPConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ClientIPConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "addresses and ranges", config: ClientIPConfig{TrustedProxies: []string{"10.0.0.1", "172.16.0.0/12", "::1"}}},
+		{name: "hostname", config: ClientIPConfig{TrustedProxies: []string{"proxy.internal"}}, wantErr: true},
+		{name: "bad range", config: ClientIPConfig{TrustedProxies: []string{"10.0.0.0/33"}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientIPSentToFraudProvider(t *testing.T) {
+	router := setup()
+	var got FraudScoreRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]float64{"score": 0})
+	}))
+	defer server.Close()
+	live := cfg
+	live.Fraud = FraudConfig{Provider: FraudProviderConfig{Type: "http", URL: server.URL, Action: fraudDecisionLog, Threshold: 0.8}}
+	live.ClientIP = ClientIPConfig{TrustedProxies: []string{"192.0.2.0/24"}}
+	liveConfig.Store(&live)
+
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON()))
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/process = %v %s, want 200", rr.Code, rr.Body)
+	}
+	if got.ClientIP != "198.51.100.1" {
+		t.Errorf("fraud provider got clientIp %q, want the forwarded address", got.ClientIP)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const specPath = "../../../api.yml"
+
+func TestClientsUpToDate(t *testing.T) {
+	out := t.TempDir()
+	if err := run(specPath, out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	for name := range outputs {
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join("../../../clients", name))
+		if err != nil {
+			t.Fatalf("missing generated client, run go generate ./cmd/gen: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("clients/%v is stale, run go generate ./cmd/gen", name)
+		}
+	}
+}
+
+func TestBuildAPI(t *testing.T) {
+	s, err := loadSpec(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := buildAPI(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validated := map[string]bool{}
+	for _, typ := range a.Types {
+		validated[typ.Name] = typ.Validate
+	}
+	// request bodies (and what they reference) are validated, response types aren't.
+	for name, want := range map[string]bool{"Receipt": true, "Item": true, "StoredReceipt": false, "GetPointsResponse": false} {
+		got, ok := validated[name]
+		if !ok {
+			t.Errorf("type %v was not generated", name)
+		} else if got != want {
+			t.Errorf("type %v Validate = %v, want %v", name, got, want)
+		}
+	}
+
+	for _, o := range a.Operations {
+		if o.ID == "" || o.Method == "" {
+			t.Errorf("operation %+v is missing its ID or method", o)
+		}
+	}
+}
+
+func TestCaseConversion(t *testing.T) {
+	testCases := []struct {
+		in, wantExported, wantSnake string
+	}{
+		{in: "processReceipt", wantExported: "ProcessReceipt", wantSnake: "process_receipt"},
+		{in: "receipts", wantExported: "Receipts", wantSnake: "receipts"},
+		{in: "StoredReceipt", wantExported: "StoredReceipt", wantSnake: "stored_receipt"},
+		{in: "X-Account-ID", wantExported: "X-Account-ID", wantSnake: "x_account_id"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := exported(tc.in); got != tc.wantExported {
+				t.Errorf("exported(%q) = %q, want %q", tc.in, got, tc.wantExported)
+			}
+			if got := snake(tc.in); got != tc.wantSnake {
+				t.Errorf("snake(%q) = %q, want %q", tc.in, got, tc.wantSnake)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Command gen writes the TypeScript and Python API clients from api.yml, including client-side validation that
+// mirrors the server's, so partners don't have to hand-write (and get subtly wrong) request code. Run it after any
+// change to api.yml:
+//
+//	go generate ./cmd/gen
+//
+// The generated files are committed; TestClientsUpToDate fails when they are stale.
+package main
+
+//go:generate go run . -spec ../../../api.yml -out ../../../clients
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// outputs maps every generated file (relative to -out) to its emitter.
+var outputs = map[string]func(*api) []byte{
+	filepath.Join("typescript", "fcpc.ts"): generateTypeScript,
+	filepath.Join("python", "fcpc.py"):     generatePython,
+}
+
+func main() {
+	specPath := flag.String("spec", "api.yml", "OpenAPI spec to generate the clients from")
+	outDir := flag.String("out", "clients", "directory the clients are written to")
+	flag.Parse()
+
+	if err := run(*specPath, *outDir); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(specPath, outDir string) error {
+	s, err := loadSpec(specPath)
+	if err != nil {
+		return err
+	}
+	a, err := buildAPI(s)
+	if err != nil {
+		return err
+	}
+
+	for name, generate := range outputs {
+		path := filepath.Join(outDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, generate(a), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
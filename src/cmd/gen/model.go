@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// api is the spec reduced to what the emitters need: named types in spec order and the operations.
+type api struct {
+	Types      []*typeDef
+	Operations []*op
+}
+
+// typeDef is an object schema that gets its own type. Validate is set for types reachable from a request body, only
+// those get client-side validation.
+type typeDef struct {
+	Name     string
+	Schema   *schema
+	Validate bool
+}
+
+type op struct {
+	ID           string
+	Method       string
+	Path         string
+	Summary      string
+	PathParams   []*parameter
+	QueryParams  []*parameter
+	HeaderParams []*parameter
+	// Body and Response are nil when the operation takes no body or returns no JSON.
+	Body     *schema
+	Response *schema
+}
+
+func buildAPI(s *spec) (*api, error) {
+	a := &api{}
+	byName := map[string]*typeDef{}
+	add := addTypeFunc(a, byName)
+	addType := func(name string, sc *schema) {
+		add(name, sc)
+		a.nameInline(name, sc, add)
+	}
+
+	for _, name := range s.Components.Schemas.Keys {
+		addType(name, s.Components.Schemas.Values[name])
+	}
+
+	for _, path := range s.Paths.Keys {
+		methods := s.Paths.Values[path]
+		for _, method := range methods.Keys {
+			o := methods.Values[method]
+			if o.OperationID == "" {
+				return nil, fmt.Errorf("%v %v: operationId is required to name the client method", strings.ToUpper(method), path)
+			}
+			res := &op{ID: o.OperationID, Method: strings.ToUpper(method), Path: path, Summary: o.Summary}
+			for _, p := range o.Parameters {
+				switch p.In {
+				case "path":
+					res.PathParams = append(res.PathParams, p)
+				case "query":
+					res.QueryParams = append(res.QueryParams, p)
+				case "header":
+					res.HeaderParams = append(res.HeaderParams, p)
+				default:
+					return nil, fmt.Errorf("%v: %v parameters are not supported", o.OperationID, p.In)
+				}
+			}
+			if o.RequestBody != nil {
+				if c, ok := o.RequestBody.Content["application/json"]; ok {
+					res.Body = c.Schema
+				}
+			}
+			for _, code := range o.Responses.Keys {
+				if !strings.HasPrefix(code, "2") {
+					continue
+				}
+				if c, ok := o.Responses.Values[code].Content["application/json"]; ok {
+					res.Response = c.Schema
+					if c.Schema.Ref == "" && len(c.Schema.Properties.Keys) > 0 {
+						addType(exported(o.OperationID)+"Response", c.Schema)
+					}
+				}
+				break
+			}
+			a.Operations = append(a.Operations, res)
+		}
+	}
+
+	if err := resolveRefs(a, byName); err != nil {
+		return nil, err
+	}
+	for _, o := range a.Operations {
+		if o.Body != nil {
+			markValidated(o.Body, byName)
+		}
+	}
+	return a, nil
+}
+
+func addTypeFunc(a *api, byName map[string]*typeDef) func(string, *schema) {
+	return func(name string, sc *schema) {
+		sc.name = name
+		t := &typeDef{Name: name, Schema: sc}
+		a.Types = append(a.Types, t)
+		byName[name] = t
+	}
+}
+
+// nameInline gives inline objects with properties a type of their own, named after where they appear.
+func (a *api) nameInline(parent string, sc *schema, add func(string, *schema)) {
+	for _, prop := range sc.Properties.Keys {
+		p := sc.Properties.Values[prop]
+		target, name := p, parent+exported(prop)
+		if p.Type == "array" && p.Items != nil {
+			target, name = p.Items, name+"Item"
+		}
+		if target.Ref == "" && len(target.Properties.Keys) > 0 {
+			add(name, target)
+			a.nameInline(name, target, add)
+		}
+	}
+}
+
+func resolveRefs(a *api, byName map[string]*typeDef) error {
+	var walk func(sc *schema) error
+	walk = func(sc *schema) error {
+		if sc == nil {
+			return nil
+		}
+		if strings.Contains(sc.Pattern, `"`) {
+			return fmt.Errorf("pattern %v: quotes in patterns are not supported", sc.Pattern)
+		}
+		if sc.Ref != "" {
+			if _, ok := byName[sc.refName()]; !ok {
+				return fmt.Errorf("unknown schema %v", sc.Ref)
+			}
+			sc.name = sc.refName()
+		}
+		for _, prop := range sc.Properties.Keys {
+			if err := walk(sc.Properties.Values[prop]); err != nil {
+				return err
+			}
+		}
+		if err := walk(sc.AdditionalProperties.Values); err != nil {
+			return err
+		}
+		return walk(sc.Items)
+	}
+
+	for _, t := range a.Types {
+		if err := walk(t.Schema); err != nil {
+			return err
+		}
+	}
+	for _, o := range a.Operations {
+		for _, p := range append(append(o.PathParams, o.QueryParams...), o.HeaderParams...) {
+			if err := walk(p.Schema); err != nil {
+				return err
+			}
+		}
+		if err := walk(o.Body); err != nil {
+			return err
+		}
+		if err := walk(o.Response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func markValidated(sc *schema, byName map[string]*typeDef) {
+	if sc == nil {
+		return
+	}
+	if sc.name != "" {
+		t := byName[sc.name]
+		if t.Validate {
+			return
+		}
+		t.Validate = true
+		sc = t.Schema
+	}
+	for _, prop := range sc.Properties.Keys {
+		markValidated(sc.Properties.Values[prop], byName)
+	}
+	markValidated(sc.Items, byName)
+	markValidated(sc.AdditionalProperties.Values, byName)
+}
+
+// exported turns "receipts" or "processReceipt" into "Receipts" / "ProcessReceipt".
+func exported(s string) string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return s
+	}
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// snake turns "processReceipt" into "process_receipt" and "X-Account-ID" into "x_account_id".
+func snake(s string) string {
+	var b strings.Builder
+	runes := []rune(strings.ReplaceAll(s, "-", "_"))
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			// a word starts here, or this is the last letter of an acronym followed by a word ("URLRequest").
+			endOfAcronym := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(runes[i-1]) || endOfAcronym {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
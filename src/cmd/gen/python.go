@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var pyKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true, "await": true,
+	"break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true, "else": true, "except": true,
+	"finally": true, "for": true, "from": true, "global": true, "if": true, "import": true, "in": true, "is": true,
+	"lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true, "raise": true, "return": true, "try": true,
+	"while": true, "with": true, "yield": true,
+}
+
+func pyType(sc *schema) string {
+	if sc.name != "" {
+		return sc.name
+	}
+	switch sc.Type {
+	case "string":
+		return "str"
+	case "integer":
+		return "int"
+	case "number":
+		return "float"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "list[" + pyType(sc.Items) + "]"
+	case "":
+		return "Any"
+	}
+	if values := sc.AdditionalProperties.Values; values != nil {
+		return "dict[str, " + pyType(values) + "]"
+	}
+	return "dict[str, Any]"
+}
+
+// pyDict builds the dict passing params by their wire names.
+func pyDict(params []*parameter) string {
+	var fields []string
+	for _, p := range params {
+		fields = append(fields, fmt.Sprintf("%q: %s", p.Name, pyParam(p.Name)))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// pyParam is the argument name of a parameter, with a trailing underscore for names that are keywords ("from_").
+func pyParam(name string) string {
+	if name := snake(name); pyKeywords[name] {
+		return name + "_"
+	}
+	return snake(name)
+}
+
+func pyBound(v *float64) string {
+	if v == nil {
+		return "None"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// pyChecks emits the statements validating expr (a value of schema sc) and appending messages to errors.
+func pyChecks(b *bytes.Buffer, indent string, sc *schema, expr, path string, depth int) {
+	switch {
+	case sc.name != "":
+		fmt.Fprintf(b, "%serrors.extend(validate_%s(%s, %s))\n", indent, snake(sc.name), expr, path)
+	case sc.Type == "string":
+		pattern, format := "None", "None"
+		if sc.Pattern != "" {
+			// a raw string keeps the pattern exactly as written in the spec, patterns with quotes are rejected by
+			// buildAPI.
+			pattern = `re.compile(r"` + sc.Pattern + `", re.ASCII)`
+		}
+		if sc.Format != "" {
+			format = strconv.Quote(sc.Format)
+		}
+		fmt.Fprintf(b, "%s_check_string(%s, %s, errors, %s, %s)\n", indent, expr, path, pattern, format)
+	case sc.Type == "integer" || sc.Type == "number":
+		integer := "False"
+		if sc.Type == "integer" {
+			integer = "True"
+		}
+		fmt.Fprintf(b, "%s_check_number(%s, %s, errors, %s, %s, %s)\n", indent, expr, path, integer, pyBound(sc.Minimum), pyBound(sc.Maximum))
+	case sc.Type == "boolean":
+		fmt.Fprintf(b, "%s_check_bool(%s, %s, errors)\n", indent, expr, path)
+	case sc.Type == "array":
+		minItems := 0
+		if sc.MinItems != nil {
+			minItems = *sc.MinItems
+		}
+		item, i := fmt.Sprintf("item%d", depth), fmt.Sprintf("i%d", depth)
+		fmt.Fprintf(b, "%sif _check_array(%s, %s, errors, %d):\n", indent, expr, path, minItems)
+		fmt.Fprintf(b, "%s    for %s, %s in enumerate(%s):\n", indent, i, item, expr)
+		pyChecks(b, indent+"        ", sc.Items, item, fmt.Sprintf("_at(%s, str(%s))", path, i), depth+1)
+	case sc.Type == "object" && sc.AdditionalProperties.Values != nil:
+		key, item := fmt.Sprintf("key%d", depth), fmt.Sprintf("item%d", depth)
+		fmt.Fprintf(b, "%sif _check_object(%s, %s, errors):\n", indent, expr, path)
+		fmt.Fprintf(b, "%s    for %s, %s in %s.items():\n", indent, key, item, expr)
+		pyChecks(b, indent+"        ", sc.AdditionalProperties.Values, item, fmt.Sprintf("_at(%s, %s)", path, key), depth+1)
+	default:
+		// an untyped schema, or an object without a type of its own, takes any value.
+		fmt.Fprintf(b, "%spass\n", indent)
+	}
+}
+
+const pyRuntime = `
+
+class ApiError(Exception):
+    def __init__(self, status: int, body: str) -> None:
+        super().__init__(f"request failed with status {status}: {body}")
+        self.status = status
+        self.body = body
+
+
+class ValidationError(Exception):
+    """Raised before sending a request the API would reject anyway."""
+
+    def __init__(self, errors: list[str]) -> None:
+        super().__init__("; ".join(errors))
+        self.errors = errors
+
+
+def _at(path: str, field: str) -> str:
+    return f"{path}.{field}" if path else field
+
+
+def _check_string(value: Any, path: str, errors: list[str], pattern: Optional[re.Pattern[str]], fmt: Optional[str]) -> None:
+    if not isinstance(value, str):
+        errors.append(f"{path}: must be a string")
+    elif pattern is not None and not pattern.fullmatch(value):
+        errors.append(f"{path}: must match {pattern.pattern}")
+    elif fmt == "date" and not _parses(value, "%Y-%m-%d", r"\d{4}-\d{2}-\d{2}"):
+        errors.append(f"{path}: want YYYY-MM-DD format")
+    elif fmt == "time" and not _parses(value, "%H:%M", r"\d{2}:\d{2}"):
+        errors.append(f"{path}: want HH:MM format")
+
+
+def _parses(value: str, layout: str, shape: str) -> bool:
+    if not re.fullmatch(shape, value, re.ASCII):
+        return False
+    try:
+        datetime.datetime.strptime(value, layout)
+    except ValueError:
+        return False
+    return True
+
+
+def _check_number(value: Any, path: str, errors: list[str], integer: bool, minimum: Optional[float], maximum: Optional[float]) -> None:
+    if isinstance(value, bool) or not isinstance(value, int if integer else (int, float)):
+        errors.append(f"{path}: must be {'an integer' if integer else 'a number'}")
+    elif minimum is not None and value < minimum:
+        errors.append(f"{path}: must be at least {minimum:g}")
+    elif maximum is not None and value > maximum:
+        errors.append(f"{path}: must be at most {maximum:g}")
+
+
+def _check_bool(value: Any, path: str, errors: list[str]) -> None:
+    if not isinstance(value, bool):
+        errors.append(f"{path}: must be a boolean")
+
+
+def _check_array(value: Any, path: str, errors: list[str], min_items: int) -> bool:
+    if not isinstance(value, list):
+        errors.append(f"{path}: must be an array")
+        return False
+    if len(value) < min_items:
+        errors.append(f"{path}: must contain at least {min_items} item(s)")
+    return True
+
+
+def _check_object(value: Any, path: str, errors: list[str]) -> bool:
+    if not isinstance(value, dict):
+        errors.append(f"{path}: must be an object")
+        return False
+    return True
+`
+
+const pyClient = `
+
+class Client:
+    def __init__(self, base_url: str, timeout: float = 10.0) -> None:
+        """base_url is e.g. "https://receipts.example.com", without a trailing path."""
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+
+    def _request(
+        self,
+        method: str,
+        path: str,
+        query: Optional[dict[str, Any]] = None,
+        body: Any = None,
+        headers: Optional[dict[str, Optional[str]]] = None,
+    ) -> Any:
+        url = self.base_url + path
+        params = {k: v for k, v in (query or {}).items() if v is not None}
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+
+        data, sent = None, {k: v for k, v in (headers or {}).items() if v is not None}
+        if body is not None:
+            data = json.dumps(body).encode()
+            sent["Content-Type"] = "application/json"
+
+        req = urllib.request.Request(url, data=data, headers=sent, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                text = resp.read().decode()
+        except urllib.error.HTTPError as e:
+            raise ApiError(e.code, e.read().decode()) from None
+        return json.loads(text) if text else None
+`
+
+func generatePython(a *api) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Code generated by src/cmd/gen from api.yml. DO NOT EDIT.\n")
+	b.WriteString("# Needs Python 3.11 or later and nothing outside the standard library.\n\n")
+	b.WriteString("from __future__ import annotations\n\n")
+	b.WriteString("import datetime\nimport json\nimport re\nimport urllib.error\nimport urllib.parse\nimport urllib.request\n")
+	b.WriteString("from typing import Any, NotRequired, Optional, TypedDict\n")
+
+	for _, t := range a.Types {
+		// fields named like keywords ("from") only work with the functional TypedDict syntax.
+		functional := false
+		for _, prop := range t.Schema.Properties.Keys {
+			functional = functional || pyKeywords[prop]
+		}
+		if functional {
+			if t.Schema.Description != "" {
+				fmt.Fprintf(&b, "\n\n# %s\n", t.Schema.Description)
+			} else {
+				b.WriteString("\n\n")
+			}
+			fmt.Fprintf(&b, "%s = TypedDict(%q, {\n", t.Name, t.Name)
+		} else {
+			fmt.Fprintf(&b, "\n\nclass %s(TypedDict):\n", t.Name)
+			if t.Schema.Description != "" {
+				fmt.Fprintf(&b, "    \"\"\"%s\"\"\"\n\n", t.Schema.Description)
+			}
+		}
+		for _, prop := range t.Schema.Properties.Keys {
+			p := t.Schema.Properties.Values[prop]
+			typ := pyType(p)
+			if !t.Schema.isRequired(prop) {
+				typ = "NotRequired[" + typ + "]"
+			}
+			if p.Description != "" {
+				fmt.Fprintf(&b, "    # %s\n", p.Description)
+			}
+			if functional {
+				fmt.Fprintf(&b, "    %q: %s,\n", prop, typ)
+			} else {
+				fmt.Fprintf(&b, "    %s: %s\n", prop, typ)
+			}
+		}
+		if functional {
+			b.WriteString("})\n")
+		}
+	}
+
+	b.WriteString(pyRuntime)
+
+	for _, t := range a.Types {
+		if !t.Validate {
+			continue
+		}
+		fmt.Fprintf(&b, "\n\ndef validate_%s(value: Any, path: str = \"\") -> list[str]:\n", snake(t.Name))
+		fmt.Fprintf(&b, "    \"\"\"Checks a %s against api.yml, returning one message per problem.\"\"\"\n", t.Name)
+		b.WriteString("    if not isinstance(value, dict):\n")
+		b.WriteString("        return [f\"{path or 'value'}: must be an object\"]\n")
+		b.WriteString("    errors: list[str] = []\n")
+		for _, prop := range t.Schema.Properties.Keys {
+			p := t.Schema.Properties.Values[prop]
+			expr, path := fmt.Sprintf("value[%q]", prop), fmt.Sprintf("_at(path, '%s')", prop)
+			if t.Schema.isRequired(prop) {
+				fmt.Fprintf(&b, "    if value.get(%q) is None:\n", prop)
+				fmt.Fprintf(&b, "        errors.append(f\"{%s}: is required\")\n", path)
+				b.WriteString("    else:\n")
+			} else {
+				fmt.Fprintf(&b, "    if value.get(%q) is not None:\n", prop)
+			}
+			pyChecks(&b, "        ", p, expr, path, 0)
+		}
+		b.WriteString("    return errors\n")
+	}
+
+	b.WriteString(pyClient)
+	for _, o := range a.Operations {
+		args := []string{"self"}
+		for _, p := range o.PathParams {
+			args = append(args, fmt.Sprintf("%s: %s", p.Name, pyType(p.Schema)))
+		}
+		if o.Body != nil {
+			args = append(args, "body: "+pyType(o.Body))
+		}
+		if len(o.QueryParams)+len(o.HeaderParams) > 0 {
+			args = append(args, "*")
+			for _, p := range append(o.QueryParams, o.HeaderParams...) {
+				if p.Required {
+					args = append(args, fmt.Sprintf("%s: %s", pyParam(p.Name), pyType(p.Schema)))
+				} else {
+					args = append(args, fmt.Sprintf("%s: Optional[%s] = None", pyParam(p.Name), pyType(p.Schema)))
+				}
+			}
+		}
+		result := "None"
+		if o.Response != nil {
+			result = pyType(o.Response)
+		}
+
+		path := o.Path
+		for _, p := range o.PathParams {
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", "{urllib.parse.quote("+p.Name+", safe='')}")
+		}
+		query, body, headers := "None", "None", "None"
+		if len(o.QueryParams) > 0 {
+			query = pyDict(o.QueryParams)
+		}
+		if len(o.HeaderParams) > 0 {
+			headers = pyDict(o.HeaderParams)
+		}
+		if o.Body != nil {
+			body = "body"
+		}
+
+		fmt.Fprintf(&b, "\n    def %s(%s) -> %s:\n", snake(o.ID), strings.Join(args, ", "), result)
+		if o.Summary != "" {
+			fmt.Fprintf(&b, "        \"\"\"%s\"\"\"\n", o.Summary)
+		}
+		if o.Body != nil && o.Body.name != "" {
+			fmt.Fprintf(&b, "        errors = validate_%s(body)\n", snake(o.Body.name))
+			b.WriteString("        if errors:\n")
+			b.WriteString("            raise ValidationError(errors)\n")
+		}
+		fmt.Fprintf(&b, "        return self._request(%q, f\"%s\", %s, %s, %s)\n", o.Method, path, query, body, headers)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// spec is the subset of OpenAPI 3 that api.yml uses. Anything else is ignored rather than guessed at.
+type spec struct {
+	Paths      orderedMap[orderedMap[*operation]] `yaml:"paths"`
+	Components struct {
+		Schemas orderedMap[*schema] `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type operation struct {
+	OperationID string       `yaml:"operationId"`
+	Summary     string       `yaml:"summary"`
+	Description string       `yaml:"description"`
+	Parameters  []*parameter `yaml:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *schema `yaml:"schema"`
+		} `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses orderedMap[*response] `yaml:"responses"`
+}
+
+type parameter struct {
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Required    bool    `yaml:"required"`
+	Description string  `yaml:"description"`
+	Schema      *schema `yaml:"schema"`
+}
+
+type response struct {
+	Description string `yaml:"description"`
+	Content     map[string]struct {
+		Schema *schema `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+type schema struct {
+	Ref                  string               `yaml:"$ref"`
+	Type                 string               `yaml:"type"`
+	Format               string               `yaml:"format"`
+	Pattern              string               `yaml:"pattern"`
+	Description          string               `yaml:"description"`
+	Required             []string             `yaml:"required"`
+	Properties           orderedMap[*schema]  `yaml:"properties"`
+	Items                *schema              `yaml:"items"`
+	MinItems             *int                 `yaml:"minItems"`
+	Minimum              *float64             `yaml:"minimum"`
+	Maximum              *float64             `yaml:"maximum"`
+	AdditionalProperties additionalProperties `yaml:"additionalProperties"`
+
+	// name is set for $refs and for inline objects that get their own type.
+	name string
+}
+
+// additionalProperties is true, false or, for maps, the schema of their values. Only the schema matters to the
+// emitters, objects without one take any keys.
+type additionalProperties struct {
+	Values *schema
+}
+
+func (a *additionalProperties) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	a.Values = &schema{}
+	return n.Decode(a.Values)
+}
+
+// refName returns "Receipt" for "#/components/schemas/Receipt".
+func (s *schema) refName() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+func (s *schema) isRequired(prop string) bool {
+	for _, r := range s.Required {
+		if r == prop {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedMap keeps the order keys appear in the spec, so the generated code reads in the same order as api.yml.
+type orderedMap[T any] struct {
+	Keys   []string
+	Values map[string]T
+}
+
+func (m *orderedMap[T]) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", n.Line)
+	}
+	m.Values = map[string]T{}
+	for i := 0; i < len(n.Content); i += 2 {
+		var v T
+		if err := n.Content[i+1].Decode(&v); err != nil {
+			return err
+		}
+		key := n.Content[i].Value
+		m.Keys = append(m.Keys, key)
+		m.Values[key] = v
+	}
+	return nil
+}
+
+func loadSpec(path string) (*spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", path, err)
+	}
+	return &s, nil
+}
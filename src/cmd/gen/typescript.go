@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tsDefault lets callers leave out a parameter object whose fields are all optional.
+func tsDefault(params []*parameter) string {
+	for _, p := range params {
+		if p.Required {
+			return ""
+		}
+	}
+	return " = {}"
+}
+
+func tsType(sc *schema) string {
+	if sc.name != "" {
+		return sc.name
+	}
+	switch sc.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return tsType(sc.Items) + "[]"
+	case "":
+		return "unknown"
+	}
+	if values := sc.AdditionalProperties.Values; values != nil {
+		return "Record<string, " + tsType(values) + ">"
+	}
+	return "Record<string, unknown>"
+}
+
+func tsComment(b *bytes.Buffer, indent, text string) {
+	if text != "" {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, text)
+	}
+}
+
+// tsChecks emits the statements validating expr (a value of schema sc) and pushing messages onto errors.
+func tsChecks(b *bytes.Buffer, indent string, sc *schema, expr, path string, depth int) {
+	switch {
+	case sc.name != "":
+		fmt.Fprintf(b, "%serrors.push(...validate%s(%s, %s));\n", indent, sc.name, expr, path)
+	case sc.Type == "string":
+		pattern, format := "undefined", "undefined"
+		if sc.Pattern != "" {
+			pattern = "/" + strings.ReplaceAll(sc.Pattern, "/", `\/`) + "/"
+		}
+		if sc.Format != "" {
+			format = strconv.Quote(sc.Format)
+		}
+		fmt.Fprintf(b, "%scheckString(%s, %s, errors, %s, %s);\n", indent, expr, path, pattern, format)
+	case sc.Type == "integer" || sc.Type == "number":
+		fmt.Fprintf(b, "%scheckNumber(%s, %s, errors, %v, %s, %s);\n", indent, expr, path, sc.Type == "integer", tsBound(sc.Minimum), tsBound(sc.Maximum))
+	case sc.Type == "boolean":
+		fmt.Fprintf(b, "%scheckBoolean(%s, %s, errors);\n", indent, expr, path)
+	case sc.Type == "array":
+		minItems := 0
+		if sc.MinItems != nil {
+			minItems = *sc.MinItems
+		}
+		item, i := fmt.Sprintf("item%d", depth), fmt.Sprintf("i%d", depth)
+		fmt.Fprintf(b, "%sif (checkArray(%s, %s, errors, %d)) {\n", indent, expr, path, minItems)
+		fmt.Fprintf(b, "%s    (%s as unknown[]).forEach((%s, %s) => {\n", indent, expr, item, i)
+		tsChecks(b, indent+"        ", sc.Items, fmt.Sprintf("%s as %s", item, tsType(sc.Items)), fmt.Sprintf("at(%s, String(%s))", path, i), depth+1)
+		fmt.Fprintf(b, "%s    });\n", indent)
+		fmt.Fprintf(b, "%s}\n", indent)
+	case sc.Type == "object" && sc.AdditionalProperties.Values != nil:
+		key, item := fmt.Sprintf("key%d", depth), fmt.Sprintf("item%d", depth)
+		values := sc.AdditionalProperties.Values
+		fmt.Fprintf(b, "%sif (checkObject(%s, %s, errors)) {\n", indent, expr, path)
+		fmt.Fprintf(b, "%s    Object.entries(%s as Record<string, unknown>).forEach(([%s, %s]) => {\n", indent, expr, key, item)
+		tsChecks(b, indent+"        ", values, fmt.Sprintf("%s as %s", item, tsType(values)), fmt.Sprintf("at(%s, %s)", path, key), depth+1)
+		fmt.Fprintf(b, "%s    });\n", indent)
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+}
+
+func tsBound(v *float64) string {
+	if v == nil {
+		return "undefined"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+const tsRuntime = `export class ApiError extends Error {
+    constructor(public readonly status: number, public readonly body: string) {
+        super(` + "`request failed with status ${status}: ${body}`" + `);
+    }
+}
+
+/** Thrown before sending a request the API would reject anyway. */
+export class ValidationError extends Error {
+    constructor(public readonly errors: string[]) {
+        super(errors.join("; "));
+    }
+}
+
+function at(path: string, field: string): string {
+    return path ? ` + "`${path}.${field}`" + ` : field;
+}
+
+function isValidDate(value: string): boolean {
+    if (!/^\d{4}-\d{2}-\d{2}$/.test(value)) {
+        return false;
+    }
+    const d = new Date(value + "T00:00:00Z");
+    return !isNaN(d.getTime()) && d.toISOString().startsWith(value);
+}
+
+function checkString(value: unknown, path: string, errors: string[], pattern?: RegExp, format?: string): void {
+    if (typeof value !== "string") {
+        errors.push(` + "`${path}: must be a string`" + `);
+    } else if (pattern && !pattern.test(value)) {
+        errors.push(` + "`${path}: must match ${pattern.source}`" + `);
+    } else if (format === "date" && !isValidDate(value)) {
+        errors.push(` + "`${path}: want YYYY-MM-DD format`" + `);
+    } else if (format === "time" && !/^([01]\d|2[0-3]):[0-5]\d$/.test(value)) {
+        errors.push(` + "`${path}: want HH:MM format`" + `);
+    }
+}
+
+function checkNumber(value: unknown, path: string, errors: string[], integer: boolean, min?: number, max?: number): void {
+    if (typeof value !== "number" || (integer && !Number.isInteger(value))) {
+        errors.push(` + "`${path}: must be ${integer ? \"an integer\" : \"a number\"}`" + `);
+    } else if (min !== undefined && value < min) {
+        errors.push(` + "`${path}: must be at least ${min}`" + `);
+    } else if (max !== undefined && value > max) {
+        errors.push(` + "`${path}: must be at most ${max}`" + `);
+    }
+}
+
+function checkBoolean(value: unknown, path: string, errors: string[]): void {
+    if (typeof value !== "boolean") {
+        errors.push(` + "`${path}: must be a boolean`" + `);
+    }
+}
+
+function checkArray(value: unknown, path: string, errors: string[], minItems: number): boolean {
+    if (!Array.isArray(value)) {
+        errors.push(` + "`${path}: must be an array`" + `);
+        return false;
+    }
+    if (value.length < minItems) {
+        errors.push(` + "`${path}: must contain at least ${minItems} item(s)`" + `);
+    }
+    return true;
+}
+
+function checkObject(value: unknown, path: string, errors: string[]): boolean {
+    if (typeof value !== "object" || value === null || Array.isArray(value)) {
+        errors.push(` + "`${path}: must be an object`" + `);
+        return false;
+    }
+    return true;
+}
+`
+
+const tsClient = `
+export class Client {
+    /**
+     * @param baseUrl e.g. "https://receipts.example.com", without a trailing path.
+     * @param fetchImpl defaults to the global fetch.
+     */
+    constructor(private readonly baseUrl: string, private readonly fetchImpl: typeof fetch = fetch) {}
+
+    private async request<T>(
+        method: string,
+        path: string,
+        query?: Record<string, unknown>,
+        body?: unknown,
+        headers?: Record<string, string | undefined>,
+    ): Promise<T> {
+        let url = this.baseUrl.replace(/\/+$/, "") + path;
+        const params = new URLSearchParams();
+        for (const [key, value] of Object.entries(query ?? {})) {
+            if (value !== undefined && value !== null) {
+                params.set(key, String(value));
+            }
+        }
+        if (params.toString()) {
+            url += "?" + params.toString();
+        }
+
+        const sent: Record<string, string> = body === undefined ? {} : {"Content-Type": "application/json"};
+        for (const [key, value] of Object.entries(headers ?? {})) {
+            if (value !== undefined) {
+                sent[key] = value;
+            }
+        }
+
+        const resp = await this.fetchImpl(url, {
+            method,
+            headers: sent,
+            body: body === undefined ? undefined : JSON.stringify(body),
+        });
+        const text = await resp.text();
+        if (!resp.ok) {
+            throw new ApiError(resp.status, text);
+        }
+        return (text ? JSON.parse(text) : undefined) as T;
+    }
+`
+
+func generateTypeScript(a *api) []byte {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by src/cmd/gen from api.yml. DO NOT EDIT.\n\n")
+
+	for _, t := range a.Types {
+		tsComment(&b, "", t.Schema.Description)
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name)
+		for _, prop := range t.Schema.Properties.Keys {
+			p := t.Schema.Properties.Values[prop]
+			tsComment(&b, "    ", p.Description)
+			optional := "?"
+			if t.Schema.isRequired(prop) {
+				optional = ""
+			}
+			fmt.Fprintf(&b, "    %s%s: %s;\n", prop, optional, tsType(p))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(tsRuntime)
+
+	for _, t := range a.Types {
+		if !t.Validate {
+			continue
+		}
+		fmt.Fprintf(&b, "\n/** Checks a %s against api.yml, returning one message per problem. */\n", t.Name)
+		fmt.Fprintf(&b, "export function validate%s(value: %s, path = \"\"): string[] {\n", t.Name, t.Name)
+		b.WriteString("    const errors: string[] = [];\n")
+		b.WriteString("    if (typeof value !== \"object\" || value === null) {\n")
+		b.WriteString("        return [`${path || \"value\"}: must be an object`];\n")
+		b.WriteString("    }\n")
+		for _, prop := range t.Schema.Properties.Keys {
+			p := t.Schema.Properties.Values[prop]
+			expr, path := "value."+prop, fmt.Sprintf("at(path, %q)", prop)
+			if t.Schema.isRequired(prop) {
+				fmt.Fprintf(&b, "    if (%s === undefined || %s === null) {\n", expr, expr)
+				fmt.Fprintf(&b, "        errors.push(`${%s}: is required`);\n", path)
+				b.WriteString("    } else {\n")
+			} else {
+				fmt.Fprintf(&b, "    if (%s !== undefined && %s !== null) {\n", expr, expr)
+			}
+			tsChecks(&b, "        ", p, expr, path, 0)
+			b.WriteString("    }\n")
+		}
+		b.WriteString("    return errors;\n")
+		b.WriteString("}\n")
+	}
+
+	b.WriteString(tsClient)
+	for _, o := range a.Operations {
+		var args []string
+		for _, p := range o.PathParams {
+			args = append(args, fmt.Sprintf("%s: %s", p.Name, tsType(p.Schema)))
+		}
+		if o.Body != nil {
+			args = append(args, "body: "+tsType(o.Body))
+		}
+		if len(o.QueryParams) > 0 {
+			var fields []string
+			for _, p := range o.QueryParams {
+				optional := "?"
+				if p.Required {
+					optional = ""
+				}
+				fields = append(fields, fmt.Sprintf("%s%s: %s", p.Name, optional, tsType(p.Schema)))
+			}
+			args = append(args, fmt.Sprintf("query: {%s}%s", strings.Join(fields, "; "), tsDefault(o.QueryParams)))
+		}
+		if len(o.HeaderParams) > 0 {
+			var fields []string
+			for _, p := range o.HeaderParams {
+				optional := "?"
+				if p.Required {
+					optional = ""
+				}
+				fields = append(fields, fmt.Sprintf("%q%s: %s", p.Name, optional, tsType(p.Schema)))
+			}
+			args = append(args, fmt.Sprintf("headers: {%s}%s", strings.Join(fields, "; "), tsDefault(o.HeaderParams)))
+		}
+		result := "void"
+		if o.Response != nil {
+			result = tsType(o.Response)
+		}
+
+		path := o.Path
+		for _, p := range o.PathParams {
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", "${encodeURIComponent("+p.Name+")}")
+		}
+		query, body, headers := "undefined", "undefined", "undefined"
+		if len(o.QueryParams) > 0 {
+			query = "query"
+		}
+		if len(o.HeaderParams) > 0 {
+			headers = "headers"
+		}
+		if o.Body != nil {
+			body = "body"
+		}
+
+		b.WriteString("\n")
+		tsComment(&b, "    ", o.Summary)
+		fmt.Fprintf(&b, "    async %s(%s): Promise<%s> {\n", o.ID, strings.Join(args, ", "), result)
+		if o.Body != nil && o.Body.name != "" {
+			fmt.Fprintf(&b, "        const errors = validate%s(body);\n", o.Body.name)
+			b.WriteString("        if (errors.length > 0) {\n")
+			b.WriteString("            throw new ValidationError(errors);\n")
+			b.WriteString("        }\n")
+		}
+		fmt.Fprintf(&b, "        return this.request<%s>(%q, `%s`, %s, %s, %s);\n", result, o.Method, path, query, body, headers)
+		b.WriteString("    }\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
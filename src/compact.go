@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var errCompactionUnsupported = errors.New("the store backend doesn't support compaction")
+
+var storeReclaimedBytesTotal = metrics.NewCounter(prometheus.CounterOpts{
+	Name: "fcpc_store_reclaimed_bytes_total",
+	Help: "Bytes given back by store compactions, as the backend measures its own size.",
+})
+
+// compactStore compacts the receipt store if its backend keeps garbage around, errCompactionUnsupported otherwise.
+func compactStore(ctx context.Context) (store.CompactResult, error) {
+	compacter, ok := store.Unwrap(receiptStore).(store.Compacter)
+	if !ok {
+		return store.CompactResult{}, errCompactionUnsupported
+	}
+
+	start := time.Now()
+	result, err := compacter.Compact(ctx)
+	if err != nil {
+		return store.CompactResult{}, err
+	}
+	storeReclaimedBytesTotal.Add(float64(result.Reclaimed))
+	logger.Info("Compacted store", zap.Int64("sizeBefore", result.SizeBefore), zap.Int64("sizeAfter", result.SizeAfter),
+		zap.Int64("reclaimed", result.Reclaimed), zap.Duration("took", time.Since(start)))
+	return result, nil
+}
+
+// startCompaction compacts the store every interval. Backends without compaction are reported once and skipped.
+func startCompaction(ctx context.Context, interval time.Duration) {
+	if _, ok := store.Unwrap(receiptStore).(store.Compacter); !ok {
+		logger.Warn("store.compactInterval is set but the store backend doesn't support compaction", zap.String("backend", cfg.Store.Backend))
+		return
+	}
+	// the first run waits for an interval so restarts don't all compact at once.
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+			runPeriodically(ctx, interval, func(ctx context.Context) {
+				if _, err := compactStore(ctx); err != nil {
+					logger.Error("Failed to compact store", zap.Error(err))
+				}
+			})
+		}
+	}()
+}
+
+// compactHandler serves POST /admin/compact.
+func compactHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := compactStore(r.Context())
+	if errors.Is(err, errCompactionUnsupported) {
+		http.Error(w, "The store backend has nothing to compact.", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to compact store", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+// compactingStore is a store whose Compact returns result or err.
+type compactingStore struct {
+	*storetest.Fake
+	result store.CompactResult
+	err    error
+}
+
+func (s *compactingStore) Compact(ctx context.Context) (store.CompactResult, error) {
+	return s.result, s.err
+}
+
+func TestCompactHandler(t *testing.T) {
+	router := setup()
+
+	testCases := []struct {
+		name       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "unsupported", store: storetest.NewFake(), wantStatus: http.StatusNotImplemented, wantBody: "The store backend has nothing to compact.\n"},
+		{
+			name:       "compacted",
+			store:      &compactingStore{Fake: storetest.NewFake(), result: store.CompactResult{SizeBefore: 4096, SizeAfter: 1024, Reclaimed: 3072}},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"sizeBefore":4096,"sizeAfter":1024,"reclaimed":3072}`,
+		},
+		{name: "failed", store: &compactingStore{Fake: storetest.NewFake(), err: errors.New("disk full")}, wantStatus: http.StatusInternalServerError, wantBody: "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receiptStore = tc.store
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/compact", nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if rr.Body.String() != tc.wantBody {
+				t.Errorf("handler returned unexpected body: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.uber.org/zap"
+)
+
+// loadReceipt returns a stored receipt along with its record, store.ErrNotFound if there is none.
+func loadReceipt(ctx context.Context, id string) (Receipt, store.Record, error) {
+	rec, err := receiptStore.Get(ctx, id)
+	if err != nil {
+		return Receipt{}, store.Record{}, err
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(rec.Receipt, &receipt); err != nil {
+		return Receipt{}, store.Record{}, fmt.Errorf("decoding stored receipt %v: %w", id, err)
+	}
+	return receipt, rec, nil
+}
+
+// compareRequest holds the two receipts to compare, each either a receipt payload or the ID of a stored receipt.
+type compareRequest struct {
+	A json.RawMessage `json:"a"`
+	B json.RawMessage `json:"b"`
+}
+
+// comparedReceipt is one side of a comparison, scored with the current rules. StoredPoints are the points a stored
+// receipt was actually awarded, which differ from Points once the rules changed.
+type comparedReceipt struct {
+	ID           string       `json:"id,omitempty"`
+	StoredPoints *int64       `json:"storedPoints,omitempty"`
+	Points       int          `json:"points"`
+	Breakdown    []RuleResult `json:"breakdown"`
+}
+
+// RuleDelta is how much more (or less) b earned than a under one rule.
+type RuleDelta struct {
+	Rule  string `json:"rule"`
+	A     int    `json:"a"`
+	B     int    `json:"b"`
+	Delta int    `json:"delta"`
+}
+
+// ruleDeltas compares two breakdowns rule by rule. They are scored under the same rules, so list the same ones.
+func ruleDeltas(a, b []RuleResult) []RuleDelta {
+	deltas := make([]RuleDelta, 0, len(a))
+	for i := range a {
+		deltas = append(deltas, RuleDelta{Rule: a[i].Rule, A: a[i].Points, B: b[i].Points, Delta: b[i].Points - a[i].Points})
+	}
+	return deltas
+}
+
+type compareResponse struct {
+	A     comparedReceipt `json:"a"`
+	B     comparedReceipt `json:"b"`
+	Delta int             `json:"delta"`
+	Rules []RuleDelta     `json:"rules"`
+}
+
+// compareReceipts serves POST /receipts/compare, for QA when rules change and for partners debugging why two
+// receipts scored differently. Nothing is stored.
+func compareReceipts(w http.ResponseWriter, r *http.Request) {
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request must be a JSON object with the receipts to compare under a and b.", http.StatusBadRequest)
+		return
+	}
+
+	var response compareResponse
+	errs := validation.Errors{}
+	for _, side := range []struct {
+		name     string
+		raw      json.RawMessage
+		compared *comparedReceipt
+	}{{"a", req.A, &response.A}, {"b", req.B, &response.B}} {
+		raw := bytes.TrimSpace(side.raw)
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			errs[side.name] = validation.ErrRequired
+			continue
+		}
+
+		var receipt Receipt
+		if raw[0] != '"' {
+			if err := json.Unmarshal(raw, &receipt); err != nil {
+				errs[side.name] = err
+				continue
+			}
+		} else {
+			if err := json.Unmarshal(raw, &side.compared.ID); err != nil {
+				errs[side.name] = err
+				continue
+			}
+			loaded, rec, err := loadReceipt(r.Context(), side.compared.ID)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "No receipt found for the ID in "+side.name+".", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to load receipt to compare", zap.String("receiptID", side.compared.ID), zap.Error(err))
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			receipt = loaded
+			side.compared.StoredPoints = &rec.Points
+		}
+		side.compared.Breakdown = receipt.Breakdown()
+		side.compared.Points = totalPoints(side.compared.Breakdown)
+	}
+
+	status := http.StatusOK
+	var body any
+	if len(errs) > 0 {
+		status = http.StatusBadRequest
+		body = map[string]validation.Errors{"errors": errs}
+	} else {
+		response.Delta = response.B.Points - response.A.Points
+		response.Rules = ruleDeltas(response.A.Breakdown, response.B.Breakdown)
+		body = response
+	}
+
+	jsonResponse, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestCompareReceipts(t *testing.T) {
+	router := setup()
+
+	even := receipttest.New().PurchaseDate("2022-01-02").Build()
+	odd := receipttest.New().PurchaseDate("2022-01-01").Build()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(odd.JSON())))
+	var processed map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &processed)
+	storedID, _ := json.Marshal(processed["id"])
+
+	testCases := []struct {
+		name       string
+		a, b       []byte
+		wantStatus int
+		wantDelta  int
+	}{
+		{name: "payloads", a: even.JSON(), b: odd.JSON(), wantStatus: http.StatusOK, wantDelta: 6},
+		{name: "stored receipt", a: storedID, b: even.JSON(), wantStatus: http.StatusOK, wantDelta: -6},
+		{name: "same receipt", a: storedID, b: odd.JSON(), wantStatus: http.StatusOK, wantDelta: 0},
+		{name: "invalid receipt", a: even.JSON(), b: []byte(`{"retailer": "Target"}`), wantStatus: http.StatusBadRequest},
+		{name: "missing receipt", a: even.JSON(), b: []byte("null"), wantStatus: http.StatusBadRequest},
+		{name: "unknown ID", a: []byte(`"does-not-exist"`), b: even.JSON(), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"a": ` + string(tc.a) + `, "b": ` + string(tc.b) + `}`
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/compare", bytes.NewBufferString(body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got compareResponse
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if got.Delta != tc.wantDelta {
+				t.Errorf("delta = %v, want %v", got.Delta, tc.wantDelta)
+			}
+			sum := 0
+			for _, rule := range got.Rules {
+				sum += rule.Delta
+			}
+			if sum != got.Delta {
+				t.Errorf("rule deltas add up to %v, want %v", sum, got.Delta)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConcurrencyConfig caps in-flight requests globally and per route (keyed by path template). Zero means unlimited.
+// Requests wait up to MaxWait for a slot and are then rejected with 503 and a Retry-After header.
+//
+// Lanes caps the "interactive" and "batch" requests separately. Requests to BatchRoutes (defaultBatchRoutes unless
+// set) and requests with an "X-Priority: batch" header are batch, everything else is interactive. With the batch
+// lane below Global, bulk work can never take the slots interactive requests need.
+type ConcurrencyConfig struct {
+	Global      int            `json:"global"`
+	Routes      map[string]int `json:"routes"`
+	Lanes       map[string]int `json:"lanes"`
+	BatchRoutes []string       `json:"batchRoutes"`
+	MaxWait     Duration       `json:"maxWait"`
+	RetryAfter  Duration       `json:"retryAfter"`
+}
+
+const (
+	laneInteractive = "interactive"
+	laneBatch       = "batch"
+)
+
+// defaultBatchRoutes are the routes that scan, export or import many receipts at once.
+var defaultBatchRoutes = []string{
+	"/accounts/{id}/statement",
+	"/aggregate",
+	"/admin/backup",
+	"/admin/compact",
+	"/admin/restore",
+	"/admin/retention/run",
+	"/admin/rules/simulate",
+	"/stats/hourly",
+	"/stats/payment-methods",
+	"/stats/regions",
+}
+
+func (c ConcurrencyConfig) Validate() error {
+	for lane, limit := range c.Lanes {
+		if lane != laneInteractive && lane != laneBatch {
+			return fmt.Errorf("concurrency: unknown lane %q, must be %q or %q", lane, laneInteractive, laneBatch)
+		}
+		if limit < 0 {
+			return fmt.Errorf("concurrency: the %v lane limit must not be negative", lane)
+		}
+	}
+	if batch := c.Lanes[laneBatch]; c.Global > 0 && batch >= c.Global {
+		return fmt.Errorf("concurrency: the batch lane limit must be below the global limit, or batch requests can take every slot")
+	}
+	return nil
+}
+
+// lane is the priority class of a request. The header can only demote a request: a bulk import calling
+// /receipts/process can ask to be batch, a batch route can't ask to be interactive.
+func (c ConcurrencyConfig) lane(r *http.Request, route string) string {
+	batchRoutes := c.BatchRoutes
+	if batchRoutes == nil {
+		batchRoutes = defaultBatchRoutes
+	}
+	if r.Header.Get("X-Priority") == laneBatch || slices.Contains(batchRoutes, route) {
+		return laneBatch
+	}
+	return laneInteractive
+}
+
+var (
+	requestsInFlight = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fcpc_requests_in_flight",
+		Help: "Requests currently being handled, by route.",
+	}, []string{"route"})
+
+	requestsWaiting = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fcpc_requests_waiting",
+		Help: "Requests queued for a concurrency slot, by route.",
+	}, []string{"route"})
+
+	requestsRejectedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_requests_rejected_total",
+		Help: "Requests rejected because a concurrency limit was saturated, by route and limit (lane, global or route).",
+	}, []string{"route", "limit"})
+
+	laneRequestsInFlight = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fcpc_lane_requests_in_flight",
+		Help: "Requests currently being handled, by lane (interactive or batch).",
+	}, []string{"lane"})
+)
+
+// metrics must stay scrapeable exactly when the service is saturated.
+var unlimitedRoutes = map[string]bool{"/metrics": true}
+
+type semaphore chan struct{}
+
+// acquire waits up to maxWait for a slot. A nil semaphore is unlimited.
+func (s semaphore) acquire(r *http.Request, maxWait time.Duration) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	if maxWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+type concurrencyLimiter struct {
+	config     ConcurrencyConfig
+	global     semaphore
+	lanes      map[string]semaphore
+	routes     map[string]semaphore
+	maxWait    time.Duration
+	retryAfter time.Duration
+}
+
+func newConcurrencyLimiter(c ConcurrencyConfig) *concurrencyLimiter {
+	l := &concurrencyLimiter{config: c, lanes: map[string]semaphore{}, routes: map[string]semaphore{}, maxWait: time.Duration(c.MaxWait), retryAfter: time.Duration(c.RetryAfter)}
+	if l.retryAfter <= 0 {
+		l.retryAfter = time.Second
+	}
+	if c.Global > 0 {
+		l.global = make(semaphore, c.Global)
+	}
+	for lane, limit := range c.Lanes {
+		if limit > 0 {
+			l.lanes[lane] = make(semaphore, limit)
+		}
+	}
+	for route, limit := range c.Routes {
+		if limit > 0 {
+			l.routes[route] = make(semaphore, limit)
+		}
+	}
+	return l
+}
+
+// requestsQueued is what fcpc_requests_waiting adds up to across routes, for load shedding.
+var requestsQueued atomic.Int64
+
+// routeTemplate is the path template of the route r matched, or its path if it matched none.
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// limiter is swapped as a whole on config reload.
+var limiter atomic.Pointer[concurrencyLimiter]
+
+func init() {
+	limiter.Store(newConcurrencyLimiter(ConcurrencyConfig{}))
+}
+
+func concurrencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if unlimitedRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		l := limiter.Load()
+		lane := l.config.lane(r, route)
+		requestsWaiting.WithLabelValues(route).Inc()
+		requestsQueued.Add(1)
+		gotLane := l.lanes[lane].acquire(r, l.maxWait)
+		gotGlobal := gotLane && l.global.acquire(r, l.maxWait)
+		gotRoute := gotGlobal && l.routes[route].acquire(r, l.maxWait)
+		requestsQueued.Add(-1)
+		requestsWaiting.WithLabelValues(route).Dec()
+
+		if !gotRoute {
+			limit := "route"
+			switch {
+			case !gotLane:
+				limit = "lane"
+			case !gotGlobal:
+				limit = "global"
+				l.lanes[lane].release()
+			default:
+				l.global.release()
+				l.lanes[lane].release()
+			}
+			requestsRejectedTotal.WithLabelValues(route, limit).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.retryAfter.Seconds()))))
+			http.Error(w, "The service is busy, please retry later.", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.lanes[lane].release()
+		defer l.global.release()
+		defer l.routes[route].release()
+
+		requestsInFlight.WithLabelValues(route).Inc()
+		defer requestsInFlight.WithLabelValues(route).Dec()
+		laneRequestsInFlight.WithLabelValues(lane).Inc()
+		defer laneRequestsInFlight.WithLabelValues(lane).Dec()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestConcurrencyMiddleware(t *testing.T) {
+	setup()
+
+	testCases := []struct {
+		name       string
+		config     ConcurrencyConfig
+		wantStatus int
+	}{
+		{name: "unlimited", config: ConcurrencyConfig{}, wantStatus: http.StatusOK},
+		{name: "route limit saturated", config: ConcurrencyConfig{Routes: map[string]int{"/slow": 1}}, wantStatus: http.StatusServiceUnavailable},
+		{name: "global limit saturated", config: ConcurrencyConfig{Global: 1}, wantStatus: http.StatusServiceUnavailable},
+		{name: "waits for a slot", config: ConcurrencyConfig{Global: 1, MaxWait: Duration(time.Second)}, wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter.Store(newConcurrencyLimiter(tc.config))
+
+			// only the first request blocks, holding its slot until unblock is closed.
+			var calls atomic.Int32
+			started := make(chan struct{})
+			unblock := make(chan struct{})
+			router := mux.NewRouter()
+			router.Use(concurrencyMiddleware)
+			router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					close(started)
+					<-unblock
+				}
+			})
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+			}()
+			<-started
+
+			if tc.config.MaxWait > 0 {
+				// free the slot while the second request is queued.
+				time.AfterFunc(20*time.Millisecond, func() { close(unblock) })
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+			if tc.config.MaxWait == 0 {
+				close(unblock)
+			}
+			wg.Wait()
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus == http.StatusServiceUnavailable && rr.Header().Get("Retry-After") != "1" {
+				t.Errorf("Retry-After = %q, want %q", rr.Header().Get("Retry-After"), "1")
+			}
+		})
+	}
+}
+
+func TestPriorityLanes(t *testing.T) {
+	setup()
+	limiter.Store(newConcurrencyLimiter(ConcurrencyConfig{Global: 2, Lanes: map[string]int{"batch": 1}, BatchRoutes: []string{"/bulk"}}))
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	router := mux.NewRouter()
+	router.Use(concurrencyMiddleware)
+	router.HandleFunc("/bulk", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+	})
+	router.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bulk", nil))
+	}()
+	<-started
+	defer wg.Wait()
+	defer close(unblock)
+
+	testCases := []struct {
+		name       string
+		path       string
+		priority   string
+		wantStatus int
+	}{
+		{name: "batch route", path: "/bulk", wantStatus: http.StatusServiceUnavailable},
+		{name: "demoted by header", path: "/process", priority: "batch", wantStatus: http.StatusServiceUnavailable},
+		{name: "interactive", path: "/process", wantStatus: http.StatusOK},
+		{name: "can't be promoted", path: "/bulk", priority: "interactive", wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.priority != "" {
+				req.Header.Set("X-Priority", tc.priority)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestConcurrencyConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ConcurrencyConfig
+		wantErr bool
+	}{
+		{name: "lanes", config: ConcurrencyConfig{Global: 100, Lanes: map[string]int{"interactive": 90, "batch": 20}}},
+		{name: "unknown lane", config: ConcurrencyConfig{Lanes: map[string]int{"urgent": 10}}, wantErr: true},
+		{name: "negative limit", config: ConcurrencyConfig{Lanes: map[string]int{"batch": -1}}, wantErr: true},
+		{name: "batch takes every slot", config: ConcurrencyConfig{Global: 20, Lanes: map[string]int{"batch": 20}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config holds everything that can be tuned without a rebuild. It is read from the JSON file pointed to by the
+// CONFIG_FILE environment variable; every field is optional so the app still runs with no file at all.
+type Config struct {
+	LogLevel           string                  `json:"logLevel"`
+	Logging            LoggingConfig           `json:"logging"`
+	Store              StoreConfig             `json:"store"`
+	Ingest             IngestConfig            `json:"ingest"`
+	IngestMetrics      IngestMetricsConfig     `json:"ingestMetrics"`
+	Connectors         []ConnectorConfig       `json:"connectors"`
+	IMAP               IMAPConfig              `json:"imap"`
+	S3Ingest           S3IngestConfig          `json:"s3Ingest"`
+	Consumer           ConsumerConfig          `json:"consumer"`
+	Chaos              ChaosConfig             `json:"chaos"`
+	Concurrency        ConcurrencyConfig       `json:"concurrency"`
+	Shedding           SheddingConfig          `json:"shedding"`
+	Transfers          TransferConfig          `json:"transfers"`
+	Streaks            StreakConfig            `json:"streaks"`
+	Statements         StatementConfig         `json:"statements"`
+	Notifications      NotificationConfig      `json:"notifications"`
+	Events             EventsConfig            `json:"events"`
+	Throttle           ThrottleConfig          `json:"throttle"`
+	Holds              HoldsConfig             `json:"holds"`
+	AuditSampling      AuditSamplingConfig     `json:"auditSampling"`
+	Fraud              FraudConfig             `json:"fraud"`
+	ReceiptLimits      ReceiptLimitsConfig     `json:"receiptLimits"`
+	Validation         ValidationConfig        `json:"validation"`
+	Rules              RulesConfig             `json:"rules"`
+	RuleHistory        RuleHistoryConfig       `json:"ruleHistory"`
+	RuleTests          []RuleTest              `json:"ruleTests"`
+	Regions            RegionsConfig           `json:"regions"`
+	TransactionNumbers TransactionNumberConfig `json:"transactionNumbers"`
+	Backup             BackupConfig            `json:"backup"`
+	Erasure            ErasureConfig           `json:"erasure"`
+	Retention          RetentionConfig         `json:"retention"`
+	Recalculation      RecalculationConfig     `json:"recalculation"`
+	Canary             CanaryConfig            `json:"canary"`
+	Aggregates         AggregatesConfig        `json:"aggregates"`
+	Spool              SpoolConfig             `json:"spool"`
+	ReceiptIDs         ReceiptIDConfig         `json:"receiptIds"`
+	Caching            CachingConfig           `json:"caching"`
+	Replication        ReplicationConfig       `json:"replication"`
+	Sharding           ShardingConfig          `json:"sharding"`
+	Tap                TapConfig               `json:"tap"`
+	SlowRequests       SlowRequestsConfig      `json:"slowRequests"`
+	Auth               AuthConfig              `json:"auth"`
+	SignedURLs         SignedURLConfig         `json:"signedUrls"`
+	ClientIP           ClientIPConfig          `json:"clientIp"`
+	Server             ServerConfig            `json:"server"`
+}
+
+// StoreConfig selects the receipt storage backend: "memory" (default), "postgres" or "redis". DSN is a postgres
+// connection string or a redis:// URL. CompactInterval schedules compaction for backends that support it. Fallback
+// smooths over blips of the backend, see StoreFallbackConfig.
+type StoreConfig struct {
+	Backend         string              `json:"backend"`
+	DSN             string              `json:"dsn"`
+	CompactInterval Duration            `json:"compactInterval"`
+	Fallback        StoreFallbackConfig `json:"fallback"`
+}
+
+// IngestConfig configures the watched-directory ingestion mode. It is disabled when Dir is empty.
+type IngestConfig struct {
+	Dir          string   `json:"dir"`
+	ResultDir    string   `json:"resultDir"`
+	ProcessedDir string   `json:"processedDir"`
+	FailedDir    string   `json:"failedDir"`
+	PollInterval Duration `json:"pollInterval"`
+	// SettleTime is how long a file must go unmodified before it is picked up, so one still being uploaded is left for
+	// a later pass. 5s by default.
+	SettleTime Duration `json:"settleTime"`
+}
+
+func (c IngestConfig) settleTime() time.Duration {
+	if c.SettleTime == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.SettleTime)
+}
+
+// Duration lets durations be written as "10s" or "5m" in the config file instead of nanoseconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfig reads the config file at path, falling back to defaults when path is empty. The LOG_LEVEL environment
+// variable still wins over the file so existing deployments keep working, and API_KEYS adds to the file's keys.
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parsing config: %w", err)
+		}
+	}
+
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		cfg.LogLevel = logLevel
+	}
+	if err := apiKeysFromEnv(&cfg.Auth); err != nil {
+		return Config{}, err
+	}
+
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return Config{}, fmt.Errorf("invalid logLevel: %w", err)
+	}
+
+	cfg.Ingest.setDefaults()
+
+	if err := cfg.Store.Fallback.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.IngestMetrics.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Logging.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Concurrency.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Shedding.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Transfers.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Streaks.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Throttle.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Holds.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.AuditSampling.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Fraud.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validation.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.ReceiptLimits.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Rules.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Regions.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Rules.validateRegions(cfg.Regions); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.RuleHistory.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.RuleHistory.validateRegions(cfg.Regions); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.TransactionNumbers.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Backup.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Erasure.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Retention.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Recalculation.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Canary.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Aggregates.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Spool.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.ReceiptIDs.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Caching.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Replication.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Sharding.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Tap.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.SlowRequests.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Auth.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.SignedURLs.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.ClientIP.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Server.Validate(); err != nil {
+		return Config{}, err
+	}
+	if cfg.Statements.PointsExpireAfterMonths < 0 {
+		return Config{}, fmt.Errorf("statements: pointsExpireAfterMonths must not be negative")
+	}
+
+	if cfg.Consumer.Type != "" {
+		if err := cfg.Consumer.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if cfg.Notifications.Type != "" {
+		if err := cfg.Notifications.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.Events.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	for _, c := range cfg.Connectors {
+		if err := c.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// last, so the tests score with a config that is valid otherwise.
+	if failures := runRuleTests(cfg, cfg.RuleTests); len(failures) > 0 {
+		return Config{}, fmt.Errorf("ruleTests: %d of %d failed:\n%w", len(failures), len(cfg.RuleTests), errors.Join(failures...))
+	}
+	return cfg, nil
+}
+
+func (c *IngestConfig) setDefaults() {
+	if c.Dir == "" {
+		return
+	}
+	if c.ResultDir == "" {
+		c.ResultDir = filepath.Join(c.Dir, "results")
+	}
+	if c.ProcessedDir == "" {
+		c.ProcessedDir = filepath.Join(c.Dir, "processed")
+	}
+	if c.FailedDir == "" {
+		c.FailedDir = filepath.Join(c.Dir, "failed")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ConnectorConfig describes one remote endpoint receipts are pulled from. Each connector runs on its own schedule.
+type ConnectorConfig struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // "sftp" or "ftps"
+	Address   string `json:"address"`
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	RemoteDir string `json:"remoteDir"`
+	// AckDir is where the per-run result file is written back, defaults to RemoteDir/results.
+	AckDir   string   `json:"ackDir"`
+	Interval Duration `json:"interval"`
+
+	// sftp only.
+	PrivateKeyFile        string `json:"privateKeyFile"`
+	KnownHostsFile        string `json:"knownHostsFile"`
+	InsecureIgnoreHostKey bool   `json:"insecureIgnoreHostKey"`
+}
+
+func (c ConnectorConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("connector: name is required")
+	}
+	if _, ok := remoteDialers[c.Type]; !ok {
+		return fmt.Errorf("connector %s: unknown type %q", c.Name, c.Type)
+	}
+	if c.Address == "" || c.RemoteDir == "" {
+		return fmt.Errorf("connector %s: address and remoteDir are required", c.Name)
+	}
+	return nil
+}
+
+// remoteFS is the small slice of a remote filesystem a connector needs, so SFTP and FTPS look the same to runConnector.
+type remoteFS interface {
+	List(dir string) ([]string, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+	Remove(path string) error
+	Close() error
+}
+
+var remoteDialers = map[string]func(ConnectorConfig) (remoteFS, error){
+	"sftp": dialSFTP,
+	"ftps": dialFTPS,
+}
+
+// connectorAck is written back to the remote side for every file picked up, as soon as it is processed.
+type connectorAck struct {
+	Connector string                  `json:"connector"`
+	RunAt     time.Time               `json:"runAt"`
+	Results   map[string]ingestResult `json:"results"`
+}
+
+func startConnectors(ctx context.Context, connectors []ConnectorConfig) {
+	for _, c := range connectors {
+		go func(c ConnectorConfig) {
+			logger.Info("Starting connector", zap.String("connector", c.Name), zap.String("type", c.Type))
+			runPeriodically(ctx, time.Duration(c.Interval), func(ctx context.Context) {
+				runConnector(ctx, c, remoteDialers[c.Type])
+			})
+		}(c)
+	}
+}
+
+// runConnector does a single pull and records the outcome in metrics, which is where failure alerts come from.
+func runConnector(ctx context.Context, c ConnectorConfig, dial func(ConnectorConfig) (remoteFS, error)) {
+	if err := pullConnector(ctx, c, dial); err != nil {
+		logger.Error("Connector run failed", zap.String("connector", c.Name), zap.Error(err))
+		connectorRunsTotal.WithLabelValues(c.Name, "failure").Inc()
+		return
+	}
+	connectorRunsTotal.WithLabelValues(c.Name, "success").Inc()
+	connectorLastSuccess.WithLabelValues(c.Name).SetToCurrentTime()
+}
+
+func pullConnector(ctx context.Context, c ConnectorConfig, dial func(ConnectorConfig) (remoteFS, error)) error {
+	fs, err := dial(c)
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	defer fs.Close()
+
+	names, err := fs.List(c.RemoteDir)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", c.RemoteDir, err)
+	}
+	sort.Strings(names)
+
+	ackDir := c.AckDir
+	if ackDir == "" {
+		ackDir = path.Join(c.RemoteDir, "results")
+	}
+	// a file that failed is left out rather than stopping the run, so the files after it are still picked up.
+	var errs []error
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if err := pullFile(ctx, c, fs, ackDir, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pullFile processes one file and acks and removes it right away. Once its receipt is stored the file is removed even
+// if the ack can't be written, reading it again would credit the receipt twice.
+func pullFile(ctx context.Context, c ConnectorConfig, fs remoteFS, ackDir, name string) error {
+	remotePath := path.Join(c.RemoteDir, name)
+	data, err := fs.ReadFile(remotePath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", remotePath, err)
+	}
+
+	result := processReceiptData(ctx, data)
+	if result.Error != "" {
+		connectorFilesTotal.WithLabelValues(c.Name, "failed").Inc()
+	} else {
+		connectorFilesTotal.WithLabelValues(c.Name, "processed").Inc()
+	}
+
+	ack := connectorAck{Connector: c.Name, RunAt: time.Now().UTC(), Results: map[string]ingestResult{name: result}}
+	ackJSON, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	ackPath := path.Join(ackDir, ack.RunAt.Format("20060102T150405Z")+"-"+strings.TrimSuffix(name, ".json")+".ack.json")
+	ackErr := fs.WriteFile(ackPath, ackJSON)
+	if ackErr != nil {
+		logger.Error("Failed to write connector ack", zap.String("connector", c.Name), zap.String("file", name), zap.String("id", result.ID), zap.Error(ackErr))
+		ackErr = fmt.Errorf("writing ack %s: %w", ackPath, ackErr)
+	}
+	if err := fs.Remove(remotePath); err != nil {
+		return errors.Join(ackErr, fmt.Errorf("removing %s: %w", remotePath, err))
+	}
+	return ackErr
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+type ftpsFS struct {
+	conn *ftp.ServerConn
+}
+
+// dialFTPS connects with explicit TLS (AUTH TLS), which is what the partners still on FTP servers support.
+func dialFTPS(c ConnectorConfig) (remoteFS, error) {
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ftp.Dial(c.Address,
+		ftp.DialWithTimeout(30*time.Second),
+		ftp.DialWithExplicitTLS(&tls.Config{ServerName: host}))
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Login(c.User, c.Password); err != nil {
+		conn.Quit()
+		return nil, err
+	}
+	return &ftpsFS{conn: conn}, nil
+}
+
+func (f *ftpsFS) List(dir string) ([]string, error) {
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type == ftp.EntryTypeFile {
+			names = append(names, entry.Name)
+		}
+	}
+	return names, nil
+}
+
+func (f *ftpsFS) ReadFile(name string) ([]byte, error) {
+	resp, err := f.conn.Retr(name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return io.ReadAll(resp)
+}
+
+func (f *ftpsFS) WriteFile(name string, data []byte) error {
+	// most servers reject MKD for an existing directory, the Stor below reports the real problem if there is one.
+	f.conn.MakeDir(path.Dir(name))
+	return f.conn.Stor(name, bytes.NewReader(data))
+}
+
+func (f *ftpsFS) Remove(name string) error {
+	return f.conn.Delete(name)
+}
+
+func (f *ftpsFS) Close() error {
+	return f.conn.Quit()
+}
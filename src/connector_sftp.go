@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDialTimeout bounds connecting and the SSH handshake, so a stalled server doesn't hang the connector.
+const sftpDialTimeout = 30 * time.Second
+
+type sftpFS struct {
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func dialSFTP(c ConnectorConfig) (remoteFS, error) {
+	var auth []ssh.AuthMethod
+	if c.PrivateKeyFile != "" {
+		key, err := os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case c.KnownHostsFile != "":
+		var err error
+		hostKeyCallback, err = knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading known hosts: %w", err)
+		}
+	case c.InsecureIgnoreHostKey:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("knownHostsFile is required unless insecureIgnoreHostKey is set")
+	}
+
+	conn, err := ssh.Dial("tcp", c.Address, &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpFS{conn: conn, client: client}, nil
+}
+
+func (s *sftpFS) List(dir string) ([]string, error) {
+	infos, err := s.client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *sftpFS) ReadFile(path string) ([]byte, error) {
+	f, err := s.client.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *sftpFS) WriteFile(name string, data []byte) error {
+	if err := s.client.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	f, err := s.client.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *sftpFS) Remove(path string) error {
+	return s.client.Remove(path)
+}
+
+func (s *sftpFS) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeFS is an in-memory remoteFS keyed by full path. Writes fail while failWrites is set.
+type fakeFS struct {
+	files      map[string][]byte
+	failWrites bool
+}
+
+func (f *fakeFS) List(dir string) ([]string, error) {
+	var names []string
+	for p := range f.files {
+		if path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	return names, nil
+}
+
+func (f *fakeFS) ReadFile(p string) ([]byte, error) {
+	data, ok := f.files[p]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (f *fakeFS) WriteFile(p string, data []byte) error {
+	if f.failWrites {
+		return errors.New("disk full")
+	}
+	f.files[p] = data
+	return nil
+}
+
+func (f *fakeFS) Remove(p string) error {
+	delete(f.files, p)
+	return nil
+}
+
+func (f *fakeFS) Close() error { return nil }
+
+func TestRunConnector(t *testing.T) {
+	setup()
+
+	fs := &fakeFS{files: map[string][]byte{
+		"/in/good.json": []byte(`{
+			"retailer": "Target",
+			"purchaseDate": "2022-01-01",
+			"purchaseTime": "13:01",
+			"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+			"total": "6.49"
+		}`),
+		"/in/bad.json": []byte(`{"retailer": "Target"}`),
+	}}
+	c := ConnectorConfig{Name: "test-partner", Type: "sftp", RemoteDir: "/in"}
+	dial := func(ConnectorConfig) (remoteFS, error) { return fs, nil }
+
+	runConnector(context.Background(), c, dial)
+
+	if got := testutil.ToFloat64(connectorRunsTotal.WithLabelValues(c.Name, "success")); got != 1 {
+		t.Errorf("success runs = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(connectorFilesTotal.WithLabelValues(c.Name, "failed")); got != 1 {
+		t.Errorf("failed files = %v, want 1", got)
+	}
+
+	// an ack per file.
+	ack := connectorAck{Results: map[string]ingestResult{}}
+	for p, data := range fs.files {
+		if strings.HasPrefix(p, "/in/results/") && strings.HasSuffix(p, ".ack.json") {
+			var fileAck connectorAck
+			if err := json.Unmarshal(data, &fileAck); err != nil {
+				t.Fatalf("Failed to parse ack: %v", err)
+			}
+			maps.Copy(ack.Results, fileAck.Results)
+		} else {
+			t.Errorf("unexpected file left behind: %v", p)
+		}
+	}
+	if ack.Results["good.json"].ID == "" {
+		t.Errorf("ack for good.json has no ID: %+v", ack.Results["good.json"])
+	}
+	if ack.Results["bad.json"].Error == "" {
+		t.Errorf("ack for bad.json has no error: %+v", ack.Results["bad.json"])
+	}
+
+	failing := func(ConnectorConfig) (remoteFS, error) { return nil, errors.New("connection refused") }
+	runConnector(context.Background(), c, failing)
+	if got := testutil.ToFloat64(connectorRunsTotal.WithLabelValues(c.Name, "failure")); got != 1 {
+		t.Errorf("failure runs = %v, want 1", got)
+	}
+}
+
+func TestRunConnectorAckFails(t *testing.T) {
+	setup()
+	receipt := receipttest.New().Build().JSON()
+	fs := &fakeFS{files: map[string][]byte{"/in/a.json": receipt, "/in/b.json": receipt}, failWrites: true}
+	c := ConnectorConfig{Name: "ack-fails", Type: "sftp", RemoteDir: "/in"}
+	dial := func(ConnectorConfig) (remoteFS, error) { return fs, nil }
+
+	// both files are processed and removed, so the next run doesn't credit them again.
+	runConnector(context.Background(), c, dial)
+	if got := testutil.ToFloat64(connectorRunsTotal.WithLabelValues(c.Name, "failure")); got != 1 {
+		t.Errorf("failure runs = %v, want 1", got)
+	}
+	if len(fs.files) != 0 {
+		t.Errorf("files left behind: %v", slices.Collect(maps.Keys(fs.files)))
+	}
+	fs.failWrites = false
+	runConnector(context.Background(), c, dial)
+	if got := testutil.ToFloat64(connectorFilesTotal.WithLabelValues(c.Name, "processed")); got != 2 {
+		t.Errorf("processed files = %v, want 2", got)
+	}
+}
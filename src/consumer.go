@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ConsumerConfig configures the message queue consumer mode, where each message is a receipt and the result is
+// published to a response topic. It is disabled when Type is empty.
+type ConsumerConfig struct {
+	Type string `json:"type"` // "kafka", "nats" or "sqs"
+	// Brokers is the kafka bootstrap list, URL the NATS server or the SQS request queue URL.
+	Brokers []string `json:"brokers"`
+	URL     string   `json:"url"`
+	// Topic is the kafka topic or NATS subject receipts arrive on, Group the kafka consumer group or NATS queue group.
+	Topic string `json:"topic"`
+	Group string `json:"group"`
+	// ResponseTopic is a kafka topic, NATS subject or SQS queue URL. NATS request/reply messages are answered on their
+	// reply subject instead.
+	ResponseTopic string `json:"responseTopic"`
+	Region        string `json:"region"`
+	// DisableHTTP runs the binary as a pure consumer, without the HTTP server.
+	DisableHTTP bool `json:"disableHttp"`
+}
+
+func (c ConsumerConfig) Validate() error {
+	if _, ok := queueDialers[c.Type]; !ok {
+		return fmt.Errorf("consumer: unknown type %q", c.Type)
+	}
+	if c.ResponseTopic == "" {
+		return fmt.Errorf("consumer: responseTopic is required")
+	}
+	switch {
+	case c.Type == "kafka" && (len(c.Brokers) == 0 || c.Topic == "" || c.Group == ""):
+		return fmt.Errorf("consumer: kafka needs brokers, topic and group")
+	case c.Type == "nats" && (c.URL == "" || c.Topic == ""):
+		return fmt.Errorf("consumer: nats needs url and topic")
+	case c.Type == "sqs" && c.URL == "":
+		return fmt.Errorf("consumer: sqs needs url")
+	}
+	return nil
+}
+
+type queueMessage struct {
+	ID      string
+	Body    []byte
+	ReplyTo string
+	// Ack marks the message as done so it isn't redelivered. It is nil for transports without acknowledgements.
+	Ack func(context.Context) error
+}
+
+// messageQueue hides the differences between the supported brokers from runConsumer.
+type messageQueue interface {
+	// Receive blocks until at least one message is available or ctx is done.
+	Receive(ctx context.Context) ([]queueMessage, error)
+	Publish(ctx context.Context, msg queueMessage, body []byte) error
+	Close() error
+}
+
+var queueDialers = map[string]func(context.Context, ConsumerConfig) (messageQueue, error){
+	"kafka": dialKafka,
+	"nats":  dialNATS,
+	"sqs":   dialSQSQueue,
+}
+
+// queuePublishers dial a queue that only publishes, to the response topic, for the replication primary. SQS queues
+// are only read from when asked to.
+var queuePublishers = map[string]func(context.Context, ConsumerConfig) (messageQueue, error){
+	"kafka": dialKafkaPublisher,
+	"nats":  dialNATSPublisher,
+	"sqs":   dialSQSQueue,
+}
+
+// consumerResponse is published for every consumed message, carrying the source message ID for correlation.
+type consumerResponse struct {
+	MessageID string `json:"messageId"`
+	ingestResult
+}
+
+func startConsumer(ctx context.Context, c ConsumerConfig) error {
+	q, err := queueDialers[c.Type](ctx, c)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", c.Type, err)
+	}
+
+	logger.Info("Consuming receipts from queue", zap.String("type", c.Type), zap.String("topic", c.Topic))
+	go func() {
+		defer q.Close()
+		runConsumer(ctx, q)
+	}()
+	return nil
+}
+
+// runConsumer processes messages until ctx is done. A message is acknowledged only once its response is published,
+// so delivery is at-least-once: a crash in between means the receipt is processed again on redelivery.
+func runConsumer(ctx context.Context, q messageQueue) {
+	for ctx.Err() == nil {
+		messages, err := q.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to receive messages", zap.Error(err))
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			if err := consumeMessage(ctx, q, msg); err != nil {
+				logger.Error("Failed to consume message", zap.String("messageID", msg.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func consumeMessage(ctx context.Context, q messageQueue, msg queueMessage) error {
+	result := processReceiptData(ctx, msg.Body)
+	logger.Debug("Consumed receipt", zap.String("messageID", msg.ID), zap.Any("result", result))
+
+	response, err := json.Marshal(consumerResponse{MessageID: msg.ID, ingestResult: result})
+	if err != nil {
+		return err
+	}
+	if err := q.Publish(ctx, msg, response); err != nil {
+		return fmt.Errorf("publishing response: %w", err)
+	}
+
+	if msg.Ack == nil {
+		return nil
+	}
+	return msg.Ack(ctx)
+}
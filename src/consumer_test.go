@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type fakeQueue struct {
+	published  map[string][]byte
+	acked      []string
+	publishErr error
+}
+
+func (f *fakeQueue) Receive(ctx context.Context) ([]queueMessage, error) { return nil, nil }
+
+func (f *fakeQueue) Publish(ctx context.Context, msg queueMessage, body []byte) error {
+	if f.publishErr != nil {
+		return f.publishErr
+	}
+	f.published[msg.ID] = body
+	return nil
+}
+
+func (f *fakeQueue) Close() error { return nil }
+
+func (f *fakeQueue) message(id, body string) queueMessage {
+	return queueMessage{ID: id, Body: []byte(body), Ack: func(context.Context) error {
+		f.acked = append(f.acked, id)
+		return nil
+	}}
+}
+
+func TestConsumeMessage(t *testing.T) {
+	setup()
+
+	testCases := []struct {
+		name       string
+		body       string
+		publishErr error
+		wantAcked  bool
+		wantResult bool
+		wantError  bool
+	}{
+		{
+			name: "valid receipt",
+			body: `{
+				"retailer": "Target",
+				"purchaseDate": "2022-01-01",
+				"purchaseTime": "13:01",
+				"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+				"total": "6.49"
+			}`,
+			wantAcked:  true,
+			wantResult: true,
+		},
+		{
+			name:       "invalid receipt is answered and acked",
+			body:       `{"retailer": "Target"}`,
+			wantAcked:  true,
+			wantResult: true,
+			wantError:  true,
+		},
+		{
+			name:       "publish failure leaves message unacked",
+			body:       `{"retailer": "Target"}`,
+			publishErr: errors.New("broker down"),
+			wantAcked:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := &fakeQueue{published: map[string][]byte{}, publishErr: tc.publishErr}
+
+			err := consumeMessage(context.Background(), q, q.message("m1", tc.body))
+			if (err != nil) != (tc.publishErr != nil) {
+				t.Fatalf("consumeMessage() error = %v", err)
+			}
+			if got := len(q.acked) == 1; got != tc.wantAcked {
+				t.Errorf("acked = %v, want %v", got, tc.wantAcked)
+			}
+			if !tc.wantResult {
+				return
+			}
+
+			var resp consumerResponse
+			if err := json.Unmarshal(q.published["m1"], &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if resp.MessageID != "m1" {
+				t.Errorf("messageId = %v, want m1", resp.MessageID)
+			}
+			if gotErr := resp.Error != ""; gotErr != tc.wantError {
+				t.Errorf("response error = %q, want error %v", resp.Error, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestConsumerConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ConsumerConfig
+		wantErr bool
+	}{
+		{name: "kafka", config: ConsumerConfig{Type: "kafka", Brokers: []string{"kafka:9092"}, Topic: "receipts", Group: "fcpc", ResponseTopic: "results"}},
+		{name: "kafka without a topic", config: ConsumerConfig{Type: "kafka", Brokers: []string{"kafka:9092"}, Group: "fcpc", ResponseTopic: "results"}, wantErr: true},
+		{name: "kafka without a group", config: ConsumerConfig{Type: "kafka", Brokers: []string{"kafka:9092"}, Topic: "receipts", ResponseTopic: "results"}, wantErr: true},
+		{name: "nats without a subject", config: ConsumerConfig{Type: "nats", URL: "nats://nats:4222", ResponseTopic: "results"}, wantErr: true},
+		{name: "sqs", config: ConsumerConfig{Type: "sqs", URL: "https://sqs/receipts", ResponseTopic: "https://sqs/results"}},
+		{name: "without a response topic", config: ConsumerConfig{Type: "sqs", URL: "https://sqs/receipts"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, want an error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
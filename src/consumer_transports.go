@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// errPublishOnly is returned by Receive on a queue dialed only to publish.
+var errPublishOnly = errors.New("the queue was dialed only to publish")
+
+type kafkaQueue struct {
+	reader *kafka.Reader
+	writer *kafka.Writer
+}
+
+func dialKafka(ctx context.Context, c ConsumerConfig) (messageQueue, error) {
+	if len(c.Brokers) == 0 || c.Topic == "" || c.Group == "" {
+		return nil, fmt.Errorf("kafka needs brokers, topic and group")
+	}
+	return &kafkaQueue{
+		reader: kafka.NewReader(kafka.ReaderConfig{Brokers: c.Brokers, Topic: c.Topic, GroupID: c.Group}),
+		writer: &kafka.Writer{Addr: kafka.TCP(c.Brokers...), Topic: c.ResponseTopic},
+	}, nil
+}
+
+// dialKafkaPublisher only publishes, to the response topic.
+func dialKafkaPublisher(ctx context.Context, c ConsumerConfig) (messageQueue, error) {
+	if len(c.Brokers) == 0 || c.ResponseTopic == "" {
+		return nil, fmt.Errorf("kafka needs brokers and a topic to publish to")
+	}
+	return &kafkaQueue{writer: &kafka.Writer{Addr: kafka.TCP(c.Brokers...), Topic: c.ResponseTopic}}, nil
+}
+
+func (k *kafkaQueue) Receive(ctx context.Context) ([]queueMessage, error) {
+	if k.reader == nil {
+		return nil, errPublishOnly
+	}
+	m, err := k.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []queueMessage{{
+		ID:   fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset),
+		Body: m.Value,
+		Ack:  func(ctx context.Context) error { return k.reader.CommitMessages(ctx, m) },
+	}}, nil
+}
+
+func (k *kafkaQueue) Publish(ctx context.Context, msg queueMessage, body []byte) error {
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(msg.ID), Value: body})
+}
+
+func (k *kafkaQueue) Close() error {
+	k.writer.Close()
+	if k.reader == nil {
+		return nil
+	}
+	return k.reader.Close()
+}
+
+// natsQueue uses core NATS with a queue group, so replicas share the load. Core NATS has no acknowledgements.
+type natsQueue struct {
+	conn            *nats.Conn
+	sub             *nats.Subscription
+	responseSubject string
+}
+
+func dialNATS(ctx context.Context, c ConsumerConfig) (messageQueue, error) {
+	if c.URL == "" || c.Topic == "" {
+		return nil, fmt.Errorf("nats needs url and topic")
+	}
+	conn, err := nats.Connect(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := conn.QueueSubscribeSync(c.Topic, c.Group)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsQueue{conn: conn, sub: sub, responseSubject: c.ResponseTopic}, nil
+}
+
+// dialNATSPublisher only publishes, to the response subject.
+func dialNATSPublisher(ctx context.Context, c ConsumerConfig) (messageQueue, error) {
+	if c.URL == "" || c.ResponseTopic == "" {
+		return nil, fmt.Errorf("nats needs url and a subject to publish to")
+	}
+	conn, err := nats.Connect(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &natsQueue{conn: conn, responseSubject: c.ResponseTopic}, nil
+}
+
+func (n *natsQueue) Receive(ctx context.Context) ([]queueMessage, error) {
+	if n.sub == nil {
+		return nil, errPublishOnly
+	}
+	m, err := n.sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id := m.Header.Get(nats.MsgIdHdr)
+	return []queueMessage{{ID: id, Body: m.Data, ReplyTo: m.Reply}}, nil
+}
+
+func (n *natsQueue) Publish(ctx context.Context, msg queueMessage, body []byte) error {
+	subject := n.responseSubject
+	if msg.ReplyTo != "" {
+		subject = msg.ReplyTo
+	}
+	return n.conn.Publish(subject, body)
+}
+
+func (n *natsQueue) Close() error {
+	if n.sub != nil {
+		n.sub.Unsubscribe()
+	}
+	n.conn.Close()
+	return nil
+}
+
+type sqsQueue struct {
+	client      *sqs.Client
+	queueURL    string
+	responseURL string
+}
+
+func dialSQSQueue(ctx context.Context, c ConsumerConfig) (messageQueue, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("sqs needs url")
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(c.Region))
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueue{client: sqs.NewFromConfig(awsCfg), queueURL: c.URL, responseURL: c.ResponseTopic}, nil
+}
+
+func (s *sqsQueue) Receive(ctx context.Context) ([]queueMessage, error) {
+	out, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(s.queueURL),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     20,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]queueMessage, len(out.Messages))
+	for i, m := range out.Messages {
+		handle := m.ReceiptHandle
+		messages[i] = queueMessage{
+			ID:   aws.ToString(m.MessageId),
+			Body: []byte(aws.ToString(m.Body)),
+			Ack: func(ctx context.Context) error {
+				_, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(s.queueURL), ReceiptHandle: handle})
+				return err
+			},
+		}
+	}
+	return messages, nil
+}
+
+func (s *sqsQueue) Publish(ctx context.Context, msg queueMessage, body []byte) error {
+	_, err := s.client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: aws.String(s.responseURL), MessageBody: aws.String(string(body))})
+	return err
+}
+
+func (s *sqsQueue) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// run with -update after an intentional API change and review the diff of testdata/golden like any other code.
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+var uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// goldenResponse is the part of a response that makes up the contract. JSON bodies are stored as JSON so the golden
+// files diff nicely, anything else as a string.
+type goldenResponse struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType"`
+	Body        json.RawMessage `json:"body"`
+}
+
+func toGolden(rr *httptest.ResponseRecorder) goldenResponse {
+	// generated IDs change on every run.
+	body := uuidPattern.ReplaceAll(rr.Body.Bytes(), []byte("00000000-0000-0000-0000-000000000000"))
+
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	} else {
+		var indented bytes.Buffer
+		json.Indent(&indented, body, "", "    ")
+		body = indented.Bytes()
+	}
+
+	return goldenResponse{Status: rr.Code, ContentType: rr.Header().Get("Content-Type"), Body: body}
+}
+
+func TestAPIContract(t *testing.T) {
+	router := setup()
+
+	validReceipt := `{
+		"retailer": "Target",
+		"purchaseDate": "2022-01-01",
+		"purchaseTime": "13:01",
+		"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+		"total": "6.49"
+	}`
+
+	// a stored receipt for the GET endpoints to find.
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(validReceipt)))
+	var processed map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &processed); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	s3Results.Store("s3://partner/drop/receipt.json", s3Result{ingestResult: ingestResult{ID: processed["id"], Points: 12}})
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header http.Header
+	}{
+		{name: "process_ok", method: "POST", path: "/receipts/process", body: validReceipt},
+		{name: "process_invalid", method: "POST", path: "/receipts/process", body: `{"retailer": "Target"}`},
+		{name: "process_malformed", method: "POST", path: "/receipts/process", body: `{`},
+		{name: "score_ok", method: "POST", path: "/receipts/score", body: validReceipt},
+		{name: "score_item_points", method: "POST", path: "/receipts/score", body: `{"retailer": "Target", "purchaseDate": "2022-01-02", "purchaseTime": "13:13", "total": "24.25", "items": [{"shortDescription": "Emils Cheese Pizza", "price": "12.25"}, {"shortDescription": "Pepsi - 12-oz", "price": "12.00"}]}`},
+		{name: "score_invalid", method: "POST", path: "/receipts/score", body: `{"retailer": "Target!", "items": [{"shortDescription": "Gum", "price": "1"}]}`},
+		{name: "score_malformed", method: "POST", path: "/receipts/score", body: `{`},
+		{name: "compare_ok", method: "POST", path: "/receipts/compare", body: `{"a": "` + processed["id"] + `", "b": ` + validReceipt + `}`},
+		{name: "compare_invalid", method: "POST", path: "/receipts/compare", body: `{"a": {"retailer": "Target"}}`},
+		{name: "canonicalize_ok", method: "POST", path: "/receipts/canonicalize", body: `{"retailer": "M&M  Corner Market", "purchaseDate": "2022-03-20", "purchaseTime": "14:33", "total": "9.00", "items": [{"shortDescription": "Gatorade", "price": "2.25"}, {"shortDescription": " Emils  Cheese Pizza", "price": "6.75"}], "transactionNumber": "tx-1001"}`},
+		{name: "canonicalize_invalid", method: "POST", path: "/receipts/canonicalize", body: `{"retailer": "Target"}`},
+		{name: "compare_not_found", method: "POST", path: "/receipts/compare", body: `{"a": "does-not-exist", "b": "does-not-exist"}`},
+		{name: "signed_url_not_configured", method: "POST", path: "/receipts/signed-urls", body: `{"account": "alice"}`},
+		{name: "points_ok", method: "GET", path: "/receipts/" + processed["id"] + "/points"},
+		{name: "explain_ok", method: "GET", path: "/receipts/" + processed["id"] + "/points/explain"},
+		{name: "explain_not_found", method: "GET", path: "/receipts/" + unknownReceiptID + "/points/explain"},
+		{name: "points_not_found", method: "GET", path: "/receipts/" + unknownReceiptID + "/points"},
+		{name: "points_malformed_id", method: "GET", path: "/receipts/does-not-exist/points"},
+		{name: "reprocess_without_key", method: "POST", path: "/receipts/" + unknownReceiptID + "/reprocess"},
+		{name: "s3_result_ok", method: "GET", path: "/ingest/s3/results?object=s3://partner/drop/receipt.json"},
+		{name: "s3_result_not_found", method: "GET", path: "/ingest/s3/results?object=s3://partner/nope.json"},
+		{name: "list_unknown_field", method: "GET", path: "/receipts?fields=id,secret"},
+		{name: "list_invalid_limit", method: "GET", path: "/receipts?limit=0"},
+		{name: "balance_not_found", method: "GET", path: "/accounts/nobody/balance"},
+		{name: "merge_unknown_account", method: "POST", path: "/admin/accounts/nobody/merge", body: `{"from": "nobody-else"}`},
+		{name: "merge_invalid", method: "POST", path: "/admin/accounts/nobody/merge", body: `{"from": "nobody"}`},
+		{name: "event_schemas_ok", method: "GET", path: "/events/schemas"},
+		{name: "account_receipts_not_found", method: "GET", path: "/accounts/nobody/receipts"},
+		{name: "erase_not_found", method: "DELETE", path: "/accounts/nobody/data"},
+		{name: "erasure_not_found", method: "GET", path: "/erasures/nothing"},
+		{name: "holds_not_found", method: "GET", path: "/accounts/nobody/holds"},
+		{name: "notifications_get", method: "GET", path: "/accounts/nobody/notifications"},
+		{name: "notifications_invalid_email", method: "PUT", path: "/accounts/nobody/notifications", body: `{"email": "nobody"}`},
+		{name: "statement_invalid_month", method: "GET", path: "/accounts/nobody/statement?month=2022-1"},
+		{name: "stats_hourly_invalid_date", method: "GET", path: "/stats/hourly?from=yesterday"},
+		{name: "statement_not_found", method: "GET", path: "/accounts/nobody/statement?month=2022-01"},
+		{name: "streak_not_found", method: "GET", path: "/accounts/nobody/streak"},
+		{name: "transfer_missing_key", method: "POST", path: "/accounts/nobody/transfer", body: `{"to": "somebody", "points": 1}`},
+		{name: "transfer_unknown_account", method: "POST", path: "/accounts/nobody/transfer", body: `{"to": "somebody", "points": 1}`, header: http.Header{"Idempotency-Key": {"k1"}}},
+		{name: "simulate_ok", method: "POST", path: "/admin/rules/simulate", body: `{"rules": {"oddDay": {"multiplier": 2}}, "receipts": [` + validReceipt + `]}`},
+		{name: "simulate_invalid_rules", method: "POST", path: "/admin/rules/simulate", body: `{"rules": {"keyword": {}}, "receipts": []}`},
+		{name: "admin_backup_not_configured", method: "POST", path: "/admin/backup"},
+		{name: "admin_compact_unsupported", method: "POST", path: "/admin/compact"},
+		{name: "admin_retention_run_invalid", method: "POST", path: "/admin/retention/run?dryRun=maybe"},
+		{name: "admin_recalculation_not_started", method: "GET", path: "/admin/recalculation"},
+		{name: "admin_recalculation_invalid_rate", method: "POST", path: "/admin/recalculation", body: `{"rate": "fast"}`},
+		{name: "admin_key_create_invalid_scope", method: "POST", path: "/admin/keys", body: `{"id": "acme", "scopes": ["superuser"]}`},
+		{name: "admin_key_not_found", method: "GET", path: "/admin/keys/nobody"},
+		{name: "admin_key_usage_not_found", method: "GET", path: "/admin/keys/nobody/usage"},
+		{name: "admin_tap_invalid_status", method: "GET", path: "/admin/tap?status=teapot"},
+		{name: "admin_reload_ok", method: "POST", path: "/admin/config/reload"},
+		{name: "admin_loglevel_get", method: "GET", path: "/admin/loglevel"},
+		{name: "admin_loglevel_invalid", method: "PUT", path: "/admin/loglevel", body: `{"level": "loud"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			if strings.HasPrefix(tc.path, "/admin/") {
+				req.Header.Set("X-API-Key", testAdminSecret)
+			}
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			got, err := json.MarshalIndent(toGolden(rr), "", "    ")
+			if err != nil {
+				t.Fatalf("Failed to marshal response: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "golden", tc.name+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("missing golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response doesn't match %v:\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CustomRule is a scoring rule compiled in on top of the built-in ones. Teams add a file to this package, behind a
+// build tag of their own, that calls registerRule from an init function:
+//
+//	//go:build acme
+//
+//	func init() {
+//		registerRule(CustomRule{Name: "acmeBrand", Description: "...", Points: acmeBrandPoints})
+//	}
+//
+// and build with -tags acme. Custom rules are scored after the built-in ones in registration order, show up in the
+// breakdown, the rule metrics and simulations like any other rule, and are tuned by name in the rules config.
+type CustomRule struct {
+	Name        string
+	Description string
+	// Points is what the rule awards the receipt, with the rule's settings from the rules config for its region. The
+	// multiplier is applied afterwards, settings.Points is the rule's own to interpret.
+	Points func(r *Receipt, settings RuleConfig) int
+	// Explain returns the sentences GET /receipts/{id}/points/explain gives for the points the rule awarded. Without
+	// it the explanation only names the rule.
+	Explain func(r *Receipt, result RuleResult) []string
+}
+
+var ruleName = regexp.MustCompile(`^[a-z][A-Za-z0-9]*$`)
+
+// customRules are the registered rules by name, so the rules config can tell them apart from the built-in ones.
+var customRules = map[string]CustomRule{}
+
+// registerRule adds rule to the scoring rules. It must only be called from init functions, and panics on a rule
+// without a name or points, or with the name of a rule that already exists.
+func registerRule(rule CustomRule) {
+	if !ruleName.MatchString(rule.Name) || rule.Points == nil {
+		panic(fmt.Sprintf("registerRule: rule %q needs a camelCase name and Points", rule.Name))
+	}
+	if knownRule(rule.Name) {
+		panic(fmt.Sprintf("registerRule: rule %q already exists", rule.Name))
+	}
+
+	customRules[rule.Name] = rule
+	pointRules = append(pointRules, scoringRule{name: rule.Name, description: rule.Description, configured: rule.Points})
+	if rule.Explain != nil {
+		ruleExplanations[rule.Name] = rule.Explain
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+// withCustomRule registers rule for the rest of the test.
+func withCustomRule(t *testing.T, rule CustomRule) {
+	rules, explanations := slices.Clone(pointRules), maps.Clone(ruleExplanations)
+	t.Cleanup(func() {
+		pointRules, ruleExplanations = rules, explanations
+		delete(customRules, rule.Name)
+	})
+	registerRule(rule)
+}
+
+func TestCustomRules(t *testing.T) {
+	setup()
+	withCustomRule(t, CustomRule{
+		Name:        "retailerBonus",
+		Description: "The points the rules config sets for the retailer.",
+		Points: func(r *Receipt, settings RuleConfig) int {
+			return settings.Points[r.Retailer]
+		},
+	})
+
+	testCases := []struct {
+		name    string
+		rules   RulesConfig
+		want    int
+		wantErr bool
+	}{
+		{name: "not configured", want: 0},
+		{name: "configured", rules: RulesConfig{"retailerBonus": {Points: map[string]int{"Target": 7}}}, want: 7},
+		{name: "multiplied", rules: RulesConfig{"retailerBonus": {Points: map[string]int{"Target": 7}, Multiplier: 2}}, want: 14},
+		{name: "negative points", rules: RulesConfig{"retailerBonus": {Points: map[string]int{"Target": -1}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rules.Validate(); (err != nil) != tc.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			var receipt Receipt
+			if err := json.Unmarshal(receipttest.New().Retailer("Target").Build().JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			results := receipt.Score(tc.rules)
+			if last := results[len(results)-1]; last.Rule != "retailerBonus" || last.Points != tc.want {
+				t.Errorf("last rule scored %s with %d points, want retailerBonus with %d", last.Rule, last.Points, tc.want)
+			}
+		})
+	}
+
+	live := *currentConfig()
+	live.Rules = RulesConfig{"retailerBonus": {Points: map[string]int{"Target": 1}}}
+	liveConfig.Store(&live)
+	var receipt Receipt
+	json.Unmarshal(receipttest.New().Retailer("Target").Build().JSON(), &receipt)
+	if got := explain(&receipt); got[len(got)-1] != "1 point from the retailerBonus rule." {
+		t.Errorf("explain() = %q, want the custom rule named last", got)
+	}
+}
+
+func TestRegisterRuleRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registerRule() with a built-in rule's name didn't panic")
+		}
+	}()
+	registerRule(CustomRule{Name: "oddDay", Points: func(*Receipt, RuleConfig) int { return 0 }})
+}
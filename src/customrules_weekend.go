@@ -0,0 +1,25 @@
+//go:build examplerules
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// weekendPurchase is an example of a custom rule, built with -tags examplerules.
+func init() {
+	registerRule(CustomRule{
+		Name:        "weekendPurchase",
+		Description: "5 points if the purchase date is a Saturday or Sunday.",
+		Points: func(r *Receipt, settings RuleConfig) int {
+			if day := r.PurchaseDate.Weekday(); day == time.Saturday || day == time.Sunday {
+				return 5
+			}
+			return 0
+		},
+		Explain: func(r *Receipt, result RuleResult) []string {
+			return []string{fmt.Sprintf("%s because the purchase was made on a %s.", pointsText(result.Points), r.PurchaseDate.Weekday())}
+		},
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// groupByAccount groups /aggregate by the account receipts were credited to. Only the daily aggregates know that, SQL
+// aggregation doesn't.
+const groupByAccount store.AggregateField = "account"
+
+const defaultAggregatesRepairInterval = 24 * time.Hour
+
+// AggregatesConfig schedules the repair of the daily aggregates the store keeps, a rebuild from all the stored
+// receipts. RepairInterval is a day by default.
+type AggregatesConfig struct {
+	RepairInterval Duration `json:"repairInterval"`
+}
+
+func (c AggregatesConfig) Validate() error {
+	if c.RepairInterval < 0 {
+		return fmt.Errorf("aggregates: repairInterval must not be negative")
+	}
+	return nil
+}
+
+var (
+	errRebuildRunning    = errors.New("the daily aggregates are being rebuilt already")
+	errNoDailyAggregates = errors.New("the store backend doesn't keep daily aggregates")
+)
+
+var (
+	aggregatesRebuildsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_daily_aggregates_rebuilds_total",
+		Help: "Rebuilds of the daily aggregates, by result (success or failure).",
+	}, []string{"result"})
+
+	aggregatesDriftedTotal = metrics.NewCounter(prometheus.CounterOpts{
+		Name: "fcpc_daily_aggregates_drifted_buckets_total",
+		Help: "Daily buckets a rebuild found to differ from what was maintained at write time.",
+	})
+)
+
+// AggregatesRebuild is the outcome of a rebuild. Drifted counts the daily buckets that differed from what was
+// maintained at write time, e.g. after receipts were edited in the database by hand.
+type AggregatesRebuild struct {
+	At       time.Time `json:"at"`
+	Took     Duration  `json:"took"`
+	Receipts int       `json:"receipts"`
+	Buckets  int       `json:"buckets"`
+	Drifted  int       `json:"drifted"`
+}
+
+// rebuilding is held by the rebuild that is running, if any.
+var rebuilding sync.Mutex
+
+// rebuildAggregates has the store work its daily aggregates out again from the receipts.
+func rebuildAggregates(ctx context.Context) (AggregatesRebuild, error) {
+	aggregator, ok := store.Unwrap(receiptStore).(store.DailyAggregator)
+	if !ok {
+		return AggregatesRebuild{}, errNoDailyAggregates
+	}
+	if !rebuilding.TryLock() {
+		return AggregatesRebuild{}, errRebuildRunning
+	}
+	defer rebuilding.Unlock()
+
+	run := AggregatesRebuild{At: time.Now().UTC()}
+	rebuilt, err := aggregator.RebuildDaily(ctx)
+	if err != nil {
+		aggregatesRebuildsTotal.WithLabelValues("failure").Inc()
+		return AggregatesRebuild{}, err
+	}
+	run.Receipts, run.Buckets, run.Drifted = rebuilt.Receipts, rebuilt.Buckets, rebuilt.Drifted
+	run.Took = Duration(time.Since(run.At))
+	aggregatesRebuildsTotal.WithLabelValues("success").Inc()
+	aggregatesDriftedTotal.Add(float64(run.Drifted))
+	return run, nil
+}
+
+// creditDaily moves a stored receipt to the account it was credited to in the daily aggregates. The credit goes
+// through replication, if it is on, so standbys have the account totals too.
+func creditDaily(ctx context.Context, receiptID, account string) {
+	var aggregator interface {
+		CreditReceipt(ctx context.Context, id, account string) error
+	}
+	if replicating, ok := receiptStore.(*store.Replicating); ok {
+		aggregator = replicating
+	} else if daily, ok := store.Unwrap(receiptStore).(store.DailyAggregator); ok {
+		aggregator = daily
+	} else {
+		return
+	}
+	if err := aggregator.CreditReceipt(ctx, receiptID, account); err != nil && !errors.Is(err, store.ErrNotFound) {
+		logger.Error("Failed to credit receipt in the daily aggregates", zap.String("receiptID", receiptID), zap.String("account", account), zap.Error(err))
+	}
+}
+
+// dailyTotals reads the store's daily aggregates of dim between the two inclusive dates ("" for no bound). Backends
+// that don't keep them are scanned, without accounts.
+func dailyTotals(ctx context.Context, dim store.DailyDimension, from, to string) ([]store.DailyTotals, error) {
+	if aggregator, ok := store.Unwrap(receiptStore).(store.DailyAggregator); ok {
+		return aggregator.Daily(ctx, dim, from, to)
+	}
+	return store.ScanDaily(ctx, receiptStore, dim, from, to)
+}
+
+// aggregateDaily answers q from the daily aggregates. Accounts are as they were credited and follow merges here.
+func aggregateDaily(ctx context.Context, q store.AggregateQuery) ([]store.AggregateBucket, error) {
+	dim := store.DailyRetailer
+	switch q.GroupBy {
+	case store.GroupByPaymentMethod:
+		dim = store.DailyPaymentMethod
+	case store.GroupByPostalCode:
+		dim = store.DailyLocation
+	case groupByAccount:
+		dim = store.DailyAccount
+	}
+	days, err := dailyTotals(ctx, dim, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ group, period string }
+	sums := map[key]int64{}
+	for _, t := range days {
+		var k key
+		switch q.GroupBy {
+		case store.GroupByRetailer, store.GroupByPaymentMethod:
+			k.group = t.Group
+		case store.GroupByPostalCode:
+			var location StoreLocation
+			json.Unmarshal([]byte(t.Group), &location)
+			k.group = location.PostalCode
+		case groupByAccount:
+			if t.Group != "" {
+				k.group = pointsLedger.Resolve(t.Group)
+			}
+		}
+		k.period = q.Period.Of(t.Date)
+		switch q.Metric {
+		case store.MetricPoints:
+			sums[k] += t.Points
+		case store.MetricTotal:
+			sums[k] += t.Cents
+		default:
+			sums[k] += t.Receipts
+		}
+	}
+
+	buckets := make([]store.AggregateBucket, 0, len(sums))
+	for k, sum := range sums {
+		buckets = append(buckets, store.AggregateBucket{Group: k.group, Period: k.period, Value: sum})
+	}
+	slices.SortFunc(buckets, func(a, b store.AggregateBucket) int {
+		return cmp.Or(cmp.Compare(a.Period, b.Period), cmp.Compare(a.Group, b.Group))
+	})
+	return buckets, nil
+}
+
+// repairAggregates rebuilds the daily aggregates, logging how far off they were.
+func repairAggregates(ctx context.Context) (AggregatesRebuild, error) {
+	run, err := rebuildAggregates(ctx)
+	if err != nil {
+		return run, err
+	}
+	if run.Drifted > 0 {
+		logger.Warn("Daily aggregates had drifted from the store", zap.Int("drifted", run.Drifted), zap.Int("receipts", run.Receipts))
+	}
+	logger.Info("Rebuilt daily aggregates", zap.Int("receipts", run.Receipts), zap.Int("buckets", run.Buckets), zap.Duration("took", time.Duration(run.Took)))
+	return run, nil
+}
+
+// startAggregatesRepair rebuilds the daily aggregates every interval. The store keeps them across restarts, so the
+// first rebuild is an interval after startup.
+func startAggregatesRepair(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultAggregatesRepairInterval
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		runPeriodically(ctx, interval, func(ctx context.Context) {
+			if _, err := repairAggregates(ctx); err != nil && !errors.Is(err, errRebuildRunning) && !errors.Is(err, errNoDailyAggregates) {
+				logger.Error("Failed to rebuild daily aggregates", zap.Error(err))
+			}
+		})
+	}()
+}
+
+// rebuildAggregatesHandler serves POST /admin/aggregates/rebuild.
+func rebuildAggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	run, err := repairAggregates(r.Context())
+	if errors.Is(err, errRebuildRunning) {
+		http.Error(w, "The daily aggregates are being rebuilt already.", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errNoDailyAggregates) {
+		http.Error(w, "The store backend doesn't keep daily aggregates.", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to rebuild daily aggregates", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(run)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
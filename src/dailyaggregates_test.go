@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func postRebuild(t *testing.T, router http.Handler) AggregatesRebuild {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/admin/aggregates/rebuild", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /admin/aggregates/rebuild = %v %s, want 200", rr.Code, rr.Body)
+	}
+	var run AggregatesRebuild
+	json.Unmarshal(rr.Body.Bytes(), &run)
+	return run
+}
+
+func TestDailyAggregates(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Retailer("Target").PurchaseDate("2022-01-03").Item("Dasani", "1.40").Build())
+	submitForAccount(t, router, "bob", receipttest.New().Retailer("Walgreens").PurchaseDate("2022-01-04").Item("Dasani", "2.25").Build())
+	submitForAccount(t, router, "", receipttest.New().Retailer("Target").PurchaseDate("2022-01-10").Item("Dasani", "1.40").Build())
+	if run := postRebuild(t, router); run.Receipts != 3 || run.Drifted != 0 {
+		t.Fatalf("rebuild = %+v, want 3 receipts and nothing drifted", run)
+	}
+
+	// written after the rebuild, so only kept up to date at write time.
+	submitForAccount(t, router, "carol", receipttest.New().Retailer("Target").PurchaseDate("2022-01-04").Item("Dasani", "1.40").Build())
+	submitForAccount(t, router, "carol", receipttest.New().Retailer("Walgreens").PurchaseDate("2022-02-01").Item("Dasani", "1.40").Build())
+	if err := receiptStore.Delete(context.Background(), pointsLedger.Receipts("bob")[0]); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/admin/accounts/alice/merge", bytes.NewBufferString(`{"from": "carol"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merging carol into alice = %v %s", rr.Code, rr.Body)
+	}
+
+	testCases := []struct {
+		name  string
+		query string
+		want  []AggregateBucket
+	}{
+		{name: "receipts per account", query: "?groupBy=account", want: []AggregateBucket{
+			{Group: "", Value: "1"},
+			{Group: "alice", Value: "3"},
+		}},
+		{name: "total per account and month", query: "?groupBy=account&metric=total&period=month", want: []AggregateBucket{
+			{Group: "", Period: "2022-01", Value: "1.40"},
+			{Group: "alice", Period: "2022-01", Value: "2.80"},
+			{Group: "alice", Period: "2022-02", Value: "1.40"},
+		}},
+		{name: "receipts per retailer and week", query: "?groupBy=retailer&period=week&to=2022-01-31", want: []AggregateBucket{
+			{Group: "Target", Period: "2022-01-03", Value: "2"},
+			{Group: "Target", Period: "2022-01-10", Value: "1"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/aggregate"+tc.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var resp aggregateResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if !reflect.DeepEqual(resp.Buckets, tc.want) {
+				t.Errorf("buckets = %+v, want %+v", resp.Buckets, tc.want)
+			}
+		})
+	}
+
+	// whatever they answer, a scan of the store has to agree with.
+	for _, q := range []store.AggregateQuery{
+		{Metric: store.MetricPoints, Period: store.PeriodDay},
+		{GroupBy: store.GroupByRetailer, Metric: store.MetricTotal, Period: store.PeriodWeek},
+		{GroupBy: store.GroupByRetailer, Metric: store.MetricReceipts, From: "2022-01-04", To: "2022-01-31"},
+		{GroupBy: store.GroupByPaymentMethod, Metric: store.MetricPoints, Period: store.PeriodMonth},
+		{GroupBy: store.GroupByPostalCode, Metric: store.MetricReceipts},
+	} {
+		daily, err := aggregateDaily(context.Background(), q)
+		if err != nil {
+			t.Fatalf("aggregateDaily(%+v) error = %v", q, err)
+		}
+		scanned, err := store.ScanAggregate(context.Background(), receiptStore, q)
+		if err != nil {
+			t.Fatalf("ScanAggregate(%+v) error = %v", q, err)
+		}
+		if !reflect.DeepEqual(daily, scanned) {
+			t.Errorf("aggregateDaily(%+v) = %+v, a scan says %+v", q, daily, scanned)
+		}
+	}
+	if run := postRebuild(t, router); run.Receipts != 4 || run.Drifted != 0 {
+		t.Errorf("rebuild = %+v, want 4 receipts and nothing drifted", run)
+	}
+}
+
+func TestDailyAggregatesWithoutBackendSupport(t *testing.T) {
+	router := setup()
+	receiptStore = storetest.NewFake(store.Record{ID: "r1", Points: 10,
+		Receipt: receipttest.New().Retailer("Target").PurchaseDate("2022-01-03").Build().JSON()})
+
+	// the store is scanned instead, it doesn't know the accounts.
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/aggregate?groupBy=account&metric=points", nil))
+	var resp aggregateResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if want := []AggregateBucket{{Group: "", Value: "10"}}; rr.Code != http.StatusOK || !reflect.DeepEqual(resp.Buckets, want) {
+		t.Errorf("GET /aggregate = %v %+v, want 200 %+v", rr.Code, resp.Buckets, want)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/admin/aggregates/rebuild", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("POST /admin/aggregates/rebuild = %v, want 501", rr.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// debugLogger logs at debug level whatever the global level, for requests made with X-Debug.
+var debugLogger *zap.Logger
+
+// debugScope lets a managed API key debug its own requests. Static keys may always.
+const debugScope = "debug"
+
+// requestDebug collects the diagnostics of a request made with X-Debug: true. Its ID is on every log line the request
+// writes and in the X-Debug-ID response header, so the two can be matched up.
+type requestDebug struct {
+	ID        string         `json:"id"`
+	TookMS    float64        `json:"tookMs"`
+	Stages    []debugStage   `json:"stages,omitempty"`
+	Breakdown []debugRuleRun `json:"breakdown,omitempty"`
+
+	start  time.Time
+	logger *zap.Logger
+	mu     sync.Mutex
+}
+
+// debugStage is a stage of the request, with the error it failed with, if any.
+type debugStage struct {
+	Name   string  `json:"name"`
+	TookMS float64 `json:"tookMs"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type debugRuleRun struct {
+	Rule   string  `json:"rule"`
+	Points int     `json:"points"`
+	TookMS float64 `json:"tookMs"`
+}
+
+// milliseconds keeps the timings ASCII for the header, unlike time.Duration's µs.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type debugContextKey struct{}
+
+func debugFrom(ctx context.Context) *requestDebug {
+	d, _ := ctx.Value(debugContextKey{}).(*requestDebug)
+	return d
+}
+
+// loggerFor returns the logger of the request behind ctx: the debug logger for requests made with X-Debug, the
+// global one otherwise.
+func loggerFor(ctx context.Context) *zap.Logger {
+	if d := debugFrom(ctx); d != nil {
+		return d.logger
+	}
+	return logger
+}
+
+// stage records that the stage name of a debugged request took took and failed with err, if it isn't nil. It does
+// nothing for other requests.
+func (d *requestDebug) stage(name string, took time.Duration, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stage := debugStage{Name: name, TookMS: milliseconds(took)}
+	if err != nil {
+		stage.Error = err.Error()
+	}
+	d.Stages = append(d.Stages, stage)
+}
+
+// scored records the rule breakdown of a debugged request.
+func (d *requestDebug) scored(results []RuleResult) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Breakdown = d.Breakdown[:0]
+	for _, result := range results {
+		d.Breakdown = append(d.Breakdown, debugRuleRun{Rule: result.Rule, Points: result.Points, TookMS: milliseconds(result.took)})
+	}
+}
+
+// debugWriter adds the diagnostics collected so far as headers when the response starts.
+type debugWriter struct {
+	http.ResponseWriter
+	debug       *requestDebug
+	status      int
+	wroteHeader bool
+}
+
+func (w *debugWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader, w.status = true, status
+		d := w.debug
+		d.mu.Lock()
+		d.TookMS = milliseconds(time.Since(d.start))
+		info, err := json.Marshal(d)
+		d.mu.Unlock()
+		w.Header().Set("X-Debug-ID", d.ID)
+		if err == nil {
+			w.Header().Set("X-Debug-Info", string(info))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *debugWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *debugWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveDebug serves a request made with X-Debug: true by the API key keyID. The request logs at debug level on its
+// own, the global level stays as it is, and the response carries its stage timings and rule breakdown in the
+// X-Debug-Info header.
+func serveDebug(w http.ResponseWriter, r *http.Request, keyID string, next http.Handler) {
+	d := &requestDebug{ID: uuid.New().String(), start: time.Now()}
+	d.logger = debugLogger.With(zap.String("debugID", d.ID), zap.String("apiKey", keyID))
+	d.logger.Debug("Debugging request", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("clientIP", clientIPFrom(r.Context())))
+
+	dw := &debugWriter{ResponseWriter: w, debug: d}
+	next.ServeHTTP(dw, r.WithContext(context.WithValue(r.Context(), debugContextKey{}, d)))
+	d.logger.Debug("Debugged request served", zap.Int("status", dw.status), zap.Duration("took", time.Since(d.start)))
+}
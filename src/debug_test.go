@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDebugRequests(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"ops": "ops-secret-0123456789", "test-admin": testAdminSecret}, AdminKeys: []string{"test-admin"}}
+	liveConfig.Store(&live)
+	noDebug := adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "partner", "scopes": ["receipts:write"]}`, http.StatusCreated)
+	withDebug := adminKeyRequest(t, router, "POST", "/admin/keys", `{"id": "support", "scopes": ["receipts:write", "debug"]}`, http.StatusCreated)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	debugLogger = zap.New(core)
+	atomicLevel.SetLevel(zapcore.InfoLevel)
+
+	testCases := []struct {
+		name       string
+		path       string
+		key        string
+		debug      string
+		wantStatus int
+		wantStages []string
+	}{
+		{name: "static key", path: "/receipts/process", key: "ops-secret-0123456789", debug: "true", wantStatus: http.StatusOK, wantStages: []string{"decode", "score", "store", "review"}},
+		{name: "managed key with the scope", path: "/receipts/score", key: withDebug.Secret, debug: "true", wantStatus: http.StatusOK, wantStages: []string{"score"}},
+		{name: "managed key without the scope", path: "/receipts/process", key: noDebug.Secret, debug: "true", wantStatus: http.StatusForbidden},
+		{name: "no key", path: "/receipts/process", debug: "true", wantStatus: http.StatusUnauthorized},
+		{name: "not asked for", path: "/receipts/process", key: "ops-secret-0123456789", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.TakeAll()
+			req := httptest.NewRequest("POST", tc.path, bytes.NewReader(receipttest.New().Build().JSON()))
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			if tc.debug != "" {
+				req.Header.Set("X-Debug", tc.debug)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST %s = %v %s, want %v", tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+
+			id := rr.Header().Get("X-Debug-ID")
+			if tc.wantStages == nil {
+				if id != "" || logs.Len() > 0 {
+					t.Errorf("X-Debug-ID = %q with %d debug log lines, want no debugging", id, logs.Len())
+				}
+				return
+			}
+			var info requestDebug
+			if err := json.Unmarshal([]byte(rr.Header().Get("X-Debug-Info")), &info); err != nil {
+				t.Fatalf("X-Debug-Info: %v", err)
+			}
+			var stages []string
+			for _, s := range info.Stages {
+				stages = append(stages, s.Name)
+			}
+			if info.ID != id || len(info.Breakdown) != len(pointRules) || !slices.Equal(stages, tc.wantStages) {
+				t.Errorf("X-Debug-Info = %s, want ID %s, stages %v and every rule", rr.Header().Get("X-Debug-Info"), id, tc.wantStages)
+			}
+			// the request logged at debug level, with the debug ID, while the global level stayed at info.
+			if n := logs.FilterField(zap.String("debugID", id)).FilterMessage("Debugging request").Len(); n != 1 {
+				t.Errorf("got %d debug log lines for %s, want the request's", n, id)
+			}
+			if logger.Core().Enabled(zapcore.DebugLevel) {
+				t.Errorf("the global logger logs at debug level")
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/receiptpb"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const formMediaType = "application/x-www-form-urlencoded"
+
+// decodeReceipt decodes a submitted receipt in the format its Content-Type names, JSON unless it names another. Every
+// format goes through Receipt's UnmarshalJSON, so they are limited and validated alike. JSON receipts may be shorthand
+// for a template, see expandTemplate. A body over the limit fails with an *http.MaxBytesError.
+func decodeReceipt(w http.ResponseWriter, r *http.Request) (Receipt, error) {
+	limits := currentConfig().ReceiptLimits
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.maxBodyBytes()))
+
+	var dto ReceiptDTO
+	var err error
+	switch receiptFormat(r) {
+	case "form":
+		dto, err = parseReceiptForm(r)
+	case "xml":
+		dto, err = decodeReceiptXML(r.Body)
+	case "protobuf":
+		dto, err = decodeReceiptProto(r.Body)
+	default:
+		// the items are checked as they are read, what was read is decoded again with the rest of the body.
+		var read bytes.Buffer
+		if err := checkReceiptStream(io.TeeReader(r.Body, &read), limits); err != nil {
+			return Receipt{}, err
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(io.MultiReader(&read, r.Body)).Decode(&raw); err != nil {
+			return Receipt{}, err
+		}
+		b, err := expandTemplate(raw)
+		if err != nil {
+			return Receipt{}, err
+		}
+		var receipt Receipt
+		err = json.Unmarshal(b, &receipt)
+		return receipt, err
+	}
+	if err != nil {
+		return Receipt{}, err
+	}
+	b, err := json.Marshal(dto)
+	if err != nil {
+		return Receipt{}, err
+	}
+	var receipt Receipt
+	err = json.Unmarshal(b, &receipt)
+	return receipt, err
+}
+
+// receiptFormat is the format of the receipt in the body by its Content-Type: form, xml, protobuf or json, the default.
+func receiptFormat(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case formMediaType:
+		return "form"
+	case "application/xml", "text/xml":
+		return "xml"
+	case "application/x-protobuf", "application/protobuf":
+		return "protobuf"
+	default:
+		return "json"
+	}
+}
+
+var formItemField = regexp.MustCompile(`^items\[(\d+)\]\.(shortDescription|price)$`)
+
+// parseReceiptForm maps a form-encoded receipt onto a ReceiptDTO, for kiosks that can't send JSON. Fields are named
+// like the JSON ones, nested ones with dots and items by index: retailer=Target&items[0].shortDescription=Gatorade&
+// items[0].price=2.25&storeLocation.postalCode=10001&metadata.channel=app. Fields it doesn't know are ignored, like
+// unknown JSON fields.
+func parseReceiptForm(r *http.Request) (ReceiptDTO, error) {
+	if err := r.ParseForm(); err != nil {
+		return ReceiptDTO{}, err
+	}
+	form := r.PostForm
+	dto := ReceiptDTO{
+		Retailer:          form.Get("retailer"),
+		PurchaseDate:      form.Get("purchaseDate"),
+		PurchaseTime:      form.Get("purchaseTime"),
+		Total:             form.Get("total"),
+		TransactionNumber: form.Get("transactionNumber"),
+		PaymentMethod:     form.Get("paymentMethod"),
+	}
+
+	location := StoreLocation{PostalCode: form.Get("storeLocation.postalCode")}
+	locationErrs := validation.Errors{}
+	for field, dst := range map[string]**float64{"latitude": &location.Latitude, "longitude": &location.Longitude} {
+		value := form.Get("storeLocation." + field)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			locationErrs[field] = validation.NewError("validation_is_float", "must be a number")
+			continue
+		}
+		*dst = &f
+	}
+	if len(locationErrs) > 0 {
+		return ReceiptDTO{}, validation.Errors{"storeLocation": locationErrs}
+	}
+	if location != (StoreLocation{}) {
+		dto.StoreLocation = &location
+	}
+
+	maxItems := currentConfig().ReceiptLimits.maxItems()
+	for key := range form {
+		if name, ok := strings.CutPrefix(key, "metadata."); ok {
+			if dto.Metadata == nil {
+				dto.Metadata = map[string]string{}
+			}
+			dto.Metadata[name] = form.Get(key)
+			continue
+		}
+		match := formItemField.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index >= maxItems {
+			return ReceiptDTO{}, tooManyItemsError(maxItems)
+		}
+		for len(dto.Items) <= index {
+			dto.Items = append(dto.Items, ItemDTO{})
+		}
+		switch match[2] {
+		case "shortDescription":
+			dto.Items[index].ShortDescription = form.Get(key)
+		case "price":
+			dto.Items[index].Price = form.Get(key)
+		}
+	}
+	return dto, nil
+}
+
+// xmlReceipt is the XML form of a ReceiptDTO, for partners whose middleware can only send XML. Elements are named
+// like the JSON fields, and every item is an <item> in <items>:
+//
+//	<receipt>
+//	  <retailer>Target</retailer>
+//	  <purchaseDate>2022-01-01</purchaseDate>
+//	  <purchaseTime>13:01</purchaseTime>
+//	  <items><item><shortDescription>Gatorade</shortDescription><price>2.25</price></item></items>
+//	  <total>2.25</total>
+//	  <metadata><entry key="channel">app</entry></metadata>
+//	</receipt>
+type xmlReceipt struct {
+	XMLName           xml.Name       `xml:"receipt"`
+	Retailer          string         `xml:"retailer"`
+	PurchaseDate      string         `xml:"purchaseDate"`
+	PurchaseTime      string         `xml:"purchaseTime"`
+	Items             xmlItems       `xml:"items"`
+	Total             string         `xml:"total"`
+	StoreLocation     *StoreLocation `xml:"storeLocation"`
+	TransactionNumber string         `xml:"transactionNumber"`
+	PaymentMethod     string         `xml:"paymentMethod"`
+	Metadata          []xmlEntry     `xml:"metadata>entry"`
+}
+
+// xmlItems are the <item>s of <items>, counted as they are read so a receipt with too many is turned away before the
+// rest are decoded.
+type xmlItems []ItemDTO
+
+func (items *xmlItems) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	maxItems := currentConfig().ReceiptLimits.maxItems()
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local != "item" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(*items) == maxItems {
+				return tooManyItemsError(maxItems)
+			}
+			var item ItemDTO
+			if err := d.DecodeElement(&item, &tok); err != nil {
+				return err
+			}
+			*items = append(*items, item)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type xmlEntry struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// decodeReceiptXML reads an xmlReceipt off r. Unknown elements are ignored, like unknown JSON fields.
+func decodeReceiptXML(r io.Reader) (ReceiptDTO, error) {
+	var x xmlReceipt
+	if err := xml.NewDecoder(r).Decode(&x); err != nil {
+		return ReceiptDTO{}, err
+	}
+	dto := ReceiptDTO{
+		Retailer:          x.Retailer,
+		PurchaseDate:      x.PurchaseDate,
+		PurchaseTime:      x.PurchaseTime,
+		Items:             x.Items,
+		Total:             x.Total,
+		StoreLocation:     x.StoreLocation,
+		TransactionNumber: x.TransactionNumber,
+		PaymentMethod:     x.PaymentMethod,
+	}
+	if len(x.Metadata) > 0 {
+		dto.Metadata = make(map[string]string, len(x.Metadata))
+		for _, entry := range x.Metadata {
+			dto.Metadata[entry.Key] = entry.Value
+		}
+	}
+	return dto, nil
+}
+
+// protoItemsField is the field number of Receipt.items in receipt.proto.
+const protoItemsField protowire.Number = 4
+
+// decodeReceiptProto reads a receiptpb.Receipt off r, at most receiptLimits.maxBodyBytes of it. The items are counted
+// on the wire before the message is unmarshalled, so one with too many is turned away without decoding them.
+func decodeReceiptProto(r io.Reader) (ReceiptDTO, error) {
+	limits := currentConfig().ReceiptLimits
+	b, err := io.ReadAll(io.LimitReader(r, int64(limits.maxBodyBytes())+1))
+	if err != nil {
+		return ReceiptDTO{}, err
+	}
+	if len(b) > limits.maxBodyBytes() {
+		return ReceiptDTO{}, &http.MaxBytesError{Limit: int64(limits.maxBodyBytes())}
+	}
+	if countProtoItems(b) > limits.maxItems() {
+		return ReceiptDTO{}, tooManyItemsError(limits.maxItems())
+	}
+	var pb receiptpb.Receipt
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		return ReceiptDTO{}, err
+	}
+	dto := ReceiptDTO{
+		Retailer:          pb.Retailer,
+		PurchaseDate:      pb.PurchaseDate,
+		PurchaseTime:      pb.PurchaseTime,
+		Items:             make([]ItemDTO, len(pb.Items)),
+		Total:             pb.Total,
+		TransactionNumber: pb.TransactionNumber,
+		PaymentMethod:     pb.PaymentMethod,
+	}
+	for i, item := range pb.Items {
+		dto.Items[i] = ItemDTO{ShortDescription: item.GetShortDescription(), Price: item.GetPrice()}
+	}
+	if location := pb.StoreLocation; location != nil {
+		dto.StoreLocation = &StoreLocation{PostalCode: location.PostalCode, Latitude: location.Latitude, Longitude: location.Longitude}
+	}
+	return dto, nil
+}
+
+// countProtoItems counts the items of an encoded receipt, up to anything malformed, which proto.Unmarshal reports.
+func countProtoItems(b []byte) int {
+	items := 0
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return items
+		}
+		b = b[n:]
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			return items
+		}
+		b = b[n:]
+		if num == protoItemsField {
+			items++
+		}
+	}
+	return items
+}
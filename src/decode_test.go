@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receiptpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFormSubmission(t *testing.T) {
+	readmeExample := url.Values{
+		"retailer":                      {"Target"},
+		"purchaseDate":                  {"2022-01-01"},
+		"purchaseTime":                  {"13:01"},
+		"items[0].shortDescription":     {"Mountain Dew 12PK"},
+		"items[0].price":                {"6.49"},
+		"items[1].shortDescription":     {"Emils Cheese Pizza"},
+		"items[1].price":                {"12.25"},
+		"items[2].shortDescription":     {"Knorr Creamy Chicken"},
+		"items[2].price":                {"1.26"},
+		"items[3].shortDescription":     {"Doritos Nacho Cheese"},
+		"items[3].price":                {"3.35"},
+		"items[4].shortDescription":     {"   Klarbrunn 12-PK 12 FL OZ  "},
+		"items[4].price":                {"12.00"},
+		"total":                         {"35.35"},
+		"storeLocation.postalCode":      {"10001"},
+		"storeLocation.latitude":        {"40.75"},
+		"storeLocation.longitude":       {"-73.99"},
+		"some kiosk field we don't use": {"1"},
+	}
+	with := func(key, value string) string {
+		form := url.Values{}
+		for k, v := range readmeExample {
+			form[k] = v
+		}
+		form.Set(key, value)
+		return form.Encode()
+	}
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantPoints int64
+	}{
+		{name: "readme example 1", body: readmeExample.Encode(), wantStatus: http.StatusOK, wantPoints: 28},
+		{name: "validated like JSON", body: with("purchaseTime", "25:00"), wantStatus: http.StatusBadRequest},
+		{name: "item with a missing price", body: with("items[5].shortDescription", "Dasani"), wantStatus: http.StatusBadRequest},
+		{name: "item index past the limit", body: with("items[100000].price", "1.00"), wantStatus: http.StatusBadRequest},
+		{name: "latitude not a number", body: with("storeLocation.latitude", "north"), wantStatus: http.StatusBadRequest},
+		{name: "no items", body: "retailer=Target&purchaseDate=2022-01-01&purchaseTime=13:01&total=0.00", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Points != tc.wantPoints {
+				t.Errorf("points = %v, want %v", rec.Points, tc.wantPoints)
+			}
+			var stored ReceiptDTO
+			json.Unmarshal(rec.Receipt, &stored)
+			if len(stored.Items) != 5 || stored.Items[4].ShortDescription != "   Klarbrunn 12-PK 12 FL OZ  " || stored.StoreLocation == nil || *stored.StoreLocation.Longitude != -73.99 {
+				t.Errorf("stored receipt = %+v, want the form's fields", stored)
+			}
+		})
+	}
+}
+
+func TestXMLSubmission(t *testing.T) {
+	const readmeExample = `<?xml version="1.0" encoding="UTF-8"?>
+<receipt>
+  <retailer>Target</retailer>
+  <purchaseDate>2022-01-01</purchaseDate>
+  <purchaseTime>13:01</purchaseTime>
+  <items>
+    <item><shortDescription>Mountain Dew 12PK</shortDescription><price>6.49</price></item>
+    <item><shortDescription>Emils Cheese Pizza</shortDescription><price>12.25</price></item>
+    <item><shortDescription>Knorr Creamy Chicken</shortDescription><price>1.26</price></item>
+    <item><shortDescription>Doritos Nacho Cheese</shortDescription><price>3.35</price></item>
+    <item><shortDescription>   Klarbrunn 12-PK 12 FL OZ  </shortDescription><price>12.00</price></item>
+  </items>
+  <total>35.35</total>
+  <storeLocation><postalCode>10001</postalCode><latitude>40.75</latitude><longitude>-73.99</longitude></storeLocation>
+  <kioskId>7</kioskId>
+</receipt>`
+
+	testCases := []struct {
+		name        string
+		body        string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "readme example 1", body: readmeExample, contentType: "application/xml", wantStatus: http.StatusOK},
+		{name: "text/xml", body: readmeExample, contentType: "text/xml; charset=utf-8", wantStatus: http.StatusOK},
+		{name: "validated like JSON", body: strings.Replace(readmeExample, "13:01", "25:00", 1), contentType: "application/xml", wantStatus: http.StatusBadRequest},
+		{name: "over the item limit", body: readmeExample, contentType: "application/xml", wantStatus: http.StatusBadRequest},
+		{name: "latitude not a number", body: strings.Replace(readmeExample, "40.75", "north", 1), contentType: "application/xml", wantStatus: http.StatusBadRequest},
+		{name: "another root element", body: "<order><retailer>Target</retailer></order>", contentType: "application/xml", wantStatus: http.StatusBadRequest},
+		{name: "malformed", body: "<receipt><retailer>Target</receipt>", contentType: "application/xml", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			if tc.name == "over the item limit" {
+				live := cfg
+				live.ReceiptLimits = ReceiptLimitsConfig{MaxItems: 4}
+				liveConfig.Store(&live)
+			}
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Points != 28 {
+				t.Errorf("points = %v, want 28", rec.Points)
+			}
+			var stored ReceiptDTO
+			json.Unmarshal(rec.Receipt, &stored)
+			if len(stored.Items) != 5 || stored.Items[4].ShortDescription != "   Klarbrunn 12-PK 12 FL OZ  " || stored.StoreLocation == nil || stored.StoreLocation.PostalCode != "10001" {
+				t.Errorf("stored receipt = %+v, want the XML's fields", stored)
+			}
+		})
+	}
+}
+
+func TestProtobufSubmission(t *testing.T) {
+	latitude, longitude := 40.75, -73.99
+	readmeExample := func() *receiptpb.Receipt {
+		return &receiptpb.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items: []*receiptpb.Item{
+				{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+				{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+				{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+				{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+				{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+			},
+			Total:         "35.35",
+			StoreLocation: &receiptpb.StoreLocation{PostalCode: "10001", Latitude: &latitude, Longitude: &longitude},
+		}
+	}
+	encode := func(change func(*receiptpb.Receipt)) []byte {
+		pb := readmeExample()
+		change(pb)
+		b, err := proto.Marshal(pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	testCases := []struct {
+		name       string
+		body       []byte
+		wantStatus int
+	}{
+		{name: "readme example 1", body: encode(func(*receiptpb.Receipt) {}), wantStatus: http.StatusOK},
+		{name: "validated like JSON", body: encode(func(pb *receiptpb.Receipt) { pb.Total = "35.3" }), wantStatus: http.StatusBadRequest},
+		{name: "no items", body: encode(func(pb *receiptpb.Receipt) { pb.Items = nil }), wantStatus: http.StatusBadRequest},
+		{name: "not protobuf", body: []byte("{\"retailer\": \"Target\"}"), wantStatus: http.StatusBadRequest},
+		{name: "over the item limit", body: encode(func(pb *receiptpb.Receipt) { pb.Items = append(pb.Items, pb.Items...) }), wantStatus: http.StatusBadRequest},
+		{name: "over the body limit", body: encode(func(pb *receiptpb.Receipt) { pb.Retailer = strings.Repeat("T", 2000) }), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.ReceiptLimits = ReceiptLimitsConfig{MaxItems: 5, MaxBodyBytes: 1000}
+			liveConfig.Store(&live)
+			t.Cleanup(func() { liveConfig.Store(&cfg) })
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Points != 28 {
+				t.Errorf("points = %v, want 28", rec.Points)
+			}
+			var stored ReceiptDTO
+			json.Unmarshal(rec.Receipt, &stored)
+			if len(stored.Items) != 5 || stored.StoreLocation == nil || *stored.StoreLocation.Latitude != latitude {
+				t.Errorf("stored receipt = %+v, want the message's fields", stored)
+			}
+		})
+	}
+}
+
+// endlessXMLItems is an XML receipt whose items never end.
+type endlessXMLItems struct{ started bool }
+
+func (r *endlessXMLItems) Read(p []byte) (int, error) {
+	chunk := `<item><shortDescription>Gatorade</shortDescription><price>1.00</price></item>`
+	if !r.started {
+		r.started, chunk = true, `<receipt><retailer>Target</retailer><items>`
+	}
+	return copy(p, chunk), nil
+}
+
+func TestDecodeReceiptXMLLimits(t *testing.T) {
+	live := cfg
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxItems: 3}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+	// the items are counted as they are read, the endless receipt is turned away after the fourth.
+	if _, err := decodeReceiptXML(&endlessXMLItems{}); err == nil || err.Error() != "items: must contain at most 3 items." {
+		t.Errorf("decodeReceiptXML() error = %v, want the item limit", err)
+	}
+
+	router := setup()
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxBodyBytes: 1000}
+	liveConfig.Store(&live)
+	req := httptest.NewRequest("POST", "/receipts/process", io.LimitReader(&endlessXMLItems{}, 2000))
+	req.Header.Set("Content-Type", "application/xml")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /receipts/process = %v %s, want 413", rr.Code, rr.Body)
+	}
+}
+
+func TestDecodeReceiptProtoLimits(t *testing.T) {
+	live := cfg
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxBodyBytes: 1000}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+	// the body is read no further than the limit, and turned away before it is unmarshalled.
+	_, err := decodeReceiptProto(bytes.NewReader(make([]byte, 5000)))
+	if tooLarge := (*http.MaxBytesError)(nil); !errors.As(err, &tooLarge) || tooLarge.Limit != 1000 {
+		t.Errorf("decodeReceiptProto() error = %v, want the body limit", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ErasureConfig sets how long an erasure request waits before it is carried out, during which it can be cancelled.
+// 72h by default.
+type ErasureConfig struct {
+	GracePeriod Duration `json:"gracePeriod"`
+}
+
+const defaultErasureGracePeriod = 72 * time.Hour
+
+func (c ErasureConfig) Validate() error {
+	if c.GracePeriod < 0 {
+		return fmt.Errorf("erasure: gracePeriod must not be negative")
+	}
+	return nil
+}
+
+func (c ErasureConfig) gracePeriod() time.Duration {
+	if c.GracePeriod == 0 {
+		return defaultErasureGracePeriod
+	}
+	return time.Duration(c.GracePeriod)
+}
+
+// Erasure statuses.
+const (
+	erasureScheduled = "scheduled"
+	erasureCancelled = "cancelled"
+	erasureCompleted = "completed"
+)
+
+// ErasureCertificate is the lasting record of an erasure request. It only holds a hash of the account ID, so it can
+// prove an erasure for a given ID later without keeping the ID around.
+type ErasureCertificate struct {
+	ID                 string     `json:"id"`
+	AccountHash        string     `json:"accountHash"`
+	Status             string     `json:"status"`
+	RequestedAt        time.Time  `json:"requestedAt"`
+	ExecuteAt          time.Time  `json:"executeAt"`
+	CompletedAt        *time.Time `json:"completedAt,omitempty"`
+	ReceiptsErased     int        `json:"receiptsErased"`
+	EntriesAnonymized  int        `json:"entriesAnonymized"`
+	AuditRecordsErased int        `json:"auditRecordsErased"`
+	AliasesErased      int        `json:"aliasesErased"`
+}
+
+func accountHash(account string) string {
+	sum := sha256.Sum256([]byte(account))
+	return hex.EncodeToString(sum[:])
+}
+
+// pendingErasure is what an erasure needs until it is done. receipts is set once the ledger part ran, deleting them
+// from the store is retried until it succeeds.
+type pendingErasure struct {
+	account  string
+	receipts []string
+	erased   bool
+}
+
+type erasureRegistry struct {
+	mu           sync.Mutex
+	certificates map[string]*ErasureCertificate
+	pending      map[string]*pendingErasure // by certificate ID
+}
+
+var erasures *erasureRegistry
+
+// erasureHooks forget what is kept about an account outside the ledger, the store and its settings, given the account
+// and the IDs of its receipts. They run once an erasure's ledger part is done.
+var erasureHooks []func(account string, receipts []string)
+
+// onErasure registers hook to run on every erasure.
+func onErasure(hook func(account string, receipts []string)) {
+	erasureHooks = append(erasureHooks, hook)
+}
+
+func newErasureRegistry() *erasureRegistry {
+	return &erasureRegistry{certificates: map[string]*ErasureCertificate{}, pending: map[string]*pendingErasure{}}
+}
+
+// schedule returns the scheduled erasure of account, creating it unless there is one already.
+func (r *erasureRegistry) schedule(account string, now time.Time, grace time.Duration) (ErasureCertificate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, p := range r.pending {
+		if p.account == account {
+			return *r.certificates[id], false
+		}
+	}
+	cert := &ErasureCertificate{
+		ID:          uuid.New().String(),
+		AccountHash: accountHash(account),
+		Status:      erasureScheduled,
+		RequestedAt: now.UTC(),
+		ExecuteAt:   now.UTC().Add(grace),
+	}
+	r.certificates[cert.ID] = cert
+	r.pending[cert.ID] = &pendingErasure{account: account}
+	return *cert, true
+}
+
+func (r *erasureRegistry) get(id string) (ErasureCertificate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cert, ok := r.certificates[id]
+	if !ok {
+		return ErasureCertificate{}, false
+	}
+	return *cert, true
+}
+
+var (
+	errErasureNotFound       = errors.New("no such erasure")
+	errErasureNotCancellable = errors.New("only a scheduled erasure that hasn't started can be cancelled")
+)
+
+func (r *erasureRegistry) cancel(id string) (ErasureCertificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cert, ok := r.certificates[id]
+	if !ok {
+		return ErasureCertificate{}, errErasureNotFound
+	}
+	if p := r.pending[id]; p == nil || p.erased {
+		return ErasureCertificate{}, errErasureNotCancellable
+	}
+	cert.Status = erasureCancelled
+	delete(r.pending, id)
+	return *cert, nil
+}
+
+// runDue carries out every erasure whose grace period is over.
+func (r *erasureRegistry) runDue(ctx context.Context, now time.Time) {
+	r.mu.Lock()
+	var due []string
+	for id := range r.pending {
+		if !r.certificates[id].ExecuteAt.After(now) {
+			due = append(due, id)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, id := range due {
+		if err := r.execute(ctx, id, now); err != nil {
+			logger.Error("Failed to erase account data, will retry", zap.String("certificate", id), zap.Error(err))
+		}
+	}
+}
+
+// execute erases the account's ledger data first, which can't fail halfway, then its receipts and settings. A failed
+// receipt delete leaves the erasure pending so the next run picks up the remaining receipts.
+func (r *erasureRegistry) execute(ctx context.Context, id string, now time.Time) error {
+	r.mu.Lock()
+	p, ok := r.pending[id]
+	if !ok {
+		r.mu.Unlock()
+		return nil
+	}
+	cert := r.certificates[id]
+	if !p.erased {
+		result, err := pointsLedger.Erase(p.account, id)
+		if err != nil && !errors.Is(err, ledger.ErrUnknownAccount) {
+			r.mu.Unlock()
+			return err
+		}
+		// receipts still under review were never credited, the ledger doesn't know them.
+		p.receipts, p.erased = append(result.Receipts, audits.erase(p.account, result.Receipts)...), true
+		cert.ReceiptsErased = len(p.receipts)
+		cert.EntriesAnonymized = result.EntriesAnonymized
+		cert.AuditRecordsErased = result.AuditRecordsErased
+		cert.AliasesErased = result.AliasesErased
+		notificationSettings.delete(p.account)
+		preferences.delete(p.account)
+		statements.forget(p.account)
+		for _, hook := range erasureHooks {
+			hook(p.account, p.receipts)
+		}
+	}
+	receipts := p.receipts
+	r.mu.Unlock()
+
+	for len(receipts) > 0 {
+		err := receiptStore.Delete(ctx, receipts[0])
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			r.mu.Lock()
+			p.receipts = receipts
+			r.mu.Unlock()
+			return err
+		}
+		receipts = receipts[1:]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	completed := now.UTC()
+	cert.Status, cert.CompletedAt = erasureCompleted, &completed
+	delete(r.pending, id)
+	logger.Info("Erased account data", zap.String("certificate", id), zap.Int("receipts", cert.ReceiptsErased))
+	return nil
+}
+
+// startErasures carries out due erasures every minute.
+func startErasures(ctx context.Context) {
+	go runPeriodically(ctx, time.Minute, func(ctx context.Context) {
+		erasures.runDue(ctx, time.Now())
+	})
+}
+
+func writeCertificate(w http.ResponseWriter, status int, cert ErasureCertificate) {
+	jsonResponse, err := json.Marshal(cert)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// eraseAccountData serves DELETE /accounts/{id}/data. The erasure is scheduled for after the grace period and the
+// certificate returned right away; asking again while it is scheduled returns the same certificate.
+func eraseAccountData(w http.ResponseWriter, r *http.Request) {
+	account := mux.Vars(r)["id"]
+	if _, err := pointsLedger.Balance(account); err != nil {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+	// erasing an alias erases the account it was merged into, which is the same customer.
+	account = pointsLedger.Resolve(account)
+
+	cert, created := erasures.schedule(account, time.Now(), currentConfig().Erasure.gracePeriod())
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+		logger.Info("Scheduled account data erasure", zap.String("certificate", cert.ID), zap.Time("executeAt", cert.ExecuteAt))
+	}
+	writeCertificate(w, status, cert)
+}
+
+// getErasure serves GET /erasures/{id}.
+func getErasure(w http.ResponseWriter, r *http.Request) {
+	cert, ok := erasures.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "No erasure found for that ID.", http.StatusNotFound)
+		return
+	}
+	writeCertificate(w, http.StatusOK, cert)
+}
+
+// cancelErasure serves DELETE /erasures/{id}, which is only possible during the grace period.
+func cancelErasure(w http.ResponseWriter, r *http.Request) {
+	cert, err := erasures.cancel(mux.Vars(r)["id"])
+	if errors.Is(err, errErasureNotFound) {
+		http.Error(w, "No erasure found for that ID.", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errErasureNotCancellable) {
+		http.Error(w, "Only a scheduled erasure can be cancelled.", http.StatusConflict)
+		return
+	}
+	logger.Info("Cancelled account data erasure", zap.String("certificate", cert.ID))
+	writeCertificate(w, http.StatusOK, cert)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func requestErasure(t *testing.T, handler http.Handler, method, path string, wantStatus int) ErasureCertificate {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	if rr.Code != wantStatus {
+		t.Fatalf("%v %v returned wrong status code: got %v want %v: %s", method, path, rr.Code, wantStatus, rr.Body)
+	}
+	var cert ErasureCertificate
+	json.Unmarshal(rr.Body.Bytes(), &cert)
+	return cert
+}
+
+func TestEraseAccountData(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Build()
+	submitForAccount(t, router, "alice", receipt)
+	submitForAccount(t, router, "alice", receipt)
+	submitForAccount(t, router, "bob", receipt)
+	aliceReceipts, bobReceipts := pointsLedger.Receipts("alice"), pointsLedger.Receipts("bob")
+	notificationSettings.set("alice", NotificationSettings{Email: "alice@example.com"})
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	if cert.Status != erasureScheduled || cert.AccountHash != accountHash("alice") || cert.ExecuteAt.Sub(cert.RequestedAt) != defaultErasureGracePeriod {
+		t.Errorf("certificate = %+v, want scheduled after the grace period", cert)
+	}
+	if again := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusOK); again.ID != cert.ID {
+		t.Errorf("asking again scheduled %v, want the existing %v", again.ID, cert.ID)
+	}
+
+	// nothing happens during the grace period.
+	erasures.runDue(context.Background(), cert.ExecuteAt.Add(-time.Second))
+	if _, err := pointsLedger.Balance("alice"); err != nil {
+		t.Fatalf("Balance() during the grace period error = %v", err)
+	}
+
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	done := requestErasure(t, router, "GET", "/erasures/"+cert.ID, http.StatusOK)
+	if done.Status != erasureCompleted || done.ReceiptsErased != 2 || done.EntriesAnonymized != 2 || done.CompletedAt == nil {
+		t.Errorf("certificate = %+v, want completed with 2 receipts", done)
+	}
+	if _, err := pointsLedger.Balance("alice"); !errors.Is(err, ledger.ErrUnknownAccount) {
+		t.Errorf("Balance() after erasure error = %v, want %v", err, ledger.ErrUnknownAccount)
+	}
+	for _, id := range aliceReceipts {
+		if _, err := receiptStore.Get(context.Background(), id); err == nil {
+			t.Errorf("receipt %v is still stored", id)
+		}
+	}
+	if _, err := receiptStore.Get(context.Background(), bobReceipts[0]); err != nil {
+		t.Errorf("bob's receipt is gone: %v", err)
+	}
+	if settings := notificationSettings.get("alice"); settings.Email != "" {
+		t.Errorf("notification settings = %+v, want none", settings)
+	}
+
+	requestErasure(t, router, "DELETE", "/erasures/"+cert.ID, http.StatusConflict)
+	requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusNotFound)
+	requestErasure(t, router, "GET", "/erasures/does-not-exist", http.StatusNotFound)
+}
+
+func TestCancelErasure(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	if cancelled := requestErasure(t, router, "DELETE", "/erasures/"+cert.ID, http.StatusOK); cancelled.Status != erasureCancelled {
+		t.Errorf("status = %v, want %v", cancelled.Status, erasureCancelled)
+	}
+
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if _, err := pointsLedger.Balance("alice"); err != nil {
+		t.Errorf("Balance() after a cancelled erasure error = %v", err)
+	}
+	// a new request starts over.
+	if again := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted); again.ID == cert.ID {
+		t.Errorf("a new erasure reused the cancelled certificate")
+	}
+}
+
+func TestErasureRetriesReceiptDeletes(t *testing.T) {
+	router := setup()
+	faulty := storetest.NewFaulty(receiptStore, 0, 0, 1)
+	receiptStore = faulty
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	id := pointsLedger.Receipts("alice")[0]
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	faulty.ErrorRate = 1
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if got, _ := erasures.get(cert.ID); got.Status != erasureScheduled {
+		t.Fatalf("status after a failed delete = %v, want %v", got.Status, erasureScheduled)
+	}
+	// the ledger part is done, it can't be cancelled any more.
+	requestErasure(t, router, "DELETE", "/erasures/"+cert.ID, http.StatusConflict)
+
+	faulty.ErrorRate = 0
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if got, _ := erasures.get(cert.ID); got.Status != erasureCompleted || got.ReceiptsErased != 1 {
+		t.Errorf("certificate = %+v, want completed with 1 receipt", got)
+	}
+	if _, err := receiptStore.Get(context.Background(), id); err == nil {
+		t.Errorf("receipt %v is still stored", id)
+	}
+}
+
+func TestErasureForgetsFraudData(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Fraud = FraudConfig{ItemDedup: ItemDedupConfig{Enabled: true}}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+	receipt := receipttest.New().Retailer("Target").Item("Gatorade", "6.00").Build()
+	submitForAccount(t, router, "alice", receipt)
+	submitForAccount(t, router, "mallory", receipt)
+	if flags := fraudFlags.list(fraudSignalItemDedup); len(flags) != 1 || len(flags[0].Matches) != 1 {
+		t.Fatalf("flags = %+v, want mallory's receipt matching alice's", flags)
+	}
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/admin/fraud/correlations/mallory", nil))
+	var got struct {
+		Correlations []AccountCorrelation `json:"correlations"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Correlations) != 0 {
+		t.Errorf("GET /admin/fraud/correlations/mallory after alice's erasure = %v %s, want no correlations", rr.Code, rr.Body)
+	}
+	if flags := fraudFlags.list(""); len(flags) != 1 || flags[0].Account != "mallory" || len(flags[0].Matches) != 0 {
+		t.Errorf("flags = %+v, want mallory's flag without alice's receipt", flags)
+	}
+
+	cert = requestErasure(t, router, "DELETE", "/accounts/mallory/data", http.StatusAccepted)
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if flags := fraudFlags.list(""); len(flags) != 0 {
+		t.Errorf("flags = %+v, want none after mallory's erasure", flags)
+	}
+}
+
+func TestErasureEmptiesReviewQueue(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	live := cfg
+	live.AuditSampling = AuditSamplingConfig{SampleRate: 1}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	submitForAccount(t, router, "bob", receipttest.New().Build())
+	held := audits.list("")[0].ReceiptID
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if items := audits.list(""); len(items) != 1 || items[0].Account != "bob" {
+		t.Errorf("review queue = %+v, want only bob's receipt", items)
+	}
+	if _, err := receiptStore.Get(context.Background(), held); err == nil {
+		t.Errorf("alice's receipt under review %v is still stored", held)
+	}
+	if got, _ := erasures.get(cert.ID); got.ReceiptsErased != 2 {
+		t.Errorf("certificate = %+v, want 2 receipts erased", got)
+	}
+}
+
+func TestErasureForgetsAmendments(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build())
+	id := pointsLedger.Receipts("alice")[0]
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("DELETE", "/receipts/"+id+"/items/0?total=4.00", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("DELETE /receipts/%s/items/0 = %v %s", id, rr.Code, rr.Body)
+	}
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	for _, record := range pointsLedger.Audit() {
+		if record.Action == "receipt.amend" {
+			t.Errorf("audit record %+v of alice's receipt survived the erasure", record)
+		}
+	}
+}
+
+func TestErasureVoidsHolds(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Holds = HoldsConfig{SettleAfter: Duration(time.Hour)}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	submitForAccount(t, router, "bob", receipttest.New().Retailer("Walgreens").Build())
+
+	cert := requestErasure(t, router, "DELETE", "/accounts/alice/data", http.StatusAccepted)
+	erasures.runDue(context.Background(), cert.ExecuteAt)
+	if list := holds.forAccount("alice"); len(list) != 0 {
+		t.Errorf("alice's holds = %+v, want none after the erasure", list)
+	}
+	if list := holds.forAccount("bob"); len(list) != 1 {
+		t.Errorf("bob's holds = %+v, want his one left", list)
+	}
+}
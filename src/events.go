@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on eventBus.
+const (
+	EventPointsEarned   = "points.earned"
+	EventPointsExpiring = "points.expiring"
+)
+
+// Event is something that happened to an account. Points is what was earned, or what is about to expire. Version is
+// the version of the type's schema in eventSchemas the event follows.
+type Event struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	Account   string    `json:"account"`
+	ReceiptID string    `json:"receiptId,omitempty"`
+	Points    int64     `json:"points"`
+	At        time.Time `json:"at"`
+}
+
+// eventBus fans events out to in-process subscribers. Subscribers run on the publisher's goroutine, so anything slow
+// (sending mail, calling out) must hand the event off to a goroutine of its own.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]func(Event)
+}
+
+var events *eventBus
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: map[string][]func(Event){}}
+}
+
+func (b *eventBus) Subscribe(eventType string, fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[eventType] = append(b.subscribers[eventType], fn)
+}
+
+func (b *eventBus) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	if e.Version == 0 {
+		e.Version = currentEventVersion(e.Type)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subscribers[e.Type] {
+		fn(e)
+	}
+}
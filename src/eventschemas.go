@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// EventField is a field of an event payload, with its JSON type.
+type EventField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // "string", "integer" or "timestamp"
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// EventSchema is a version of the payload of an event type. Events carry the version they were published with.
+type EventSchema struct {
+	Type        string       `json:"type"`
+	Version     int          `json:"version"`
+	Description string       `json:"description"`
+	Fields      []EventField `json:"fields"`
+}
+
+var eventEnvelope = []EventField{
+	{Name: "type", Type: "string", Required: true, Description: "The event type."},
+	{Name: "version", Type: "integer", Required: true, Description: "The version of the event type's schema."},
+	{Name: "account", Type: "string", Required: true, Description: "The account the event happened to."},
+	{Name: "at", Type: "timestamp", Required: true, Description: "When the event was published, in UTC."},
+}
+
+// eventSchemas has every version of the schema of every event type, oldest first. The last is what is published. A
+// new version may add fields or make required ones optional, anything else breaks the consumers coding against the
+// old one and needs a new event type instead.
+var eventSchemas = map[string][]EventSchema{
+	EventPointsEarned: {{
+		Type:        EventPointsEarned,
+		Version:     1,
+		Description: "A receipt earned an account points.",
+		Fields: append(slices.Clone(eventEnvelope),
+			EventField{Name: "receiptId", Type: "string", Required: true, Description: "The receipt that earned the points."},
+			EventField{Name: "points", Type: "integer", Required: true, Description: "The points earned."},
+		),
+	}},
+	EventPointsExpiring: {{
+		Type:        EventPointsExpiring,
+		Version:     1,
+		Description: "Some of an account's points expire at the end of next month.",
+		Fields: append(slices.Clone(eventEnvelope),
+			EventField{Name: "points", Type: "integer", Required: true, Description: "The points about to expire."},
+		),
+	}},
+}
+
+// currentEventVersion is the version events of eventType are published with, 0 for unknown types.
+func currentEventVersion(eventType string) int {
+	versions := eventSchemas[eventType]
+	if len(versions) == 0 {
+		return 0
+	}
+	return versions[len(versions)-1].Version
+}
+
+func eventSchema(eventType string, version int) (EventSchema, bool) {
+	for _, s := range eventSchemas[eventType] {
+		if s.Version == version {
+			return s, true
+		}
+	}
+	return EventSchema{}, false
+}
+
+// compatibleEventSchema returns why consumers of old can't read events of next, nil if they can: next must have every
+// field of old with the same type, and may not require a field old didn't.
+func compatibleEventSchema(old, next EventSchema) error {
+	fields := map[string]EventField{}
+	for _, f := range old.Fields {
+		fields[f.Name] = f
+	}
+	for _, f := range next.Fields {
+		was, ok := fields[f.Name]
+		switch {
+		case ok && was.Type != f.Type:
+			return fmt.Errorf("field %s changed from %s to %s", f.Name, was.Type, f.Type)
+		case !ok && f.Required:
+			return fmt.Errorf("field %s is new and required", f.Name)
+		}
+		delete(fields, f.Name)
+	}
+	for name := range fields {
+		return fmt.Errorf("field %s was removed", name)
+	}
+	return nil
+}
+
+// EventsConfig pins the schema versions the deployment's event consumers code against, by event type. Loading the
+// config fails if the version events are published with isn't compatible with a pinned one, so an upgrade that would
+// break them doesn't start.
+type EventsConfig struct {
+	Versions map[string]int `json:"versions"`
+}
+
+func (c EventsConfig) Validate() error {
+	for eventType, version := range c.Versions {
+		if _, ok := eventSchemas[eventType]; !ok {
+			return fmt.Errorf("events: unknown event type %q", eventType)
+		}
+		pinned, ok := eventSchema(eventType, version)
+		if !ok {
+			return fmt.Errorf("events: %s has no version %d", eventType, version)
+		}
+		current, _ := eventSchema(eventType, currentEventVersion(eventType))
+		if err := compatibleEventSchema(pinned, current); err != nil {
+			return fmt.Errorf("events: %s version %d isn't compatible with version %d: %w", eventType, current.Version, version, err)
+		}
+	}
+	return nil
+}
+
+type eventSchemasResponse struct {
+	Schemas []EventSchema `json:"schemas"`
+}
+
+// getEventSchemas handles GET /events/schemas, every version of the schema of every event type.
+func getEventSchemas(w http.ResponseWriter, r *http.Request) {
+	response := eventSchemasResponse{Schemas: []EventSchema{}}
+	for _, eventType := range slices.Sorted(maps.Keys(eventSchemas)) {
+		response.Schemas = append(response.Schemas, eventSchemas[eventType]...)
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompatibleEventSchema(t *testing.T) {
+	v1 := EventSchema{Fields: []EventField{{Name: "account", Type: "string", Required: true}, {Name: "points", Type: "integer", Required: true}}}
+
+	testCases := []struct {
+		name    string
+		fields  []EventField
+		wantErr bool
+	}{
+		{name: "same", fields: v1.Fields},
+		{name: "optional field added", fields: append(v1.Fields[:2:2], EventField{Name: "note", Type: "string"})},
+		{name: "field made optional", fields: []EventField{{Name: "account", Type: "string", Required: true}, {Name: "points", Type: "integer"}}},
+		{name: "required field added", fields: append(v1.Fields[:2:2], EventField{Name: "note", Type: "string", Required: true}), wantErr: true},
+		{name: "field removed", fields: v1.Fields[:1], wantErr: true},
+		{name: "field retyped", fields: []EventField{{Name: "account", Type: "string", Required: true}, {Name: "points", Type: "string", Required: true}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := compatibleEventSchema(v1, EventSchema{Fields: tc.fields}); (err != nil) != tc.wantErr {
+				t.Errorf("compatibleEventSchema() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventsConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		events  EventsConfig
+		wantErr bool
+	}{
+		{name: "nothing pinned"},
+		{name: "current version", events: EventsConfig{Versions: map[string]int{EventPointsEarned: 1, EventPointsExpiring: 1}}},
+		{name: "unknown type", events: EventsConfig{Versions: map[string]int{"receipt.rejected": 1}}, wantErr: true},
+		{name: "unknown version", events: EventsConfig{Versions: map[string]int{EventPointsEarned: 2}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.events.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishedEventsFollowTheirSchema(t *testing.T) {
+	setup()
+	testCases := []Event{
+		{Type: EventPointsEarned, Account: "alice", ReceiptID: "r1", Points: 10},
+		{Type: EventPointsExpiring, Account: "alice", Points: 5},
+	}
+
+	for _, e := range testCases {
+		t.Run(e.Type, func(t *testing.T) {
+			var published Event
+			events.Subscribe(e.Type, func(e Event) { published = e })
+			events.Publish(e)
+			if published.Version != currentEventVersion(e.Type) {
+				t.Errorf("published version %d, want %d", published.Version, currentEventVersion(e.Type))
+			}
+
+			payload, _ := json.Marshal(published)
+			var fields map[string]any
+			json.Unmarshal(payload, &fields)
+			schema, _ := eventSchema(e.Type, published.Version)
+			for _, f := range schema.Fields {
+				if _, ok := fields[f.Name]; f.Required && !ok {
+					t.Errorf("%s is missing the required field %s", payload, f.Name)
+				}
+				delete(fields, f.Name)
+			}
+			if len(fields) > 0 {
+				t.Errorf("%s has fields %v the schema doesn't have", payload, fields)
+			}
+		})
+	}
+}
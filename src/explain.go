@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ruleExplanations turn a rule's result into sentences for customer support, one per pointRules entry. They are only
+// called for rules that awarded points, rules without one are only named.
+var ruleExplanations = map[string]func(r *Receipt, result RuleResult) []string{
+	"retailerName": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the retailer name %q has %d letters and digits.", pointsText(result.Points), r.Retailer, result.Points)}
+	},
+	"roundDollarTotal": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the total $%.2f is a round dollar amount.", pointsText(result.Points), r.Total)}
+	},
+	"totalMultipleOf25": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the total $%.2f is a multiple of 0.25.", pointsText(result.Points), r.Total)}
+	},
+	"everyTwoItems": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the receipt has %d items, 5 for every two.", pointsText(result.Points), len(r.Items))}
+	},
+	"itemDescription": func(r *Receipt, result RuleResult) []string {
+		var sentences []string
+		for _, item := range result.Items {
+			description := strings.TrimSpace(item.ShortDescription)
+			sentences = append(sentences, fmt.Sprintf("%s for item %d because its description %q is %d characters long, a multiple of 3 (a fifth of its price $%.2f, rounded up).",
+				pointsText(item.Points), item.Item+1, description, len(description), r.Items[item.Item].Price))
+		}
+		return sentences
+	},
+	"oddDay": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the day of purchase is odd (%s).", pointsText(result.Points), r.PurchaseDate.Format("January 2"))}
+	},
+	"afternoonPurchase": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because the purchase was made at %s, between 14:00 and 16:59.", pointsText(result.Points), r.PurchaseTime.Format("15:04"))}
+	},
+	"paymentMethod": func(r *Receipt, result RuleResult) []string {
+		return []string{fmt.Sprintf("%s because it was paid with %s.", pointsText(result.Points), paymentMethodNames[r.PaymentMethod])}
+	},
+	"metadata": func(r *Receipt, result RuleResult) []string {
+		settings := currentConfig().rulesFor(r.PurchaseDate)["metadata"].in(currentConfig().Regions.regionOf(r.StoreLocation))
+		return []string{fmt.Sprintf("%s because its metadata has %s.", pointsText(result.Points), strings.Join(r.metadataMatches(settings), ", "))}
+	},
+}
+
+func pointsText(n int) string {
+	if n == 1 {
+		return "1 point"
+	}
+	return fmt.Sprintf("%d points", n)
+}
+
+// explain returns a sentence for every rule that awarded points, in rule order.
+func explain(r *Receipt) []string {
+	var sentences []string
+	for _, result := range r.Breakdown() {
+		if result.Points == 0 {
+			continue
+		}
+		if explainRule, ok := ruleExplanations[result.Rule]; ok {
+			sentences = append(sentences, explainRule(r, result)...)
+		} else {
+			sentences = append(sentences, fmt.Sprintf("%s from the %s rule.", pointsText(result.Points), result.Rule))
+		}
+	}
+	return sentences
+}
+
+type explanationResponse struct {
+	Points      int64    `json:"points"`
+	Explanation []string `json:"explanation"`
+}
+
+// explainPoints serves GET /receipts/{id}/points/explain for support tooling. The stored receipt is scored again to
+// say why, the points are the ones actually awarded.
+func explainPoints(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	receipt, rec, err := loadReceipt(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	response := explanationResponse{Points: rec.Points, Explanation: explain(&receipt)}
+	switch scored := int64(receipt.CalculatePoints()); {
+	case len(response.Explanation) == 0:
+		response.Explanation = []string{"No rule awarded points to this receipt."}
+	case rec.Points == 0 && scored > 0:
+		response.Explanation = append(response.Explanation, fmt.Sprintf("It was awarded no points instead of %d because its account was over the daily receipt limit.", scored))
+	case rec.Points != scored:
+		response.Explanation = append(response.Explanation, fmt.Sprintf("It was awarded %s when it was processed.", pointsText(int(rec.Points))))
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
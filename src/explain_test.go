@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestRuleExplanations(t *testing.T) {
+	for _, rule := range pointRules {
+		// custom rules may leave it to the default sentence.
+		if _, custom := customRules[rule.name]; custom {
+			continue
+		}
+		if _, ok := ruleExplanations[rule.name]; !ok {
+			t.Errorf("rule %v has no explanation", rule.name)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: time.Date(2022, 3, 21, 0, 0, 0, 0, time.UTC),
+		PurchaseTime: time.Date(0, 1, 1, 14, 33, 0, 0, time.UTC),
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+		},
+		Total: 9.00,
+	}
+	want := []string{
+		"14 points because the retailer name \"M&M Corner Market\" has 14 letters and digits.",
+		"50 points because the total $9.00 is a round dollar amount.",
+		"25 points because the total $9.00 is a multiple of 0.25.",
+		"10 points because the receipt has 4 items, 5 for every two.",
+		"6 points because the day of purchase is odd (March 21).",
+		"10 points because the purchase was made at 14:33, between 14:00 and 16:59.",
+	}
+
+	if got := explain(&receipt); !reflect.DeepEqual(got, want) {
+		t.Errorf("explain() = %q, want %q", got, want)
+	}
+}
+
+func TestExplainPoints(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Throttle = ThrottleConfig{DailyReceiptsPerAccount: 1}
+	liveConfig.Store(&live)
+	receipt := receipttest.New().Item("Emils Cheese Pizza", "12.25").Build()
+
+	testCases := []struct {
+		name       string
+		wantPoints int64
+		want       string
+	}{
+		{name: "credited", wantPoints: 40, want: "3 points for item 1 because its description \"Emils Cheese Pizza\" is 18 characters long, a multiple of 3 (a fifth of its price $12.25, rounded up)."},
+		{name: "throttled", wantPoints: 0, want: "It was awarded no points instead of 40 because its account was over the daily receipt limit."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			submitForAccount(t, router, "alice", receipt)
+			id := pointsLedger.Receipts("alice")[len(pointsLedger.Receipts("alice"))-1]
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+id+"/points/explain", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var got explanationResponse
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if got.Points != tc.wantPoints || !slices.Contains(got.Explanation, tc.want) {
+				t.Errorf("explanation = %+v, want %v points and %q", got, tc.wantPoints, tc.want)
+			}
+		})
+	}
+}
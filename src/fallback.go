@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// StoreFallbackConfig puts a circuit breaker in front of the store, when Enabled. After FailureThreshold failed calls
+// in a row (5 by default) the store isn't called for CooldownSeconds (10 by default). Meanwhile the last RecentRecords
+// receipts written (10000 by default) are read from memory. Writes fail, submissions go to the spool if there is one.
+// Every ReconcileInterval (5s by default) after the store failed, the remembered records are checked against it.
+type StoreFallbackConfig struct {
+	Enabled           bool     `json:"enabled"`
+	FailureThreshold  int      `json:"failureThreshold"`
+	CooldownSeconds   int      `json:"cooldownSeconds"`
+	RecentRecords     int      `json:"recentRecords"`
+	ReconcileInterval Duration `json:"reconcileInterval"`
+}
+
+func (c StoreFallbackConfig) Validate() error {
+	if c.FailureThreshold < 0 || c.CooldownSeconds < 0 || c.RecentRecords < 0 || c.ReconcileInterval < 0 {
+		return fmt.Errorf("store: fallback: failureThreshold, cooldownSeconds, recentRecords and reconcileInterval must not be negative")
+	}
+	return nil
+}
+
+func (c StoreFallbackConfig) failureThreshold() int {
+	if c.FailureThreshold == 0 {
+		return 5
+	}
+	return c.FailureThreshold
+}
+
+func (c StoreFallbackConfig) cooldown() time.Duration {
+	if c.CooldownSeconds == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.CooldownSeconds) * time.Second
+}
+
+func (c StoreFallbackConfig) recentRecords() int {
+	if c.RecentRecords == 0 {
+		return 10000
+	}
+	return c.RecentRecords
+}
+
+func (c StoreFallbackConfig) reconcileInterval() time.Duration {
+	if c.ReconcileInterval == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.ReconcileInterval)
+}
+
+var errStoreUnavailable = errors.New("the store is unavailable and its circuit breaker is open")
+
+var (
+	storeFallbackTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_store_fallback_total",
+		Help: "Store calls answered from memory while the store was failing, by operation.",
+	}, []string{"operation"})
+
+	storeReconciledTotal = metrics.NewCounter(prometheus.CounterOpts{
+		Name: "fcpc_store_reconciled_total",
+		Help: "Remembered records a reconcile found the store had lost and wrote back.",
+	})
+)
+
+// recentRecord is a record in the fallback's memory. seq tells whether an entry of the eviction order is its latest.
+type recentRecord struct {
+	rec store.Record
+	seq uint64
+}
+
+// fallbackStore smooths over blips of the store it wraps. Calls go through a circuit breaker, and records written
+// recently are read from memory while the breaker is open or the store fails the call. It is read-only: writes the
+// store fails fail too, a failed submission goes to the spool, which is on disk, rather than into memory a crash would
+// lose. List and Scan have nothing to fall back to and fail. API keys aren't covered. Once the store is back,
+// reconcile checks the remembered records against it.
+type fallbackStore struct {
+	primary store.Store
+	keys    store.KeyStore
+	breaker *circuitBreaker
+	c       StoreFallbackConfig
+
+	mu     sync.Mutex
+	recent map[string]recentRecord
+	order  []recentEntry
+	seq    uint64
+
+	// failures counts the calls the store failed, reconciled how many of them the last full reconcile came after.
+	failures, reconciled atomic.Uint64
+	// reconciling makes reconcile runs take turns.
+	reconciling sync.Mutex
+}
+
+type recentEntry struct {
+	id  string
+	seq uint64
+}
+
+// storeFallback is the fallback in front of the store, nil unless it is enabled.
+var storeFallback *fallbackStore
+
+func newFallbackStore(primary store.Store, c StoreFallbackConfig) *fallbackStore {
+	keys, _ := primary.(store.KeyStore)
+	return &fallbackStore{primary: primary, keys: keys, breaker: &circuitBreaker{}, c: c, recent: map[string]recentRecord{}}
+}
+
+// Unwrap returns the wrapped store.
+func (f *fallbackStore) Unwrap() store.Store {
+	return f.primary
+}
+
+// failed reports whether err means the store is failing, rather than the call being wrong or given up on.
+func failed(ctx context.Context, err error) bool {
+	return err != nil && !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrExists) && ctx.Err() == nil
+}
+
+// call runs fn against the store if the breaker lets it through, and reports whether it was answered by the store.
+func (f *fallbackStore) call(ctx context.Context, fn func() error) (bool, error) {
+	if !f.breaker.allow(time.Now()) {
+		return false, errStoreUnavailable
+	}
+	err := fn()
+	if failed(ctx, err) {
+		f.failures.Add(1)
+		f.breaker.done(err, time.Now(), f.c.failureThreshold(), f.c.cooldown())
+		return false, err
+	}
+	f.breaker.done(nil, time.Now(), f.c.failureThreshold(), f.c.cooldown())
+	return true, err
+}
+
+// remember keeps rec as recently written, forgetting the oldest records past the limit.
+func (f *fallbackStore) remember(rec store.Record) {
+	f.seq++
+	f.recent[rec.ID] = recentRecord{rec: rec, seq: f.seq}
+	f.order = append(f.order, recentEntry{id: rec.ID, seq: f.seq})
+	for len(f.recent) > f.c.recentRecords() {
+		oldest := f.order[0]
+		f.order = f.order[1:]
+		if f.recent[oldest.id].seq == oldest.seq {
+			delete(f.recent, oldest.id)
+		}
+	}
+}
+
+// write makes a write to the store, and remembers what it wrote for reads while the store fails.
+func (f *fallbackStore) write(ctx context.Context, c store.Change, fn func() error) error {
+	if _, err := f.call(ctx, fn); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c.Record != nil {
+		f.remember(*c.Record)
+	} else {
+		delete(f.recent, c.ID)
+	}
+	return nil
+}
+
+func (f *fallbackStore) Put(ctx context.Context, rec store.Record) error {
+	return f.write(ctx, store.Change{Kind: store.ChangePut, Record: &rec}, func() error { return f.primary.Put(ctx, rec) })
+}
+
+func (f *fallbackStore) Update(ctx context.Context, rec store.Record) error {
+	return f.write(ctx, store.Change{Kind: store.ChangeUpdate, Record: &rec}, func() error { return f.primary.Update(ctx, rec) })
+}
+
+func (f *fallbackStore) Delete(ctx context.Context, id string) error {
+	return f.write(ctx, store.Change{Kind: store.ChangeDelete, ID: id}, func() error { return f.primary.Delete(ctx, id) })
+}
+
+func (f *fallbackStore) Get(ctx context.Context, id string) (store.Record, error) {
+	var rec store.Record
+	answered, err := f.call(ctx, func() error {
+		var err error
+		rec, err = f.primary.Get(ctx, id)
+		return err
+	})
+	if answered {
+		return rec, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if recent, ok := f.recent[id]; ok {
+		storeFallbackTotal.WithLabelValues("get").Inc()
+		return recent.rec, nil
+	}
+	return store.Record{}, err
+}
+
+func (f *fallbackStore) List(ctx context.Context, opts store.ListOptions) ([]store.Record, error) {
+	var records []store.Record
+	_, err := f.call(ctx, func() error {
+		var err error
+		records, err = f.primary.List(ctx, opts)
+		return err
+	})
+	return records, err
+}
+
+func (f *fallbackStore) Scan(ctx context.Context, fn func(store.Record) error) error {
+	_, err := f.call(ctx, func() error { return f.primary.Scan(ctx, fn) })
+	return err
+}
+
+func (f *fallbackStore) Close() error {
+	return f.primary.Close()
+}
+
+func (f *fallbackStore) PutKey(ctx context.Context, key store.APIKey) error {
+	return f.keys.PutKey(ctx, key)
+}
+
+func (f *fallbackStore) GetKey(ctx context.Context, id string) (store.APIKey, error) {
+	return f.keys.GetKey(ctx, id)
+}
+
+func (f *fallbackStore) ListKeys(ctx context.Context) ([]store.APIKey, error) {
+	return f.keys.ListKeys(ctx)
+}
+
+// reconcile checks the remembered records against the store after it failed, once the breaker lets calls through. A
+// record the store no longer has, e.g. after a failover to a replica that lagged behind, was written and confirmed, so
+// it is written back. The others are remembered as the store has them, in case another instance changed them. It stops
+// at the first call the store fails and returns how many records it restored.
+func (f *fallbackStore) reconcile(ctx context.Context) (int, error) {
+	f.reconciling.Lock()
+	defer f.reconciling.Unlock()
+
+	failures := f.failures.Load()
+	if failures == f.reconciled.Load() || f.breaker.isOpen(time.Now()) {
+		return 0, nil
+	}
+	f.mu.Lock()
+	records := make([]recentRecord, 0, len(f.recent))
+	for _, recent := range f.recent {
+		records = append(records, recent)
+	}
+	f.mu.Unlock()
+
+	restored := 0
+	for _, recent := range records {
+		rec := recent.rec
+		var stored store.Record
+		answered, err := f.call(ctx, func() error {
+			var err error
+			stored, err = f.primary.Get(ctx, rec.ID)
+			return err
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			answered, err = f.call(ctx, func() error { return f.primary.Put(ctx, rec) })
+			if errors.Is(err, store.ErrExists) {
+				continue
+			}
+			if answered && err == nil {
+				logger.Warn("Restored a record the store lost", zap.String("receiptID", rec.ID))
+				storeReconciledTotal.Inc()
+				restored++
+				continue
+			}
+		}
+		if !answered || err != nil {
+			return restored, err
+		}
+		f.mu.Lock()
+		// unless it was written again meanwhile.
+		if f.recent[rec.ID].seq == recent.seq {
+			f.recent[rec.ID] = recentRecord{rec: stored, seq: recent.seq}
+		}
+		f.mu.Unlock()
+	}
+	f.reconciled.Store(failures)
+	return restored, nil
+}
+
+// fallbackStatus is the fallback's part of GET /admin/store/stats.
+type fallbackStatus struct {
+	Open          bool `json:"open"`
+	RecentRecords int  `json:"recentRecords"`
+}
+
+func (f *fallbackStore) status() *fallbackStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &fallbackStatus{Open: f.breaker.isOpen(time.Now()), RecentRecords: len(f.recent)}
+}
+
+// startReconciler reconciles the fallback's remembered records with the store every interval.
+func startReconciler(ctx context.Context) {
+	if storeFallback == nil {
+		return
+	}
+	go runPeriodically(ctx, storeFallback.c.reconcileInterval(), func(ctx context.Context) {
+		restored, err := storeFallback.reconcile(ctx)
+		if restored > 0 {
+			logger.Info("Reconciled remembered records with the store", zap.Int("restored", restored))
+		}
+		if err != nil && !errors.Is(err, errStoreUnavailable) {
+			logger.Warn("Failed to reconcile remembered records with the store", zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary := storetest.NewFaulty(store.NewMemory(), 0, 0, 1)
+	f := newFallbackStore(primary, StoreFallbackConfig{FailureThreshold: 2, RecentRecords: 2})
+	for _, id := range []string{"old", "recent", "deleted", "latest"} {
+		if err := f.Put(ctx, store.Record{ID: id, Points: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Delete(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	primary.ErrorRate = 1
+	// writes fail rather than being kept in memory, reads still try the store.
+	if err := f.Put(ctx, store.Record{ID: "failed", Points: 2}); err == nil {
+		t.Fatalf("Put while the store fails = nil, want an error")
+	}
+	if _, err := f.Get(ctx, "latest"); err != nil {
+		t.Fatalf("Get while the store fails = %v, want it read from memory", err)
+	}
+	if !f.breaker.isOpen(time.Now()) {
+		t.Fatalf("breaker is closed after 2 failures in a row, want it open")
+	}
+	if err := f.Delete(ctx, "recent"); !errors.Is(err, errStoreUnavailable) {
+		t.Fatalf("Delete while the breaker is open = %v, want %v", err, errStoreUnavailable)
+	}
+
+	testCases := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "written recently", id: "latest"},
+		{name: "failed put", id: "failed", wantErr: errStoreUnavailable},
+		{name: "forgotten", id: "old", wantErr: errStoreUnavailable},
+		{name: "deleted before", id: "deleted", wantErr: errStoreUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, err := f.Get(ctx, tc.id)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Get(%v) = %v, want %v", tc.id, err, tc.wantErr)
+			}
+			if tc.wantErr == nil && rec.ID != tc.id {
+				t.Errorf("Get(%v) = %+v", tc.id, rec)
+			}
+		})
+	}
+	if _, err := f.List(ctx, store.ListOptions{}); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("List while the breaker is open = %v, want %v", err, errStoreUnavailable)
+	}
+
+	// the store is back and the cooldown is over.
+	primary.ErrorRate = 0
+	f.breaker.openUntil = time.Now()
+	if _, err := f.Get(ctx, "old"); err != nil {
+		t.Errorf("Get(old) once the store is back = %v", err)
+	}
+	if status := f.status(); status.Open {
+		t.Errorf("status once the store is back = %+v, want closed", status)
+	}
+}
+
+func TestFallbackReconcile(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	backend := store.NewMemory()
+	primary := storetest.NewFaulty(backend, 0, 0, 1)
+	f := newFallbackStore(primary, StoreFallbackConfig{FailureThreshold: 2})
+	for _, id := range []string{"kept", "lost", "changed"} {
+		if err := f.Put(ctx, store.Record{ID: id, Points: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if restored, err := f.reconcile(ctx); restored != 0 || err != nil {
+		t.Fatalf("reconcile() before the store failed = %d, %v, want nothing to do", restored, err)
+	}
+
+	// the store fails and comes back without one of the records, e.g. after a failover.
+	primary.ErrorRate = 1
+	f.Get(ctx, "kept")
+	f.Get(ctx, "kept")
+	if restored, err := f.reconcile(ctx); restored != 0 || err != nil {
+		t.Fatalf("reconcile() while the breaker is open = %d, %v, want it to wait", restored, err)
+	}
+	backend.Delete(ctx, "lost")
+	backend.Update(ctx, store.Record{ID: "changed", Points: 5})
+	primary.ErrorRate = 0
+	f.breaker.openUntil = time.Now()
+
+	if restored, err := f.reconcile(ctx); restored != 1 || err != nil {
+		t.Fatalf("reconcile() = %d, %v, want 1 record restored", restored, err)
+	}
+	if rec, err := backend.Get(ctx, "lost"); err != nil || rec.Points != 1 {
+		t.Errorf("lost record in the store = %+v, %v, want it written back", rec, err)
+	}
+	f.mu.Lock()
+	changed := f.recent["changed"].rec
+	f.mu.Unlock()
+	if changed.Points != 5 {
+		t.Errorf("remembered changed record = %+v, want it as the store has it", changed)
+	}
+	// once reconciled, it waits for the store to fail again.
+	backend.Delete(ctx, "lost")
+	if restored, err := f.reconcile(ctx); restored != 0 || err != nil {
+		t.Errorf("reconcile() again = %d, %v, want nothing to do", restored, err)
+	}
+}
+
+func TestProcessReceiptDuringStoreBlip(t *testing.T) {
+	router := setup()
+	primary := storetest.NewFaulty(receiptStore, 0, 0, 1)
+	storeFallback = newFallbackStore(primary, StoreFallbackConfig{})
+	receiptStore = storeFallback
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/process = %v %s, want 200", rr.Code, rr.Body)
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+
+	primary.ErrorRate = 1
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+resp.ID+"/points", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /receipts/%v/points = %v, want it read from memory", resp.ID, rr.Code)
+	}
+
+	// without a spool, a submission the store fails fails.
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Retailer("Walgreens").Build().JSON())))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("POST /receipts/process while the store fails = %v %s, want 500", rr.Code, rr.Body)
+	}
+	if status := storeFallback.status(); status.RecentRecords != 1 {
+		t.Errorf("fallback status = %+v, want 1 recent record", status)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestFastJSON(t *testing.T) {
+	testCases := []struct {
+		name string
+		got  []byte
+		want any
+	}{
+		{name: "points", got: appendPointsResponse(nil, 109), want: map[string]int64{"points": 109}},
+		{name: "negative points", got: appendPointsResponse(nil, -5), want: map[string]int64{"points": -5}},
+		{name: "process", got: appendProcessResponse(nil, submission{ID: "adb6b560-0eef-42bc-9d16-df48f30e89b2"}), want: map[string]any{"id": "adb6b560-0eef-42bc-9d16-df48f30e89b2"}},
+		{name: "process with flags", got: appendProcessResponse(nil, submission{ID: "a", Throttled: true, UnderReview: true}), want: map[string]any{"id": "a", "throttled": true, "underReview": true}},
+		{name: "process with warnings", got: appendProcessResponse(nil, submission{ID: "a", Warnings: []Warning{{Field: "retailer", Message: "was <trimmed>"}, {Field: "total", Message: "is off"}}}), want: map[string]any{"id": "a", "warnings": []Warning{{Field: "retailer", Message: "was <trimmed>"}, {Field: "total", Message: "is off"}}}},
+		{name: "string that needs escaping", got: appendJSONString(nil, "a\"<b>\n"), want: "a\"<b>\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, _ := json.Marshal(tc.want)
+			if string(tc.got) != string(want) {
+				t.Errorf("got %s, want %s", tc.got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkPointsResponse(b *testing.B) {
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			json.Marshal(map[string]int64{"points": 109})
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 128)
+		for b.Loop() {
+			buf = appendPointsResponse(buf[:0], 109)
+		}
+	})
+}
+
+func BenchmarkProcessResponse(b *testing.B) {
+	sub := submission{ID: "adb6b560-0eef-42bc-9d16-df48f30e89b2"}
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			response := map[string]any{"id": sub.ID}
+			if sub.Throttled {
+				response["throttled"] = true
+			}
+			json.Marshal(response)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 128)
+		for b.Loop() {
+			buf = appendProcessResponse(buf[:0], sub)
+		}
+	})
+}
+
+func BenchmarkGetPoints(b *testing.B) {
+	router := setup()
+	receiptStore.Put(b.Context(), store.Record{ID: "bench", Points: 109, Receipt: receipttest.New().Build().JSON()})
+	req := httptest.NewRequest("GET", "/receipts/bench/points", nil)
+	b.ReportAllocs()
+	for b.Loop() {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
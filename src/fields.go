@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// fieldSet is a parsed ?fields= selection. A key mapped to nil keeps its whole value, otherwise only the listed
+// keys of it are kept. Selections on arrays apply to every element.
+type fieldSet map[string]fieldSet
+
+// storedReceiptFields are the paths ?fields= accepts on receipt listings.
+var storedReceiptFields = []string{
+	"id", "points", "createdAt", "receipt",
+	"receipt.retailer", "receipt.purchaseDate", "receipt.purchaseTime", "receipt.total", "receipt.items",
+	"receipt.items.shortDescription", "receipt.items.price", "receipt.metadata",
+}
+
+// compactReceiptFields is what ?compact=true keeps, enough for a list row on the mobile client.
+const compactReceiptFields = "id,points,receipt.retailer,receipt.purchaseDate,receipt.total"
+
+// parseFields parses a comma separated list of dotted paths, each of which must be in known.
+func parseFields(s string, known []string) (fieldSet, error) {
+	set := fieldSet{}
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !slices.Contains(known, path) {
+			return nil, fmt.Errorf("unknown field %s, fields are %s", path, strings.Join(known, ", "))
+		}
+		set.add(strings.Split(path, "."))
+	}
+	if len(set) == 0 {
+		return nil, errors.New("no fields listed")
+	}
+	return set, nil
+}
+
+func (f fieldSet) add(path []string) {
+	child, seen := f[path[0]]
+	if len(path) == 1 {
+		// the whole value wins over parts of it.
+		f[path[0]] = nil
+		return
+	}
+	if seen && child == nil {
+		return
+	}
+	if child == nil {
+		child = fieldSet{}
+		f[path[0]] = child
+	}
+	child.add(path[1:])
+}
+
+// apply returns the part of v, as decoded from JSON, that f selects.
+func (f fieldSet) apply(v any) any {
+	if f == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(f))
+		for key, child := range f {
+			if value, ok := v[key]; ok {
+				out[key] = child.apply(value)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = f.apply(elem)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// receiptFieldSet reads ?fields= and ?compact= off a receipt listing. It returns nil when the client wants
+// everything.
+func receiptFieldSet(r *http.Request) (fieldSet, error) {
+	fields, compact := r.URL.Query().Get("fields"), r.URL.Query().Get("compact")
+	switch {
+	case fields != "" && compact != "":
+		return nil, errors.New("use either fields or compact, not both")
+	case compact == "true":
+		return parseFields(compactReceiptFields, storedReceiptFields)
+	case compact != "" && compact != "false":
+		return nil, errors.New("compact must be true or false")
+	case fields != "":
+		return parseFields(fields, storedReceiptFields)
+	}
+	return nil, nil
+}
+
+// projectRecords applies f to every record. With a nil f the records are returned as they are.
+func projectRecords(records []store.Record, f fieldSet) (any, error) {
+	if f == nil {
+		return records, nil
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	// UseNumber so points survive the round trip exactly.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return f.apply(generic), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestParseFields(t *testing.T) {
+	testCases := []struct {
+		name    string
+		fields  string
+		want    fieldSet
+		wantErr bool
+	}{
+		{name: "top level", fields: "id,points", want: fieldSet{"id": nil, "points": nil}},
+		{name: "nested", fields: "id, receipt.items.price", want: fieldSet{"id": nil, "receipt": {"items": {"price": nil}}}},
+		{name: "whole wins over part", fields: "receipt.total,receipt", want: fieldSet{"receipt": nil}},
+		{name: "part after whole", fields: "receipt,receipt.total", want: fieldSet{"receipt": nil}},
+		{name: "unknown", fields: "id,secret", wantErr: true},
+		{name: "empty", fields: " , ", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseFields(tc.fields, storedReceiptFields)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseFields(%q) error = %v, wantErr %v", tc.fields, err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseFields(%q) = %v, want %v", tc.fields, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListReceiptsFields(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Item("Gum", "1.00").Item("Soda", "2.00").Build()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON())))
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{name: "everything", query: "", wantStatus: http.StatusOK, wantKeys: []string{"createdAt", "id", "points", "receipt"}},
+		{name: "fields", query: "?fields=id,receipt.items.price", wantStatus: http.StatusOK, wantKeys: []string{"id", "receipt"}},
+		{name: "compact", query: "?compact=true", wantStatus: http.StatusOK, wantKeys: []string{"id", "points", "receipt"}},
+		{name: "unknown field", query: "?fields=id,secret", wantStatus: http.StatusBadRequest},
+		{name: "both", query: "?fields=id&compact=true", wantStatus: http.StatusBadRequest},
+		{name: "invalid compact", query: "?compact=yes", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var got map[string][]map[string]json.RawMessage
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			keys := slices.Sorted(maps.Keys(got["receipts"][0]))
+			if !slices.Equal(keys, tc.wantKeys) {
+				t.Errorf("receipt has %v, want %v", keys, tc.wantKeys)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts?fields=receipt.items.price", nil))
+	want := `{"receipts":[{"receipt":{"items":[{"price":"1.00"},{"price":"2.00"}]}}]}`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
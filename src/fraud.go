@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// FraudConfig turns on the fraud signals checked for every receipt submitted for an account. A receipt a signal
+// fires for is flagged for GET /admin/fraud/flags; with Review it is also held for review like a sampled receipt, its
+// points only credited once a reviewer approves. Submitters see it under review, not why.
+type FraudConfig struct {
+	Review    bool                `json:"review"`
+	ItemDedup ItemDedupConfig     `json:"itemDedup"`
+	Provider  FraudProviderConfig `json:"provider"`
+}
+
+func (c FraudConfig) Validate() error {
+	if err := c.ItemDedup.Validate(); err != nil {
+		return err
+	}
+	return c.Provider.Validate()
+}
+
+// fraudSignals are the signals a receipt can be flagged for.
+var fraudSignals = []string{fraudSignalItemDedup, fraudSignalProvider}
+
+// FraudFlag is a receipt a fraud signal fired for. Matches are the line items it shares with receipts of other
+// accounts, for the itemDedup signal, Score what the fraud provider scored it, for the provider signal.
+type FraudFlag struct {
+	ReceiptID string      `json:"receiptId"`
+	Account   string      `json:"account,omitempty"`
+	Signal    string      `json:"signal"`
+	Matches   []ItemMatch `json:"matches,omitempty"`
+	Score     *float64    `json:"score,omitempty"`
+	FlaggedAt time.Time   `json:"flaggedAt"`
+}
+
+// fraudFlagRegistry keeps the flags, oldest first, in memory like the ledger.
+type fraudFlagRegistry struct {
+	mu    sync.Mutex
+	flags []FraudFlag
+}
+
+var fraudFlags *fraudFlagRegistry
+
+func newFraudFlagRegistry() *fraudFlagRegistry {
+	return &fraudFlagRegistry{}
+}
+
+func init() {
+	onErasure(func(account string, receipts []string) { fraudFlags.erase(account, receipts) })
+}
+
+var fraudFlagsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_fraud_flags_total",
+	Help: "Receipts flagged by a fraud signal, by signal.",
+}, []string{"signal"})
+
+func (f *fraudFlagRegistry) add(flag FraudFlag) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flags = append(f.flags, flag)
+	fraudFlagsTotal.WithLabelValues(flag.Signal).Inc()
+	logger.Warn("Flagged receipt", zap.String("receiptID", flag.ReceiptID), zap.String("account", flag.Account), zap.String("signal", flag.Signal))
+}
+
+// erase drops the flags of an erased account's receipts, and its receipts from the matches of other accounts' flags.
+func (f *fraudFlagRegistry) erase(account string, receipts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	erased := func(receiptID, owner string) bool { return owner == account || slices.Contains(receipts, receiptID) }
+	f.flags = slices.DeleteFunc(f.flags, func(flag FraudFlag) bool { return erased(flag.ReceiptID, flag.Account) })
+	for i := range f.flags {
+		f.flags[i].Matches = slices.DeleteFunc(slices.Clone(f.flags[i].Matches), func(match ItemMatch) bool { return erased(match.ReceiptID, match.Account) })
+	}
+}
+
+// list returns the flags of signal, or all of them, oldest first.
+func (f *fraudFlagRegistry) list(signal string) []FraudFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flags := []FraudFlag{}
+	for _, flag := range f.flags {
+		if signal == "" || flag.Signal == signal {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// checkFraud runs the enabled fraud signals on a receipt just stored for account, flagging it if any fires, check
+// being what the fraud provider made of it. It returns the signal to hold the receipt for review for, "" if it isn't
+// to be held.
+func checkFraud(c FraudConfig, receipt Receipt, receiptID, account string, check *FraudCheck) string {
+	signal := ""
+	if check != nil && check.Decision == fraudDecisionFlag {
+		fraudFlags.add(FraudFlag{ReceiptID: receiptID, Account: account, Signal: fraudSignalProvider, Score: check.Score, FlaggedAt: time.Now().UTC()})
+		signal = fraudSignalProvider
+	}
+	if c.ItemDedup.Enabled && account != "" {
+		if matches := itemClaims.claim(c.ItemDedup, receipt, receiptID, account); matches != nil {
+			fraudFlags.add(FraudFlag{ReceiptID: receiptID, Account: account, Signal: fraudSignalItemDedup, Matches: matches, FlaggedAt: time.Now().UTC()})
+			signal = fraudSignalItemDedup
+		}
+	}
+	if !c.Review {
+		return ""
+	}
+	return signal
+}
+
+// listFraudFlags serves GET /admin/fraud/flags, optionally only those of ?signal=.
+func listFraudFlags(w http.ResponseWriter, r *http.Request) {
+	signal := r.URL.Query().Get("signal")
+	if signal != "" && !slices.Contains(fraudSignals, signal) {
+		http.Error(w, fmt.Sprintf("The signal must be one of %s.", strings.Join(fraudSignals, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(map[string]any{"flags": fraudFlags.list(signal)})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestItemDedup(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Fraud = FraudConfig{Review: true, ItemDedup: ItemDedupConfig{Enabled: true}}
+	liveConfig.Store(&live)
+
+	original := receipttest.New().Retailer("Target").Item("Gatorade", "6.00").Item("Dasani", "4.00")
+	submitForAccount(t, router, "alice", original.Build())
+	// the same account again isn't a match, nor is the same item bought at another time.
+	submitForAccount(t, router, "alice", original.Build())
+	submitForAccount(t, router, "bob", receipttest.New().Retailer("Target").PurchaseTime("09:12").Item("Gatorade", "6.00").Build())
+	if flags := fraudFlags.list(""); len(flags) != 0 {
+		t.Fatalf("flags = %+v, want none yet", flags)
+	}
+
+	// spelled differently, at another store of the chain.
+	submitForAccount(t, router, "mallory", receipttest.New().Retailer("TARGET ").Item("gatorade", "6.00").Build())
+	if balance, _ := pointsLedger.Balance("mallory"); balance != 0 {
+		t.Errorf("mallory was credited %v points, want the flagged receipt held for review", balance)
+	}
+	flags := fraudFlags.list(fraudSignalItemDedup)
+	if len(flags) != 1 || flags[0].Account != "mallory" || len(flags[0].Matches) != 2 || flags[0].Matches[0].Account != "alice" {
+		t.Fatalf("flags = %+v, want mallory's receipt matching both of alice's Gatorades", flags)
+	}
+	if held := audits.list(auditPending); len(held) != 1 || held[0].ReceiptID != flags[0].ReceiptID || held[0].Reason != fraudSignalItemDedup {
+		t.Errorf("review queue = %+v, want the flagged receipt", held)
+	}
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{name: "flags", path: "/admin/fraud/flags?signal=itemDedup", wantStatus: http.StatusOK},
+		{name: "unknown signal", path: "/admin/fraud/flags?signal=velocity", wantStatus: http.StatusBadRequest},
+		{name: "correlations", path: "/admin/fraud/correlations/mallory", wantStatus: http.StatusOK, want: `[{"account":"alice","sharedItems":2,"receiptIds":["` + pointsLedger.Receipts("alice")[0] + `","` + pointsLedger.Receipts("alice")[1] + `"]}]`},
+		{name: "no correlations", path: "/admin/fraud/correlations/bob", wantStatus: http.StatusOK, want: `[]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("GET %s = %v %s, want %v", tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.want == "" {
+				return
+			}
+			var got struct {
+				Correlations json.RawMessage `json:"correlations"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if string(got.Correlations) != tc.want {
+				t.Errorf("GET %s correlations = %s, want %s", tc.path, got.Correlations, tc.want)
+			}
+		})
+	}
+}
+
+func TestItemDedupWithoutReview(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Fraud = FraudConfig{ItemDedup: ItemDedupConfig{Enabled: true, MinItems: 2}}
+	liveConfig.Store(&live)
+
+	submitForAccount(t, router, "alice", receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build())
+	submitForAccount(t, router, "bob", receipttest.New().Item("Gatorade", "6.00").Item("Doritos", "3.35").Build())
+	submitForAccount(t, router, "carol", receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build())
+	if balance, _ := pointsLedger.Balance("carol"); balance == 0 {
+		t.Errorf("carol was credited nothing, want flagging alone not to hold the receipt")
+	}
+	if flags := fraudFlags.list(""); len(flags) != 1 || flags[0].Account != "carol" {
+		t.Errorf("flags = %+v, want only carol's receipt, bob's matched one item of the 2", flags)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const fraudSignalProvider = "provider"
+
+// What a receipt's fraud score decided. Unscored receipts are let through: the provider failed, timed out or was
+// skipped while its circuit breaker was open.
+const (
+	fraudDecisionAllow    = "allow"
+	fraudDecisionLog      = "log"
+	fraudDecisionFlag     = "flag"
+	fraudDecisionBlock    = "block"
+	fraudDecisionUnscored = "unscored"
+)
+
+// FraudProviderConfig has every receipt scored by an external fraud-scoring service before it is stored. Receipts
+// scored at or above Threshold get Action: "log" only logs them, "flag" flags them like a fraud signal (held for
+// review with the fraud config's review), "block" rejects them. The provider gets TimeoutMS (500 by default) per
+// receipt; after FailureThreshold failures in a row (5 by default) it isn't called for CooldownSeconds (30 by
+// default), and receipts go through unscored rather than waiting on it.
+type FraudProviderConfig struct {
+	Type             string  `json:"type"`
+	URL              string  `json:"url"`
+	TimeoutMS        int     `json:"timeoutMs"`
+	Action           string  `json:"action"`
+	Threshold        float64 `json:"threshold"`
+	FailureThreshold int     `json:"failureThreshold"`
+	CooldownSeconds  int     `json:"cooldownSeconds"`
+}
+
+func (c FraudProviderConfig) Validate() error {
+	if c.Type == "" {
+		return nil
+	}
+	if _, ok := fraudProviders[c.Type]; !ok {
+		return fmt.Errorf("fraud: provider: unknown type %q, must be \"http\"", c.Type)
+	}
+	if c.URL == "" {
+		return fmt.Errorf("fraud: provider: url is required")
+	}
+	if !slices.Contains([]string{fraudDecisionLog, fraudDecisionFlag, fraudDecisionBlock}, c.Action) {
+		return fmt.Errorf("fraud: provider: unknown action %q, must be \"log\", \"flag\" or \"block\"", c.Action)
+	}
+	if c.TimeoutMS < 0 || c.FailureThreshold < 0 || c.CooldownSeconds < 0 {
+		return fmt.Errorf("fraud: provider: timeoutMs, failureThreshold and cooldownSeconds must not be negative")
+	}
+	return nil
+}
+
+func (c FraudProviderConfig) timeout() time.Duration {
+	if c.TimeoutMS == 0 {
+		return 500 * time.Millisecond
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
+
+func (c FraudProviderConfig) failureThreshold() int {
+	if c.FailureThreshold == 0 {
+		return 5
+	}
+	return c.FailureThreshold
+}
+
+func (c FraudProviderConfig) cooldown() time.Duration {
+	if c.CooldownSeconds == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.CooldownSeconds) * time.Second
+}
+
+// FraudScoreRequest is what a fraud provider is asked to score. Account is empty for receipts submitted without one,
+// ClientIP for receipts that didn't come in over HTTP, like ingested files; providers check velocity by it.
+type FraudScoreRequest struct {
+	ReceiptID string     `json:"receiptId"`
+	Account   string     `json:"account,omitempty"`
+	ClientIP  string     `json:"clientIp,omitempty"`
+	Points    int        `json:"points"`
+	Receipt   ReceiptDTO `json:"receipt"`
+}
+
+// FraudProvider scores receipts for fraud, the higher the more likely. Implementations must be safe for concurrent
+// use and give up when ctx is done.
+type FraudProvider interface {
+	Score(ctx context.Context, req FraudScoreRequest) (float64, error)
+}
+
+// fraudProviders opens a provider of each type from its config.
+var fraudProviders = map[string]func(FraudProviderConfig) FraudProvider{
+	"http": func(c FraudProviderConfig) FraudProvider { return httpFraudProvider{url: c.URL} },
+}
+
+// httpFraudProvider POSTs the FraudScoreRequest as JSON to url, which answers {"score": 0.87}.
+type httpFraudProvider struct {
+	url string
+}
+
+func (p httpFraudProvider) Score(ctx context.Context, req FraudScoreRequest) (float64, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fraud provider answered %s", resp.Status)
+	}
+	var score struct {
+		Score *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&score); err != nil {
+		return 0, fmt.Errorf("decoding fraud score: %w", err)
+	}
+	if score.Score == nil {
+		return 0, errors.New("fraud provider answered without a score")
+	}
+	return *score.Score, nil
+}
+
+// circuitBreaker stops calling a failing dependency for a while. After the cooldown one call is let through, and the
+// breaker closes again if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var fraudBreaker *circuitBreaker
+
+// allow reports whether the dependency may be called now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records how a call let through by allow went. The breaker opens for cooldown after threshold failures in a
+// row, or straight away when the call probing it fails.
+func (b *circuitBreaker) done(err error, now time.Time, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= threshold {
+		b.openUntil, b.probing = now.Add(cooldown), false
+	}
+}
+
+// isOpen reports whether calls are held back right now.
+func (b *circuitBreaker) isOpen(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero() && (now.Before(b.openUntil) || b.probing)
+}
+
+var fraudProviderDecisionsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_fraud_provider_decisions_total",
+	Help: "Receipts scored by the fraud provider, by what their score decided.",
+}, []string{"decision"})
+
+// FraudCheck is what the fraud provider made of a receipt, stored with it. Score is missing for unscored receipts.
+type FraudCheck struct {
+	Score     *float64  `json:"score,omitempty"`
+	Decision  string    `json:"decision"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// storedReceipt is a receipt as it is stored, with its fraud check if the fraud provider was asked about it. Canary
+// marks the synthetic receipts of the canary, which stats leave out.
+type storedReceipt struct {
+	ReceiptDTO
+	FraudCheck *FraudCheck `json:"fraudCheck,omitempty"`
+	Canary     bool        `json:"canary,omitempty"`
+}
+
+// storedFraudCheck returns the fraud check stored with a receipt, nil if there is none.
+func storedFraudCheck(payload json.RawMessage) *FraudCheck {
+	var stored storedReceipt
+	if err := json.Unmarshal(payload, &stored); err != nil {
+		return nil
+	}
+	return stored.FraudCheck
+}
+
+var errReceiptBlocked = errors.New("the receipt was blocked by the fraud provider")
+
+// checkFraudProvider has the configured fraud provider score a receipt about to be stored. It returns nil without a
+// provider, and errReceiptBlocked with the check when the receipt is to be rejected.
+func checkFraudProvider(ctx context.Context, c FraudProviderConfig, req FraudScoreRequest) (*FraudCheck, error) {
+	if c.Type == "" {
+		return nil, nil
+	}
+	check := &FraudCheck{Decision: fraudDecisionUnscored, CheckedAt: time.Now().UTC()}
+	if fraudBreaker.allow(time.Now()) {
+		scoreCtx, cancel := context.WithTimeout(ctx, c.timeout())
+		score, err := fraudProviders[c.Type](c).Score(scoreCtx, req)
+		cancel()
+		fraudBreaker.done(err, time.Now(), c.failureThreshold(), c.cooldown())
+		if err != nil {
+			loggerFor(ctx).Warn("Failed to score receipt for fraud", zap.String("receiptID", req.ReceiptID), zap.Error(err))
+		} else {
+			check.Score = &score
+			check.Decision = fraudDecisionAllow
+			if score >= c.Threshold {
+				check.Decision = c.Action
+			}
+		}
+	}
+	fraudProviderDecisionsTotal.WithLabelValues(check.Decision).Inc()
+
+	switch check.Decision {
+	case fraudDecisionLog, fraudDecisionFlag:
+		loggerFor(ctx).Warn("Receipt scored as fraud", zap.String("receiptID", req.ReceiptID), zap.String("account", req.Account), zap.Float64("score", *check.Score), zap.String("decision", check.Decision))
+	case fraudDecisionBlock:
+		loggerFor(ctx).Warn("Blocked receipt scored as fraud", zap.String("receiptID", req.ReceiptID), zap.String("account", req.Account), zap.Float64("score", *check.Score))
+		return check, errReceiptBlocked
+	}
+	return check, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+// fraudScorer is a fraud provider scoring every receipt score, or failing with status when it is set.
+func fraudScorer(t *testing.T, score float64, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req FraudScoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReceiptID == "" {
+			t.Errorf("fraud provider got %+v (%v), want a receipt to score", req, err)
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]float64{"score": score})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestFraudProvider(t *testing.T) {
+	testCases := []struct {
+		name         string
+		action       string
+		score        float64
+		status       int
+		wantStatus   int
+		wantDecision string
+		wantCredited bool
+		wantFlagged  bool
+	}{
+		{name: "below threshold", action: fraudDecisionBlock, score: 0.2, wantStatus: http.StatusOK, wantDecision: fraudDecisionAllow, wantCredited: true},
+		{name: "log only", action: fraudDecisionLog, score: 0.9, wantStatus: http.StatusOK, wantDecision: fraudDecisionLog, wantCredited: true},
+		{name: "flag", action: fraudDecisionFlag, score: 0.9, wantStatus: http.StatusOK, wantDecision: fraudDecisionFlag, wantFlagged: true},
+		{name: "block", action: fraudDecisionBlock, score: 0.9, wantStatus: http.StatusUnprocessableEntity},
+		{name: "provider failing", action: fraudDecisionBlock, status: http.StatusBadGateway, wantStatus: http.StatusOK, wantDecision: fraudDecisionUnscored, wantCredited: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			server, _ := fraudScorer(t, tc.score, tc.status)
+			live := cfg
+			live.Fraud = FraudConfig{Review: true, Provider: FraudProviderConfig{Type: "http", URL: server.URL, Action: tc.action, Threshold: 0.8}}
+			liveConfig.Store(&live)
+
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().TransactionNumber("TX-1001").Build().JSON()))
+			req.Header.Set("X-Account-ID", "alice")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if receipts := pointsLedger.Receipts("alice"); len(receipts) != 0 {
+					t.Errorf("alice has receipts %v, want the blocked one not stored", receipts)
+				}
+				// the transaction number is free again.
+				live.Fraud = FraudConfig{}
+				liveConfig.Store(&live)
+				submitForAccount(t, router, "alice", receipttest.New().TransactionNumber("TX-1001").Build())
+				return
+			}
+
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if check := storedFraudCheck(rec.Receipt); check == nil || check.Decision != tc.wantDecision {
+				t.Errorf("stored fraud check = %+v, want decision %v", check, tc.wantDecision)
+			}
+			if balance, _ := pointsLedger.Balance("alice"); (balance > 0) != tc.wantCredited {
+				t.Errorf("alice's balance = %v, want credited %v", balance, tc.wantCredited)
+			}
+			if flags := fraudFlags.list(fraudSignalProvider); (len(flags) == 1) != tc.wantFlagged {
+				t.Errorf("flags = %+v, want flagged %v", flags, tc.wantFlagged)
+			}
+		})
+	}
+}
+
+func TestFraudCheckSurvivesAmendment(t *testing.T) {
+	router := setup()
+	server, _ := fraudScorer(t, 0.5, 0)
+	live := cfg
+	live.Fraud = FraudConfig{Provider: FraudProviderConfig{Type: "http", URL: server.URL, Action: fraudDecisionLog, Threshold: 0.8}}
+	live.Auth = AuthConfig{APIKeys: map[string]string{"static": "static-secret-0123456789"}}
+	liveConfig.Store(&live)
+
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON()))
+	req.Header.Set("X-API-Key", "static-secret-0123456789")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+
+	req = httptest.NewRequest("POST", "/receipts/"+resp.ID+"/items", bytes.NewReader([]byte(`{"shortDescription": "Dasani", "price": "1.00"}`)))
+	req.Header.Set("X-API-Key", "static-secret-0123456789")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("adding an item = %v %s, want 200", rr.Code, rr.Body)
+	}
+	rec, _ := receiptStore.Get(context.Background(), resp.ID)
+	if check := storedFraudCheck(rec.Receipt); check == nil || check.Decision != fraudDecisionAllow {
+		t.Errorf("stored fraud check after amending = %+v, want the original one", check)
+	}
+}
+
+func TestFraudProviderCircuitBreaker(t *testing.T) {
+	router := setup()
+	server, calls := fraudScorer(t, 0, http.StatusInternalServerError)
+	live := cfg
+	live.Fraud = FraudConfig{Provider: FraudProviderConfig{Type: "http", URL: server.URL, Action: fraudDecisionBlock, Threshold: 0.8, FailureThreshold: 2}}
+	liveConfig.Store(&live)
+
+	for range 4 {
+		submitForAccount(t, router, "alice", receipttest.New().Build())
+	}
+	if calls.Load() != 2 {
+		t.Errorf("fraud provider was called %v times, want the breaker open after 2 failures", calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{}
+	now := time.Now()
+	failed := errors.New("down")
+	b.done(failed, now, 2, time.Minute)
+	if !b.allow(now) {
+		t.Fatal("breaker opened after 1 failure of 2")
+	}
+	b.done(failed, now, 2, time.Minute)
+	if b.allow(now.Add(time.Second)) {
+		t.Fatal("breaker stayed closed after 2 failures")
+	}
+	if !b.allow(now.Add(time.Minute)) {
+		t.Fatal("breaker let no probe through after the cooldown")
+	}
+	if b.allow(now.Add(time.Minute)) {
+		t.Fatal("breaker let a second call through while probing")
+	}
+	b.done(failed, now.Add(time.Minute), 2, time.Minute)
+	if b.allow(now.Add(time.Minute + time.Second)) {
+		t.Fatal("breaker closed after the probe failed")
+	}
+	if !b.allow(now.Add(2 * time.Minute)) {
+		t.Fatal("breaker let no probe through after the second cooldown")
+	}
+	b.done(nil, now.Add(2*time.Minute), 2, time.Minute)
+	if !b.allow(now.Add(2*time.Minute)) || !b.allow(now.Add(2*time.Minute)) {
+		t.Fatal("breaker stayed open after the probe succeeded")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// seeds for the fuzzers, the fuzzing engine mutates these so they cover the interesting shapes.
+var fuzzSeeds = []string{
+	`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`,
+	`{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"}],"total":"4.50"}`,
+	`{"retailer":"A","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"abc","price":"99999999999999999999999999999999999999.99"}],"total":"99999999999999999999999999999999999999.00"}`,
+	`{"retailer":"\xff\xfe","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[],"total":"1.00"}`,
+	`{"items":[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]}`,
+	`[]`,
+	`null`,
+}
+
+func FuzzReceiptUnmarshalJSON(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var receipt Receipt
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			return
+		}
+
+		// anything that validates must score without panicking and never produce negative points.
+		if points := receipt.CalculatePoints(); points < 0 {
+			t.Errorf("CalculatePoints() = %v for %s, expected a non-negative value", points, data)
+		}
+
+		// and it must survive the round trip through its stored form.
+		payload, err := json.Marshal(receipt.ToDTO())
+		if err != nil {
+			t.Fatalf("Failed to marshal DTO: %v", err)
+		}
+		var again Receipt
+		if err := json.Unmarshal(payload, &again); err != nil {
+			t.Errorf("stored form %s doesn't decode back: %v", payload, err)
+		}
+	})
+}
+
+func FuzzCalculatePoints(f *testing.F) {
+	f.Add("Target", 6.49, "Mountain Dew 12PK", 6.49, 1, 13)
+	f.Add("", 0.0, "", 0.0, 31, 23)
+	f.Add("M&M", 1e308, "abc", 1e308, 2, 14)
+
+	f.Fuzz(func(t *testing.T, retailer string, total float64, description string, price float64, day int, hour int) {
+		receipt := Receipt{
+			Retailer:     retailer,
+			PurchaseDate: time.Date(2022, 1, day, 0, 0, 0, 0, time.UTC),
+			PurchaseTime: time.Date(0, 1, 1, hour, 0, 0, 0, time.UTC),
+			Items:        []Item{{ShortDescription: description, Price: price}},
+			Total:        total,
+		}
+		// the scoring code trusts validation, so only check it doesn't panic on anything.
+		receipt.CalculatePoints()
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// maxGroupSize is how many receipts one group can link, a shopping trip doesn't span more registers.
+const maxGroupSize = 20
+
+// ReceiptGroup links receipts of one shopping trip that was split across registers.
+type ReceiptGroup struct {
+	ID         string    `json:"id"`
+	ReceiptIDs []string  `json:"receiptIds"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type groupRequest struct {
+	ReceiptIDs []string `json:"receiptIds"`
+}
+
+func (r groupRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.ReceiptIDs, validation.Required, validation.Length(2, maxGroupSize).Error(fmt.Sprintf("must link 2 to %d receipts", maxGroupSize)),
+			validation.By(func(any) error {
+				seen := map[string]bool{}
+				for _, id := range r.ReceiptIDs {
+					if seen[id] {
+						return fmt.Errorf("lists %v more than once", id)
+					}
+					seen[id] = true
+				}
+				return nil
+			})),
+	)
+}
+
+// groupedReceiptError is returned for receipts that are already in another group.
+type groupedReceiptError struct {
+	receiptID, group string
+}
+
+func (e groupedReceiptError) Error() string {
+	return fmt.Sprintf("receipt %v is already in group %v", e.receiptID, e.group)
+}
+
+// groupRegistry holds the receipt groups. A receipt is in one group at most, so a trip can't be grouped twice and its
+// points counted twice.
+type groupRegistry struct {
+	mu        sync.Mutex
+	groups    map[string]ReceiptGroup
+	byReceipt map[string]string
+}
+
+var receiptGroups *groupRegistry
+
+func newGroupRegistry() *groupRegistry {
+	return &groupRegistry{groups: map[string]ReceiptGroup{}, byReceipt: map[string]string{}}
+}
+
+// add stores the group, unless one of its receipts is in another group. Grouping the same receipts again returns the
+// existing group and false.
+func (g *groupRegistry) add(group ReceiptGroup) (ReceiptGroup, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	existing, ok := g.groups[g.byReceipt[group.ReceiptIDs[0]]]
+	if ok && len(existing.ReceiptIDs) == len(group.ReceiptIDs) {
+		same := true
+		for _, id := range group.ReceiptIDs {
+			same = same && g.byReceipt[id] == existing.ID
+		}
+		if same {
+			return existing, false, nil
+		}
+	}
+	for _, id := range group.ReceiptIDs {
+		if other, ok := g.byReceipt[id]; ok {
+			return ReceiptGroup{}, false, groupedReceiptError{receiptID: id, group: other}
+		}
+	}
+	g.groups[group.ID] = group
+	for _, id := range group.ReceiptIDs {
+		g.byReceipt[id] = group.ID
+	}
+	return group, true, nil
+}
+
+func (g *groupRegistry) get(id string) (ReceiptGroup, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group, ok := g.groups[id]
+	return group, ok
+}
+
+// checkTrip makes sure the receipts are from the same retailer on the same day, as receipts of one trip are.
+func checkTrip(receipts []Receipt) error {
+	first := receipts[0]
+	for _, receipt := range receipts[1:] {
+		if normalizeRetailer(receipt.Retailer) != normalizeRetailer(first.Retailer) {
+			return errors.New("the receipts are from different retailers")
+		}
+		if !receipt.PurchaseDate.Equal(first.PurchaseDate) {
+			return errors.New("the receipts were not issued on the same day")
+		}
+	}
+	return nil
+}
+
+// createGroup serves POST /receipt-groups. The receipts have to exist and be from one trip. Creating a group of
+// receipts that are already grouped together returns that group with 200 instead of 201.
+func createGroup(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, "The request is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	receipts := make([]Receipt, len(req.ReceiptIDs))
+	for i, id := range req.ReceiptIDs {
+		receipt, _, err := loadReceipt(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("No receipt found for ID %v.", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		receipts[i] = receipt
+	}
+	if err := checkTrip(receipts); err != nil {
+		http.Error(w, "The receipts can't be grouped: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	group, created, err := receiptGroups.add(ReceiptGroup{ID: uuid.New().String(), ReceiptIDs: req.ReceiptIDs, CreatedAt: time.Now().UTC()})
+	if groupedErr := (groupedReceiptError{}); errors.As(err, &groupedErr) {
+		http.Error(w, fmt.Sprintf("Receipt %v is already in group %v.", groupedErr.receiptID, groupedErr.group), http.StatusConflict)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		logger.Debug("Created receipt group", zap.String("groupID", group.ID), zap.Strings("receiptIDs", group.ReceiptIDs))
+	}
+
+	jsonResponse, err := json.Marshal(group)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// getGroup serves GET /receipt-groups/{id}.
+func getGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := receiptGroups.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "No receipt group found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(group)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// groupPoints is the points of a group's receipts, in the order they were grouped. Receipts deleted since, e.g. by
+// retention, are left out.
+type groupPoints struct {
+	ID       string          `json:"id"`
+	Points   int64           `json:"points"`
+	Receipts []receiptPoints `json:"receipts"`
+}
+
+type receiptPoints struct {
+	ID     string `json:"id"`
+	Points int64  `json:"points"`
+}
+
+// getGroupPoints serves GET /receipt-groups/{id}/points, the points awarded for the whole trip.
+func getGroupPoints(w http.ResponseWriter, r *http.Request) {
+	group, ok := receiptGroups.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "No receipt group found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	response := groupPoints{ID: group.ID, Receipts: []receiptPoints{}}
+	for _, id := range group.ReceiptIDs {
+		rec, err := receiptStore.Get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		response.Points += rec.Points
+		response.Receipts = append(response.Receipts, receiptPoints{ID: id, Points: rec.Points})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
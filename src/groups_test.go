@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestReceiptGroups(t *testing.T) {
+	router := setup()
+	var points int64
+	for _, description := range []string{"Gatorade", "Dasani", "Doritos"} {
+		points += submitForAccount(t, router, "alice", receipttest.New().Item(description, "6.00").Build())
+	}
+	submitForAccount(t, router, "alice", receipttest.New().Retailer("Other Store").Build())
+	submitForAccount(t, router, "alice", receipttest.New().PurchaseDate("2022-01-02").Build())
+	ids := pointsLedger.Receipts("alice")
+
+	createGroup := func(receiptIDs ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"receiptIds": receiptIDs})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipt-groups", bytes.NewReader(body)))
+		return rr
+	}
+
+	var group ReceiptGroup
+	testCases := []struct {
+		name       string
+		receiptIDs []string
+		wantStatus int
+	}{
+		{name: "one receipt", receiptIDs: ids[:1], wantStatus: http.StatusBadRequest},
+		{name: "same receipt twice", receiptIDs: []string{ids[0], ids[0]}, wantStatus: http.StatusBadRequest},
+		{name: "unknown receipt", receiptIDs: []string{ids[0], "nope"}, wantStatus: http.StatusNotFound},
+		{name: "other retailer", receiptIDs: []string{ids[0], ids[3]}, wantStatus: http.StatusBadRequest},
+		{name: "other day", receiptIDs: []string{ids[0], ids[4]}, wantStatus: http.StatusBadRequest},
+		{name: "ok", receiptIDs: ids[:3], wantStatus: http.StatusCreated},
+		{name: "same group again", receiptIDs: []string{ids[2], ids[0], ids[1]}, wantStatus: http.StatusOK},
+		{name: "receipt in another group", receiptIDs: ids[1:3], wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := createGroup(tc.receiptIDs...)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if rr.Code == http.StatusCreated {
+				json.Unmarshal(rr.Body.Bytes(), &group)
+			} else if rr.Code == http.StatusOK {
+				var again ReceiptGroup
+				json.Unmarshal(rr.Body.Bytes(), &again)
+				if again.ID != group.ID {
+					t.Errorf("group = %v, want the existing group %v", again.ID, group.ID)
+				}
+			}
+		})
+	}
+
+	t.Run("get", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipt-groups/"+group.ID, nil))
+		var got ReceiptGroup
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		if rr.Code != http.StatusOK || len(got.ReceiptIDs) != 3 {
+			t.Errorf("got %v %s, want the group with its 3 receipts", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("points", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipt-groups/"+group.ID+"/points", nil))
+		var got groupPoints
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		if rr.Code != http.StatusOK || got.Points != points || len(got.Receipts) != 3 {
+			t.Errorf("got %v %s, want %v points over 3 receipts", rr.Code, rr.Body, points)
+		}
+	})
+
+	t.Run("deleted receipt", func(t *testing.T) {
+		receiptStore.Delete(t.Context(), ids[0])
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipt-groups/"+group.ID+"/points", nil))
+		var got groupPoints
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		if len(got.Receipts) != 2 {
+			t.Errorf("receipts = %v, want the deleted one left out", got.Receipts)
+		}
+	})
+
+	t.Run("unknown group", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipt-groups/nope/points", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("got %v, want 404", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// HoldsConfig credits points in two phases, the way card-linked programs settle: a processed receipt only places a
+// hold on its points, which are credited SettleAfter later. Until then they show up as held, not in the balance, and
+// can't be transferred. Receipts held for review settle with the review instead. Off unless SettleAfter is set.
+type HoldsConfig struct {
+	SettleAfter Duration `json:"settleAfter"`
+}
+
+func (c HoldsConfig) Validate() error {
+	if c.SettleAfter < 0 {
+		return fmt.Errorf("holds: settleAfter must not be negative")
+	}
+	return nil
+}
+
+// PointsHold is the points of a receipt waiting to settle.
+type PointsHold struct {
+	ReceiptID string    `json:"receiptId"`
+	Account   string    `json:"account"`
+	Points    int64     `json:"points"`
+	PlacedAt  time.Time `json:"placedAt"`
+	SettlesAt time.Time `json:"settlesAt"`
+}
+
+// holdRegistry keeps the holds until they settle. Like the ledger, it is kept in memory.
+type holdRegistry struct {
+	mu    sync.Mutex
+	holds map[string]PointsHold
+}
+
+var holds *holdRegistry
+
+func newHoldRegistry() *holdRegistry {
+	return &holdRegistry{holds: map[string]PointsHold{}}
+}
+
+func init() {
+	onErasure(func(account string, receipts []string) { holds.erase(account, receipts) })
+}
+
+func (h *holdRegistry) place(hold PointsHold) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.holds[hold.ReceiptID] = hold
+}
+
+// pending reports whether receiptID's points are on hold.
+func (h *holdRegistry) pending(receiptID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.holds[receiptID]
+	return ok
+}
+
+func (h *holdRegistry) remove(receiptID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.holds, receiptID)
+}
+
+// erase voids the holds of an erased account, and those of its receipts held under an alias before a merge. Their
+// receipts are deleted, so they would earn nothing anyway.
+func (h *holdRegistry) erase(account string, receipts []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, hold := range h.holds {
+		if hold.Account == account || slices.Contains(receipts, id) {
+			delete(h.holds, id)
+		}
+	}
+}
+
+// forAccount returns the holds of account, and of the accounts merged into it, by when they settle.
+func (h *holdRegistry) forAccount(account string) []PointsHold {
+	account = pointsLedger.Resolve(account)
+	h.mu.Lock()
+	var list []PointsHold
+	for _, hold := range h.holds {
+		list = append(list, hold)
+	}
+	h.mu.Unlock()
+
+	list = slices.DeleteFunc(list, func(hold PointsHold) bool { return pointsLedger.Resolve(hold.Account) != account })
+	slices.SortFunc(list, func(a, b PointsHold) int { return a.SettlesAt.Compare(b.SettlesAt) })
+	return list
+}
+
+// held sums the points on hold for account.
+func (h *holdRegistry) held(account string) int64 {
+	var points int64
+	for _, hold := range h.forAccount(account) {
+		points += hold.Points
+	}
+	return points
+}
+
+// settleDue credits the holds that are due. A hold that fails to settle is retried on the next run.
+func (h *holdRegistry) settleDue(ctx context.Context, now time.Time) {
+	h.mu.Lock()
+	var due []string
+	for id, hold := range h.holds {
+		if !now.Before(hold.SettlesAt) {
+			due = append(due, id)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, id := range due {
+		if err := h.settle(ctx, id); err != nil {
+			logger.Error("Failed to settle points hold, will retry", zap.String("receiptID", id), zap.Error(err))
+		}
+	}
+}
+
+// settle credits the hold on receiptID with what the receipt is worth now, in case it was amended while held. A
+// receipt deleted in the meantime, e.g. by an erasure, earns nothing.
+func (h *holdRegistry) settle(ctx context.Context, receiptID string) error {
+	unlock := lockReceiptAccount(receiptID)
+	defer unlock()
+	if !h.pending(receiptID) {
+		return nil
+	}
+
+	var points int64
+	rec, err := receiptStore.Get(ctx, receiptID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		points = rec.Points
+	}
+	if points > 0 {
+		account, _, err := pointsLedger.Release(receiptID, points)
+		switch {
+		case errors.Is(err, ledger.ErrUnownedReceipt):
+			points = 0
+		case err != nil:
+			return err
+		default:
+			events.Publish(Event{Type: EventPointsEarned, Account: account, ReceiptID: receiptID, Points: points})
+		}
+	}
+	h.remove(receiptID)
+	logger.Debug("Settled points hold", zap.String("receiptID", receiptID), zap.Int64("points", points))
+	return nil
+}
+
+// startHoldSettlement settles the due holds every minute.
+func startHoldSettlement(ctx context.Context) {
+	go runPeriodically(ctx, time.Minute, func(ctx context.Context) {
+		holds.settleDue(ctx, time.Now())
+	})
+}
+
+// listHolds serves GET /accounts/{id}/holds, the points of the account waiting to settle.
+func listHolds(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := pointsLedger.Balance(id); err != nil {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	list := holds.forAccount(id)
+	if list == nil {
+		list = []PointsHold{}
+	}
+	jsonResponse, err := json.Marshal(map[string][]PointsHold{"holds": list})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
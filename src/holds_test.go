@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/gorilla/mux"
+)
+
+func getBalanceAndHeld(t *testing.T, router *mux.Router, account string) (balance, held int64) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/"+account+"/balance", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /accounts/%s/balance = %v %s", account, rr.Code, rr.Body)
+	}
+	var resp struct {
+		Balance int64 `json:"balance"`
+		Held    int64 `json:"held"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp.Balance, resp.Held
+}
+
+func TestHolds(t *testing.T) {
+	testCases := []struct {
+		name        string
+		settleAfter time.Duration
+		// change is applied to the stored receipt while it is held, e.g. an amendment.
+		change      func(t *testing.T, id string)
+		settleAt    time.Duration
+		wantHeld    bool
+		wantSettled func(points int64) int64
+	}{
+		{name: "off", wantSettled: func(points int64) int64 { return points }},
+		{name: "not due yet", settleAfter: time.Hour, settleAt: 30 * time.Minute, wantHeld: true, wantSettled: func(int64) int64 { return 0 }},
+		{name: "settled", settleAfter: time.Hour, settleAt: time.Hour, wantHeld: true, wantSettled: func(points int64) int64 { return points }},
+		{
+			name: "amended while held", settleAfter: time.Hour, settleAt: 2 * time.Hour, wantHeld: true,
+			change: func(t *testing.T, id string) {
+				rec, err := receiptStore.Get(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rec.Points = 3
+				receiptStore.Update(context.Background(), rec)
+			},
+			wantSettled: func(int64) int64 { return 3 },
+		},
+		{
+			name: "deleted while held", settleAfter: time.Hour, settleAt: 2 * time.Hour, wantHeld: true,
+			change: func(t *testing.T, id string) {
+				receiptStore.Delete(context.Background(), id)
+			},
+			wantSettled: func(int64) int64 { return 0 },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.Holds = HoldsConfig{SettleAfter: Duration(tc.settleAfter)}
+			liveConfig.Store(&live)
+
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON()))
+			req.Header.Set("X-Account-ID", "alice")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			var resp struct {
+				ID   string `json:"id"`
+				Held bool   `json:"held"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if rr.Code != http.StatusOK || resp.Held != tc.wantHeld {
+				t.Fatalf("POST /receipts/process = %v %s, want held: %v", rr.Code, rr.Body, tc.wantHeld)
+			}
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			balance, held := getBalanceAndHeld(t, router, "alice")
+			if tc.wantHeld && (balance != 0 || held != rec.Points) {
+				t.Errorf("balance %d and %d held, want the receipt's %d points held", balance, held, rec.Points)
+			}
+			if tc.change != nil {
+				tc.change(t, resp.ID)
+			}
+
+			holds.settleDue(context.Background(), time.Now().Add(tc.settleAt))
+			if tc.settleAt < tc.settleAfter {
+				return
+			}
+			balance, held = getBalanceAndHeld(t, router, "alice")
+			if want := tc.wantSettled(rec.Points); balance != want || held != 0 {
+				t.Errorf("balance %d and %d held after settling, want %d and none held", balance, held, want)
+			}
+		})
+	}
+}
+
+func TestHoldsEndpoint(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Holds = HoldsConfig{SettleAfter: Duration(72 * time.Hour)}
+	liveConfig.Store(&live)
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	submitForAccount(t, router, "bob", receipttest.New().Build())
+	if _, err := pointsLedger.Merge("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/holds", nil))
+	var resp map[string][]PointsHold
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp["holds"]) != 2 {
+		t.Fatalf("GET /accounts/alice/holds = %v %s, want the holds of alice and bob", rr.Code, rr.Body)
+	}
+	hold := resp["holds"][0]
+	if hold.Points <= 0 || hold.SettlesAt.Sub(hold.PlacedAt) != 72*time.Hour {
+		t.Errorf("hold = %+v, want the receipt's points settling in 72h", hold)
+	}
+
+	// the points aren't credited yet, so there is nothing to return.
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+hold.ReceiptID+"/returns", bytes.NewReader([]byte(`{"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}]}`))))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("returning items of a held receipt = %v %s, want 409", rr.Code, rr.Body)
+	}
+}
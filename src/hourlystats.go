@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+// HourlyStats sums up the stored receipts purchased in one hour of the day, 0 to 23.
+type HourlyStats struct {
+	Hour     int   `json:"hour"`
+	Receipts int   `json:"receipts"`
+	Points   int64 `json:"points"`
+}
+
+// WeekdayStats sums up the stored receipts purchased on one day of the week, and by hour of that day.
+type WeekdayStats struct {
+	Weekday  string        `json:"weekday"`
+	Receipts int           `json:"receipts"`
+	Points   int64         `json:"points"`
+	Hours    []HourlyStats `json:"hours"`
+}
+
+// hourlyStatsResponse is the response of GET /stats/hourly: the receipts by hour of the day across all days, and the
+// heatmap of every hour of every day of the week, Sunday first.
+type hourlyStatsResponse struct {
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Hours    []HourlyStats  `json:"hours"`
+	Weekdays []WeekdayStats `json:"weekdays"`
+}
+
+// purchaseSlot returns the weekday and hour of a purchase date and hour, as their group for groupDaily.
+func purchaseSlot(purchaseDate, hour string) string {
+	date, err := time.Parse(time.DateOnly, purchaseDate)
+	if err != nil {
+		return ""
+	}
+	at, err := time.Parse("15", hour)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", date.Weekday(), at.Hour())
+}
+
+// getHourlyStats serves GET /stats/hourly?from=&to=, grouping the stored receipts purchased between the two inclusive
+// dates (all of them by default) by the day of the week and hour of their purchase date and time, to see when the
+// time window rules like afternoonPurchase would pay off. Purchase times are the store's local time.
+func getHourlyStats(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := statsDates(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := groupDaily(r.Context(), store.DailyHour, from, to, purchaseSlot)
+	if err != nil {
+		logger.Error("Failed to read daily aggregates", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	response := hourlyStatsResponse{From: from, To: to, Hours: make([]HourlyStats, 24), Weekdays: make([]WeekdayStats, 7)}
+	for hour := range response.Hours {
+		response.Hours[hour].Hour = hour
+	}
+	for weekday := range response.Weekdays {
+		day := &response.Weekdays[weekday]
+		day.Weekday, day.Hours = time.Weekday(weekday).String(), make([]HourlyStats, 24)
+		for hour := range day.Hours {
+			day.Hours[hour].Hour = hour
+			totals := groups[fmt.Sprintf("%d/%d", weekday, hour)]
+			if totals == nil {
+				continue
+			}
+			day.Hours[hour].Receipts, day.Hours[hour].Points = totals.receipts, totals.points
+			day.Receipts += totals.receipts
+			day.Points += totals.points
+			response.Hours[hour].Receipts += totals.receipts
+			response.Hours[hour].Points += totals.points
+		}
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestGetHourlyStats(t *testing.T) {
+	router := setup()
+	points := map[string]int64{}
+	for _, receipt := range []struct{ date, time string }{
+		{"2022-01-01", "13:01"},
+		{"2022-01-01", "14:30"},
+		{"2022-01-03", "14:05"},
+		{"2022-02-06", "09:00"},
+	} {
+		points[receipt.date+" "+receipt.time] = submitForAccount(t, router, "", receipttest.New().PurchaseDate(receipt.date).PurchaseTime(receipt.time).Build())
+	}
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		// wantHours is the receipts by hour, wantCells by weekday and hour.
+		wantHours map[int]int
+		wantCells map[string]map[int]int
+	}{
+		{
+			name: "all", wantStatus: http.StatusOK,
+			wantHours: map[int]int{9: 1, 13: 1, 14: 2},
+			wantCells: map[string]map[int]int{"Saturday": {13: 1, 14: 1}, "Monday": {14: 1}, "Sunday": {9: 1}},
+		},
+		{
+			name: "january", query: "?from=2022-01-02&to=2022-01-31", wantStatus: http.StatusOK,
+			wantHours: map[int]int{14: 1},
+			wantCells: map[string]map[int]int{"Monday": {14: 1}},
+		},
+		{name: "invalid date", query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/stats/hourly"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("GET /stats/hourly%s = %v %s, want %v", tc.query, rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp hourlyStatsResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if len(resp.Hours) != 24 || len(resp.Weekdays) != 7 || resp.Weekdays[0].Weekday != "Sunday" {
+				t.Fatalf("got %d hours and %d weekdays starting with %+v, want 24 and 7 starting on Sunday", len(resp.Hours), len(resp.Weekdays), resp.Weekdays[0])
+			}
+			for _, hour := range resp.Hours {
+				if hour.Receipts != tc.wantHours[hour.Hour] {
+					t.Errorf("%d:00 has %d receipts, want %d", hour.Hour, hour.Receipts, tc.wantHours[hour.Hour])
+				}
+			}
+			for _, day := range resp.Weekdays {
+				for _, hour := range day.Hours {
+					if want := tc.wantCells[day.Weekday][hour.Hour]; hour.Receipts != want {
+						t.Errorf("%s %d:00 has %d receipts, want %d", day.Weekday, hour.Hour, hour.Receipts, want)
+					}
+				}
+			}
+			if tc.query == "" && resp.Weekdays[6].Points != points["2022-01-01 13:01"]+points["2022-01-01 14:30"] {
+				t.Errorf("Saturday has %d points, want %d", resp.Weekdays[6].Points, points["2022-01-01 13:01"]+points["2022-01-01 14:30"])
+			}
+		})
+	}
+}
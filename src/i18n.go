@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// translations are the errors /receipts/process returns, by language and English text. Messages without a
+// translation stay in English.
+var translations = map[string]map[string]string{
+	"es": {
+		"The receipt is invalid.":                                             "El recibo no es válido.",
+		"The account ID is invalid.":                                          "El ID de la cuenta no es válido.",
+		"The account has reached its daily receipt limit.":                    "La cuenta alcanzó su límite diario de recibos.",
+		"The account has reached its daily limit of %d receipts from %s.":     "La cuenta alcanzó su límite diario de %d recibos de %s.",
+		"A receipt with this transaction number was already submitted as %s.": "Ya se envió un recibo con este número de transacción como %s.",
+		"The receipt was rejected.":                                           "El recibo fue rechazado.",
+		"No receipt template found for the template of the receipt.":          "No se encontró la plantilla del recibo.",
+		"The receipt is larger than %d bytes.":                                "El recibo ocupa más de %d bytes.",
+	},
+	"fr": {
+		"The receipt is invalid.":                                             "Le reçu n'est pas valide.",
+		"The account ID is invalid.":                                          "L'identifiant du compte n'est pas valide.",
+		"The account has reached its daily receipt limit.":                    "Le compte a atteint sa limite quotidienne de reçus.",
+		"The account has reached its daily limit of %d receipts from %s.":     "Le compte a atteint sa limite quotidienne de %d reçus de %s.",
+		"A receipt with this transaction number was already submitted as %s.": "Un reçu avec ce numéro de transaction a déjà été envoyé sous %s.",
+		"The receipt was rejected.":                                           "Le reçu a été refusé.",
+		"No receipt template found for the template of the receipt.":          "Le modèle du reçu est introuvable.",
+		"The receipt is larger than %d bytes.":                                "Le reçu dépasse %d octets.",
+	},
+}
+
+// localeLanguage is the language of a locale like en-US.
+func localeLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return language
+}
+
+// localizedError is http.Error with the message formatted in the language of locale, English if it has no
+// translation.
+func localizedError(w http.ResponseWriter, locale string, status int, format string, args ...any) {
+	language := "en"
+	if translated, ok := translations[localeLanguage(locale)][format]; ok {
+		format, language = translated, localeLanguage(locale)
+	}
+	w.Header().Set("Content-Language", language)
+	http.Error(w, fmt.Sprintf(format, args...), status)
+}
+
+// supportedLanguages are the languages errors can be in, English first.
+func supportedLanguages() []string {
+	languages := []string{}
+	for language := range translations {
+		languages = append(languages, language)
+	}
+	slices.Sort(languages)
+	return append([]string{"en"}, languages...)
+}
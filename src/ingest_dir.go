@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// watchDir ingests everything already in the directory and, if a poll interval is configured, keeps picking up new
+// files until ctx is cancelled. Polling is used instead of inotify because SFTP drops are often on network mounts.
+func watchDir(ctx context.Context, c IngestConfig) {
+	logger.Info("Watching directory for receipts", zap.String("dir", c.Dir), zap.Duration("pollInterval", time.Duration(c.PollInterval)))
+
+	runPeriodically(ctx, time.Duration(c.PollInterval), func(ctx context.Context) {
+		if err := ingestDir(ctx, c); err != nil {
+			logger.Error("Failed to ingest directory", zap.String("dir", c.Dir), zap.Error(err))
+		}
+	})
+}
+
+// runPeriodically calls fn right away and then every interval until ctx is done. A zero interval means run once.
+func runPeriodically(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	fn(ctx)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// ingestDir makes a single pass over the directory. Each *.json file gets a <name>.result.json in ResultDir and is
+// then moved to ProcessedDir or FailedDir so it is never picked up twice. Files modified within the settle time may
+// still be being written and wait for the next pass, hidden files are temporary ones of upload tools and are skipped.
+func ingestDir(ctx context.Context, c IngestConfig) error {
+	for _, dir := range []string{c.ResultDir, c.ProcessedDir, c.FailedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating %s: %w", dir, err)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Error("Failed to ingest file", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if time.Since(info.ModTime()) < c.settleTime() {
+			logger.Debug("Leaving file that may still be written", zap.String("path", path))
+			continue
+		}
+		if err := ingestFile(ctx, c, path); err != nil {
+			// keep going, one bad file shouldn't block the rest of the drop.
+			logger.Error("Failed to ingest file", zap.String("path", path), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func ingestFile(ctx context.Context, c IngestConfig, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	result := processReceiptData(ctx, data)
+	logger.Debug("Ingested file", zap.String("path", path), zap.Any("result", result))
+
+	name := filepath.Base(path)
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	resultPath := filepath.Join(c.ResultDir, strings.TrimSuffix(name, ".json")+".result.json")
+	if err := os.WriteFile(resultPath, resultJSON, 0o644); err != nil {
+		return err
+	}
+
+	dest := c.ProcessedDir
+	if result.Error != "" {
+		dest = c.FailedDir
+	}
+	return os.Rename(path, filepath.Join(dest, name))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIngestDir(t *testing.T) {
+	setup()
+
+	dir := t.TempDir()
+	c := IngestConfig{Dir: dir}
+	c.setDefaults()
+
+	files := map[string]string{
+		"good.json": `{
+			"retailer": "Target",
+			"purchaseDate": "2022-01-01",
+			"purchaseTime": "13:01",
+			"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+			"total": "6.49"
+		}`,
+		"bad.json":    `{"retailer": "Target"}`,
+		"ignored.txt": `not a receipt`,
+		".temp.json":  `{"retailer": "Tar`,
+	}
+	settled := time.Now().Add(-time.Minute)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, settled, settled); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// still being uploaded.
+	if err := os.WriteFile(filepath.Join(dir, "partial.json"), []byte(`{"retailer": "Tar`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ingestDir(context.Background(), c); err != nil {
+		t.Fatalf("ingestDir() error = %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		wantDir string
+		wantErr bool
+	}{
+		{name: "good", wantDir: c.ProcessedDir, wantErr: false},
+		{name: "bad", wantDir: c.FailedDir, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := os.Stat(filepath.Join(tc.wantDir, tc.name+".json")); err != nil {
+				t.Errorf("input was not moved to %v: %v", tc.wantDir, err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(c.ResultDir, tc.name+".result.json"))
+			if err != nil {
+				t.Fatalf("result file missing: %v", err)
+			}
+			var result ingestResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatalf("Failed to parse result: %v", err)
+			}
+
+			if gotErr := result.Error != ""; gotErr != tc.wantErr {
+				t.Errorf("result error = %q, want error %v", result.Error, tc.wantErr)
+			}
+			if !tc.wantErr {
+				if _, err := receiptStore.Get(context.Background(), result.ID); err != nil {
+					t.Errorf("receipt %v was not stored", result.ID)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"ignored.txt", ".temp.json", "partial.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should be left alone: %v", name, err)
+		}
+	}
+}
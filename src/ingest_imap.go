@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"go.uber.org/zap"
+)
+
+// IMAPConfig configures the optional mailbox ingester for forwarded e-receipts. It is disabled when Address is empty.
+type IMAPConfig struct {
+	Address  string   `json:"address"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	Mailbox  string   `json:"mailbox"`
+	Interval Duration `json:"interval"`
+}
+
+// keywords set on messages once they've been looked at, so they're skipped on the next poll and a human going through
+// the mailbox can see what happened.
+const (
+	imapProcessedFlag = "fcpc-processed"
+	imapFailedFlag    = "fcpc-failed"
+)
+
+var errNoReceiptAttachment = errors.New("no JSON receipt found in message")
+
+type mailMessage struct {
+	UID uint32
+	Raw []byte
+}
+
+// mailbox is what the poller needs from an IMAP connection, kept narrow so it can be faked in tests.
+type mailbox interface {
+	Unprocessed() ([]mailMessage, error)
+	Tag(uid uint32, flags ...string) error
+	Close() error
+}
+
+func startIMAPIngester(ctx context.Context, c IMAPConfig) {
+	logger.Info("Polling mailbox for receipts", zap.String("address", c.Address), zap.String("mailbox", c.Mailbox))
+	runPeriodically(ctx, time.Duration(c.Interval), func(ctx context.Context) {
+		mb, err := dialIMAP(c)
+		if err != nil {
+			logger.Error("Failed to connect to mailbox", zap.Error(err))
+			return
+		}
+		defer mb.Close()
+
+		if err := pollMailbox(ctx, mb); err != nil {
+			logger.Error("Failed to poll mailbox", zap.Error(err))
+		}
+	})
+}
+
+// pollMailbox processes every receipt attachment in every unprocessed message and tags the message with the outcome.
+// A message fails if it has no receipt or any of its receipts is rejected.
+func pollMailbox(ctx context.Context, mb mailbox) error {
+	messages, err := mb.Unprocessed()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		receipts, err := extractReceipts(msg.Raw)
+		failed := err != nil
+		if err != nil {
+			logger.Info("Skipping message", zap.Uint32("uid", msg.UID), zap.Error(err))
+		}
+		for name, data := range receipts {
+			result := processReceiptData(ctx, data)
+			logger.Info("Ingested emailed receipt", zap.Uint32("uid", msg.UID), zap.String("attachment", name), zap.Any("result", result))
+			failed = failed || result.Error != ""
+		}
+
+		flags := []string{imapProcessedFlag}
+		if failed {
+			flags = append(flags, imapFailedFlag)
+		}
+		if err := mb.Tag(msg.UID, flags...); err != nil {
+			return fmt.Errorf("tagging message %d: %w", msg.UID, err)
+		}
+	}
+	return nil
+}
+
+// extractReceipts walks a MIME message and returns every JSON part, keyed by filename (or part index if unnamed).
+func extractReceipts(raw []byte) (map[string][]byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := map[string][]byte{}
+	if err := walkPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, receipts); err != nil {
+		return nil, err
+	}
+	if len(receipts) == 0 {
+		return nil, errNoReceiptAttachment
+	}
+	return receipts, nil
+}
+
+func walkPart(contentType, encoding, filename string, body io.Reader, receipts map[string][]byte) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// a part without a usable content type can't be one of ours.
+		return nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = walkPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.FileName(), part, receipts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if mediaType != "application/json" && filepath.Ext(filename) != ".json" {
+		return nil
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	if filename == "" {
+		filename = fmt.Sprintf("part-%d.json", len(receipts))
+	}
+	receipts[filename] = data
+	return nil
+}
+
+type imapMailbox struct {
+	client *client.Client
+}
+
+func dialIMAP(c IMAPConfig) (mailbox, error) {
+	cl, err := client.DialTLS(c.Address, &tls.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cl.Login(c.User, c.Password); err != nil {
+		cl.Logout()
+		return nil, err
+	}
+
+	name := c.Mailbox
+	if name == "" {
+		name = "INBOX"
+	}
+	if _, err := cl.Select(name, false); err != nil {
+		cl.Logout()
+		return nil, err
+	}
+	return &imapMailbox{client: cl}, nil
+}
+
+func (m *imapMailbox) Unprocessed() ([]mailMessage, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imapProcessedFlag}
+	uids, err := m.client.UidSearch(criteria)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	section := &imap.BodySectionName{Peek: true}
+
+	ch := make(chan *imap.Message, len(uids))
+	if err := m.client.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, ch); err != nil {
+		return nil, err
+	}
+
+	var messages []mailMessage
+	for msg := range ch {
+		body := msg.GetBody(section)
+		if body == nil {
+			continue
+		}
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, mailMessage{UID: msg.Uid, Raw: raw})
+	}
+	return messages, nil
+}
+
+func (m *imapMailbox) Tag(uid uint32, flags ...string) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = flag
+	}
+	return m.client.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), values, nil)
+}
+
+func (m *imapMailbox) Close() error {
+	return m.client.Logout()
+}
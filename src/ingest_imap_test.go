@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fakeMailbox struct {
+	messages []mailMessage
+	tags     map[uint32][]string
+}
+
+func (f *fakeMailbox) Unprocessed() ([]mailMessage, error) { return f.messages, nil }
+
+func (f *fakeMailbox) Tag(uid uint32, flags ...string) error {
+	f.tags[uid] = flags
+	return nil
+}
+
+func (f *fakeMailbox) Close() error { return nil }
+
+const emailedReceipt = `{
+	"retailer": "Target",
+	"purchaseDate": "2022-01-01",
+	"purchaseTime": "13:01",
+	"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+	"total": "6.49"
+}`
+
+func multipartEmail(attachment string) []byte {
+	return []byte(strings.ReplaceAll(`From: shopper@example.com
+Subject: Fwd: your receipt
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+See attached.
+--b1
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="receipt.json"
+Content-Transfer-Encoding: base64
+
+`+base64.StdEncoding.EncodeToString([]byte(attachment))+`
+--b1--
+`, "\n", "\r\n"))
+}
+
+func TestExtractReceipts(t *testing.T) {
+	testCases := []struct {
+		name      string
+		raw       []byte
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "base64 attachment matched by filename",
+			raw:       multipartEmail(emailedReceipt),
+			wantNames: []string{"receipt.json"},
+		},
+		{
+			name:      "json body",
+			raw:       []byte("Content-Type: application/json\r\n\r\n" + emailedReceipt),
+			wantNames: []string{"part-0.json"},
+		},
+		{
+			name:    "plain text only",
+			raw:     []byte("Content-Type: text/plain\r\n\r\nhello"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receipts, err := extractReceipts(tc.raw)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("extractReceipts() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			for _, name := range tc.wantNames {
+				if strings.TrimSpace(string(receipts[name])) != emailedReceipt {
+					t.Errorf("receipt %v = %q, want the attached receipt", name, receipts[name])
+				}
+			}
+		})
+	}
+}
+
+func TestPollMailbox(t *testing.T) {
+	setup()
+
+	mb := &fakeMailbox{
+		messages: []mailMessage{
+			{UID: 1, Raw: multipartEmail(emailedReceipt)},
+			{UID: 2, Raw: multipartEmail(`{"retailer": "Target"}`)},
+			{UID: 3, Raw: []byte("Content-Type: text/plain\r\n\r\nhello")},
+		},
+		tags: map[uint32][]string{},
+	}
+
+	if err := pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("pollMailbox() error = %v", err)
+	}
+
+	testCases := []struct {
+		uid        uint32
+		wantFailed bool
+	}{
+		{uid: 1, wantFailed: false},
+		{uid: 2, wantFailed: true},
+		{uid: 3, wantFailed: true},
+	}
+	for _, tc := range testCases {
+		tags := mb.tags[tc.uid]
+		if !slices.Contains(tags, imapProcessedFlag) {
+			t.Errorf("message %d tags = %v, want %v", tc.uid, tags, imapProcessedFlag)
+		}
+		if got := slices.Contains(tags, imapFailedFlag); got != tc.wantFailed {
+			t.Errorf("message %d failed = %v, want %v", tc.uid, got, tc.wantFailed)
+		}
+	}
+}
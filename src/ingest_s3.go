@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.uber.org/zap"
+)
+
+// S3IngestConfig configures the worker consuming S3 object-created notifications from an SQS queue. It is disabled
+// when QueueURL is empty. Credentials come from the standard AWS chain (env, shared config, instance role).
+type S3IngestConfig struct {
+	QueueURL string `json:"queueUrl"`
+	Region   string `json:"region"`
+	// MaxResults is how many object results are kept for GET /ingest/s3/results, the oldest go first. Defaults to
+	// 10000.
+	MaxResults int `json:"maxResults"`
+}
+
+func (c S3IngestConfig) maxResults() int {
+	if c.MaxResults == 0 {
+		return 10000
+	}
+	return c.MaxResults
+}
+
+// sqsAPI and s3API are the SDK calls the worker makes, so they can be faked in tests.
+type sqsAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// s3Event is the subset of the S3 event notification payload we care about.
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+				// Sequencer orders the events of a key, a new upload of the key gets a greater one.
+				Sequencer string `json:"sequencer"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// s3Result is what happened to an object, and the sequencer of the upload it was for.
+type s3Result struct {
+	ingestResult
+	sequencer string
+}
+
+// s3ResultLog keeps the results of the latest objects keyed by "s3://bucket/key", so partners can look up what happened
+// to an object they uploaded, and a redelivered notification isn't processed again.
+type s3ResultLog struct {
+	mu      sync.Mutex
+	results map[string]s3Result
+	order   []string
+}
+
+var s3Results = &s3ResultLog{}
+
+func (l *s3ResultLog) Load(object string) (s3Result, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result, ok := l.results[object]
+	return result, ok
+}
+
+// Store records the result of an object, evicting the oldest objects past s3Ingest.maxResults.
+func (l *s3ResultLog) Store(object string, result s3Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.results == nil {
+		l.results = map[string]s3Result{}
+	}
+	if _, ok := l.results[object]; !ok {
+		l.order = append(l.order, object)
+	}
+	l.results[object] = result
+	for max := currentConfig().S3Ingest.maxResults(); len(l.order) > max; l.order = l.order[1:] {
+		delete(l.results, l.order[0])
+	}
+}
+
+type s3Worker struct {
+	queueURL string
+	sqs      sqsAPI
+	s3       s3API
+}
+
+func startS3Ingester(ctx context.Context, c S3IngestConfig) error {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(c.Region))
+	if err != nil {
+		return fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	w := &s3Worker{queueURL: c.QueueURL, sqs: sqs.NewFromConfig(awsCfg), s3: s3.NewFromConfig(awsCfg)}
+	logger.Info("Consuming S3 notifications", zap.String("queueURL", c.QueueURL))
+	go func() {
+		for ctx.Err() == nil {
+			if err := w.poll(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to poll S3 notification queue", zap.Error(err))
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}()
+	return nil
+}
+
+// poll long-polls the queue once. A message is only deleted when every object in it was handled, otherwise SQS
+// redelivers it after the visibility timeout. Invalid receipts count as handled, retrying them wouldn't help.
+func (w *s3Worker) poll(ctx context.Context) error {
+	out, err := w.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(w.queueURL),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     20,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range out.Messages {
+		if err := w.handleMessage(ctx, aws.ToString(msg.Body)); err != nil {
+			logger.Error("Failed to handle S3 notification", zap.String("messageID", aws.ToString(msg.MessageId)), zap.Error(err))
+			continue
+		}
+		_, err := w.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(w.queueURL),
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *s3Worker) handleMessage(ctx context.Context, body string) error {
+	var event s3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		// not something we can ever process, e.g. the s3:TestEvent sent when notifications are configured.
+		logger.Info("Ignoring non-event message", zap.Error(err))
+		return nil
+	}
+
+	for _, record := range event.Records {
+		// keys in notifications are URL encoded, with spaces as '+'.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return fmt.Errorf("decoding key %q: %w", record.S3.Object.Key, err)
+		}
+		bucket := record.S3.Bucket.Name
+		objectPath := "s3://" + bucket + "/" + key
+
+		// SQS delivers at least once, and a message is retried whole when one of its objects failed.
+		if done, ok := s3Results.Load(objectPath); ok && done.sequencer == record.S3.Object.Sequencer {
+			logger.Info("Skipping S3 object already ingested", zap.String("object", objectPath))
+			continue
+		}
+
+		obj, err := w.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return fmt.Errorf("getting s3://%s/%s: %w", bucket, key, err)
+		}
+		max := currentConfig().ReceiptLimits.maxBodyBytes()
+		data, err := io.ReadAll(io.LimitReader(obj.Body, int64(max)+1))
+		obj.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		var result ingestResult
+		if len(data) > max {
+			result.Error = fmt.Sprintf("The receipt is larger than %d bytes.", max)
+		} else {
+			result = processReceiptData(ctx, data)
+		}
+		s3Results.Store(objectPath, s3Result{ingestResult: result, sequencer: record.S3.Object.Sequencer})
+		logger.Info("Ingested S3 object", zap.String("object", objectPath), zap.Any("result", result))
+	}
+	return nil
+}
+
+// getS3Result serves GET /ingest/s3/results?object=s3://bucket/key.
+func getS3Result(w http.ResponseWriter, r *http.Request) {
+	result, ok := s3Results.Load(r.URL.Query().Get("object"))
+	if !ok {
+		http.Error(w, "No result found for that object.", http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(result.ingestResult)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
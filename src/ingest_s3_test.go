@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+type fakeSQS struct {
+	messages []sqstypes.Message
+	deleted  []string
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return &sqs.ReceiveMessageOutput{Messages: f.messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+type fakeS3 struct {
+	objects map[string]string
+	gets    int
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.gets++
+	body, ok := f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func s3Notification(bucket, key string) sqstypes.Message {
+	return s3NotificationSeq(bucket, key, "")
+}
+
+func s3NotificationSeq(bucket, key, sequencer string) sqstypes.Message {
+	body := `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"` + bucket + `"},"object":{"key":"` + key +
+		`","sequencer":"` + sequencer + `"}}}]}`
+	return sqstypes.Message{Body: aws.String(body), ReceiptHandle: aws.String(key)}
+}
+
+func TestS3WorkerPoll(t *testing.T) {
+	router := setup()
+
+	queue := &fakeSQS{messages: []sqstypes.Message{
+		s3Notification("partner", "drop/good+receipt.json"),
+		s3Notification("partner", "drop/missing.json"),
+	}}
+	objects := &fakeS3{objects: map[string]string{
+		"partner/drop/good receipt.json": `{
+			"retailer": "Target",
+			"purchaseDate": "2022-01-01",
+			"purchaseTime": "13:01",
+			"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+			"total": "6.49"
+		}`,
+	}}
+	w := &s3Worker{queueURL: "https://sqs.example/queue", sqs: queue, s3: objects}
+
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	// the missing object must stay on the queue for redelivery.
+	if len(queue.deleted) != 1 || queue.deleted[0] != "drop/good+receipt.json" {
+		t.Errorf("deleted messages = %v, want only the processed one", queue.deleted)
+	}
+
+	req := httptest.NewRequest("GET", "/ingest/s3/results?object=s3://partner/drop/good%20receipt.json", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var result ingestResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if result.ID == "" || result.Points != 12 {
+		t.Errorf("result = %+v, want an ID and 12 points", result)
+	}
+}
+
+func TestS3WorkerSkipsIngestedObjects(t *testing.T) {
+	setup()
+
+	receipt := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+	objects := &fakeS3{objects: map[string]string{"partner/drop/again.json": receipt}}
+	queue := &fakeSQS{messages: []sqstypes.Message{s3NotificationSeq("partner", "drop/again.json", "0A1")}}
+	w := &s3Worker{queueURL: "https://sqs.example/queue", sqs: queue, s3: objects}
+
+	// SQS redelivers the message.
+	for range 2 {
+		if err := w.poll(context.Background()); err != nil {
+			t.Fatalf("poll() error = %v", err)
+		}
+	}
+	if objects.gets != 1 {
+		t.Errorf("object downloaded %d times, want once", objects.gets)
+	}
+	first, _ := s3Results.Load("s3://partner/drop/again.json")
+
+	// a new upload of the key is processed.
+	queue.messages = []sqstypes.Message{s3NotificationSeq("partner", "drop/again.json", "0A2")}
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	second, _ := s3Results.Load("s3://partner/drop/again.json")
+	if objects.gets != 2 || second.ID == "" || second.ID == first.ID {
+		t.Errorf("new upload: %d downloads, result %+v after %+v, want a second receipt", objects.gets, second, first)
+	}
+}
+
+func TestS3WorkerLimits(t *testing.T) {
+	setup()
+	live := *currentConfig()
+	live.S3Ingest.MaxResults = 2
+	live.ReceiptLimits.MaxBodyBytes = 20
+	liveConfig.Store(&live)
+
+	objects := &fakeS3{objects: map[string]string{}}
+	var messages []sqstypes.Message
+	for _, key := range []string{"a.json", "b.json", "c.json"} {
+		objects.objects["partner/"+key] = `{"retailer": "a retailer too long for the limit"}`
+		messages = append(messages, s3Notification("partner", key))
+	}
+	w := &s3Worker{queueURL: "https://sqs.example/queue", sqs: &fakeSQS{messages: messages}, s3: objects}
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	if _, ok := s3Results.Load("s3://partner/a.json"); ok {
+		t.Error("the oldest result was kept past maxResults")
+	}
+	result, ok := s3Results.Load("s3://partner/c.json")
+	if !ok || result.Error != "The receipt is larger than 20 bytes." {
+		t.Errorf("result = %+v, want the size limit error", result)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxLabelValues = 50
+	// otherLabelValue replaces the values of a label past its maxValuesPerLabel.
+	otherLabelValue = "other"
+)
+
+// ingestMetricLabels are the labels IngestMetricsConfig can add: the API key the receipt was submitted with ("none"
+// without one, like for ingested files), its normalized retailer and the format of the body.
+var ingestMetricLabels = []string{"partner", "retailer", "format"}
+
+// IngestMetricsConfig breaks fcpc_receipts_ingested_total down by Labels on top of the result. Every label keeps at
+// most MaxValuesPerLabel values (50 by default), the values first seen; the rest count as "other", so a partner
+// sending random retailer names can't blow up the number of series.
+type IngestMetricsConfig struct {
+	Labels            []string `json:"labels"`
+	MaxValuesPerLabel int      `json:"maxValuesPerLabel"`
+}
+
+func (c IngestMetricsConfig) Validate() error {
+	for i, label := range c.Labels {
+		if !slices.Contains(ingestMetricLabels, label) {
+			return fmt.Errorf("ingestMetrics: unknown label %q", label)
+		}
+		if slices.Contains(c.Labels[:i], label) {
+			return fmt.Errorf("ingestMetrics: label %q is listed twice", label)
+		}
+	}
+	if c.MaxValuesPerLabel < 0 {
+		return fmt.Errorf("ingestMetrics: maxValuesPerLabel must not be negative")
+	}
+	return nil
+}
+
+var receiptsIngestedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_receipts_ingested_total",
+	Help: "Receipts submitted or ingested, by result (processed, spooled, invalid, limited, duplicate, rejected or failed) and the labels of ingestMetrics.",
+}, append([]string{"result"}, ingestMetricLabels...))
+
+// ingestMetrics counts the receipts submitted over HTTP and ingested from files, mail and queues. Labels that aren't
+// configured are left empty, which Prometheus treats as not set. It is built by setup and keeps the values it has seen
+// for as long as the process runs, so changes to the config only apply after a restart.
+type ingestMetrics struct {
+	labels []string
+	max    int
+
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+var ingested *ingestMetrics
+
+func newIngestMetrics(c IngestMetricsConfig) *ingestMetrics {
+	return &ingestMetrics{
+		labels: c.Labels,
+		max:    cmp.Or(c.MaxValuesPerLabel, defaultMaxLabelValues),
+		seen:   map[string]map[string]bool{},
+	}
+}
+
+// count counts a receipt submitted with ctx in format, its retailer as decoded ("" if it wasn't), with result.
+// Receipts of the canary aren't counted.
+func (m *ingestMetrics) count(ctx context.Context, format, retailer, result string) {
+	if isCanary(ctx) {
+		return
+	}
+	values := map[string]string{
+		"partner":  cmp.Or(apiKeyFrom(ctx), "none"),
+		"retailer": cmp.Or(normalizeRetailer(retailer), "unknown"),
+		"format":   format,
+	}
+	labelValues := []string{result}
+	for _, label := range ingestMetricLabels {
+		value := ""
+		if slices.Contains(m.labels, label) {
+			value = m.limit(label, values[label])
+		}
+		labelValues = append(labelValues, value)
+	}
+	receiptsIngestedTotal.WithLabelValues(labelValues...).Inc()
+}
+
+// limit returns value, or "other" once label has m.max other values.
+func (m *ingestMetrics) limit(label, value string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := m.seen[label]
+	if seen == nil {
+		seen = map[string]bool{}
+		m.seen[label] = seen
+	}
+	if seen[value] {
+		return value
+	}
+	if len(seen) >= m.max {
+		return otherLabelValue
+	}
+	seen[value] = true
+	if len(seen) == m.max {
+		logger.Warn("Ingestion metric label reached its maximum number of values, counting new ones as other", zap.String("label", label), zap.Int("max", m.max))
+	}
+	return value
+}
+
+// ingestOutcome is the result label of a receipt submitReceipt returned err for.
+func ingestOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "processed"
+	case errors.Is(err, ledger.ErrInvalidAccount):
+		return "invalid"
+	case errors.Is(err, errDailyLimitReached), errors.As(err, &retailerQuotaError{}):
+		return "limited"
+	case errors.As(err, &duplicateTransactionError{}):
+		return "duplicate"
+	case errors.Is(err, errReceiptBlocked):
+		return "rejected"
+	default:
+		return "failed"
+	}
+}
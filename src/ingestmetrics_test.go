@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIngestMetrics(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    IngestMetricsConfig
+		retailers []string
+		// want are the receipts counted by the label values after the result.
+		want map[[3]string]float64
+	}{
+		{
+			name:      "result only",
+			retailers: []string{"Target", "Walgreens"},
+			want:      map[[3]string]float64{{"", "", ""}: 2},
+		},
+		{
+			name:      "partner, retailer and format",
+			config:    IngestMetricsConfig{Labels: []string{"partner", "retailer", "format"}},
+			retailers: []string{"Target", "target", "Walgreens"},
+			want:      map[[3]string]float64{{"none", "target", "json"}: 2, {"none", "walgreens", "json"}: 1},
+		},
+		{
+			name:      "retailers past the limit",
+			config:    IngestMetricsConfig{Labels: []string{"retailer"}, MaxValuesPerLabel: 2},
+			retailers: []string{"Costco", "Kroger", "Aldi", "Safeway"},
+			want:      map[[3]string]float64{{"", "costco", ""}: 1, {"", "kroger", ""}: 1, {"", "other", ""}: 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			ingested = newIngestMetrics(tc.config)
+			count := func(result string, labels [3]string) float64 {
+				return testutil.ToFloat64(receiptsIngestedTotal.WithLabelValues(result, labels[0], labels[1], labels[2]))
+			}
+			before := map[[3]string]float64{}
+			for labels := range tc.want {
+				before[labels] = count("processed", labels)
+			}
+			invalid := count("invalid", [3]string{})
+
+			for _, retailer := range tc.retailers {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Retailer(retailer).Build().JSON())))
+			}
+			for labels, want := range tc.want {
+				if got := count("processed", labels) - before[labels]; got != want {
+					t.Errorf("counted %v processed receipts with %q, want %v", got, labels, want)
+				}
+			}
+
+			if len(tc.config.Labels) == 0 {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader([]byte(`{`))))
+				if got := count("invalid", [3]string{}) - invalid; got != 1 {
+					t.Errorf("counted %v invalid receipts, want 1", got)
+				}
+			}
+		})
+	}
+}
+
+func TestIngestMetricsConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  IngestMetricsConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "every label", config: IngestMetricsConfig{Labels: []string{"partner", "retailer", "format"}, MaxValuesPerLabel: 10}},
+		{name: "unknown label", config: IngestMetricsConfig{Labels: []string{"account"}}, wantErr: true},
+		{name: "label twice", config: IngestMetricsConfig{Labels: []string{"retailer", "retailer"}}, wantErr: true},
+		{name: "negative limit", config: IngestMetricsConfig{MaxValuesPerLabel: -1}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+//go:build integration
+
+// The integration suite runs the full HTTP lifecycle against every storage backend, starting real Postgres and Redis
+// containers with dockertest. It needs a docker daemon and is run with:
+//
+//	go test -tags integration ./...
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// backendDSNs is filled in by TestMain once the containers are up.
+var backendDSNs = map[string]string{"memory": ""}
+
+// startContainer runs repository:tag with its ports published on random local ports. The container is removed once
+// stopped, and stopped after 10 minutes in case the suite dies before purging it.
+func startContainer(pool *dockertest.Pool, repository, tag string, env ...string) (*dockertest.Resource, error) {
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{Repository: repository, Tag: tag, Env: env}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting %s:%s: %w", repository, tag, err)
+	}
+	resource.Expire(600)
+	return resource, nil
+}
+
+// waitForStore retries opening the backend until the container accepts connections.
+func waitForStore(pool *dockertest.Pool, backend, dsn string) error {
+	return pool.Retry(func() error {
+		s, err := store.Open(context.Background(), backend, dsn)
+		if err != nil {
+			return err
+		}
+		return s.Close()
+	})
+}
+
+func TestMain(m *testing.M) {
+	var containers []*dockertest.Resource
+	var pool *dockertest.Pool
+	cleanup := func() {
+		for _, resource := range containers {
+			pool.Purge(resource)
+		}
+	}
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		cleanup()
+		os.Exit(1)
+	}
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		fail(fmt.Errorf("connecting to docker: %w", err))
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	postgres, err := startContainer(pool, "postgres", "16-alpine", "POSTGRES_PASSWORD=fcpc", "POSTGRES_DB=fcpc")
+	if err != nil {
+		fail(err)
+	}
+	containers = append(containers, postgres)
+
+	redis, err := startContainer(pool, "redis", "7-alpine")
+	if err != nil {
+		fail(err)
+	}
+	containers = append(containers, redis)
+
+	backendDSNs["postgres"] = fmt.Sprintf("postgres://postgres:fcpc@%s/fcpc?sslmode=disable", postgres.GetHostPort("5432/tcp"))
+	backendDSNs["redis"] = fmt.Sprintf("redis://%s/0", redis.GetHostPort("6379/tcp"))
+
+	for _, backend := range []string{"postgres", "redis"} {
+		if err := waitForStore(pool, backend, backendDSNs[backend]); err != nil {
+			fail(fmt.Errorf("%s never became ready: %w", backend, err))
+		}
+	}
+
+	code := m.Run()
+	cleanup()
+	os.Exit(code)
+}
+
+func TestIntegrationLifecycle(t *testing.T) {
+	for backend, dsn := range backendDSNs {
+		t.Run(backend, func(t *testing.T) {
+			router := setup()
+
+			s, err := store.Open(context.Background(), backend, dsn)
+			if err != nil {
+				t.Fatalf("store.Open(%v) error = %v", backend, err)
+			}
+			defer s.Close()
+			receiptStore = s
+
+			body := `{
+				"retailer": "M&M Corner Market",
+				"purchaseDate": "2022-03-20",
+				"purchaseTime": "14:33",
+				"items": [
+					{"shortDescription": "Gatorade", "price": "2.25"},
+					{"shortDescription": "Gatorade", "price": "2.25"},
+					{"shortDescription": "Gatorade", "price": "2.25"},
+					{"shortDescription": "Gatorade", "price": "2.25"}
+				],
+				"total": "9.00"
+			}`
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("process returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			var resp map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+resp["id"]+"/points", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("points returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var pointsResp map[string]int64
+			if err := json.Unmarshal(rr.Body.Bytes(), &pointsResp); err != nil {
+				t.Fatalf("Failed to parse points response: %v", err)
+			}
+			if pointsResp["points"] != 109 {
+				t.Errorf("wrong points calculation: got %v want %v", pointsResp["points"], 109)
+			}
+
+			rec, err := s.Get(context.Background(), resp["id"])
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			var stored Receipt
+			if err := json.Unmarshal(rec.Receipt, &stored); err != nil {
+				t.Errorf("stored payload doesn't decode back into a receipt: %v", err)
+			}
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/does-not-exist/points", nil))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("unknown ID returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestIntegrationStoreConformance(t *testing.T) {
+	for backend, dsn := range backendDSNs {
+		t.Run(backend, func(t *testing.T) {
+			storetest.Conformance(t, func(t *testing.T) store.Store {
+				s, err := store.Open(context.Background(), backend, dsn)
+				if err != nil {
+					t.Fatalf("store.Open(%v) error = %v", backend, err)
+				}
+				t.Cleanup(func() { s.Close() })
+				return s
+			})
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const fraudSignalItemDedup = "itemDedup"
+
+// ItemDedupConfig flags receipts whose line items were already claimed on another account: the same item, at the
+// same price, from the same retailer at the same minute. MinItems is how many of a receipt's items have to match to
+// flag it, 1 by default.
+type ItemDedupConfig struct {
+	Enabled  bool `json:"enabled"`
+	MinItems int  `json:"minItems"`
+}
+
+func (c ItemDedupConfig) Validate() error {
+	if c.MinItems < 0 {
+		return fmt.Errorf("fraud: itemDedup: minItems must not be negative")
+	}
+	return nil
+}
+
+func (c ItemDedupConfig) minItems() int {
+	return max(c.MinItems, 1)
+}
+
+// ItemMatch is an item of a flagged receipt that ReceiptID, of Account, claimed before.
+type ItemMatch struct {
+	Item      ItemDTO `json:"item"`
+	ReceiptID string  `json:"receiptId"`
+	Account   string  `json:"account"`
+}
+
+type itemClaim struct {
+	receiptID string
+	account   string
+}
+
+// itemClaimIndex has the items claimed on every account since startup, by fingerprint.
+type itemClaimIndex struct {
+	mu        sync.Mutex
+	claims    map[string][]itemClaim
+	byAccount map[string][]string
+}
+
+var itemClaims *itemClaimIndex
+
+func newItemClaimIndex() *itemClaimIndex {
+	return &itemClaimIndex{claims: map[string][]itemClaim{}, byAccount: map[string][]string{}}
+}
+
+func init() {
+	onErasure(func(account string, receipts []string) { itemClaims.erase(account, receipts) })
+}
+
+// itemFingerprint identifies an item of a receipt across receipts: retailer as normalizeRetailer has it, description
+// lowercased with single spaces, price, and purchase date and time to the minute.
+func itemFingerprint(r Receipt, item Item) string {
+	return strings.Join([]string{
+		normalizeRetailer(r.Retailer),
+		strings.ToLower(strings.Join(strings.Fields(item.ShortDescription), " ")),
+		strconv.FormatFloat(item.Price, 'f', 2, 64),
+		r.PurchaseDate.Format(time.DateOnly),
+		r.PurchaseTime.Format("15:04"),
+	}, "\x00")
+}
+
+// claim records the items of a receipt for account and returns what other accounts claimed of them before, nil if
+// fewer than c.MinItems of its items were.
+func (x *itemClaimIndex) claim(c ItemDedupConfig, receipt Receipt, receiptID, account string) []ItemMatch {
+	account = pointsLedger.Resolve(account)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var matches []ItemMatch
+	matched := 0
+	for _, item := range receipt.Items {
+		fp := itemFingerprint(receipt, item)
+		found := false
+		for _, claim := range x.claims[fp] {
+			if claim.account != account {
+				matches = append(matches, ItemMatch{Item: ItemDTO{ShortDescription: item.ShortDescription, Price: strconv.FormatFloat(item.Price, 'f', 2, 64)}, ReceiptID: claim.receiptID, Account: claim.account})
+				found = true
+			}
+		}
+		if found {
+			matched++
+		}
+		x.claims[fp] = append(x.claims[fp], itemClaim{receiptID: receiptID, account: account})
+		x.byAccount[account] = append(x.byAccount[account], fp)
+	}
+	if matched < c.minItems() {
+		return nil
+	}
+	return matches
+}
+
+// erase drops the claims of an erased account, and those of its receipts claimed under an alias before a merge.
+func (x *itemClaimIndex) erase(account string, receipts []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for fp, claims := range x.claims {
+		claims = slices.DeleteFunc(claims, func(claim itemClaim) bool {
+			return claim.account == account || slices.Contains(receipts, claim.receiptID)
+		})
+		if len(claims) == 0 {
+			delete(x.claims, fp)
+		} else {
+			x.claims[fp] = claims
+		}
+	}
+	delete(x.byAccount, account)
+}
+
+// AccountCorrelation is another account that claimed SharedItems of the same items, on ReceiptIDs.
+type AccountCorrelation struct {
+	Account     string   `json:"account"`
+	SharedItems int      `json:"sharedItems"`
+	ReceiptIDs  []string `json:"receiptIds"`
+}
+
+// correlations returns the accounts that claimed items account claimed too, the most shared items first.
+func (x *itemClaimIndex) correlations(account string) []AccountCorrelation {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	byAccount := map[string]*AccountCorrelation{}
+	for _, fp := range x.byAccount[account] {
+		for _, claim := range x.claims[fp] {
+			if claim.account == account {
+				continue
+			}
+			correlation, ok := byAccount[claim.account]
+			if !ok {
+				correlation = &AccountCorrelation{Account: claim.account}
+				byAccount[claim.account] = correlation
+			}
+			correlation.SharedItems++
+			if !slices.Contains(correlation.ReceiptIDs, claim.receiptID) {
+				correlation.ReceiptIDs = append(correlation.ReceiptIDs, claim.receiptID)
+			}
+		}
+	}
+	correlations := []AccountCorrelation{}
+	for _, correlation := range byAccount {
+		correlations = append(correlations, *correlation)
+	}
+	slices.SortFunc(correlations, func(a, b AccountCorrelation) int {
+		return cmp.Or(cmp.Compare(b.SharedItems, a.SharedItems), strings.Compare(a.Account, b.Account))
+	})
+	return correlations
+}
+
+// getAccountCorrelations serves GET /admin/fraud/correlations/{account}, the other accounts that claimed the same line
+// items as the account, to follow a flag to the ring of accounts behind it.
+func getAccountCorrelations(w http.ResponseWriter, r *http.Request) {
+	account := pointsLedger.Resolve(mux.Vars(r)["account"])
+
+	response := map[string]any{"account": account, "correlations": itemClaims.correlations(account)}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,107 @@
+package ledger
+
+import "maps"
+
+// ErasedAccount takes over the entries of erased accounts, so every transaction still sums to zero and the points
+// issued stay accounted for.
+const ErasedAccount = SystemPrefix + "erased"
+
+// ErasureResult is what Erase removed. Receipts are the IDs the account owned, for the caller to delete wherever the
+// receipts themselves are kept.
+type ErasureResult struct {
+	Receipts           []string `json:"-"`
+	EntriesAnonymized  int      `json:"entriesAnonymized"`
+	AuditRecordsErased int      `json:"auditRecordsErased"`
+	AliasesErased      int      `json:"aliasesErased"`
+}
+
+// Erase removes every trace of account, and of the accounts merged into it, from the ledger. Entries move to
+// ErasedAccount without their receipt IDs, audit records mentioning the account or one of its receipts and transfer
+// results mentioning the account are dropped, and its streak is forgotten. The erasure itself is audited under
+// certificate, without the account ID.
+func (l *Ledger) Erase(account, certificate string) (ErasureResult, error) {
+	if err := ValidateAccount(account); err != nil {
+		return ErasureResult{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	if !l.exists(account) {
+		return ErasureResult{}, ErrUnknownAccount
+	}
+
+	ids := map[string]bool{account: true}
+	for alias := range l.mergedInto {
+		if l.resolve(alias) == account {
+			ids[alias] = true
+		}
+	}
+	var result ErasureResult
+	for alias := range ids {
+		if alias != account {
+			delete(l.mergedInto, alias)
+			result.AliasesErased++
+		}
+	}
+
+	result.Receipts = l.receipts[account]
+	delete(l.receipts, account)
+	delete(l.streaks, account)
+	delete(l.balances, account)
+	delete(l.balances, ErasedAccount)
+
+	txs := map[string]bool{}
+	for i := range l.entries {
+		if l.entries[i].Account == account {
+			l.entries[i].Account = ErasedAccount
+			txs[l.entries[i].TxID] = true
+			result.EntriesAnonymized++
+		}
+	}
+	// the receipt IDs would lead back to the account through the other side of the transaction.
+	for i := range l.entries {
+		if txs[l.entries[i].TxID] {
+			l.entries[i].ReceiptID = ""
+		}
+	}
+
+	for key, t := range l.transfers {
+		if ids[t.request.From] || ids[t.request.To] || ids[t.result.From] || ids[t.result.To] {
+			delete(l.transfers, key)
+		}
+	}
+
+	// records of what was done to a receipt, like amendments, only name the receipt.
+	mentioned := maps.Clone(ids)
+	for _, id := range result.Receipts {
+		mentioned[id] = true
+	}
+	kept := l.audit[:0]
+	for _, r := range l.audit {
+		if mentions(r.Details, mentioned) {
+			result.AuditRecordsErased++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	l.audit = kept
+
+	l.recordAudit("account.erase", map[string]any{
+		"certificate":        certificate,
+		"entriesAnonymized":  result.EntriesAnonymized,
+		"auditRecordsErased": result.AuditRecordsErased,
+		"aliasesErased":      result.AliasesErased,
+		"receiptsErased":     len(result.Receipts),
+	})
+	return result, nil
+}
+
+func mentions(details map[string]any, ids map[string]bool) bool {
+	for _, v := range details {
+		if s, ok := v.(string); ok && ids[s] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErase(t *testing.T) {
+	l := New()
+	l.Accrue("alice", "r1", 10)
+	l.Accrue("alice-tablet", "r2", 20)
+	l.Accrue("bob", "r3", 30)
+	l.Merge("alice", "alice-tablet")
+	l.Transfer(TransferRequest{From: "bob", To: "alice", Points: 5, IdempotencyKey: "k1"})
+	l.RecordAudit("receipt.amend", map[string]any{"receiptId": "r2", "pointsBefore": 20, "pointsAfter": 25})
+	l.RecordAudit("receipt.amend", map[string]any{"receiptId": "r3", "pointsBefore": 30, "pointsAfter": 35})
+
+	got, err := l.Erase("alice-tablet", "cert-1")
+	if err != nil {
+		t.Fatalf("Erase() error = %v", err)
+	}
+	// r1, r2 and the transfer credit; the merge and r2's amendment.
+	if got.EntriesAnonymized != 3 || got.AuditRecordsErased != 2 || got.AliasesErased != 1 || len(got.Receipts) != 2 {
+		t.Errorf("Erase() = %+v", got)
+	}
+
+	for _, account := range []string{"alice", "alice-tablet"} {
+		if _, err := l.Balance(account); !errors.Is(err, ErrUnknownAccount) {
+			t.Errorf("Balance(%v) error = %v, want %v", account, err, ErrUnknownAccount)
+		}
+	}
+	if balance, _ := l.Balance("bob"); balance != 25 {
+		t.Errorf("Balance(bob) = %v, want 25", balance)
+	}
+	if balance, _ := l.Balance(ErasedAccount); balance != 35 {
+		t.Errorf("Balance(ErasedAccount) = %v, want 35", balance)
+	}
+	for _, e := range l.entries {
+		if e.ReceiptID == "r1" || e.ReceiptID == "r2" {
+			t.Errorf("entry %+v still has the erased account's receipt", e)
+		}
+	}
+
+	audit := l.Audit()
+	if len(audit) != 2 || audit[0].Details["receiptId"] != "r3" || audit[1].Action != "account.erase" || audit[1].Details["certificate"] != "cert-1" {
+		t.Errorf("Audit() = %+v, want bob's amendment and the account.erase record", audit)
+	}
+
+	// the idempotency record named alice, the retry is a new transfer to an account that no longer exists.
+	if _, err := l.Transfer(TransferRequest{From: "bob", To: "alice", Points: 5, IdempotencyKey: "k1"}); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Transfer() retry error = %v, want %v", err, ErrUnknownAccount)
+	}
+
+	if _, err := l.Erase("alice", "cert-2"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Erase() again error = %v, want %v", err, ErrUnknownAccount)
+	}
+}
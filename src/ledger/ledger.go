@@ -0,0 +1,249 @@
+// Package ledger keeps account points as a double-entry ledger: every transaction's entries sum to zero, points issued
+// for receipts are balanced against the IssuedAccount system account. It also records which account owns which
+// receipt and keeps the audit trail of administrative changes, all behind one lock so multi-step changes like merges
+// are atomic.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// System accounts start with SystemPrefix and can't be used by customers.
+const (
+	SystemPrefix  = "fcpc:"
+	IssuedAccount = SystemPrefix + "issued"
+	FeesAccount   = SystemPrefix + "fees"
+)
+
+var (
+	ErrUnknownAccount = errors.New("unknown account")
+	ErrInvalidAccount = errors.New("invalid account ID")
+	ErrUnbalanced     = errors.New("transaction entries don't sum to zero")
+)
+
+// Entry is one side of a transaction. Amount is positive for credits and negative for debits.
+type Entry struct {
+	Account   string    `json:"account"`
+	Amount    int64     `json:"amount"`
+	ReceiptID string    `json:"receiptId,omitempty"`
+	TxID      string    `json:"txId"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Transaction kinds.
+const (
+	KindAccrual  = "accrual"
+	KindTransfer = "transfer"
+)
+
+// AuditRecord is an administrative change, kept forever.
+type AuditRecord struct {
+	ID      string         `json:"id"`
+	At      time.Time      `json:"at"`
+	Action  string         `json:"action"`
+	Details map[string]any `json:"details"`
+}
+
+type Ledger struct {
+	mu       sync.Mutex
+	entries  []Entry
+	receipts map[string][]string // account -> receipt IDs, oldest first
+	// mergedInto redirects accounts that were merged away, so clients still using the old ID keep earning.
+	mergedInto map[string]string
+	audit      []AuditRecord
+	// transfers remembers results by idempotency key, so a retried request returns the original outcome.
+	transfers map[string]transferRecord
+	streaks   map[string]Streak
+	// balances caches the sums of the accounts' entries. Whatever changes an account's entries drops its balance,
+	// the next read sums them again.
+	balances map[string]int64
+	now      func() time.Time
+}
+
+func New() *Ledger {
+	return &Ledger{
+		receipts:   map[string][]string{},
+		mergedInto: map[string]string{},
+		transfers:  map[string]transferRecord{},
+		streaks:    map[string]Streak{},
+		balances:   map[string]int64{},
+		now:        time.Now,
+	}
+}
+
+// ValidateAccount rejects IDs that are empty, too long or reserved for system accounts.
+func ValidateAccount(id string) error {
+	if id == "" || len(id) > 128 || strings.HasPrefix(id, SystemPrefix) || strings.ContainsAny(id, " /\t\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidAccount, id)
+	}
+	return nil
+}
+
+// resolve follows merges. Must be called with mu held.
+func (l *Ledger) resolve(account string) string {
+	for {
+		next, ok := l.mergedInto[account]
+		if !ok {
+			return account
+		}
+		account = next
+	}
+}
+
+// Resolve returns the account that account was merged into, or account itself.
+func (l *Ledger) Resolve(account string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.resolve(account)
+}
+
+// exists must be called with mu held.
+func (l *Ledger) exists(account string) bool {
+	if len(l.receipts[account]) > 0 {
+		return true
+	}
+	for _, e := range l.entries {
+		if e.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// post appends a balanced transaction. Must be called with mu held.
+func (l *Ledger) post(kind string, entries ...Entry) (string, error) {
+	var sum int64
+	for _, e := range entries {
+		sum += e.Amount
+	}
+	if sum != 0 {
+		return "", ErrUnbalanced
+	}
+
+	txID := uuid.New().String()
+	at := l.now().UTC()
+	for _, e := range entries {
+		e.TxID, e.Kind, e.CreatedAt = txID, kind, at
+		l.entries = append(l.entries, e)
+		delete(l.balances, e.Account)
+	}
+	return txID, nil
+}
+
+// recordAudit must be called with mu held.
+func (l *Ledger) recordAudit(action string, details map[string]any) {
+	l.audit = append(l.audit, AuditRecord{ID: uuid.New().String(), At: l.now().UTC(), Action: action, Details: details})
+}
+
+// RecordAudit adds an administrative change made outside the ledger to its audit trail.
+func (l *Ledger) RecordAudit(action string, details map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recordAudit(action, details)
+}
+
+// Accrue credits points for a receipt to account (or the account it was merged into), returning the account that
+// was credited.
+func (l *Ledger) Accrue(account, receiptID string, points int64) (string, error) {
+	if err := ValidateAccount(account); err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	if _, err := l.post(KindAccrual,
+		Entry{Account: account, Amount: points, ReceiptID: receiptID},
+		Entry{Account: IssuedAccount, Amount: -points, ReceiptID: receiptID},
+	); err != nil {
+		return "", err
+	}
+	l.receipts[account] = append(l.receipts[account], receiptID)
+	return account, nil
+}
+
+// Balance sums the account's entries, or returns the sum cached since they last changed. ErrUnknownAccount means it
+// never had any activity.
+func (l *Ledger) Balance(account string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	// only accounts that exist have their balance cached.
+	if sum, ok := l.balances[account]; ok {
+		return sum, nil
+	}
+	if !l.exists(account) {
+		return 0, ErrUnknownAccount
+	}
+	return l.balance(account), nil
+}
+
+// balance must be called with mu held, for an account that exists.
+func (l *Ledger) balance(account string) int64 {
+	if sum, ok := l.balances[account]; ok {
+		return sum
+	}
+	var sum int64
+	for _, e := range l.entries {
+		if e.Account == account {
+			sum += e.Amount
+		}
+	}
+	l.balances[account] = sum
+	return sum
+}
+
+// Entries returns the account's entries, oldest first.
+func (l *Ledger) Entries(account string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	var entries []Entry
+	for _, e := range l.entries {
+		if e.Account == account {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Receipts returns the IDs of the account's receipts, oldest first.
+func (l *Ledger) Receipts(account string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.receipts[l.resolve(account)]...)
+}
+
+// Accounts returns the customer accounts with any activity, sorted. Accounts merged into another one aren't included.
+func (l *Ledger) Accounts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := map[string]bool{}
+	var accounts []string
+	for _, e := range l.entries {
+		if !seen[e.Account] && !strings.HasPrefix(e.Account, SystemPrefix) {
+			seen[e.Account] = true
+			accounts = append(accounts, e.Account)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// Audit returns the audit trail, oldest first.
+func (l *Ledger) Audit() []AuditRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditRecord(nil), l.audit...)
+}
@@ -0,0 +1,159 @@
+package ledger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAccrue(t *testing.T) {
+	l := New()
+	if _, err := l.Accrue("alice", "r1", 28); err != nil {
+		t.Fatalf("Accrue() error = %v", err)
+	}
+	if _, err := l.Accrue("alice", "r2", 109); err != nil {
+		t.Fatalf("Accrue() error = %v", err)
+	}
+
+	if got, err := l.Balance("alice"); err != nil || got != 137 {
+		t.Errorf("Balance() = %v, %v, want 137", got, err)
+	}
+	if got, _ := l.Balance(IssuedAccount); got != -137 {
+		t.Errorf("issued balance = %v, want -137", got)
+	}
+	if got := l.Receipts("alice"); !reflect.DeepEqual(got, []string{"r1", "r2"}) {
+		t.Errorf("Receipts() = %v, want [r1 r2]", got)
+	}
+	if got := l.Accounts(); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("Accounts() = %v, want [alice]", got)
+	}
+	if _, err := l.Balance("bob"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Balance() of unknown account error = %v, want %v", err, ErrUnknownAccount)
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	testCases := []struct {
+		id      string
+		wantErr bool
+	}{
+		{id: "alice", wantErr: false},
+		{id: "device-1234", wantErr: false},
+		{id: "", wantErr: true},
+		{id: "fcpc:issued", wantErr: true},
+		{id: "a/b", wantErr: true},
+		{id: "with space", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			if err := ValidateAccount(tc.id); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateAccount(%q) error = %v, wantErr %v", tc.id, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	l := New()
+	l.Accrue("phone", "r1", 10)
+	l.Accrue("tablet", "r2", 20)
+	l.Accrue("phone", "r3", 30)
+
+	got, err := l.Merge("phone", "tablet")
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	want := MergeResult{Account: "phone", MergedFrom: "tablet", ReceiptsMoved: 1, EntriesMoved: 1, Balance: 60}
+	if got != want {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+	if receipts := l.Receipts("phone"); !reflect.DeepEqual(receipts, []string{"r1", "r2", "r3"}) {
+		t.Errorf("Receipts() = %v, want submission order", receipts)
+	}
+
+	// the old ID is an alias now.
+	if credited, _ := l.Accrue("tablet", "r4", 5); credited != "phone" {
+		t.Errorf("Accrue() on merged account credited %v, want phone", credited)
+	}
+	if balance, _ := l.Balance("tablet"); balance != 65 {
+		t.Errorf("Balance() via alias = %v, want 65", balance)
+	}
+
+	audit := l.Audit()
+	if len(audit) != 1 || audit[0].Action != "account.merge" || audit[0].Details["mergedFrom"] != "tablet" {
+		t.Errorf("Audit() = %+v, want one account.merge record", audit)
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		into    string
+		from    string
+		wantErr error
+		before  func(l *Ledger)
+	}{
+		{name: "same account", into: "a", from: "a", wantErr: ErrSameAccount},
+		{name: "already merged", into: "a", from: "b", wantErr: ErrSameAccount, before: func(l *Ledger) { l.Merge("a", "b") }},
+		{name: "unknown source", into: "a", from: "nobody", wantErr: ErrUnknownAccount},
+		{name: "unknown target", into: "nobody", from: "a", wantErr: ErrUnknownAccount},
+		{name: "system account", into: "a", from: IssuedAccount, wantErr: ErrInvalidAccount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			l.Accrue("a", "r1", 1)
+			l.Accrue("b", "r2", 2)
+			if tc.before != nil {
+				tc.before(l)
+			}
+			auditBefore := len(l.Audit())
+
+			if _, err := l.Merge(tc.into, tc.from); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Merge() error = %v, want %v", err, tc.wantErr)
+			}
+			if len(l.Audit()) != auditBefore {
+				t.Errorf("failed merge was audited")
+			}
+		})
+	}
+}
+
+func TestBalanceCache(t *testing.T) {
+	testCases := []struct {
+		name   string
+		change func(l *Ledger) error
+		want   map[string]int64
+	}{
+		{name: "accrual", change: func(l *Ledger) error { _, err := l.Accrue("alice", "r3", 5); return err }, want: map[string]int64{"alice": 15, "bob": 20}},
+		{name: "transfer", change: func(l *Ledger) error {
+			_, err := l.Transfer(TransferRequest{From: "bob", To: "alice", Points: 7, Fee: 1})
+			return err
+		}, want: map[string]int64{"alice": 17, "bob": 12, FeesAccount: 1}},
+		{name: "rescore", change: func(l *Ledger) error { _, _, err := l.Rescore("r2", -4); return err }, want: map[string]int64{"alice": 10, "bob": 16}},
+		{name: "merge", change: func(l *Ledger) error { _, err := l.Merge("alice", "bob"); return err }, want: map[string]int64{"alice": 30, "bob": 30}},
+		{name: "erasure", change: func(l *Ledger) error { _, err := l.Erase("bob", "ticket"); return err }, want: map[string]int64{"alice": 10, ErasedAccount: 20}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			l.Accrue("alice", "r1", 10)
+			l.Accrue("bob", "r2", 20)
+			for _, account := range []string{"alice", "bob", ErasedAccount, FeesAccount} {
+				l.Balance(account)
+			}
+
+			if err := tc.change(l); err != nil {
+				t.Fatal(err)
+			}
+			for account, want := range tc.want {
+				if got, err := l.Balance(account); err != nil || got != want {
+					t.Errorf("Balance(%q) = %v, %v, want %v", account, got, err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package ledger
+
+import "errors"
+
+var ErrSameAccount = errors.New("can't merge an account into itself")
+
+// MergeResult is what Merge moved and the surviving account's balance afterwards.
+type MergeResult struct {
+	Account       string `json:"account"`
+	MergedFrom    string `json:"mergedFrom"`
+	ReceiptsMoved int    `json:"receiptsMoved"`
+	EntriesMoved  int    `json:"entriesMoved"`
+	Balance       int64  `json:"balance"`
+}
+
+// Merge folds from into into, e.g. after a user links two devices. from's entries are reassigned rather than
+// reposted so the history keeps its original dates, its receipts move along, and from becomes an alias of into.
+// Everything, including the audit record, happens under one lock: other callers see the accounts before or after,
+// never in between.
+func (l *Ledger) Merge(into, from string) (MergeResult, error) {
+	for _, id := range []string{into, from} {
+		if err := ValidateAccount(id); err != nil {
+			return MergeResult{}, err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	into, from = l.resolve(into), l.resolve(from)
+	if into == from {
+		return MergeResult{}, ErrSameAccount
+	}
+	if !l.exists(into) || !l.exists(from) {
+		return MergeResult{}, ErrUnknownAccount
+	}
+
+	result := MergeResult{Account: into, MergedFrom: from, ReceiptsMoved: len(l.receipts[from])}
+	for i := range l.entries {
+		if l.entries[i].Account == from {
+			l.entries[i].Account = into
+			result.EntriesMoved++
+		}
+	}
+
+	// rebuilt from the accruals so the combined history stays in the order the receipts were submitted.
+	var receipts []string
+	for _, e := range l.entries {
+		if e.Account == into && e.Kind == KindAccrual && e.ReceiptID != "" {
+			receipts = append(receipts, e.ReceiptID)
+		}
+	}
+	l.receipts[into] = receipts
+	delete(l.receipts, from)
+	l.mergedInto[from] = into
+	delete(l.balances, into)
+	delete(l.balances, from)
+	// the surviving account keeps its own streak, only the record carries over.
+	if s, ok := l.streaks[from]; ok {
+		kept := l.streaks[into]
+		kept.Longest = max(kept.Longest, s.Longest)
+		l.streaks[into] = kept
+		delete(l.streaks, from)
+	}
+	result.Balance = l.balance(into)
+
+	l.recordAudit("account.merge", map[string]any{
+		"account":       into,
+		"mergedFrom":    from,
+		"receiptsMoved": result.ReceiptsMoved,
+		"entriesMoved":  result.EntriesMoved,
+		"balance":       result.Balance,
+	})
+	return result, nil
+}
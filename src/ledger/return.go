@@ -0,0 +1,76 @@
+package ledger
+
+import "errors"
+
+// KindReturn entries take back points of a receipt whose items were returned, KindRescore entries the difference
+// when a receipt is scored again, KindRelease entries the points of a receipt that were held back for review or until
+// they settled. All are balanced against IssuedAccount.
+const (
+	KindReturn  = "return"
+	KindRescore = "rescore"
+	KindRelease = "release"
+)
+
+var ErrUnownedReceipt = errors.New("no account owns the receipt")
+
+// Owner returns the account the receipt was credited to, following merges.
+func (l *Ledger) Owner(receiptID string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.owner(receiptID)
+}
+
+// owner must be called with mu held.
+func (l *Ledger) owner(receiptID string) (string, bool) {
+	for account, ids := range l.receipts {
+		for _, id := range ids {
+			if id == receiptID {
+				return l.resolve(account), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Return debits points for returned items of a receipt from the account that owns it, returning the account and the
+// transaction ID. The balance may go negative, the points could have been transferred or spent already.
+func (l *Ledger) Return(receiptID string, points int64) (string, string, error) {
+	if points < 0 {
+		return "", "", ErrInvalidAmount
+	}
+	return l.adjust(KindReturn, receiptID, -points)
+}
+
+// Rescore credits (or debits, when negative) the points a receipt gains when it is scored again, e.g. after it was
+// amended, to the account that owns it.
+func (l *Ledger) Rescore(receiptID string, delta int64) (string, string, error) {
+	return l.adjust(KindRescore, receiptID, delta)
+}
+
+// Release credits the points of a receipt that were held back until it was reviewed, or until they settled, to the
+// account that owns it.
+func (l *Ledger) Release(receiptID string, points int64) (string, string, error) {
+	if points < 0 {
+		return "", "", ErrInvalidAmount
+	}
+	return l.adjust(KindRelease, receiptID, points)
+}
+
+// adjust posts amount to the account that owns the receipt, balanced against IssuedAccount.
+func (l *Ledger) adjust(kind, receiptID string, amount int64) (string, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account, ok := l.owner(receiptID)
+	if !ok {
+		return "", "", ErrUnownedReceipt
+	}
+	txID, err := l.post(kind,
+		Entry{Account: account, Amount: amount, ReceiptID: receiptID},
+		Entry{Account: IssuedAccount, Amount: -amount, ReceiptID: receiptID},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return account, txID, nil
+}
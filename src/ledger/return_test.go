@@ -0,0 +1,98 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReturn(t *testing.T) {
+	testCases := []struct {
+		name        string
+		receiptID   string
+		points      int64
+		wantAccount string
+		wantBalance int64
+		wantErr     error
+	}{
+		{name: "partial", receiptID: "r1", points: 4, wantAccount: "alice", wantBalance: 6},
+		{name: "more than the balance", receiptID: "r1", points: 15, wantAccount: "alice", wantBalance: -5},
+		{name: "merged account", receiptID: "r2", points: 2, wantAccount: "alice", wantBalance: 8},
+		{name: "unknown receipt", receiptID: "r3", points: 1, wantErr: ErrUnownedReceipt},
+		{name: "negative points", receiptID: "r1", points: -1, wantErr: ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			l.Accrue("alice", "r1", 10)
+			l.Accrue("bob", "r2", 0)
+			if _, err := l.Merge("alice", "bob"); err != nil {
+				t.Fatal(err)
+			}
+
+			account, _, err := l.Return(tc.receiptID, tc.points)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Return() error = %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if account != tc.wantAccount {
+				t.Errorf("Return() account = %v, want %v", account, tc.wantAccount)
+			}
+			if balance, _ := l.Balance("alice"); balance != tc.wantBalance {
+				t.Errorf("Balance() = %v, want %v", balance, tc.wantBalance)
+			}
+			if issued, _ := l.Balance(IssuedAccount); issued != -tc.wantBalance {
+				t.Errorf("issued balance = %v, want %v", issued, -tc.wantBalance)
+			}
+		})
+	}
+}
+
+func TestRescore(t *testing.T) {
+	testCases := []struct {
+		name        string
+		receiptID   string
+		delta       int64
+		wantBalance int64
+		wantErr     error
+	}{
+		{name: "more points", receiptID: "r1", delta: 5, wantBalance: 15},
+		{name: "fewer points", receiptID: "r1", delta: -4, wantBalance: 6},
+		{name: "unknown receipt", receiptID: "r3", delta: 1, wantErr: ErrUnownedReceipt},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			l.Accrue("alice", "r1", 10)
+
+			_, _, err := l.Rescore(tc.receiptID, tc.delta)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Rescore() error = %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if balance, _ := l.Balance("alice"); balance != tc.wantBalance {
+				t.Errorf("Balance() = %v, want %v", balance, tc.wantBalance)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	l := New()
+	l.Accrue("alice", "r1", 0)
+	if _, _, err := l.Release("r1", -1); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Release() error = %v, want %v", err, ErrInvalidAmount)
+	}
+	account, txID, err := l.Release("r1", 12)
+	if err != nil || account != "alice" || txID == "" {
+		t.Fatalf("Release() = %v, %v, %v, want alice and a transaction", account, txID, err)
+	}
+	if balance, _ := l.Balance("alice"); balance != 12 {
+		t.Errorf("Balance() = %v, want 12", balance)
+	}
+}
@@ -0,0 +1,88 @@
+package ledger
+
+// KindStreakBonus entries credit a streak bonus, balanced against IssuedAccount like accruals.
+const KindStreakBonus = "streakBonus"
+
+// Period is the day or week a receipt counts towards. Indexes of consecutive periods differ by exactly one.
+type Period struct {
+	Unit  string
+	Index int64
+	Label string
+}
+
+// StreakBonus awards Points once a streak reaches Length periods.
+type StreakBonus struct {
+	Length int   `json:"length"`
+	Points int64 `json:"points"`
+}
+
+type Streak struct {
+	Unit    string `json:"unit"`
+	Current int    `json:"current"`
+	Longest int    `json:"longest"`
+	// LastPeriod is the label of the latest period with a receipt, e.g. "2022-01-02" or "2022-W01".
+	LastPeriod string `json:"lastPeriod,omitempty"`
+	lastIndex  int64
+}
+
+// Active reports whether the streak can still be extended in period now, i.e. it had a receipt in now or the
+// period before.
+func (s Streak) Active(now Period) bool {
+	return s.Current > 0 && s.Unit == now.Unit && now.Index-s.lastIndex <= 1
+}
+
+// ExtendStreak counts a receipt in period p towards the account's streak and credits the bonus for the length it
+// reaches, if any. Streaks are updated incrementally: a receipt for a period before the latest one doesn't change
+// anything, and switching between days and weeks starts over.
+func (l *Ledger) ExtendStreak(account, receiptID string, p Period, bonuses []StreakBonus) (Streak, int64, error) {
+	if err := ValidateAccount(account); err != nil {
+		return Streak{}, 0, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	s := l.streaks[account]
+	switch {
+	case s.Unit != p.Unit:
+		// a longest streak in days says nothing about weeks.
+		s = Streak{Unit: p.Unit, Current: 1}
+	case s.Current == 0 || p.Index > s.lastIndex+1:
+		s.Current = 1
+	case p.Index == s.lastIndex+1:
+		s.Current++
+	default:
+		return s, 0, nil
+	}
+	s.lastIndex, s.LastPeriod = p.Index, p.Label
+	s.Longest = max(s.Longest, s.Current)
+	l.streaks[account] = s
+
+	var bonus int64
+	for _, b := range bonuses {
+		if b.Length == s.Current {
+			bonus += b.Points
+		}
+	}
+	if bonus > 0 {
+		if _, err := l.post(KindStreakBonus,
+			Entry{Account: account, Amount: bonus, ReceiptID: receiptID},
+			Entry{Account: IssuedAccount, Amount: -bonus, ReceiptID: receiptID},
+		); err != nil {
+			return Streak{}, 0, err
+		}
+	}
+	return s, bonus, nil
+}
+
+// Streak returns the account's streak. Accounts that never extended one get a zero Streak.
+func (l *Ledger) Streak(account string) (Streak, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account = l.resolve(account)
+	if !l.exists(account) {
+		return Streak{}, ErrUnknownAccount
+	}
+	return l.streaks[account], nil
+}
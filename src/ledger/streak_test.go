@@ -0,0 +1,67 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+)
+
+func day(i int64) Period {
+	return Period{Unit: "day", Index: i, Label: "day"}
+}
+
+func TestExtendStreak(t *testing.T) {
+	bonuses := []StreakBonus{{Length: 2, Points: 5}, {Length: 3, Points: 10}}
+
+	testCases := []struct {
+		name        string
+		periods     []Period
+		wantCurrent int
+		wantLongest int
+		wantBalance int64
+	}{
+		{name: "consecutive", periods: []Period{day(1), day(2), day(3)}, wantCurrent: 3, wantLongest: 3, wantBalance: 3 + 5 + 10},
+		{name: "same day twice", periods: []Period{day(1), day(1), day(2)}, wantCurrent: 2, wantLongest: 2, wantBalance: 3 + 5},
+		{name: "gap", periods: []Period{day(1), day(2), day(4)}, wantCurrent: 1, wantLongest: 2, wantBalance: 3 + 5},
+		{name: "restart earns again", periods: []Period{day(1), day(2), day(4), day(5)}, wantCurrent: 2, wantLongest: 2, wantBalance: 4 + 5 + 5},
+		{name: "earlier day ignored", periods: []Period{day(5), day(3), day(6)}, wantCurrent: 2, wantLongest: 2, wantBalance: 3 + 5},
+		{name: "unit change", periods: []Period{day(1), day(2), {Unit: "week", Index: 1}}, wantCurrent: 1, wantLongest: 1, wantBalance: 3 + 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			for i, p := range tc.periods {
+				l.Accrue("alice", "r", 1)
+				if _, _, err := l.ExtendStreak("alice", "r", p, bonuses); err != nil {
+					t.Fatalf("ExtendStreak(%d) error = %v", i, err)
+				}
+			}
+
+			got, err := l.Streak("alice")
+			if err != nil {
+				t.Fatalf("Streak() error = %v", err)
+			}
+			if got.Current != tc.wantCurrent || got.Longest != tc.wantLongest {
+				t.Errorf("Streak() = %+v, want current %v and longest %v", got, tc.wantCurrent, tc.wantLongest)
+			}
+			if balance, _ := l.Balance("alice"); balance != tc.wantBalance {
+				t.Errorf("Balance() = %v, want %v", balance, tc.wantBalance)
+			}
+		})
+	}
+}
+
+func TestStreakActive(t *testing.T) {
+	l := New()
+	l.Accrue("alice", "r", 1)
+	s, _, _ := l.ExtendStreak("alice", "r", day(10), nil)
+
+	for now, want := range map[int64]bool{10: true, 11: true, 12: false} {
+		if got := s.Active(day(now)); got != want {
+			t.Errorf("Active(%v) = %v, want %v", now, got, want)
+		}
+	}
+	if _, err := l.Streak("bob"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Streak() of unknown account error = %v, want %v", err, ErrUnknownAccount)
+	}
+}
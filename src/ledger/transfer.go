@@ -0,0 +1,108 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidAmount        = errors.New("points must be positive")
+	ErrInsufficientPoints   = errors.New("insufficient points")
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different transfer")
+)
+
+// TransferRequest moves Points from one account to another, charging Fee on top to the sender.
+type TransferRequest struct {
+	From           string
+	To             string
+	Points         int64
+	Fee            int64
+	IdempotencyKey string
+}
+
+type TransferResult struct {
+	TxID   string `json:"txId"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Points int64  `json:"points"`
+	Fee    int64  `json:"fee"`
+	// Balance is the sender's balance right after the transfer.
+	Balance int64 `json:"balance"`
+	// Replayed is set when the idempotency key matched an earlier transfer and nothing was moved this time.
+	Replayed bool `json:"replayed"`
+}
+
+type transferRecord struct {
+	request TransferRequest
+	result  TransferResult
+	at      time.Time
+}
+
+// Transfer posts the sender's debit, the recipient's credit and the fee (credited to FeesAccount) as one
+// transaction. Keys are scoped to the sender; repeating a request with the same key returns the first result.
+func (l *Ledger) Transfer(req TransferRequest) (TransferResult, error) {
+	for _, id := range []string{req.From, req.To} {
+		if err := ValidateAccount(id); err != nil {
+			return TransferResult{}, err
+		}
+	}
+	if req.Points <= 0 || req.Fee < 0 {
+		return TransferResult{}, ErrInvalidAmount
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := req.From + "\x00" + req.IdempotencyKey
+	if prev, ok := l.transfers[key]; ok && req.IdempotencyKey != "" {
+		if prev.request != req {
+			return TransferResult{}, ErrIdempotencyKeyReused
+		}
+		result := prev.result
+		result.Replayed = true
+		return result, nil
+	}
+
+	from, to := l.resolve(req.From), l.resolve(req.To)
+	if from == to {
+		return TransferResult{}, ErrSameAccount
+	}
+	if !l.exists(from) || !l.exists(to) {
+		return TransferResult{}, ErrUnknownAccount
+	}
+	if l.balance(from) < req.Points+req.Fee {
+		return TransferResult{}, ErrInsufficientPoints
+	}
+
+	entries := []Entry{{Account: from, Amount: -(req.Points + req.Fee)}, {Account: to, Amount: req.Points}}
+	if req.Fee > 0 {
+		entries = append(entries, Entry{Account: FeesAccount, Amount: req.Fee})
+	}
+	txID, err := l.post(KindTransfer, entries...)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	result := TransferResult{TxID: txID, From: from, To: to, Points: req.Points, Fee: req.Fee, Balance: l.balance(from)}
+	if req.IdempotencyKey != "" {
+		l.transfers[key] = transferRecord{request: req, result: result, at: l.now().UTC()}
+	}
+	return result, nil
+}
+
+// ExpireIdempotencyKeys forgets the results of transfers made before cutoff, after which their keys can be used for
+// new transfers. It returns how many there were; with dryRun nothing is forgotten.
+func (l *Ledger) ExpireIdempotencyKeys(cutoff time.Time, dryRun bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for key, t := range l.transfers {
+		if t.at.Before(cutoff) {
+			n++
+			if !dryRun {
+				delete(l.transfers, key)
+			}
+		}
+	}
+	return n
+}
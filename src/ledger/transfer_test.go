@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	l := New()
+	l.Accrue("alice", "r1", 100)
+	l.Accrue("bob", "r2", 10)
+
+	got, err := l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 50, Fee: 5, IdempotencyKey: "k1"})
+	if err != nil {
+		t.Fatalf("Transfer() error = %v", err)
+	}
+	if got.Balance != 45 || got.Replayed {
+		t.Errorf("Transfer() = %+v, want balance 45", got)
+	}
+
+	for account, want := range map[string]int64{"alice": 45, "bob": 60, FeesAccount: 5, IssuedAccount: -110} {
+		if balance, _ := l.Balance(account); balance != want {
+			t.Errorf("Balance(%v) = %v, want %v", account, balance, want)
+		}
+	}
+
+	// a retry with the same key doesn't move anything again.
+	again, err := l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 50, Fee: 5, IdempotencyKey: "k1"})
+	if err != nil || !again.Replayed || again.TxID != got.TxID {
+		t.Errorf("retried Transfer() = %+v, %v, want the original result replayed", again, err)
+	}
+	if balance, _ := l.Balance("alice"); balance != 45 {
+		t.Errorf("Balance() after retry = %v, want 45", balance)
+	}
+}
+
+func TestTransferErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		req     TransferRequest
+		wantErr error
+	}{
+		{name: "insufficient", req: TransferRequest{From: "alice", To: "bob", Points: 100}, wantErr: ErrInsufficientPoints},
+		{name: "fee makes it insufficient", req: TransferRequest{From: "alice", To: "bob", Points: 100, Fee: 1, IdempotencyKey: "fee"}, wantErr: ErrInsufficientPoints},
+		{name: "zero points", req: TransferRequest{From: "alice", To: "bob", Points: 0}, wantErr: ErrInvalidAmount},
+		{name: "negative fee", req: TransferRequest{From: "alice", To: "bob", Points: 1, Fee: -1}, wantErr: ErrInvalidAmount},
+		{name: "to itself", req: TransferRequest{From: "alice", To: "alice", Points: 1}, wantErr: ErrSameAccount},
+		{name: "unknown recipient", req: TransferRequest{From: "alice", To: "carol", Points: 1}, wantErr: ErrUnknownAccount},
+		{name: "to a system account", req: TransferRequest{From: "alice", To: FeesAccount, Points: 1}, wantErr: ErrInvalidAccount},
+		{name: "key reused", req: TransferRequest{From: "alice", To: "bob", Points: 2, IdempotencyKey: "used"}, wantErr: ErrIdempotencyKeyReused},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			l.Accrue("alice", "r1", 100)
+			l.Accrue("bob", "r2", 10)
+			if _, err := l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 1, IdempotencyKey: "used"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := l.Transfer(tc.req); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Transfer() error = %v, want %v", err, tc.wantErr)
+			}
+			if balance, _ := l.Balance("alice"); balance != 99 {
+				t.Errorf("failed transfer changed the balance to %v", balance)
+			}
+		})
+	}
+}
+
+func TestExpireIdempotencyKeys(t *testing.T) {
+	l := New()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	l.now = func() time.Time { return now }
+	l.Accrue("alice", "r1", 100)
+	l.Accrue("bob", "r2", 10)
+	l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 10, IdempotencyKey: "old"})
+	now = start.Add(48 * time.Hour)
+	l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 10, IdempotencyKey: "new"})
+
+	if n := l.ExpireIdempotencyKeys(start.Add(24*time.Hour), true); n != 1 || len(l.transfers) != 2 {
+		t.Errorf("dry run expired %v keys and left %v, want 1 found and 2 left", n, len(l.transfers))
+	}
+	if n := l.ExpireIdempotencyKeys(start.Add(24*time.Hour), false); n != 1 || len(l.transfers) != 1 {
+		t.Errorf("expired %v keys and left %v, want 1 and 1", n, len(l.transfers))
+	}
+
+	// an expired key is free for a new transfer.
+	if got, _ := l.Transfer(TransferRequest{From: "alice", To: "bob", Points: 20, IdempotencyKey: "old"}); got.Replayed {
+		t.Errorf("Transfer() with an expired key was replayed")
+	}
+}
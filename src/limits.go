@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// ReceiptLimitsConfig caps how many items a receipt can have and how long their descriptions can be. The payload
+// is checked token by token as it is read, so a receipt with a million items is turned away after reading
+// MaxItems+1 of them instead of being buffered, decoded and scored. MaxBodyBytes caps the body of a submission in any
+// format. MaxMetadataKeys and MaxMetadataValueLength cap the metadata, which is checked once decoded. Zero means the
+// default.
+type ReceiptLimitsConfig struct {
+	MaxItems               int `json:"maxItems"`
+	MaxDescriptionLength   int `json:"maxDescriptionLength"`
+	MaxMetadataKeys        int `json:"maxMetadataKeys"`
+	MaxMetadataValueLength int `json:"maxMetadataValueLength"`
+	MaxBodyBytes           int `json:"maxBodyBytes"`
+}
+
+const (
+	defaultMaxItems               = 500
+	defaultMaxDescriptionLength   = 100
+	defaultMaxMetadataKeys        = 20
+	defaultMaxMetadataValueLength = 200
+	defaultMaxBodyBytes           = 1 << 20
+)
+
+func (c ReceiptLimitsConfig) Validate() error {
+	if c.MaxItems < 0 || c.MaxDescriptionLength < 0 || c.MaxMetadataKeys < 0 || c.MaxMetadataValueLength < 0 || c.MaxBodyBytes < 0 {
+		return fmt.Errorf("receiptLimits: maxItems, maxDescriptionLength, maxMetadataKeys, maxMetadataValueLength and maxBodyBytes must not be negative")
+	}
+	return nil
+}
+
+func (c ReceiptLimitsConfig) maxItems() int {
+	if c.MaxItems == 0 {
+		return defaultMaxItems
+	}
+	return c.MaxItems
+}
+
+func (c ReceiptLimitsConfig) maxDescriptionLength() int {
+	if c.MaxDescriptionLength == 0 {
+		return defaultMaxDescriptionLength
+	}
+	return c.MaxDescriptionLength
+}
+
+func (c ReceiptLimitsConfig) maxMetadataKeys() int {
+	if c.MaxMetadataKeys == 0 {
+		return defaultMaxMetadataKeys
+	}
+	return c.MaxMetadataKeys
+}
+
+func (c ReceiptLimitsConfig) maxMetadataValueLength() int {
+	if c.MaxMetadataValueLength == 0 {
+		return defaultMaxMetadataValueLength
+	}
+	return c.MaxMetadataValueLength
+}
+
+func (c ReceiptLimitsConfig) maxBodyBytes() int {
+	if c.MaxBodyBytes == 0 {
+		return defaultMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// errMalformed stops the walk over a payload the decoder will reject anyway.
+var errMalformed = errors.New("malformed receipt")
+
+func tooManyItemsError(max int) error {
+	return validation.Errors{"items": validation.NewError("validation_items_limit", fmt.Sprintf("must contain at most %d items", max))}
+}
+
+func descriptionTooLongError(item, max int) error {
+	return validation.Errors{"items": validation.Errors{strconv.Itoa(item): validation.Errors{
+		"shortDescription": validation.NewError("validation_description_limit", fmt.Sprintf("must be at most %d characters", max)),
+	}}}
+}
+
+// checkReceiptLimits walks the items of a receipt payload and stops at the first one over the limits. Anything
+// malformed is left for the decoder to report.
+func checkReceiptLimits(b []byte, c ReceiptLimitsConfig) error {
+	return checkReceiptStream(bytes.NewReader(b), c)
+}
+
+// checkReceiptStream is checkReceiptLimits on a payload still being read, reading no further than the first item
+// over the limits. Read errors are left for the decoder to report too. Every "items" key is checked: the decoder keeps
+// the last of duplicate keys, and matches them regardless of case.
+func checkReceiptStream(r io.Reader, c ReceiptLimitsConfig) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if !isKey(key, "items") {
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return nil
+			}
+			continue
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if tok != json.Delim('[') {
+			if _, ok := tok.(json.Delim); ok {
+				return nil
+			}
+			// null, or a value the decoder rejects.
+			continue
+		}
+		for i := 0; dec.More(); i++ {
+			if i == c.maxItems() {
+				return tooManyItemsError(c.maxItems())
+			}
+			if err := checkItemLimits(dec, i, c); errors.Is(err, errMalformed) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// isKey reports whether tok is the key name as the decoder matches keys to fields, regardless of case.
+func isKey(tok json.Token, name string) bool {
+	key, ok := tok.(string)
+	return ok && strings.EqualFold(key, name)
+}
+
+// checkItemLimits reads one item off dec.
+func checkItemLimits(dec *json.Decoder, i int, c ReceiptLimitsConfig) error {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errMalformed
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return errMalformed
+		}
+		value, err := dec.Token()
+		if err != nil {
+			return errMalformed
+		}
+		if _, ok := value.(json.Delim); ok {
+			// nested values are the decoder's to reject.
+			return errMalformed
+		}
+		if s, ok := value.(string); ok && isKey(key, "shortDescription") && utf8.RuneCountInString(s) > c.maxDescriptionLength() {
+			return descriptionTooLongError(i, c.maxDescriptionLength())
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return errMalformed
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestCheckReceiptLimits(t *testing.T) {
+	limits := ReceiptLimitsConfig{MaxItems: 2, MaxDescriptionLength: 5}
+
+	testCases := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "within limits", payload: string(receipttest.New().Item("Apple", "1.00").Item("Pear", "2.00").Build().JSON())},
+		{name: "too many items", payload: string(receipttest.New().Item("Apple", "1.00").Item("Pear", "2.00").Item("Plum", "3.00").Build().JSON()), wantErr: "items: must contain at most 2 items."},
+		{name: "description too long", payload: string(receipttest.New().Item("Apple", "1.00").Item("Banana", "2.00").Build().JSON()), wantErr: "items: (1: (shortDescription: must be at most 5 characters.).)."},
+		{name: "counted in characters", payload: `{"items": [{"shortDescription": "Äpfel", "price": "1.00"}]}`},
+		{name: "items first", payload: `{"items": [{"price": "1.00", "shortDescription": "Apple"}, {}, {}], "retailer": "Target"}`, wantErr: "items: must contain at most 2 items."},
+		{name: "malformed items", payload: `{"items": [["Apple"], {}, {}]}`},
+		{name: "not an object", payload: `[1, 2, 3]`},
+		{name: "truncated", payload: `{"items": [{"shortDescription": "Apple"`},
+		{name: "duplicate items", payload: `{"items": [], "items": [{}, {}, {}]}`, wantErr: "items: must contain at most 2 items."},
+		{name: "items after null", payload: `{"items": null, "Items": [{"SHORTDESCRIPTION": "Banana"}]}`, wantErr: "items: (0: (shortDescription: must be at most 5 characters.).)."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkReceiptLimits([]byte(tc.payload), limits)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("checkReceiptLimits() error = %v, want none", err)
+			}
+			if tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+				t.Errorf("checkReceiptLimits() error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReceiptLimits(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxItems: 3}
+	liveConfig.Store(&live)
+
+	builder := receipttest.New()
+	for range 4 {
+		builder.Item("Gatorade", "1.00")
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/score", bytes.NewReader(builder.Build().JSON())))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "must contain at most 3 items") {
+		t.Errorf("got %v %s, want 400 naming the item limit", rr.Code, rr.Body)
+	}
+}
+
+// endlessItems is a receipt payload whose items never end.
+type endlessItems struct{ started bool }
+
+func (r *endlessItems) Read(p []byte) (int, error) {
+	chunk := `{"shortDescription": "Gatorade", "price": "1.00"}, `
+	if !r.started {
+		r.started, chunk = true, `{"items": [`
+	}
+	return copy(p, chunk), nil
+}
+
+func TestCheckReceiptStream(t *testing.T) {
+	err := checkReceiptStream(&endlessItems{}, ReceiptLimitsConfig{MaxItems: 3})
+	if err == nil || err.Error() != "items: must contain at most 3 items." {
+		t.Errorf("checkReceiptStream() error = %v, want the item limit", err)
+	}
+}
+
+func TestReceiptBodyLimit(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxBodyBytes: 100}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "larger than 100 bytes") {
+		t.Errorf("got %v %s, want 413 naming the limit", rr.Code, rr.Body)
+	}
+}
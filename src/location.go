@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.uber.org/zap"
+)
+
+// StoreLocation is where a receipt was issued, optional on receipts: a postal code, coordinates, or both.
+type StoreLocation struct {
+	PostalCode string   `json:"postalCode,omitempty" xml:"postalCode"`
+	Latitude   *float64 `json:"latitude,omitempty" xml:"latitude"`
+	Longitude  *float64 `json:"longitude,omitempty" xml:"longitude"`
+}
+
+var postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$`)
+
+func (l StoreLocation) Validate() error {
+	err := validation.ValidateStruct(&l,
+		validation.Field(&l.PostalCode,
+			validation.Match(postalCodePattern).Error("want 3 to 10 letters, digits, spaces or hyphens")),
+		validation.Field(&l.Latitude,
+			validation.When(l.Longitude != nil, validation.NotNil.Error("is required with a longitude")),
+			validation.Min(-90.0), validation.Max(90.0)),
+		validation.Field(&l.Longitude,
+			validation.When(l.Latitude != nil, validation.NotNil.Error("is required with a latitude")),
+			validation.Min(-180.0), validation.Max(180.0)),
+	)
+	if err != nil {
+		return err
+	}
+	if l.PostalCode == "" && l.Latitude == nil {
+		return validation.Errors{"postalCode": validation.NewError("validation_required", "a postal code or coordinates are required")}
+	}
+	return nil
+}
+
+// normalizedPostalCode is the postal code uppercased and without spaces or hyphens, what region prefixes match on.
+func (l StoreLocation) normalizedPostalCode() string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(l.PostalCode))
+}
+
+// RegionsConfig names regions, which rules can be scoped to and stats are grouped by.
+type RegionsConfig map[string]RegionConfig
+
+// RegionConfig is the area of a region: postal codes starting with any of PostalCodePrefixes, or coordinates in any
+// of Boxes.
+type RegionConfig struct {
+	PostalCodePrefixes []string      `json:"postalCodePrefixes"`
+	Boxes              []BoundingBox `json:"boxes"`
+}
+
+// BoundingBox is an area between two latitudes and two longitudes. It can't cross the antimeridian; use two boxes.
+type BoundingBox struct {
+	MinLatitude  float64 `json:"minLatitude"`
+	MaxLatitude  float64 `json:"maxLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+func (b BoundingBox) contains(latitude, longitude float64) bool {
+	return latitude >= b.MinLatitude && latitude <= b.MaxLatitude && longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+var regionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func (c RegionsConfig) Validate() error {
+	for name, region := range c {
+		if !regionNamePattern.MatchString(name) {
+			return fmt.Errorf("regions: %q must be lowercase letters, digits and hyphens", name)
+		}
+		if name == otherRegion || name == unknownRegion {
+			return fmt.Errorf("regions: %q is reserved for the stats of receipts outside every region", name)
+		}
+		if len(region.PostalCodePrefixes) == 0 && len(region.Boxes) == 0 {
+			return fmt.Errorf("regions: %v needs postalCodePrefixes or boxes", name)
+		}
+		for _, prefix := range region.PostalCodePrefixes {
+			if (StoreLocation{PostalCode: prefix}).normalizedPostalCode() == "" {
+				return fmt.Errorf("regions: %v: empty postal code prefix", name)
+			}
+		}
+		for _, b := range region.Boxes {
+			if b.MinLatitude > b.MaxLatitude || b.MinLongitude > b.MaxLongitude || b.MinLatitude < -90 || b.MaxLatitude > 90 || b.MinLongitude < -180 || b.MaxLongitude > 180 {
+				return fmt.Errorf("regions: %v: boxes need min below max, latitudes within ±90 and longitudes within ±180", name)
+			}
+		}
+	}
+	return nil
+}
+
+// regionOf returns the region l is in, or "" for none. When regions overlap the first by name wins, so every receipt
+// is in at most one region.
+func (c RegionsConfig) regionOf(l *StoreLocation) string {
+	if l == nil {
+		return ""
+	}
+	postalCode := l.normalizedPostalCode()
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		region := c[name]
+		if postalCode != "" && slices.ContainsFunc(region.PostalCodePrefixes, func(prefix string) bool {
+			return strings.HasPrefix(postalCode, StoreLocation{PostalCode: prefix}.normalizedPostalCode())
+		}) {
+			return name
+		}
+		if l.Latitude != nil && slices.ContainsFunc(region.Boxes, func(b BoundingBox) bool {
+			return b.contains(*l.Latitude, *l.Longitude)
+		}) {
+			return name
+		}
+	}
+	return ""
+}
+
+// RegionStats sums up the stored receipts of a region. Total is the sum of the receipt totals.
+type RegionStats struct {
+	Region   string `json:"region"`
+	Receipts int    `json:"receipts"`
+	Points   int64  `json:"points"`
+	Total    string `json:"total"`
+}
+
+// regionStatsResponse is the response of GET /stats/regions. Receipts with a location outside every region are
+// counted under "other", receipts without one under "unknown".
+type regionStatsResponse struct {
+	From    string        `json:"from,omitempty"`
+	To      string        `json:"to,omitempty"`
+	Regions []RegionStats `json:"regions"`
+}
+
+const (
+	otherRegion   = "other"
+	unknownRegion = "unknown"
+)
+
+// getRegionStats serves GET /stats/regions?from=&to=, grouping the stored receipts purchased between the two
+// inclusive dates (all of them by default) by region. The daily aggregates keep the store locations rather than the
+// regions, which are those of the live config, so changing them regroups receipts stored before.
+func getRegionStats(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := statsDates(w, r)
+	if !ok {
+		return
+	}
+
+	regions := currentConfig().Regions
+	groups, err := groupDaily(r.Context(), store.DailyLocation, from, to, func(_, location string) string {
+		if location == "" {
+			return unknownRegion
+		}
+		var l StoreLocation
+		if err := json.Unmarshal([]byte(location), &l); err != nil {
+			return unknownRegion
+		}
+		if region := regions.regionOf(&l); region != "" {
+			return region
+		}
+		return otherRegion
+	})
+	if err != nil {
+		logger.Error("Failed to read daily aggregates", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	names := append(slices.Collect(maps.Keys(regions)), otherRegion, unknownRegion)
+	slices.Sort(names)
+	response := regionStatsResponse{From: from, To: to, Regions: []RegionStats{}}
+	for _, name := range names {
+		totals := statsTotals{}
+		if groups[name] != nil {
+			totals = *groups[name]
+		}
+		response.Regions = append(response.Regions, RegionStats{Region: name, Receipts: totals.receipts, Points: totals.points, Total: totals.total()})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
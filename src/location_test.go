@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestStoreLocationValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		wantErr bool
+	}{
+		{name: "none", receipt: receipttest.New().Build()},
+		{name: "postal code", receipt: receipttest.New().PostalCode("10001").Build()},
+		{name: "postal code with a space", receipt: receipttest.New().PostalCode("SW1A 1AA").Build()},
+		{name: "coordinates", receipt: receipttest.New().Coordinates(40.75, -73.99).Build()},
+		{name: "both", receipt: receipttest.New().PostalCode("10001").Coordinates(40.75, -73.99).Build()},
+		{name: "invalid postal code", receipt: receipttest.New().PostalCode("1!").Build(), wantErr: true},
+		{name: "latitude out of range", receipt: receipttest.New().Coordinates(91, 0).Build(), wantErr: true},
+		{name: "longitude out of range", receipt: receipttest.New().Coordinates(0, -181).Build(), wantErr: true},
+		{name: "latitude without longitude", receipt: func() receipttest.Receipt {
+			r := receipttest.New().Coordinates(40, -73).Build()
+			r.StoreLocation.Longitude = nil
+			return r
+		}(), wantErr: true},
+		{name: "empty", receipt: func() receipttest.Receipt {
+			r := receipttest.New().Build()
+			r.StoreLocation = &receipttest.Location{}
+			return r
+		}(), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			err := json.Unmarshal(tc.receipt.JSON(), &receipt)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+var testRegions = RegionsConfig{
+	"manhattan": {PostalCodePrefixes: []string{"100", "101", "102"}, Boxes: []BoundingBox{{MinLatitude: 40.70, MaxLatitude: 40.88, MinLongitude: -74.02, MaxLongitude: -73.91}}},
+	"london":    {PostalCodePrefixes: []string{"EC", "WC", "SW1"}},
+}
+
+func TestRegionOf(t *testing.T) {
+	latitude, longitude := 40.75, -73.99
+	testCases := []struct {
+		name     string
+		location *StoreLocation
+		want     string
+	}{
+		{name: "no location", location: nil, want: ""},
+		{name: "postal code", location: &StoreLocation{PostalCode: "10001"}, want: "manhattan"},
+		{name: "postal code with spaces and lowercase", location: &StoreLocation{PostalCode: "sw1a 1aa"}, want: "london"},
+		{name: "coordinates", location: &StoreLocation{Latitude: &latitude, Longitude: &longitude}, want: "manhattan"},
+		{name: "outside every region", location: &StoreLocation{PostalCode: "94105"}, want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := testRegions.regionOf(tc.location); got != tc.want {
+				t.Errorf("regionOf() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegionScopedRules(t *testing.T) {
+	setup()
+	live := cfg
+	live.Regions = testRegions
+	live.Rules = RulesConfig{"oddDay": {Regions: map[string]RuleConfig{"manhattan": {Multiplier: 2}, "london": {Disabled: true}}}}
+	liveConfig.Store(&live)
+
+	testCases := []struct {
+		name       string
+		postalCode string
+		want       int
+	}{
+		{name: "no region", postalCode: "94105", want: 6},
+		{name: "multiplied in a region", postalCode: "10001", want: 12},
+		{name: "disabled in a region", postalCode: "EC1A 1BB", want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			if err := json.Unmarshal(receipttest.New().PurchaseDate("2022-01-01").PostalCode(tc.postalCode).Build().JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			got := 0
+			for _, result := range receipt.Breakdown() {
+				if result.Rule == "oddDay" {
+					got = result.Points
+				}
+			}
+			if got != tc.want {
+				t.Errorf("oddDay = %d points, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRulesConfigUnknownRegion(t *testing.T) {
+	rules := RulesConfig{"oddDay": {Regions: map[string]RuleConfig{"atlantis": {Multiplier: 2}}}}
+	if err := rules.validateRegions(testRegions); err == nil {
+		t.Error("validateRegions() = nil, want an error for an unknown region")
+	}
+}
+
+func TestGetRegionStats(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Regions = testRegions
+	liveConfig.Store(&live)
+
+	for _, receipt := range []receipttest.Receipt{
+		receipttest.New().PostalCode("10001").Item("Dasani", "1.40").Build(),
+		receipttest.New().Coordinates(40.75, -73.99).Item("Dasani", "1.40").Build(),
+		receipttest.New().PurchaseDate("2022-02-01").PostalCode("10001").Item("Dasani", "1.40").Build(),
+		receipttest.New().PostalCode("94105").Item("Dasani", "1.40").Build(),
+		receipttest.New().Item("Dasani", "1.40").Build(),
+	} {
+		submitForAccount(t, router, "", receipt)
+	}
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       map[string]int
+	}{
+		{name: "all", query: "", wantStatus: http.StatusOK, want: map[string]int{"london": 0, "manhattan": 3, "other": 1, "unknown": 1}},
+		{name: "january", query: "?from=2022-01-01&to=2022-01-31", wantStatus: http.StatusOK, want: map[string]int{"london": 0, "manhattan": 2, "other": 1, "unknown": 1}},
+		{name: "invalid date", query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/stats/regions"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp regionStatsResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			got := map[string]int{}
+			for _, region := range resp.Regions {
+				got[region.Region] = region.Receipts
+			}
+			for region, want := range tc.want {
+				if got[region] != want {
+					t.Errorf("%s has %d receipts, want %d (%s)", region, got[region], want, rr.Body)
+				}
+			}
+			if tc.query == "" && resp.Regions[1].Total != "4.20" {
+				t.Errorf("manhattan total = %s, want 4.20", resp.Regions[1].Total)
+			}
+		})
+	}
+}
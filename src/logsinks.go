@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LoggingConfig ships the logs somewhere besides stderr, for deployments without a log collector. Every sink gets
+// the same JSON lines as stderr, from Level up (the global log level when empty). Sinks need a restart to change.
+type LoggingConfig struct {
+	Sinks []LogSinkConfig `json:"sinks"`
+}
+
+// LogSinkConfig is one place the logs go:
+//   - "file" writes to Path, rotated once it reaches MaxSizeMB (100 by default), keeping MaxBackups old files for
+//     MaxAgeDays, gzipped with Compress. Zero keeps them all.
+//   - "syslog" sends to the syslog daemon at Address over Network ("udp" by default), or the local one without an
+//     Address, tagged Tag ("fcpc" by default). Log levels map to syslog severities.
+//   - "tcp" writes the lines to Address, reconnecting when the connection drops. Lines written while it is down are
+//     dropped rather than holding up the service.
+type LogSinkConfig struct {
+	Type       string `json:"type"`
+	Level      string `json:"level"`
+	Path       string `json:"path"`
+	MaxSizeMB  int    `json:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups"`
+	MaxAgeDays int    `json:"maxAgeDays"`
+	Compress   bool   `json:"compress"`
+	Network    string `json:"network"`
+	Address    string `json:"address"`
+	Tag        string `json:"tag"`
+}
+
+func (c LoggingConfig) Validate() error {
+	for i, sink := range c.Sinks {
+		if _, err := parseLogLevel(sink.Level); err != nil {
+			return fmt.Errorf("logging: sinks[%d]: invalid level: %w", i, err)
+		}
+		switch sink.Type {
+		case "file":
+			if sink.Path == "" {
+				return fmt.Errorf("logging: sinks[%d]: path is required for a file sink", i)
+			}
+			if sink.MaxSizeMB < 0 || sink.MaxBackups < 0 || sink.MaxAgeDays < 0 {
+				return fmt.Errorf("logging: sinks[%d]: maxSizeMB, maxBackups and maxAgeDays must not be negative", i)
+			}
+		case "syslog":
+			if sink.Network != "" && sink.Network != "udp" && sink.Network != "tcp" {
+				return fmt.Errorf("logging: sinks[%d]: network must be \"udp\" or \"tcp\"", i)
+			}
+		case "tcp":
+			if sink.Address == "" {
+				return fmt.Errorf("logging: sinks[%d]: address is required for a tcp sink", i)
+			}
+		default:
+			return fmt.Errorf("logging: sinks[%d]: unknown type %q, must be \"file\", \"syslog\" or \"tcp\"", i, sink.Type)
+		}
+	}
+	return nil
+}
+
+// logSinks are the sinks opened at startup, closed when setup opens them again.
+var logSinks []logSink
+
+type logSink struct {
+	core   func(zapcore.Encoder, zapcore.LevelEnabler) zapcore.Core
+	level  *zapcore.Level
+	closer io.Closer
+}
+
+// openLogSinks opens the sinks of c in place of the ones opened before.
+func openLogSinks(c LoggingConfig) error {
+	for _, sink := range logSinks {
+		sink.closer.Close()
+	}
+	logSinks = nil
+	for i, sc := range c.Sinks {
+		sink, err := openLogSink(sc)
+		if err != nil {
+			return fmt.Errorf("logging: sinks[%d]: %w", i, err)
+		}
+		if sc.Level != "" {
+			level, _ := parseLogLevel(sc.Level)
+			sink.level = &level
+		}
+		logSinks = append(logSinks, sink)
+	}
+	return nil
+}
+
+func openLogSink(c LogSinkConfig) (logSink, error) {
+	switch c.Type {
+	case "file":
+		w := &lumberjack.Logger{Filename: c.Path, MaxSize: c.MaxSizeMB, MaxBackups: c.MaxBackups, MaxAge: c.MaxAgeDays, Compress: c.Compress}
+		return ioSink(zapcore.AddSync(w), w), nil
+	case "syslog":
+		network := c.Network
+		if network == "" && c.Address != "" {
+			network = "udp"
+		}
+		tag := c.Tag
+		if tag == "" {
+			tag = "fcpc"
+		}
+		w, err := syslog.Dial(network, c.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return logSink{}, err
+		}
+		return logSink{core: func(enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core {
+			return &syslogCore{LevelEnabler: level, enc: enc, w: w}
+		}, closer: w}, nil
+	default:
+		w := &tcpSink{address: c.Address}
+		return ioSink(w, w), nil
+	}
+}
+
+func ioSink(w zapcore.WriteSyncer, closer io.Closer) logSink {
+	return logSink{core: func(enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core {
+		return zapcore.NewCore(enc, w, level)
+	}, closer: closer}
+}
+
+// withLogSinks tees l into the sinks. level is what the sinks without a level of their own log from.
+func withLogSinks(l *zap.Logger, level zapcore.LevelEnabler) *zap.Logger {
+	if len(logSinks) == 0 {
+		return l
+	}
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	cores := []zapcore.Core{}
+	for _, sink := range logSinks {
+		var enabler zapcore.LevelEnabler = level
+		if sink.level != nil {
+			enabler = *sink.level
+		}
+		cores = append(cores, sink.core(zapcore.NewJSONEncoder(encoderConfig), enabler))
+	}
+	return l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(append(cores, core)...)
+	}))
+}
+
+// syslogCore writes each entry at the syslog severity of its level.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *syslog.Writer
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &syslogCore{LevelEnabler: c.LevelEnabler, enc: enc, w: c.w}
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	msg := buf.String()
+	switch {
+	case ent.Level >= zapcore.DPanicLevel:
+		return c.w.Crit(msg)
+	case ent.Level == zapcore.ErrorLevel:
+		return c.w.Err(msg)
+	case ent.Level == zapcore.WarnLevel:
+		return c.w.Warning(msg)
+	case ent.Level == zapcore.InfoLevel:
+		return c.w.Info(msg)
+	default:
+		return c.w.Debug(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+var logLinesDroppedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_log_lines_dropped_total",
+	Help: "Log lines a sink dropped because it couldn't deliver them, by sink type.",
+}, []string{"sink"})
+
+// tcpSinkRetry is how long a tcp sink waits after a failed connection before it tries again.
+const tcpSinkRetry = 5 * time.Second
+
+// tcpSink writes to a TCP connection it opens on the first write and again after it drops, at most every
+// tcpSinkRetry. A write with no connection is dropped and counted, returning an error would have zap report every
+// line to stderr.
+type tcpSink struct {
+	address string
+
+	mu         sync.Mutex
+	conn       net.Conn
+	lastDialed time.Time
+}
+
+func (s *tcpSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if time.Since(s.lastDialed) < tcpSinkRetry {
+			logLinesDroppedTotal.WithLabelValues("tcp").Inc()
+			return len(p), nil
+		}
+		s.lastDialed = time.Now()
+		conn, err := net.DialTimeout("tcp", s.address, time.Second)
+		if err != nil {
+			logLinesDroppedTotal.WithLabelValues("tcp").Inc()
+			return len(p), nil
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := s.conn.Write(p); err != nil {
+		logLinesDroppedTotal.WithLabelValues("tcp").Inc()
+		s.conn.Close()
+		s.conn = nil
+	}
+	return len(p), nil
+}
+
+func (s *tcpSink) Sync() error {
+	return nil
+}
+
+func (s *tcpSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogSinks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lines := bufio.NewScanner(conn)
+		for lines.Scan() {
+			received <- lines.Text()
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "fcpc.log")
+	err = openLogSinks(LoggingConfig{Sinks: []LogSinkConfig{
+		{Type: "file", Path: path},
+		{Type: "tcp", Address: listener.Addr().String(), Level: "warn"},
+	}})
+	if err != nil {
+		t.Fatalf("openLogSinks() error = %v", err)
+	}
+	t.Cleanup(func() { openLogSinks(LoggingConfig{}) })
+
+	l := withLogSinks(zap.NewNop(), zapcore.InfoLevel)
+	l.Debug("Not logged anywhere")
+	l.Info("Stored receipt", zap.String("receiptID", "r1"))
+	l.Warn("Store is slow")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("file sink line %q isn't JSON: %v", line, err)
+		}
+		messages = append(messages, entry["msg"].(string))
+	}
+	if got := strings.Join(messages, ", "); got != "Stored receipt, Store is slow" {
+		t.Errorf("file sink got %s, want the info and warn lines", got)
+	}
+
+	select {
+	case line := <-received:
+		if !strings.Contains(line, `"msg":"Store is slow"`) {
+			t.Errorf("tcp sink got %s, want only the warning", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tcp sink got nothing")
+	}
+}
+
+func TestLoggingConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		sink    LogSinkConfig
+		wantErr bool
+	}{
+		{name: "file", sink: LogSinkConfig{Type: "file", Path: "/var/log/fcpc.log", MaxSizeMB: 50, MaxBackups: 3}},
+		{name: "local syslog", sink: LogSinkConfig{Type: "syslog"}},
+		{name: "remote syslog", sink: LogSinkConfig{Type: "syslog", Network: "tcp", Address: "logs:514", Level: "error"}},
+		{name: "tcp", sink: LogSinkConfig{Type: "tcp", Address: "logs:5170"}},
+		{name: "file without path", sink: LogSinkConfig{Type: "file"}, wantErr: true},
+		{name: "negative backups", sink: LogSinkConfig{Type: "file", Path: "fcpc.log", MaxBackups: -1}, wantErr: true},
+		{name: "syslog over unix", sink: LogSinkConfig{Type: "syslog", Network: "unix"}, wantErr: true},
+		{name: "tcp without address", sink: LogSinkConfig{Type: "tcp"}, wantErr: true},
+		{name: "bad level", sink: LogSinkConfig{Type: "tcp", Address: "logs:5170", Level: "loud"}, wantErr: true},
+		{name: "unknown type", sink: LogSinkConfig{Type: "kafka"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := (LoggingConfig{Sinks: []LogSinkConfig{tc.sink}}).Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
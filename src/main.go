@@ -1,78 +1,319 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-// using sync.Map instead of map+mutex because the requirements for this app fall specifically into what sync.Map
-// is recommended for: https://pkg.go.dev/sync#Map
-var receiptStore = sync.Map{}
+var receiptStore store.Store
+var pointsLedger *ledger.Ledger
 var logger *zap.Logger
+var cfg Config
 
 func main() {
-
-	router := setup()
-	defer logger.Sync()
-
-	logger.Info("Starting server on port 8000")
-	http.ListenAndServe(":8000", router)
+	os.Exit(runCLI(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
 }
 
+// setup builds everything the server needs from the config at $CONFIG_FILE and returns its router.
 func setup() *mux.Router {
-	logLevel := os.Getenv("LOG_LEVEL")
+	return setupFrom(os.Getenv("CONFIG_FILE"))
+}
+
+func setupFrom(path string) *mux.Router {
 	var err error
 
-	if logLevel == "DEBUG" {
-		logger, err = zap.NewDevelopment()
-	} else {
-		logger, err = zap.NewProduction()
+	cfg, err = loadConfig(path)
+	if err != nil {
+		panic("failed to load config: " + err.Error())
 	}
 
+	receiptStore, err = store.Open(context.Background(), cfg.Store.Backend, cfg.Store.DSN)
+	if err != nil {
+		panic("failed to open store: " + err.Error())
+	}
+	storeFallback = nil
+	if cfg.Store.Fallback.Enabled {
+		storeFallback = newFallbackStore(receiptStore, cfg.Store.Fallback)
+		receiptStore = storeFallback
+	}
+	spool, err = openSpool(cfg.Spool)
+	if err != nil {
+		panic("failed to open spool: " + err.Error())
+	}
+	pointsLedger = ledger.New()
+	statements = &statementCache{jobs: map[string]*statementJob{}}
+	events = newEventBus()
+	receiptCounter = newDailyCounter()
+	retailerCounter = newDailyCounter()
+	notificationSettings = &settingsStore{accounts: map[string]NotificationSettings{}}
+	preferences = newPreferenceStore()
+	erasures = newErasureRegistry()
+	returns = newReturnRegistry()
+	audits = newAuditQueue()
+	latencies = &latencyTracker{}
+	receiptGroups = newGroupRegistry()
+	receiptTemplates = newTemplateRegistry()
+	fraudFlags = newFraudFlagRegistry()
+	itemClaims = newItemClaimIndex()
+	fraudBreaker = &circuitBreaker{}
+	holds = newHoldRegistry()
+	ingested = newIngestMetrics(cfg.IngestMetrics)
+	lastRetentionRuns.Clear()
+	tap.clear()
+	keyUsage = newUsageTracker()
+	if err := refreshAPIKeys(context.Background()); err != nil {
+		panic("failed to load API keys: " + err.Error())
+	}
+	if recalculations != nil {
+		recalculations.stop()
+	}
+	recalculations, err = newRecalculationRunner(cfg.Recalculation.CheckpointPath)
+	if err != nil {
+		panic("failed to load recalculation checkpoint: " + err.Error())
+	}
+	transactions, err = loadTransactionIndex(context.Background(), receiptStore)
+	if err != nil {
+		panic("failed to load transaction numbers: " + err.Error())
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	if cfg.LogLevel == "DEBUG" {
+		zapConfig = zap.NewDevelopmentConfig()
+	}
+	zapConfig.Level = atomicLevel
+	logger, err = zapConfig.Build()
 	if err != nil {
 		panic("failed to initialize logger")
 	}
+	zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	debugLogger, err = zapConfig.Build()
+	if err != nil {
+		panic("failed to initialize logger")
+	}
+	if err := openLogSinks(cfg.Logging); err != nil {
+		panic("failed to open log sinks: " + err.Error())
+	}
+	logger = withLogSinks(logger, atomicLevel)
+	debugLogger = withLogSinks(debugLogger, zapcore.DebugLevel)
+
+	if cfg.Chaos.Enabled {
+		logger.Warn("Chaos injection is enabled, requests will be delayed and failed on purpose", zap.Any("chaos", cfg.Chaos))
+	}
+
+	applyConfig(cfg)
 
 	router := mux.NewRouter()
+	router.Use(clientIPMiddleware)
+	// first after that, so what clients got back from the other middlewares is recorded too.
+	router.Use(tapMiddleware)
+	router.Use(slowRequestsMiddleware)
+	router.Use(readOnlyMiddleware)
+	router.Use(apiKeyMiddleware)
+	router.Use(shardingMiddleware)
+	router.Use(sheddingMiddleware)
+	router.Use(concurrencyMiddleware)
+	router.Use(chaosMiddleware)
+	router.Use(receiptIDMiddleware)
 
 	router.HandleFunc("/receipts/{id}/points", getPoints).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points/explain", explainPoints).Methods("GET")
+	router.HandleFunc("/receipts/{id}/returns", createReturn).Methods("POST")
+	router.HandleFunc("/receipts/{id}/returns", listReturns).Methods("GET")
+	router.HandleFunc("/receipts/{id}/items", addItem).Methods("POST")
+	router.HandleFunc("/receipts/{id}/items/{index}", deleteItem).Methods("DELETE")
+	router.HandleFunc("/receipts/{id}/reprocess", reprocessReceipt).Methods("POST")
 	router.HandleFunc("/receipts/process", processReceipt).Methods("POST")
+	router.HandleFunc("/receipts", listReceipts).Methods("GET")
+	router.HandleFunc("/receipts/score", scoreReceipt).Methods("POST")
+	router.HandleFunc("/receipts/compare", compareReceipts).Methods("POST")
+	router.HandleFunc("/receipts/canonicalize", canonicalizeReceipt).Methods("POST")
+	router.HandleFunc("/receipts/signed-urls", createSignedURL).Methods("POST")
+	router.HandleFunc("/receipts/submit", submitSigned).Methods("POST")
+	router.HandleFunc("/receipt-groups", createGroup).Methods("POST")
+	router.HandleFunc("/receipt-templates", createTemplate).Methods("POST")
+	router.HandleFunc("/receipt-templates/{id}", getTemplate).Methods("GET")
+	router.HandleFunc("/receipt-templates/{id}", deleteTemplate).Methods("DELETE")
+	router.HandleFunc("/receipt-groups/{id}", getGroup).Methods("GET")
+	router.HandleFunc("/receipt-groups/{id}/points", getGroupPoints).Methods("GET")
+	router.HandleFunc("/ingest/s3/results", getS3Result).Methods("GET")
+	router.HandleFunc("/aggregate", getAggregate).Methods("GET")
+	router.HandleFunc("/stats/regions", getRegionStats).Methods("GET")
+	router.HandleFunc("/stats/payment-methods", getPaymentMethodStats).Methods("GET")
+	router.HandleFunc("/stats/hourly", getHourlyStats).Methods("GET")
+	router.HandleFunc("/events/schemas", getEventSchemas).Methods("GET")
+	router.Handle("/metrics", metricsHandler()).Methods("GET")
+	router.HandleFunc("/accounts/{id}/balance", getBalance).Methods("GET")
+	router.HandleFunc("/accounts/{id}/data", eraseAccountData).Methods("DELETE")
+	router.HandleFunc("/accounts/{id}/holds", listHolds).Methods("GET")
+	router.HandleFunc("/accounts/{id}/notifications", getNotificationSettings).Methods("GET")
+	router.HandleFunc("/accounts/{id}/notifications", putNotificationSettings).Methods("PUT")
+	router.HandleFunc("/accounts/{id}/preferences", getPreferences).Methods("GET")
+	router.HandleFunc("/accounts/{id}/preferences", putPreferences).Methods("PUT")
+	router.HandleFunc("/accounts/{id}/preferences", deletePreferences).Methods("DELETE")
+	router.HandleFunc("/accounts/{id}/receipts", listAccountReceipts).Methods("GET")
+	router.HandleFunc("/accounts/{id}/statement", getStatement).Methods("GET")
+	router.HandleFunc("/accounts/{id}/streak", getStreak).Methods("GET")
+	router.HandleFunc("/accounts/{id}/transfer", transferPoints).Methods("POST")
+	router.HandleFunc("/erasures/{id}", getErasure).Methods("GET")
+	router.HandleFunc("/erasures/{id}", cancelErasure).Methods("DELETE")
+	router.HandleFunc("/admin/accounts/{id}/merge", mergeAccounts).Methods("POST")
+	registerUI(router)
+	router.HandleFunc("/admin/backup", backupHandler).Methods("POST")
+	router.HandleFunc("/admin/compact", compactHandler).Methods("POST")
+	router.HandleFunc("/admin/aggregates/rebuild", rebuildAggregatesHandler).Methods("POST")
+	router.HandleFunc("/admin/store/stats", storeStats).Methods("GET")
+	router.HandleFunc("/admin/sharding/rebalance", rebalanceShard).Methods("POST")
+	router.HandleFunc("/admin/sharding/records", receiveRecord).Methods("POST")
+	router.HandleFunc("/admin/restore", restoreHandler).Methods("POST")
+	router.HandleFunc("/admin/retention", retentionStatus).Methods("GET")
+	router.HandleFunc("/admin/keys", createAPIKey).Methods("POST")
+	router.HandleFunc("/admin/keys", listAPIKeys).Methods("GET")
+	router.HandleFunc("/admin/keys/{key}", getAPIKey).Methods("GET")
+	router.HandleFunc("/admin/keys/{key}", revokeAPIKey).Methods("DELETE")
+	router.HandleFunc("/admin/keys/{key}/rotate", rotateAPIKey).Methods("POST")
+	router.HandleFunc("/admin/keys/{key}/usage", getKeyUsage).Methods("GET")
+	router.HandleFunc("/admin/audits", listAudits).Methods("GET")
+	router.HandleFunc("/admin/audits/{id}", getReceiptAudit).Methods("GET")
+	router.HandleFunc("/admin/fraud/flags", listFraudFlags).Methods("GET")
+	router.HandleFunc("/admin/fraud/correlations/{account}", getAccountCorrelations).Methods("GET")
+	router.HandleFunc("/admin/audits/{id}", reviewAudit).Methods("POST")
+	router.HandleFunc("/admin/tap", tapHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/admin/retention/run", runRetention).Methods("POST")
+	router.HandleFunc("/admin/rules/simulate", simulateRules).Methods("POST")
+	router.HandleFunc("/admin/recalculation", startRecalculation).Methods("POST")
+	router.HandleFunc("/admin/recalculation", getRecalculation).Methods("GET")
+	router.HandleFunc("/admin/recalculation", cancelRecalculation).Methods("DELETE")
+	router.HandleFunc("/admin/config/reload", reloadConfigHandler).Methods("POST")
+	router.HandleFunc("/admin/loglevel", logLevelHandler).Methods("GET", "PUT")
 
 	return router
 }
 
 func processReceipt(w http.ResponseWriter, r *http.Request) {
-	var receipt Receipt
-	err := json.NewDecoder(r.Body).Decode(&receipt)
+	processReceiptFor(w, r, r.Header.Get("X-Account-ID"))
+}
+
+// processReceiptFor is processReceipt with the account decided by the caller. It reports whether the receipt was
+// stored.
+func processReceiptFor(w http.ResponseWriter, r *http.Request, accountID string) bool {
+	start := time.Now()
+	receipt, err := decodeReceipt(w, r)
+	timeStage(r.Context(), stageDecode, start, err)
+	result := "invalid"
+	defer func() { ingested.count(r.Context(), receiptFormat(r), receipt.Retailer, result) }()
 
+	locale := preferences.get(accountID).Locale
+	if errors.Is(err, errUnknownTemplate) {
+		localizedError(w, locale, http.StatusBadRequest, "No receipt template found for the template of the receipt.")
+		return false
+	}
+	if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		localizedError(w, locale, http.StatusRequestEntityTooLarge, "The receipt is larger than %d bytes.", tooLarge.Limit)
+		return false
+	}
 	if err != nil {
-		logger.Debug("Failed to decode receipt", zap.Error(err))
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
-		return
+		loggerFor(r.Context()).Debug("Failed to decode receipt", zap.Error(err))
+		localizedError(w, locale, http.StatusBadRequest, "The receipt is invalid.")
+		return false
 	}
-	logger.Debug("Received receipt", zap.Any("receipt", receipt))
+	loggerFor(r.Context()).Debug("Received receipt", zap.Any("receipt", receipt))
 
-	receiptID := uuid.New().String()
-	logger.Debug("Generated UUID", zap.String("receiptID", receiptID))
-
-	// very unlikely, but just in case.
-	if _, ok := receiptStore.Load(receiptID); ok {
-		logger.Error("Duplicate UUID generated", zap.String("receiptID", receiptID))
+	sub, err := submitReceipt(r.Context(), receipt, accountID)
+	result = ingestOutcome(err)
+	if errors.Is(err, ledger.ErrInvalidAccount) {
+		localizedError(w, locale, http.StatusBadRequest, "The account ID is invalid.")
+		return false
+	}
+	if errors.Is(err, errDailyLimitReached) {
+		localizedError(w, locale, http.StatusTooManyRequests, "The account has reached its daily receipt limit.")
+		return false
+	}
+	if quotaErr := (retailerQuotaError{}); errors.As(err, &quotaErr) {
+		localizedError(w, locale, http.StatusTooManyRequests, "The account has reached its daily limit of %d receipts from %s.", quotaErr.quota, quotaErr.retailer)
+		return false
+	}
+	if dupErr := (duplicateTransactionError{}); errors.As(err, &dupErr) {
+		localizedError(w, locale, http.StatusConflict, "A receipt with this transaction number was already submitted as %s.", dupErr.existing)
+		return false
+	}
+	if errors.Is(err, errReceiptBlocked) {
+		localizedError(w, locale, http.StatusUnprocessableEntity, "The receipt was rejected.")
+		return false
+	}
+	if errors.Is(err, errStoreWrite) && spool != nil {
+		if sub, err = spool.add(receipt, accountID); err == nil {
+			result = "spooled"
+			loggerFor(r.Context()).Warn("Spooled receipt, the store failed to write it", zap.String("receiptID", sub.ID))
+			writeFastJSON(w, http.StatusAccepted, func(b []byte) []byte { return appendProcessResponse(b, sub) })
+			return true
+		}
+		logger.Error("Failed to spool receipt", zap.Error(err))
+	}
+	if err != nil {
 		http.Error(w, "", http.StatusInternalServerError)
-		return
+		return false
 	}
 
-	points := receipt.CalculatePoints()
-	receiptStore.Store(receiptID, int64(points))
-	logger.Debug("Stored receipt points", zap.String("receiptID", receiptID), zap.Int("points", points))
+	writeFastJSON(w, http.StatusOK, func(b []byte) []byte { return appendProcessResponse(b, sub) })
+	return true
+}
+
+// scoreResponse explains a score rule by rule.
+type scoreResponse struct {
+	Points    int          `json:"points"`
+	Breakdown []RuleResult `json:"breakdown"`
+	Throttled bool         `json:"throttled,omitempty"`
+	Warnings  []Warning    `json:"warnings,omitempty"`
+}
 
-	jsonResponse, err := json.Marshal(map[string]string{"id": receiptID})
+// scoreReceipt is a dry run of processReceipt: nothing is stored, and unlike processReceipt it reports what is wrong
+// with an invalid receipt, so partners can check their payloads while integrating.
+func scoreReceipt(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	var response any
+
+	var receipt Receipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		var errs validation.Errors
+		if !errors.As(err, &errs) {
+			errs = validation.Errors{"body": err}
+		}
+		status = http.StatusBadRequest
+		response = map[string]validation.Errors{"errors": errs}
+	} else {
+		start := time.Now()
+		breakdown := receipt.Breakdown()
+		// a dry run, only debugged requests report it.
+		debugFrom(r.Context()).stage(stageScore, time.Since(start), nil)
+		debugFrom(r.Context()).scored(breakdown)
+		score := scoreResponse{Points: totalPoints(breakdown), Breakdown: breakdown, Warnings: receipt.warnings}
+		// with an account the score is what submitting the receipt right now would earn.
+		if account := r.Header.Get("X-Account-ID"); account != "" {
+			if c := currentConfig().Throttle; c.DailyReceiptsPerAccount > 0 && receiptCounter.reached(pointsLedger.Resolve(account), c.DailyReceiptsPerAccount, accountNow(account)) {
+				score.Breakdown = append(score.Breakdown, dailyCapRule(c.DailyReceiptsPerAccount, score.Points))
+				score.Points = 0
+				score.Throttled = true
+			}
+		}
+		response = score
+	}
+
+	jsonResponse, err := json.Marshal(response)
 	if err != nil {
 		logger.Error("Failed to marshal response", zap.Error(err))
 		http.Error(w, "", http.StatusInternalServerError)
@@ -80,7 +321,7 @@ func processReceipt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	w.Write(jsonResponse)
 }
 
@@ -89,13 +330,74 @@ func getPoints(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	logger.Debug("Getting points for receipt", zap.String("receiptID", id))
 
-	points, ok := receiptStore.Load(id)
-	if !ok {
+	rec, err := receiptStore.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		setPointsCacheControl(w, id, false)
 		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	setPointsCacheControl(w, id, true)
+	writeFastJSON(w, http.StatusOK, func(b []byte) []byte { return appendPointsResponse(b, rec.Points) })
+}
+
+// maxListLimit keeps a single page cheap for every backend.
+const maxListLimit = 500
+
+func listReceipts(w http.ResponseWriter, r *http.Request) {
+	opts, err := listSort(r)
+	if err != nil {
+		http.Error(w, "The sort is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+	limit := store.DefaultListLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "The limit must be between 1 and "+strconv.Itoa(maxListLimit)+".", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	fields, err := receiptFieldSet(r)
+	if err != nil {
+		http.Error(w, "The fields are invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	// one more than the page tells whether there is a next one.
+	opts.Limit = limit + 1
+	var records []store.Record
+	if number := r.URL.Query().Get("transactionNumber"); number != "" {
+		records, err = receiptsWithTransaction(r.Context(), number, opts)
+	} else {
+		records, err = receiptStore.List(r.Context(), opts)
+	}
+	if err != nil {
+		logger.Error("Failed to list receipts", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	response := map[string]any{}
+	if len(records) > limit {
+		records = records[:limit]
+		response["nextCursor"] = encodeCursor(opts, records[limit-1])
+	}
+	if records == nil {
+		records = []store.Record{}
+	}
+	response["receipts"], err = projectRecords(records, fields)
+	if err != nil {
+		logger.Error("Failed to select fields", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
 
-	response := map[string]int64{"points": points.(int64)}
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -106,3 +408,45 @@ func getPoints(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResponse)
 }
+
+// listSort reads the sort, order and cursor parameters of the list endpoints: ?sort=points&order=asc. Cursors hold the
+// sort value and ID of the last receipt of a page, so receipts submitted while paging don't shift later pages, and
+// the sort they were made for: a cursor of another sort is invalid.
+func listSort(r *http.Request) (store.ListOptions, error) {
+	q := r.URL.Query()
+	field, err := store.ParseSortField(q.Get("sort"))
+	if err != nil {
+		return store.ListOptions{}, fmt.Errorf("the sort must be one of %s", joinNames(store.SortFields))
+	}
+	opts := store.ListOptions{Sort: field}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return store.ListOptions{}, errors.New("the order must be asc or desc")
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		b, err := base64.RawURLEncoding.DecodeString(c)
+		sort, value, _ := strings.Cut(string(b), ":")
+		cursor, parseErr := store.ParseCursor(value)
+		if err != nil || parseErr != nil || sort != sortName(opts) {
+			return store.ListOptions{}, errors.New("the cursor is not one of this sort")
+		}
+		opts.After = &cursor
+	}
+	return opts, nil
+}
+
+// encodeCursor is the cursor of the page after rec.
+func encodeCursor(opts store.ListOptions, rec store.Record) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortName(opts) + ":" + opts.CursorAfter(rec).String()))
+}
+
+func sortName(opts store.ListOptions) string {
+	if opts.Ascending {
+		return string(opts.Sort) + ".asc"
+	}
+	return string(opts.Sort) + ".desc"
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+
+	"github.com/MDanialSaleem/fcpc/store/storetest"
 )
 
 func TestFullCycle(t *testing.T) {
@@ -60,12 +64,14 @@ func TestFullCycle(t *testing.T) {
 				return
 			}
 
-			var resp map[string]string
+			var resp struct {
+				ID string `json:"id"`
+			}
 			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
 				t.Fatalf("Failed to parse response: %v", err)
 			}
 
-			pointsReq := httptest.NewRequest("GET", "/receipts/"+resp["id"]+"/points", nil)
+			pointsReq := httptest.NewRequest("GET", "/receipts/"+resp.ID+"/points", nil)
 			pointsRR := httptest.NewRecorder()
 
 			router.ServeHTTP(pointsRR, pointsReq)
@@ -157,7 +163,7 @@ func TestInvalidReceipt(t *testing.T) {
 func TestNonExistentReceipt(t *testing.T) {
 	router := setup()
 
-	req := httptest.NewRequest("GET", "/receipts/whatever/points", nil)
+	req := httptest.NewRequest("GET", "/receipts/"+unknownReceiptID+"/points", nil)
 	rr := httptest.NewRecorder()
 
 	router.ServeHTTP(rr, req)
@@ -171,3 +177,224 @@ func TestNonExistentReceipt(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v expected %v", rr.Body.String(), expectedResponse)
 	}
 }
+
+func TestListReceipts(t *testing.T) {
+	router := setup()
+
+	var ids []string
+	for _, retailer := range []string{"Target", "Walgreens", "Costco"} {
+		body := `{"retailer": "` + retailer + `", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+			"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body)))
+		var resp map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		ids = append(ids, resp["id"])
+	}
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{name: "default limit", query: "", wantStatus: http.StatusOK, wantIDs: []string{ids[2], ids[1], ids[0]}},
+		{name: "limit", query: "?limit=2", wantStatus: http.StatusOK, wantIDs: []string{ids[2], ids[1]}},
+		{name: "zero limit", query: "?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "limit too large", query: "?limit=501", wantStatus: http.StatusBadRequest},
+		{name: "not a number", query: "?limit=ten", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Receipts []struct {
+					ID string `json:"id"`
+				} `json:"receipts"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			var got []string
+			for _, r := range resp.Receipts {
+				got = append(got, r.ID)
+			}
+			if !reflect.DeepEqual(got, tc.wantIDs) {
+				t.Errorf("got ids %v want %v", got, tc.wantIDs)
+			}
+		})
+	}
+}
+
+func TestListReceiptsSorted(t *testing.T) {
+	router := setup()
+	submit := func(total string) string {
+		body := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+			"items": [{"shortDescription": "Mountain Dew 12PK", "price": "` + total + `"}], "total": "` + total + `"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body)))
+		var resp map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp["id"]
+	}
+	ids := []string{submit("6.49"), submit("12.25"), submit("1.26")}
+
+	testCases := []struct {
+		name    string
+		query   string
+		wantIDs []string
+	}{
+		{name: "total descending", query: "?sort=total", wantIDs: []string{ids[1], ids[0], ids[2]}},
+		{name: "total ascending", query: "?sort=total&order=asc", wantIDs: []string{ids[2], ids[0], ids[1]}},
+		{name: "processed ascending", query: "?order=asc", wantIDs: []string{ids[0], ids[1], ids[2]}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			cursor := ""
+			for page := 0; ; page++ {
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts"+tc.query+"&limit=2&cursor="+cursor, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+				}
+				var resp struct {
+					Receipts []struct {
+						ID string `json:"id"`
+					} `json:"receipts"`
+					NextCursor string `json:"nextCursor"`
+				}
+				json.Unmarshal(rr.Body.Bytes(), &resp)
+				for _, r := range resp.Receipts {
+					got = append(got, r.ID)
+				}
+				if page == 0 {
+					// lands in front of the first page or after the last one, either way not on the second page.
+					defer receiptStore.Delete(t.Context(), submit("99.00"))
+					defer receiptStore.Delete(t.Context(), submit("0.01"))
+				}
+				if resp.NextCursor == "" {
+					break
+				}
+				cursor = resp.NextCursor
+			}
+			if got := got[:min(len(got), 3)]; !reflect.DeepEqual(got, tc.wantIDs) {
+				t.Errorf("paged through %v, want %v", got, tc.wantIDs)
+			}
+		})
+	}
+}
+
+func TestListReceiptsSortErrors(t *testing.T) {
+	router := setup()
+	otherSort := base64.RawURLEncoding.EncodeToString([]byte("points.desc:28:a"))
+	testCases := []struct {
+		name  string
+		query string
+	}{
+		{name: "unknown sort", query: "?sort=retailer"},
+		{name: "unknown order", query: "?order=up"},
+		{name: "garbage cursor", query: "?cursor=!!"},
+		{name: "cursor of another sort", query: "?sort=total&cursor=" + otherSort},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts"+tc.query, nil))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestUI(t *testing.T) {
+	router := setup()
+
+	testCases := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/ui", wantStatus: http.StatusMovedPermanently},
+		{path: "/ui/", wantStatus: http.StatusOK},
+		{path: "/ui/style.css", wantStatus: http.StatusOK},
+		{path: "/ui/playground/", wantStatus: http.StatusOK},
+		{path: "/ui/nope.js", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestScoreReceipt(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantPoints int
+	}{
+		{
+			name: "valid",
+			body: `{"retailer": "M&M Corner Market", "purchaseDate": "2022-03-20", "purchaseTime": "14:33",
+				"items": [{"shortDescription": "Gatorade", "price": "2.25"}, {"shortDescription": "Gatorade", "price": "2.25"},
+					{"shortDescription": "Gatorade", "price": "2.25"}, {"shortDescription": "Gatorade", "price": "2.25"}],
+				"total": "9.00"}`,
+			wantStatus: http.StatusOK,
+			wantPoints: 109,
+		},
+		{name: "invalid", body: `{"retailer": "Target"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			fake := storetest.NewFake()
+			receiptStore = fake
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/score", bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if fake.Calls["Put"] != 0 {
+				t.Errorf("dry run stored the receipt")
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp scoreResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			sum := 0
+			for _, r := range resp.Breakdown {
+				sum += r.Points
+			}
+			if resp.Points != tc.wantPoints || sum != tc.wantPoints {
+				t.Errorf("got points %v (breakdown sums to %v) want %v", resp.Points, sum, tc.wantPoints)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// metadataKey is what metadata keys look like. Keys can't have "=" or ".", so rules can address a key and value as
+// key=value and errors can nest under the key.
+var metadataKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,39}$`)
+
+// validateMetadata checks a receipt's metadata against the limits: at most c.maxMetadataKeys() keys, each a
+// metadataKey, with values of at most c.maxMetadataValueLength() characters and no control characters.
+func validateMetadata(c ReceiptLimitsConfig) validation.RuleFunc {
+	return func(value any) error {
+		metadata, _ := value.(map[string]string)
+		if len(metadata) > c.maxMetadataKeys() {
+			return validation.NewError("validation_metadata_limit", fmt.Sprintf("must have at most %d keys", c.maxMetadataKeys()))
+		}
+		errs := validation.Errors{}
+		for key, value := range metadata {
+			switch {
+			case !metadataKey.MatchString(key):
+				errs[key] = validation.NewError("validation_metadata_key", "want a key of at most 40 letters, digits, underscores and hyphens, starting with a letter")
+			case utf8.RuneCountInString(value) > c.maxMetadataValueLength():
+				errs[key] = validation.NewError("validation_metadata_value_limit", fmt.Sprintf("must be at most %d characters", c.maxMetadataValueLength()))
+			case strings.ContainsFunc(value, unicode.IsControl):
+				errs[key] = validation.NewError("validation_metadata_value", "must not contain control characters")
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+}
+
+// metadataMatches returns the key=value pairs of the receipt's metadata that settings.Points awards points for, sorted.
+func (r *Receipt) metadataMatches(settings RuleConfig) []string {
+	var matches []string
+	for key, value := range r.Metadata {
+		if _, ok := settings.Points[key+"="+value]; ok {
+			matches = append(matches, key+"="+value)
+		}
+	}
+	slices.Sort(matches)
+	return matches
+}
+
+// calculateMetadataPoints is what the metadata rule awards: the sum of what settings.Points has for every key=value
+// of the receipt's metadata, nothing for receipts without any.
+func (r *Receipt) calculateMetadataPoints(settings RuleConfig) int {
+	points := 0
+	for _, match := range r.metadataMatches(settings) {
+		points += settings.Points[match]
+	}
+	return points
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestMetadataValidation(t *testing.T) {
+	setup()
+	live := *currentConfig()
+	live.ReceiptLimits = ReceiptLimitsConfig{MaxMetadataKeys: 2, MaxMetadataValueLength: 8}
+	liveConfig.Store(&live)
+
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		wantErr string
+	}{
+		{name: "none", receipt: receipttest.New().Build()},
+		{name: "valid", receipt: receipttest.New().Metadata("channel", "app").Metadata("order_id", "A-1 2").Build()},
+		{name: "too many keys", receipt: receipttest.New().Metadata("a", "1").Metadata("b", "2").Metadata("c", "3").Build(), wantErr: "metadata: must have at most 2 keys"},
+		{name: "value too long", receipt: receipttest.New().Metadata("channel", "partner-app").Build(), wantErr: "channel: must be at most 8 characters"},
+		{name: "key with =", receipt: receipttest.New().Metadata("a=b", "1").Build(), wantErr: "a=b: want a key"},
+		{name: "key starting with a digit", receipt: receipttest.New().Metadata("1st", "1").Build(), wantErr: "1st: want a key"},
+		{name: "control characters", receipt: receipttest.New().Metadata("note", "a\nb").Build(), wantErr: "note: must not contain control characters"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			err := json.Unmarshal(tc.receipt.JSON(), &receipt)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("Unmarshal() error = %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("Unmarshal() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataRule(t *testing.T) {
+	setup()
+	rules := RulesConfig{"metadata": {Points: map[string]int{"channel=app": 25, "tier=gold": 10}}}
+
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		want    int
+	}{
+		{name: "one match", receipt: receipttest.New().Metadata("channel", "app").Build(), want: 25},
+		{name: "two matches", receipt: receipttest.New().Metadata("channel", "app").Metadata("tier", "gold").Build(), want: 35},
+		{name: "other value", receipt: receipttest.New().Metadata("channel", "web").Build(), want: 0},
+		{name: "no metadata", receipt: receipttest.New().Build(), want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			if err := json.Unmarshal(tc.receipt.JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			for _, result := range receipt.Score(rules) {
+				if result.Rule == "metadata" && result.Points != tc.want {
+					t.Errorf("metadata awarded %d points, want %d", result.Points, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRulesConfigMetadataPoints(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rules   RulesConfig
+		wantErr bool
+	}{
+		{name: "valid", rules: RulesConfig{"metadata": {Points: map[string]int{"channel=app": 3, "campaign=": 1}}}},
+		{name: "no value", rules: RulesConfig{"metadata": {Points: map[string]int{"channel": 3}}}, wantErr: true},
+		{name: "invalid key", rules: RulesConfig{"metadata": {Points: map[string]int{"sales.channel=app": 3}}}, wantErr: true},
+		{name: "negative", rules: RulesConfig{"metadata": {Points: map[string]int{"channel=app": -1}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rules.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataStoredVerbatim(t *testing.T) {
+	router := setup()
+	live := *currentConfig()
+	live.Rules = RulesConfig{"metadata": {Points: map[string]int{"channel=app": 25}}}
+	liveConfig.Store(&live)
+	want := map[string]string{"channel": "app", "correlationId": "  Ab-12 / x  "}
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "json", contentType: "application/json", body: string(receipttest.New().Metadata("channel", "app").Metadata("correlationId", "  Ab-12 / x  ").Build().JSON())},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: url.Values{
+			"retailer": {"Target"}, "purchaseDate": {"2022-01-01"}, "purchaseTime": {"13:01"}, "total": {"6.49"},
+			"items[0].shortDescription": {"Mountain Dew 12PK"}, "items[0].price": {"6.49"},
+			"metadata.channel": {"app"}, "metadata.correlationId": {"  Ab-12 / x  "},
+		}.Encode()},
+		{name: "xml", contentType: "application/xml", body: `<receipt><retailer>Target</retailer><purchaseDate>2022-01-01</purchaseDate><purchaseTime>13:01</purchaseTime>
+			<items><item><shortDescription>Mountain Dew 12PK</shortDescription><price>6.49</price></item></items><total>6.49</total>
+			<metadata><entry key="channel">app</entry><entry key="correlationId">  Ab-12 / x  </entry></metadata></receipt>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("POST /receipts/process = %v %s, want 200", rr.Code, rr.Body)
+			}
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+
+			receipt, _, err := loadReceipt(req.Context(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(receipt.Metadata, want) {
+				t.Errorf("stored metadata = %q, want %q", receipt.Metadata, want)
+			}
+			if got := explain(&receipt); len(got) == 0 || got[len(got)-1] != "25 points because its metadata has channel=app." {
+				t.Errorf("explain() = %q, want the metadata rule last", got)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts?fields=receipt.metadata", nil))
+	var list struct {
+		Receipts []struct {
+			Receipt struct {
+				Metadata map[string]string `json:"metadata"`
+			} `json:"receipt"`
+		} `json:"receipts"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Receipts) != len(testCases) || !reflect.DeepEqual(list.Receipts[0].Receipt.Metadata, want) {
+		t.Errorf("GET /receipts?fields=receipt.metadata = %s, want the metadata of every receipt", rr.Body)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// a dedicated registry rather than the global one, so setup() can be called repeatedly in tests and only the metrics
+// we define (plus the standard go/process ones) end up on /metrics.
+var metricsRegistry = prometheus.NewRegistry()
+var metrics = promauto.With(metricsRegistry)
+
+func init() {
+	metricsRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+var (
+	connectorRunsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_connector_runs_total",
+		Help: "Connector pull runs, by connector and result (success or failure).",
+	}, []string{"connector", "result"})
+
+	connectorFilesTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_connector_files_total",
+		Help: "Receipt files pulled by connectors, by connector and result (processed or failed).",
+	}, []string{"connector", "result"})
+
+	connectorLastSuccess = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fcpc_connector_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run per connector. Alert when this falls too far behind.",
+	}, []string{"connector"})
+)
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
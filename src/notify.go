@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// NotificationConfig turns on notifications to customers for the events in Templates. It is disabled when Type is
+// empty. Accounts only get notifications once they've set an email address through /accounts/{id}/notifications.
+type NotificationConfig struct {
+	Type string     `json:"type"` // "smtp" or "log"
+	SMTP SMTPConfig `json:"smtp"`
+	// Templates override the default subject and body per event type, as text/template with the event's fields and
+	// the account's Balance.
+	Templates map[string]NotificationTemplate `json:"templates"`
+	// ExpiryCheckInterval is how often accounts are checked for points about to expire (see StatementConfig), default
+	// 24h. Each account is warned at most once a month.
+	ExpiryCheckInterval Duration `json:"expiryCheckInterval"`
+}
+
+// SMTPConfig is the mail server notifications are sent through. STARTTLS is used when the server offers it.
+type SMTPConfig struct {
+	Address  string `json:"address"` // host:port
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+type NotificationTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+var defaultNotificationTemplates = map[string]NotificationTemplate{
+	EventPointsEarned: {
+		Subject: "You earned {{.Points}} points",
+		Body:    "Your receipt {{.ReceiptID}} earned {{.Points}} points. Your balance is now {{.Balance}} points.\n",
+	},
+	EventPointsExpiring: {
+		Subject: "{{.Points}} of your points expire soon",
+		Body:    "{{.Points}} of your points expire at the end of next month. Your balance is {{.Balance}} points.\n",
+	},
+}
+
+func (c NotificationConfig) Validate() error {
+	if _, ok := notifiers[c.Type]; !ok {
+		return fmt.Errorf("notifications: unknown type %q", c.Type)
+	}
+	if c.Type == "smtp" && (c.SMTP.Address == "" || c.SMTP.From == "") {
+		return fmt.Errorf("notifications: smtp.address and smtp.from are required")
+	}
+	_, err := c.templates()
+	return err
+}
+
+type parsedTemplate struct {
+	subject, body *template.Template
+}
+
+// templates parses the defaults with the configured overrides applied.
+func (c NotificationConfig) templates() (map[string]parsedTemplate, error) {
+	parsed := map[string]parsedTemplate{}
+	for eventType, t := range defaultNotificationTemplates {
+		if override, ok := c.Templates[eventType]; ok {
+			t = override
+		}
+		subject, err := template.New(eventType).Option("missingkey=error").Parse(t.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("notifications: template %v: %w", eventType, err)
+		}
+		body, err := template.New(eventType).Option("missingkey=error").Parse(t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("notifications: template %v: %w", eventType, err)
+		}
+		parsed[eventType] = parsedTemplate{subject: subject, body: body}
+	}
+	for eventType := range c.Templates {
+		if _, ok := defaultNotificationTemplates[eventType]; !ok {
+			return nil, fmt.Errorf("notifications: no event %q to template", eventType)
+		}
+	}
+	return parsed, nil
+}
+
+type Notification struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers a notification. Implementations are only called from one goroutine at a time.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+var notifiers = map[string]func(NotificationConfig) Notifier{
+	"smtp": func(c NotificationConfig) Notifier { return smtpNotifier{c.SMTP} },
+	"log":  func(NotificationConfig) Notifier { return logNotifier{} },
+}
+
+// logNotifier only logs, for trying out templates and for environments without a mail server.
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, n Notification) error {
+	logger.Info("Notification", zap.String("to", n.To), zap.String("subject", n.Subject), zap.String("body", n.Body))
+	return nil
+}
+
+type smtpNotifier struct {
+	c SMTPConfig
+}
+
+func (s smtpNotifier) Notify(ctx context.Context, n Notification) error {
+	host, _, err := net.SplitHostPort(s.c.Address)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.c.Address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.c.Username, s.c.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.c.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(n.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(s.c.From, n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildMail(from string, n Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", n.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(n.Body, "\n", "\r\n")))
+	qp.Close()
+	return b.Bytes()
+}
+
+// NotificationSettings are an account's notification preferences.
+type NotificationSettings struct {
+	Email  string `json:"email"`
+	OptOut bool   `json:"optOut"`
+}
+
+type settingsStore struct {
+	mu       sync.Mutex
+	accounts map[string]NotificationSettings
+}
+
+var notificationSettings *settingsStore
+
+func (s *settingsStore) get(account string) NotificationSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accounts[account]
+}
+
+func (s *settingsStore) delete(account string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accounts, account)
+}
+
+func (s *settingsStore) set(account string, settings NotificationSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[account] = settings
+}
+
+var notificationsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_notifications_total",
+	Help: "Notifications by event type and result (sent, failed or dropped when the queue was full).",
+}, []string{"event", "result"})
+
+// notificationQueueSize bounds the events waiting for a slow mail server. Beyond that they are dropped rather than
+// holding up receipt submissions.
+const notificationQueueSize = 1000
+
+// startNotifications subscribes to the events that have templates and delivers them in the background.
+func startNotifications(ctx context.Context, c NotificationConfig) error {
+	templates, err := c.templates()
+	if err != nil {
+		return err
+	}
+	notifier := notifiers[c.Type](c)
+
+	queue := make(chan Event, notificationQueueSize)
+	for eventType := range templates {
+		events.Subscribe(eventType, func(e Event) {
+			select {
+			case queue <- e:
+			default:
+				notificationsTotal.WithLabelValues(e.Type, "dropped").Inc()
+				logger.Warn("Notification queue is full, dropping notification", zap.String("event", e.Type), zap.String("account", e.Account))
+			}
+		})
+	}
+
+	logger.Info("Sending notifications", zap.String("type", c.Type))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-queue:
+				notify(ctx, notifier, templates[e.Type], e)
+			}
+		}
+	}()
+	return nil
+}
+
+func notify(ctx context.Context, notifier Notifier, t parsedTemplate, e Event) {
+	settings := notificationSettings.get(e.Account)
+	if settings.Email == "" || settings.OptOut {
+		return
+	}
+	if optIn, ok := preferences.get(e.Account).Notifications[e.Type]; ok && !optIn {
+		return
+	}
+
+	// a balance we can't read only leaves it out of the message.
+	balance, _ := pointsLedger.Balance(e.Account)
+	data := struct {
+		Event
+		Balance int64
+	}{e, balance}
+	var subject, body strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		logger.Error("Failed to render notification", zap.String("event", e.Type), zap.Error(err))
+		return
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		logger.Error("Failed to render notification", zap.String("event", e.Type), zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	n := Notification{To: settings.Email, Subject: strings.Join(strings.Fields(subject.String()), " "), Body: body.String()}
+	if err := notifier.Notify(ctx, n); err != nil {
+		notificationsTotal.WithLabelValues(e.Type, "failed").Inc()
+		logger.Error("Failed to send notification", zap.String("event", e.Type), zap.String("account", e.Account), zap.Error(err))
+		return
+	}
+	notificationsTotal.WithLabelValues(e.Type, "sent").Inc()
+}
+
+// warnExpiringPoints publishes EventPointsExpiring for accounts with points expiring at the end of next month, once
+// per account and month. warned remembers who was warned when.
+func warnExpiringPoints(ctx context.Context, c StatementConfig, warned map[string]string) {
+	for _, account := range pointsLedger.Accounts() {
+		now := accountNow(account)
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		label := month.Format("2006-01")
+		if warned[account] == label {
+			continue
+		}
+		s, err := buildStatement(ctx, account, month, pointsLedger.Entries(account), c)
+		if err != nil {
+			logger.Error("Failed to check for expiring points", zap.String("account", account), zap.Error(err))
+			continue
+		}
+		if s.ExpiringSoon > 0 {
+			events.Publish(Event{Type: EventPointsExpiring, Account: account, Points: s.ExpiringSoon})
+			warned[account] = label
+		}
+	}
+}
+
+func startExpiryWarnings(ctx context.Context, c NotificationConfig) {
+	interval := time.Duration(c.ExpiryCheckInterval)
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	warned := map[string]string{}
+	go runPeriodically(ctx, interval, func(ctx context.Context) {
+		if c := currentConfig().Statements; c.PointsExpireAfterMonths > 0 {
+			warnExpiringPoints(ctx, c, warned)
+		}
+	})
+}
+
+func getNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := ledger.ValidateAccount(id); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+	writeNotificationSettings(w, notificationSettings.get(id))
+}
+
+// putNotificationSettings replaces the account's settings. An empty email stops all notifications too.
+func putNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := ledger.ValidateAccount(id); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	var settings NotificationSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "The notification settings are invalid.", http.StatusBadRequest)
+		return
+	}
+	if settings.Email != "" {
+		addr, err := mail.ParseAddress(settings.Email)
+		if err != nil || addr.Address != settings.Email {
+			http.Error(w, "The email must be a plain address like name@example.com.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	notificationSettings.set(id, settings)
+	writeNotificationSettings(w, settings)
+}
+
+func writeNotificationSettings(w http.ResponseWriter, settings NotificationSettings) {
+	jsonResponse, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, msg Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func (n *recordingNotifier) Sent() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Notification(nil), n.sent...)
+}
+
+func TestNotificationConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  NotificationConfig
+		wantErr bool
+	}{
+		{name: "log", config: NotificationConfig{Type: "log"}, wantErr: false},
+		{name: "smtp", config: NotificationConfig{Type: "smtp", SMTP: SMTPConfig{Address: "mail:25", From: "points@example.com"}}, wantErr: false},
+		{name: "smtp without server", config: NotificationConfig{Type: "smtp"}, wantErr: true},
+		{name: "unknown type", config: NotificationConfig{Type: "pigeon"}, wantErr: true},
+		{name: "template override", config: NotificationConfig{Type: "log", Templates: map[string]NotificationTemplate{EventPointsEarned: {Subject: "+{{.Points}}"}}}, wantErr: false},
+		{name: "unknown event", config: NotificationConfig{Type: "log", Templates: map[string]NotificationTemplate{"points.lost": {}}}, wantErr: true},
+		{name: "broken template", config: NotificationConfig{Type: "log", Templates: map[string]NotificationTemplate{EventPointsEarned: {Body: "{{.Points"}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings NotificationSettings
+		wantSent bool
+	}{
+		{name: "subscribed", settings: NotificationSettings{Email: "alice@example.com"}, wantSent: true},
+		{name: "opted out", settings: NotificationSettings{Email: "alice@example.com", OptOut: true}, wantSent: false},
+		{name: "no email", settings: NotificationSettings{}, wantSent: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setup()
+			pointsLedger.Accrue("alice", "r1", 40)
+			notificationSettings.set("alice", tc.settings)
+			templates, _ := NotificationConfig{}.templates()
+
+			notifier := &recordingNotifier{}
+			notify(t.Context(), notifier, templates[EventPointsEarned], Event{Type: EventPointsEarned, Account: "alice", ReceiptID: "r1", Points: 40})
+
+			sent := notifier.Sent()
+			if !tc.wantSent {
+				if len(sent) != 0 {
+					t.Errorf("sent %+v, want nothing", sent)
+				}
+				return
+			}
+			want := Notification{To: "alice@example.com", Subject: "You earned 40 points", Body: "Your receipt r1 earned 40 points. Your balance is now 40 points.\n"}
+			if len(sent) != 1 || sent[0] != want {
+				t.Errorf("sent %+v, want %+v", sent, want)
+			}
+		})
+	}
+}
+
+func TestPointsEarnedNotification(t *testing.T) {
+	router := setup()
+	notifier := &recordingNotifier{}
+	notifiers["recording"] = func(NotificationConfig) Notifier { return notifier }
+	defer delete(notifiers, "recording")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := startNotifications(ctx, NotificationConfig{Type: "recording"}); err != nil {
+		t.Fatal(err)
+	}
+	notificationSettings.set("alice", NotificationSettings{Email: "alice@example.com"})
+	notificationSettings.set("bob", NotificationSettings{Email: "bob@example.com", OptOut: true})
+	// bob first: once alice's notification is out, the worker is done with both.
+	submitForAccount(t, router, "bob", receipttest.New().Build())
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+
+	deadline := time.Now().Add(time.Second)
+	for len(notifier.Sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sent := notifier.Sent(); len(sent) != 1 || sent[0].To != "alice@example.com" {
+		t.Errorf("sent %+v, want one notification to alice", sent)
+	}
+}
+
+func TestWarnExpiringPoints(t *testing.T) {
+	setup()
+	pointsLedger.Accrue("alice", "r1", 40)
+	pointsLedger.Accrue("bob", "r2", 10)
+	pointsLedger.Transfer(ledger.TransferRequest{From: "bob", To: "alice", Points: 10})
+
+	var got []Event
+	events.Subscribe(EventPointsExpiring, func(e Event) { got = append(got, e) })
+
+	warned := map[string]string{}
+	// this month's points expire at the end of the next one.
+	warnExpiringPoints(t.Context(), StatementConfig{PointsExpireAfterMonths: 1}, warned)
+	warnExpiringPoints(t.Context(), StatementConfig{PointsExpireAfterMonths: 1}, warned)
+
+	if len(got) != 1 || got[0].Account != "alice" || got[0].Points != 50 {
+		t.Errorf("published %+v, want one warning about alice's 50 points", got)
+	}
+}
+
+func TestNotificationSettings(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "email", body: `{"email": "alice@example.com"}`, wantStatus: http.StatusOK},
+		{name: "opt out", body: `{"email": "alice@example.com", "optOut": true}`, wantStatus: http.StatusOK},
+		{name: "display name", body: `{"email": "Alice <alice@example.com>"}`, wantStatus: http.StatusBadRequest},
+		{name: "not an address", body: `{"email": "alice"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("PUT", "/accounts/alice/notifications", strings.NewReader(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+
+			get := httptest.NewRecorder()
+			router.ServeHTTP(get, httptest.NewRequest("GET", "/accounts/alice/notifications", nil))
+			if tc.wantStatus == http.StatusOK && get.Body.String() != rr.Body.String() {
+				t.Errorf("GET returned %v, want the settings just saved: %v", get.Body, rr.Body)
+			}
+		})
+	}
+}
+
+// fakeSMTPServer accepts one message and sends it back on the returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ready")
+		var data bytes.Buffer
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	n := smtpNotifier{SMTPConfig{Address: addr, From: "points@example.com"}}
+
+	err := n.Notify(t.Context(), Notification{To: "alice@example.com", Subject: "Punkte für dich", Body: "You earned 40 points.\n"})
+	if err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	msg := <-received
+	for _, want := range []string{"To: alice@example.com\r\n", "Subject: =?utf-8?q?Punkte_f=C3=BCr_dich?=\r\n", "\r\n\r\nYou earned 40 points.\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q doesn't contain %q", msg, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+// paymentMethods are the values of a receipt's optional paymentMethod, in the order stats list them.
+var paymentMethods = []string{"cash", "credit", "debit", "giftCard", "storeCard"}
+
+// paymentMethodNames are how explanations refer to payment methods.
+var paymentMethodNames = map[string]string{
+	"cash":      "cash",
+	"credit":    "a credit card",
+	"debit":     "a debit card",
+	"giftCard":  "a gift card",
+	"storeCard": "a store card",
+}
+
+// calculatePaymentMethodPoints is what the paymentMethod rule awards: whatever settings.Points has for the receipt's
+// payment method, nothing for receipts without one.
+func (r *Receipt) calculatePaymentMethodPoints(settings RuleConfig) int {
+	if r.PaymentMethod == "" {
+		return 0
+	}
+	return settings.Points[r.PaymentMethod]
+}
+
+// PaymentMethodStats sums up the stored receipts paid with a payment method. Total is the sum of the receipt totals.
+type PaymentMethodStats struct {
+	PaymentMethod string `json:"paymentMethod"`
+	Receipts      int    `json:"receipts"`
+	Points        int64  `json:"points"`
+	Total         string `json:"total"`
+}
+
+// paymentMethodStatsResponse is the response of GET /stats/payment-methods. Receipts without a payment method are
+// counted under "unknown".
+type paymentMethodStatsResponse struct {
+	From           string               `json:"from,omitempty"`
+	To             string               `json:"to,omitempty"`
+	PaymentMethods []PaymentMethodStats `json:"paymentMethods"`
+}
+
+const unknownPaymentMethod = "unknown"
+
+// getPaymentMethodStats serves GET /stats/payment-methods?from=&to=, grouping the stored receipts purchased between
+// the two inclusive dates (all of them by default) by payment method.
+func getPaymentMethodStats(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := statsDates(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := groupDaily(r.Context(), store.DailyPaymentMethod, from, to, func(_, method string) string {
+		if method == "" {
+			return unknownPaymentMethod
+		}
+		return method
+	})
+	if err != nil {
+		logger.Error("Failed to read daily aggregates", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	response := paymentMethodStatsResponse{From: from, To: to, PaymentMethods: []PaymentMethodStats{}}
+	for _, method := range append(slices.Clone(paymentMethods), unknownPaymentMethod) {
+		totals := statsTotals{}
+		if groups[method] != nil {
+			totals = *groups[method]
+		}
+		response.PaymentMethods = append(response.PaymentMethods, PaymentMethodStats{PaymentMethod: method, Receipts: totals.receipts, Points: totals.points, Total: totals.total()})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestPaymentMethodValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		wantErr bool
+	}{
+		{name: "none", receipt: receipttest.New().Build()},
+		{name: "cash", receipt: receipttest.New().PaymentMethod("cash").Build()},
+		{name: "store card", receipt: receipttest.New().PaymentMethod("storeCard").Build()},
+		{name: "unknown", receipt: receipttest.New().PaymentMethod("bitcoin").Build(), wantErr: true},
+		{name: "wrong case", receipt: receipttest.New().PaymentMethod("Cash").Build(), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			err := json.Unmarshal(tc.receipt.JSON(), &receipt)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPaymentMethodRule(t *testing.T) {
+	setup()
+	live := cfg
+	live.Regions = testRegions
+	liveConfig.Store(&live)
+	rules := RulesConfig{"paymentMethod": {
+		Points:  map[string]int{"storeCard": 25, "cash": 5},
+		Regions: map[string]RuleConfig{"london": {Points: map[string]int{"storeCard": 10}}},
+	}}
+
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		want    int
+	}{
+		{name: "store card", receipt: receipttest.New().PaymentMethod("storeCard").Build(), want: 25},
+		{name: "cash", receipt: receipttest.New().PaymentMethod("cash").Build(), want: 5},
+		{name: "not configured", receipt: receipttest.New().PaymentMethod("credit").Build(), want: 0},
+		{name: "no payment method", receipt: receipttest.New().Build(), want: 0},
+		{name: "region", receipt: receipttest.New().PaymentMethod("storeCard").PostalCode("EC1A 1BB").Build(), want: 10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			if err := json.Unmarshal(tc.receipt.JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			for _, result := range receipt.Score(rules) {
+				if result.Rule == "paymentMethod" && result.Points != tc.want {
+					t.Errorf("paymentMethod awarded %d points, want %d", result.Points, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRulesConfigPoints(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rules   RulesConfig
+		wantErr bool
+	}{
+		{name: "valid", rules: RulesConfig{"paymentMethod": {Points: map[string]int{"giftCard": 3}}}},
+		{name: "other rule", rules: RulesConfig{"oddDay": {Points: map[string]int{"cash": 3}}}, wantErr: true},
+		{name: "unknown payment method", rules: RulesConfig{"paymentMethod": {Points: map[string]int{"cheque": 3}}}, wantErr: true},
+		{name: "negative", rules: RulesConfig{"paymentMethod": {Points: map[string]int{"cash": -1}}}, wantErr: true},
+		{name: "negative in a region", rules: RulesConfig{"paymentMethod": {Regions: map[string]RuleConfig{"london": {Points: map[string]int{"cash": -1}}}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rules.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetPaymentMethodStats(t *testing.T) {
+	router := setup()
+	for _, receipt := range []receipttest.Receipt{
+		receipttest.New().PaymentMethod("cash").Item("Dasani", "1.40").Build(),
+		receipttest.New().PaymentMethod("cash").Item("Dasani", "1.40").Build(),
+		receipttest.New().PaymentMethod("storeCard").PurchaseDate("2022-02-01").Item("Dasani", "1.40").Build(),
+		receipttest.New().Item("Dasani", "1.40").Build(),
+	} {
+		submitForAccount(t, router, "", receipt)
+	}
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       map[string]int
+	}{
+		{name: "all", query: "", wantStatus: http.StatusOK, want: map[string]int{"cash": 2, "credit": 0, "storeCard": 1, "unknown": 1}},
+		{name: "january", query: "?to=2022-01-31", wantStatus: http.StatusOK, want: map[string]int{"cash": 2, "storeCard": 0, "unknown": 1}},
+		{name: "invalid date", query: "?to=tomorrow", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/stats/payment-methods"+tc.query, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp paymentMethodStatsResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			got := map[string]PaymentMethodStats{}
+			for _, stats := range resp.PaymentMethods {
+				got[stats.PaymentMethod] = stats
+			}
+			for method, want := range tc.want {
+				if got[method].Receipts != want {
+					t.Errorf("%s has %d receipts, want %d (%s)", method, got[method].Receipts, want, rr.Body)
+				}
+			}
+			if tc.query == "" && got["cash"].Total != "2.80" {
+				t.Errorf("cash total = %s, want 2.80", got["cash"].Total)
+			}
+		})
+	}
+}
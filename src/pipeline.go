@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+var errDuplicateID = errors.New("duplicate receipt ID generated")
+
+// errStoreWrite is returned when the store failed to write a receipt, e.g. because the backend is down.
+var errStoreWrite = errors.New("storing the receipt failed")
+
+// submission is the outcome of submitReceipt. Throttled receipts were over the account's daily limit and earned
+// no points. The points of receipts under review are credited once they are approved, held points once they settle,
+// see HoldsConfig. Spooled receipts were accepted while the store was down and are submitted once it is back, see
+// receiptSpool.
+type submission struct {
+	ID          string
+	Points      int
+	Throttled   bool
+	UnderReview bool
+	Held        bool
+	Spooled     bool
+	Warnings    []Warning
+}
+
+// submitReceipt scores an already validated receipt and stores it under a freshly generated ID. Every entry point
+// (HTTP, watched directories, ...) goes through here so they can't drift apart. With an accountID the points are also
+// credited to that account.
+func submitReceipt(ctx context.Context, receipt Receipt, accountID string) (submission, error) {
+	return submitReceiptAs(ctx, receipt, accountID, "")
+}
+
+// submitReceiptAs is submitReceipt storing the receipt under id, for receipts that were handed an ID before they were
+// submitted. An empty id generates one. Submissions for the same account run one at a time, from the limits to the
+// streak, so they can't race each other's counters and balance.
+func submitReceiptAs(ctx context.Context, receipt Receipt, accountID, id string) (submission, error) {
+	var sub submission
+	var counted, retailerCounted bool
+	if accountID != "" {
+		start := time.Now()
+		err := ledger.ValidateAccount(accountID)
+		if err == nil {
+			unlock := accountLocks.lock(accountID)
+			defer unlock()
+		}
+		if err == nil {
+			retailerCounted, err = takeRetailerQuota(accountID, receipt.Retailer)
+		}
+		if err == nil {
+			if counted, sub.Throttled, err = throttleReceipt(accountID); err != nil && retailerCounted {
+				retailerCounter.release(retailerCounterKey(accountID, receipt.Retailer), accountNow(accountID))
+			}
+		}
+		timeStage(ctx, stageLimits, start, err)
+		if err != nil {
+			return submission{}, err
+		}
+	}
+
+	sub.ID = id
+	if sub.ID == "" {
+		sub.ID = newReceiptID()
+	}
+	sub.Warnings = receipt.warnings
+	loggerFor(ctx).Debug("Generated UUID", zap.String("receiptID", sub.ID))
+
+	// release gives back what the submission took from the account's limits and the transaction number's claim, for
+	// a receipt that didn't make it.
+	release := func() {
+		if counted {
+			receiptCounter.release(pointsLedger.Resolve(accountID), accountNow(accountID))
+		}
+		if retailerCounted {
+			retailerCounter.release(retailerCounterKey(accountID, receipt.Retailer), accountNow(accountID))
+		}
+		transactions.release(receipt.Retailer, receipt.TransactionNumber, sub.ID)
+	}
+
+	payload, err := json.Marshal(receipt.ToDTO())
+	if err == nil && receipt.TransactionNumber != "" {
+		start := time.Now()
+		err = claimTransaction(ctx, receipt, sub.ID)
+		timeStage(ctx, stageTransaction, start, err)
+	}
+	if err != nil {
+		release()
+		return submission{}, err
+	}
+
+	canary := isCanary(ctx)
+	if !sub.Throttled {
+		start := time.Now()
+		breakdown := receipt.Breakdown()
+		timeStage(ctx, stageScore, start, nil)
+		debugFrom(ctx).scored(breakdown)
+		if !canary {
+			observeRules(breakdown)
+		}
+		sub.Points = totalPoints(breakdown)
+	}
+	start := time.Now()
+	provider := currentConfig().Fraud.Provider
+	// canaries aren't real purchases, the fraud provider is never asked about them.
+	if canary {
+		provider = FraudProviderConfig{}
+	}
+	check, err := checkFraudProvider(ctx, provider, FraudScoreRequest{ReceiptID: sub.ID, Account: accountID, ClientIP: clientIPFrom(ctx), Points: sub.Points, Receipt: receipt.ToDTO()})
+	if provider.Type != "" {
+		timeStage(ctx, stageFraud, start, err)
+	}
+	if (check != nil || canary) && err == nil {
+		payload, err = json.Marshal(storedReceipt{ReceiptDTO: receipt.ToDTO(), FraudCheck: check, Canary: canary})
+	}
+	if err != nil {
+		release()
+		return submission{}, err
+	}
+	start = time.Now()
+	err = receiptStore.Put(ctx, store.Record{
+		ID:        sub.ID,
+		Points:    int64(sub.Points),
+		Receipt:   payload,
+		CreatedAt: time.Now().UTC(),
+	})
+	timeStage(ctx, stageStore, start, err)
+	if err != nil {
+		release()
+	}
+	// very unlikely, but just in case.
+	if errors.Is(err, store.ErrExists) {
+		logger.Error("Duplicate UUID generated", zap.String("receiptID", sub.ID))
+		return submission{}, errDuplicateID
+	}
+	if err != nil {
+		logger.Error("Failed to store receipt", zap.String("receiptID", sub.ID), zap.Error(err))
+		return submission{}, fmt.Errorf("%w: %w", errStoreWrite, err)
+	}
+	logger.Debug("Stored receipt points", zap.String("receiptID", sub.ID), zap.Int("points", sub.Points), zap.Bool("throttled", sub.Throttled))
+	if key := apiKeyFrom(ctx); key != "" {
+		keyUsage.recordPoints(key, int64(sub.Points))
+	}
+	// the same canary every time would look like a duplicate, and shouldn't land in the review queue either way.
+	if !canary {
+		start = time.Now()
+		if signal := checkFraud(currentConfig().Fraud, receipt, sub.ID, accountID, check); signal != "" && !sub.Throttled {
+			audits.hold(sub.ID, accountID, sub.Points, signal)
+			sub.UnderReview = true
+			logger.Debug("Held receipt for review", zap.String("receiptID", sub.ID))
+		} else if !sub.Throttled && audits.sample(currentConfig().AuditSampling, receipt, sub.ID, accountID, sub.Points) {
+			sub.UnderReview = true
+			logger.Debug("Sampled receipt for review", zap.String("receiptID", sub.ID))
+		}
+		timeStage(ctx, stageReview, start, nil)
+	}
+
+	if accountID != "" {
+		// throttled receipts, receipts under review and held points are still credited, with zero points, so they
+		// show up in the account's history.
+		points := int64(sub.Points)
+		settleAfter := time.Duration(currentConfig().Holds.SettleAfter)
+		sub.Held = settleAfter > 0 && points > 0 && !sub.UnderReview
+		if sub.UnderReview || sub.Held {
+			points = 0
+		}
+		start := time.Now()
+		credited, err := pointsLedger.Accrue(accountID, sub.ID, points)
+		timeStage(ctx, stageLedger, start, err)
+		if err != nil {
+			logger.Error("Failed to credit points", zap.String("receiptID", sub.ID), zap.String("account", accountID), zap.Error(err))
+			// the receipt is taken back out, so a retry isn't turned away as a duplicate and gets its points credited.
+			if err := receiptStore.Delete(ctx, sub.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+				logger.Error("Failed to delete uncredited receipt", zap.String("receiptID", sub.ID), zap.Error(err))
+			}
+			audits.drop(sub.ID)
+			release()
+			return submission{}, err
+		}
+		logger.Debug("Credited points", zap.String("receiptID", sub.ID), zap.String("account", credited))
+		creditDaily(ctx, sub.ID, credited)
+		if sub.Held {
+			now := time.Now().UTC()
+			holds.place(PointsHold{ReceiptID: sub.ID, Account: credited, Points: int64(sub.Points), PlacedAt: now, SettlesAt: now.Add(settleAfter)})
+		} else if !sub.Throttled && !sub.UnderReview {
+			start := time.Now()
+			events.Publish(Event{Type: EventPointsEarned, Account: credited, ReceiptID: sub.ID, Points: int64(sub.Points)})
+			timeStage(ctx, stageEvents, start, nil)
+		}
+		extendStreak(credited, sub.ID, receipt)
+	}
+
+	return sub, nil
+}
+
+// ingestResult is the outcome of ingesting one payload outside of HTTP (files, mail, queues), so partners can pick up
+// the IDs (or the reason it was rejected) without calling the HTTP API.
+type ingestResult struct {
+	ID       string    `json:"id,omitempty"`
+	Points   int       `json:"points"`
+	Error    string    `json:"error,omitempty"`
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// processReceiptData runs a raw JSON payload through the same decode/validate/score pipeline as the HTTP handler.
+func processReceiptData(ctx context.Context, data []byte) ingestResult {
+	var receipt Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		ingested.count(ctx, "json", "", "invalid")
+		return ingestResult{Error: err.Error()}
+	}
+
+	sub, err := submitReceipt(ctx, receipt, "")
+	ingested.count(ctx, "json", receipt.Retailer, ingestOutcome(err))
+	if err != nil {
+		return ingestResult{Error: err.Error()}
+	}
+
+	return ingestResult{ID: sub.ID, Points: sub.Points, Warnings: sub.Warnings}
+}
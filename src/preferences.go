@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // timezones work on hosts and images without a zoneinfo database.
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AccountPreferences are settings the account holder picks. Timezone, an IANA name like "America/Chicago" (UTC by
+// default), decides when the account's days and months start: for its daily receipt limits, its streak and its
+// statements. Locale picks the language of the errors /receipts/process returns for the account, English by default.
+// Notifications turns off the event types set to false, for accounts with an email set at
+// /accounts/{id}/notifications; the others are sent as usual, unless the account opted out of all of them there.
+type AccountPreferences struct {
+	Timezone      string          `json:"timezone,omitempty"`
+	Locale        string          `json:"locale,omitempty"`
+	Notifications map[string]bool `json:"notifications,omitempty"`
+
+	// loc is Timezone loaded, nil for UTC.
+	loc *time.Location
+}
+
+var localePattern = regexp.MustCompile(`^([a-z]{2})(-[A-Z]{2})?$`)
+
+func (p AccountPreferences) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Timezone, validation.By(func(any) error {
+			if _, err := time.LoadLocation(p.Timezone); err != nil {
+				return fmt.Errorf("unknown timezone %q", p.Timezone)
+			}
+			return nil
+		})),
+		validation.Field(&p.Locale, validation.By(func(any) error {
+			if p.Locale == "" {
+				return nil
+			}
+			if !localePattern.MatchString(p.Locale) || !slices.Contains(supportedLanguages(), localeLanguage(p.Locale)) {
+				return fmt.Errorf("want a locale like en-US in one of the languages %s", strings.Join(supportedLanguages(), ", "))
+			}
+			return nil
+		})),
+		validation.Field(&p.Notifications, validation.By(func(any) error {
+			for eventType := range p.Notifications {
+				if _, ok := defaultNotificationTemplates[eventType]; !ok {
+					return fmt.Errorf("no notifications for %q", eventType)
+				}
+			}
+			return nil
+		})),
+	)
+}
+
+// location is where the account's days start.
+func (p AccountPreferences) location() *time.Location {
+	if p.loc == nil {
+		return time.UTC
+	}
+	return p.loc
+}
+
+// preferenceStore holds the preferences of accounts that set any, in memory like their notification settings.
+type preferenceStore struct {
+	mu       sync.Mutex
+	accounts map[string]AccountPreferences
+}
+
+var preferences *preferenceStore
+
+func newPreferenceStore() *preferenceStore {
+	return &preferenceStore{accounts: map[string]AccountPreferences{}}
+}
+
+// get returns the preferences of account, or of the account it was merged into.
+func (s *preferenceStore) get(account string) AccountPreferences {
+	account = pointsLedger.Resolve(account)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accounts[account]
+}
+
+func (s *preferenceStore) set(account string, p AccountPreferences) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[account] = p
+}
+
+func (s *preferenceStore) delete(account string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accounts, account)
+}
+
+// accountNow is the time now in the account's timezone.
+func accountNow(account string) time.Time {
+	if account == "" {
+		return time.Now().UTC()
+	}
+	return time.Now().In(preferences.get(account).location())
+}
+
+func getPreferences(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := ledger.ValidateAccount(id); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+	writePreferences(w, preferences.get(id))
+}
+
+// putPreferences replaces the account's preferences. Its statements are generated again, in case the timezone moved
+// their months.
+func putPreferences(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := ledger.ValidateAccount(id); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	var p AccountPreferences
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "The preferences are invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := p.Validate(); err != nil {
+		http.Error(w, "The preferences are invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	// Validate made sure it loads.
+	p.loc, _ = time.LoadLocation(p.Timezone)
+	id = pointsLedger.Resolve(id)
+	preferences.set(id, p)
+	statements.forget(id)
+	logger.Debug("Set account preferences", zap.String("account", id), zap.String("timezone", p.Timezone), zap.String("locale", p.Locale))
+	writePreferences(w, p)
+}
+
+// deletePreferences puts the account back on the defaults.
+func deletePreferences(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := ledger.ValidateAccount(id); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+	id = pointsLedger.Resolve(id)
+	preferences.delete(id)
+	statements.forget(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePreferences(w http.ResponseWriter, p AccountPreferences) {
+	jsonResponse, err := json.Marshal(p)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountPreferences(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "all set", body: `{"timezone": "America/Chicago", "locale": "es-MX", "notifications": {"points.expiring": false}}`, wantStatus: http.StatusOK},
+		{name: "empty", body: `{}`, wantStatus: http.StatusOK},
+		{name: "unknown timezone", body: `{"timezone": "Mars/Olympus_Mons"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed locale", body: `{"locale": "spanish"}`, wantStatus: http.StatusBadRequest},
+		{name: "unsupported language", body: `{"locale": "de-DE"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown event type", body: `{"notifications": {"points.lost": false}}`, wantStatus: http.StatusBadRequest},
+		{name: "not JSON", body: `timezone=UTC`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("PUT", "/accounts/alice/preferences", strings.NewReader(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("PUT /accounts/alice/preferences = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/preferences", nil))
+			if got := strings.TrimSpace(rr.Body.String()); !jsonEqual(got, tc.body) {
+				t.Errorf("GET /accounts/alice/preferences = %s, want %s", got, tc.body)
+			}
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/accounts/alice/preferences", nil))
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("DELETE /accounts/alice/preferences = %v, want 204", rr.Code)
+			}
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/preferences", nil))
+			if got := rr.Body.String(); got != "{}" {
+				t.Errorf("GET /accounts/alice/preferences after deleting them = %s, want {}", got)
+			}
+		})
+	}
+}
+
+func jsonEqual(a, b string) bool {
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		locale       string
+		wantBody     string
+		wantLanguage string
+	}{
+		{name: "default", locale: "", wantBody: "The receipt is invalid.", wantLanguage: "en"},
+		{name: "spanish", locale: "es-MX", wantBody: "El recibo no es válido.", wantLanguage: "es"},
+		{name: "french", locale: "fr", wantBody: "Le reçu n'est pas valide.", wantLanguage: "fr"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			preferences.set("alice", AccountPreferences{Locale: tc.locale})
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(`{"retailer": "Target"}`))
+			req.Header.Set("X-Account-ID", "alice")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("POST /receipts/process = %v, want 400", rr.Code)
+			}
+			if got := strings.TrimSpace(rr.Body.String()); !strings.HasPrefix(got, strings.TrimSuffix(tc.wantBody, ".")) {
+				t.Errorf("body = %q, want %q", got, tc.wantBody)
+			}
+			if got := rr.Header().Get("Content-Language"); got != tc.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tc.wantLanguage)
+			}
+		})
+	}
+}
+
+func TestDailyCounterTimezone(t *testing.T) {
+	setup()
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	preferences.set("alice", AccountPreferences{Timezone: "Asia/Tokyo", loc: tokyo})
+
+	// 23:00 and 01:00 UTC are both the morning of January 2 in Tokyo.
+	c := newDailyCounter()
+	before := time.Date(2022, 1, 1, 23, 0, 0, 0, time.UTC).In(tokyo)
+	after := time.Date(2022, 1, 2, 1, 0, 0, 0, time.UTC).In(tokyo)
+	if !c.take("alice", 1, before) {
+		t.Fatalf("take() = false, want true")
+	}
+	if c.take("alice", 1, after) {
+		t.Errorf("take() after UTC midnight, the same day in Tokyo = true, want false")
+	}
+	if !c.take("alice", 1, after.Add(24*time.Hour)) {
+		t.Errorf("take() on the next day in Tokyo = false, want true")
+	}
+}
+
+func TestStatementTimezone(t *testing.T) {
+	router := setup()
+	pointsLedger.Accrue("alice", "r1", 40)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/accounts/alice/preferences", strings.NewReader(`{"timezone": "America/Chicago"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT /accounts/alice/preferences = %v %s, want 200", rr.Code, rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/statement?month=2022-01", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /accounts/alice/statement?month=2022-01 = %v %s, want 200", rr.Code, rr.Body)
+	}
+	var statement Statement
+	json.Unmarshal(rr.Body.Bytes(), &statement)
+	if statement.Timezone != "America/Chicago" {
+		t.Errorf("statement timezone = %q, want America/Chicago", statement.Timezone)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	testCases := []struct {
+		name          string
+		notifications map[string]bool
+		wantSent      bool
+	}{
+		{name: "no preferences", notifications: nil, wantSent: true},
+		{name: "turned on", notifications: map[string]bool{EventPointsEarned: true}, wantSent: true},
+		{name: "turned off", notifications: map[string]bool{EventPointsEarned: false}, wantSent: false},
+		{name: "another event turned off", notifications: map[string]bool{EventPointsExpiring: false}, wantSent: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setup()
+			notificationSettings.set("alice", NotificationSettings{Email: "alice@example.com"})
+			preferences.set("alice", AccountPreferences{Notifications: tc.notifications})
+			templates, _ := NotificationConfig{}.templates()
+
+			notifier := &recordingNotifier{}
+			notify(t.Context(), notifier, templates[EventPointsEarned], Event{Type: EventPointsEarned, Account: "alice", ReceiptID: "r1", Points: 40})
+			if got := len(notifier.Sent()) == 1; got != tc.wantSent {
+				t.Errorf("sent %+v, want sent = %v", notifier.Sent(), tc.wantSent)
+			}
+		})
+	}
+}
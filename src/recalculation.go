@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RecalculationConfig tunes recalculations of the whole store. Rate is the most receipts one rescores per second (/s)
+// or minute (/m), 100/s by default, so it doesn't crowd out the traffic on the store; a recalculation can ask for
+// another. CheckpointPath is where the progress is saved, so a recalculation interrupted by a restart can be resumed;
+// without it one can only be resumed by the process that ran it.
+type RecalculationConfig struct {
+	Rate           string `json:"rate"`
+	CheckpointPath string `json:"checkpointPath"`
+}
+
+const (
+	defaultRecalculationRate = "100/s"
+	// recalculationBatch is how many receipts a recalculation lists at a time, its checkpoint is saved after each.
+	recalculationBatch = 500
+)
+
+func (c RecalculationConfig) Validate() error {
+	if c.Rate != "" {
+		if _, err := parseRate(c.Rate); err != nil {
+			return fmt.Errorf("recalculation: rate must be a positive number of receipts per second or minute, like 500/s")
+		}
+	}
+	return nil
+}
+
+// Recalculation statuses. A recalculation that was running when the process stopped is interrupted.
+const (
+	recalculationRunning     = "running"
+	recalculationCompleted   = "completed"
+	recalculationCancelled   = "cancelled"
+	recalculationFailed      = "failed"
+	recalculationInterrupted = "interrupted"
+)
+
+// Recalculation is a rescoring of every stored receipt, oldest first, and how far it got. Cursor is the place of the
+// last rescored receipt in that order, where resuming picks up; Through is when that receipt was processed. Total is
+// how many receipts the store held when it started, as the backend counts them.
+type Recalculation struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Rules       string     `json:"rules,omitempty"`
+	Rate        string     `json:"rate"`
+	Reason      string     `json:"reason,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Total       int64      `json:"total"`
+	Processed   int64      `json:"processed"`
+	Changed     int64      `json:"changed"`
+	Skipped     int64      `json:"skipped"`
+	Failed      int64      `json:"failed"`
+	PointsDelta int64      `json:"pointsDelta"`
+	Through     *time.Time `json:"through,omitempty"`
+	Cursor      string     `json:"cursor,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// resumable reports whether the recalculation stopped before the end.
+func (j Recalculation) resumable() bool {
+	return j.Status == recalculationCancelled || j.Status == recalculationFailed || j.Status == recalculationInterrupted
+}
+
+// recalculationRequest starts a recalculation with the rules named like for reprocessing, or resumes the last one
+// with the rules and reason it started with. Rate overrides the configured rate.
+type recalculationRequest struct {
+	Rules  string `json:"rules"`
+	Rate   string `json:"rate"`
+	Reason string `json:"reason"`
+	Resume bool   `json:"resume"`
+}
+
+var (
+	errRecalculationRunning = errors.New("a recalculation is running")
+	errNoRecalculation      = errors.New("no recalculation to resume")
+)
+
+// recalculationRunner runs one recalculation at a time and keeps the latest one, running or not.
+type recalculationRunner struct {
+	mu     sync.Mutex
+	path   string
+	job    *Recalculation
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var recalculations *recalculationRunner
+
+// newRecalculationRunner picks up the recalculation checkpointed at path, if any.
+func newRecalculationRunner(path string) (*recalculationRunner, error) {
+	r := &recalculationRunner{path: path}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Recalculation
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	if job.Status == recalculationRunning {
+		job.Status = recalculationInterrupted
+	}
+	r.job = &job
+	return r, nil
+}
+
+// latest returns the latest recalculation.
+func (r *recalculationRunner) latest() (Recalculation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job == nil {
+		return Recalculation{}, false
+	}
+	return *r.job, true
+}
+
+// start starts or resumes a recalculation in the background.
+func (r *recalculationRunner) start(req recalculationRequest, interval time.Duration) (Recalculation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job != nil && r.job.Status == recalculationRunning {
+		return *r.job, errRecalculationRunning
+	}
+
+	now := time.Now().UTC()
+	var job Recalculation
+	if req.Resume {
+		if r.job == nil || !r.job.resumable() {
+			return Recalculation{}, errNoRecalculation
+		}
+		job = *r.job
+		job.Status, job.Rate, job.Error, job.FinishedAt, job.UpdatedAt = recalculationRunning, req.Rate, "", nil, now
+	} else {
+		job = Recalculation{ID: uuid.New().String(), Status: recalculationRunning, Rules: req.Rules, Rate: req.Rate, Reason: req.Reason, StartedAt: now, UpdatedAt: now}
+		if reporter, ok := store.Unwrap(receiptStore).(store.StatsReporter); ok {
+			if stats, err := reporter.Stats(context.Background()); err == nil {
+				job.Total = stats.Records
+			}
+		}
+	}
+	r.job = &job
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel, r.done = cancel, make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		r.run(ctx, job, interval)
+		r.mu.Lock()
+		if r.done == done {
+			r.cancel, r.done = nil, nil
+		}
+		r.mu.Unlock()
+		cancel()
+	}(r.done)
+	return job, nil
+}
+
+// stop cancels the running recalculation, if any, and waits for it to save its progress. It reports whether one was
+// running.
+func (r *recalculationRunner) stop() bool {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	<-done
+	return true
+}
+
+// update records the progress of job, and saves it as the checkpoint if save is set.
+func (r *recalculationRunner) update(job Recalculation, save bool) {
+	job.UpdatedAt = time.Now().UTC()
+	r.mu.Lock()
+	r.job = &job
+	r.mu.Unlock()
+	if !save || r.path == "" {
+		return
+	}
+	data, err := json.Marshal(job)
+	if err == nil {
+		// like backfill checkpoints, written to a temporary file first so a crash never leaves half of one behind.
+		if err = os.WriteFile(r.path+".tmp", data, 0o644); err == nil {
+			err = os.Rename(r.path+".tmp", r.path)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to save recalculation checkpoint", zap.String("path", r.path), zap.Error(err))
+	}
+}
+
+// run rescores the receipts after job's cursor, at most one per interval, until it gets to the end or ctx is
+// cancelled.
+func (r *recalculationRunner) run(ctx context.Context, job Recalculation, interval time.Duration) {
+	log := logger.With(zap.String("recalculation", job.ID))
+	log.Info("Recalculation started", zap.String("rules", job.Rules), zap.String("rate", job.Rate), zap.String("cursor", job.Cursor))
+	finish := func(status string, err error) {
+		now := time.Now().UTC()
+		job.Status, job.FinishedAt = status, &now
+		if err != nil {
+			job.Error = err.Error()
+		}
+		r.update(job, true)
+		pointsLedger.RecordAudit("receipts.recalculate", map[string]any{"id": job.ID, "status": status, "rules": job.Rules, "reason": job.Reason,
+			"processed": job.Processed, "changed": job.Changed, "pointsDelta": job.PointsDelta})
+		log.Info("Recalculation stopped", zap.String("status", status), zap.Int64("processed", job.Processed), zap.Int64("changed", job.Changed), zap.Error(err))
+	}
+
+	opts := store.ListOptions{Limit: recalculationBatch, Sort: store.SortProcessed, Ascending: true}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if job.Cursor != "" {
+			cursor, err := store.ParseCursor(job.Cursor)
+			if err != nil {
+				finish(recalculationFailed, err)
+				return
+			}
+			opts.After = &cursor
+		}
+		// the rules are looked up for every batch, so a reload applies from the next one.
+		c := currentConfig()
+		rulesFor := c.rulesFor
+		if job.Rules != "" {
+			rules, ok := c.namedRules(job.Rules)
+			if !ok {
+				finish(recalculationFailed, fmt.Errorf("no rules named %q are configured anymore", job.Rules))
+				return
+			}
+			rulesFor = func(time.Time) RulesConfig { return rules }
+		}
+
+		page, err := receiptStore.List(ctx, opts)
+		if ctx.Err() != nil {
+			finish(recalculationCancelled, nil)
+			return
+		}
+		if err != nil {
+			finish(recalculationFailed, err)
+			return
+		}
+		if len(page) == 0 {
+			finish(recalculationCompleted, nil)
+			return
+		}
+
+		for _, rec := range page {
+			select {
+			case <-ctx.Done():
+				finish(recalculationCancelled, nil)
+				return
+			case <-ticker.C:
+			}
+
+			before, points, _, err := rescoreStored(ctx, rec.ID, rulesFor)
+			var invalid invalidStoredError
+			switch {
+			// receipts deleted since the page was listed are just gone.
+			case errors.Is(err, store.ErrNotFound):
+			case errors.Is(err, errHasReturns), errors.As(err, &invalid):
+				job.Skipped++
+			case err != nil:
+				if ctx.Err() != nil {
+					finish(recalculationCancelled, nil)
+					return
+				}
+				job.Failed++
+				log.Error("Failed to recalculate receipt", zap.String("receiptID", rec.ID), zap.Error(err))
+			case points != before.Points:
+				job.Changed++
+				job.PointsDelta += points - before.Points
+				log.Debug("Recalculated receipt", zap.String("receiptID", rec.ID), zap.Int64("pointsBefore", before.Points), zap.Int64("points", points))
+			}
+			job.Processed++
+			job.Cursor = opts.CursorAfter(rec).String()
+			through := rec.CreatedAt
+			job.Through = &through
+			r.update(job, false)
+		}
+		r.update(job, true)
+	}
+}
+
+func writeRecalculation(w http.ResponseWriter, status int, job Recalculation) {
+	jsonResponse, err := json.Marshal(job)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// startRecalculation serves POST /admin/recalculation, which rescores every stored receipt in the background like
+// POST /receipts/{id}/reprocess does one, and answers 202 with its progress. With {"resume": true} it picks the last
+// one up where it was cancelled, failed or interrupted.
+func startRecalculation(w http.ResponseWriter, r *http.Request) {
+	var req recalculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	c := currentConfig()
+	if _, ok := c.namedRules(req.Rules); req.Rules != "" && !ok {
+		http.Error(w, "No rules found by that name, use \"live\" or the until date of a ruleHistory version.", http.StatusBadRequest)
+		return
+	}
+	if req.Rate == "" {
+		req.Rate = c.Recalculation.Rate
+	}
+	if req.Rate == "" {
+		req.Rate = defaultRecalculationRate
+	}
+	interval, err := parseRate(req.Rate)
+	if err != nil {
+		http.Error(w, "The rate must be a positive number of receipts per second or minute, like 500/s.", http.StatusBadRequest)
+		return
+	}
+
+	job, err := recalculations.start(req, interval)
+	switch {
+	case errors.Is(err, errRecalculationRunning):
+		http.Error(w, "A recalculation is running already, cancel it first.", http.StatusConflict)
+		return
+	case errors.Is(err, errNoRecalculation):
+		http.Error(w, "There is no cancelled, failed or interrupted recalculation to resume.", http.StatusConflict)
+		return
+	}
+	writeRecalculation(w, http.StatusAccepted, job)
+}
+
+// getRecalculation serves GET /admin/recalculation, the progress of the latest recalculation.
+func getRecalculation(w http.ResponseWriter, r *http.Request) {
+	job, ok := recalculations.latest()
+	if !ok {
+		http.Error(w, "No recalculation was started.", http.StatusNotFound)
+		return
+	}
+	writeRecalculation(w, http.StatusOK, job)
+}
+
+// cancelRecalculation serves DELETE /admin/recalculation. It returns once the recalculation stopped and saved its
+// checkpoint; the receipt being rescored at the time is finished first.
+func cancelRecalculation(w http.ResponseWriter, r *http.Request) {
+	if !recalculations.stop() {
+		http.Error(w, "No recalculation is running.", http.StatusConflict)
+		return
+	}
+	job, _ := recalculations.latest()
+	writeRecalculation(w, http.StatusOK, job)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/gorilla/mux"
+)
+
+// doRecalculation sends a request to /admin/recalculation and decodes the recalculation in the response.
+func doRecalculation(t *testing.T, router *mux.Router, method, body string, wantStatus int) Recalculation {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(method, "/admin/recalculation", bytes.NewBufferString(body)))
+	if rr.Code != wantStatus {
+		t.Fatalf("%s /admin/recalculation = %v %s, want %v", method, rr.Code, rr.Body, wantStatus)
+	}
+	var job Recalculation
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	return job
+}
+
+// awaitRecalculation waits for the running recalculation to stop and returns how it ended.
+func awaitRecalculation(t *testing.T, router *mux.Router) Recalculation {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		job := doRecalculation(t, router, "GET", "", http.StatusOK)
+		if job.Status != recalculationRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recalculation still running after 5s: %+v", job)
+		}
+	}
+}
+
+func TestRecalculation(t *testing.T) {
+	doubled := RulesConfig{"retailerName": {Multiplier: 2}}
+
+	testCases := []struct {
+		name        string
+		rules       RulesConfig
+		body        string
+		wantStatus  int
+		wantChanged int64
+		// wantDelta is what recalculating adds to each receipt, by the retailerName rule's points.
+		wantDelta func(retailerPoints int64) int64
+	}{
+		{name: "rules unchanged", body: `{"rate": "1000/s"}`, wantStatus: http.StatusAccepted, wantDelta: func(int64) int64 { return 0 }},
+		{name: "live rules changed", rules: doubled, body: `{"rate": "1000/s"}`, wantStatus: http.StatusAccepted, wantChanged: 3, wantDelta: func(p int64) int64 { return p }},
+		{name: "named rules", body: `{"rules": "live", "rate": "1000/s", "reason": "rule fix"}`, rules: doubled, wantStatus: http.StatusAccepted, wantChanged: 3, wantDelta: func(p int64) int64 { return p }},
+		{name: "unknown rules", body: `{"rules": "2019-01-01"}`, wantStatus: http.StatusBadRequest},
+		{name: "bad rate", body: `{"rate": "fast"}`, wantStatus: http.StatusBadRequest},
+		{name: "nothing to resume", body: `{"resume": true}`, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			var points []int64
+			for _, account := range []string{"alice", "bob", "carol"} {
+				points = append(points, submitForAccount(t, router, account, receipttest.New().Retailer("Target").Build()))
+			}
+			live := cfg
+			live.Rules = tc.rules
+			liveConfig.Store(&live)
+			t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+			doRecalculation(t, router, "POST", tc.body, tc.wantStatus)
+			if tc.wantStatus != http.StatusAccepted {
+				return
+			}
+			job := awaitRecalculation(t, router)
+			if job.Status != recalculationCompleted || job.Processed != 3 || job.Total != 3 || job.Changed != tc.wantChanged || job.PointsDelta != 3*tc.wantDelta(6) {
+				t.Errorf("recalculation = %+v, want 3 receipts processed and %d changed by %d points", job, tc.wantChanged, tc.wantDelta(6))
+			}
+			for i, account := range []string{"alice", "bob", "carol"} {
+				if balance, _ := pointsLedger.Balance(account); balance != points[i]+tc.wantDelta(6) {
+					t.Errorf("%s's balance = %d, want %d", account, balance, points[i]+tc.wantDelta(6))
+				}
+			}
+			audit := pointsLedger.Audit()
+			if last := audit[len(audit)-1]; last.Action != "receipts.recalculate" || last.Details["status"] != recalculationCompleted {
+				t.Errorf("last audit record = %+v, want the recalculation", last)
+			}
+		})
+	}
+}
+
+func TestRecalculationSkipsReturns(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Item("Gatorade", "6.00").Build())
+	id := pointsLedger.Receipts("alice")[0]
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+id+"/returns", bytes.NewBufferString(`{"items": [{"shortDescription": "Gatorade", "price": "6.00"}]}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/%s/returns = %v %s", id, rr.Code, rr.Body)
+	}
+
+	doRecalculation(t, router, "POST", `{"rate": "1000/s"}`, http.StatusAccepted)
+	if job := awaitRecalculation(t, router); job.Processed != 1 || job.Skipped != 1 || job.Changed != 0 {
+		t.Errorf("recalculation = %+v, want the receipt with returns skipped", job)
+	}
+}
+
+func TestRecalculationCancelAndResume(t *testing.T) {
+	router := setup()
+	var points int64
+	for range 5 {
+		points += submitForAccount(t, router, "alice", receipttest.New().Retailer("Target").Build())
+	}
+	live := cfg
+	live.Rules = RulesConfig{"retailerName": {Multiplier: 2}}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+	path := filepath.Join(t.TempDir(), "recalculation.json")
+	var err error
+	if recalculations, err = newRecalculationRunner(path); err != nil {
+		t.Fatal(err)
+	}
+
+	doRecalculation(t, router, "DELETE", "", http.StatusConflict)
+	started := doRecalculation(t, router, "POST", `{"rate": "20/s"}`, http.StatusAccepted)
+	doRecalculation(t, router, "POST", `{"rate": "20/s"}`, http.StatusConflict)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if job, _ := recalculations.latest(); job.Processed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("recalculation processed nothing within 5s")
+		}
+	}
+	cancelled := doRecalculation(t, router, "DELETE", "", http.StatusOK)
+	if cancelled.Status != recalculationCancelled || cancelled.Processed == 0 || cancelled.Processed == 5 || cancelled.Cursor == "" {
+		t.Fatalf("cancelled recalculation = %+v, want it stopped part way", cancelled)
+	}
+
+	// after a restart the checkpoint is picked up again.
+	if recalculations, err = newRecalculationRunner(path); err != nil {
+		t.Fatal(err)
+	}
+	if job := doRecalculation(t, router, "GET", "", http.StatusOK); job.ID != started.ID || job.Processed != cancelled.Processed {
+		t.Fatalf("recalculation after a restart = %+v, want the cancelled one", job)
+	}
+	resumed := doRecalculation(t, router, "POST", `{"resume": true, "rate": "1000/s"}`, http.StatusAccepted)
+	if resumed.ID != started.ID {
+		t.Errorf("resumed recalculation %s, want %s", resumed.ID, started.ID)
+	}
+	job := awaitRecalculation(t, router)
+	if job.Status != recalculationCompleted || job.Processed != 5 || job.Changed != 5 {
+		t.Errorf("resumed recalculation = %+v, want every receipt processed once", job)
+	}
+	if balance, _ := pointsLedger.Balance("alice"); balance != points+5*6 {
+		t.Errorf("alice's balance = %d, want %d", balance, points+5*6)
+	}
+}
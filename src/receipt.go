@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"regexp"
@@ -13,17 +14,21 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
+// amounts above this can't come from a real receipt, and they would overflow the int conversions in the points rules.
+const maxAmount = 1_000_000_000.00
+
 // DTOs are used to handle the raw JSON input, followed by validation and conversion to proper types
 // the validators help for debugging even if they are yet not sent to the user.
 type ItemDTO struct {
-	ShortDescription string `json:"shortDescription"`
-	Price            string `json:"price"`
+	ShortDescription string `json:"shortDescription" xml:"shortDescription"`
+	Price            string `json:"price" xml:"price"`
 }
 
 func (r ItemDTO) Validate() error {
 	return validation.ValidateStruct(&r,
 		validation.Field(&r.ShortDescription,
 			validation.Required,
+			validation.RuneLength(0, currentConfig().ReceiptLimits.maxDescriptionLength()),
 			validation.Match(regexp.MustCompile(`^[\w\s\-&]+$`)).Error("want alphanumeric characters, spaces, hyphens, and ampersands")),
 		validation.Field(&r.Price,
 			validation.Required,
@@ -38,12 +43,16 @@ func (r ItemDTO) ToItem() (Item, error) {
 
 	price, err := strconv.ParseFloat(r.Price, 64)
 	if err != nil {
-		return Item{}, fmt.Errorf("invalid price value: %s", r.Price)
+		return Item{}, validation.Errors{"price": validation.NewError("price", "invalid price value: "+r.Price)}
 	}
 
 	// making an assumption here.
 	if price < 0 {
-		return Item{}, fmt.Errorf("price must be a positive number")
+		return Item{}, validation.Errors{"price": validation.NewError("price", "must be a positive number")}
+	}
+
+	if price > maxAmount {
+		return Item{}, validation.Errors{"price": validation.NewError("price", fmt.Sprintf("must be at most %.2f", maxAmount))}
 	}
 
 	return Item{
@@ -53,11 +62,15 @@ func (r ItemDTO) ToItem() (Item, error) {
 }
 
 type ReceiptDTO struct {
-	Retailer     string    `json:"retailer"`
-	PurchaseDate string    `json:"purchaseDate"`
-	PurchaseTime string    `json:"purchaseTime"`
-	Items        []ItemDTO `json:"items"`
-	Total        string    `json:"total"`
+	Retailer          string            `json:"retailer"`
+	PurchaseDate      string            `json:"purchaseDate"`
+	PurchaseTime      string            `json:"purchaseTime"`
+	Items             []ItemDTO         `json:"items"`
+	Total             string            `json:"total"`
+	StoreLocation     *StoreLocation    `json:"storeLocation,omitempty"`
+	TransactionNumber string            `json:"transactionNumber,omitempty"`
+	PaymentMethod     string            `json:"paymentMethod,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
 }
 
 func (r ReceiptDTO) Validate() error {
@@ -73,10 +86,19 @@ func (r ReceiptDTO) Validate() error {
 			validation.Date("15:04").Error("want HH:MM format")),
 		validation.Field(&r.Items,
 			validation.Required,
-			validation.Length(1, 0).Error("must contain at least one item")),
+			validation.Length(1, 0).Error("must contain at least one item"),
+			validation.Length(0, currentConfig().ReceiptLimits.maxItems())),
 		validation.Field(&r.Total,
 			validation.Required,
 			validation.Match(regexp.MustCompile(`^\d+\.\d{2}$`)).Error("want 0.00 format")),
+		validation.Field(&r.StoreLocation),
+		validation.Field(&r.TransactionNumber,
+			validation.When(r.TransactionNumber != "", validation.By(func(any) error {
+				return currentConfig().TransactionNumbers.check(r.Retailer, r.TransactionNumber)
+			}))),
+		validation.Field(&r.PaymentMethod,
+			validation.In("cash", "credit", "debit", "giftCard", "storeCard").Error("want cash, credit, debit, giftCard or storeCard")),
+		validation.Field(&r.Metadata, validation.By(validateMetadata(currentConfig().ReceiptLimits))),
 	)
 }
 
@@ -86,72 +108,157 @@ type Item struct {
 }
 
 type Receipt struct {
-	Retailer     string    `json:"retailer"`
-	PurchaseDate time.Time `json:"purchaseDate"`
-	PurchaseTime time.Time `json:"purchaseTime"`
-	Items        []Item    `json:"items"`
-	Total        float64   `json:"total"`
+	Retailer          string         `json:"retailer"`
+	PurchaseDate      time.Time      `json:"purchaseDate"`
+	PurchaseTime      time.Time      `json:"purchaseTime"`
+	Items             []Item         `json:"items"`
+	Total             float64        `json:"total"`
+	StoreLocation     *StoreLocation `json:"storeLocation,omitempty"`
+	TransactionNumber string         `json:"transactionNumber,omitempty"`
+	PaymentMethod     string         `json:"paymentMethod,omitempty"`
+	// Metadata is partner data, like correlation IDs, stored and returned as submitted.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// warnings are about the receipt as it was submitted, they aren't stored.
+	warnings []Warning
 }
 
+// ToReceipt converts every field it can and reports all that it can't at once, items nested under "items" by index
+// the way Validate reports them, so clients can fix everything in one round trip.
 func (r ReceiptDTO) ToReceipt() (Receipt, error) {
+	errs := validation.Errors{}
+
 	// these errors are unlikely to happen - and should signify some internal server error.
 	purchaseDate, err := time.Parse("2006-01-02", r.PurchaseDate)
 	if err != nil {
-		return Receipt{}, validation.Errors{"purchaseDate": validation.NewError("purchaseDate", err.Error())}
+		errs["purchaseDate"] = validation.NewError("purchaseDate", err.Error())
 	}
 
 	purchaseTime, err := time.Parse("15:04", r.PurchaseTime)
 	if err != nil {
-		return Receipt{}, validation.Errors{"purchaseTime": validation.NewError("purchaseTime", err.Error())}
+		errs["purchaseTime"] = validation.NewError("purchaseTime", err.Error())
 	}
 
 	total, err := strconv.ParseFloat(r.Total, 64)
-	if err != nil {
-		return Receipt{}, validation.Errors{"total": validation.NewError("total", err.Error())}
-	}
-
+	switch {
+	case err != nil:
+		errs["total"] = validation.NewError("total", err.Error())
 	// making an assumption here.
-	if total < 0 {
-		return Receipt{}, validation.Errors{"total": validation.NewError("total", "must be a positive number")}
+	case total < 0:
+		errs["total"] = validation.NewError("total", "must be a positive number")
+	case total > maxAmount:
+		errs["total"] = validation.NewError("total", fmt.Sprintf("must be at most %.2f", maxAmount))
 	}
 
 	items := make([]Item, len(r.Items))
+	itemErrs := validation.Errors{}
 	for i, itemDTO := range r.Items {
 		item, err := itemDTO.ToItem()
 		if err != nil {
-			return Receipt{}, validation.Errors{fmt.Sprintf("items.%d", i): validation.NewError(fmt.Sprintf("items.%d", i), err.Error())}
+			itemErrs[strconv.Itoa(i)] = err
 		}
 		items[i] = item
 	}
+	if len(itemErrs) > 0 {
+		errs["items"] = itemErrs
+	}
+	var warnings []Warning
+	if len(errs) == 0 {
+		warning, err := checkTotal(currentConfig().Validation, items, total)
+		if err != nil {
+			errs["total"] = err
+		}
+		if warning != nil {
+			warnings = append(warnings, *warning)
+		}
+	}
+	if len(errs) > 0 {
+		return Receipt{}, errs
+	}
 
 	return Receipt{
-		Retailer:     r.Retailer,
-		PurchaseDate: purchaseDate,
-		PurchaseTime: purchaseTime,
-		Items:        items,
-		Total:        total,
+		Retailer:          r.Retailer,
+		PurchaseDate:      purchaseDate,
+		PurchaseTime:      purchaseTime,
+		Items:             items,
+		Total:             total,
+		StoreLocation:     r.StoreLocation,
+		TransactionNumber: r.TransactionNumber,
+		PaymentMethod:     r.PaymentMethod,
+		Metadata:          r.Metadata,
+		warnings:          warnings,
 	}, nil
 }
 
+// ToDTO is the inverse of ToReceipt, used to persist receipts in the same format they are submitted in.
+func (r Receipt) ToDTO() ReceiptDTO {
+	items := make([]ItemDTO, len(r.Items))
+	for i, item := range r.Items {
+		items[i] = ItemDTO{
+			ShortDescription: item.ShortDescription,
+			Price:            strconv.FormatFloat(item.Price, 'f', 2, 64),
+		}
+	}
+
+	return ReceiptDTO{
+		Retailer:          r.Retailer,
+		PurchaseDate:      r.PurchaseDate.Format("2006-01-02"),
+		PurchaseTime:      r.PurchaseTime.Format("15:04"),
+		Items:             items,
+		Total:             strconv.FormatFloat(r.Total, 'f', 2, 64),
+		StoreLocation:     r.StoreLocation,
+		TransactionNumber: r.TransactionNumber,
+		PaymentMethod:     r.PaymentMethod,
+		Metadata:          r.Metadata,
+	}
+}
+
 func (r *Receipt) UnmarshalJSON(b []byte) error {
-	var dto ReceiptDTO
-	if err := json.Unmarshal(b, &dto); err != nil {
+	if err := checkReceiptLimits(b, currentConfig().ReceiptLimits); err != nil {
 		return err
 	}
 
-	if err := dto.Validate(); err != nil {
+	var dto ReceiptDTO
+	if err := json.Unmarshal(b, &dto); err != nil {
 		return err
 	}
 
+	warnings := dto.whitespaceWarnings()
+	// Validate and ToReceipt both report every error they find, together they are all that is wrong with the receipt.
 	receipt, err := dto.ToReceipt()
+	if verr := dto.Validate(); verr != nil {
+		return mergeValidationErrors(verr, err)
+	}
 	if err != nil {
 		return err
 	}
 
+	receipt.warnings = append(warnings, receipt.warnings...)
 	*r = receipt
 	return nil
 }
 
+// mergeValidationErrors adds the errors of src to dst, nested ones (like items) included, keeping dst's where both
+// report a field. Anything but validation.Errors is returned as is.
+func mergeValidationErrors(dst, src error) error {
+	var d, s validation.Errors
+	if !errors.As(dst, &d) || !errors.As(src, &s) {
+		return dst
+	}
+	merged := validation.Errors{}
+	for field, err := range d {
+		merged[field] = err
+	}
+	for field, err := range s {
+		if existing, ok := merged[field]; ok {
+			merged[field] = mergeValidationErrors(existing, err)
+		} else {
+			merged[field] = err
+		}
+	}
+	return merged
+}
+
 // writing these separately helps in testing them indepedently.
 // making them pointer receivers helps in making less copies of the struct.
 func (r *Receipt) calculateRetailerPoints() int {
@@ -186,12 +293,20 @@ func (r *Receipt) calculateTotalPointsForEveryTwoItems() int {
 
 func (r *Receipt) calculatePointsForItemDescription() int {
 	points := 0
-	for _, item := range r.Items {
+	for _, item := range r.itemDescriptionPoints() {
+		points += item.Points
+	}
+	return points
+}
+
+func (r *Receipt) itemDescriptionPoints() []ItemPoints {
+	var items []ItemPoints
+	for i, item := range r.Items {
 		if len(strings.TrimSpace(item.ShortDescription))%3 == 0 {
-			points += int(math.Ceil(item.Price * 0.2))
+			items = append(items, ItemPoints{Item: i, ShortDescription: item.ShortDescription, Points: int(math.Ceil(item.Price * 0.2))})
 		}
 	}
-	return points
+	return items
 }
 
 func (r *Receipt) calculatePointsForOddDay() int {
@@ -210,15 +325,101 @@ func (r *Receipt) calculatePointsForPurchaseTime() int {
 	return points
 }
 
+// RuleResult is what a single rule contributed to a receipt's points. Rules scoring individual items list the items
+// that earned points, so clients can show the bonus next to the line item.
+type RuleResult struct {
+	Rule        string       `json:"rule"`
+	Description string       `json:"description"`
+	Points      int          `json:"points"`
+	Items       []ItemPoints `json:"items,omitempty"`
+	// took is how long the rule took to evaluate, for its metrics.
+	took time.Duration
+}
+
+// ItemPoints is what one item earned under a rule. Item is its index in the receipt's items.
+type ItemPoints struct {
+	Item             int    `json:"item"`
+	ShortDescription string `json:"shortDescription"`
+	Points           int    `json:"points"`
+}
+
+// scoringRule is one of pointRules.
+type scoringRule struct {
+	name        string
+	description string
+	calculate   func(*Receipt) int
+	// items is set for rules that score items one by one.
+	items func(*Receipt) []ItemPoints
+	// configured is set instead of calculate for rules whose points come from the rules config, and for custom rules.
+	configured func(*Receipt, RuleConfig) int
+}
+
+// pointRules are all scoring rules in the order the challenge lists them, then the custom ones (see registerRule).
+// WebAssembly rules come from the rules config (see scoringRules). The names are part of the API (the breakdown
+// returned by /receipts/score), don't rename them.
+var pointRules = []scoringRule{
+	{"retailerName", "One point for every alphanumeric character in the retailer name.", (*Receipt).calculateRetailerPoints, nil, nil},
+	{"roundDollarTotal", "50 points if the total is a round dollar amount with no cents.", (*Receipt).calculateTotalPointsForNoCents, nil, nil},
+	{"totalMultipleOf25", "25 points if the total is a multiple of 0.25.", (*Receipt).calculateTotalPointsForMultipleOf25, nil, nil},
+	{"everyTwoItems", "5 points for every two items on the receipt.", (*Receipt).calculateTotalPointsForEveryTwoItems, nil, nil},
+	{"itemDescription", "If the trimmed length of an item description is a multiple of 3, the price times 0.2 rounded up.", (*Receipt).calculatePointsForItemDescription, (*Receipt).itemDescriptionPoints, nil},
+	{"oddDay", "6 points if the day in the purchase date is odd.", (*Receipt).calculatePointsForOddDay, nil, nil},
+	{"afternoonPurchase", "10 points if the time of purchase is between 14:00 and 16:59.", (*Receipt).calculatePointsForPurchaseTime, nil, nil},
+	{"paymentMethod", "The points the rules config sets for the payment method, none unless configured.", nil, nil, (*Receipt).calculatePaymentMethodPoints},
+	{"metadata", "The points the rules config sets for key=value pairs of the metadata, none unless configured.", nil, nil, (*Receipt).calculateMetadataPoints},
+}
+
+// Score returns the points every enabled rule awards under rules, including rules that award none. A nil rules
+// scores with every rule as written. Rules scoped to the receipt's region, as the live config defines them, use the
+// region's settings.
+func (r Receipt) Score(rules RulesConfig) []RuleResult {
+	return r.scoreIn(rules, currentConfig().Regions)
+}
+
+// scoreIn is Score with the regions of a config that may not be live yet.
+func (r Receipt) scoreIn(rules RulesConfig, regions RegionsConfig) []RuleResult {
+	results := make([]RuleResult, 0, len(pointRules))
+	region := regions.regionOf(r.StoreLocation)
+	for _, rule := range scoringRules(rules) {
+		settings := rules[rule.name].in(region)
+		if settings.Disabled {
+			continue
+		}
+		start := time.Now()
+		result := RuleResult{Rule: rule.name, Description: rule.description}
+		if rule.items != nil {
+			// scaled item by item so the items still add up to the rule's points.
+			result.Items = rule.items(&r)
+			for i := range result.Items {
+				result.Items[i].Points = settings.scale(result.Items[i].Points)
+				result.Points += result.Items[i].Points
+			}
+		} else if rule.configured != nil {
+			result.Points = settings.scale(rule.configured(&r, settings))
+		} else {
+			result.Points = settings.scale(rule.calculate(&r))
+		}
+		result.took = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
+
+// Breakdown returns the points every enabled rule awards under the live rules config, or the rules that were active
+// on the purchase date when the rule history says to score by it.
+func (r Receipt) Breakdown() []RuleResult {
+	return r.Score(currentConfig().rulesFor(r.PurchaseDate))
+}
+
 // not making the public function a pointer receiver, otherwise the users get the impression that the /can/ be modified.
 func (r Receipt) CalculatePoints() int {
+	return totalPoints(r.Breakdown())
+}
+
+func totalPoints(results []RuleResult) int {
 	points := 0
-	points += r.calculateRetailerPoints()
-	points += r.calculateTotalPointsForNoCents()
-	points += r.calculateTotalPointsForMultipleOf25()
-	points += r.calculateTotalPointsForEveryTwoItems()
-	points += r.calculatePointsForItemDescription()
-	points += r.calculatePointsForOddDay()
-	points += r.calculatePointsForPurchaseTime()
+	for _, result := range results {
+		points += result.Points
+	}
 	return points
 }
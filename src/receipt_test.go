@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"testing"
 	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
 )
 
 func TestReceiptUnmarshalJSON(t *testing.T) {
@@ -277,6 +279,52 @@ func TestReceiptUnmarshalJSON(t *testing.T) {
 			wantErr:    true,
 			wantErrMsg: "items: (0: (price: cannot be blank.).).",
 		},
+		{
+			name: "item price too large",
+			json: `{
+				"retailer": "Target",
+				"purchaseDate": "2022-01-01",
+				"purchaseTime": "13:01",
+				"items": [{
+					"shortDescription": "abc",
+					"price": "99999999999999999999999999999999999999.99"
+				}],
+				"total": "1.25"
+			}`,
+			wantErr:    true,
+			wantErrMsg: "items: (0: (price: must be at most 1000000000.00.).).",
+		},
+		{
+			name: "total too large",
+			json: `{
+				"retailer": "Target",
+				"purchaseDate": "2022-01-01",
+				"purchaseTime": "13:01",
+				"items": [{
+					"shortDescription": "Mountain Dew",
+					"price": "1.25"
+				}],
+				"total": "1000000000.01"
+			}`,
+			wantErr:    true,
+			wantErrMsg: "total: must be at most 1000000000.00.",
+		},
+		{
+			name: "every error at once",
+			json: `{
+				"retailer": "Tar$get",
+				"purchaseDate": "2022-01-01",
+				"purchaseTime": "25:01",
+				"items": [
+					{"price": "1.25"},
+					{"shortDescription": "abc", "price": "99999999999999999999999999999999999999.99"},
+					{"shortDescription": "Mountain Dew", "price": "1"}
+				],
+				"total": "1000000000.01"
+			}`,
+			wantErr:    true,
+			wantErrMsg: "items: (0: (shortDescription: cannot be blank.); 1: (price: must be at most 1000000000.00.); 2: (price: want 0.00 format.).); purchaseTime: want HH:MM format; retailer: only alphanumeric characters, spaces, hyphens, and ampersands are allowed; total: must be at most 1000000000.00.",
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -501,6 +549,117 @@ func TestReceiptPoints(t *testing.T) {
 					t.Errorf("CalculatePoints() = %v, expected %v", got, tc.want)
 				}
 			})
+
+			t.Run("breakdown", func(t *testing.T) {
+				want := map[string]int{
+					"retailerName":      tc.wantRetailerPoints,
+					"roundDollarTotal":  tc.wantNoCentsPoints,
+					"totalMultipleOf25": tc.wantMultipleOf25Points,
+					"everyTwoItems":     tc.wantItemPairsPoints,
+					"itemDescription":   tc.wantDescriptionPoints,
+					"oddDay":            tc.wantOddDayPoints,
+					"afternoonPurchase": tc.wantTimePoints,
+					"paymentMethod":     0,
+					"metadata":          0,
+				}
+				breakdown := tc.receipt.Breakdown()
+				if len(breakdown) != len(want) {
+					t.Fatalf("Breakdown() has %v rules, expected %v", len(breakdown), len(want))
+				}
+				for _, r := range breakdown {
+					if r.Points != want[r.Rule] {
+						t.Errorf("Breakdown() %v = %v, expected %v", r.Rule, r.Points, want[r.Rule])
+					}
+					if r.Items == nil {
+						continue
+					}
+					itemPoints := 0
+					for _, item := range r.Items {
+						itemPoints += item.Points
+					}
+					if itemPoints != r.Points {
+						t.Errorf("Breakdown() %v items add up to %v, expected %v", r.Rule, itemPoints, r.Points)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestBreakdownItems(t *testing.T) {
+	receipt := Receipt{Items: []Item{
+		{ShortDescription: "Mountain Dew 12PK", Price: 6.49},
+		{ShortDescription: "Emils Cheese Pizza", Price: 12.25},
+		{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: 12.00},
+	}}
+	want := []ItemPoints{
+		{Item: 1, ShortDescription: "Emils Cheese Pizza", Points: 3},
+		{Item: 2, ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Points: 3},
+	}
+
+	for _, r := range receipt.Breakdown() {
+		if r.Rule != "itemDescription" {
+			if r.Items != nil {
+				t.Errorf("Breakdown() %v has items %+v, expected none", r.Rule, r.Items)
+			}
+			continue
+		}
+		if len(r.Items) != len(want) {
+			t.Fatalf("Breakdown() itemDescription items = %+v, expected %+v", r.Items, want)
+		}
+		for i := range want {
+			if r.Items[i] != want[i] {
+				t.Errorf("Breakdown() itemDescription items[%d] = %+v, expected %+v", i, r.Items[i], want[i])
+			}
+		}
+	}
+}
+
+func TestReceiptToDTO(t *testing.T) {
+	dto := ReceiptDTO{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+		Total: "14.25",
+	}
+
+	receipt, err := dto.ToReceipt()
+	if err != nil {
+		t.Fatalf("ToReceipt() error = %v", err)
+	}
+
+	got := receipt.ToDTO()
+	if got.Retailer != dto.Retailer || got.PurchaseDate != dto.PurchaseDate || got.PurchaseTime != dto.PurchaseTime || got.Total != dto.Total {
+		t.Errorf("ToDTO() = %+v, expected %+v", got, dto)
+	}
+	for i := range dto.Items {
+		if got.Items[i] != dto.Items[i] {
+			t.Errorf("ToDTO() Items[%d] = %+v, expected %+v", i, got.Items[i], dto.Items[i])
+		}
+	}
+}
+
+func TestGeneratedReceipts(t *testing.T) {
+	gen := receipttest.NewGenerator(1)
+	for i := 0; i < 100; i++ {
+		body := gen.Valid().JSON()
+		var receipt Receipt
+		if err := json.Unmarshal(body, &receipt); err != nil {
+			t.Errorf("generated receipt %s was rejected: %v", body, err)
+		}
+	}
+
+	for _, tc := range receipttest.EdgeCases() {
+		t.Run(tc.Name, func(t *testing.T) {
+			var receipt Receipt
+			err := json.Unmarshal(tc.Receipt.JSON(), &receipt)
+			if (err == nil) != tc.Valid {
+				t.Errorf("Unmarshal() error = %v, want valid %v", err, tc.Valid)
+			}
 		})
 	}
 }
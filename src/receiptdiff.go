@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ReceiptDiff is what an amendment changed about a receipt. Items are compared as a whole, since removing one moves
+// every item after it, so a corrected price shows as the old item removed and the new one added. Rules lists the rules
+// whose points the amendment changed, scored under the live rules.
+type ReceiptDiff struct {
+	Fields       []FieldChange `json:"fields,omitempty"`
+	ItemsRemoved []ItemDTO     `json:"itemsRemoved,omitempty"`
+	ItemsAdded   []ItemDTO     `json:"itemsAdded,omitempty"`
+	PointsBefore int64         `json:"pointsBefore"`
+	PointsAfter  int64         `json:"pointsAfter"`
+	Rules        []RuleDelta   `json:"rules,omitempty"`
+}
+
+// FieldChange is a field of the receipt other than its items, by its JSON path. Before or After is missing when the
+// field was unset.
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// diffReceipts compares the receipt before and after an amendment that moved its points from pointsBefore to
+// pointsAfter.
+func diffReceipts(before, after Receipt, pointsBefore, pointsAfter int64) ReceiptDiff {
+	diff := ReceiptDiff{PointsBefore: pointsBefore, PointsAfter: pointsAfter}
+	a, b := before.ToDTO(), after.ToDTO()
+
+	fieldsA, fieldsB := map[string]string{}, map[string]string{}
+	itemsA, itemsB := a.Items, b.Items
+	a.Items, b.Items = nil, nil
+	flattenJSON("", a, fieldsA)
+	flattenJSON("", b, fieldsB)
+	for _, field := range sortedKeys(mergeKeys(fieldsA, fieldsB)) {
+		if va, vb := fieldsA[field], fieldsB[field]; va != vb {
+			change := FieldChange{Field: strings.TrimPrefix(field, ".")}
+			if va != "" {
+				change.Before = json.RawMessage(va)
+			}
+			if vb != "" {
+				change.After = json.RawMessage(vb)
+			}
+			diff.Fields = append(diff.Fields, change)
+		}
+	}
+
+	diff.ItemsRemoved = subtractItems(itemsA, itemsB)
+	diff.ItemsAdded = subtractItems(itemsB, itemsA)
+	for _, delta := range ruleDeltas(before.Breakdown(), after.Breakdown()) {
+		if delta.Delta != 0 {
+			diff.Rules = append(diff.Rules, delta)
+		}
+	}
+	return diff
+}
+
+// mergeKeys returns a map with the keys of both, for iterating over their union.
+func mergeKeys(a, b map[string]string) map[string]string {
+	keys := map[string]string{}
+	for k := range a {
+		keys[k] = ""
+	}
+	for k := range b {
+		keys[k] = ""
+	}
+	return keys
+}
+
+// subtractItems returns the items of a that b doesn't have, as many times as a has them more than b, in a's order.
+func subtractItems(a, b []ItemDTO) []ItemDTO {
+	remaining := slices.Clone(b)
+	var missing []ItemDTO
+	for _, item := range a {
+		if i := slices.Index(remaining, item); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+		} else {
+			missing = append(missing, item)
+		}
+	}
+	return missing
+}
+
+// receiptAudit is GET /admin/audits/{id}: the receipt's review if it was sampled, and its audit trail.
+type receiptAudit struct {
+	ReceiptID string               `json:"receiptId"`
+	Review    *AuditItem           `json:"review,omitempty"`
+	Trail     []ledger.AuditRecord `json:"trail"`
+}
+
+// getReceiptAudit serves GET /admin/audits/{id}, everything done to a receipt after it was submitted, oldest first:
+// its review, amendments with what they changed, and returns.
+func getReceiptAudit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	response := receiptAudit{ReceiptID: id, Trail: []ledger.AuditRecord{}}
+	audits.mu.Lock()
+	if item, ok := audits.items[id]; ok {
+		review := *item
+		response.Review = &review
+	}
+	audits.mu.Unlock()
+	for _, record := range pointsLedger.Audit() {
+		if record.Details["receiptId"] == id {
+			response.Trail = append(response.Trail, record)
+		}
+	}
+	if response.Review == nil && len(response.Trail) == 0 {
+		http.Error(w, "Nothing was audited for that receipt.", http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
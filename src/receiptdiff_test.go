@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestDiffReceipts(t *testing.T) {
+	var before, after Receipt
+	json.Unmarshal(receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Item("Dasani", "4.00").Total("14.00").Build().JSON(), &before)
+	json.Unmarshal(receipttest.New().Item("Dasani", "4.00").Item("Gatorade", "6.50").Total("10.50").Build().JSON(), &after)
+
+	diff := diffReceipts(before, after, 80, 60)
+	got, _ := json.Marshal(diff.Fields)
+	if string(got) != `[{"field":"total","before":"14.00","after":"10.50"}]` {
+		t.Errorf("fields = %s, want only the total", got)
+	}
+	removed, _ := json.Marshal(diff.ItemsRemoved)
+	added, _ := json.Marshal(diff.ItemsAdded)
+	if string(removed) != `[{"shortDescription":"Gatorade","price":"6.00"},{"shortDescription":"Dasani","price":"4.00"}]` ||
+		string(added) != `[{"shortDescription":"Gatorade","price":"6.50"}]` {
+		t.Errorf("removed %s and added %s, want the old Gatorade and one Dasani out and the new Gatorade in", removed, added)
+	}
+	if diff.PointsBefore != 80 || diff.PointsAfter != 60 {
+		t.Errorf("points = %v -> %v, want 80 -> 60", diff.PointsBefore, diff.PointsAfter)
+	}
+	for _, rule := range diff.Rules {
+		if rule.Delta == 0 || rule.Delta != rule.B-rule.A {
+			t.Errorf("rule %+v didn't change, or its delta is off", rule)
+		}
+	}
+	if len(diff.Rules) == 0 {
+		t.Errorf("no rule changed, want at least roundDollarTotal and everyTwoItems")
+	}
+}
+
+func TestGetReceiptAudit(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build()
+	submitForAccount(t, router, "alice", receipt)
+	id := pointsLedger.Receipts("alice")[0]
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"support": "support-secret-0123456789", "test-admin": testAdminSecret}, AdminKeys: []string{"test-admin"}}
+	liveConfig.Store(&live)
+
+	req := httptest.NewRequest("DELETE", "/receipts/"+id+"/items/0?total=4.00", nil)
+	req.Header.Set("X-API-Key", "support-secret-0123456789")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	testCases := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{name: "amended", id: id, wantStatus: http.StatusOK},
+		{name: "never audited", id: "nope", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("GET", "/admin/audits/"+tc.id, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("GET /admin/audits/%s = %v %s, want %v", tc.id, rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var got struct {
+				Trail []struct {
+					Action  string `json:"action"`
+					Details struct {
+						Diff ReceiptDiff `json:"diff"`
+					} `json:"details"`
+				} `json:"trail"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if len(got.Trail) != 1 || got.Trail[0].Action != "receipt.amend" {
+				t.Fatalf("trail = %s, want the amendment", rr.Body)
+			}
+			diff := got.Trail[0].Details.Diff
+			if len(diff.ItemsRemoved) != 1 || diff.ItemsRemoved[0].ShortDescription != "Gatorade" || len(diff.Fields) != 1 || diff.Fields[0].Field != "total" {
+				t.Errorf("diff = %+v, want the Gatorade removed and the total corrected", diff)
+			}
+		})
+	}
+}
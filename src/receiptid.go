@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ReceiptIDConfig sets the format of the receipt IDs in paths like /receipts/{id}/points. IDs in another format are
+// rejected with 400 before the store is asked about them. Format is "uuid" (the default), what newReceiptID
+// generates, or "any" to accept anything as before, for stores holding receipts with IDs from elsewhere.
+type ReceiptIDConfig struct {
+	Format string `json:"format"`
+}
+
+// receiptIDFormats are the formats receipt IDs can be checked against, by name.
+var receiptIDFormats = map[string]func(id string) bool{
+	"uuid": func(id string) bool {
+		// uuid.Parse also takes braces, urn:uuid: and no hyphens, the service never hands those out.
+		_, err := uuid.Parse(id)
+		return len(id) == 36 && err == nil
+	},
+	"any": func(string) bool { return true },
+}
+
+func (c ReceiptIDConfig) Validate() error {
+	if _, ok := receiptIDFormats[c.format()]; !ok {
+		return fmt.Errorf("receiptIds: unknown format %q, must be \"uuid\" or \"any\"", c.Format)
+	}
+	return nil
+}
+
+func (c ReceiptIDConfig) format() string {
+	if c.Format == "" {
+		return "uuid"
+	}
+	return c.Format
+}
+
+// valid reports whether id is in the configured format.
+func (c ReceiptIDConfig) valid(id string) bool {
+	return receiptIDFormats[c.format()](id)
+}
+
+// receiptIDMiddleware turns away requests for /receipts/{id} routes whose ID can't be one the service handed out, so
+// they don't cost a store lookup. With sharding, the shard that owns the ID turns it away.
+func receiptIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(routeTemplate(r), "/receipts/{id}") && !currentConfig().ReceiptIDs.valid(mux.Vars(r)["id"]) {
+			http.Error(w, "The receipt ID is malformed.", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
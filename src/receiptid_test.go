@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// unknownReceiptID is well formed, but no receipt has it.
+const unknownReceiptID = "00000000-0000-4000-8000-000000000000"
+
+func TestReceiptIDValidation(t *testing.T) {
+	testCases := []struct {
+		name       string
+		format     string
+		path       string
+		wantStatus int
+	}{
+		{name: "malformed", path: "/receipts/whatever/points", wantStatus: http.StatusBadRequest},
+		{name: "uuid without hyphens", path: "/receipts/00000000000040008000000000000000/points", wantStatus: http.StatusBadRequest},
+		{name: "malformed on a subroute", path: "/receipts/whatever/points/explain", wantStatus: http.StatusBadRequest},
+		{name: "unknown", path: "/receipts/" + unknownReceiptID + "/points", wantStatus: http.StatusNotFound},
+		{name: "legacy permissive", format: "any", path: "/receipts/whatever/points", wantStatus: http.StatusNotFound},
+		{name: "not a receipt route", path: "/accounts/whatever/balance", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := *currentConfig()
+			live.ReceiptIDs.Format = tc.format
+			liveConfig.Store(&live)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("GET %v = %v %s, want %v", tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReceiptIDConfigValidate(t *testing.T) {
+	for format, wantErr := range map[string]bool{"": false, "uuid": false, "any": false, "ulid": true} {
+		if err := (ReceiptIDConfig{Format: format}).Validate(); (err != nil) != wantErr {
+			t.Errorf("Validate(%q) = %v, want error %v", format, err, wantErr)
+		}
+	}
+}
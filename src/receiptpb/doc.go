@@ -0,0 +1,5 @@
+// Package receiptpb is the Protocol Buffers schema of receipts, for clients that send them instead of JSON to save
+// bytes, like the mobile SDK.
+package receiptpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative receipt.proto
@@ -0,0 +1,243 @@
+// Package receipttest builds receipt payloads for tests, both ours and those of teams writing clients for the API.
+//
+// Receipts are in the wire format (amounts and dates as strings) so they can be posted as-is:
+//
+//	body := receipttest.New().Retailer("Walgreens").Item("Dasani", "1.40").Build().JSON()
+package receipttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"strings"
+)
+
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+type Location struct {
+	PostalCode string   `json:"postalCode,omitempty"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+}
+
+type Receipt struct {
+	Retailer          string            `json:"retailer"`
+	PurchaseDate      string            `json:"purchaseDate"`
+	PurchaseTime      string            `json:"purchaseTime"`
+	Items             []Item            `json:"items"`
+	Total             string            `json:"total"`
+	StoreLocation     *Location         `json:"storeLocation,omitempty"`
+	TransactionNumber string            `json:"transactionNumber,omitempty"`
+	PaymentMethod     string            `json:"paymentMethod,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+}
+
+// JSON returns the request body for the receipt.
+func (r Receipt) JSON() []byte {
+	data, err := json.Marshal(r)
+	if err != nil {
+		// only strings and numbers in here, can't happen.
+		panic(err)
+	}
+	return data
+}
+
+// Builder starts from a valid receipt and keeps the total in sync with the items unless Total is called.
+type Builder struct {
+	receipt       Receipt
+	items         []Item
+	totalOverride *string
+}
+
+// New returns a builder for a valid receipt with no items yet. Build adds a default item if none were added.
+func New() *Builder {
+	return &Builder{receipt: Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+	}}
+}
+
+func (b *Builder) Retailer(retailer string) *Builder {
+	b.receipt.Retailer = retailer
+	return b
+}
+
+func (b *Builder) PurchaseDate(date string) *Builder {
+	b.receipt.PurchaseDate = date
+	return b
+}
+
+func (b *Builder) PurchaseTime(t string) *Builder {
+	b.receipt.PurchaseTime = t
+	return b
+}
+
+func (b *Builder) Item(description, price string) *Builder {
+	b.items = append(b.items, Item{ShortDescription: description, Price: price})
+	return b
+}
+
+// PostalCode sets the store location to a postal code, keeping any coordinates.
+func (b *Builder) PostalCode(code string) *Builder {
+	b.location().PostalCode = code
+	return b
+}
+
+// Coordinates sets the store location's latitude and longitude, keeping any postal code.
+func (b *Builder) Coordinates(latitude, longitude float64) *Builder {
+	b.location().Latitude, b.location().Longitude = &latitude, &longitude
+	return b
+}
+
+func (b *Builder) TransactionNumber(number string) *Builder {
+	b.receipt.TransactionNumber = number
+	return b
+}
+
+func (b *Builder) PaymentMethod(method string) *Builder {
+	b.receipt.PaymentMethod = method
+	return b
+}
+
+// Metadata sets a key of the receipt's metadata.
+func (b *Builder) Metadata(key, value string) *Builder {
+	if b.receipt.Metadata == nil {
+		b.receipt.Metadata = map[string]string{}
+	}
+	b.receipt.Metadata[key] = value
+	return b
+}
+
+func (b *Builder) location() *Location {
+	if b.receipt.StoreLocation == nil {
+		b.receipt.StoreLocation = &Location{}
+	}
+	return b.receipt.StoreLocation
+}
+
+// NoItems makes Build produce an empty items list instead of the default item.
+func (b *Builder) NoItems() *Builder {
+	b.items = []Item{}
+	return b
+}
+
+// Total overrides the computed total, e.g. to build a receipt whose total doesn't match its items.
+func (b *Builder) Total(total string) *Builder {
+	b.totalOverride = &total
+	return b
+}
+
+func (b *Builder) Build() Receipt {
+	r := b.receipt
+	if r.StoreLocation != nil {
+		location := *r.StoreLocation
+		r.StoreLocation = &location
+	}
+	r.Metadata = maps.Clone(r.Metadata)
+	switch {
+	case b.items == nil:
+		r.Items = []Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}}
+	default:
+		r.Items = append([]Item{}, b.items...)
+	}
+
+	if b.totalOverride != nil {
+		r.Total = *b.totalOverride
+	} else {
+		r.Total = sumPrices(r.Items)
+	}
+	return r
+}
+
+// sumPrices adds up prices in cents so totals are exact. Unparseable prices are skipped.
+func sumPrices(items []Item) string {
+	var cents int64
+	for _, item := range items {
+		var dollars, c int64
+		if _, err := fmt.Sscanf(item.Price, "%d.%02d", &dollars, &c); err == nil {
+			cents += dollars*100 + c
+		}
+	}
+	return formatCents(cents)
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+var (
+	retailers    = []string{"Target", "Walgreens", "M&M Corner Market", "Costco", "Trader Joes", "7-Eleven"}
+	descriptions = []string{"Mountain Dew 12PK", "Emils Cheese Pizza", "Knorr Creamy Chicken", "Doritos Nacho Cheese",
+		"Klarbrunn 12-PK 12 FL OZ", "Gatorade", "Pepsi - 12-oz", "Dasani", "Milk", "Eggs & Bacon"}
+)
+
+// Generator produces random valid receipts. The same seed always yields the same sequence, so failures reproduce.
+type Generator struct {
+	rand *rand.Rand
+}
+
+func NewGenerator(seed uint64) *Generator {
+	return &Generator{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Valid returns a random receipt that passes validation, with 1-10 items priced up to 50.00.
+func (g *Generator) Valid() Receipt {
+	b := New().
+		Retailer(retailers[g.rand.IntN(len(retailers))]).
+		PurchaseDate(fmt.Sprintf("2022-%02d-%02d", g.rand.IntN(12)+1, g.rand.IntN(28)+1)).
+		PurchaseTime(fmt.Sprintf("%02d:%02d", g.rand.IntN(24), g.rand.IntN(60)))
+
+	for range g.rand.IntN(10) + 1 {
+		b.Item(descriptions[g.rand.IntN(len(descriptions))], formatCents(g.rand.Int64N(5000)+1))
+	}
+	return b.Build()
+}
+
+// Case is a named receipt together with whether the API should accept it.
+type Case struct {
+	Name    string
+	Receipt Receipt
+	Valid   bool
+}
+
+// EdgeCases returns receipts sitting on the boundaries of the validation and scoring rules.
+func EdgeCases() []Case {
+	return []Case{
+		{Name: "time window start", Receipt: New().PurchaseTime("14:00").Build(), Valid: true},
+		{Name: "time window end", Receipt: New().PurchaseTime("16:59").Build(), Valid: true},
+		{Name: "just before time window", Receipt: New().PurchaseTime("13:59").Build(), Valid: true},
+		{Name: "just after time window", Receipt: New().PurchaseTime("17:00").Build(), Valid: true},
+		{Name: "midnight", Receipt: New().PurchaseTime("00:00").Build(), Valid: true},
+		{Name: "round dollar total", Receipt: New().Item("Milk", "3.00").Build(), Valid: true},
+		{Name: "quarter multiple total", Receipt: New().Item("Milk", "3.25").Build(), Valid: true},
+		{Name: "zero total", Receipt: New().Item("Free Sample", "0.00").Build(), Valid: true},
+		{Name: "largest amount", Receipt: New().Item("Yacht", "1000000000.00").Build(), Valid: true},
+		{Name: "single character retailer", Receipt: New().Retailer("A").Build(), Valid: true},
+		{Name: "padded description", Receipt: New().Item("   abc   ", "1.00").Build(), Valid: true},
+		{Name: "leap day", Receipt: New().PurchaseDate("2024-02-29").Build(), Valid: true},
+		{Name: "many items", Receipt: manyItems(100), Valid: true},
+
+		{Name: "amount too large", Receipt: New().Item("Yacht", "1000000000.01").Build(), Valid: false},
+		{Name: "no items", Receipt: New().NoItems().Total("0.00").Build(), Valid: false},
+		{Name: "not a leap year", Receipt: New().PurchaseDate("2022-02-29").Build(), Valid: false},
+		{Name: "hour out of range", Receipt: New().PurchaseTime("24:00").Build(), Valid: false},
+		{Name: "price with one decimal", Receipt: New().Item("Milk", "3.5").Total("3.50").Build(), Valid: false},
+		{Name: "retailer with punctuation", Receipt: New().Retailer("Target!").Build(), Valid: false},
+		{Name: "blank retailer", Receipt: New().Retailer("").Build(), Valid: false},
+		{Name: "description with punctuation", Receipt: New().Item("Milk!", "1.00").Build(), Valid: false},
+		{Name: "negative total", Receipt: New().Total("-1.00").Build(), Valid: false},
+	}
+}
+
+func manyItems(n int) Receipt {
+	b := New()
+	for i := range n {
+		b.Item("Item "+strings.Repeat("x", i%5+1), "1.00")
+	}
+	return b.Build()
+}
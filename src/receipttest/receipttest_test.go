@@ -0,0 +1,38 @@
+package receipttest
+
+import "testing"
+
+func TestBuilderTotal(t *testing.T) {
+	testCases := []struct {
+		name      string
+		builder   *Builder
+		wantTotal string
+		wantItems int
+	}{
+		{name: "default item", builder: New(), wantTotal: "6.49", wantItems: 1},
+		{name: "sums items", builder: New().Item("Gatorade", "2.25").Item("Gatorade", "2.25"), wantTotal: "4.50", wantItems: 2},
+		{name: "override", builder: New().Item("Gatorade", "2.25").Total("9.00"), wantTotal: "9.00", wantItems: 1},
+		{name: "no items", builder: New().NoItems(), wantTotal: "0.00", wantItems: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.builder.Build()
+			if got.Total != tc.wantTotal {
+				t.Errorf("Total = %v, want %v", got.Total, tc.wantTotal)
+			}
+			if len(got.Items) != tc.wantItems {
+				t.Errorf("len(Items) = %v, want %v", len(got.Items), tc.wantItems)
+			}
+		})
+	}
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	a, b := NewGenerator(42), NewGenerator(42)
+	for i := 0; i < 10; i++ {
+		if string(a.Valid().JSON()) != string(b.Valid().JSON()) {
+			t.Fatalf("receipt %d differs between generators with the same seed", i)
+		}
+	}
+}
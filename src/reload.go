@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// liveConfig is the config request handling reads. cfg stays the snapshot taken at startup, which is what the
+// ingesters, connectors, consumer and store were built from.
+var liveConfig atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	if c := liveConfig.Load(); c != nil {
+		return c
+	}
+	return &cfg
+}
+
+// atomicLevel is shared by every logger built in setup, so the level can change without rebuilding them.
+var atomicLevel = zap.NewAtomicLevel()
+
+// reloadableSections are the top-level config keys a reload applies. Changes to anything else are reported but only
+// take effect after a restart.
+var reloadableSections = map[string]bool{"logLevel": true, "chaos": true, "concurrency": true, "shedding": true, "transfers": true, "streaks": true, "statements": true, "throttle": true, "holds": true, "auditSampling": true, "fraud": true, "receiptLimits": true, "validation": true, "rules": true, "ruleHistory": true, "ruleTests": true, "regions": true, "transactionNumbers": true, "erasure": true, "retention": true, "canary": true, "tap": true, "slowRequests": true, "replication": true, "sharding": true, "auth": true, "signedUrls": true, "clientIp": true, "receiptIds": true, "caching": true}
+
+// config keys whose values must never end up in logs or responses.
+var secretKeys = []string{"password", "dsn", "secret", "token", "encryptionkey", "apikeys"}
+
+// reloadMu keeps two reloads (e.g. SIGHUP and the admin endpoint) from interleaving their diff and swap.
+var reloadMu sync.Mutex
+
+type reloadResult struct {
+	Changed         []string `json:"changed"`
+	RestartRequired []string `json:"restartRequired,omitempty"`
+}
+
+func parseLogLevel(s string) (zapcore.Level, error) {
+	if s == "" {
+		return zapcore.InfoLevel, nil
+	}
+	return zapcore.ParseLevel(s)
+}
+
+// applyConfig swaps in the reloadable parts of c. In-flight requests finish against the limiter they started with.
+func applyConfig(c Config) {
+	level, _ := parseLogLevel(c.LogLevel)
+	atomicLevel.SetLevel(level)
+	limiter.Store(newConcurrencyLimiter(c.Concurrency))
+	shards.Store(newShardMap(c.Sharding))
+	liveConfig.Store(&c)
+}
+
+// reloadConfig re-reads the config file at path and applies it. An invalid file is rejected as a whole and the
+// running config is left untouched.
+func reloadConfig(path string) (reloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := loadConfig(path)
+	if err != nil {
+		return reloadResult{}, err
+	}
+
+	old := *currentConfig()
+	result := reloadResult{Changed: []string{}}
+	applied := old
+	oldValue, nextValue, appliedValue := reflect.ValueOf(old), reflect.ValueOf(next), reflect.ValueOf(&applied).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		key := jsonName(oldValue.Type().Field(i))
+		changes := diffJSON(key, oldValue.Field(i).Interface(), nextValue.Field(i).Interface())
+		if len(changes) == 0 {
+			continue
+		}
+		if reloadableSections[key] {
+			result.Changed = append(result.Changed, changes...)
+			appliedValue.Field(i).Set(nextValue.Field(i))
+		} else {
+			result.RestartRequired = append(result.RestartRequired, changes...)
+		}
+	}
+
+	applyConfig(applied)
+	logger.Info("Reloaded config", zap.String("path", path), zap.Strings("changed", result.Changed))
+	if len(result.RestartRequired) > 0 {
+		logger.Warn("Config changes need a restart to take effect", zap.Strings("changes", result.RestartRequired))
+	}
+	if applied.Chaos.Enabled && !old.Chaos.Enabled {
+		logger.Warn("Chaos injection is enabled, requests will be delayed and failed on purpose", zap.Any("chaos", applied.Chaos))
+	}
+	return result, nil
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// diffJSON compares the JSON form of a and b leaf by leaf and describes every difference as "path: old -> new".
+func diffJSON(prefix string, a, b any) []string {
+	flatA, flatB := map[string]string{}, map[string]string{}
+	flattenJSON(prefix, a, flatA)
+	flattenJSON(prefix, b, flatB)
+
+	var changes []string
+	for path, va := range flatA {
+		if vb, ok := flatB[path]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: %s -> (unset)", path, redact(path, va)))
+		} else if va != vb {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", path, redact(path, va), redact(path, vb)))
+		}
+	}
+	for path, vb := range flatB {
+		if _, ok := flatA[path]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: (unset) -> %s", path, redact(path, vb)))
+		}
+	}
+	slices.Sort(changes)
+	return changes
+}
+
+func flattenJSON(prefix string, v any, out map[string]string) {
+	data, _ := json.Marshal(v)
+	var generic any
+	json.Unmarshal(data, &generic)
+	flattenValue(prefix, generic, out)
+}
+
+func flattenValue(path string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			flattenValue(path+"."+k, child, out)
+		}
+	case []any:
+		for i, child := range v {
+			flattenValue(fmt.Sprintf("%s[%d]", path, i), child, out)
+		}
+	case nil:
+		// treat null like a missing key so nil and empty maps/slices don't show up as changes.
+	default:
+		data, _ := json.Marshal(v)
+		out[path] = string(data)
+	}
+}
+
+func redact(path, value string) string {
+	if value == `""` {
+		return value
+	}
+	lower := strings.ToLower(path)
+	for _, k := range secretKeys {
+		if strings.Contains(lower, k) {
+			return `"***"`
+		}
+	}
+	return value
+}
+
+// reloadOnSIGHUP reloads the config every time the process gets SIGHUP, the usual way to ask a daemon to do so.
+func reloadOnSIGHUP(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if _, err := reloadConfig(path); err != nil {
+				logger.Error("Rejected config reload, keeping the running config", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+}
+
+func reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	path := os.Getenv("CONFIG_FILE")
+	result, err := reloadConfig(path)
+	if err != nil {
+		logger.Error("Rejected config reload, keeping the running config", zap.String("path", path), zap.Error(err))
+		http.Error(w, "The config is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// logLevelHandler is zap's AtomicLevel handler: GET returns {"level":"info"}, PUT with {"level":"debug"} changes it on
+// the fly. The change lasts until the next config reload or restart.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	before := atomicLevel.Level()
+	w.Header().Set("Content-Type", "application/json")
+	atomicLevel.ServeHTTP(w, r)
+	if after := atomicLevel.Level(); after != before {
+		// logged at warn so it shows up whatever the new level is.
+		logger.Warn("Log level changed", zap.Stringer("from", before), zap.Stringer("to", after))
+	}
+}
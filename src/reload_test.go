@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"logLevel": "INFO", "store": {"backend": "memory"}}`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("LOG_LEVEL", "")
+	router := setup()
+
+	writeConfig(`{
+		"logLevel": "DEBUG",
+		"store": {"backend": "redis", "dsn": "redis://:hunter2@cache:6379"},
+		"chaos": {"enabled": true},
+		"concurrency": {"global": 5}
+	}`)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/admin/config/reload", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var result reloadResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	wantChanged := []string{`logLevel: "INFO" -> "DEBUG"`, "chaos.enabled: false -> true", "concurrency.global: 0 -> 5"}
+	if !reflect.DeepEqual(result.Changed, wantChanged) {
+		t.Errorf("changed = %q, want %q", result.Changed, wantChanged)
+	}
+	wantRestart := []string{`store.backend: "memory" -> "redis"`, `store.dsn: "" -> "***"`}
+	if !reflect.DeepEqual(result.RestartRequired, wantRestart) {
+		t.Errorf("restartRequired = %q, want %q", result.RestartRequired, wantRestart)
+	}
+
+	live := currentConfig()
+	if !live.Chaos.Enabled || live.Concurrency.Global != 5 {
+		t.Errorf("live config = %+v, want chaos enabled and a global limit of 5", live)
+	}
+	if live.Store.Backend != "memory" {
+		t.Errorf("store backend = %q, want the startup value to stay until a restart", live.Store.Backend)
+	}
+	if got := atomicLevel.Level(); got != zapcore.DebugLevel {
+		t.Errorf("log level = %v, want %v", got, zapcore.DebugLevel)
+	}
+	if cap(limiter.Load().global) != 5 {
+		t.Errorf("global limit = %v, want 5", cap(limiter.Load().global))
+	}
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed", data: `{`},
+		{name: "bad log level", data: `{"logLevel": "LOUD"}`},
+		{name: "bad consumer", data: `{"consumer": {"type": "carrier-pigeon"}}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeConfig(tc.data)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/config/reload", nil))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+			if !currentConfig().Chaos.Enabled || atomicLevel.Level() != zapcore.DebugLevel {
+				t.Errorf("rejected reload changed the running config")
+			}
+		})
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "INFO")
+	router := setup()
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLevel  zapcore.Level
+	}{
+		{name: "debug", body: `{"level": "debug"}`, wantStatus: http.StatusOK, wantLevel: zapcore.DebugLevel},
+		{name: "upper case", body: `{"level": "INFO"}`, wantStatus: http.StatusOK, wantLevel: zapcore.InfoLevel},
+		{name: "unknown level keeps current", body: `{"level": "loud"}`, wantStatus: http.StatusBadRequest, wantLevel: zapcore.InfoLevel},
+		{name: "missing level", body: `{}`, wantStatus: http.StatusBadRequest, wantLevel: zapcore.InfoLevel},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("PUT", "/admin/loglevel", bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if got := atomicLevel.Level(); got != tc.wantLevel {
+				t.Errorf("log level = %v, want %v", got, tc.wantLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ReplicationConfig runs the service active-passive across regions. The primary publishes every write to its store
+// on a replication topic, and a standby applies them to its own store. Type and the connection settings are those of
+// ConsumerConfig; for sqs URL is the queue, which should be FIFO since changes to a receipt must stay in order.
+// ReadOnly rejects writes through the API, for the standby. Role and the connection need a restart, ReadOnly is
+// reloadable.
+type ReplicationConfig struct {
+	Role     string   `json:"role"` // "primary" or "standby", off when empty
+	Type     string   `json:"type"` // "kafka", "nats" or "sqs"
+	Brokers  []string `json:"brokers"`
+	URL      string   `json:"url"`
+	Topic    string   `json:"topic"`
+	Group    string   `json:"group"`
+	Region   string   `json:"region"`
+	ReadOnly bool     `json:"readOnly"`
+}
+
+const (
+	replicationPrimary = "primary"
+	replicationStandby = "standby"
+	// replicationBuffer caps the changes waiting to be published. Beyond it changes are dropped, and the standby
+	// needs a resync, rather than holding up writes on the primary while the broker is down.
+	replicationBuffer = 10000
+)
+
+func (c ReplicationConfig) Validate() error {
+	if c.Role == "" {
+		return nil
+	}
+	if c.Role != replicationPrimary && c.Role != replicationStandby {
+		return fmt.Errorf("replication: unknown role %q, must be \"primary\" or \"standby\"", c.Role)
+	}
+	if _, ok := queueDialers[c.Type]; !ok {
+		return fmt.Errorf("replication: unknown type %q", c.Type)
+	}
+	if c.Type != "sqs" && c.Topic == "" {
+		return fmt.Errorf("replication: topic is required")
+	}
+	return nil
+}
+
+// queueConfig is what the queue of either side is dialed with. The primary only publishes, to the topic.
+func (c ReplicationConfig) queueConfig() ConsumerConfig {
+	q := ConsumerConfig{Type: c.Type, Brokers: c.Brokers, URL: c.URL, Region: c.Region}
+	topic := c.Topic
+	if c.Type == "sqs" {
+		topic = c.URL
+	}
+	if c.Role == replicationPrimary {
+		q.ResponseTopic = topic
+	} else {
+		q.Topic, q.Group = c.Topic, c.Group
+	}
+	return q
+}
+
+var (
+	replicationChangesTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_replication_changes_total",
+		Help: "Store changes published by the primary or applied by the standby, by role and outcome (ok, dropped or failed).",
+	}, []string{"role", "outcome"})
+
+	replicationPending = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_replication_pending_changes",
+		Help: "Changes the primary has yet to publish.",
+	})
+
+	replicationLag = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_replication_lag_seconds",
+		Help: "How long after the primary wrote it the standby applied the latest change.",
+	})
+
+	replicationLastApplied = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_replication_last_applied_timestamp_seconds",
+		Help: "When the standby last applied a change, to alert on a stalled stream.",
+	})
+)
+
+// startReplication connects the primary's store to the replication topic, or starts applying it on the standby.
+// It runs before anything writes to the store.
+func startReplication(ctx context.Context, c ReplicationConfig) error {
+	if c.Role == "" {
+		return nil
+	}
+	dial := queueDialers[c.Type]
+	if c.Role == replicationPrimary {
+		dial = queuePublishers[c.Type]
+	}
+	q, err := dial(ctx, c.queueConfig())
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", c.Type, err)
+	}
+	logger.Info("Replicating the store", zap.String("role", c.Role), zap.String("type", c.Type), zap.String("topic", c.Topic))
+
+	if c.Role == replicationStandby {
+		go func() {
+			defer q.Close()
+			runStandby(ctx, q)
+		}()
+		return nil
+	}
+
+	changes := make(chan store.Change, replicationBuffer)
+	receiptStore = store.NewReplicating(receiptStore, func(change store.Change) {
+		select {
+		case changes <- change:
+			replicationPending.Inc()
+		default:
+			replicationChangesTotal.WithLabelValues(c.Role, "dropped").Inc()
+			logger.Error("Dropped a store change, the standby needs a resync", zap.Uint64("seq", change.Seq), zap.String("kind", change.Kind))
+		}
+	})
+	go func() {
+		defer q.Close()
+		publishChanges(ctx, q, changes)
+	}()
+	return nil
+}
+
+// publishChanges publishes changes one at a time, retrying each until it goes through so they stay in order.
+func publishChanges(ctx context.Context, q messageQueue, changes <-chan store.Change) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-changes:
+			replicationPending.Dec()
+			body, err := json.Marshal(change)
+			if err != nil {
+				logger.Error("Failed to marshal store change", zap.Uint64("seq", change.Seq), zap.Error(err))
+				continue
+			}
+			// keyed by receipt, so kafka keeps the changes to a receipt on one partition.
+			msg := queueMessage{ID: changeSubject(change)}
+			for {
+				err := q.Publish(ctx, msg, body)
+				if err == nil {
+					replicationChangesTotal.WithLabelValues(replicationPrimary, "ok").Inc()
+					break
+				}
+				replicationChangesTotal.WithLabelValues(replicationPrimary, "failed").Inc()
+				logger.Error("Failed to publish store change", zap.Uint64("seq", change.Seq), zap.Error(err))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}
+}
+
+func changeSubject(c store.Change) string {
+	switch {
+	case c.Record != nil:
+		return c.Record.ID
+	case c.Key != nil:
+		return "key/" + c.Key.ID
+	default:
+		return c.ID
+	}
+}
+
+// runStandby applies changes until ctx is done. A change is acknowledged once applied, and applying is idempotent,
+// so redeliveries are harmless.
+func runStandby(ctx context.Context, q messageQueue) {
+	for ctx.Err() == nil {
+		messages, err := q.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to receive store changes", zap.Error(err))
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+		for _, msg := range messages {
+			if err := applyChange(ctx, msg); err != nil {
+				replicationChangesTotal.WithLabelValues(replicationStandby, "failed").Inc()
+				logger.Error("Failed to apply store change", zap.String("messageID", msg.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func applyChange(ctx context.Context, msg queueMessage) error {
+	var change store.Change
+	if err := json.Unmarshal(msg.Body, &change); err != nil {
+		return fmt.Errorf("decoding change: %w", err)
+	}
+	if err := store.Apply(ctx, receiptStore, change); err != nil {
+		return err
+	}
+	if change.Kind == store.ChangePutKey {
+		// so the key works right away, not after the next refresh.
+		if err := refreshAPIKeys(ctx); err != nil {
+			logger.Warn("Failed to refresh API keys", zap.Error(err))
+		}
+	}
+	now := time.Now()
+	replicationChangesTotal.WithLabelValues(replicationStandby, "ok").Inc()
+	replicationLag.Set(now.Sub(change.At).Seconds())
+	replicationLastApplied.Set(float64(now.Unix()))
+	if msg.Ack == nil {
+		return nil
+	}
+	return msg.Ack(ctx)
+}
+
+// dryRunPaths are POSTs that don't write anything.
+var dryRunPaths = map[string]bool{"/receipts/score": true, "/receipts/compare": true, "/receipts/canonicalize": true}
+
+// readOnlyMiddleware turns away writes through the API while replication.readOnly is set. /admin stays writable, so
+// operators can still manage the standby.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !currentConfig().Replication.ReadOnly, r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions,
+			dryRunPaths[r.URL.Path], strings.HasPrefix(r.URL.Path, "/admin/"):
+			next.ServeHTTP(w, r)
+		default:
+			http.Error(w, "This region is a read-only standby, send writes to the primary.", http.StatusServiceUnavailable)
+		}
+	})
+}
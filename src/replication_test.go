@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// chanQueue delivers what is published to it to its own Receive.
+type chanQueue struct {
+	messages chan queueMessage
+}
+
+func (q *chanQueue) Receive(ctx context.Context) ([]queueMessage, error) {
+	select {
+	case msg := <-q.messages:
+		return []queueMessage{msg}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *chanQueue) Publish(ctx context.Context, msg queueMessage, body []byte) error {
+	q.messages <- queueMessage{ID: msg.ID, Body: body}
+	return nil
+}
+
+func (q *chanQueue) Close() error { return nil }
+
+func TestReplication(t *testing.T) {
+	router := setup()
+	q := &chanQueue{messages: make(chan queueMessage, 10)}
+	queueDialers["chan"] = func(context.Context, ConsumerConfig) (messageQueue, error) { return q, nil }
+	queuePublishers["chan"] = queueDialers["chan"]
+	t.Cleanup(func() {
+		delete(queueDialers, "chan")
+		delete(queuePublishers, "chan")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := startReplication(ctx, ReplicationConfig{Role: replicationPrimary, Type: "chan", Topic: "changes"}); err != nil {
+		t.Fatalf("startReplication() error = %v", err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+
+	var msg queueMessage
+	select {
+	case msg = <-q.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the primary published no change")
+	}
+	if msg.ID != resp.ID {
+		t.Errorf("change is keyed by %q, want the receipt ID %q", msg.ID, resp.ID)
+	}
+
+	// the same change applied by a standby with a store of its own.
+	receiptStore = store.NewMemory()
+	before := testutil.ToFloat64(replicationChangesTotal.WithLabelValues(replicationStandby, "ok"))
+	if err := applyChange(ctx, msg); err != nil {
+		t.Fatalf("applyChange() error = %v", err)
+	}
+	if _, err := receiptStore.Get(ctx, resp.ID); err != nil {
+		t.Errorf("standby doesn't have the receipt: %v", err)
+	}
+	if got := testutil.ToFloat64(replicationChangesTotal.WithLabelValues(replicationStandby, "ok")); got != before+1 {
+		t.Errorf("applied changes = %v, want %v", got, before+1)
+	}
+	if lag := testutil.ToFloat64(replicationLag); lag < 0 || lag > 5 {
+		t.Errorf("replication lag = %vs, want a few seconds at most", lag)
+	}
+}
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Replication.ReadOnly = true
+	liveConfig.Store(&live)
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "submission", method: "POST", path: "/receipts/process", wantStatus: http.StatusServiceUnavailable},
+		{name: "dry run", method: "POST", path: "/receipts/score", wantStatus: http.StatusOK},
+		{name: "read", method: "GET", path: "/receipts", wantStatus: http.StatusOK},
+		{name: "admin", method: "GET", path: "/admin/loglevel", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest(tc.method, tc.path, bytes.NewReader(receipttest.New().Build().JSON())))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("%s %s = %v %s, want %v", tc.method, tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReplicationConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ReplicationConfig
+		wantErr bool
+	}{
+		{name: "off", config: ReplicationConfig{}},
+		{name: "primary", config: ReplicationConfig{Role: "primary", Type: "kafka", Topic: "changes"}},
+		{name: "standby on sqs", config: ReplicationConfig{Role: "standby", Type: "sqs", URL: "https://sqs/changes.fifo"}},
+		{name: "unknown role", config: ReplicationConfig{Role: "leader", Type: "kafka", Topic: "changes"}, wantErr: true},
+		{name: "unknown type", config: ReplicationConfig{Role: "primary", Type: "carrier-pigeon", Topic: "changes"}, wantErr: true},
+		{name: "no topic", config: ReplicationConfig{Role: "standby", Type: "nats"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// reprocessRequest names the rules to score the receipt under: "live" for the live rules, or the until date of a
+// ruleHistory version. Without one it is scored like a new submission with its purchase date. Reason ends up in the
+// audit trail.
+type reprocessRequest struct {
+	Rules  string `json:"rules"`
+	Reason string `json:"reason"`
+}
+
+// reprocessed is the response of POST /receipts/{id}/reprocess: the receipt's new points and how they came about.
+type reprocessed struct {
+	ID             string       `json:"id"`
+	Points         int64        `json:"points"`
+	PreviousPoints int64        `json:"previousPoints"`
+	Breakdown      []RuleResult `json:"breakdown"`
+}
+
+// namedRules returns the rules called name: "live" for the live rules, or the until date of a ruleHistory version.
+func (c Config) namedRules(name string) (RulesConfig, bool) {
+	if name == "live" {
+		return c.Rules, true
+	}
+	for _, version := range c.RuleHistory.Versions {
+		if version.Until == name {
+			return version.Rules, true
+		}
+	}
+	return nil, false
+}
+
+// errHasReturns is returned by rescoreStored for receipts with returns, whose points were returned against.
+var errHasReturns = errors.New("the receipt has returns")
+
+// invalidStoredError is returned by rescoreStored for a stored receipt that doesn't pass validation anymore.
+type invalidStoredError struct{ err error }
+
+func (e invalidStoredError) Error() string {
+	return "the stored receipt doesn't pass validation anymore: " + e.err.Error()
+}
+
+// rescoreStored validates the stored receipt id again and scores it under rulesFor its purchase date. The new points
+// replace the stored ones, and the difference is credited or debited to the account it was credited to. It returns
+// the record as it was before. Receipts with returns are left alone.
+func rescoreStored(ctx context.Context, id string, rulesFor func(purchased time.Time) RulesConfig) (store.Record, int64, []RuleResult, error) {
+	// returns.mu also serializes rescoring, against amendments and returns.
+	returns.mu.Lock()
+	defer returns.mu.Unlock()
+	unlock := lockReceiptAccount(id)
+	defer unlock()
+	if len(returns.byReceipt[id]) > 0 {
+		return store.Record{}, 0, nil, errHasReturns
+	}
+
+	rec, err := receiptStore.Get(ctx, id)
+	if err != nil {
+		return store.Record{}, 0, nil, err
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(rec.Receipt, &receipt); err != nil {
+		return store.Record{}, 0, nil, invalidStoredError{err}
+	}
+
+	breakdown := receipt.Score(rulesFor(receipt.PurchaseDate))
+	points := int64(totalPoints(breakdown))
+	if points != rec.Points {
+		if err := receiptStore.Update(ctx, store.Record{ID: id, Points: points, Receipt: rec.Receipt}); err != nil {
+			return store.Record{}, 0, nil, err
+		}
+	}
+	// the points of a receipt under review or on hold are credited once it's approved or they settle, with what it
+	// earns then.
+	if delta := points - rec.Points; delta != 0 && !audits.pending(id) && !holds.pending(id) {
+		if _, _, err := pointsLedger.Rescore(id, delta); err != nil && !errors.Is(err, ledger.ErrUnownedReceipt) {
+			logger.Error("Failed to credit rescored points", zap.String("receiptID", id), zap.Int64("delta", delta), zap.Error(err))
+		}
+	}
+	return rec, points, breakdown, nil
+}
+
+// reprocessReceipt serves POST /receipts/{id}/reprocess, for support to correct the points of a receipt after a rule
+// bug. The stored receipt is validated again and scored under the current or the named rules, and the difference is
+// credited or debited to the account it was credited to. Like amendments, it needs an API key with the
+// receipts:amend scope, and receipts with returns can't be reprocessed.
+func reprocessReceipt(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	key := apiKeyFrom(r.Context())
+	if key == "" {
+		http.Error(w, "An API key with the receipts:amend scope is required to reprocess receipts.", http.StatusUnauthorized)
+		return
+	}
+
+	var req reprocessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	c := currentConfig()
+	rules, ok := c.namedRules(req.Rules)
+	if req.Rules != "" && !ok {
+		http.Error(w, "No rules found by that name, use \"live\" or the until date of a ruleHistory version.", http.StatusBadRequest)
+		return
+	}
+
+	rulesFor := c.rulesFor
+	if req.Rules != "" {
+		rulesFor = func(time.Time) RulesConfig { return rules }
+	}
+	rec, points, breakdown, err := rescoreStored(r.Context(), id, rulesFor)
+	var invalid invalidStoredError
+	switch {
+	case errors.Is(err, errHasReturns):
+		http.Error(w, "Receipts with returns can't be reprocessed.", http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	case errors.As(err, &invalid):
+		http.Error(w, "The stored receipt doesn't pass validation anymore: "+invalid.err.Error()+".", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		logger.Error("Failed to reprocess receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	pointsLedger.RecordAudit("receipt.reprocess", map[string]any{"receiptId": id, "apiKey": key, "rules": req.Rules, "reason": req.Reason,
+		"pointsBefore": rec.Points, "pointsAfter": points})
+	logger.Info("Reprocessed receipt", zap.String("receiptID", id), zap.String("apiKey", key), zap.Int64("pointsBefore", rec.Points), zap.Int64("points", points))
+
+	jsonResponse, err := json.Marshal(reprocessed{ID: id, Points: points, PreviousPoints: rec.Points, Breakdown: breakdown})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
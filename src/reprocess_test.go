@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestReprocessReceipt(t *testing.T) {
+	doubled := RulesConfig{"retailerName": {Multiplier: 2}}
+
+	testCases := []struct {
+		name       string
+		rules      RulesConfig
+		history    []RuleVersion
+		body       string
+		secret     string
+		wantStatus int
+		// wantDelta is what reprocessing adds to the points, by the retailerName rule's points.
+		wantDelta func(retailerPoints int64) int64
+	}{
+		{name: "no API key", rules: doubled, wantStatus: http.StatusUnauthorized},
+		{name: "rules unchanged", secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantDelta: func(int64) int64 { return 0 }},
+		{name: "live rules changed", rules: doubled, secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantDelta: func(p int64) int64 { return p }},
+		{
+			name: "named version", history: []RuleVersion{{Until: "2022-01-01", Rules: RulesConfig{"retailerName": {Disabled: true}}}},
+			body: `{"rules": "2022-01-01", "reason": "ticket 4711"}`, secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantDelta: func(p int64) int64 { return -p },
+		},
+		{name: "live by name", rules: doubled, body: `{"rules": "live"}`, secret: "support-secret-0123456789", wantStatus: http.StatusOK, wantDelta: func(p int64) int64 { return p }},
+		{name: "unknown rules", body: `{"rules": "2019-01-01"}`, secret: "support-secret-0123456789", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			receipt := receipttest.New().Retailer("Target").Build()
+			points := submitForAccount(t, router, "alice", receipt)
+			id := pointsLedger.Receipts("alice")[0]
+			live := cfg
+			live.Auth = AuthConfig{APIKeys: map[string]string{"support": "support-secret-0123456789"}}
+			live.Rules = tc.rules
+			live.RuleHistory = RuleHistoryConfig{Versions: tc.history}
+			liveConfig.Store(&live)
+
+			req := httptest.NewRequest("POST", "/receipts/"+id+"/reprocess", bytes.NewBufferString(tc.body))
+			if tc.secret != "" {
+				req.Header.Set("X-API-Key", tc.secret)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/%s/reprocess = %v %s, want %v", id, rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp reprocessed
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			want := points + tc.wantDelta(6)
+			if resp.Points != want || resp.PreviousPoints != points {
+				t.Errorf("reprocessed from %d to %d points, want from %d to %d", resp.PreviousPoints, resp.Points, points, want)
+			}
+			if balance, _ := pointsLedger.Balance("alice"); balance != want {
+				t.Errorf("alice's balance = %d, want %d", balance, want)
+			}
+			if rec, err := receiptStore.Get(context.Background(), id); err != nil || rec.Points != want {
+				t.Errorf("stored receipt has %d points (%v), want %d", rec.Points, err, want)
+			}
+			audit := pointsLedger.Audit()
+			if last := audit[len(audit)-1]; last.Action != "receipt.reprocess" || last.Details["pointsAfter"] != want {
+				t.Errorf("last audit record = %+v, want the reprocessing", last)
+			}
+		})
+	}
+}
+
+func TestReprocessReceiptWithReturns(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Item("Gatorade", "6.00").Build())
+	id := pointsLedger.Receipts("alice")[0]
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"support": "support-secret-0123456789"}}
+	liveConfig.Store(&live)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+id+"/returns", bytes.NewBufferString(`{"items": [{"shortDescription": "Gatorade", "price": "6.00"}]}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/%s/returns = %v %s", id, rr.Code, rr.Body)
+	}
+
+	req := httptest.NewRequest("POST", "/receipts/"+id+"/reprocess", nil)
+	req.Header.Set("X-API-Key", "support-secret-0123456789")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("reprocessing a receipt with returns = %v %s, want 409", rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// RetentionConfig lists what gets deleted after how long. Policies run every Interval (1h by default); with DryRun
+// they, like a policy with its own DryRun, only report what they would delete.
+type RetentionConfig struct {
+	Interval Duration          `json:"interval"`
+	DryRun   bool              `json:"dryRun"`
+	Policies []RetentionPolicy `json:"policies"`
+}
+
+// RetentionPolicy deletes the data of Target once it is older than MaxAge. Name labels its metrics and audit records.
+type RetentionPolicy struct {
+	Name   string   `json:"name"`
+	Target string   `json:"target"`
+	MaxAge Duration `json:"maxAge"`
+	DryRun bool     `json:"dryRun"`
+}
+
+const defaultRetentionInterval = time.Hour
+
+func (c RetentionConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("retention: interval must not be negative")
+	}
+	names := map[string]bool{}
+	for i, p := range c.Policies {
+		if p.Name == "" {
+			return fmt.Errorf("retention: policies[%d]: name is required", i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("retention: policy %q is defined twice", p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := retentionTargets[p.Target]; !ok {
+			return fmt.Errorf("retention: policy %q: unknown target %q", p.Name, p.Target)
+		}
+		if p.MaxAge <= 0 {
+			return fmt.Errorf("retention: policy %q: maxAge must be positive", p.Name)
+		}
+	}
+	return nil
+}
+
+// retentionTarget deletes what is older than cutoff and returns how much that was. With dryRun it only counts.
+type retentionTarget func(ctx context.Context, cutoff time.Time, dryRun bool) (int, error)
+
+var retentionTargets = map[string]retentionTarget{
+	// receipts are the raw payloads in the store, points already credited stay in the ledger.
+	"receipts":        expireReceipts,
+	"idempotencyKeys": expireIdempotencyKeys,
+	// per API key, per day usage counters.
+	"apiKeyUsage": expireKeyUsage,
+}
+
+func expireReceipts(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	var expired []string
+	err := receiptStore.Scan(ctx, func(rec store.Record) error {
+		if rec.CreatedAt.Before(cutoff) {
+			expired = append(expired, rec.ID)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return len(expired), err
+	}
+	for i, id := range expired {
+		// a receipt deleted by someone else in the meantime is gone either way.
+		if err := receiptStore.Delete(ctx, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+			receiptsExpired.Add(int64(i))
+			return i, err
+		}
+	}
+	receiptsExpired.Add(int64(len(expired)))
+	return len(expired), nil
+}
+
+func expireIdempotencyKeys(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	return pointsLedger.ExpireIdempotencyKeys(cutoff, dryRun), nil
+}
+
+var (
+	retentionRunsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_retention_runs_total",
+		Help: "Retention policy runs, by policy and result (success or failure).",
+	}, []string{"policy", "result"})
+
+	retentionDeletedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_retention_deleted_total",
+		Help: "Items deleted by retention policies, by policy. Dry runs count under dry_run=\"true\" and delete nothing.",
+	}, []string{"policy", "dry_run"})
+
+	retentionLastSuccess = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fcpc_retention_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run per retention policy.",
+	}, []string{"policy"})
+)
+
+// RetentionRun is the outcome of one policy run.
+type RetentionRun struct {
+	Policy  string    `json:"policy"`
+	Target  string    `json:"target"`
+	At      time.Time `json:"at"`
+	Cutoff  time.Time `json:"cutoff"`
+	DryRun  bool      `json:"dryRun"`
+	Deleted int       `json:"deleted"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// lastRetentionRuns keeps the latest run per policy for GET /admin/retention.
+var lastRetentionRuns sync.Map
+
+// enforceRetention runs every policy once. Runs that delete anything, or would in a dry run, go to the audit trail.
+func enforceRetention(ctx context.Context, c RetentionConfig, now time.Time, forceDryRun bool) []RetentionRun {
+	runs := []RetentionRun{}
+	for _, p := range c.Policies {
+		run := RetentionRun{Policy: p.Name, Target: p.Target, At: now.UTC(), Cutoff: now.UTC().Add(-time.Duration(p.MaxAge)), DryRun: c.DryRun || p.DryRun || forceDryRun}
+		deleted, err := retentionTargets[p.Target](ctx, run.Cutoff, run.DryRun)
+		run.Deleted = deleted
+		retentionDeletedTotal.WithLabelValues(p.Name, strconv.FormatBool(run.DryRun)).Add(float64(deleted))
+		if err != nil {
+			run.Error = err.Error()
+			retentionRunsTotal.WithLabelValues(p.Name, "failure").Inc()
+			logger.Error("Retention policy failed", zap.String("policy", p.Name), zap.Int("deleted", deleted), zap.Error(err))
+		} else {
+			retentionRunsTotal.WithLabelValues(p.Name, "success").Inc()
+			retentionLastSuccess.WithLabelValues(p.Name).SetToCurrentTime()
+		}
+		if deleted > 0 {
+			logger.Info("Retention policy ran", zap.String("policy", p.Name), zap.Int("deleted", deleted), zap.Bool("dryRun", run.DryRun))
+			pointsLedger.RecordAudit("retention.run", map[string]any{
+				"policy":  p.Name,
+				"target":  p.Target,
+				"cutoff":  run.Cutoff,
+				"deleted": deleted,
+				"dryRun":  run.DryRun,
+			})
+		}
+		lastRetentionRuns.Store(p.Name, run)
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// startRetention runs the live retention policies every interval, so reloaded policies apply from the next run.
+func startRetention(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	go runPeriodically(ctx, interval, func(ctx context.Context) {
+		enforceRetention(ctx, currentConfig().Retention, time.Now(), false)
+	})
+}
+
+// retentionStatus serves GET /admin/retention: the live policies and how each of them last ran.
+func retentionStatus(w http.ResponseWriter, r *http.Request) {
+	c := currentConfig().Retention
+	type policyStatus struct {
+		RetentionPolicy
+		LastRun *RetentionRun `json:"lastRun,omitempty"`
+	}
+	response := struct {
+		DryRun   bool           `json:"dryRun"`
+		Policies []policyStatus `json:"policies"`
+	}{DryRun: c.DryRun, Policies: []policyStatus{}}
+	for _, p := range c.Policies {
+		status := policyStatus{RetentionPolicy: p}
+		if run, ok := lastRetentionRuns.Load(p.Name); ok {
+			run := run.(RetentionRun)
+			status.LastRun = &run
+		}
+		response.Policies = append(response.Policies, status)
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// runRetention serves POST /admin/retention/run, running every policy now. ?dryRun=true only reports.
+func runRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "The dryRun parameter must be true or false.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs := enforceRetention(r.Context(), currentConfig().Retention, time.Now(), dryRun)
+	jsonResponse, err := json.Marshal(map[string][]RetentionRun{"runs": runs})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestRetentionConfigValidate(t *testing.T) {
+	day := Duration(24 * time.Hour)
+	testCases := []struct {
+		name    string
+		config  RetentionConfig
+		wantErr bool
+	}{
+		{name: "empty", config: RetentionConfig{}},
+		{name: "valid", config: RetentionConfig{Policies: []RetentionPolicy{{Name: "raw", Target: "receipts", MaxAge: day}}}},
+		{name: "missing name", config: RetentionConfig{Policies: []RetentionPolicy{{Target: "receipts", MaxAge: day}}}, wantErr: true},
+		{name: "duplicate name", config: RetentionConfig{Policies: []RetentionPolicy{{Name: "raw", Target: "receipts", MaxAge: day}, {Name: "raw", Target: "idempotencyKeys", MaxAge: day}}}, wantErr: true},
+		{name: "unknown target", config: RetentionConfig{Policies: []RetentionPolicy{{Name: "raw", Target: "aggregates", MaxAge: day}}}, wantErr: true},
+		{name: "no max age", config: RetentionConfig{Policies: []RetentionPolicy{{Name: "raw", Target: "receipts"}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunRetention(t *testing.T) {
+	router := setup()
+	now := time.Now().UTC()
+	receiptStore = storetest.NewFake(
+		store.Record{ID: "old", CreatedAt: now.AddDate(0, 0, -100)},
+		store.Record{ID: "older", CreatedAt: now.AddDate(-1, 0, 0)},
+		store.Record{ID: "new", CreatedAt: now.AddDate(0, 0, -1)},
+	)
+
+	testCases := []struct {
+		name        string
+		dryRun      bool
+		query       string
+		wantDeleted int
+		wantLeft    int
+	}{
+		{name: "dry run policy", dryRun: true, wantDeleted: 2, wantLeft: 3},
+		{name: "dry run request", query: "?dryRun=true", wantDeleted: 2, wantLeft: 3},
+		{name: "deletes", wantDeleted: 2, wantLeft: 1},
+		{name: "nothing left to delete", wantDeleted: 0, wantLeft: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.Retention = RetentionConfig{Policies: []RetentionPolicy{{Name: "raw-payloads", Target: "receipts", MaxAge: Duration(90 * 24 * time.Hour), DryRun: tc.dryRun}}}
+			liveConfig.Store(&live)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/retention/run"+tc.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var got map[string][]RetentionRun
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if len(got["runs"]) != 1 || got["runs"][0].Deleted != tc.wantDeleted {
+				t.Errorf("runs = %+v, want %v deleted", got["runs"], tc.wantDeleted)
+			}
+			if left := receiptStore.(*storetest.Fake).Len(); left != tc.wantLeft {
+				t.Errorf("store has %v records, want %v", left, tc.wantLeft)
+			}
+		})
+	}
+
+	// every run that found something is audited, the last one found nothing.
+	if audit := pointsLedger.Audit(); len(audit) != 3 || audit[2].Details["dryRun"] != false {
+		t.Errorf("Audit() = %+v, want 3 retention.run records", audit)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/admin/retention", nil))
+	var status struct {
+		Policies []struct {
+			Name    string        `json:"name"`
+			LastRun *RetentionRun `json:"lastRun"`
+		} `json:"policies"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if len(status.Policies) != 1 || status.Policies[0].LastRun == nil || status.Policies[0].LastRun.Deleted != 0 {
+		t.Errorf("status = %+v, want the last run of raw-payloads", status)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ReceiptReturn is items of a receipt brought back to the store, and the points taken back for them. Points is 0
+// for receipts no account was credited for, there was nothing to take back.
+type ReceiptReturn struct {
+	ID        string    `json:"id"`
+	ReceiptID string    `json:"receiptId"`
+	Items     []ItemDTO `json:"items"`
+	Amount    string    `json:"amount"`
+	Points    int64     `json:"points"`
+	TxID      string    `json:"txId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	cents int64
+}
+
+type returnRequest struct {
+	Items []ItemDTO `json:"items"`
+}
+
+func (r returnRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Items, validation.Required, validation.Length(1, 0).Error("must contain at least one item")),
+	)
+}
+
+// returnRegistry links returns to their receipts. It also serializes returns, so two returns of the same item can't
+// both pass the check against the receipt.
+type returnRegistry struct {
+	mu        sync.Mutex
+	byReceipt map[string][]ReceiptReturn
+}
+
+var returns *returnRegistry
+
+func newReturnRegistry() *returnRegistry {
+	return &returnRegistry{byReceipt: map[string][]ReceiptReturn{}}
+}
+
+func (r *returnRegistry) list(receiptID string) []ReceiptReturn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ReceiptReturn{}, r.byReceipt[receiptID]...)
+}
+
+// notOnReceiptError is returned for returned items that aren't on the receipt, or were returned before.
+type notOnReceiptError struct {
+	item int
+}
+
+func (e notOnReceiptError) Error() string {
+	return fmt.Sprintf("item %d isn't on the receipt or was already returned", e.item)
+}
+
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// matchReturnedItems finds an item of the receipt for every returned one, same price and same description save for
+// case and padding, that wasn't returned before. It returns the cents of the returned items.
+func matchReturnedItems(receipt Receipt, previous []ReceiptReturn, returned []Item) (int64, error) {
+	taken := make([]bool, len(receipt.Items))
+	claim := func(item Item) bool {
+		for i, original := range receipt.Items {
+			if !taken[i] && cents(original.Price) == cents(item.Price) &&
+				strings.EqualFold(strings.TrimSpace(original.ShortDescription), strings.TrimSpace(item.ShortDescription)) {
+				taken[i] = true
+				return true
+			}
+		}
+		return false
+	}
+	for _, ret := range previous {
+		for _, dto := range ret.Items {
+			item, _ := dto.ToItem()
+			claim(item)
+		}
+	}
+
+	var amount int64
+	for i, item := range returned {
+		if !claim(item) {
+			return 0, notOnReceiptError{item: i}
+		}
+		amount += cents(item.Price)
+	}
+	return amount, nil
+}
+
+// returnPoints is how many more points to take back once returnedCents of the receipt's itemCents are returned in
+// total, given that deducted were taken back before. Working from the running total keeps rounding from taking back
+// more than the receipt earned: returning every item takes back exactly its points.
+func returnPoints(points, itemCents, returnedCents, deducted int64) int64 {
+	if itemCents == 0 {
+		return 0
+	}
+	return int64(math.Round(float64(points)*float64(returnedCents)/float64(itemCents))) - deducted
+}
+
+// createReturn serves POST /receipts/{id}/returns. The returned items are checked against the receipt, and the share
+// of its points they stand for (by price) is debited from the account it was credited to.
+func createReturn(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req returnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, "The request is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+	items := make([]Item, len(req.Items))
+	for i, dto := range req.Items {
+		item, err := dto.ToItem()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Item %d is invalid: %v.", i, err), http.StatusBadRequest)
+			return
+		}
+		items[i] = item
+	}
+
+	receipt, rec, err := loadReceipt(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	returns.mu.Lock()
+	defer returns.mu.Unlock()
+	unlock := lockReceiptAccount(id)
+	defer unlock()
+	if audits.pending(id) {
+		http.Error(w, "The receipt is under review, its items can be returned once it was reviewed.", http.StatusConflict)
+		return
+	}
+	if holds.pending(id) {
+		http.Error(w, "The receipt's points are on hold, its items can be returned once they settled.", http.StatusConflict)
+		return
+	}
+	previous := returns.byReceipt[id]
+	amount, err := matchReturnedItems(receipt, previous, items)
+	if err != nil {
+		http.Error(w, "The return is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	var itemCents, returnedCents, deducted int64
+	for _, item := range receipt.Items {
+		itemCents += cents(item.Price)
+	}
+	for _, ret := range previous {
+		returnedCents += ret.cents
+		deducted += ret.Points
+	}
+	ret := ReceiptReturn{
+		ID:        uuid.New().String(),
+		ReceiptID: id,
+		Items:     req.Items,
+		Amount:    fmt.Sprintf("%d.%02d", amount/100, amount%100),
+		Points:    returnPoints(rec.Points, itemCents, returnedCents+amount, deducted),
+		CreatedAt: time.Now().UTC(),
+		cents:     amount,
+	}
+
+	if ret.Points > 0 {
+		_, txID, err := pointsLedger.Return(id, ret.Points)
+		switch {
+		case errors.Is(err, ledger.ErrUnownedReceipt):
+			ret.Points = 0
+		case err != nil:
+			logger.Error("Failed to take back points", zap.String("receiptID", id), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		default:
+			ret.TxID = txID
+		}
+	}
+	returns.byReceipt[id] = append(previous, ret)
+	pointsLedger.RecordAudit("receipt.return", map[string]any{"returnId": ret.ID, "receiptId": id, "amount": ret.Amount, "points": ret.Points, "txId": ret.TxID})
+	logger.Debug("Recorded return", zap.String("receiptID", id), zap.String("returnID", ret.ID), zap.Int64("points", ret.Points))
+
+	jsonResponse, err := json.Marshal(ret)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// listReturns serves GET /receipts/{id}/returns, the returns of a receipt, oldest first.
+func listReturns(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := receiptStore.Get(r.Context(), id); errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(map[string]any{"returns": returns.list(id)})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestReturnPoints(t *testing.T) {
+	testCases := []struct {
+		name          string
+		points        int64
+		itemCents     int64
+		returnedCents int64
+		deducted      int64
+		want          int64
+	}{
+		{name: "half", points: 100, itemCents: 1000, returnedCents: 500, want: 50},
+		{name: "rounded", points: 10, itemCents: 300, returnedCents: 100, want: 3},
+		{name: "rest after rounding", points: 10, itemCents: 300, returnedCents: 300, deducted: 3 + 3, want: 4},
+		{name: "free items", points: 10, itemCents: 0, returnedCents: 0, want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := returnPoints(tc.points, tc.itemCents, tc.returnedCents, tc.deducted); got != tc.want {
+				t.Errorf("returnPoints() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReturns(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Item("Gatorade", "6.00").Item("Dasani", "4.00").Build()
+	points := submitForAccount(t, router, "alice", receipt)
+	id := pointsLedger.Receipts("alice")[0]
+
+	returnItems := func(receiptID string, items ...receipttest.Item) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"items": items})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+receiptID+"/returns", bytes.NewReader(body)))
+		return rr
+	}
+
+	testCases := []struct {
+		name        string
+		receiptID   string
+		items       []receipttest.Item
+		wantStatus  int
+		wantBalance int64
+	}{
+		{name: "one item", receiptID: id, items: []receipttest.Item{{ShortDescription: "gatorade ", Price: "6.00"}}, wantStatus: http.StatusOK, wantBalance: points - (points*6+5)/10},
+		{name: "same item again", receiptID: id, items: []receipttest.Item{{ShortDescription: "Gatorade", Price: "6.00"}}, wantStatus: http.StatusBadRequest, wantBalance: points - (points*6+5)/10},
+		{name: "wrong price", receiptID: id, items: []receipttest.Item{{ShortDescription: "Dasani", Price: "5.00"}}, wantStatus: http.StatusBadRequest, wantBalance: points - (points*6+5)/10},
+		{name: "invalid item", receiptID: id, items: []receipttest.Item{{ShortDescription: "Dasani", Price: "4"}}, wantStatus: http.StatusBadRequest, wantBalance: points - (points*6+5)/10},
+		{name: "no items", receiptID: id, wantStatus: http.StatusBadRequest, wantBalance: points - (points*6+5)/10},
+		{name: "unknown receipt", receiptID: unknownReceiptID, items: []receipttest.Item{{ShortDescription: "Dasani", Price: "4.00"}}, wantStatus: http.StatusNotFound, wantBalance: points - (points*6+5)/10},
+		{name: "the rest", receiptID: id, items: []receipttest.Item{{ShortDescription: "Dasani", Price: "4.00"}}, wantStatus: http.StatusOK, wantBalance: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := returnItems(tc.receiptID, tc.items...)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if balance, _ := pointsLedger.Balance("alice"); balance != tc.wantBalance {
+				t.Errorf("balance = %v, want %v", balance, tc.wantBalance)
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+id+"/returns", nil))
+		var resp struct{ Returns []ReceiptReturn }
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if len(resp.Returns) != 2 || resp.Returns[0].Amount != "6.00" || resp.Returns[0].TxID == "" {
+			t.Errorf("returns = %s, want the two returns with their ledger transactions", rr.Body)
+		}
+	})
+
+	t.Run("receipt without an account", func(t *testing.T) {
+		submitForAccount(t, router, "", receipt)
+		list, _ := receiptStore.List(t.Context(), store.ListOptions{Limit: 1})
+		rr := returnItems(list[0].ID, receipttest.Item{ShortDescription: "Dasani", Price: "4.00"})
+		var ret ReceiptReturn
+		json.Unmarshal(rr.Body.Bytes(), &ret)
+		if rr.Code != http.StatusOK || ret.Points != 0 {
+			t.Errorf("got %v %s, want 200 with no points taken back", rr.Code, rr.Body)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RuleHistoryConfig keeps the rule sets that were live before the current rules. With ScoreBy "purchaseDate"
+// receipts are scored under the rules that were active on their purchase date instead of the live rules, which is what
+// backfilling historical receipts needs. Versions are ordered by Until, the first day a version was no longer active;
+// receipts purchased after the last one use the live rules.
+type RuleHistoryConfig struct {
+	ScoreBy  string        `json:"scoreBy"`
+	Versions []RuleVersion `json:"versions"`
+}
+
+// RuleVersion is a rule set and the day it was replaced.
+type RuleVersion struct {
+	Until string      `json:"until"`
+	Rules RulesConfig `json:"rules"`
+}
+
+func (c RuleHistoryConfig) Validate() error {
+	if c.ScoreBy != "" && c.ScoreBy != "submission" && c.ScoreBy != "purchaseDate" {
+		return fmt.Errorf("ruleHistory: unknown scoreBy %q, must be \"submission\" or \"purchaseDate\"", c.ScoreBy)
+	}
+	for i, version := range c.Versions {
+		if _, err := time.Parse("2006-01-02", version.Until); err != nil {
+			return fmt.Errorf("ruleHistory: versions: %d: until must be a date like 2022-01-01", i)
+		}
+		if i > 0 && version.Until <= c.Versions[i-1].Until {
+			return fmt.Errorf("ruleHistory: versions: %d: until must be after the previous version's", i)
+		}
+		if err := version.Rules.Validate(); err != nil {
+			return fmt.Errorf("ruleHistory: versions: %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c RuleHistoryConfig) validateRegions(regions RegionsConfig) error {
+	for i, version := range c.Versions {
+		if err := version.Rules.validateRegions(regions); err != nil {
+			return fmt.Errorf("ruleHistory: versions: %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// rulesFor returns the rules a receipt purchased on the given day is scored under.
+func (c Config) rulesFor(purchased time.Time) RulesConfig {
+	if c.RuleHistory.ScoreBy != "purchaseDate" {
+		return c.Rules
+	}
+	// dates like 2022-01-01 sort as strings.
+	day := purchased.Format("2006-01-02")
+	for _, version := range c.RuleHistory.Versions {
+		if day < version.Until {
+			return version.Rules
+		}
+	}
+	return c.Rules
+}
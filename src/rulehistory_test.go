@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestRuleHistory(t *testing.T) {
+	setup()
+	history := RuleHistoryConfig{ScoreBy: "purchaseDate", Versions: []RuleVersion{
+		{Until: "2022-01-01", Rules: RulesConfig{"retailerName": {Multiplier: 3}}},
+		{Until: "2023-01-01", Rules: RulesConfig{"retailerName": {Multiplier: 2}}},
+	}}
+	// Target on an even day at 13:01 with a 6.49 item earns 6 retailerName points and nothing else.
+	testCases := []struct {
+		name         string
+		scoreBy      string
+		purchaseDate string
+		want         int
+	}{
+		{name: "before the first version", scoreBy: "purchaseDate", purchaseDate: "2021-06-02", want: 18},
+		{name: "on the day a version was replaced", scoreBy: "purchaseDate", purchaseDate: "2022-01-02", want: 12},
+		{name: "after the last version", scoreBy: "purchaseDate", purchaseDate: "2024-06-02", want: 6},
+		{name: "scored by submission", scoreBy: "submission", purchaseDate: "2021-06-02", want: 6},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.RuleHistory = history
+			live.RuleHistory.ScoreBy = tc.scoreBy
+			liveConfig.Store(&live)
+
+			var receipt Receipt
+			if err := json.Unmarshal(receipttest.New().PurchaseDate(tc.purchaseDate).Build().JSON(), &receipt); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := receipt.CalculatePoints(); got != tc.want {
+				t.Errorf("CalculatePoints() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleHistoryValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		history RuleHistoryConfig
+		wantErr bool
+	}{
+		{name: "empty", history: RuleHistoryConfig{}},
+		{name: "versions in order", history: RuleHistoryConfig{ScoreBy: "purchaseDate", Versions: []RuleVersion{{Until: "2022-01-01"}, {Until: "2023-01-01"}}}},
+		{name: "unknown scoreBy", history: RuleHistoryConfig{ScoreBy: "receiptDate"}, wantErr: true},
+		{name: "not a date", history: RuleHistoryConfig{Versions: []RuleVersion{{Until: "01/01/2022"}}}, wantErr: true},
+		{name: "out of order", history: RuleHistoryConfig{Versions: []RuleVersion{{Until: "2023-01-01"}, {Until: "2022-01-01"}}}, wantErr: true},
+		{name: "unknown rule", history: RuleHistoryConfig{Versions: []RuleVersion{{Until: "2022-01-01", Rules: RulesConfig{"bogus": {}}}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.history.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
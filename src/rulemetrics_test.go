@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRuleMetrics(t *testing.T) {
+	router := setup()
+	// an even day, so oddDay doesn't fire, and a 5 character retailer.
+	body := receipttest.New().Retailer("Aldis").PurchaseDate("2022-01-02").Build().JSON()
+
+	testCases := []struct {
+		name      string
+		path      string
+		rule      string
+		fired     string
+		wantCount float64
+		wantSum   float64
+	}{
+		{name: "fired", path: "/receipts/process", rule: "retailerName", fired: "true", wantCount: 1, wantSum: 5},
+		{name: "not fired", path: "/receipts/process", rule: "oddDay", fired: "false", wantCount: 1, wantSum: 0},
+		{name: "dry run", path: "/receipts/score", rule: "retailerName", fired: "true", wantCount: 0, wantSum: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			invocations := ruleInvocationsTotal.WithLabelValues(tc.rule, tc.fired)
+			before := testutil.ToFloat64(invocations)
+			beforeSum := histogramSum(t, tc.rule)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", tc.path, bytes.NewReader(body)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("POST %s = %v %s", tc.path, rr.Code, rr.Body)
+			}
+			if got := testutil.ToFloat64(invocations) - before; got != tc.wantCount {
+				t.Errorf("%s invocations{fired=%q} went up by %v, want %v", tc.rule, tc.fired, got, tc.wantCount)
+			}
+			if got := histogramSum(t, tc.rule) - beforeSum; got != tc.wantSum {
+				t.Errorf("%s points went up by %v, want %v", tc.rule, got, tc.wantSum)
+			}
+		})
+	}
+}
+
+// histogramSum returns the sum of the points rule awarded so far.
+func histogramSum(t *testing.T, rule string) float64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "fcpc_rule_points" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "rule" && label.GetValue() == rule {
+					return m.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.uber.org/zap"
+)
+
+// RulesConfig tunes the scoring rules by name, rules that aren't listed score as written. It is reloadable, so
+// candidates should go through /admin/rules/simulate first.
+type RulesConfig map[string]RuleConfig
+
+// RuleConfig tunes a single rule. Multiplier scales the points the rule awards, rounded up; 0 means 1. Regions replaces
+// the settings for receipts from the regions it lists. Points, only for the paymentMethod, metadata, custom and
+// WebAssembly rules, is what they award by payment method, by key=value of the metadata and as the rule defines. Wasm
+// adds a rule that isn't built in, scored by a WebAssembly module.
+type RuleConfig struct {
+	Disabled   bool                  `json:"disabled,omitempty"`
+	Multiplier float64               `json:"multiplier,omitempty"`
+	Points     map[string]int        `json:"points,omitempty"`
+	Regions    map[string]RuleConfig `json:"regions,omitempty"`
+	Wasm       *WasmRuleConfig       `json:"wasm,omitempty"`
+}
+
+func (c RulesConfig) Validate() error {
+	for name, rule := range c {
+		switch {
+		case !knownRule(name) && rule.Wasm == nil:
+			return fmt.Errorf("rules: unknown rule %q", name)
+		case knownRule(name) && rule.Wasm != nil:
+			return fmt.Errorf("rules: %v: only new rules can be WebAssembly rules", name)
+		case rule.Wasm != nil && !ruleName.MatchString(name):
+			return fmt.Errorf("rules: %q: WebAssembly rules need a camelCase name", name)
+		case rule.Wasm != nil:
+			if err := rule.Wasm.Validate(); err != nil {
+				return fmt.Errorf("rules: %v: %w", name, err)
+			}
+		}
+		if rule.Multiplier < 0 {
+			return fmt.Errorf("rules: %v: multiplier must not be negative", name)
+		}
+		if err := rule.validatePoints(name, rule.Wasm != nil); err != nil {
+			return fmt.Errorf("rules: %v: %w", name, err)
+		}
+		for region, scoped := range rule.Regions {
+			if scoped.Multiplier < 0 {
+				return fmt.Errorf("rules: %v: regions: %v: multiplier must not be negative", name, region)
+			}
+			if err := scoped.validatePoints(name, rule.Wasm != nil); err != nil {
+				return fmt.Errorf("rules: %v: regions: %v: %w", name, region, err)
+			}
+			if scoped.Regions != nil || scoped.Wasm != nil {
+				return fmt.Errorf("rules: %v: regions: %v can't have regions or a WebAssembly module of its own", name, region)
+			}
+		}
+	}
+	return nil
+}
+
+func (c RuleConfig) validatePoints(rule string, wasm bool) error {
+	if _, custom := customRules[rule]; c.Points != nil && rule != "paymentMethod" && rule != "metadata" && !custom && !wasm {
+		return errors.New("only the paymentMethod, metadata, custom and WebAssembly rules have points")
+	}
+	for match, points := range c.Points {
+		key, _, ok := strings.Cut(match, "=")
+		switch {
+		case rule == "paymentMethod" && !slices.Contains(paymentMethods, match):
+			return fmt.Errorf("points: unknown payment method %q", match)
+		case rule == "metadata" && (!ok || !metadataKey.MatchString(key)):
+			return fmt.Errorf("points: %q isn't a metadata key=value", match)
+		}
+		if points < 0 {
+			return fmt.Errorf("points: %v must not be negative", match)
+		}
+	}
+	return nil
+}
+
+// validateRegions checks that the regions rules are scoped to are defined.
+func (c RulesConfig) validateRegions(regions RegionsConfig) error {
+	for name, rule := range c {
+		for region := range rule.Regions {
+			if _, ok := regions[region]; !ok {
+				return fmt.Errorf("rules: %v: unknown region %q", name, region)
+			}
+		}
+	}
+	return nil
+}
+
+// in returns the settings for receipts from region, "" meaning none.
+func (c RuleConfig) in(region string) RuleConfig {
+	if scoped, ok := c.Regions[region]; ok && region != "" {
+		return scoped
+	}
+	return c
+}
+
+func knownRule(name string) bool {
+	for _, rule := range pointRules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c RuleConfig) scale(points int) int {
+	if c.Multiplier == 0 || points == 0 {
+		return points
+	}
+	return int(math.Ceil(float64(points) * c.Multiplier))
+}
+
+// simulateRequest is a candidate rules config and the receipts to try it on: either inline receipts or a filter over
+// the newest stored ones.
+type simulateRequest struct {
+	Rules    RulesConfig       `json:"rules"`
+	Receipts []json.RawMessage `json:"receipts"`
+	Filter   *receiptFilter    `json:"filter"`
+}
+
+// receiptFilter picks stored receipts among the newest Limit (maxListLimit by default). From and To are inclusive
+// purchase dates.
+type receiptFilter struct {
+	Limit    int    `json:"limit"`
+	Retailer string `json:"retailer"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// RuleImpact is what one rule awards in total before and after a change.
+type RuleImpact struct {
+	Rule   string `json:"rule"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+	Delta  int    `json:"delta"`
+}
+
+// simulation is the aggregate impact of a candidate rules config. Before is scored with the live rules, not the
+// points the receipts were awarded at the time.
+type simulation struct {
+	Receipts int          `json:"receipts"`
+	Changed  int          `json:"changed"`
+	Before   int          `json:"before"`
+	After    int          `json:"after"`
+	Delta    int          `json:"delta"`
+	Rules    []RuleImpact `json:"rules"`
+}
+
+func simulate(receipts []Receipt, before, after RulesConfig) simulation {
+	result := simulation{Receipts: len(receipts)}
+	index := map[string]int{}
+	// WebAssembly rules may only be in one of the two.
+	for _, rule := range append(scoringRules(before), scoringRules(after)...) {
+		if _, ok := index[rule.name]; !ok {
+			index[rule.name] = len(result.Rules)
+			result.Rules = append(result.Rules, RuleImpact{Rule: rule.name})
+		}
+	}
+
+	for _, receipt := range receipts {
+		scoredBefore, scoredAfter := receipt.Score(before), receipt.Score(after)
+		for _, r := range scoredBefore {
+			result.Rules[index[r.Rule]].Before += r.Points
+		}
+		for _, r := range scoredAfter {
+			result.Rules[index[r.Rule]].After += r.Points
+		}
+		pointsBefore, pointsAfter := totalPoints(scoredBefore), totalPoints(scoredAfter)
+		if pointsBefore != pointsAfter {
+			result.Changed++
+		}
+		result.Before += pointsBefore
+		result.After += pointsAfter
+	}
+
+	result.Delta = result.After - result.Before
+	for i := range result.Rules {
+		result.Rules[i].Delta = result.Rules[i].After - result.Rules[i].Before
+	}
+	return result
+}
+
+// storedReceipts returns the stored receipts matching f.
+func storedReceipts(r *http.Request, f receiptFilter) ([]Receipt, error) {
+	var from, to time.Time
+	var err error
+	if f.From != "" {
+		if from, err = time.Parse("2006-01-02", f.From); err != nil {
+			return nil, validation.Errors{"filter.from": validation.NewError("validation_date", "must be a date like 2022-01-01")}
+		}
+	}
+	if f.To != "" {
+		if to, err = time.Parse("2006-01-02", f.To); err != nil {
+			return nil, validation.Errors{"filter.to": validation.NewError("validation_date", "must be a date like 2022-01-01")}
+		}
+	}
+	if f.Limit == 0 {
+		f.Limit = maxListLimit
+	}
+	if f.Limit < 0 || f.Limit > maxListLimit {
+		return nil, validation.Errors{"filter.limit": validation.NewError("validation_limit", "must be between 1 and "+strconv.Itoa(maxListLimit))}
+	}
+
+	records, err := receiptStore.List(r.Context(), store.ListOptions{Limit: f.Limit})
+	if err != nil {
+		return nil, err
+	}
+	var receipts []Receipt
+	for _, rec := range records {
+		var receipt Receipt
+		if err := json.Unmarshal(rec.Receipt, &receipt); err != nil {
+			return nil, fmt.Errorf("decoding stored receipt %v: %w", rec.ID, err)
+		}
+		if f.Retailer != "" && !strings.EqualFold(receipt.Retailer, f.Retailer) {
+			continue
+		}
+		if !from.IsZero() && receipt.PurchaseDate.Before(from) || !to.IsZero() && receipt.PurchaseDate.After(to) {
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+// simulateRules serves POST /admin/rules/simulate, which reports what a candidate rules config would do to points
+// before anyone activates it. Nothing is stored and the live rules don't change.
+func simulateRules(w http.ResponseWriter, r *http.Request) {
+	var req simulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := errors.Join(req.Rules.Validate(), req.Rules.validateRegions(currentConfig().Regions)); err != nil {
+		http.Error(w, "The rules are invalid: "+strings.TrimPrefix(err.Error(), "rules: ")+".", http.StatusBadRequest)
+		return
+	}
+	if (req.Receipts == nil) == (req.Filter == nil) {
+		http.Error(w, "Either receipts or a filter over the stored receipts is required.", http.StatusBadRequest)
+		return
+	}
+	if len(req.Receipts) > maxListLimit {
+		http.Error(w, "At most "+strconv.Itoa(maxListLimit)+" receipts can be simulated at once.", http.StatusBadRequest)
+		return
+	}
+
+	var receipts []Receipt
+	errs := validation.Errors{}
+	for i, raw := range req.Receipts {
+		var receipt Receipt
+		if err := json.Unmarshal(raw, &receipt); err != nil {
+			errs["receipts."+strconv.Itoa(i)] = err
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+	if req.Filter != nil {
+		stored, err := storedReceipts(r, *req.Filter)
+		var filterErrs validation.Errors
+		if errors.As(err, &filterErrs) {
+			errs = filterErrs
+		} else if err != nil {
+			logger.Error("Failed to load receipts to simulate", zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		receipts = stored
+	}
+
+	status := http.StatusOK
+	var response any
+	if len(errs) > 0 {
+		status = http.StatusBadRequest
+		response = map[string]validation.Errors{"errors": errs}
+	} else {
+		response = simulate(receipts, currentConfig().Rules, req.Rules)
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestRulesConfig(t *testing.T) {
+	setup()
+	// Target on an odd day with one pizza: 6 retailer, 25 multiple of 0.25, 3 description, 6 odd day.
+	var receipt Receipt
+	json.Unmarshal(receipttest.New().Item("Emils Cheese Pizza", "12.25").Build().JSON(), &receipt)
+
+	testCases := []struct {
+		name       string
+		rules      RulesConfig
+		wantErr    bool
+		wantPoints int
+	}{
+		{name: "default", rules: nil, wantPoints: 40},
+		{name: "disabled", rules: RulesConfig{"oddDay": {Disabled: true}}, wantPoints: 34},
+		{name: "multiplier", rules: RulesConfig{"oddDay": {Multiplier: 2}}, wantPoints: 46},
+		{name: "item multiplier rounds up per item", rules: RulesConfig{"itemDescription": {Multiplier: 1.5}}, wantPoints: 42},
+		{name: "unknown rule", rules: RulesConfig{"keyword": {Disabled: true}}, wantErr: true},
+		{name: "negative multiplier", rules: RulesConfig{"oddDay": {Multiplier: -1}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rules.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := totalPoints(receipt.Score(tc.rules)); got != tc.wantPoints {
+				t.Errorf("points = %v, want %v", got, tc.wantPoints)
+			}
+
+			// the live config is what submissions are scored with.
+			live := cfg
+			live.Rules = tc.rules
+			liveConfig.Store(&live)
+			defer liveConfig.Store(&cfg)
+			if got := receipt.CalculatePoints(); got != tc.wantPoints {
+				t.Errorf("CalculatePoints() = %v, want %v", got, tc.wantPoints)
+			}
+		})
+	}
+}
+
+func TestSimulateRules(t *testing.T) {
+	router := setup()
+	odd := receipttest.New().PurchaseDate("2022-01-01").Build()
+	even := receipttest.New().PurchaseDate("2022-01-02").Build()
+	for _, receipt := range []receipttest.Receipt{odd, even, odd} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON())))
+	}
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantResult simulation
+		wantOddDay RuleImpact
+	}{
+		{
+			name:       "inline receipts",
+			body:       `{"rules": {"oddDay": {"multiplier": 2}}, "receipts": [` + string(odd.JSON()) + `, ` + string(even.JSON()) + `]}`,
+			wantStatus: http.StatusOK,
+			wantResult: simulation{Receipts: 2, Changed: 1, Delta: 6},
+			wantOddDay: RuleImpact{Rule: "oddDay", Before: 6, After: 12, Delta: 6},
+		},
+		{
+			name:       "stored receipts",
+			body:       `{"rules": {"oddDay": {"disabled": true}}, "filter": {"from": "2022-01-01", "to": "2022-01-01"}}`,
+			wantStatus: http.StatusOK,
+			wantResult: simulation{Receipts: 2, Changed: 2, Delta: -12},
+			wantOddDay: RuleImpact{Rule: "oddDay", Before: 12, After: 0, Delta: -12},
+		},
+		{name: "unknown rule", body: `{"rules": {"keyword": {}}, "receipts": []}`, wantStatus: http.StatusBadRequest},
+		{name: "no receipts", body: `{"rules": {}}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid receipt", body: `{"rules": {}, "receipts": [{"retailer": "Target"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid filter", body: `{"rules": {}, "filter": {"from": "yesterday"}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/rules/simulate", bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got simulation
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			if got.Receipts != tc.wantResult.Receipts || got.Changed != tc.wantResult.Changed || got.Delta != tc.wantResult.Delta || got.After-got.Before != got.Delta {
+				t.Errorf("simulation = %+v, want %+v", got, tc.wantResult)
+			}
+			for _, impact := range got.Rules {
+				if impact.Rule == "oddDay" && impact != tc.wantOddDay {
+					t.Errorf("oddDay impact = %+v, want %+v", impact, tc.wantOddDay)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// RuleTest is an example receipt and the points the rules must award it, in total and, for the rules in Rules, by
+// rule. Tests run whenever the config is loaded or reloaded, so rules that would mis-score them never go live.
+type RuleTest struct {
+	Name    string          `json:"name"`
+	Receipt json.RawMessage `json:"receipt"`
+	Points  int             `json:"points"`
+	Rules   map[string]int  `json:"rules,omitempty"`
+}
+
+// run returns what is wrong with the points c awards the test's receipt, nil if nothing. The receipt is scored like a
+// submitted one, by the rules for its purchase date and with the regions of c.
+func (t RuleTest) run(c Config) error {
+	if t.Name == "" {
+		return errors.New("a test needs a name")
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(t.Receipt, &receipt); err != nil {
+		return fmt.Errorf("%s: receipt: %w", t.Name, err)
+	}
+	results := receipt.scoreIn(c.rulesFor(receipt.PurchaseDate), c.Regions)
+	var errs []error
+	if points := totalPoints(results); points != t.Points {
+		errs = append(errs, fmt.Errorf("%s: awarded %d points, want %d", t.Name, points, t.Points))
+	}
+	for _, rule := range slices.Sorted(maps.Keys(t.Rules)) {
+		awarded := 0
+		if i := slices.IndexFunc(results, func(r RuleResult) bool { return r.Rule == rule }); i >= 0 {
+			awarded = results[i].Points
+		}
+		if awarded != t.Rules[rule] {
+			errs = append(errs, fmt.Errorf("%s: %s awarded %d points, want %d", t.Name, rule, awarded, t.Rules[rule]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runRuleTests runs tests under c and returns the failures, one per failing test.
+func runRuleTests(c Config, tests []RuleTest) []error {
+	var failures []error
+	for _, t := range tests {
+		if err := t.run(c); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return failures
+}
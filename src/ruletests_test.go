@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRuleTestsOnLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "passing", config: `{"ruleTests": [{"name": "challenge", "receipt": ` + checkReceipt + `, "points": 109, "rules": {"afternoonPurchase": 10, "oddDay": 0}}]}`},
+		{name: "wrong total", config: `{"ruleTests": [{"name": "challenge", "receipt": ` + checkReceipt + `, "points": 100}]}`, wantErr: "challenge: awarded 109 points, want 100"},
+		{
+			name:    "rule changed under the test",
+			config:  `{"rules": {"afternoonPurchase": {"multiplier": 2}}, "ruleTests": [{"name": "challenge", "receipt": ` + checkReceipt + `, "points": 109, "rules": {"afternoonPurchase": 10}}]}`,
+			wantErr: "challenge: afternoonPurchase awarded 20 points, want 10",
+		},
+		{name: "invalid receipt", config: `{"ruleTests": [{"name": "empty", "receipt": {}, "points": 0}]}`, wantErr: "empty: receipt:"},
+		{name: "no name", config: `{"ruleTests": [{"receipt": ` + checkReceipt + `, "points": 109}]}`, wantErr: "needs a name"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tc.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfig(path)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("loadConfig() error = %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("loadConfig() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
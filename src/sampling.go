@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AuditSamplingConfig marks a random share of incoming receipts for manual review. With Weight "points" or "total"
+// receipts are sampled in proportion to their points or total, so high-value receipts are reviewed more often while
+// SampleRate stays the overall share. The points of sampled receipts are only credited once a reviewer approves them.
+type AuditSamplingConfig struct {
+	SampleRate float64 `json:"sampleRate"`
+	Weight     string  `json:"weight"`
+}
+
+func (c AuditSamplingConfig) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("auditSampling: sampleRate must be between 0 and 1")
+	}
+	if c.Weight != "" && c.Weight != "points" && c.Weight != "total" {
+		return fmt.Errorf("auditSampling: unknown weight %q, must be \"points\" or \"total\"", c.Weight)
+	}
+	return nil
+}
+
+const (
+	auditPending  = "pending"
+	auditApproved = "approved"
+	auditRejected = "rejected"
+)
+
+// AuditItem is a receipt sampled for review. Points are what it earns if approved. Reason is the fraud signal that
+// held it, if it wasn't sampled.
+type AuditItem struct {
+	ReceiptID  string     `json:"receiptId"`
+	Reason     string     `json:"reason,omitempty"`
+	Account    string     `json:"account,omitempty"`
+	Points     int64      `json:"points"`
+	Status     string     `json:"status"`
+	SampledAt  time.Time  `json:"sampledAt"`
+	Reviewer   string     `json:"reviewer,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+	TxID       string     `json:"txId,omitempty"`
+}
+
+// auditQueue holds the sampled receipts, and the running sums weighted sampling needs.
+type auditQueue struct {
+	mu     sync.Mutex
+	items  map[string]*AuditItem
+	order  []string
+	seen   int
+	points float64
+	totals float64
+}
+
+var audits *auditQueue
+
+func newAuditQueue() *auditQueue {
+	return &auditQueue{items: map[string]*AuditItem{}}
+}
+
+// auditProbability is the chance to sample a receipt of the given weight when the average receipt weighs mean, so
+// that receipts are sampled at rate on average.
+func auditProbability(rate, weight, mean float64) float64 {
+	if mean <= 0 {
+		return rate
+	}
+	return min(1, rate*weight/mean)
+}
+
+// sample decides whether a receipt that earned points goes to review, and queues it if so.
+func (q *auditQueue) sample(c AuditSamplingConfig, receipt Receipt, receiptID, account string, points int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seen++
+	q.points += float64(points)
+	q.totals += receipt.Total
+	p := c.SampleRate
+	switch c.Weight {
+	case "points":
+		p = auditProbability(c.SampleRate, float64(points), q.points/float64(q.seen))
+	case "total":
+		p = auditProbability(c.SampleRate, receipt.Total, q.totals/float64(q.seen))
+	}
+	if p == 0 || rand.Float64() >= p {
+		return false
+	}
+
+	q.items[receiptID] = &AuditItem{ReceiptID: receiptID, Account: account, Points: int64(points), Status: auditPending, SampledAt: time.Now().UTC()}
+	q.order = append(q.order, receiptID)
+	return true
+}
+
+// hold queues a receipt for review whatever the sampling, because reason, a fraud signal, fired for it.
+func (q *auditQueue) hold(receiptID, account string, points int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[receiptID] = &AuditItem{ReceiptID: receiptID, Reason: reason, Account: account, Points: int64(points), Status: auditPending, SampledAt: time.Now().UTC()}
+	q.order = append(q.order, receiptID)
+}
+
+// drop takes a receipt out of the queue, for one that was never stored after all.
+func (q *auditQueue) drop(receiptID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[receiptID]; !ok {
+		return
+	}
+	delete(q.items, receiptID)
+	q.order = slices.DeleteFunc(q.order, func(id string) bool { return id == receiptID })
+}
+
+// erase takes the receipts of an erased account out of the queue, reviewed or not, since approving one would credit the
+// account again. It returns those that were never credited, which aren't among receipts, for the erasure to delete
+// them from the store too.
+func (q *auditQueue) erase(account string, receipts []string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var uncredited []string
+	q.order = slices.DeleteFunc(q.order, func(id string) bool {
+		if item := q.items[id]; item.Account != account && !slices.Contains(receipts, id) {
+			return false
+		}
+		if !slices.Contains(receipts, id) {
+			uncredited = append(uncredited, id)
+		}
+		delete(q.items, id)
+		return true
+	})
+	return uncredited
+}
+
+// pending reports whether the receipt is waiting for review.
+func (q *auditQueue) pending(receiptID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[receiptID]
+	return ok && item.Status == auditPending
+}
+
+// list returns the sampled receipts with the given status, or all of them, oldest first.
+func (q *auditQueue) list(status string) []AuditItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := []AuditItem{}
+	for _, id := range q.order {
+		if item := q.items[id]; status == "" || item.Status == status {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+type reviewRequest struct {
+	Decision string `json:"decision"`
+	Reviewer string `json:"reviewer"`
+}
+
+func (r reviewRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Decision, validation.Required, validation.In("approve", "reject")),
+		validation.Field(&r.Reviewer, validation.Required, validation.Length(1, 100)),
+	)
+}
+
+// listAudits serves GET /admin/audits, the review queue. It has the pending receipts unless ?status= asks for the
+// approved, rejected or "all" of them.
+func listAudits(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = auditPending
+	case "all":
+		status = ""
+	case auditPending, auditApproved, auditRejected:
+	default:
+		http.Error(w, "The status must be pending, approved, rejected or all.", http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(map[string]any{"audits": audits.list(status)})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// reviewAudit serves POST /admin/audits/{id}, a reviewer's decision on a sampled receipt. Approving credits its
+// points, with what it earns now in case it was amended while waiting. Rejecting sets them to 0.
+func reviewAudit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The request is invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, "The request is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+
+	// returns.mu keeps amendments from changing the receipt while it is reviewed.
+	returns.mu.Lock()
+	defer returns.mu.Unlock()
+	unlock := lockReceiptAccount(id)
+	defer unlock()
+	audits.mu.Lock()
+	defer audits.mu.Unlock()
+	item, ok := audits.items[id]
+	if !ok {
+		http.Error(w, "No sampled receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	if item.Status != auditPending {
+		http.Error(w, "The receipt was already "+item.Status+".", http.StatusConflict)
+		return
+	}
+
+	rec, err := receiptStore.Get(r.Context(), id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		logger.Error("Failed to load receipt", zap.String("receiptID", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	// a receipt deleted while waiting, e.g. by an erasure, earns nothing.
+	points := rec.Points
+	if req.Decision == "reject" || errors.Is(err, store.ErrNotFound) {
+		points = 0
+	}
+	if err == nil && points != rec.Points {
+		rec.Points = points
+		if err := receiptStore.Update(r.Context(), rec); err != nil && !errors.Is(err, store.ErrNotFound) {
+			logger.Error("Failed to update receipt", zap.String("receiptID", id), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	status := auditRejected
+	if req.Decision == "approve" {
+		status = auditApproved
+	}
+	if points > 0 {
+		account, txID, err := pointsLedger.Release(id, points)
+		switch {
+		case errors.Is(err, ledger.ErrUnownedReceipt):
+		case err != nil:
+			logger.Error("Failed to release points", zap.String("receiptID", id), zap.Error(err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		default:
+			item.TxID = txID
+			events.Publish(Event{Type: EventPointsEarned, Account: account, ReceiptID: id, Points: points})
+		}
+	}
+	now := time.Now().UTC()
+	item.Status, item.Points, item.Reviewer, item.ReviewedAt = status, points, req.Reviewer, &now
+	pointsLedger.RecordAudit("receipt.review", map[string]any{"receiptId": id, "decision": req.Decision, "reviewer": req.Reviewer, "points": points})
+	logger.Info("Reviewed sampled receipt", zap.String("receiptID", id), zap.String("status", status), zap.String("reviewer", req.Reviewer), zap.Int64("points", points))
+
+	jsonResponse, err := json.Marshal(item)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
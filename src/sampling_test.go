@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestAuditProbability(t *testing.T) {
+	testCases := []struct {
+		name   string
+		rate   float64
+		weight float64
+		mean   float64
+		want   float64
+	}{
+		{name: "average receipt", rate: 0.1, weight: 50, mean: 50, want: 0.1},
+		{name: "twice the average", rate: 0.1, weight: 100, mean: 50, want: 0.2},
+		{name: "capped", rate: 0.5, weight: 500, mean: 50, want: 1},
+		{name: "worth nothing", rate: 0.1, weight: 0, mean: 50, want: 0},
+		{name: "no receipts yet", rate: 0.1, weight: 0, mean: 0, want: 0.1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := auditProbability(tc.rate, tc.weight, tc.mean); got != tc.want {
+				t.Errorf("auditProbability() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditSampling(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.AuditSampling = AuditSamplingConfig{SampleRate: 1}
+	liveConfig.Store(&live)
+
+	var ids []string
+	for _, description := range []string{"Gatorade", "Dasani"} {
+		req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Item(description, "6.00").Build().JSON()))
+		req.Header.Set("X-Account-ID", "alice")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp struct {
+			ID          string
+			UnderReview bool
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if !resp.UnderReview {
+			t.Fatalf("response = %s, want the receipt under review", rr.Body)
+		}
+		ids = append(ids, resp.ID)
+	}
+	if balance, _ := pointsLedger.Balance("alice"); balance != 0 {
+		t.Fatalf("balance = %v, want nothing credited before the review", balance)
+	}
+	rec, _ := receiptStore.Get(t.Context(), ids[0])
+	points := rec.Points
+
+	t.Run("queue", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest("GET", "/admin/audits", nil))
+		var resp struct{ Audits []AuditItem }
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if len(resp.Audits) != 2 || resp.Audits[0].ReceiptID != ids[0] || resp.Audits[0].Account != "alice" {
+			t.Errorf("queue = %s, want both receipts, oldest first", rr.Body)
+		}
+	})
+
+	t.Run("receipt under review can't be returned", func(t *testing.T) {
+		body := `{"items": [{"shortDescription": "Gatorade", "price": "6.00"}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/"+ids[0]+"/returns", bytes.NewBufferString(body)))
+		if rr.Code != http.StatusConflict {
+			t.Errorf("got %v %s, want 409", rr.Code, rr.Body)
+		}
+	})
+
+	testCases := []struct {
+		name        string
+		receiptID   string
+		body        string
+		wantStatus  int
+		wantBalance int64
+	}{
+		{name: "unknown decision", receiptID: ids[0], body: `{"decision": "maybe", "reviewer": "sam"}`, wantStatus: http.StatusBadRequest},
+		{name: "no reviewer", receiptID: ids[0], body: `{"decision": "approve"}`, wantStatus: http.StatusBadRequest},
+		{name: "not sampled", receiptID: "nope", body: `{"decision": "approve", "reviewer": "sam"}`, wantStatus: http.StatusNotFound},
+		{name: "approve", receiptID: ids[0], body: `{"decision": "approve", "reviewer": "sam"}`, wantStatus: http.StatusOK, wantBalance: points},
+		{name: "approve again", receiptID: ids[0], body: `{"decision": "approve", "reviewer": "sam"}`, wantStatus: http.StatusConflict, wantBalance: points},
+		{name: "reject", receiptID: ids[1], body: `{"decision": "reject", "reviewer": "sam"}`, wantStatus: http.StatusOK, wantBalance: points},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("POST", "/admin/audits/"+tc.receiptID, bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if balance, _ := pointsLedger.Balance("alice"); balance != tc.wantBalance {
+				t.Errorf("balance = %v, want %v", balance, tc.wantBalance)
+			}
+		})
+	}
+
+	if rec, _ := receiptStore.Get(t.Context(), ids[1]); rec.Points != 0 {
+		t.Errorf("rejected receipt points = %v, want 0", rec.Points)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/admin/audits?status=all", nil))
+	var resp struct{ Audits []AuditItem }
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Audits) != 2 || resp.Audits[0].Status != auditApproved || resp.Audits[1].Status != auditRejected || resp.Audits[0].TxID == "" {
+		t.Errorf("queue = %s, want the approved and the rejected receipt", rr.Body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// checkReceipt is the well-known example from the challenge. If it stops scoring checkPoints the rules changed.
+const checkReceipt = `{
+	"retailer": "M&M Corner Market",
+	"purchaseDate": "2022-03-20",
+	"purchaseTime": "14:33",
+	"items": [
+		{"shortDescription": "Gatorade", "price": "2.25"},
+		{"shortDescription": "Gatorade", "price": "2.25"},
+		{"shortDescription": "Gatorade", "price": "2.25"},
+		{"shortDescription": "Gatorade", "price": "2.25"}
+	],
+	"total": "9.00"
+}`
+
+const checkPoints = 109
+
+// selfCheck is what --check runs before a deploy or as a container init step: the config at configPath must load,
+// the configured store must be reachable, and the canned receipt must still score as expected. Nothing is written.
+func selfCheck(ctx context.Context, configPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	c, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	s, err := store.Open(ctx, c.Store.Backend, c.Store.DSN)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	defer s.Close()
+	// a read of an ID that can't exist proves the backend answers queries, not just that it accepted a connection.
+	if _, err := s.Get(ctx, "fcpc-self-check"); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal([]byte(checkReceipt), &receipt); err != nil {
+		return fmt.Errorf("scoring: canned receipt rejected: %w", err)
+	}
+	// the scoring code itself is checked, a rules config changing the points is fine.
+	if got := totalPoints(receipt.Score(nil)); got != checkPoints {
+		return fmt.Errorf("scoring: canned receipt scored %d points, want %d", got, checkPoints)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfCheck(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+
+	testCases := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "defaults", config: `{}`, wantErr: false},
+		{name: "malformed config", config: `{`, wantErr: true},
+		{name: "invalid consumer", config: `{"consumer": {"type": "carrier-pigeon"}}`, wantErr: true},
+		{name: "unknown store", config: `{"store": {"backend": "floppy"}}`, wantErr: true},
+		{name: "unreachable store", config: `{"store": {"backend": "redis", "dsn": "redis://127.0.0.1:1"}}`, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tc.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := selfCheck(context.Background(), path)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("selfCheck() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
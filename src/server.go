@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ServerConfig tunes the HTTP server. Listen is where it accepts connections: a TCP address (":8000" by default) or
+// "unix:" and a socket path, created with SocketMode (e.g. "0660"). Under systemd socket activation the passed socket
+// is used instead. ShutdownTimeout is how long in-flight requests get to finish on SIGTERM (30s by default).
+//
+// Every timeout has a default, unlike a bare http.ListenAndServe, so a client that
+// trickles its headers or body (slowloris) can't hold a connection forever; "0s" turns a timeout off.
+//
+// ReadHeaderTimeout and ReadTimeout bound reading the headers and the whole request, WriteTimeout writing the
+// response, IdleTimeout how long a kept-alive connection may sit between requests. DisableKeepAlives closes every
+// connection after one request. TCPKeepAlive is the interval of TCP keep-alive probes on accepted connections, a
+// negative value turns them off. HTTP2 serves HTTP/2 without TLS (h2c, prior knowledge) next to HTTP/1, for load
+// balancers that speak it to their backends; HTTP2MaxConcurrentStreams caps the streams per connection.
+type ServerConfig struct {
+	Listen                    string    `json:"listen"`
+	SocketMode                string    `json:"socketMode"`
+	ShutdownTimeout           Duration  `json:"shutdownTimeout"`
+	ReadHeaderTimeout         *Duration `json:"readHeaderTimeout"`
+	ReadTimeout               *Duration `json:"readTimeout"`
+	WriteTimeout              *Duration `json:"writeTimeout"`
+	IdleTimeout               *Duration `json:"idleTimeout"`
+	MaxHeaderBytes            int       `json:"maxHeaderBytes"`
+	DisableKeepAlives         bool      `json:"disableKeepAlives"`
+	TCPKeepAlive              Duration  `json:"tcpKeepAlive"`
+	HTTP2                     bool      `json:"http2"`
+	HTTP2MaxConcurrentStreams int       `json:"http2MaxConcurrentStreams"`
+}
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultListen            = ":8000"
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+func (c ServerConfig) Validate() error {
+	for name, d := range map[string]*Duration{"readHeaderTimeout": c.ReadHeaderTimeout, "readTimeout": c.ReadTimeout, "writeTimeout": c.WriteTimeout, "idleTimeout": c.IdleTimeout} {
+		if d != nil && *d < 0 {
+			return fmt.Errorf("server: %s must not be negative", name)
+		}
+	}
+	if c.MaxHeaderBytes < 0 || c.HTTP2MaxConcurrentStreams < 0 {
+		return fmt.Errorf("server: maxHeaderBytes and http2MaxConcurrentStreams must not be negative")
+	}
+	if c.HTTP2MaxConcurrentStreams > 0 && !c.HTTP2 {
+		return fmt.Errorf("server: http2MaxConcurrentStreams needs http2")
+	}
+	if path, ok := strings.CutPrefix(c.Listen, "unix:"); ok && path == "" {
+		return fmt.Errorf("server: listen needs a socket path after unix:")
+	}
+	if c.SocketMode != "" {
+		if _, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil {
+			return fmt.Errorf("server: socketMode must be octal like \"0660\"")
+		}
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("server: shutdownTimeout must not be negative")
+	}
+	return nil
+}
+
+func (c ServerConfig) listen() string {
+	if c.Listen == "" {
+		return defaultListen
+	}
+	return c.Listen
+}
+
+func (c ServerConfig) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeout)
+}
+
+func timeoutOr(d *Duration, fallback time.Duration) time.Duration {
+	if d == nil {
+		return fallback
+	}
+	return time.Duration(*d)
+}
+
+// newServer builds the server for handler from c.
+func newServer(c ServerConfig, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: timeoutOr(c.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       timeoutOr(c.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      timeoutOr(c.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       timeoutOr(c.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+	if server.MaxHeaderBytes == 0 {
+		server.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+	if c.HTTP2 {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+		server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: c.HTTP2MaxConcurrentStreams}
+	}
+	server.SetKeepAlivesEnabled(!c.DisableKeepAlives)
+	return server
+}
+
+// listen opens the listener c asks for, or takes over the one systemd passed. It returns a description of it for
+// the logs.
+func listen(ctx context.Context, c ServerConfig) (net.Listener, string, error) {
+	if ln, err := activatedListener(); ln != nil || err != nil {
+		return ln, "systemd socket " + addrOf(ln), err
+	}
+
+	path, unix := strings.CutPrefix(c.listen(), "unix:")
+	if !unix {
+		lc := net.ListenConfig{KeepAlive: time.Duration(c.TCPKeepAlive)}
+		ln, err := lc.Listen(ctx, "tcp", c.listen())
+		return ln, "port " + addrOf(ln), err
+	}
+
+	// a socket file left behind by a crash would make the listen fail. Anything else at the path is left alone.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.SocketMode != "" {
+		mode, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, "", err
+		}
+	}
+	return ln, "socket " + path, nil
+}
+
+func addrOf(ln net.Listener) string {
+	if ln == nil {
+		return ""
+	}
+	return ln.Addr().String()
+}
+
+// listenFDsStart is the first file descriptor systemd passes, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// activatedListener returns the socket systemd passed with socket activation (LISTEN_PID and LISTEN_FDS), or nil
+// when there is none. Only the first socket is used.
+func activatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// so child processes don't think the sockets are theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(listenFDsStart), "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("using the socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// notifySystemd sends state (e.g. "READY=1") to systemd when it runs the service with Type=notify. Without
+// NOTIFY_SOCKET it does nothing.
+func notifySystemd(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// serveUntil serves on ln until ctx is done, then stops accepting and gives in-flight requests up to
+// c.shutdownTimeout to finish. With socket activation systemd keeps the socket open in between, so a restart drops
+// no connections.
+func serveUntil(ctx context.Context, c ServerConfig, server *http.Server, ln net.Listener) error {
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(ln) }()
+	if err := notifySystemd("READY=1"); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	notifySystemd("STOPPING=1")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout())
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// extendDeadlines lifts the server's read and write timeouts for one request, for the few handlers that stream
+// archives far larger than a normal request.
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
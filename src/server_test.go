@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewServer(t *testing.T) {
+	zero, minute := Duration(0), Duration(time.Minute)
+
+	testCases := []struct {
+		name            string
+		config          ServerConfig
+		wantReadHeader  time.Duration
+		wantWrite       time.Duration
+		wantHeaderBytes int
+		wantHTTP2       bool
+	}{
+		{name: "defaults", wantReadHeader: defaultReadHeaderTimeout, wantWrite: defaultWriteTimeout, wantHeaderBytes: defaultMaxHeaderBytes},
+		{name: "overrides", config: ServerConfig{ReadHeaderTimeout: &minute, WriteTimeout: &zero, MaxHeaderBytes: 1024}, wantReadHeader: time.Minute, wantWrite: 0, wantHeaderBytes: 1024},
+		{name: "http2", config: ServerConfig{HTTP2: true}, wantReadHeader: defaultReadHeaderTimeout, wantWrite: defaultWriteTimeout, wantHeaderBytes: defaultMaxHeaderBytes, wantHTTP2: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newServer(tc.config, http.NotFoundHandler())
+			if server.ReadHeaderTimeout != tc.wantReadHeader || server.WriteTimeout != tc.wantWrite || server.MaxHeaderBytes != tc.wantHeaderBytes {
+				t.Errorf("server = {readHeader: %v, write: %v, maxHeaderBytes: %v}, want {%v, %v, %v}",
+					server.ReadHeaderTimeout, server.WriteTimeout, server.MaxHeaderBytes, tc.wantReadHeader, tc.wantWrite, tc.wantHeaderBytes)
+			}
+			if got := server.Protocols != nil && server.Protocols.UnencryptedHTTP2(); got != tc.wantHTTP2 {
+				t.Errorf("unencrypted HTTP/2 = %v, want %v", got, tc.wantHTTP2)
+			}
+		})
+	}
+}
+
+// serve runs a server built from c on a free local port and returns its address.
+func serve(t *testing.T, c ServerConfig) string {
+	t.Helper()
+	if c.Listen == "" {
+		c.Listen = "127.0.0.1:0"
+	}
+	ln, _, err := listen(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+func TestServerHTTP2(t *testing.T) {
+	addr := serve(t, ServerConfig{HTTP2: true})
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("served over %v, want HTTP/2", resp.Proto)
+	}
+}
+
+func TestServerDropsSlowHeaders(t *testing.T) {
+	timeout := Duration(50 * time.Millisecond)
+	addr := serve(t, ServerConfig{ReadHeaderTimeout: &timeout})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the headers never finish.
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection wasn't closed by the server: %v", err)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fcpc.sock")
+	// a stale socket from a previous run.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	serve(t, ServerConfig{Listen: "unix:" + path, SocketMode: "0660"})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode = %v, want 0660", info.Mode().Perm())
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}}}
+	resp, err := client.Get("http://fcpc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestListenIgnoresOtherProcessesSockets(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	ln, where, err := listen(context.Background(), ServerConfig{Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if where != "port "+ln.Addr().String() {
+		t.Errorf("listening on %q, want the configured port", where)
+	}
+}
+
+func TestServeUntilFinishesRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := newServer(ServerConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- serveUntil(ctx, ServerConfig{}, server, ln) }()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-started
+	cancel()
+
+	if got := <-responses; got != "done" {
+		t.Errorf("in-flight request got %q, want done", got)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("serveUntil = %v, want nil", err)
+	}
+}
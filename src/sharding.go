@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ShardingConfig splits the receipts and accounts across several deployments, each with a store of its own. Shards
+// maps every shard's name to its base URL, Self names this one. A request for a receipt or account another shard owns
+// is forwarded to it, the owner picked by consistent hashing of the ID, so adding a shard only moves the keys the new
+// one takes over. Previous is the shard map before the latest change: while set, receipts not yet moved are looked up
+// on their previous owner. Sharding is off without Shards.
+type ShardingConfig struct {
+	Self         string            `json:"self"`
+	Shards       map[string]string `json:"shards"`
+	Previous     map[string]string `json:"previous"`
+	VirtualNodes int               `json:"virtualNodes"`
+}
+
+// defaultVirtualNodes spreads the keys within a few percent of even for a handful of shards.
+const defaultVirtualNodes = 128
+
+// shardHopHeader marks forwarded requests, which the receiving shard always serves itself so a disagreement about
+// the shard map can't forward a request in circles.
+const shardHopHeader = "X-Fcpc-Shard"
+
+func (c ShardingConfig) Validate() error {
+	if len(c.Shards) == 0 {
+		return nil
+	}
+	if _, ok := c.Shards[c.Self]; !ok {
+		return fmt.Errorf("sharding: self must be one of the shards")
+	}
+	if c.VirtualNodes < 0 {
+		return fmt.Errorf("sharding: virtualNodes must not be negative")
+	}
+	for _, shards := range []map[string]string{c.Shards, c.Previous} {
+		for name, base := range shards {
+			if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("sharding: %v: %q is not a URL", name, base)
+			}
+		}
+	}
+	return nil
+}
+
+func (c ShardingConfig) virtualNodes() int {
+	if c.VirtualNodes == 0 {
+		return defaultVirtualNodes
+	}
+	return c.VirtualNodes
+}
+
+// hashRing places virtualNodes points per shard on a ring of 64-bit hashes. A key belongs to the shard of the first
+// point at or after its own hash.
+type hashRing struct {
+	points []uint64
+	owners []string
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv spreads similar keys poorly over the high bits, mixing them in makes the ring even.
+	sum := h.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	return sum
+}
+
+func newHashRing(shards []string, virtualNodes int) *hashRing {
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	var points []point
+	for _, shard := range shards {
+		for i := range virtualNodes {
+			points = append(points, point{hashKey(shard + "#" + strconv.Itoa(i)), shard})
+		}
+	}
+	slices.SortFunc(points, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(a.owner, b.owner))
+	})
+	ring := &hashRing{}
+	for _, p := range points {
+		ring.points = append(ring.points, p.hash)
+		ring.owners = append(ring.owners, p.owner)
+	}
+	return ring
+}
+
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// shardMap is the sharding config with its rings built, swapped as a whole on config reload.
+type shardMap struct {
+	self     string
+	shards   map[string]*url.URL
+	ring     *hashRing
+	previous *hashRing
+	// previousShards are the URLs of Previous.
+	previousShards map[string]*url.URL
+}
+
+var shards atomic.Pointer[shardMap]
+
+func newShardMap(c ShardingConfig) *shardMap {
+	m := &shardMap{self: c.Self, shards: map[string]*url.URL{}, previousShards: map[string]*url.URL{}}
+	if len(c.Shards) == 0 {
+		return m
+	}
+	for name, base := range c.Shards {
+		m.shards[name], _ = url.Parse(base)
+	}
+	for name, base := range c.Previous {
+		m.previousShards[name], _ = url.Parse(base)
+	}
+	m.ring = newHashRing(sortedKeys(c.Shards), c.virtualNodes())
+	if len(c.Previous) > 0 {
+		m.previous = newHashRing(sortedKeys(c.Previous), c.virtualNodes())
+	}
+	return m
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (m *shardMap) enabled() bool {
+	return m != nil && m.ring != nil
+}
+
+// owns reports whether key belongs to this shard, always true without sharding.
+func (m *shardMap) owns(key string) bool {
+	return !m.enabled() || m.ring.owner(key) == m.self
+}
+
+// newReceiptID generates the ID of a new receipt, one this shard owns so lookups by ID are routed back here.
+func newReceiptID() string {
+	m := shards.Load()
+	for {
+		id := uuid.New().String()
+		if m.owns(id) {
+			return id
+		}
+	}
+}
+
+var shardForwardedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_shard_forwarded_requests_total",
+	Help: "Requests forwarded to the shard owning their receipt or account, by shard.",
+}, []string{"shard"})
+
+// shardKey is what a request is routed by: the receipt or account in its path, or the account a receipt is
+// submitted for. Requests without one are served by whichever shard gets them.
+func shardKey(r *http.Request) (key string, receipt bool) {
+	route := routeTemplate(r)
+	switch {
+	case strings.HasPrefix(route, "/receipts/{id}"):
+		return mux.Vars(r)["id"], true
+	case strings.HasPrefix(route, "/accounts/{id}"):
+		return "account/" + mux.Vars(r)["id"], false
+	case route == "/receipts/process" && r.Header.Get("X-Account-ID") != "":
+		return "account/" + pointsLedger.Resolve(r.Header.Get("X-Account-ID")), false
+	}
+	return "", false
+}
+
+// shardingMiddleware forwards requests for receipts and accounts of other shards to their owner. While the shard map
+// is being rebalanced, receipts this shard owns but doesn't have yet are looked up on their previous owner.
+func shardingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := shards.Load()
+		if !m.enabled() || r.Header.Get(shardHopHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, receipt := shardKey(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner := m.ring.owner(key)
+		var target *url.URL
+		if owner != m.self {
+			target = m.shards[owner]
+		} else if receipt && m.previous != nil {
+			if previous := m.previous.owner(key); previous != m.self {
+				if _, err := receiptStore.Get(r.Context(), key); errors.Is(err, store.ErrNotFound) {
+					owner, target = previous, m.previousShards[previous]
+				}
+			}
+		}
+		if target == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		shardForwardedTotal.WithLabelValues(owner).Inc()
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+				pr.Out.Header.Set(shardHopHeader, m.self)
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logger.Error("Failed to forward request to shard", zap.String("shard", owner), zap.Error(err))
+				http.Error(w, "The shard owning this receipt or account is unavailable.", http.StatusBadGateway)
+			},
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// rebalanceResult counts what a rebalance did with the receipts of this shard.
+type rebalanceResult struct {
+	Moved  int `json:"moved"`
+	Kept   int `json:"kept"`
+	Failed int `json:"failed"`
+}
+
+var shardClient = &http.Client{Timeout: 30 * time.Second}
+
+// rebalanceShard serves POST /admin/sharding/rebalance, which moves the receipts another shard owns under the
+// current shard map to it: each is put on its owner and then deleted here. Receipts that fail to move are kept and
+// moved by the next run. Account balances aren't moved. The owners are sent the API key the rebalance was requested
+// with, so it must be an admin key on every shard.
+func rebalanceShard(w http.ResponseWriter, r *http.Request) {
+	m := shards.Load()
+	if !m.enabled() {
+		http.Error(w, "Sharding is not configured.", http.StatusConflict)
+		return
+	}
+
+	var result rebalanceResult
+	var moving []store.Record
+	err := receiptStore.Scan(r.Context(), func(rec store.Record) error {
+		if m.owns(rec.ID) {
+			result.Kept++
+		} else {
+			moving = append(moving, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to scan receipts to rebalance", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	for _, rec := range moving {
+		owner := m.ring.owner(rec.ID)
+		if err := moveRecord(r.Context(), m.shards[owner], rec, r.Header.Get("X-API-Key")); err != nil {
+			result.Failed++
+			logger.Error("Failed to move receipt", zap.String("receiptID", rec.ID), zap.String("shard", owner), zap.Error(err))
+			continue
+		}
+		result.Moved++
+	}
+	logger.Info("Rebalanced shard", zap.Int("moved", result.Moved), zap.Int("kept", result.Kept), zap.Int("failed", result.Failed))
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// moveRecord puts rec on the shard at base, with the admin key apiKey, then deletes it here.
+func moveRecord(ctx context.Context, base *url.URL, rec store.Record, apiKey string) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.JoinPath("/admin/sharding/records").String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	resp, err := shardClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shard answered %v", resp.Status)
+	}
+	if err := receiptStore.Delete(ctx, rec.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// receiveRecord serves POST /admin/sharding/records, a receipt moved here by another shard's rebalance. A receipt
+// that is here already is fine, so moves can be retried.
+func receiveRecord(w http.ResponseWriter, r *http.Request) {
+	var rec store.Record
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil || rec.ID == "" {
+		http.Error(w, "The request must be a stored receipt record.", http.StatusBadRequest)
+		return
+	}
+	if err := receiptStore.Put(r.Context(), rec); err != nil && !errors.Is(err, store.ErrExists) {
+		logger.Error("Failed to store moved receipt", zap.String("receiptID", rec.ID), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
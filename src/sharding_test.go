@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestHashRing(t *testing.T) {
+	before := newHashRing([]string{"a", "b", "c"}, defaultVirtualNodes)
+	after := newHashRing([]string{"a", "b", "c", "d"}, defaultVirtualNodes)
+
+	const keys = 10000
+	counts := map[string]int{}
+	moved := 0
+	for i := range keys {
+		key := fmt.Sprintf("receipt-%d", i)
+		owner := before.owner(key)
+		counts[owner]++
+		if newOwner := after.owner(key); newOwner != owner {
+			if newOwner != "d" {
+				t.Fatalf("%s moved from %s to %s, want only moves to the new shard", key, owner, newOwner)
+			}
+			moved++
+		}
+	}
+	for shard, n := range counts {
+		if n < keys/3*8/10 || n > keys/3*12/10 {
+			t.Errorf("shard %s owns %d of %d keys, want about a third", shard, n, keys)
+		}
+	}
+	if moved < keys/4*7/10 || moved > keys/4*13/10 {
+		t.Errorf("adding a fourth shard moved %d of %d keys, want about a quarter", moved, keys)
+	}
+}
+
+func TestNewReceiptID(t *testing.T) {
+	setup()
+	m := newShardMap(ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a", "b": "http://b", "c": "http://c"}})
+	shards.Store(m)
+	t.Cleanup(func() { shards.Store(newShardMap(ShardingConfig{})) })
+
+	for range 20 {
+		if id := newReceiptID(); m.ring.owner(id) != "a" {
+			t.Fatalf("newReceiptID() = %s, owned by %s, want a", id, m.ring.owner(id))
+		}
+	}
+}
+
+// ownedBy returns a key of the form prefix+n that shard owns under m.
+func ownedBy(m *shardMap, shard, prefix string) string {
+	for i := 0; ; i++ {
+		if key := fmt.Sprintf("%s%d", prefix, i); m.ring.owner(key) == shard {
+			return key
+		}
+	}
+}
+
+func TestShardingMiddleware(t *testing.T) {
+	router := setup()
+	var forwarded []string
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Method+" "+r.URL.Path+" from "+r.Header.Get(shardHopHeader))
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer owner.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	sharding := ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a.invalid", "b": owner.URL}}
+	m := newShardMap(sharding)
+	shards.Store(m)
+	t.Cleanup(func() { shards.Store(newShardMap(ShardingConfig{})) })
+	// the receipt keys aren't UUIDs.
+	live := *currentConfig()
+	live.ReceiptIDs.Format = "any"
+	liveConfig.Store(&live)
+	remote, local := ownedBy(m, "b", "r"), ownedBy(m, "a", "r")
+	remoteAccount := ownedBy(m, "b", "account/")[len("account/"):]
+
+	testCases := []struct {
+		name          string
+		method        string
+		path          string
+		header        http.Header
+		wantStatus    int
+		wantForwarded string
+	}{
+		{name: "remote receipt", method: "GET", path: "/receipts/" + remote + "/points", wantStatus: http.StatusTeapot, wantForwarded: "GET /receipts/" + remote + "/points from a"},
+		{name: "local receipt", method: "GET", path: "/receipts/" + local + "/points", wantStatus: http.StatusNotFound},
+		{name: "remote account", method: "GET", path: "/accounts/" + remoteAccount + "/balance", wantStatus: http.StatusTeapot, wantForwarded: "GET /accounts/" + remoteAccount + "/balance from a"},
+		{name: "submission for a remote account", method: "POST", path: "/receipts/process", header: http.Header{"X-Account-Id": {remoteAccount}}, wantStatus: http.StatusTeapot, wantForwarded: "POST /receipts/process from a"},
+		{name: "already forwarded", method: "GET", path: "/receipts/" + remote + "/points", header: http.Header{shardHopHeader: {"b"}}, wantStatus: http.StatusNotFound},
+		{name: "no key", method: "GET", path: "/receipts", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwarded = nil
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewReader([]byte("{}")))
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("%s %s = %v %s, want %v", tc.method, tc.path, rr.Code, rr.Body, tc.wantStatus)
+			}
+			if got := fmt.Sprint(forwarded); tc.wantForwarded != "" && got != "["+tc.wantForwarded+"]" || tc.wantForwarded == "" && len(forwarded) > 0 {
+				t.Errorf("forwarded %v, want %q", forwarded, tc.wantForwarded)
+			}
+		})
+	}
+
+	t.Run("owner down", func(t *testing.T) {
+		sharding.Shards["b"] = down.URL
+		shards.Store(newShardMap(sharding))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+remote+"/points", nil))
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("GET with the owner down = %v, want %v", rr.Code, http.StatusBadGateway)
+		}
+	})
+}
+
+func TestRebalanceShard(t *testing.T) {
+	router := setup()
+	ctx := context.Background()
+	received := store.NewMemory()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec store.Record
+		json.NewDecoder(r.Body).Decode(&rec)
+		received.Put(r.Context(), rec)
+	}))
+	defer other.Close()
+
+	m := newShardMap(ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a.invalid", "b": other.URL}})
+	shards.Store(m)
+	t.Cleanup(func() { shards.Store(newShardMap(ShardingConfig{})) })
+	for i := range 20 {
+		receiptStore.Put(ctx, store.Record{ID: fmt.Sprintf("r%d", i), Receipt: json.RawMessage(`{}`), CreatedAt: time.Now()})
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/admin/sharding/rebalance", nil))
+	var result rebalanceResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if rr.Code != http.StatusOK || result.Failed != 0 || result.Moved == 0 || result.Moved+result.Kept != 20 {
+		t.Fatalf("POST /admin/sharding/rebalance = %v %+v, want every receipt moved or kept", rr.Code, result)
+	}
+	for i := range 20 {
+		id := fmt.Sprintf("r%d", i)
+		_, here := receiptStore.Get(ctx, id)
+		_, there := received.Get(ctx, id)
+		if owner := m.ring.owner(id); (owner == "a") != (here == nil) || (owner == "b") != (there == nil) {
+			t.Errorf("%s owned by %s: local error = %v, moved error = %v", id, owner, here, there)
+		}
+	}
+}
+
+func TestShardingConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ShardingConfig
+		wantErr bool
+	}{
+		{name: "off", config: ShardingConfig{}},
+		{name: "sharded", config: ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a:8080", "b": "http://b:8080"}}},
+		{name: "self not a shard", config: ShardingConfig{Self: "c", Shards: map[string]string{"a": "http://a:8080"}}, wantErr: true},
+		{name: "bad URL", config: ShardingConfig{Self: "a", Shards: map[string]string{"a": "a:8080"}}, wantErr: true},
+		{name: "bad previous URL", config: ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a:8080"}, Previous: map[string]string{"a": "/"}}, wantErr: true},
+		{name: "negative virtual nodes", config: ShardingConfig{Self: "a", Shards: map[string]string{"a": "http://a:8080"}, VirtualNodes: -1}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
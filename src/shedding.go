@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SheddingConfig turns away low-priority requests (exports, batch jobs, scans) with 503 while the service is
+// overloaded: when the p99 latency of the other requests over Window exceeds P99Latency, or more than QueueDepth
+// requests wait for a concurrency slot. Zero thresholds are off. LowPriorityRoutes are path templates and default to
+// defaultBatchRoutes.
+type SheddingConfig struct {
+	P99Latency        Duration `json:"p99Latency"`
+	QueueDepth        int      `json:"queueDepth"`
+	Window            Duration `json:"window"`
+	LowPriorityRoutes []string `json:"lowPriorityRoutes"`
+}
+
+const (
+	defaultSheddingWindow = 30 * time.Second
+	// latencySamples caps the requests the p99 is computed from.
+	latencySamples = 1024
+)
+
+func (c SheddingConfig) Validate() error {
+	if c.P99Latency < 0 || c.QueueDepth < 0 || c.Window < 0 {
+		return fmt.Errorf("shedding: p99Latency, queueDepth and window must not be negative")
+	}
+	return nil
+}
+
+func (c SheddingConfig) window() time.Duration {
+	if c.Window == 0 {
+		return defaultSheddingWindow
+	}
+	return time.Duration(c.Window)
+}
+
+func (c SheddingConfig) lowPriority(route string) bool {
+	if c.LowPriorityRoutes == nil {
+		return slices.Contains(defaultBatchRoutes, route)
+	}
+	return slices.Contains(c.LowPriorityRoutes, route)
+}
+
+var (
+	requestsShedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_requests_shed_total",
+		Help: "Low-priority requests rejected while the service was overloaded, by route and reason (latency or queueDepth).",
+	}, []string{"route", "reason"})
+
+	requestLatencyP99 = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_request_latency_p99_seconds",
+		Help: "The p99 latency of requests that are never shed, over the shedding window.",
+	})
+)
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// latencyTracker keeps the latest request latencies. The p99 is recomputed at most once a second, not per request.
+type latencyTracker struct {
+	mu         sync.Mutex
+	samples    []latencySample
+	next       int
+	p99        time.Duration
+	computedAt time.Time
+}
+
+var latencies = &latencyTracker{}
+
+func (t *latencyTracker) record(d time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, latencySample{at: now, duration: d})
+		return
+	}
+	t.samples[t.next] = latencySample{at: now, duration: d}
+	t.next = (t.next + 1) % latencySamples
+}
+
+// percentile99 is the p99 latency of the requests that finished within window before now.
+func (t *latencyTracker) percentile99(window time.Duration, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.computedAt) < time.Second {
+		return t.p99
+	}
+
+	var durations []time.Duration
+	for _, s := range t.samples {
+		if now.Sub(s.at) <= window {
+			durations = append(durations, s.duration)
+		}
+	}
+	t.p99 = 0
+	if len(durations) > 0 {
+		slices.Sort(durations)
+		t.p99 = durations[int(math.Ceil(float64(len(durations))*0.99))-1]
+	}
+	t.computedAt = now
+	requestLatencyP99.Set(t.p99.Seconds())
+	return t.p99
+}
+
+// overloaded reports why low-priority requests should be shed right now, or "" if they shouldn't.
+func overloaded(c SheddingConfig, now time.Time) string {
+	if c.QueueDepth > 0 && requestsQueued.Load() > int64(c.QueueDepth) {
+		return "queueDepth"
+	}
+	if c.P99Latency > 0 && latencies.percentile99(c.window(), now) > time.Duration(c.P99Latency) {
+		return "latency"
+	}
+	return ""
+}
+
+// sheddingMiddleware runs before concurrencyMiddleware, so shed requests don't take a place in its queue. The latency
+// of low-priority requests isn't tracked, they are slow by nature and shedding them wouldn't bring it down.
+func sheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		c := currentConfig().Shedding
+		if unlimitedRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.lowPriority(route) {
+			if reason := overloaded(c, time.Now()); reason != "" {
+				requestsShedTotal.WithLabelValues(route, reason).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limiter.Load().retryAfter.Seconds()))))
+				http.Error(w, "The service is overloaded, please retry later.", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		latencies.record(time.Since(start), time.Now())
+	})
+}
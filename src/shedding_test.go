@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	now := time.Now()
+	var tracker latencyTracker
+	for i := 1; i <= 100; i++ {
+		tracker.record(time.Duration(i)*time.Millisecond, now.Add(-time.Duration(i)*time.Second))
+	}
+
+	testCases := []struct {
+		name   string
+		window time.Duration
+		want   time.Duration
+	}{
+		{name: "all samples", window: 100 * time.Second, want: 99 * time.Millisecond},
+		{name: "recent samples", window: 11 * time.Second, want: 10 * time.Millisecond},
+		{name: "no samples", window: time.Millisecond, want: 0},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// a second apart, so the p99 isn't served from the last computation.
+			if got := tracker.percentile99(tc.window, now.Add(time.Duration(i)*time.Second)); got != tc.want {
+				t.Errorf("percentile99() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSheddingMiddleware(t *testing.T) {
+	router := setup()
+
+	testCases := []struct {
+		name       string
+		config     SheddingConfig
+		queued     int64
+		latency    time.Duration
+		path       string
+		wantStatus int
+	}{
+		{name: "off", queued: 100, latency: time.Minute, path: "/stats/regions", wantStatus: http.StatusOK},
+		{name: "queue too deep", config: SheddingConfig{QueueDepth: 10}, queued: 11, path: "/stats/regions", wantStatus: http.StatusServiceUnavailable},
+		{name: "queue short enough", config: SheddingConfig{QueueDepth: 10}, queued: 10, path: "/stats/regions", wantStatus: http.StatusOK},
+		{name: "too slow", config: SheddingConfig{P99Latency: Duration(time.Second)}, latency: 2 * time.Second, path: "/stats/regions", wantStatus: http.StatusServiceUnavailable},
+		{name: "fast enough", config: SheddingConfig{P99Latency: Duration(time.Second)}, latency: time.Millisecond, path: "/stats/regions", wantStatus: http.StatusOK},
+		{name: "interactive request", config: SheddingConfig{QueueDepth: 10}, queued: 11, path: "/receipts/" + unknownReceiptID + "/points", wantStatus: http.StatusNotFound},
+		{name: "configured routes", config: SheddingConfig{QueueDepth: 10, LowPriorityRoutes: []string{"/receipts/{id}/points"}}, queued: 11, path: "/receipts/" + unknownReceiptID + "/points", wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.Shedding = tc.config
+			liveConfig.Store(&live)
+			latencies = &latencyTracker{}
+			latencies.record(tc.latency, time.Now())
+			requestsQueued.Store(tc.queued)
+			defer requestsQueued.Store(0)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if tc.wantStatus == http.StatusServiceUnavailable && rr.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After is missing")
+			}
+		})
+	}
+}
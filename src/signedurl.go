@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+// SignedURLConfig enables one-time submission URLs. Secret signs them and must be at least 32 characters; every
+// replica needs the same one. URLs live for at most MaxTTL (15m by default). BaseURL, e.g. https://api.example.com,
+// makes the minted URLs absolute; without it they are paths.
+type SignedURLConfig struct {
+	Secret  string   `json:"secret"`
+	MaxTTL  Duration `json:"maxTTL"`
+	BaseURL string   `json:"baseURL"`
+}
+
+const (
+	minSignedURLSecretLength = 32
+	defaultSignedURLMaxTTL   = 15 * time.Minute
+	defaultSignedURLTTL      = 5 * time.Minute
+	signedSubmissionPath     = "/receipts/submit"
+)
+
+func (c SignedURLConfig) Validate() error {
+	if c.Secret != "" && len(c.Secret) < minSignedURLSecretLength {
+		return fmt.Errorf("signedUrls: secret must be at least %d characters", minSignedURLSecretLength)
+	}
+	if c.MaxTTL < 0 {
+		return fmt.Errorf("signedUrls: maxTTL must not be negative")
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("signedUrls: baseURL must be an absolute URL")
+		}
+	}
+	return nil
+}
+
+func (c SignedURLConfig) maxTTL() time.Duration {
+	if c.MaxTTL == 0 {
+		return defaultSignedURLMaxTTL
+	}
+	return time.Duration(c.MaxTTL)
+}
+
+// signSubmission is the HMAC-SHA256 of account, expiry and nonce. The fields are joined with newlines, which none of
+// them can contain, so no two different URLs share a signature.
+func signSubmission(secret, account string, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%d\n%s", account, expires, nonce)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// nonceStore returns the store's nonce claims, which every replica shares.
+func nonceStore() (store.NonceStore, bool) {
+	nonces, ok := store.Unwrap(receiptStore).(store.NonceStore)
+	return nonces, ok
+}
+
+type signedURLRequest struct {
+	Account string   `json:"account"`
+	TTL     Duration `json:"ttl"`
+}
+
+// SignedURL is a minted submission URL.
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// createSignedURL serves POST /receipts/signed-urls. It is meant for a partner's backend, which hands the URL to an
+// untrusted app so the app can submit one receipt for the account without holding an API key.
+func createSignedURL(w http.ResponseWriter, r *http.Request) {
+	c := currentConfig().SignedURLs
+	if c.Secret == "" {
+		http.Error(w, "Signed URLs aren't configured.", http.StatusNotImplemented)
+		return
+	}
+
+	var req signedURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "The signed URL request is invalid.", http.StatusBadRequest)
+		return
+	}
+	if err := ledger.ValidateAccount(req.Account); err != nil {
+		http.Error(w, "The account ID is invalid.", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTL)
+	if ttl == 0 {
+		ttl = min(defaultSignedURLTTL, c.maxTTL())
+	}
+	if ttl < 0 || ttl > c.maxTTL() {
+		http.Error(w, "The ttl must be positive and at most "+c.maxTTL().String()+".", http.StatusBadRequest)
+		return
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		logger.Error("Failed to generate nonce", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(nonceBytes)
+	expiresAt := time.Now().UTC().Add(ttl).Truncate(time.Second)
+
+	query := url.Values{}
+	query.Set("account", req.Account)
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("nonce", nonce)
+	query.Set("signature", signSubmission(c.Secret, req.Account, expiresAt.Unix(), nonce))
+	signed := SignedURL{URL: strings.TrimSuffix(c.BaseURL, "/") + signedSubmissionPath + "?" + query.Encode(), ExpiresAt: expiresAt}
+
+	jsonResponse, err := json.Marshal(signed)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+var errSignatureInvalid = errors.New("invalid signature")
+
+// verifySubmission checks the signed query and returns the account and expiry it was signed for.
+func verifySubmission(secret string, query url.Values) (string, time.Time, error) {
+	account, nonce := query.Get("account"), query.Get("nonce")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || account == "" || nonce == "" {
+		return "", time.Time{}, errSignatureInvalid
+	}
+	want := signSubmission(secret, account, expires, nonce)
+	if !hmac.Equal([]byte(want), []byte(query.Get("signature"))) {
+		return "", time.Time{}, errSignatureInvalid
+	}
+	return account, time.Unix(expires, 0), nil
+}
+
+// submitSigned serves POST /receipts/submit, processing one receipt for the account a signed URL was minted for. The
+// URL is used up once a receipt is stored; a rejected receipt can be fixed and sent again.
+func submitSigned(w http.ResponseWriter, r *http.Request) {
+	c := currentConfig().SignedURLs
+	if c.Secret == "" {
+		http.Error(w, "Signed URLs aren't configured.", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	account, expires, err := verifySubmission(c.Secret, query)
+	if err != nil {
+		http.Error(w, "The signed URL is invalid.", http.StatusForbidden)
+		return
+	}
+	if !time.Now().Before(expires) {
+		http.Error(w, "The signed URL has expired.", http.StatusGone)
+		return
+	}
+	nonces, ok := nonceStore()
+	if !ok {
+		http.Error(w, "The store backend doesn't keep signed URL nonces.", http.StatusNotImplemented)
+		return
+	}
+	// claimed in the store rather than here, so neither another replica nor a restart lets the URL submit again.
+	nonce := query.Get("nonce")
+	claimed, err := nonces.ClaimNonce(r.Context(), nonce, expires)
+	if err != nil {
+		logger.Error("Failed to claim signed URL nonce", zap.Error(err))
+		http.Error(w, "The signed URL can't be checked right now.", http.StatusServiceUnavailable)
+		return
+	}
+	if !claimed {
+		http.Error(w, "The signed URL has already been used.", http.StatusConflict)
+		return
+	}
+
+	if !processReceiptFor(w, r, account) {
+		if err := nonces.ReleaseNonce(context.WithoutCancel(r.Context()), nonce); err != nil {
+			logger.Error("Failed to release signed URL nonce", zap.Error(err))
+		}
+	}
+}
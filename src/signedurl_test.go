@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+const testSignedURLSecret = "0123456789abcdef0123456789abcdef"
+
+func mintSignedURL(t *testing.T, router http.Handler, body string) SignedURL {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/signed-urls", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	var signed SignedURL
+	if err := json.Unmarshal(rr.Body.Bytes(), &signed); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	return signed
+}
+
+func TestSignedURLSubmission(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.SignedURLs = SignedURLConfig{Secret: testSignedURLSecret}
+	liveConfig.Store(&live)
+
+	signed := mintSignedURL(t, router, `{"account": "alice", "ttl": "1m"}`)
+	if until := time.Until(signed.ExpiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("URL expires in %v, want within a minute", until)
+	}
+	tampered, _ := url.Parse(signed.URL)
+	query := tampered.Query()
+	query.Set("account", "mallory")
+	tampered.RawQuery = query.Encode()
+	expired, _ := url.Parse(signed.URL)
+	query = expired.Query()
+	expires := time.Now().Add(-time.Second).Unix()
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signSubmission(testSignedURLSecret, "alice", expires, query.Get("nonce")))
+	expired.RawQuery = query.Encode()
+
+	valid := string(receipttest.New().Build().JSON())
+	testCases := []struct {
+		name       string
+		url        string
+		body       string
+		wantStatus int
+	}{
+		{name: "tampered", url: tampered.String(), body: valid, wantStatus: http.StatusForbidden},
+		{name: "unsigned", url: "/receipts/submit?account=alice", body: valid, wantStatus: http.StatusForbidden},
+		{name: "expired", url: expired.String(), body: valid, wantStatus: http.StatusGone},
+		// an invalid receipt doesn't use the URL up.
+		{name: "invalid receipt", url: signed.URL, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "ok", url: signed.URL, body: valid, wantStatus: http.StatusOK},
+		{name: "used", url: signed.URL, body: valid, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", tc.url, bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+		})
+	}
+
+	if _, err := pointsLedger.Balance("alice"); err != nil {
+		t.Errorf("the receipt wasn't credited to the signed account: %v", err)
+	}
+}
+
+func TestCreateSignedURLValidation(t *testing.T) {
+	router := setup()
+
+	testCases := []struct {
+		name       string
+		config     SignedURLConfig
+		body       string
+		wantStatus int
+	}{
+		{name: "not configured", body: `{"account": "alice"}`, wantStatus: http.StatusNotImplemented},
+		{name: "default ttl", config: SignedURLConfig{Secret: testSignedURLSecret}, body: `{"account": "alice"}`, wantStatus: http.StatusCreated},
+		{name: "ttl over max", config: SignedURLConfig{Secret: testSignedURLSecret, MaxTTL: Duration(time.Minute)}, body: `{"account": "alice", "ttl": "2m"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid account", config: SignedURLConfig{Secret: testSignedURLSecret}, body: `{"account": ""}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", config: SignedURLConfig{Secret: testSignedURLSecret}, body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.SignedURLs = tc.config
+			liveConfig.Store(&live)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/signed-urls", bytes.NewBufferString(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+		})
+	}
+}
+
+func TestSignedURLUsedOnAnotherReplica(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.SignedURLs = SignedURLConfig{Secret: testSignedURLSecret}
+	liveConfig.Store(&live)
+	t.Cleanup(func() { liveConfig.Store(&cfg) })
+
+	signed := mintSignedURL(t, router, `{"account": "alice"}`)
+	u, _ := url.Parse(signed.URL)
+	// claimed in the shared store, like another replica that took the submission would.
+	nonces, _ := nonceStore()
+	if ok, err := nonces.ClaimNonce(context.Background(), u.Query().Get("nonce"), signed.ExpiresAt); err != nil || !ok {
+		t.Fatalf("ClaimNonce() = %v, %v", ok, err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", signed.URL, bytes.NewReader(receipttest.New().Build().JSON())))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("submission with a URL used elsewhere = %v %s, want %v", rr.Code, rr.Body, http.StatusConflict)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// SlowRequestsConfig logs a warning for every request that takes longer than Threshold, with the time each stage of
+// processing a receipt took, so store tail latencies can be caught in the act. With the tap enabled slow requests are
+// also recorded in it, whatever its sample rate. Off unless set.
+type SlowRequestsConfig struct {
+	Threshold Duration `json:"threshold"`
+}
+
+func (c SlowRequestsConfig) Validate() error {
+	if c.Threshold < 0 {
+		return errors.New("slowRequests: threshold must not be negative")
+	}
+	return nil
+}
+
+var slowRequestsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_slow_requests_total",
+	Help: "Requests that took longer than the slowRequests threshold, by route.",
+}, []string{"route"})
+
+// requestStages collects the stages of a request while slow requests are logged.
+type requestStages struct {
+	mu     sync.Mutex
+	stages []debugStage
+}
+
+type stagesContextKey struct{}
+
+func stagesFrom(ctx context.Context) *requestStages {
+	s, _ := ctx.Value(stagesContextKey{}).(*requestStages)
+	return s
+}
+
+// add records that the stage name took took and failed with err, if it isn't nil. It does nothing while slow
+// requests aren't logged.
+func (s *requestStages) add(name string, took time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stage := debugStage{Name: name, TookMS: milliseconds(took)}
+	if err != nil {
+		stage.Error = err.Error()
+	}
+	s.stages = append(s.stages, stage)
+}
+
+func (s *requestStages) list() []debugStage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stages
+}
+
+// slowRequestsMiddleware logs the requests that took longer than the threshold. It runs right after the tap, so the
+// time spent waiting on the concurrency limits counts too.
+func slowRequestsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		threshold := time.Duration(currentConfig().SlowRequests.Threshold)
+		if threshold == 0 || strings.HasPrefix(r.URL.Path, "/admin") || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		stages := &requestStages{}
+		sw := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), stagesContextKey{}, stages)))
+		took := time.Since(start)
+		if took < threshold {
+			return
+		}
+
+		route := routeTemplate(r)
+		slowRequestsTotal.WithLabelValues(route).Inc()
+		logger.Warn("Slow request",
+			zap.String("method", r.Method),
+			zap.String("route", route),
+			zap.String("path", r.URL.Path),
+			zap.String("clientIP", clientIPFrom(r.Context())),
+			zap.Int("status", sw.status),
+			zap.Duration("took", took),
+			zap.Duration("threshold", threshold),
+			zap.Any("stages", stages.list()))
+	})
+}
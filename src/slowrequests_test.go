@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSlowRequests(t *testing.T) {
+	router := setup()
+	core, logs := observer.New(zapcore.WarnLevel)
+	logger = zap.New(core)
+
+	testCases := []struct {
+		name       string
+		threshold  time.Duration
+		tap        TapConfig
+		wantLogged bool
+		wantTapped int
+	}{
+		{name: "off", threshold: 0},
+		{name: "fast enough", threshold: time.Hour},
+		{name: "slow", threshold: time.Nanosecond, wantLogged: true},
+		{name: "slow with the tap off", threshold: time.Nanosecond, tap: TapConfig{SampleRate: 1}, wantLogged: true},
+		{name: "slow with the tap on", threshold: time.Nanosecond, tap: TapConfig{Enabled: true}, wantLogged: true, wantTapped: 1},
+		{name: "fast with the tap on", threshold: time.Hour, tap: TapConfig{Enabled: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.TakeAll()
+			tap.clear()
+			live := cfg
+			live.SlowRequests = SlowRequestsConfig{Threshold: Duration(tc.threshold)}
+			live.Tap = tc.tap
+			liveConfig.Store(&live)
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+			// never logged.
+			router.ServeHTTP(httptest.NewRecorder(), adminRequest("GET", "/admin/loglevel", nil))
+
+			slow := logs.FilterMessage("Slow request").All()
+			if got := len(slow) == 1; got != tc.wantLogged {
+				t.Fatalf("logged %d slow requests, want logged: %v", len(slow), tc.wantLogged)
+			}
+			if tc.wantLogged {
+				fields := slow[0].ContextMap()
+				if fields["route"] != "/receipts/process" || fields["status"] != int64(200) {
+					t.Errorf("logged %v, want the route and status", fields)
+				}
+				var stages []string
+				for _, stage := range fields["stages"].([]debugStage) {
+					stages = append(stages, stage.Name)
+				}
+				if want := []string{"decode", "score", "store", "review"}; !slices.Equal(stages, want) {
+					t.Errorf("logged stages %v, want %v", stages, want)
+				}
+			}
+			if got := len(tap.list()); got != tc.wantTapped {
+				t.Errorf("tap has %d records, want %d", got, tc.wantTapped)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSpoolDrainInterval = 5 * time.Second
+	defaultSpoolMaxReceipts   = 10000
+)
+
+// SpoolConfig turns on spooling, when Dir is set. Receipts submitted to /receipts/process that the store fails to write
+// are written to Dir instead and accepted with 202, and a drainer submits them every DrainInterval (5s by default) once
+// the store is back. MaxReceipts (10000 by default) caps what is spooled at once, beyond it submissions fail as they
+// would without a spool. The spool is local to the instance.
+type SpoolConfig struct {
+	Dir           string   `json:"dir"`
+	DrainInterval Duration `json:"drainInterval"`
+	MaxReceipts   int      `json:"maxReceipts"`
+}
+
+func (c SpoolConfig) Validate() error {
+	if c.DrainInterval < 0 {
+		return fmt.Errorf("spool: drainInterval must not be negative")
+	}
+	if c.MaxReceipts < 0 {
+		return fmt.Errorf("spool: maxReceipts must not be negative")
+	}
+	return nil
+}
+
+func (c SpoolConfig) maxReceipts() int {
+	if c.MaxReceipts == 0 {
+		return defaultSpoolMaxReceipts
+	}
+	return c.MaxReceipts
+}
+
+var errSpoolFull = errors.New("the spool is full")
+
+var (
+	spooledReceipts = metrics.NewGauge(prometheus.GaugeOpts{
+		Name: "fcpc_spooled_receipts",
+		Help: "Receipts in the spool, waiting for the store to come back.",
+	})
+
+	spoolDrainedTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_spool_drained_total",
+		Help: "Spooled receipts the drainer is done with, by result: stored, or failed when the receipt was rejected.",
+	}, []string{"result"})
+)
+
+// spooledReceipt is a spool file. The receipt keeps the ID it was accepted with, so it can be looked up under it once
+// it is drained.
+type spooledReceipt struct {
+	ID        string          `json:"id"`
+	Account   string          `json:"account,omitempty"`
+	Receipt   json.RawMessage `json:"receipt"`
+	SpooledAt time.Time       `json:"spooledAt"`
+}
+
+// receiptSpool is a directory of receipts waiting to be submitted, one file each, named so they sort in the order they
+// were spooled. Receipts the drainer can't submit for good go to failed/.
+type receiptSpool struct {
+	mu    sync.Mutex
+	dir   string
+	max   int
+	count int
+}
+
+// spool is nil unless spooling is turned on.
+var spool *receiptSpool
+
+// openSpool opens the spool in c.Dir, picking up what was left in it, or returns nil without a Dir.
+func openSpool(c SpoolConfig) (*receiptSpool, error) {
+	if c.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Join(c.Dir, "failed"), 0o755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", c.Dir, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	spooledReceipts.Set(float64(len(paths)))
+	return &receiptSpool{dir: c.Dir, max: c.maxReceipts(), count: len(paths)}, nil
+}
+
+// add spools receipt under a new ID. The file is written to a temporary name first, so the drainer never reads half of
+// one.
+func (s *receiptSpool) add(receipt Receipt, accountID string) (submission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count >= s.max {
+		return submission{}, errSpoolFull
+	}
+
+	payload, err := json.Marshal(receipt.ToDTO())
+	if err != nil {
+		return submission{}, err
+	}
+	spooled := spooledReceipt{ID: newReceiptID(), Account: accountID, Receipt: payload, SpooledAt: time.Now().UTC()}
+	data, err := json.Marshal(spooled)
+	if err != nil {
+		return submission{}, err
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d-%s.json", spooled.SpooledAt.UnixNano(), spooled.ID))
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return submission{}, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return submission{}, err
+	}
+	s.count++
+	spooledReceipts.Set(float64(s.count))
+	return submission{ID: spooled.ID, Spooled: true, Warnings: receipt.warnings}, nil
+}
+
+// done takes a drained file out of the spool.
+func (s *receiptSpool) done(path string, failed bool) error {
+	var err error
+	if failed {
+		err = os.Rename(path, filepath.Join(s.dir, "failed", filepath.Base(path)))
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count--
+	spooledReceipts.Set(float64(s.count))
+	return nil
+}
+
+// drain submits the spooled receipts oldest first, and stops at the first one the store still fails to write, to try
+// again on the next run. It returns how many it stored.
+func (s *receiptSpool) drain(ctx context.Context) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	drained := 0
+	for _, path := range paths {
+		stored, err := s.drainFile(ctx, path)
+		if errors.Is(err, errStoreWrite) {
+			return drained, err
+		}
+		if err != nil {
+			logger.Error("Failed to submit spooled receipt", zap.String("path", path), zap.Error(err))
+			spoolDrainedTotal.WithLabelValues("failed").Inc()
+			if err := s.done(path, true); err != nil {
+				return drained, err
+			}
+			continue
+		}
+		if err := s.done(path, false); err != nil {
+			return drained, err
+		}
+		spoolDrainedTotal.WithLabelValues("stored").Inc()
+		if stored {
+			drained++
+		}
+	}
+	return drained, nil
+}
+
+// drainFile submits one spooled receipt. It reports false for a receipt that was stored already, by a run that
+// failed to remove its file; store errors are errStoreWrite, anything else means the receipt won't ever go through.
+func (s *receiptSpool) drainFile(ctx context.Context, path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var spooled spooledReceipt
+	if err := json.Unmarshal(data, &spooled); err != nil {
+		return false, err
+	}
+
+	_, err = receiptStore.Get(ctx, spooled.ID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return false, fmt.Errorf("%w: %w", errStoreWrite, err)
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(spooled.Receipt, &receipt); err != nil {
+		return false, err
+	}
+	sub, err := submitReceiptAs(ctx, receipt, spooled.Account, spooled.ID)
+	if err != nil {
+		return false, err
+	}
+	logger.Info("Submitted spooled receipt", zap.String("receiptID", sub.ID), zap.Int("points", sub.Points), zap.Duration("spooledFor", time.Since(spooled.SpooledAt)))
+	return true, nil
+}
+
+// startSpoolDrainer drains the spool every interval.
+func startSpoolDrainer(ctx context.Context, interval time.Duration) {
+	if spool == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultSpoolDrainInterval
+	}
+	go runPeriodically(ctx, interval, func(ctx context.Context) {
+		drained, err := spool.drain(ctx)
+		if drained > 0 {
+			logger.Info("Drained spooled receipts", zap.Int("drained", drained))
+		}
+		if err != nil && !errors.Is(err, errStoreWrite) {
+			logger.Error("Failed to drain the spool", zap.Error(err))
+		}
+	})
+}
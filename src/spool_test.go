@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+	"github.com/gorilla/mux"
+)
+
+func setupSpool(t *testing.T) (*mux.Router, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "spool")
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"spool": {"dir": "`+dir+`", "maxReceipts": 2}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return setup(), dir
+}
+
+func TestSpoolOnStoreOutage(t *testing.T) {
+	router, dir := setupSpool(t)
+	faulty := storetest.NewFaulty(receiptStore, 0, 1, 1)
+	receiptStore = faulty
+
+	testCases := []struct {
+		name        string
+		wantStatus  int
+		wantSpooled bool
+	}{
+		{name: "spooled", wantStatus: http.StatusAccepted, wantSpooled: true},
+		{name: "spooled up to maxReceipts", wantStatus: http.StatusAccepted, wantSpooled: true},
+		{name: "spool full", wantStatus: http.StatusInternalServerError},
+	}
+
+	var ids []string
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(string(receipttest.New().Build().JSON())))
+			req.Header.Set("X-Account-ID", "alice")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			var resp struct {
+				ID      string `json:"id"`
+				Spooled bool   `json:"spooled"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if resp.Spooled != tc.wantSpooled || (tc.wantSpooled && resp.ID == "") {
+				t.Errorf("response = %s, want spooled %v with an ID", rr.Body, tc.wantSpooled)
+			}
+			if resp.ID != "" {
+				ids = append(ids, resp.ID)
+			}
+		})
+	}
+
+	if drained, err := spool.drain(context.Background()); drained != 0 || !errors.Is(err, errStoreWrite) {
+		t.Fatalf("drain while the store is down = %v, %v, want nothing drained and a store error", drained, err)
+	}
+
+	faulty.ErrorRate = 0
+	if drained, err := spool.drain(context.Background()); drained != 2 || err != nil {
+		t.Fatalf("drain once the store is back = %v, %v, want 2 drained", drained, err)
+	}
+	for _, id := range ids {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+id+"/points", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET /receipts/%v/points after draining = %v, want 200", id, rr.Code)
+		}
+	}
+	if code, balance := getBalanceOf(t, router, "alice"); code != http.StatusOK || balance == 0 {
+		t.Errorf("alice's balance = %v %v, want the drained receipts' points", code, balance)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(left) != 0 {
+		t.Errorf("spool files left after draining = %v", left)
+	}
+}
+
+func TestDrainRejectedReceipt(t *testing.T) {
+	_, dir := setupSpool(t)
+	// spooled by an instance with looser limits, say.
+	spooled := `{"id": "f3a1c9d2-0000-4000-8000-000000000000", "account": "not a valid account!", "receipt": ` + string(receipttest.New().Build().JSON()) + `}`
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-f3a1c9d2.json"), []byte(spooled), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spool, _ = openSpool(currentConfig().Spool)
+
+	if drained, err := spool.drain(context.Background()); drained != 0 || err != nil {
+		t.Fatalf("drain = %v, %v, want nothing drained", drained, err)
+	}
+	if failed, _ := filepath.Glob(filepath.Join(dir, "failed", "*.json")); len(failed) != 1 {
+		t.Errorf("failed spool files = %v, want the rejected receipt", failed)
+	}
+	if spool.count != 0 {
+		t.Errorf("spool count = %v, want 0", spool.count)
+	}
+}
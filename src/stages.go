@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stages of processing a receipt, in the order they run. Stages that have nothing to do for a receipt, like limits
+// for one without an account, aren't reported for it.
+const (
+	stageDecode      = "decode"
+	stageLimits      = "limits"
+	stageTransaction = "transaction"
+	stageScore       = "score"
+	stageFraud       = "fraud"
+	stageStore       = "store"
+	stageReview      = "review"
+	stageLedger      = "ledger"
+	stageEvents      = "events"
+)
+
+var (
+	stageSeconds = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "fcpc_stage_seconds",
+		Help: "How long a stage of processing a receipt took, by stage.",
+		// from in-memory checks in microseconds to store writes and fraud provider calls in seconds.
+		Buckets: prometheus.ExponentialBuckets(1e-5, 4, 10),
+	}, []string{"stage"})
+
+	stageFailuresTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_stage_failures_total",
+		Help: "Receipts whose processing stopped at a stage with an error, by stage. Receipts rejected as invalid or over a limit count too.",
+	}, []string{"stage"})
+)
+
+// timeStage reports that stage took since start, and that the receipt failed there if err isn't nil, in the metrics,
+// the diagnostics of a request made with X-Debug and the log line of a slow request.
+func timeStage(ctx context.Context, stage string, start time.Time, err error) {
+	took := time.Since(start)
+	stageSeconds.WithLabelValues(stage).Observe(took.Seconds())
+	if err != nil {
+		stageFailuresTotal.WithLabelValues(stage).Inc()
+	}
+	debugFrom(ctx).stage(stage, took, err)
+	stagesFrom(ctx).add(stage, took, err)
+}
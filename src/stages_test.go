@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStageAttribution(t *testing.T) {
+	testCases := []struct {
+		name        string
+		account     string
+		wantStages  []string
+		wantFailure string
+	}{
+		{name: "without an account", wantStages: []string{"score", "store", "review"}},
+		{name: "with an account", account: "alice", wantStages: []string{"limits", "score", "store", "review", "ledger", "events"}},
+		{name: "invalid account", account: "a b", wantStages: []string{"limits"}, wantFailure: "limits"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setup()
+			var receipt Receipt
+			if err := json.Unmarshal(receipttest.New().Build().JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			failures := map[string]float64{}
+			for _, stage := range tc.wantStages {
+				failures[stage] = testutil.ToFloat64(stageFailuresTotal.WithLabelValues(stage))
+			}
+
+			d := &requestDebug{logger: logger}
+			_, err := submitReceipt(context.WithValue(context.Background(), debugContextKey{}, d), receipt, tc.account)
+			if (err != nil) != (tc.wantFailure != "") {
+				t.Fatalf("submitReceipt() error = %v, want a failure at %q", err, tc.wantFailure)
+			}
+
+			var stages []string
+			for _, s := range d.Stages {
+				stages = append(stages, s.Name)
+				if (s.Error != "") != (s.Name == tc.wantFailure) {
+					t.Errorf("stage %s has error %q, want one only at %q", s.Name, s.Error, tc.wantFailure)
+				}
+			}
+			if !slices.Equal(stages, tc.wantStages) {
+				t.Errorf("stages = %v, want %v", stages, tc.wantStages)
+			}
+			for stage, before := range failures {
+				want := before
+				if stage == tc.wantFailure {
+					want++
+				}
+				if got := testutil.ToFloat64(stageFailuresTotal.WithLabelValues(stage)); got != want {
+					t.Errorf("fcpc_stage_failures_total{stage=%q} = %v, want %v", stage, got, want)
+				}
+			}
+		})
+	}
+}
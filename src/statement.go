@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// StatementConfig tunes monthly statements. With PointsExpireAfterMonths set, statements show the points that expire
+// at the end of the following month, oldest points being used up first. Nothing is expired automatically, it's only
+// shown to customers.
+type StatementConfig struct {
+	PointsExpireAfterMonths int `json:"pointsExpireAfterMonths"`
+}
+
+// Statement is an account's activity for one calendar month in its timezone (UTC by default), by when it hit the
+// ledger.
+type Statement struct {
+	Account        string             `json:"account"`
+	Month          string             `json:"month"`
+	Timezone       string             `json:"timezone"`
+	OpeningBalance int64              `json:"openingBalance"`
+	Earned         int64              `json:"earned"`
+	Redeemed       int64              `json:"redeemed"`
+	ClosingBalance int64              `json:"closingBalance"`
+	ExpiringSoon   int64              `json:"expiringSoon"`
+	Receipts       []StatementReceipt `json:"receipts"`
+	GeneratedAt    time.Time          `json:"generatedAt"`
+}
+
+// StatementReceipt is a receipt credited during the month. The details are empty if the receipt is gone from the
+// store.
+type StatementReceipt struct {
+	ID           string    `json:"id"`
+	Retailer     string    `json:"retailer,omitempty"`
+	PurchaseDate string    `json:"purchaseDate,omitempty"`
+	Total        string    `json:"total,omitempty"`
+	Points       int64     `json:"points"`
+	CreditedAt   time.Time `json:"creditedAt"`
+}
+
+// buildStatement works from a snapshot of the account's entries, oldest first.
+func buildStatement(ctx context.Context, account string, month time.Time, entries []ledger.Entry, c StatementConfig) (*Statement, error) {
+	end := month.AddDate(0, 1, 0)
+	s := &Statement{Account: account, Month: month.Format("2006-01"), Timezone: month.Location().String(), Receipts: []StatementReceipt{}}
+
+	var spent int64
+	for _, e := range entries {
+		if !e.CreatedAt.Before(end) {
+			break
+		}
+		if e.Amount < 0 {
+			spent -= e.Amount
+		}
+		if e.CreatedAt.Before(month) {
+			s.OpeningBalance += e.Amount
+			continue
+		}
+		if e.Amount > 0 {
+			s.Earned += e.Amount
+		} else {
+			s.Redeemed -= e.Amount
+		}
+		if e.Kind != ledger.KindAccrual {
+			continue
+		}
+
+		r := StatementReceipt{ID: e.ReceiptID, Points: e.Amount, CreditedAt: e.CreatedAt}
+		rec, err := receiptStore.Get(ctx, e.ReceiptID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		var dto ReceiptDTO
+		if err == nil && json.Unmarshal(rec.Receipt, &dto) == nil {
+			r.Retailer, r.PurchaseDate, r.Total = dto.Retailer, dto.PurchaseDate, dto.Total
+		}
+		s.Receipts = append(s.Receipts, r)
+	}
+	s.ClosingBalance = s.OpeningBalance + s.Earned - s.Redeemed
+
+	if n := c.PointsExpireAfterMonths; n > 0 {
+		// points earned in this month expire at the end of the next one. Everything spent so far used up the oldest
+		// points first.
+		expiring := month.AddDate(0, 1-n, 0)
+		for _, e := range entries {
+			if e.Amount <= 0 || !e.CreatedAt.Before(end) {
+				continue
+			}
+			left := max(e.Amount-spent, 0)
+			spent = max(spent-e.Amount, 0)
+			if e.CreatedAt.Year() == expiring.Year() && e.CreatedAt.Month() == expiring.Month() {
+				s.ExpiringSoon += left
+			}
+		}
+	}
+
+	s.GeneratedAt = time.Now().UTC()
+	return s, nil
+}
+
+// statementRenderer turns a statement into one of the formats ?format= accepts. Register new ones in
+// statementRenderers.
+type statementRenderer interface {
+	ContentType() string
+	Render(w io.Writer, s *Statement) error
+}
+
+var statementRenderers = map[string]statementRenderer{
+	"json": jsonStatementRenderer{},
+	"pdf":  pdfStatementRenderer{},
+}
+
+type jsonStatementRenderer struct{}
+
+func (jsonStatementRenderer) ContentType() string { return "application/json" }
+
+func (jsonStatementRenderer) Render(w io.Writer, s *Statement) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// statementJob is one statement being generated in the background. done is closed once statement or err is set.
+type statementJob struct {
+	revision  int
+	done      chan struct{}
+	statement *Statement
+	err       error
+}
+
+// statementCache keeps the latest statement per account and month. A statement is regenerated once the account has
+// new ledger entries, which also covers merges moving entries into an earlier month.
+type statementCache struct {
+	mu   sync.Mutex
+	jobs map[string]*statementJob
+}
+
+var statements *statementCache
+
+// statementWait is how long a request waits for a statement before answering 202 and letting the client poll.
+var statementWait = 2 * time.Second
+
+// forget drops the cached statements of account.
+func (c *statementCache) forget(account string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.jobs {
+		if strings.HasPrefix(key, account+"\x00") {
+			delete(c.jobs, key)
+		}
+	}
+}
+
+// get returns the job for the statement, starting it unless one for the same entries exists already.
+func (c *statementCache) get(account string, month time.Time, entries []ledger.Entry) *statementJob {
+	key := account + "\x00" + month.Format("2006-01")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if job, ok := c.jobs[key]; ok && job.revision == len(entries) {
+		return job
+	}
+	job := &statementJob{revision: len(entries), done: make(chan struct{})}
+	c.jobs[key] = job
+
+	config := currentConfig().Statements
+	go func() {
+		defer close(job.done)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		job.statement, job.err = buildStatement(ctx, account, month, entries, config)
+		if job.err != nil {
+			logger.Error("Failed to generate statement", zap.String("account", account), zap.String("month", month.Format("2006-01")), zap.Error(job.err))
+			// failed statements aren't cached, the next request tries again.
+			c.mu.Lock()
+			if c.jobs[key] == job {
+				delete(c.jobs, key)
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return job
+}
+
+func formatNames() string {
+	var names []string
+	for name := range statementRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// getStatement serves GET /accounts/{id}/statement?month=2022-01&format=pdf. Statements are generated in the
+// background: if it isn't ready within statementWait the response is 202 and the client should retry.
+func getStatement(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	month, err := time.ParseInLocation("2006-01", r.URL.Query().Get("month"), preferences.get(id).location())
+	if err != nil || month.After(time.Now()) {
+		http.Error(w, "The month must be in YYYY-MM format and not in the future.", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	renderer, ok := statementRenderers[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("The format must be one of: %s.", formatNames()), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := pointsLedger.Balance(id); errors.Is(err, ledger.ErrUnknownAccount) {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	job := statements.get(id, month, pointsLedger.Entries(id))
+	select {
+	case <-job.done:
+	case <-time.After(statementWait):
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"pending"}`))
+		return
+	case <-r.Context().Done():
+		return
+	}
+	if job.err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", renderer.ContentType())
+	w.WriteHeader(http.StatusOK)
+	if err := renderer.Render(w, job.statement); err != nil {
+		logger.Error("Failed to render statement", zap.String("account", id), zap.String("format", format), zap.Error(err))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pdfStatementRenderer writes a plain, text-only PDF with the built-in Helvetica font, so no PDF library is needed.
+type pdfStatementRenderer struct{}
+
+func (pdfStatementRenderer) ContentType() string { return "application/pdf" }
+
+const pdfLinesPerPage = 50
+
+func (pdfStatementRenderer) Render(w io.Writer, s *Statement) error {
+	lines := []string{
+		"Points statement " + s.Month,
+		"Account: " + s.Account,
+		"",
+		fmt.Sprintf("Opening balance: %d", s.OpeningBalance),
+		fmt.Sprintf("Earned: %d", s.Earned),
+		fmt.Sprintf("Redeemed: %d", s.Redeemed),
+		fmt.Sprintf("Closing balance: %d", s.ClosingBalance),
+		fmt.Sprintf("Expiring at the end of next month: %d", s.ExpiringSoon),
+		"",
+		fmt.Sprintf("Receipts (%d)", len(s.Receipts)),
+	}
+	for _, r := range s.Receipts {
+		lines = append(lines, fmt.Sprintf("%s  %s  %s  %d points", r.PurchaseDate, r.Retailer, r.Total, r.Points))
+	}
+	lines = append(lines, "", "Generated "+s.GeneratedAt.Format("2006-01-02 15:04 MST"))
+
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages, lines = append(pages, lines[:pdfLinesPerPage]), lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+	return writePDF(w, pages)
+}
+
+// writePDF lays out every page as lines of 11pt text on A4. Objects 1-3 are the catalog, page tree and font, each
+// page then takes two objects: the page and its content stream.
+func writePDF(w io.Writer, pages [][]string) error {
+	var b bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	b.WriteString("%PDF-1.4\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+
+	for i, lines := range pages {
+		var content strings.Builder
+		content.WriteString("BT /F1 11 Tf 14 TL 50 800 Td\n")
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfEscape escapes a PDF string literal. Helvetica's built-in encoding can't show anything outside ASCII reliably.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestBuildStatement(t *testing.T) {
+	setup()
+	month := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m time.Month, day int) time.Time { return time.Date(2022, m, day, 12, 0, 0, 0, time.UTC) }
+	entries := []ledger.Entry{
+		{Amount: 100, Kind: ledger.KindAccrual, ReceiptID: "jan", CreatedAt: at(1, 5)},
+		{Amount: 40, Kind: ledger.KindAccrual, ReceiptID: "feb", CreatedAt: at(2, 5)},
+		{Amount: -30, Kind: ledger.KindTransfer, CreatedAt: at(2, 6)},
+		{Amount: 25, Kind: ledger.KindAccrual, ReceiptID: "mar", CreatedAt: at(3, 5)},
+		{Amount: -90, Kind: ledger.KindTransfer, CreatedAt: at(3, 6)},
+		{Amount: 7, Kind: ledger.KindAccrual, ReceiptID: "apr", CreatedAt: at(4, 1)},
+	}
+
+	got, err := buildStatement(t.Context(), "alice", month, entries, StatementConfig{PointsExpireAfterMonths: 2})
+	if err != nil {
+		t.Fatalf("buildStatement() error = %v", err)
+	}
+	if got.OpeningBalance != 110 || got.Earned != 25 || got.Redeemed != 90 || got.ClosingBalance != 45 {
+		t.Errorf("buildStatement() = %+v, want opening 110, earned 25, redeemed 90, closing 45", got)
+	}
+	// February's points expire at the end of April, and the 120 points spent used up all of January's and 20 of them.
+	if got.ExpiringSoon != 20 {
+		t.Errorf("ExpiringSoon = %v, want 20", got.ExpiringSoon)
+	}
+	if len(got.Receipts) != 1 || got.Receipts[0].ID != "mar" {
+		t.Errorf("Receipts = %+v, want only mar", got.Receipts)
+	}
+}
+
+func TestGetStatement(t *testing.T) {
+	month := time.Now().UTC().Format("2006-01")
+
+	testCases := []struct {
+		name            string
+		path            string
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "json", path: "/accounts/alice/statement?month=" + month, wantStatus: http.StatusOK, wantContentType: "application/json"},
+		{name: "pdf", path: "/accounts/alice/statement?format=pdf&month=" + month, wantStatus: http.StatusOK, wantContentType: "application/pdf"},
+		{name: "unknown format", path: "/accounts/alice/statement?format=csv&month=" + month, wantStatus: http.StatusBadRequest},
+		{name: "bad month", path: "/accounts/alice/statement?month=2022-13", wantStatus: http.StatusBadRequest},
+		{name: "future month", path: "/accounts/alice/statement?month=2999-01", wantStatus: http.StatusBadRequest},
+		{name: "unknown account", path: "/accounts/bob/statement?month=" + month, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			submitForAccount(t, router, "alice", receipttest.New().Build())
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantContentType != "" && rr.Header().Get("Content-Type") != tc.wantContentType {
+				t.Errorf("Content-Type = %v, want %v", rr.Header().Get("Content-Type"), tc.wantContentType)
+			}
+			if tc.wantContentType == "application/pdf" && (!bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) || !bytes.Contains(rr.Body.Bytes(), []byte("Account: alice"))) {
+				t.Errorf("body is not a statement PDF: %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetStatementCached(t *testing.T) {
+	router := setup()
+	path := "/accounts/alice/statement?month=" + time.Now().UTC().Format("2006-01")
+	gen := receipttest.NewGenerator(3)
+	get := func() Statement {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var s Statement
+		json.Unmarshal(rr.Body.Bytes(), &s)
+		return s
+	}
+
+	submitForAccount(t, router, "alice", gen.Valid())
+	first := get()
+	if again := get(); !again.GeneratedAt.Equal(first.GeneratedAt) {
+		t.Errorf("statement was regenerated without new activity")
+	}
+	submitForAccount(t, router, "alice", gen.Valid())
+	if got := get(); len(got.Receipts) != 2 {
+		t.Errorf("statement after new activity has %v receipts, want 2", len(got.Receipts))
+	}
+}
+
+func TestGetStatementPending(t *testing.T) {
+	router := setup()
+	submitForAccount(t, router, "alice", receipttest.New().Build())
+	receiptStore = storetest.NewFaulty(receiptStore, 200*time.Millisecond, 0, 1)
+	defer func(wait time.Duration) { statementWait = wait }(statementWait)
+	statementWait = 10 * time.Millisecond
+
+	path := "/accounts/alice/statement?month=" + time.Now().UTC().Format("2006-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	if rr.Code != http.StatusAccepted || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("handler returned %v with Retry-After %q, want %v with one", rr.Code, rr.Header().Get("Retry-After"), http.StatusAccepted)
+	}
+
+	// the retry picks up the statement that kept generating in the background.
+	statementWait = time.Second
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
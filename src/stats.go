@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// statsTotals sums up stored receipts. Totals are summed in cents, floats would drift over many receipts.
+type statsTotals struct {
+	receipts int
+	points   int64
+	cents    int64
+}
+
+func (t statsTotals) total() string {
+	return fmt.Sprintf("%d.%02d", t.cents/100, t.cents%100)
+}
+
+// statsDates reads the from and to dates of a stats request, answering with 400 if either is invalid.
+func statsDates(w http.ResponseWriter, r *http.Request) (from, to string, ok bool) {
+	from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	for name, date := range map[string]string{"from": from, "to": to} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			http.Error(w, "The "+name+" date must be a date like 2022-01-01.", http.StatusBadRequest)
+			return "", "", false
+		}
+	}
+	return from, to, true
+}
+
+// groupDaily sums up the store's daily aggregates of dim between the two inclusive dates ("" for no bound) by the
+// group group puts each of their days and groups in, so stats don't have to read every receipt.
+func groupDaily(ctx context.Context, dim store.DailyDimension, from, to string, group func(date, value string) string) (map[string]*statsTotals, error) {
+	days, err := dailyTotals(ctx, dim, from, to)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string]*statsTotals{}
+	for _, t := range days {
+		name := group(t.Date, t.Group)
+		totals := groups[name]
+		if totals == nil {
+			totals = &statsTotals{}
+			groups[name] = totals
+		}
+		totals.receipts += int(t.Receipts)
+		totals.points += t.Points
+		totals.cents += t.Cents
+	}
+	return groups, nil
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// AggregateField is a receipt field Aggregate groups by.
+type AggregateField string
+
+const (
+	GroupByNone          AggregateField = ""
+	GroupByRetailer      AggregateField = "retailer"
+	GroupByPaymentMethod AggregateField = "paymentMethod"
+	GroupByPostalCode    AggregateField = "postalCode"
+)
+
+// AggregateMetric is what Aggregate adds up per group.
+type AggregateMetric string
+
+const (
+	MetricReceipts AggregateMetric = "receipts"
+	MetricPoints   AggregateMetric = "points"
+	// MetricTotal sums the receipt totals in cents.
+	MetricTotal AggregateMetric = "total"
+)
+
+// AggregatePeriod buckets receipts by their purchase date.
+type AggregatePeriod string
+
+const (
+	PeriodNone AggregatePeriod = ""
+	PeriodDay  AggregatePeriod = "day"
+	// PeriodWeek buckets by ISO week, named after its Monday.
+	PeriodWeek  AggregatePeriod = "week"
+	PeriodMonth AggregatePeriod = "month"
+)
+
+// AggregateQuery asks for Metric summed per GroupBy value and Period, over the receipts purchased between From and
+// To, inclusive dates like 2022-01-01 ("" for no bound).
+type AggregateQuery struct {
+	GroupBy  AggregateField
+	Metric   AggregateMetric
+	Period   AggregatePeriod
+	From, To string
+}
+
+// AggregateBucket is one group in one period. Group and Period are "" when the query doesn't group or bucket by
+// them, and receipts without the field are in the "" group.
+type AggregateBucket struct {
+	Group  string `json:"group"`
+	Period string `json:"period"`
+	Value  int64  `json:"value"`
+}
+
+// Aggregator is implemented by backends that can aggregate without handing every record over, e.g. in SQL. Buckets
+// are ordered by period, then group.
+type Aggregator interface {
+	Aggregate(ctx context.Context, q AggregateQuery) ([]AggregateBucket, error)
+}
+
+// aggregatedReceipt is the part of a receipt Aggregate reads.
+type aggregatedReceipt struct {
+	Retailer      string `json:"retailer"`
+	PurchaseDate  string `json:"purchaseDate"`
+	Total         string `json:"total"`
+	PaymentMethod string `json:"paymentMethod"`
+	StoreLocation *struct {
+		PostalCode string `json:"postalCode"`
+	} `json:"storeLocation"`
+}
+
+// ScanAggregate works out an aggregate from a scan, for backends that can't aggregate themselves.
+func ScanAggregate(ctx context.Context, s Store, q AggregateQuery) ([]AggregateBucket, error) {
+	type key struct{ group, period string }
+	sums := map[key]int64{}
+	err := s.Scan(ctx, func(rec Record) error {
+		var r aggregatedReceipt
+		if err := json.Unmarshal(rec.Receipt, &r); err != nil {
+			return fmt.Errorf("receipt %s: %w", rec.ID, err)
+		}
+		// dates in this format compare like strings.
+		if (q.From != "" && r.PurchaseDate < q.From) || (q.To != "" && r.PurchaseDate > q.To) {
+			return nil
+		}
+
+		var k key
+		switch q.GroupBy {
+		case GroupByRetailer:
+			k.group = r.Retailer
+		case GroupByPaymentMethod:
+			k.group = r.PaymentMethod
+		case GroupByPostalCode:
+			if r.StoreLocation != nil {
+				k.group = r.StoreLocation.PostalCode
+			}
+		}
+		k.period = q.Period.Of(r.PurchaseDate)
+
+		switch q.Metric {
+		case MetricPoints:
+			sums[k] += rec.Points
+		case MetricTotal:
+			total, _ := strconv.ParseFloat(r.Total, 64)
+			sums[k] += int64(math.Round(total * 100))
+		default:
+			sums[k]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]AggregateBucket, 0, len(sums))
+	for k, sum := range sums {
+		buckets = append(buckets, AggregateBucket{Group: k.group, Period: k.period, Value: sum})
+	}
+	sortBuckets(buckets)
+	return buckets, nil
+}
+
+func sortBuckets(buckets []AggregateBucket) {
+	slices.SortFunc(buckets, func(a, b AggregateBucket) int {
+		return cmp.Or(cmp.Compare(a.Period, b.Period), cmp.Compare(a.Group, b.Group))
+	})
+}
+
+// Of names the period a purchase date falls in, "" for PeriodNone.
+func (p AggregatePeriod) Of(date string) string {
+	switch p {
+	case PeriodDay:
+		return date
+	case PeriodMonth:
+		if len(date) < 7 {
+			return date
+		}
+		return date[:7]
+	case PeriodWeek:
+		d, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return date
+		}
+		monday := d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+		return monday.Format(time.DateOnly)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CopyProgress counts what Copy did so far. Skipped receipts were in the destination already, e.g. from an earlier
+// run that was interrupted.
+type CopyProgress struct {
+	Copied  int
+	Skipped int
+	Keys    int
+	Elapsed time.Duration
+}
+
+// Copy puts every receipt of from into to, and every API key if both keep them. Receipts already in to are left as
+// they are, so an interrupted copy is resumed by running it again. progress, if not nil, is called at most once per
+// every interval while copying.
+func Copy(ctx context.Context, from, to Store, interval time.Duration, progress func(CopyProgress)) (CopyProgress, error) {
+	var p CopyProgress
+	start := time.Now()
+	lastReport := start
+	err := from.Scan(ctx, func(rec Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := to.Put(ctx, rec)
+		switch {
+		case errors.Is(err, ErrExists):
+			p.Skipped++
+		case err != nil:
+			return err
+		default:
+			p.Copied++
+		}
+		if now := time.Now(); progress != nil && now.Sub(lastReport) >= interval {
+			p.Elapsed = now.Sub(start)
+			progress(p)
+			lastReport = now
+		}
+		return nil
+	})
+	if err != nil {
+		p.Elapsed = time.Since(start)
+		return p, err
+	}
+
+	fromKeys, ok := from.(KeyStore)
+	toKeys, ok2 := to.(KeyStore)
+	if ok && ok2 {
+		keys, err := fromKeys.ListKeys(ctx)
+		if err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+		// keys are few and the source is the truth while migrating, so they are simply overwritten.
+		for _, key := range keys {
+			if err := toKeys.PutKey(ctx, key); err != nil {
+				p.Elapsed = time.Since(start)
+				return p, err
+			}
+			p.Keys++
+		}
+	}
+	p.Elapsed = time.Since(start)
+	return p, nil
+}
@@ -0,0 +1,65 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	from, to := store.NewMemory(), store.NewMemory()
+	for i := range 5 {
+		rec := store.Record{ID: fmt.Sprint(i), Points: int64(i), Receipt: []byte(`{}`), CreatedAt: time.Unix(int64(i), 0)}
+		if err := from.Put(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+		// an earlier, interrupted run got the first two across.
+		if i < 2 {
+			if err := to.Put(ctx, rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := from.PutKey(ctx, store.APIKey{ID: "partner", SecretHash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reports := 0
+	got, err := store.Copy(ctx, from, to, 0, func(store.CopyProgress) { reports++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Copied != 3 || got.Skipped != 2 || got.Keys != 1 {
+		t.Errorf("Copy() = %+v, want 3 copied, 2 skipped and 1 key", got)
+	}
+	if reports != 5 {
+		t.Errorf("progress reported %d times, want once per receipt with a zero interval", reports)
+	}
+	for i := range 5 {
+		if _, err := to.Get(ctx, fmt.Sprint(i)); err != nil {
+			t.Errorf("receipt %d wasn't copied: %v", i, err)
+		}
+	}
+	if _, err := to.GetKey(ctx, "partner"); err != nil {
+		t.Errorf("key wasn't copied: %v", err)
+	}
+}
+
+func TestCopyStopsOnError(t *testing.T) {
+	ctx := context.Background()
+	from := store.NewMemory()
+	if err := from.Put(ctx, store.Record{ID: "1", Receipt: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.Copy(ctx, from, storetest.NewFaulty(store.NewMemory(), 0, 1, 1), time.Second, nil)
+	if !errors.Is(err, storetest.ErrInjected) {
+		t.Errorf("Copy() error = %v, want %v", err, storetest.ErrInjected)
+	}
+}
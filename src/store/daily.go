@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// DailyDimension is what the daily aggregates group the receipts of a day by.
+type DailyDimension string
+
+const (
+	DailyRetailer      DailyDimension = "retailer"
+	DailyPaymentMethod DailyDimension = "paymentMethod"
+	// DailyLocation groups by the store location as JSON, for callers to place in regions of their own.
+	DailyLocation DailyDimension = "location"
+	// DailyHour groups by the hour of the purchase time, 00 to 23.
+	DailyHour DailyDimension = "hour"
+	// DailyAccount groups by the account a receipt was credited to, see CreditReceipt.
+	DailyAccount DailyDimension = "account"
+)
+
+// DailyDimensions are all the dimensions the daily aggregates keep.
+var DailyDimensions = []DailyDimension{DailyRetailer, DailyPaymentMethod, DailyLocation, DailyHour, DailyAccount}
+
+// DailyTotals is what the receipts of one day in one group add up to. Cents sums the receipt totals.
+type DailyTotals struct {
+	Date     string
+	Group    string
+	Receipts int64
+	Points   int64
+	Cents    int64
+}
+
+// DailyRebuild is the outcome of RebuildDaily. Drifted counts the buckets that differed from what was kept at write
+// time.
+type DailyRebuild struct {
+	Receipts int
+	Buckets  int
+	Drifted  int
+}
+
+// DailyAggregator is implemented by backends that keep daily aggregates of the receipts, updated in the same
+// transaction as every write of a receipt, so stats read a bucket per day and group instead of every receipt. Canary
+// receipts are left out.
+type DailyAggregator interface {
+	// Daily returns the totals of dim for the days between from and to, inclusive dates ("" for no bound), ordered by
+	// date, then group.
+	Daily(ctx context.Context, dim DailyDimension, from, to string) ([]DailyTotals, error)
+	// CreditReceipt records the account a stored receipt was credited to, moving it between the account totals. It
+	// returns ErrNotFound for unknown IDs.
+	CreditReceipt(ctx context.Context, id, account string) error
+	// RebuildDaily works the aggregates out again from the stored receipts and the accounts they were credited to.
+	RebuildDaily(ctx context.Context) (DailyRebuild, error)
+}
+
+type dailyKey struct {
+	dim         DailyDimension
+	date, group string
+}
+
+// dailyReceipt is the part of a receipt the daily aggregates read.
+type dailyReceipt struct {
+	Retailer      string          `json:"retailer"`
+	PurchaseDate  string          `json:"purchaseDate"`
+	PurchaseTime  string          `json:"purchaseTime"`
+	Total         string          `json:"total"`
+	PaymentMethod string          `json:"paymentMethod"`
+	StoreLocation json.RawMessage `json:"storeLocation"`
+	Canary        bool            `json:"canary"`
+}
+
+// dailyBuckets returns the bucket of every dimension rec counts towards, none for canaries, and the cents of its
+// total.
+func dailyBuckets(rec Record, account string) ([]dailyKey, int64) {
+	var r dailyReceipt
+	if json.Unmarshal(rec.Receipt, &r) != nil || r.Canary {
+		return nil, 0
+	}
+	var location bytes.Buffer
+	if len(r.StoreLocation) > 0 && string(r.StoreLocation) != "null" {
+		json.Compact(&location, r.StoreLocation)
+	}
+	hour := r.PurchaseTime
+	if len(hour) > 2 {
+		hour = hour[:2]
+	}
+	total, _ := strconv.ParseFloat(r.Total, 64)
+	return []dailyKey{
+		{DailyRetailer, r.PurchaseDate, r.Retailer},
+		{DailyPaymentMethod, r.PurchaseDate, r.PaymentMethod},
+		{DailyLocation, r.PurchaseDate, location.String()},
+		{DailyHour, r.PurchaseDate, hour},
+		{DailyAccount, r.PurchaseDate, account},
+	}, int64(math.Round(total * 100))
+}
+
+// dailySums are the daily aggregates held in memory.
+type dailySums map[dailyKey]DailyTotals
+
+// add adds what rec contributes to the sums, or takes it back out with sign -1. Buckets that drop to no receipts are
+// removed.
+func (s dailySums) add(rec Record, account string, sign int64) {
+	keys, cents := dailyBuckets(rec, account)
+	for _, k := range keys {
+		t := s[k]
+		t.Date, t.Group = k.date, k.group
+		t.Receipts += sign
+		t.Points += sign * rec.Points
+		t.Cents += sign * cents
+		if t.Receipts == 0 {
+			delete(s, k)
+		} else {
+			s[k] = t
+		}
+	}
+}
+
+// totals returns the sums of dim between the two inclusive dates, ordered by date, then group.
+func (s dailySums) totals(dim DailyDimension, from, to string) []DailyTotals {
+	var totals []DailyTotals
+	for k, t := range s {
+		// dates in this format compare like strings.
+		if k.dim == dim && (from == "" || k.date >= from) && (to == "" || k.date <= to) {
+			totals = append(totals, t)
+		}
+	}
+	sortDaily(totals)
+	return totals
+}
+
+// drifted counts the buckets that are in only one of s and fresh, or differ.
+func (s dailySums) drifted(fresh dailySums) int {
+	n := 0
+	for k, t := range fresh {
+		if s[k] != t {
+			n++
+		}
+	}
+	for k := range s {
+		if _, ok := fresh[k]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+func sortDaily(totals []DailyTotals) {
+	slices.SortFunc(totals, func(a, b DailyTotals) int {
+		return cmp.Or(cmp.Compare(a.Date, b.Date), cmp.Compare(a.Group, b.Group))
+	})
+}
+
+// ScanDaily works out the daily totals of dim from a scan, for backends that don't keep them. Those don't know the
+// accounts either, every receipt is in the "" account.
+func ScanDaily(ctx context.Context, s Store, dim DailyDimension, from, to string) ([]DailyTotals, error) {
+	sums := dailySums{}
+	err := s.Scan(ctx, func(rec Record) error {
+		sums.add(rec, "", 1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sums.totals(dim, from, to), nil
+}
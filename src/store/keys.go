@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("API key not found")
+
+// APIKey is a partner's API key. Only a hash of the secret is kept; PreviousSecretHash stays valid until
+// PreviousExpiresAt so a rotated key keeps working while the partner switches over.
+type APIKey struct {
+	ID                 string     `json:"id"`
+	SecretHash         string     `json:"secretHash"`
+	PreviousSecretHash string     `json:"previousSecretHash,omitempty"`
+	PreviousExpiresAt  *time.Time `json:"previousExpiresAt,omitempty"`
+	Scopes             []string   `json:"scopes"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt          *time.Time `json:"revokedAt,omitempty"`
+}
+
+// KeyStore is implemented by backends that can also keep API keys, next to the receipts so every replica sees the
+// same keys.
+type KeyStore interface {
+	// PutKey creates or replaces the key with key.ID.
+	PutKey(ctx context.Context, key APIKey) error
+	// GetKey returns ErrKeyNotFound for unknown IDs.
+	GetKey(ctx context.Context, id string) (APIKey, error)
+	// ListKeys returns every key, revoked ones included, ordered by ID.
+	ListKeys(ctx context.Context) ([]APIKey, error)
+}
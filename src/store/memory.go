@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps everything in process memory, it is the default and loses all data on restart.
+type Memory struct {
+	// using sync.Map instead of map+mutex because the requirements for this app fall specifically into what sync.Map
+	// is recommended for: https://pkg.go.dev/sync#Map
+	records sync.Map
+	keys    sync.Map
+	// updates serializes the writes of records, so an update can't bring a deleted record back and the daily
+	// aggregates change along with the records.
+	updates  sync.Mutex
+	daily    dailySums
+	accounts map[string]string // receipt ID -> the account it was credited to
+	nonceMu  sync.Mutex
+	nonces   map[string]time.Time // nonce -> claim expiry
+}
+
+func NewMemory() *Memory {
+	return &Memory{daily: dailySums{}, accounts: map[string]string{}}
+}
+
+func (m *Memory) Put(ctx context.Context, rec Record) error {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	if _, loaded := m.records.LoadOrStore(rec.ID, rec); loaded {
+		return ErrExists
+	}
+	m.daily.add(rec, "", 1)
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (Record, error) {
+	rec, ok := m.records.Load(id)
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return rec.(Record), nil
+}
+
+func (m *Memory) Update(ctx context.Context, rec Record) error {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	old, ok := m.records.Load(rec.ID)
+	if !ok {
+		return ErrNotFound
+	}
+	rec.CreatedAt = old.(Record).CreatedAt
+	m.records.Store(rec.ID, rec)
+	m.daily.add(old.(Record), m.accounts[rec.ID], -1)
+	m.daily.add(rec, m.accounts[rec.ID], 1)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	old, loaded := m.records.LoadAndDelete(id)
+	if !loaded {
+		return ErrNotFound
+	}
+	m.daily.add(old.(Record), m.accounts[id], -1)
+	delete(m.accounts, id)
+	return nil
+}
+
+// List has to look at every record, which is fine for the data sets the memory backend is meant for.
+func (m *Memory) List(ctx context.Context, opts ListOptions) ([]Record, error) {
+	var records []Record
+	m.records.Range(func(_, v any) bool {
+		records = append(records, v.(Record))
+		return true
+	})
+	return Page(records, opts), nil
+}
+
+func (m *Memory) Scan(ctx context.Context, fn func(Record) error) error {
+	var err error
+	m.records.Range(func(_, v any) bool {
+		err = fn(v.(Record))
+		return err == nil
+	})
+	return err
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) PutKey(ctx context.Context, key APIKey) error {
+	m.keys.Store(key.ID, key)
+	return nil
+}
+
+func (m *Memory) GetKey(ctx context.Context, id string) (APIKey, error) {
+	key, ok := m.keys.Load(id)
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return key.(APIKey), nil
+}
+
+func (m *Memory) ListKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	m.keys.Range(func(_, v any) bool {
+		keys = append(keys, v.(APIKey))
+		return true
+	})
+	slices.SortFunc(keys, func(a, b APIKey) int { return strings.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+func (m *Memory) Daily(ctx context.Context, dim DailyDimension, from, to string) ([]DailyTotals, error) {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	return m.daily.totals(dim, from, to), nil
+}
+
+func (m *Memory) CreditReceipt(ctx context.Context, id, account string) error {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	rec, ok := m.records.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	m.daily.add(rec.(Record), m.accounts[id], -1)
+	m.daily.add(rec.(Record), account, 1)
+	m.accounts[id] = account
+	return nil
+}
+
+// RebuildDaily holds off writes while it goes through the records, they are all in memory anyway.
+func (m *Memory) RebuildDaily(ctx context.Context) (DailyRebuild, error) {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	var run DailyRebuild
+	fresh := dailySums{}
+	m.records.Range(func(_, v any) bool {
+		rec := v.(Record)
+		fresh.add(rec, m.accounts[rec.ID], 1)
+		run.Receipts++
+		return true
+	})
+	run.Buckets, run.Drifted = len(fresh), m.daily.drifted(fresh)
+	m.daily = fresh
+	return run, nil
+}
+
+// ClaimNonce forgets expired claims on the way.
+func (m *Memory) ClaimNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	m.nonceMu.Lock()
+	defer m.nonceMu.Unlock()
+	now := time.Now()
+	for k, exp := range m.nonces {
+		if !now.Before(exp) {
+			delete(m.nonces, k)
+		}
+	}
+	if _, ok := m.nonces[nonce]; ok {
+		return false, nil
+	}
+	if m.nonces == nil {
+		m.nonces = map[string]time.Time{}
+	}
+	m.nonces[nonce] = expires
+	return true, nil
+}
+
+func (m *Memory) ReleaseNonce(ctx context.Context, nonce string) error {
+	m.nonceMu.Lock()
+	defer m.nonceMu.Unlock()
+	delete(m.nonces, nonce)
+	return nil
+}
+
+// Stats has to scan, everything is in memory anyway.
+func (m *Memory) Stats(ctx context.Context) (Stats, error) {
+	return ScanStats(ctx, m)
+}
@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		return store.NewMemory()
+	})
+}
@@ -0,0 +1,22 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := store.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 || m.Name == "" {
+			t.Errorf("migration %d = %d_%s, want version %d with a name", i, m.Version, m.Name, i+1)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS receipts (
+	id         TEXT PRIMARY KEY,
+	points     BIGINT NOT NULL,
+	receipt    JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS receipts_created_at ON receipts (created_at DESC, id DESC);
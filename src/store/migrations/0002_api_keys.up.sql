@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id  TEXT PRIMARY KEY,
+	key JSONB NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS receipts_purchased;
+DROP INDEX IF EXISTS receipts_total;
+DROP INDEX IF EXISTS receipts_points;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS receipts_points ON receipts (points DESC, id DESC);
+CREATE INDEX IF NOT EXISTS receipts_total ON receipts (((receipt->>'total')::numeric) DESC, id DESC);
+CREATE INDEX IF NOT EXISTS receipts_purchased ON receipts (((receipt->>'purchaseDate') || ' ' || (receipt->>'purchaseTime')) DESC, id DESC);
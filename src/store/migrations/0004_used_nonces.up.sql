@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS used_nonces (
+	nonce      TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
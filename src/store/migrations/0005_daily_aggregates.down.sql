@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS daily_aggregates;
+ALTER TABLE receipts DROP COLUMN IF EXISTS account;
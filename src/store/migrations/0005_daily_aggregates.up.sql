@@ -0,0 +1,24 @@
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS account TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS daily_aggregates (
+	dimension TEXT NOT NULL,
+	day       TEXT NOT NULL,
+	grp       TEXT NOT NULL,
+	receipts  BIGINT NOT NULL,
+	points    BIGINT NOT NULL,
+	cents     BIGINT NOT NULL,
+	PRIMARY KEY (dimension, day, grp)
+);
+-- the receipts stored so far, none of them credited to an account yet as far as the table knows.
+INSERT INTO daily_aggregates (dimension, day, grp, receipts, points, cents)
+SELECT d.dimension, coalesce(receipt->>'purchaseDate', ''), d.grp, count(*), sum(points)::bigint,
+	sum(coalesce(round((receipt->>'total')::numeric * 100), 0))::bigint
+FROM receipts CROSS JOIN LATERAL (VALUES
+	('retailer', coalesce(receipt->>'retailer', '')),
+	('paymentMethod', coalesce(receipt->>'paymentMethod', '')),
+	('location', coalesce(receipt->>'storeLocation', '')),
+	('hour', coalesce(left(receipt->>'purchaseTime', 2), '')),
+	('account', account)
+) AS d (dimension, grp)
+WHERE NOT coalesce((receipt->>'canary')::boolean, false)
+GROUP BY 1, 2, 3
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// NonceStore is implemented by backends that can claim one-time nonces, next to the receipts so a nonce is used once
+// across every replica and restart.
+type NonceStore interface {
+	// ClaimNonce marks nonce as used until expires and reports whether it was free. It is atomic: of concurrent
+	// claims of the same nonce only one wins. A nonce is free again once its claim expired.
+	ClaimNonce(ctx context.Context, nonce string, expires time.Time) (bool, error)
+	// ReleaseNonce frees a claimed nonce.
+	ReleaseNonce(ctx context.Context, nonce string) error
+}
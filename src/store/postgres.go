@@ -0,0 +1,462 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Postgres stores receipts in a single table. Its schema is managed by the migrations in migrations/.
+type Postgres struct {
+	db *sql.DB
+}
+
+// NewPostgres connects to dsn and applies any pending migration.
+func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	p, err := ConnectPostgres(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.MigrateUp(ctx); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ConnectPostgres connects to dsn without touching the schema, for managing the migrations themselves.
+func ConnectPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Postgres{db: db}, nil
+}
+
+func (p *Postgres) Put(ctx context.Context, rec Record) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO receipts (id, points, receipt, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING`,
+			rec.ID, rec.Points, []byte(rec.Receipt), rec.CreatedAt)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrExists
+		}
+		return addDaily(ctx, tx, rec.ID, 1)
+	})
+}
+
+func (p *Postgres) Get(ctx context.Context, id string) (Record, error) {
+	rec := Record{ID: id}
+	var receipt []byte
+	err := p.db.QueryRowContext(ctx, `SELECT points, receipt, created_at FROM receipts WHERE id = $1`, id).
+		Scan(&rec.Points, &receipt, &rec.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, ErrNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	rec.Receipt = receipt
+	return rec, nil
+}
+
+func (p *Postgres) Update(ctx context.Context, rec Record) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if err := lockReceipt(ctx, tx, rec.ID); err != nil {
+			return err
+		}
+		if err := addDaily(ctx, tx, rec.ID, -1); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE receipts SET points = $2, receipt = $3 WHERE id = $1`, rec.ID, rec.Points, []byte(rec.Receipt)); err != nil {
+			return err
+		}
+		return addDaily(ctx, tx, rec.ID, 1)
+	})
+}
+
+func (p *Postgres) Delete(ctx context.Context, id string) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if err := lockReceipt(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := addDaily(ctx, tx, id, -1); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM receipts WHERE id = $1`, id)
+		return err
+	})
+}
+
+// inTx runs fn in a transaction, which is committed if fn returns nil and rolled back otherwise.
+func (p *Postgres) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockReceipt locks a receipt's row until the end of tx, so its contribution to the daily aggregates can be taken
+// back out and put in again without a concurrent write in between.
+func lockReceipt(ctx context.Context, tx *sql.Tx, id string) error {
+	var locked int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM receipts WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// postgresDailyRows are the rows of the daily aggregates the receipts matching a condition add up to, each one times
+// $1. Migration 0005 filled the table with the same expressions.
+const postgresDailyRows = `SELECT d.dimension, coalesce(receipt->>'purchaseDate', '') AS day, d.grp, count(*) * $1 AS receipts,
+	sum(points)::bigint * $1 AS points, sum(coalesce(round((receipt->>'total')::numeric * 100), 0))::bigint * $1 AS cents
+FROM receipts CROSS JOIN LATERAL (VALUES
+	('retailer', coalesce(receipt->>'retailer', '')),
+	('paymentMethod', coalesce(receipt->>'paymentMethod', '')),
+	('location', coalesce(receipt->>'storeLocation', '')),
+	('hour', coalesce(left(receipt->>'purchaseTime', 2), '')),
+	('account', account)
+) AS d (dimension, grp)
+WHERE NOT coalesce((receipt->>'canary')::boolean, false) AND %s
+GROUP BY 1, 2, 3`
+
+// addDaily adds the receipt's contribution to the daily aggregates, or takes it back out with sign -1. Buckets that
+// drop to no receipts are left at zero for Daily to skip, until the next RebuildDaily drops them.
+func addDaily(ctx context.Context, tx *sql.Tx, id string, sign int64) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO daily_aggregates (dimension, day, grp, receipts, points, cents) `+
+		fmt.Sprintf(postgresDailyRows, `id = $2`)+`
+		ON CONFLICT (dimension, day, grp) DO UPDATE SET receipts = daily_aggregates.receipts + excluded.receipts,
+			points = daily_aggregates.points + excluded.points, cents = daily_aggregates.cents + excluded.cents`, sign, id)
+	return err
+}
+
+func (p *Postgres) Daily(ctx context.Context, dim DailyDimension, from, to string) ([]DailyTotals, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT day, grp, receipts, points, cents FROM daily_aggregates
+		WHERE dimension = $1 AND receipts <> 0 AND ($2 = '' OR day >= $2) AND ($3 = '' OR day <= $3)`, string(dim), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []DailyTotals
+	for rows.Next() {
+		var t DailyTotals
+		if err := rows.Scan(&t.Date, &t.Group, &t.Receipts, &t.Points, &t.Cents); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// in Go, so groups order by bytes like the other backends rather than by the database's collation.
+	sortDaily(totals)
+	return totals, nil
+}
+
+func (p *Postgres) CreditReceipt(ctx context.Context, id, account string) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if err := lockReceipt(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := addDaily(ctx, tx, id, -1); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE receipts SET account = $2 WHERE id = $1`, id, account); err != nil {
+			return err
+		}
+		return addDaily(ctx, tx, id, 1)
+	})
+}
+
+// RebuildDaily works the aggregates out in SQL and swaps them in, in one transaction. It locks out writes to the
+// receipts table meanwhile, so none of them are lost from the aggregates.
+func (p *Postgres) RebuildDaily(ctx context.Context) (DailyRebuild, error) {
+	var run DailyRebuild
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE receipts IN SHARE MODE`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE fresh_daily_aggregates (LIKE daily_aggregates) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO fresh_daily_aggregates `+fmt.Sprintf(postgresDailyRows, `true`), 1); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, `SELECT count(*) FROM fresh_daily_aggregates f
+			FULL JOIN (SELECT * FROM daily_aggregates WHERE receipts <> 0) d USING (dimension, day, grp)
+			WHERE (f.receipts, f.points, f.cents) IS DISTINCT FROM (d.receipts, d.points, d.cents)`).Scan(&run.Drifted)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM daily_aggregates`); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO daily_aggregates (dimension, day, grp, receipts, points, cents)
+			SELECT * FROM fresh_daily_aggregates`)
+		if err != nil {
+			return err
+		}
+		buckets, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		run.Buckets = int(buckets)
+		return tx.QueryRowContext(ctx, `SELECT count(*) FROM receipts`).Scan(&run.Receipts)
+	})
+	if err != nil {
+		return DailyRebuild{}, err
+	}
+	return run, nil
+}
+
+// postgresSortColumns are what the sort fields order by, each with an index from migration 0003 (created_at's is from
+// 0001), and turn a cursor's value into a parameter to compare against.
+var postgresSortColumns = map[SortField]struct {
+	expr  string
+	param func(float64) any
+}{
+	SortProcessed: {`created_at`, func(v float64) any { return time.UnixMicro(int64(v)).UTC() }},
+	SortPoints:    {`points`, func(v float64) any { return int64(v) }},
+	SortTotal:     {`((receipt->>'total')::numeric)`, func(v float64) any { return strconv.FormatFloat(v, 'f', -1, 64) }},
+	SortPurchased: {`((receipt->>'purchaseDate') || ' ' || (receipt->>'purchaseTime'))`, func(v float64) any {
+		return time.Unix(int64(v), 0).UTC().Format("2006-01-02 15:04")
+	}},
+}
+
+// List seeks to the cursor with a row comparison, which the sort field's index answers without reading the records
+// before it.
+func (p *Postgres) List(ctx context.Context, opts ListOptions) ([]Record, error) {
+	column := postgresSortColumns[opts.sort()]
+	direction, seek := "DESC", "<"
+	if opts.Ascending {
+		direction, seek = "ASC", ">"
+	}
+	query := `SELECT id, points, receipt, created_at FROM receipts`
+	args := []any{opts.limit()}
+	if opts.After != nil {
+		query += fmt.Sprintf(` WHERE (%s, id) %s ($2, $3)`, column.expr, seek)
+		args = append(args, column.param(opts.After.Value), opts.After.ID)
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT $1`, column.expr, direction, direction)
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		var receipt []byte
+		if err := rows.Scan(&rec.ID, &rec.Points, &receipt, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.Receipt = receipt
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// postgresAggregates are the SQL for the parts of an AggregateQuery.
+var (
+	postgresGroups = map[AggregateField]string{
+		GroupByNone:          `''`,
+		GroupByRetailer:      `coalesce(receipt->>'retailer', '')`,
+		GroupByPaymentMethod: `coalesce(receipt->>'paymentMethod', '')`,
+		GroupByPostalCode:    `coalesce(receipt#>>'{storeLocation,postalCode}', '')`,
+	}
+	postgresPeriods = map[AggregatePeriod]string{
+		PeriodNone:  `''`,
+		PeriodDay:   `receipt->>'purchaseDate'`,
+		PeriodWeek:  `to_char(date_trunc('week', (receipt->>'purchaseDate')::date), 'YYYY-MM-DD')`,
+		PeriodMonth: `left(receipt->>'purchaseDate', 7)`,
+	}
+	postgresMetrics = map[AggregateMetric]string{
+		MetricReceipts: `count(*)`,
+		MetricPoints:   `sum(points)::bigint`,
+		MetricTotal:    `sum(round((receipt->>'total')::numeric * 100))::bigint`,
+	}
+)
+
+// Aggregate groups and sums in the database, so only the buckets come back.
+func (p *Postgres) Aggregate(ctx context.Context, q AggregateQuery) ([]AggregateBucket, error) {
+	group, period, metric := postgresGroups[q.GroupBy], postgresPeriods[q.Period], postgresMetrics[q.Metric]
+	if group == "" || period == "" || metric == "" {
+		return nil, fmt.Errorf("unsupported aggregate %+v", q)
+	}
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s, %s, %s FROM receipts
+		WHERE ($1 = '' OR receipt->>'purchaseDate' >= $1) AND ($2 = '' OR receipt->>'purchaseDate' <= $2)
+		GROUP BY 1, 2`, group, period, metric), q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []AggregateBucket
+	for rows.Next() {
+		var b AggregateBucket
+		if err := rows.Scan(&b.Group, &b.Period, &b.Value); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// in Go, so groups order by bytes like the other backends rather than by the database's collation.
+	sortBuckets(buckets)
+	return buckets, nil
+}
+
+func (p *Postgres) Scan(ctx context.Context, fn func(Record) error) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, points, receipt, created_at FROM receipts`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec Record
+		var receipt []byte
+		if err := rows.Scan(&rec.ID, &rec.Points, &receipt, &rec.CreatedAt); err != nil {
+			return err
+		}
+		rec.Receipt = receipt
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PutKey keeps the whole key as JSON, nothing queries its fields.
+func (p *Postgres) PutKey(ctx context.Context, key APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `INSERT INTO api_keys (id, key) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET key = $2`, key.ID, data)
+	return err
+}
+
+func (p *Postgres) GetKey(ctx context.Context, id string) (APIKey, error) {
+	var data []byte
+	err := p.db.QueryRowContext(ctx, `SELECT key FROM api_keys WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	var key APIKey
+	err = json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (p *Postgres) ListKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT key FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var key APIKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// ClaimNonce inserts the nonce, or takes over an expired claim of it. Claims that expired are deleted first, nonces
+// are random so they would never be claimed again.
+func (p *Postgres) ClaimNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM used_nonces WHERE expires_at <= now()`); err != nil {
+		return false, err
+	}
+	res, err := p.db.ExecContext(ctx,
+		`INSERT INTO used_nonces (nonce, expires_at) VALUES ($1, $2)
+		ON CONFLICT (nonce) DO UPDATE SET expires_at = $2 WHERE used_nonces.expires_at <= now()`,
+		nonce, expires)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (p *Postgres) ReleaseNonce(ctx context.Context, nonce string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM used_nonces WHERE nonce = $1`, nonce)
+	return err
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+// Compact vacuums the receipts table. A plain VACUUM doesn't lock out writers but only returns trailing free pages to
+// the OS, the rest is reused by later inserts, so Reclaimed is often small even when there was garbage to collect.
+func (p *Postgres) Compact(ctx context.Context) (CompactResult, error) {
+	var result CompactResult
+	if err := p.db.QueryRowContext(ctx, `SELECT pg_total_relation_size('receipts')`).Scan(&result.SizeBefore); err != nil {
+		return CompactResult{}, err
+	}
+	if _, err := p.db.ExecContext(ctx, `VACUUM (ANALYZE) receipts`); err != nil {
+		return CompactResult{}, err
+	}
+	if err := p.db.QueryRowContext(ctx, `SELECT pg_total_relation_size('receipts')`).Scan(&result.SizeAfter); err != nil {
+		return CompactResult{}, err
+	}
+	result.Reclaimed = max(result.SizeBefore-result.SizeAfter, 0)
+	return result, nil
+}
+
+// Stats counts the rows and takes the size of the receipts table, indexes and TOAST included.
+func (p *Postgres) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	var oldest, newest sql.NullTime
+	err := p.db.QueryRowContext(ctx, `SELECT count(*), min(created_at), max(created_at), pg_total_relation_size('receipts') FROM receipts`).
+		Scan(&stats.Records, &oldest, &newest, &stats.SizeBytes)
+	if err != nil {
+		return Stats{}, err
+	}
+	if oldest.Valid {
+		stats.Oldest, stats.Newest = &oldest.Time, &newest.Time
+	}
+	if err := p.db.QueryRowContext(ctx, `SELECT count(*) FROM api_keys`).Scan(&stats.APIKeys); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,535 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix = "fcpc:receipt:"
+	// redisIndexKey is a sorted set of IDs scored by creation time, for List and Scan.
+	redisIndexKey = "fcpc:receipts:by-created"
+	// redisAPIKeysKey is a hash of key ID -> API key JSON.
+	redisAPIKeysKey = "fcpc:api-keys"
+	// redisNoncePrefix keys a claimed nonce, expiring with its claim.
+	redisNoncePrefix = "fcpc:nonce:"
+	// redisDailyPrefix keys the hashes of the daily aggregates, fcpc:daily:<dimension>:<receipts|points|cents>, of
+	// "<date> <group>" -> sum.
+	redisDailyPrefix = "fcpc:daily:"
+)
+
+// redisSortKeys are sorted sets of IDs scored by SortField.Value, for List. Redis orders members with the same score by
+// ID, the way the other backends break ties.
+var redisSortKeys = map[SortField]string{
+	SortProcessed: redisIndexKey,
+	SortPoints:    "fcpc:receipts:by-points",
+	SortTotal:     "fcpc:receipts:by-total",
+	SortPurchased: "fcpc:receipts:by-purchased",
+}
+
+// redisRecord is what is kept under a record's key, with the account it was credited to.
+type redisRecord struct {
+	Record
+	Account string `json:"account,omitempty"`
+}
+
+// Redis stores each record as a JSON string under fcpc:receipt:<id>.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis takes a redis:// URL as dsn.
+func NewRedis(ctx context.Context, dsn string) (*Redis, error) {
+	opts, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	r := &Redis{client: client}
+	if err := r.indexSortFields(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := r.buildDaily(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// indexSortFields fills the sort indexes of records stored before they existed, going by their size.
+func (r *Redis) indexSortFields(ctx context.Context) error {
+	records, err := r.client.ZCard(ctx, redisIndexKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, key := range redisSortKeys {
+		n, err := r.client.ZCard(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if n < records {
+			return r.Scan(ctx, func(rec Record) error { return r.index(ctx, rec) })
+		}
+	}
+	return nil
+}
+
+// buildDaily builds the daily aggregates of records stored before they were kept.
+func (r *Redis) buildDaily(ctx context.Context) error {
+	records, err := r.client.ZCard(ctx, redisIndexKey).Result()
+	if err != nil {
+		return err
+	}
+	built, err := r.client.Exists(ctx, redisDailyKeys(DailyRetailer)...).Result()
+	if err != nil || records == 0 || built > 0 {
+		return err
+	}
+	_, err = r.RebuildDaily(ctx)
+	return err
+}
+
+// index adds rec to the sort indexes, or moves it to its new place in them.
+func (r *Redis) index(ctx context.Context, rec Record) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		queueIndex(ctx, pipe, rec)
+		return nil
+	})
+	return err
+}
+
+func queueIndex(ctx context.Context, pipe redis.Pipeliner, rec Record) {
+	for field, key := range redisSortKeys {
+		pipe.ZAdd(ctx, key, redis.Z{Score: field.Value(rec), Member: rec.ID})
+	}
+}
+
+// redisDailyKeys are the hashes of dim's receipts, points and cents.
+func redisDailyKeys(dim DailyDimension) []string {
+	prefix := redisDailyPrefix + string(dim) + ":"
+	return []string{prefix + "receipts", prefix + "points", prefix + "cents"}
+}
+
+// queueDaily adds what rec contributes to the daily aggregates, or takes it back out with sign -1. Buckets that drop
+// to no receipts are left at zero for Daily to skip, until the next RebuildDaily drops them.
+func queueDaily(ctx context.Context, pipe redis.Pipeliner, rec redisRecord, sign int64) {
+	keys, cents := dailyBuckets(rec.Record, rec.Account)
+	for _, k := range keys {
+		hashes, field := redisDailyKeys(k.dim), k.date+" "+k.group
+		pipe.HIncrBy(ctx, hashes[0], field, sign)
+		pipe.HIncrBy(ctx, hashes[1], field, sign*rec.Points)
+		pipe.HIncrBy(ctx, hashes[2], field, sign*cents)
+	}
+}
+
+// write reads the record stored under id, nil if there is none, and runs the writes fn queues in a transaction that
+// only goes through if the record didn't change meanwhile. It is retried until it does.
+func (r *Redis) write(ctx context.Context, id string, fn func(old *redisRecord, pipe redis.Pipeliner) error) error {
+	key := redisKeyPrefix + id
+	for {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			var old *redisRecord
+			data, err := tx.Get(ctx, key).Bytes()
+			switch {
+			case errors.Is(err, redis.Nil):
+			case err != nil:
+				return err
+			default:
+				old = &redisRecord{}
+				if err := json.Unmarshal(data, old); err != nil {
+					return err
+				}
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error { return fn(old, pipe) })
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+}
+
+// Put is a transaction with the daily aggregates and the sort indexes.
+func (r *Redis) Put(ctx context.Context, rec Record) error {
+	data, err := json.Marshal(redisRecord{Record: rec})
+	if err != nil {
+		return err
+	}
+	return r.write(ctx, rec.ID, func(old *redisRecord, pipe redis.Pipeliner) error {
+		if old != nil {
+			return ErrExists
+		}
+		pipe.Set(ctx, redisKeyPrefix+rec.ID, data, 0)
+		queueIndex(ctx, pipe, rec)
+		queueDaily(ctx, pipe, redisRecord{Record: rec}, 1)
+		return nil
+	})
+}
+
+func (r *Redis) Get(ctx context.Context, id string) (Record, error) {
+	data, err := r.client.Get(ctx, redisKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Record{}, ErrNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func (r *Redis) Update(ctx context.Context, rec Record) error {
+	return r.write(ctx, rec.ID, func(old *redisRecord, pipe redis.Pipeliner) error {
+		if old == nil {
+			return ErrNotFound
+		}
+		rec.CreatedAt = old.CreatedAt
+		next := redisRecord{Record: rec, Account: old.Account}
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, redisKeyPrefix+rec.ID, data, 0)
+		queueIndex(ctx, pipe, rec)
+		queueDaily(ctx, pipe, *old, -1)
+		queueDaily(ctx, pipe, next, 1)
+		return nil
+	})
+}
+
+func (r *Redis) Delete(ctx context.Context, id string) error {
+	return r.write(ctx, id, func(old *redisRecord, pipe redis.Pipeliner) error {
+		if old == nil {
+			return ErrNotFound
+		}
+		pipe.Del(ctx, redisKeyPrefix+id)
+		for _, key := range redisSortKeys {
+			pipe.ZRem(ctx, key, id)
+		}
+		queueDaily(ctx, pipe, *old, -1)
+		return nil
+	})
+}
+
+func (r *Redis) CreditReceipt(ctx context.Context, id, account string) error {
+	return r.write(ctx, id, func(old *redisRecord, pipe redis.Pipeliner) error {
+		if old == nil {
+			return ErrNotFound
+		}
+		next := *old
+		next.Account = account
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, redisKeyPrefix+id, data, 0)
+		queueDaily(ctx, pipe, *old, -1)
+		queueDaily(ctx, pipe, next, 1)
+		return nil
+	})
+}
+
+// Daily reads the whole of dim's hashes and picks the days out, they hold a field per day and group rather than per
+// receipt.
+func (r *Redis) Daily(ctx context.Context, dim DailyDimension, from, to string) ([]DailyTotals, error) {
+	sums, err := r.readDaily(ctx, r.client, dim)
+	if err != nil {
+		return nil, err
+	}
+	return sums.totals(dim, from, to), nil
+}
+
+// readDaily reads dim's hashes into sums, skipping the buckets at zero.
+func (r *Redis) readDaily(ctx context.Context, c redis.Cmdable, dim DailyDimension) (dailySums, error) {
+	var hashes [3]map[string]string
+	for i, key := range redisDailyKeys(dim) {
+		var err error
+		if hashes[i], err = c.HGetAll(ctx, key).Result(); err != nil {
+			return nil, err
+		}
+	}
+	sums := dailySums{}
+	for field, receipts := range hashes[0] {
+		date, group, _ := strings.Cut(field, " ")
+		t := DailyTotals{Date: date, Group: group}
+		t.Receipts, _ = strconv.ParseInt(receipts, 10, 64)
+		t.Points, _ = strconv.ParseInt(hashes[1][field], 10, 64)
+		t.Cents, _ = strconv.ParseInt(hashes[2][field], 10, 64)
+		if t.Receipts != 0 {
+			sums[dailyKey{dim, date, group}] = t
+		}
+	}
+	return sums, nil
+}
+
+// redisRebuildAttempts is how often RebuildDaily starts over because receipts were written while it scanned.
+const redisRebuildAttempts = 3
+
+// RebuildDaily works the aggregates out from a scan and swaps them in, unless a write changed them meanwhile; then it
+// starts over.
+func (r *Redis) RebuildDaily(ctx context.Context) (DailyRebuild, error) {
+	var keys []string
+	for _, dim := range DailyDimensions {
+		keys = append(keys, redisDailyKeys(dim)...)
+	}
+	for range redisRebuildAttempts {
+		var run DailyRebuild
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			old := dailySums{}
+			for _, dim := range DailyDimensions {
+				sums, err := r.readDaily(ctx, tx, dim)
+				if err != nil {
+					return err
+				}
+				maps.Copy(old, sums)
+			}
+			fresh := dailySums{}
+			err := r.scan(ctx, func(rec redisRecord) error {
+				fresh.add(rec.Record, rec.Account, 1)
+				run.Receipts++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			run.Buckets, run.Drifted = len(fresh), old.drifted(fresh)
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, keys...)
+				for k, t := range fresh {
+					hashes, field := redisDailyKeys(k.dim), k.date+" "+k.group
+					pipe.HSet(ctx, hashes[0], field, t.Receipts)
+					pipe.HSet(ctx, hashes[1], field, t.Points)
+					pipe.HSet(ctx, hashes[2], field, t.Cents)
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return run, err
+		}
+	}
+	return DailyRebuild{}, errors.New("receipts kept being written while the daily aggregates were rebuilt")
+}
+
+// List reads the sort field's index from the cursor's score on. Records with that very score up to the cursor's ID
+// come first and are skipped, which takes another round trip only when more than a page of them share it.
+func (r *Redis) List(ctx context.Context, opts ListOptions) ([]Record, error) {
+	args := redis.ZRangeArgs{Key: redisSortKeys[opts.sort()], Start: "-inf", Stop: "+inf", ByScore: true, Rev: !opts.Ascending}
+	if opts.After != nil {
+		score := strconv.FormatFloat(opts.After.Value, 'f', -1, 64)
+		if opts.Ascending {
+			args.Start = score
+		} else {
+			args.Stop = score
+		}
+	}
+
+	var ids []string
+	for len(ids) < opts.limit() {
+		args.Count = int64(opts.limit() - len(ids))
+		page, err := r.client.ZRangeArgsWithScores(ctx, args).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range page {
+			id := z.Member.(string)
+			if opts.After == nil || opts.compare(Cursor{Value: z.Score, ID: id}, *opts.After) > 0 {
+				ids = append(ids, id)
+			}
+		}
+		if len(page) < int(args.Count) {
+			break
+		}
+		args.Offset += int64(len(page))
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisKeyPrefix + id
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(values))
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// redisScanBatch is how many records Scan fetches per round trip.
+const redisScanBatch = 500
+
+// Scan walks the index in batches, oldest first.
+func (r *Redis) Scan(ctx context.Context, fn func(Record) error) error {
+	return r.scan(ctx, func(rec redisRecord) error { return fn(rec.Record) })
+}
+
+func (r *Redis) scan(ctx context.Context, fn func(redisRecord) error) error {
+	for start := int64(0); ; start += redisScanBatch {
+		ids, err := r.client.ZRange(ctx, redisIndexKey, start, start+redisScanBatch-1).Result()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = redisKeyPrefix + id
+		}
+		values, err := r.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			data, ok := v.(string)
+			if !ok {
+				continue
+			}
+			var rec redisRecord
+			if err := json.Unmarshal([]byte(data), &rec); err != nil {
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Redis) PutKey(ctx context.Context, key APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, redisAPIKeysKey, key.ID, data).Err()
+}
+
+func (r *Redis) GetKey(ctx context.Context, id string) (APIKey, error) {
+	data, err := r.client.HGet(ctx, redisAPIKeysKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	var key APIKey
+	err = json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (r *Redis) ListKeys(ctx context.Context) ([]APIKey, error) {
+	values, err := r.client.HVals(ctx, redisAPIKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(values))
+	for _, data := range values {
+		var key APIKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int { return strings.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+// ClaimNonce is a SET NX with the claim's expiry as the key's TTL.
+func (r *Redis) ClaimNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return r.client.SetNX(ctx, redisNoncePrefix+nonce, 1, ttl).Result()
+}
+
+func (r *Redis) ReleaseNonce(ctx context.Context, nonce string) error {
+	return r.client.Del(ctx, redisNoncePrefix+nonce).Err()
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Stats reads the counts and the ends of the index, and the memory and evictions from INFO. Those are the whole redis
+// instance's, not just fcpc's keys.
+func (r *Redis) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	var err error
+	if stats.Records, err = r.client.ZCard(ctx, redisIndexKey).Result(); err != nil {
+		return Stats{}, err
+	}
+	if stats.APIKeys, err = r.client.HLen(ctx, redisAPIKeysKey).Result(); err != nil {
+		return Stats{}, err
+	}
+	oldest, err := r.client.ZRangeWithScores(ctx, redisIndexKey, 0, 0).Result()
+	if err != nil {
+		return Stats{}, err
+	}
+	newest, err := r.client.ZRevRangeWithScores(ctx, redisIndexKey, 0, 0).Result()
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(oldest) > 0 && len(newest) > 0 {
+		o, n := time.UnixMicro(int64(oldest[0].Score)).UTC(), time.UnixMicro(int64(newest[0].Score)).UTC()
+		stats.Oldest, stats.Newest = &o, &n
+	}
+
+	info, err := r.client.Info(ctx, "memory", "stats").Result()
+	if err != nil {
+		return Stats{}, err
+	}
+	fields := parseRedisInfo(info)
+	stats.SizeBytes = fields["used_memory"]
+	if maxBytes, ok := fields["maxmemory"]; ok && maxBytes > 0 {
+		stats.MaxBytes = &maxBytes
+	}
+	if evictions, ok := fields["evicted_keys"]; ok {
+		stats.Evictions = &evictions
+	}
+	return stats, nil
+}
+
+// parseRedisInfo picks the integer fields out of an INFO reply.
+func parseRedisInfo(info string) map[string]int64 {
+	fields := map[string]int64{}
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			fields[name] = n
+		}
+	}
+	return fields
+}
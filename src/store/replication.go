@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds of Change.
+const (
+	ChangePut    = "put"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+	ChangePutKey = "putKey"
+	ChangeCredit = "credit"
+)
+
+// Change is a write that succeeded on the active region, for a standby to apply to its own store. Seq numbers the
+// changes of one Replicating store, starting over when the process restarts. Deletes only carry the record's ID,
+// credits the ID and the account.
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Kind    string    `json:"kind"`
+	Record  *Record   `json:"record,omitempty"`
+	Key     *APIKey   `json:"key,omitempty"`
+	ID      string    `json:"id,omitempty"`
+	Account string    `json:"account,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Replicating passes every write that succeeded on to publish, in the order they succeeded per record. publish must
+// not block for long: it is called while the write returns. Compaction isn't a write, Unwrap to get at it.
+type Replicating struct {
+	store   Store
+	keys    KeyStore
+	publish func(Change)
+	seq     atomic.Uint64
+}
+
+// NewReplicating wraps s. Every backend keeps API keys, so they are replicated too.
+func NewReplicating(s Store, publish func(Change)) *Replicating {
+	keys, _ := s.(KeyStore)
+	return &Replicating{store: s, keys: keys, publish: publish}
+}
+
+// Unwrap returns the wrapped store.
+func (r *Replicating) Unwrap() Store {
+	return r.store
+}
+
+func (r *Replicating) changed(c Change) {
+	c.Seq = r.seq.Add(1)
+	c.At = time.Now().UTC()
+	r.publish(c)
+}
+
+func (r *Replicating) Put(ctx context.Context, rec Record) error {
+	if err := r.store.Put(ctx, rec); err != nil {
+		return err
+	}
+	r.changed(Change{Kind: ChangePut, Record: &rec})
+	return nil
+}
+
+func (r *Replicating) Get(ctx context.Context, id string) (Record, error) {
+	return r.store.Get(ctx, id)
+}
+
+func (r *Replicating) Update(ctx context.Context, rec Record) error {
+	if err := r.store.Update(ctx, rec); err != nil {
+		return err
+	}
+	r.changed(Change{Kind: ChangeUpdate, Record: &rec})
+	return nil
+}
+
+func (r *Replicating) Delete(ctx context.Context, id string) error {
+	if err := r.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.changed(Change{Kind: ChangeDelete, ID: id})
+	return nil
+}
+
+func (r *Replicating) List(ctx context.Context, opts ListOptions) ([]Record, error) {
+	return r.store.List(ctx, opts)
+}
+
+func (r *Replicating) Scan(ctx context.Context, fn func(Record) error) error {
+	return r.store.Scan(ctx, fn)
+}
+
+func (r *Replicating) Close() error {
+	return r.store.Close()
+}
+
+func (r *Replicating) PutKey(ctx context.Context, key APIKey) error {
+	if err := r.keys.PutKey(ctx, key); err != nil {
+		return err
+	}
+	r.changed(Change{Kind: ChangePutKey, Key: &key})
+	return nil
+}
+
+// CreditReceipt credits a receipt to an account in the daily aggregates of the wrapped store, if it keeps them, and
+// passes the credit on either way, for standbys that keep them.
+func (r *Replicating) CreditReceipt(ctx context.Context, id, account string) error {
+	if daily, ok := Unwrap(r.store).(DailyAggregator); ok {
+		if err := daily.CreditReceipt(ctx, id, account); err != nil {
+			return err
+		}
+	}
+	r.changed(Change{Kind: ChangeCredit, ID: id, Account: account})
+	return nil
+}
+
+func (r *Replicating) GetKey(ctx context.Context, id string) (APIKey, error) {
+	return r.keys.GetKey(ctx, id)
+}
+
+func (r *Replicating) ListKeys(ctx context.Context) ([]APIKey, error) {
+	return r.keys.ListKeys(ctx)
+}
+
+// Unwrap returns the store under any wrappers like Replicating, for the optional interfaces like Compacter.
+func Unwrap(s Store) Store {
+	for {
+		wrapper, ok := s.(interface{ Unwrap() Store })
+		if !ok {
+			return s
+		}
+		s = wrapper.Unwrap()
+	}
+}
+
+// Apply makes a change to s. It is idempotent, so changes delivered more than once are fine: a put of a record s has
+// already is an update, an update of one it doesn't have a put and a delete of one it doesn't have nothing.
+func Apply(ctx context.Context, s Store, c Change) error {
+	switch c.Kind {
+	case ChangePut, ChangeUpdate:
+		if c.Record == nil {
+			return fmt.Errorf("%s change %d without a record", c.Kind, c.Seq)
+		}
+		err := s.Put(ctx, *c.Record)
+		if errors.Is(err, ErrExists) {
+			err = s.Update(ctx, *c.Record)
+		}
+		return err
+	case ChangeDelete:
+		if err := s.Delete(ctx, c.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	case ChangePutKey:
+		keys, ok := s.(KeyStore)
+		if !ok || c.Key == nil {
+			return fmt.Errorf("can't apply key change %d", c.Seq)
+		}
+		return keys.PutKey(ctx, *c.Key)
+	case ChangeCredit:
+		daily, ok := Unwrap(s).(DailyAggregator)
+		if !ok {
+			return nil
+		}
+		if err := daily.CreditReceipt(ctx, c.ID, c.Account); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown change kind %q", c.Kind)
+	}
+}
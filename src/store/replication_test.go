@@ -0,0 +1,74 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestReplicatingConformance(t *testing.T) {
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		return store.NewReplicating(store.NewMemory(), func(store.Change) {})
+	})
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	var changes []store.Change
+	primary := store.NewReplicating(store.NewMemory(), func(c store.Change) {
+		changes = append(changes, c)
+	})
+	standby := store.NewMemory()
+
+	rec := func(id string, points int64) store.Record {
+		return store.Record{ID: id, Points: points, Receipt: json.RawMessage(`{}`), CreatedAt: time.Unix(0, 0).UTC()}
+	}
+	primary.Put(ctx, rec("a", 10))
+	primary.Put(ctx, rec("b", 20))
+	primary.Update(ctx, rec("a", 15))
+	primary.CreditReceipt(ctx, "a", "alice")
+	primary.Delete(ctx, "b")
+	primary.PutKey(ctx, store.APIKey{ID: "partner", Scopes: []string{"receipts:read"}})
+	// failed writes aren't replicated.
+	if err := primary.Put(ctx, rec("a", 99)); !errors.Is(err, store.ErrExists) {
+		t.Fatalf("Put() error = %v, want ErrExists", err)
+	}
+
+	if len(changes) != 6 {
+		t.Fatalf("got %d changes, want 6: %+v", len(changes), changes)
+	}
+	for i, c := range changes {
+		if c.Seq != uint64(i+1) {
+			t.Errorf("changes[%d].Seq = %d, want %d", i, c.Seq, i+1)
+		}
+	}
+
+	// applying everything twice, as a redelivery would, ends up the same as applying it once.
+	for range 2 {
+		for _, c := range changes {
+			if err := store.Apply(ctx, standby, c); err != nil {
+				t.Fatalf("Apply(%+v) error = %v", c, err)
+			}
+		}
+	}
+	if got, err := standby.Get(ctx, "a"); err != nil || got.Points != 15 {
+		t.Errorf("standby a = %+v, %v, want 15 points", got, err)
+	}
+	if _, err := standby.Get(ctx, "b"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("standby b error = %v, want ErrNotFound", err)
+	}
+	if _, err := standby.GetKey(ctx, "partner"); err != nil {
+		t.Errorf("standby key error = %v", err)
+	}
+	if totals, err := standby.Daily(ctx, store.DailyAccount, "", ""); err != nil || len(totals) != 1 || totals[0].Group != "alice" {
+		t.Errorf("standby account totals = %+v, %v, want a's credit to alice", totals, err)
+	}
+	if store.Unwrap(primary) == store.Store(primary) {
+		t.Errorf("Unwrap() returned the wrapper")
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SortField is what List orders records by. Ties are broken by ID, so every record has one place in the order.
+type SortField string
+
+const (
+	// SortProcessed orders by CreatedAt, when the receipt was processed. It is the default.
+	SortProcessed SortField = "processedAt"
+	SortPoints    SortField = "points"
+	// SortTotal orders by the receipt's total.
+	SortTotal SortField = "total"
+	// SortPurchased orders by the receipt's purchase date and time.
+	SortPurchased SortField = "purchaseDate"
+)
+
+// SortFields are the fields List can order by.
+var SortFields = []SortField{SortProcessed, SortPoints, SortTotal, SortPurchased}
+
+// ParseSortField returns the SortField named s, SortProcessed for "".
+func ParseSortField(s string) (SortField, error) {
+	if s == "" {
+		return SortProcessed, nil
+	}
+	if !slices.Contains(SortFields, SortField(s)) {
+		return "", fmt.Errorf("unknown sort field %q", s)
+	}
+	return SortField(s), nil
+}
+
+// sortedReceipt is the part of a receipt the sort fields read. Backends otherwise don't look into receipts.
+type sortedReceipt struct {
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Total        string `json:"total"`
+}
+
+// Value is where rec falls in the order of f: microseconds since the epoch for SortProcessed, seconds for
+// SortPurchased, the total in dollars for SortTotal. Receipts missing the field sort as 0.
+func (f SortField) Value(rec Record) float64 {
+	switch f {
+	case SortPoints:
+		return float64(rec.Points)
+	case SortTotal, SortPurchased:
+		var r sortedReceipt
+		json.Unmarshal(rec.Receipt, &r)
+		if f == SortTotal {
+			total, _ := strconv.ParseFloat(r.Total, 64)
+			return total
+		}
+		purchased, err := time.Parse("2006-01-02 15:04", r.PurchaseDate+" "+r.PurchaseTime)
+		if err != nil {
+			return 0
+		}
+		return float64(purchased.Unix())
+	default:
+		return float64(rec.CreatedAt.UnixMicro())
+	}
+}
+
+// Cursor is where a page of List ended: the sort value and ID of its last record. The next page starts right after
+// that place in the order, so records put in the meantime don't shift or repeat the records on it, and it works even
+// if the record itself was deleted since.
+type Cursor struct {
+	Value float64
+	ID    string
+}
+
+// CursorAfter is the cursor for a page ending with rec.
+func (o ListOptions) CursorAfter(rec Record) Cursor {
+	return Cursor{Value: o.sort().Value(rec), ID: rec.ID}
+}
+
+// String encodes c for clients, who hand it back as is.
+func (c Cursor) String() string {
+	return strconv.FormatFloat(c.Value, 'f', -1, 64) + ":" + c.ID
+}
+
+// ParseCursor decodes a Cursor.String.
+func ParseCursor(s string) (Cursor, error) {
+	value, id, ok := strings.Cut(s, ":")
+	v, err := strconv.ParseFloat(value, 64)
+	if !ok || err != nil || id == "" {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	return Cursor{Value: v, ID: id}, nil
+}
+
+func (o ListOptions) sort() SortField {
+	if o.Sort == "" {
+		return SortProcessed
+	}
+	return o.Sort
+}
+
+// compare orders a before b (-1) or after it (1) in the order o asks for.
+func (o ListOptions) compare(a, b Cursor) int {
+	var c int
+	switch {
+	case a.Value < b.Value:
+		c = -1
+	case a.Value > b.Value:
+		c = 1
+	default:
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if !o.Ascending {
+		c = -c
+	}
+	return c
+}
+
+// Page sorts records and cuts out the page o asks for, for backends that hold every record at hand.
+func Page(records []Record, o ListOptions) []Record {
+	keyed := make([]Cursor, 0, len(records))
+	byID := make(map[string]Record, len(records))
+	for _, rec := range records {
+		key := o.CursorAfter(rec)
+		if o.After != nil && o.compare(key, *o.After) <= 0 {
+			continue
+		}
+		keyed = append(keyed, key)
+		byID[rec.ID] = rec
+	}
+	slices.SortFunc(keyed, o.compare)
+	if len(keyed) > o.limit() {
+		keyed = keyed[:o.limit()]
+	}
+
+	page := make([]Record, len(keyed))
+	for i, key := range keyed {
+		page[i] = byID[key.ID]
+	}
+	return page
+}
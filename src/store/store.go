@@ -0,0 +1,138 @@
+// Package store holds the receipt storage interface and its backends.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("receipt not found")
+	ErrExists   = errors.New("receipt already exists")
+)
+
+// Record is what gets persisted per receipt. The receipt itself is kept as the validated JSON payload so backends
+// don't need to know about the receipt schema.
+type Record struct {
+	ID        string          `json:"id"`
+	Points    int64           `json:"points"`
+	Receipt   json.RawMessage `json:"receipt"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Store is implemented by every backend. Implementations must be safe for concurrent use.
+type Store interface {
+	// Put stores a new record, returning ErrExists if the ID is taken.
+	Put(ctx context.Context, rec Record) error
+	// Get returns ErrNotFound for unknown IDs.
+	Get(ctx context.Context, id string) (Record, error)
+	// Update replaces the points and receipt of a stored record, keeping when it was created, and returns
+	// ErrNotFound for unknown IDs.
+	Update(ctx context.Context, rec Record) error
+	// Delete removes a record for good, returning ErrNotFound for unknown IDs.
+	Delete(ctx context.Context, id string) error
+	// List returns a page of records in the order opts asks for, newest first by default.
+	List(ctx context.Context, opts ListOptions) ([]Record, error)
+	// Scan calls fn for every record, in no particular order, and stops at the first error fn returns. Records put
+	// during a scan may or may not be visited.
+	Scan(ctx context.Context, fn func(Record) error) error
+	Close() error
+}
+
+// CompactResult reports a compaction, with sizes in bytes as the backend measures itself.
+type CompactResult struct {
+	SizeBefore int64 `json:"sizeBefore"`
+	SizeAfter  int64 `json:"sizeAfter"`
+	Reclaimed  int64 `json:"reclaimed"`
+}
+
+// Compacter is implemented by backends whose storage can hold more than the live records, e.g. dead rows, and can
+// give the space back.
+type Compacter interface {
+	Compact(ctx context.Context) (CompactResult, error)
+}
+
+// DefaultListLimit applies when ListOptions.Limit is not positive.
+const DefaultListLimit = 50
+
+// ListOptions narrows down List.
+type ListOptions struct {
+	Limit int
+	// Sort is SortProcessed when empty. Records are in descending order unless Ascending is set.
+	Sort      SortField
+	Ascending bool
+	// After starts the page after the place of a record in the order, see CursorAfter.
+	After *Cursor
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	return o.Limit
+}
+
+// Open returns the backend with the given name. dsn is ignored for the memory backend.
+func Open(ctx context.Context, backend, dsn string) (Store, error) {
+	switch backend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "postgres":
+		return NewPostgres(ctx, dsn)
+	case "redis":
+		return NewRedis(ctx, dsn)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// Stats is what a backend holds, for capacity monitoring. SizeBytes is as the backend measures itself, or an estimate
+// of the records' size in memory. Evictions counts records the backend dropped on its own, e.g. redis under its
+// maxmemory policy, MaxBytes is where that starts; both are nil for backends that never evict.
+type Stats struct {
+	Records   int64      `json:"records"`
+	APIKeys   int64      `json:"apiKeys"`
+	SizeBytes int64      `json:"sizeBytes"`
+	MaxBytes  *int64     `json:"maxBytes,omitempty"`
+	Evictions *int64     `json:"evictions,omitempty"`
+	Oldest    *time.Time `json:"oldest,omitempty"`
+	Newest    *time.Time `json:"newest,omitempty"`
+}
+
+// StatsReporter is implemented by backends that know their Stats without a full scan.
+type StatsReporter interface {
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// recordOverhead approximates what a record takes in memory on top of its ID and receipt.
+const recordOverhead = 128
+
+// ScanStats works out the Stats of s from a scan, for backends that can't report them cheaply.
+func ScanStats(ctx context.Context, s Store) (Stats, error) {
+	var stats Stats
+	err := s.Scan(ctx, func(rec Record) error {
+		stats.Records++
+		stats.SizeBytes += int64(len(rec.ID)+len(rec.Receipt)) + recordOverhead
+		if stats.Oldest == nil || rec.CreatedAt.Before(*stats.Oldest) {
+			stats.Oldest = &rec.CreatedAt
+		}
+		if stats.Newest == nil || rec.CreatedAt.After(*stats.Newest) {
+			stats.Newest = &rec.CreatedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	if keys, ok := s.(KeyStore); ok {
+		list, err := keys.ListKeys(ctx)
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.APIKeys = int64(len(list))
+	}
+	return stats, nil
+}
@@ -0,0 +1,531 @@
+package storetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// Conformance runs the behaviour every store.Store must have. newStore is called once per subtest; backends sharing
+// state between calls (e.g. one database) are fine since every subtest uses fresh IDs.
+func Conformance(t *testing.T, newStore func(t *testing.T) store.Store) {
+	ctx := context.Background()
+
+	t.Run("put then get", func(t *testing.T) {
+		s := newStore(t)
+		want := newRecord()
+		if err := s.Put(ctx, want); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+
+		got, err := s.Get(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		assertRecordEqual(t, got, want)
+	})
+
+	t.Run("get unknown", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.Get(ctx, newID()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, store.ErrNotFound)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := newStore(t)
+		rec := newRecord()
+		if err := s.Put(ctx, rec); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if err := s.Delete(ctx, rec.ID); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := s.Get(ctx, rec.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get() after Delete() error = %v, want %v", err, store.ErrNotFound)
+		}
+		if err := s.Delete(ctx, rec.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Delete() again error = %v, want %v", err, store.ErrNotFound)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		s := newStore(t)
+		rec := newRecord()
+		if err := s.Put(ctx, rec); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+
+		want := rec
+		want.Points = rec.Points + 1
+		want.Receipt = json.RawMessage(`{"retailer":"Walgreens"}`)
+		changed := want
+		changed.CreatedAt = rec.CreatedAt.Add(time.Hour)
+		if err := s.Update(ctx, changed); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		got, err := s.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		assertRecordEqual(t, got, want)
+
+		if err := s.Update(ctx, newRecord()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Update() unknown error = %v, want %v", err, store.ErrNotFound)
+		}
+	})
+
+	t.Run("put duplicate keeps original", func(t *testing.T) {
+		s := newStore(t)
+		want := newRecord()
+		if err := s.Put(ctx, want); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+
+		dup := want
+		dup.Points = want.Points + 1
+		if err := s.Put(ctx, dup); !errors.Is(err, store.ErrExists) {
+			t.Errorf("Put() duplicate error = %v, want %v", err, store.ErrExists)
+		}
+
+		got, err := s.Get(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		assertRecordEqual(t, got, want)
+	})
+
+	t.Run("list newest first", func(t *testing.T) {
+		s := newStore(t)
+		// far in the future so they are the newest even in a store other subtests or runs have written to.
+		base := time.Now().UTC().Truncate(time.Microsecond).AddDate(100, 0, 0)
+		records := make([]store.Record, 3)
+		for i := range records {
+			records[i] = newRecord()
+			records[i].CreatedAt = base.Add(time.Duration(i) * time.Second)
+			if err := s.Put(ctx, records[i]); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+
+		got, err := s.List(ctx, store.ListOptions{Limit: 2})
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("List() returned %d records, want 2", len(got))
+		}
+		assertRecordEqual(t, got[0], records[2])
+		assertRecordEqual(t, got[1], records[1])
+	})
+
+	t.Run("list sorted with cursors", func(t *testing.T) {
+		s := newStore(t)
+		// points, totals and dates above those of other subtests and earlier runs, so ours come first in descending
+		// order. Records 1 and 3 tie on points and total.
+		now := time.Now().UTC()
+		points := now.UnixMicro()
+		purchased := now.AddDate(100, 0, 0)
+		records := make([]store.Record, 4)
+		for i := range records {
+			records[i] = newRecord()
+			records[i].Points = points + int64(i%2)
+			records[i].Receipt = json.RawMessage(fmt.Sprintf(`{"retailer":"Target","purchaseDate":%q,"purchaseTime":"13:01","total":"%d.%02d"}`,
+				purchased.AddDate(0, 0, -i).Format("2006-01-02"), now.UnixMilli(), 10+i%2*50))
+			if err := s.Put(ctx, records[i]); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+		tied := []int{1, 3}
+		if records[1].ID < records[3].ID {
+			tied = []int{3, 1}
+		}
+
+		testCases := []struct {
+			sort store.SortField
+			want []int
+		}{
+			{sort: store.SortPoints, want: tied},
+			{sort: store.SortTotal, want: tied},
+			{sort: store.SortPurchased, want: []int{0, 1}},
+		}
+		for _, tc := range testCases {
+			t.Run(string(tc.sort), func(t *testing.T) {
+				opts := store.ListOptions{Limit: 1, Sort: tc.sort}
+				for i, want := range tc.want {
+					got, err := s.List(ctx, opts)
+					if err != nil {
+						t.Fatalf("List() error = %v", err)
+					}
+					if len(got) != 1 {
+						t.Fatalf("List() page %d returned %d records, want 1", i, len(got))
+					}
+					assertRecordEqual(t, got[0], records[want])
+					cursor := opts.CursorAfter(got[0])
+					opts.After = &cursor
+
+					if i == 0 {
+						// a record put after the first page, in front of it, must not shift the next page.
+						front := newRecord()
+						front.Points = points + 10
+						front.Receipt = json.RawMessage(fmt.Sprintf(`{"purchaseDate":%q,"purchaseTime":"23:59","total":"%d.00"}`,
+							purchased.AddDate(0, 0, 1).Format("2006-01-02"), now.UnixMilli()+1))
+						if err := s.Put(ctx, front); err != nil {
+							t.Fatalf("Put() error = %v", err)
+						}
+						t.Cleanup(func() { s.Delete(ctx, front.ID) })
+					}
+				}
+			})
+		}
+
+		opts := store.ListOptions{Limit: 2, Sort: store.SortPoints, Ascending: true, After: &store.Cursor{Value: float64(points - 1)}}
+		got, err := s.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List() ascending error = %v", err)
+		}
+		if len(got) != 2 || got[0].Points != points || got[1].Points != points || got[0].ID > got[1].ID {
+			t.Errorf("List() ascending = %+v, want records 0 and 2 by ID", got)
+		}
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		s := newStore(t)
+		// retailers of our own, other subtests and runs may have put receipts purchased on the same days.
+		retailers := []string{"a" + newID(), "b" + newID()}
+		receipts := []struct {
+			retailer int
+			date     string
+			total    string
+		}{
+			{0, "2200-01-05", "1.25"}, // a Sunday, in the week of Monday 2199-12-30
+			{0, "2200-01-06", "2.50"},
+			{1, "2200-01-06", "10.00"},
+			{1, "2200-02-01", "0.10"},
+		}
+		for _, r := range receipts {
+			rec := newRecord()
+			rec.Receipt = json.RawMessage(fmt.Sprintf(`{"retailer":%q,"purchaseDate":%q,"purchaseTime":"13:01","total":%q}`, retailers[r.retailer], r.date, r.total))
+			if err := s.Put(ctx, rec); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+
+		q := store.AggregateQuery{GroupBy: store.GroupByRetailer, Metric: store.MetricTotal, Period: store.PeriodWeek, From: "2200-01-01", To: "2200-01-31"}
+		want := []store.AggregateBucket{
+			{Group: retailers[0], Period: "2199-12-30", Value: 125},
+			{Group: retailers[0], Period: "2200-01-06", Value: 250},
+			{Group: retailers[1], Period: "2200-01-06", Value: 1000},
+		}
+		aggregates := map[string]func() ([]store.AggregateBucket, error){
+			"scan": func() ([]store.AggregateBucket, error) { return store.ScanAggregate(ctx, s, q) },
+		}
+		if aggregator, ok := store.Unwrap(s).(store.Aggregator); ok {
+			aggregates["backend"] = func() ([]store.AggregateBucket, error) { return aggregator.Aggregate(ctx, q) }
+		}
+		for name, aggregate := range aggregates {
+			buckets, err := aggregate()
+			if err != nil {
+				t.Fatalf("%s Aggregate() error = %v", name, err)
+			}
+			var got []store.AggregateBucket
+			for _, b := range buckets {
+				if b.Group == retailers[0] || b.Group == retailers[1] {
+					got = append(got, b)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s Aggregate() = %+v, want %+v", name, got, want)
+			}
+		}
+	})
+
+	t.Run("daily aggregates", func(t *testing.T) {
+		s := newStore(t)
+		daily, ok := store.Unwrap(s).(store.DailyAggregator)
+		if !ok {
+			t.Skip("the backend doesn't keep daily aggregates")
+		}
+		// a retailer and account of our own, other subtests and runs may have put receipts purchased on the same days.
+		retailer, account := "a"+newID(), "a"+newID()
+		receipt := func(date, total string, canary bool) json.RawMessage {
+			return json.RawMessage(fmt.Sprintf(`{"retailer":%q,"purchaseDate":%q,"purchaseTime":"13:01","total":%q,"canary":%t}`, retailer, date, total, canary))
+		}
+		records := make([]store.Record, 4)
+		for i, r := range []struct {
+			date, total string
+			canary      bool
+		}{{"2200-03-01", "1.25", false}, {"2200-03-02", "2.50", false}, {"2200-03-01", "9.99", true}, {"2200-03-02", "4.00", false}} {
+			records[i] = newRecord()
+			records[i].Receipt = receipt(r.date, r.total, r.canary)
+			if err := s.Put(ctx, records[i]); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+		if err := daily.CreditReceipt(ctx, records[0].ID, account); err != nil {
+			t.Fatalf("CreditReceipt() error = %v", err)
+		}
+		if err := daily.CreditReceipt(ctx, newID(), account); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("CreditReceipt() unknown error = %v, want %v", err, store.ErrNotFound)
+		}
+		updated := records[1]
+		updated.Points, updated.Receipt = 7, receipt("2200-03-02", "3.00", false)
+		if err := s.Update(ctx, updated); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := s.Delete(ctx, records[3].ID); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+
+		ours := func(dim store.DailyDimension, group string) []store.DailyTotals {
+			t.Helper()
+			totals, err := daily.Daily(ctx, dim, "2200-03-01", "2200-03-31")
+			if err != nil {
+				t.Fatalf("Daily(%s) error = %v", dim, err)
+			}
+			var got []store.DailyTotals
+			for _, total := range totals {
+				if total.Group == group {
+					got = append(got, total)
+				}
+			}
+			return got
+		}
+		wantRetailer := []store.DailyTotals{
+			{Date: "2200-03-01", Group: retailer, Receipts: 1, Points: 28, Cents: 125},
+			{Date: "2200-03-02", Group: retailer, Receipts: 1, Points: 7, Cents: 300},
+		}
+		wantAccount := []store.DailyTotals{{Date: "2200-03-01", Group: account, Receipts: 1, Points: 28, Cents: 125}}
+		check := func(when string) {
+			t.Helper()
+			if got := ours(store.DailyRetailer, retailer); !reflect.DeepEqual(got, wantRetailer) {
+				t.Errorf("Daily(retailer) %s = %+v, want %+v", when, got, wantRetailer)
+			}
+			if got := ours(store.DailyAccount, account); !reflect.DeepEqual(got, wantAccount) {
+				t.Errorf("Daily(account) %s = %+v, want %+v", when, got, wantAccount)
+			}
+		}
+		check("as written")
+
+		// kept in the same transaction as the writes, so there is nothing to repair.
+		run, err := daily.RebuildDaily(ctx)
+		if err != nil {
+			t.Fatalf("RebuildDaily() error = %v", err)
+		}
+		if run.Drifted != 0 || run.Receipts < 3 {
+			t.Errorf("RebuildDaily() = %+v, want at least 3 receipts and nothing drifted", run)
+		}
+		check("after a rebuild")
+	})
+
+	t.Run("scan visits every record", func(t *testing.T) {
+		s := newStore(t)
+		want := map[string]store.Record{}
+		for range 3 {
+			rec := newRecord()
+			if err := s.Put(ctx, rec); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			want[rec.ID] = rec
+		}
+
+		// the store may hold records from other subtests, only ours have to be there.
+		err := s.Scan(ctx, func(got store.Record) error {
+			if rec, ok := want[got.ID]; ok {
+				assertRecordEqual(t, got, rec)
+				delete(want, got.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		if len(want) != 0 {
+			t.Errorf("Scan() missed %d records", len(want))
+		}
+	})
+
+	t.Run("scan stops at error", func(t *testing.T) {
+		s := newStore(t)
+		for range 2 {
+			if err := s.Put(ctx, newRecord()); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err := s.Scan(ctx, func(store.Record) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("Scan() error = %v after %d calls, want %v after 1", err, calls, stop)
+		}
+	})
+
+	t.Run("concurrent puts", func(t *testing.T) {
+		s := newStore(t)
+		records := make([]store.Record, 20)
+		var wg sync.WaitGroup
+		for i := range records {
+			records[i] = newRecord()
+			wg.Add(1)
+			go func(rec store.Record) {
+				defer wg.Done()
+				if err := s.Put(ctx, rec); err != nil {
+					t.Errorf("Put() error = %v", err)
+				}
+			}(records[i])
+		}
+		wg.Wait()
+
+		for _, want := range records {
+			got, err := s.Get(ctx, want.ID)
+			if err != nil {
+				t.Errorf("Get(%v) error = %v", want.ID, err)
+				continue
+			}
+			assertRecordEqual(t, got, want)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		s, ok := newStore(t).(store.KeyStore)
+		if !ok {
+			t.Skip("the backend doesn't keep API keys")
+		}
+		if _, err := s.GetKey(ctx, newID()); !errors.Is(err, store.ErrKeyNotFound) {
+			t.Errorf("GetKey() error = %v, want %v", err, store.ErrKeyNotFound)
+		}
+
+		expires := time.Now().UTC().Add(time.Hour).Truncate(time.Microsecond)
+		want := store.APIKey{ID: newID(), SecretHash: "hash", Scopes: []string{"receipts:write"}, CreatedAt: time.Now().UTC().Truncate(time.Microsecond), ExpiresAt: &expires}
+		if err := s.PutKey(ctx, want); err != nil {
+			t.Fatalf("PutKey() error = %v", err)
+		}
+		// PutKey replaces, that is how keys are rotated and revoked.
+		want.SecretHash = "rotated"
+		if err := s.PutKey(ctx, want); err != nil {
+			t.Fatalf("PutKey() error = %v", err)
+		}
+
+		got, err := s.GetKey(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("GetKey() error = %v", err)
+		}
+		if got.SecretHash != want.SecretHash || !reflect.DeepEqual(got.Scopes, want.Scopes) || !got.ExpiresAt.Equal(*want.ExpiresAt) {
+			t.Errorf("key = %+v, want %+v", got, want)
+		}
+
+		keys, err := s.ListKeys(ctx)
+		if err != nil {
+			t.Fatalf("ListKeys() error = %v", err)
+		}
+		found := 0
+		for _, key := range keys {
+			if key.ID == want.ID {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("ListKeys() has the key %d times, want once", found)
+		}
+	})
+
+	t.Run("nonces", func(t *testing.T) {
+		s, ok := newStore(t).(store.NonceStore)
+		if !ok {
+			t.Skip("the backend doesn't claim nonces")
+		}
+		nonce := newID()
+		expires := time.Now().Add(time.Hour)
+
+		var wg sync.WaitGroup
+		var claimed atomic.Int32
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := s.ClaimNonce(ctx, nonce, expires)
+				if err != nil {
+					t.Errorf("ClaimNonce() error = %v", err)
+				}
+				if ok {
+					claimed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if claimed.Load() != 1 {
+			t.Fatalf("%d concurrent claims of a nonce won, want 1", claimed.Load())
+		}
+
+		if err := s.ReleaseNonce(ctx, nonce); err != nil {
+			t.Fatalf("ReleaseNonce() error = %v", err)
+		}
+		if ok, err := s.ClaimNonce(ctx, nonce, expires); err != nil || !ok {
+			t.Errorf("ClaimNonce() of a released nonce = %v, %v, want it claimed", ok, err)
+		}
+
+		// an expired claim is free again.
+		short := newID()
+		if ok, err := s.ClaimNonce(ctx, short, time.Now().Add(50*time.Millisecond)); err != nil || !ok {
+			t.Fatalf("ClaimNonce() = %v, %v, want it claimed", ok, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if ok, err := s.ClaimNonce(ctx, short, expires); err != nil || !ok {
+			t.Errorf("ClaimNonce() after the claim expired = %v, %v, want it claimed", ok, err)
+		}
+	})
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newRecord() store.Record {
+	return store.Record{
+		ID:     newID(),
+		Points: 28,
+		Receipt: json.RawMessage(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01",` +
+			`"items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`),
+		// databases commonly keep microseconds, so don't ask for more.
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+func assertRecordEqual(t *testing.T, got, want store.Record) {
+	t.Helper()
+	if got.ID != want.ID || got.Points != want.Points || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("record = %+v, want %+v", got, want)
+	}
+
+	// backends may reformat the JSON (e.g. postgres jsonb), only its meaning has to survive.
+	if !sameJSON(got.Receipt, want.Receipt) {
+		t.Errorf("receipt = %s, want %s", got.Receipt, want.Receipt)
+	}
+}
+
+func sameJSON(a, b []byte) bool {
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,197 @@
+// Package storetest has test doubles for store.Store and the conformance suite every backend must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// Fake is a map-backed store that also counts calls, for tests that want to assert on how the store was used.
+type Fake struct {
+	mu      sync.Mutex
+	records map[string]store.Record
+	Calls   map[string]int
+}
+
+func NewFake(records ...store.Record) *Fake {
+	f := &Fake{records: map[string]store.Record{}, Calls: map[string]int{}}
+	for _, rec := range records {
+		f.records[rec.ID] = rec
+	}
+	return f
+}
+
+func (f *Fake) Put(ctx context.Context, rec store.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Put"]++
+	if _, ok := f.records[rec.ID]; ok {
+		return store.ErrExists
+	}
+	f.records[rec.ID] = rec
+	return nil
+}
+
+func (f *Fake) Get(ctx context.Context, id string) (store.Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Get"]++
+	rec, ok := f.records[id]
+	if !ok {
+		return store.Record{}, store.ErrNotFound
+	}
+	return rec, nil
+}
+
+func (f *Fake) Update(ctx context.Context, rec store.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Update"]++
+	old, ok := f.records[rec.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	rec.CreatedAt = old.CreatedAt
+	f.records[rec.ID] = rec
+	return nil
+}
+
+func (f *Fake) Delete(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Delete"]++
+	if _, ok := f.records[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(f.records, id)
+	return nil
+}
+
+func (f *Fake) List(ctx context.Context, opts store.ListOptions) ([]store.Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["List"]++
+	records := make([]store.Record, 0, len(f.records))
+	for _, rec := range f.records {
+		records = append(records, rec)
+	}
+	return store.Page(records, opts), nil
+}
+
+func (f *Fake) Scan(ctx context.Context, fn func(store.Record) error) error {
+	f.mu.Lock()
+	f.Calls["Scan"]++
+	records := make([]store.Record, 0, len(f.records))
+	for _, rec := range f.records {
+		records = append(records, rec)
+	}
+	// fn may call back into the store.
+	f.mu.Unlock()
+
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Close() error {
+	return nil
+}
+
+// Len returns the number of stored records.
+func (f *Fake) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.records)
+}
+
+// ErrInjected is returned by Faulty for calls it decided to fail.
+var ErrInjected = errors.New("storetest: injected failure")
+
+// Faulty wraps a store and makes calls slow or fail, to test how callers cope with a misbehaving backend.
+type Faulty struct {
+	store.Store
+	// Latency is added to every call, ErrorRate is the fraction (0-1) of calls that fail with Err.
+	Latency   time.Duration
+	ErrorRate float64
+	// Err defaults to ErrInjected.
+	Err error
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaulty wraps s. The seed makes the sequence of injected failures reproducible.
+func NewFaulty(s store.Store, latency time.Duration, errorRate float64, seed uint64) *Faulty {
+	return &Faulty{Store: s, Latency: latency, ErrorRate: errorRate, rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (f *Faulty) inject(ctx context.Context) error {
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	fail := f.rand.Float64() < f.ErrorRate
+	f.mu.Unlock()
+	if !fail {
+		return nil
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrInjected
+}
+
+func (f *Faulty) Put(ctx context.Context, rec store.Record) error {
+	if err := f.inject(ctx); err != nil {
+		return err
+	}
+	return f.Store.Put(ctx, rec)
+}
+
+func (f *Faulty) Get(ctx context.Context, id string) (store.Record, error) {
+	if err := f.inject(ctx); err != nil {
+		return store.Record{}, err
+	}
+	return f.Store.Get(ctx, id)
+}
+
+func (f *Faulty) Update(ctx context.Context, rec store.Record) error {
+	if err := f.inject(ctx); err != nil {
+		return err
+	}
+	return f.Store.Update(ctx, rec)
+}
+
+func (f *Faulty) Delete(ctx context.Context, id string) error {
+	if err := f.inject(ctx); err != nil {
+		return err
+	}
+	return f.Store.Delete(ctx, id)
+}
+
+func (f *Faulty) List(ctx context.Context, opts store.ListOptions) ([]store.Record, error) {
+	if err := f.inject(ctx); err != nil {
+		return nil, err
+	}
+	return f.Store.List(ctx, opts)
+}
+
+func (f *Faulty) Scan(ctx context.Context, fn func(store.Record) error) error {
+	if err := f.inject(ctx); err != nil {
+		return err
+	}
+	return f.Store.Scan(ctx, fn)
+}
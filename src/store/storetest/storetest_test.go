@@ -0,0 +1,47 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+func TestFakeConformance(t *testing.T) {
+	Conformance(t, func(t *testing.T) store.Store {
+		return NewFake()
+	})
+}
+
+func TestFaultyConformance(t *testing.T) {
+	// with no faults configured the wrapper must be transparent.
+	Conformance(t, func(t *testing.T) store.Store {
+		return NewFaulty(NewFake(), 0, 0, 1)
+	})
+}
+
+func TestFaultyInjectsErrors(t *testing.T) {
+	testCases := []struct {
+		name      string
+		errorRate float64
+		wantErr   error
+	}{
+		{name: "never", errorRate: 0, wantErr: store.ErrNotFound},
+		{name: "always", errorRate: 1, wantErr: ErrInjected},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := NewFake()
+			s := NewFaulty(fake, 0, tc.errorRate, 1)
+
+			if _, err := s.Get(context.Background(), "missing"); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Get() error = %v, want %v", err, tc.wantErr)
+			}
+			if got := fake.Calls["Get"] > 0; got != (tc.wantErr != ErrInjected) {
+				t.Errorf("wrapped store called = %v, injected failures must not reach it", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"go.uber.org/zap"
+)
+
+// receiptsExpired counts the receipts retention policies deleted since startup.
+var receiptsExpired atomic.Int64
+
+// storeStatsResponse is GET /admin/store/stats. Estimated is set when the backend can't measure itself and SizeBytes
+// is worked out from the records.
+type storeStatsResponse struct {
+	Backend string `json:"backend"`
+	store.Stats
+	Estimated        bool            `json:"estimated"`
+	RetentionDeleted int64           `json:"retentionDeleted"`
+	Fallback         *fallbackStatus `json:"fallback,omitempty"`
+}
+
+// storeStats serves GET /admin/store/stats. Backends without cheap stats are scanned, which takes as long as an export.
+func storeStats(w http.ResponseWriter, r *http.Request) {
+	response := storeStatsResponse{Backend: cfg.Store.Backend, RetentionDeleted: receiptsExpired.Load()}
+	if storeFallback != nil {
+		response.Fallback = storeFallback.status()
+	}
+	if response.Backend == "" {
+		response.Backend = "memory"
+	}
+	var err error
+	if reporter, ok := store.Unwrap(receiptStore).(store.StatsReporter); ok {
+		response.Stats, err = reporter.Stats(r.Context())
+		_, response.Estimated = reporter.(*store.Memory)
+	} else {
+		response.Stats, err = store.ScanStats(r.Context(), receiptStore)
+		response.Estimated = true
+	}
+	if err != nil {
+		logger.Error("Failed to get store stats", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
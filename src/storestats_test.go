@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/MDanialSaleem/fcpc/store/storetest"
+)
+
+func TestStoreStats(t *testing.T) {
+	router := setup()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	records := []store.Record{
+		{ID: "b", Receipt: json.RawMessage(`{"retailer":"Target"}`), CreatedAt: day.Add(time.Hour)},
+		{ID: "a", Receipt: json.RawMessage(`{}`), CreatedAt: day},
+		{ID: "c", Receipt: json.RawMessage(`{}`), CreatedAt: day.Add(2 * time.Hour)},
+	}
+
+	testCases := []struct {
+		name  string
+		store store.Store
+	}{
+		{name: "memory", store: store.NewMemory()},
+		{name: "scanned", store: storetest.NewFake()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receiptStore = tc.store
+			for _, rec := range records {
+				receiptStore.Put(context.Background(), rec)
+			}
+			receiptsExpired.Store(4)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("GET", "/admin/store/stats", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GET /admin/store/stats = %v %s", rr.Code, rr.Body)
+			}
+			var got storeStatsResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Backend != "memory" || got.Records != 3 || !got.Estimated || got.RetentionDeleted != 4 || got.Evictions != nil {
+				t.Errorf("stats = %+v, want 3 estimated memory records and 4 deleted by retention", got)
+			}
+			if got.SizeBytes <= int64(len(`{"retailer":"Target"}`)) {
+				t.Errorf("sizeBytes = %v, want at least the receipts' size", got.SizeBytes)
+			}
+			if got.Oldest == nil || !got.Oldest.Equal(day) || got.Newest == nil || !got.Newest.Equal(day.Add(2*time.Hour)) {
+				t.Errorf("oldest, newest = %v, %v, want %v, %v", got.Oldest, got.Newest, day, day.Add(2*time.Hour))
+			}
+		})
+	}
+}
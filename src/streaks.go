@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// StreakConfig counts consecutive days (the default) or ISO weeks with at least one receipt per account, going by
+// purchase date. Bonuses are credited when a streak reaches their length.
+type StreakConfig struct {
+	Unit    string               `json:"unit"`
+	Bonuses []ledger.StreakBonus `json:"bonuses"`
+}
+
+func (c StreakConfig) Validate() error {
+	if c.Unit != "" && c.Unit != "day" && c.Unit != "week" {
+		return fmt.Errorf("streaks: unit must be \"day\" or \"week\", got %q", c.Unit)
+	}
+	for _, b := range c.Bonuses {
+		if b.Length < 1 || b.Points < 0 {
+			return fmt.Errorf("streaks: bonus lengths must be positive and points must not be negative")
+		}
+	}
+	return nil
+}
+
+// period returns the day or week t falls in. Weeks start on Monday.
+func (c StreakConfig) period(t time.Time) ledger.Period {
+	days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+	if c.Unit == "week" {
+		year, week := t.ISOWeek()
+		// 1970-01-01 was a Thursday, shifting by 3 days makes every index start on a Monday.
+		return ledger.Period{Unit: "week", Index: floorDiv(days+3, 7), Label: fmt.Sprintf("%d-W%02d", year, week)}
+	}
+	return ledger.Period{Unit: "day", Index: days, Label: t.Format("2006-01-02")}
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// extendStreak is called for every receipt submitted for an account. A failure only costs the bonus, so it is
+// logged rather than failing a receipt that is already stored and credited.
+func extendStreak(account, receiptID string, receipt Receipt) {
+	c := currentConfig().Streaks
+	streak, bonus, err := pointsLedger.ExtendStreak(account, receiptID, c.period(receipt.PurchaseDate), c.Bonuses)
+	if err != nil {
+		logger.Error("Failed to extend streak", zap.String("account", account), zap.String("receiptID", receiptID), zap.Error(err))
+		return
+	}
+	if bonus > 0 {
+		logger.Info("Credited streak bonus", zap.String("account", account), zap.Int("streak", streak.Current), zap.Int64("points", bonus))
+	}
+}
+
+type streakResponse struct {
+	Account string `json:"account"`
+	ledger.Streak
+	// Active is false once a whole period went by without a receipt, the next one starts a new streak.
+	Active bool `json:"active"`
+}
+
+func getStreak(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	streak, err := pointsLedger.Streak(id)
+	if errors.Is(err, ledger.ErrUnknownAccount) {
+		http.Error(w, "No account found for that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load streak", zap.String("account", id), zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	now := currentConfig().Streaks.period(accountNow(id))
+	jsonResponse, err := json.Marshal(streakResponse{Account: id, Streak: streak, Active: streak.Active(now)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
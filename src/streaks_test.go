@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/ledger"
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestStreakPeriod(t *testing.T) {
+	testCases := []struct {
+		unit      string
+		date      string
+		wantLabel string
+		wantNext  string
+	}{
+		{unit: "", date: "2022-01-31", wantLabel: "2022-01-31", wantNext: "2022-02-01"},
+		// 2022-01-02 is a Sunday, the last day of ISO week 2021-W52.
+		{unit: "week", date: "2022-01-02", wantLabel: "2021-W52", wantNext: "2022-01-03"},
+		{unit: "week", date: "1969-12-28", wantLabel: "1969-W52", wantNext: "1969-12-29"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.unit+" "+tc.date, func(t *testing.T) {
+			c := StreakConfig{Unit: tc.unit}
+			date, _ := time.Parse("2006-01-02", tc.date)
+			next, _ := time.Parse("2006-01-02", tc.wantNext)
+
+			got := c.period(date)
+			if got.Label != tc.wantLabel {
+				t.Errorf("period(%v) label = %v, want %v", tc.date, got.Label, tc.wantLabel)
+			}
+			if c.period(next).Index != got.Index+1 {
+				t.Errorf("period(%v) = %v, want the one after %v", tc.wantNext, c.period(next).Index, got.Index)
+			}
+		})
+	}
+}
+
+func TestGetStreak(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Streaks = StreakConfig{Bonuses: []ledger.StreakBonus{{Length: 3, Points: 50}}}
+	liveConfig.Store(&live)
+
+	var earned int64
+	for _, date := range []string{"2022-03-01", "2022-03-02", "2022-03-02", "2022-03-03"} {
+		earned += submitForAccount(t, router, "alice", receipttest.New().PurchaseDate(date).Build())
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/alice/streak", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var got streakResponse
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Current != 3 || got.Longest != 3 || got.LastPeriod != "2022-03-03" || got.Active {
+		t.Errorf("streak = %+v, want an inactive streak of 3 ending 2022-03-03", got)
+	}
+	if _, balance := getBalanceOf(t, router, "alice"); balance != earned+50 {
+		t.Errorf("balance = %v, want %v including the bonus", balance, earned+50)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/accounts/nobody/streak", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// TapConfig records full request/response pairs for a sampled fraction (SampleRate, 0-1) of traffic, so partner
+// integration issues can be debugged without asking them to resend. The last Capacity pairs (100 by default) are kept
+// in memory, bodies are cut at MaxBodyBytes (64 KiB by default). Admin routes and /metrics are never recorded.
+type TapConfig struct {
+	Enabled      bool    `json:"enabled"`
+	SampleRate   float64 `json:"sampleRate"`
+	Capacity     int     `json:"capacity"`
+	MaxBodyBytes int     `json:"maxBodyBytes"`
+}
+
+const (
+	defaultTapCapacity     = 100
+	defaultTapMaxBodyBytes = 64 << 10
+)
+
+func (c TapConfig) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("tap: sampleRate must be between 0 and 1")
+	}
+	if c.Capacity < 0 || c.MaxBodyBytes < 0 {
+		return fmt.Errorf("tap: capacity and maxBodyBytes must not be negative")
+	}
+	return nil
+}
+
+func (c TapConfig) capacity() int {
+	if c.Capacity == 0 {
+		return defaultTapCapacity
+	}
+	return c.Capacity
+}
+
+func (c TapConfig) maxBodyBytes() int {
+	if c.MaxBodyBytes == 0 {
+		return defaultTapMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// TapRecord is one recorded request/response pair.
+type TapRecord struct {
+	ID       string     `json:"id"`
+	At       time.Time  `json:"at"`
+	Duration Duration   `json:"duration"`
+	Request  TapMessage `json:"request"`
+	Response TapMessage `json:"response"`
+}
+
+// TapMessage is either side of a pair. Method and URL are only set for requests, Status only for responses.
+type TapMessage struct {
+	Method        string      `json:"method,omitempty"`
+	URL           string      `json:"url,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+}
+
+// tapRedactedHeaders never end up in the tap, whoever gets to read it.
+var tapRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range tapRedactedHeaders {
+		if _, ok := h[name]; ok {
+			h[name] = []string{"***"}
+		}
+	}
+	return h
+}
+
+// tapBuffer is a ring buffer of the latest records.
+type tapBuffer struct {
+	mu      sync.Mutex
+	records []TapRecord
+}
+
+var tap = &tapBuffer{}
+
+func (b *tapBuffer) add(rec TapRecord, capacity int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records = append(b.records, rec)
+	if len(b.records) > capacity {
+		b.records = append(b.records[:0], b.records[len(b.records)-capacity:]...)
+	}
+}
+
+// list returns the records newest first.
+func (b *tapBuffer) list() []TapRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	records := make([]TapRecord, len(b.records))
+	for i, rec := range b.records {
+		records[len(records)-1-i] = rec
+	}
+	return records
+}
+
+func (b *tapBuffer) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records = nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and notes whether there was more.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.limit - c.buf.Len(); room < len(p) {
+		c.buf.Write(p[:max(room, 0)])
+		c.truncated = true
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+type tapResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (w *tapResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tapResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush a streamed backup.
+func (w *tapResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tapMiddleware records the sampled requests, and the slow ones. The request body is recorded as the handler reads it,
+// so a handler that stops reading early leaves the rest out.
+func tapMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := currentConfig().Tap
+		slow := time.Duration(currentConfig().SlowRequests.Threshold)
+		sampled := rand.Float64() < c.SampleRate
+		if !c.Enabled || strings.HasPrefix(r.URL.Path, "/admin") || r.URL.Path == "/metrics" || !sampled && slow == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := TapRecord{
+			ID:      uuid.New().String(),
+			At:      time.Now().UTC(),
+			Request: TapMessage{Method: r.Method, URL: r.URL.String(), Header: redactHeader(r.Header)},
+		}
+		requestBody := &cappedBuffer{limit: c.maxBodyBytes()}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, requestBody), r.Body}
+		tw := &tapResponseWriter{ResponseWriter: w, body: &cappedBuffer{limit: c.maxBodyBytes()}}
+
+		next.ServeHTTP(tw, r)
+
+		rec.Duration = Duration(time.Since(rec.At))
+		if !sampled && time.Duration(rec.Duration) < slow {
+			return
+		}
+		rec.Request.Body, rec.Request.BodyTruncated = requestBody.buf.String(), requestBody.truncated
+		rec.Response = TapMessage{Status: tw.status, Header: redactHeader(tw.Header()), Body: tw.body.buf.String(), BodyTruncated: tw.body.truncated}
+		tap.add(rec, c.capacity())
+	})
+}
+
+// tapHandler serves GET /admin/tap, optionally narrowed down by ?path= (a prefix of the request path) and ?status=,
+// and DELETE /admin/tap to clear it.
+func tapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		tap.clear()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	status := 0
+	if s := r.URL.Query().Get("status"); s != "" {
+		var err error
+		if status, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "The status must be an HTTP status code.", http.StatusBadRequest)
+			return
+		}
+	}
+	records := []TapRecord{}
+	for _, rec := range tap.list() {
+		if status != 0 && rec.Response.Status != status {
+			continue
+		}
+		if path != "" && !strings.HasPrefix(strings.SplitN(rec.Request.URL, "?", 2)[0], path) {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	jsonResponse, err := json.Marshal(map[string][]TapRecord{"records": records})
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func listTap(t *testing.T, router http.Handler, query string) []TapRecord {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/admin/tap"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /admin/tap returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var got map[string][]TapRecord
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	return got["records"]
+}
+
+func TestTap(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Build()
+
+	testCases := []struct {
+		name        string
+		config      TapConfig
+		wantRecords int
+	}{
+		{name: "disabled", config: TapConfig{SampleRate: 1}, wantRecords: 0},
+		{name: "not sampled", config: TapConfig{Enabled: true, SampleRate: 0}, wantRecords: 0},
+		{name: "everything", config: TapConfig{Enabled: true, SampleRate: 1}, wantRecords: 3},
+		{name: "ring buffer", config: TapConfig{Enabled: true, SampleRate: 1, Capacity: 2}, wantRecords: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tap.clear()
+			live := cfg
+			live.Tap = tc.config
+			liveConfig.Store(&live)
+
+			for range 3 {
+				req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON()))
+				req.Header.Set("Authorization", "Bearer secret")
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			// never recorded.
+			router.ServeHTTP(httptest.NewRecorder(), adminRequest("GET", "/admin/loglevel", nil))
+
+			records := listTap(t, router, "")
+			if len(records) != tc.wantRecords {
+				t.Fatalf("tap has %v records, want %v", len(records), tc.wantRecords)
+			}
+			for _, rec := range records {
+				if rec.Request.Body != string(receipt.JSON()) || rec.Response.Status != http.StatusOK || !strings.Contains(rec.Response.Body, `"id"`) {
+					t.Errorf("record = %+v, want the receipt and its ID", rec)
+				}
+				if got := rec.Request.Header.Get("Authorization"); got != "***" {
+					t.Errorf("Authorization header recorded as %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestTapFilters(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Tap = TapConfig{Enabled: true, SampleRate: 1, MaxBodyBytes: 10}
+	liveConfig.Store(&live)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Build().JSON())))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/receipts/"+unknownReceiptID+"/points", nil))
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus []int
+	}{
+		{name: "all, newest first", query: "", wantStatus: []int{http.StatusNotFound, http.StatusOK}},
+		{name: "by status", query: "?status=404", wantStatus: []int{http.StatusNotFound}},
+		{name: "by path", query: "?path=/receipts/process", wantStatus: []int{http.StatusOK}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := listTap(t, router, tc.query)
+			if len(records) != len(tc.wantStatus) {
+				t.Fatalf("got %v records, want %v", len(records), len(tc.wantStatus))
+			}
+			for i, rec := range records {
+				if rec.Response.Status != tc.wantStatus[i] {
+					t.Errorf("records[%d] status = %v, want %v", i, rec.Response.Status, tc.wantStatus[i])
+				}
+			}
+		})
+	}
+
+	records := listTap(t, router, "?path=/receipts/process")
+	if rec := records[0]; len(rec.Request.Body) != 10 || !rec.Request.BodyTruncated {
+		t.Errorf("request body = %q (truncated %v), want the first 10 bytes", rec.Request.Body, rec.Request.BodyTruncated)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("DELETE", "/admin/tap", nil))
+	if rr.Code != http.StatusNoContent || len(listTap(t, router, "")) != 0 {
+		t.Errorf("DELETE /admin/tap returned %v and left records", rr.Code)
+	}
+}
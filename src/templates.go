@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ReceiptTemplate is what the receipts of a kiosk have in common, so it can submit just what differs. See
+// expandTemplate.
+type ReceiptTemplate struct {
+	ID            string         `json:"id"`
+	Retailer      string         `json:"retailer"`
+	Items         []ItemDTO      `json:"items,omitempty"`
+	StoreLocation *StoreLocation `json:"storeLocation,omitempty"`
+	PaymentMethod string         `json:"paymentMethod,omitempty"`
+	CreatedAt     time.Time      `json:"createdAt"`
+}
+
+// Validate checks the template's fields like those of a receipt, so receipts made from it only fail on what they add.
+func (t ReceiptTemplate) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Retailer,
+			validation.Required,
+			validation.Match(regexp.MustCompile(`^[\w\s\-&]+$`)).Error("only alphanumeric characters, spaces, hyphens, and ampersands are allowed")),
+		validation.Field(&t.Items, validation.Length(0, currentConfig().ReceiptLimits.maxItems())),
+		validation.Field(&t.StoreLocation),
+		validation.Field(&t.PaymentMethod,
+			validation.In("cash", "credit", "debit", "giftCard", "storeCard").Error("want cash, credit, debit, giftCard or storeCard")),
+	)
+}
+
+// templateRegistry holds the receipt templates, in memory like the receipt groups.
+type templateRegistry struct {
+	mu        sync.Mutex
+	templates map[string]ReceiptTemplate
+}
+
+var receiptTemplates *templateRegistry
+
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{templates: map[string]ReceiptTemplate{}}
+}
+
+func (g *templateRegistry) add(t ReceiptTemplate) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.templates[t.ID] = t
+}
+
+func (g *templateRegistry) get(id string) (ReceiptTemplate, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t, ok := g.templates[id]
+	return t, ok
+}
+
+func (g *templateRegistry) remove(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.templates[id]
+	delete(g.templates, id)
+	return ok
+}
+
+var errUnknownTemplate = errors.New("the receipt references an unknown template")
+
+// expandTemplate turns a shorthand submission into the full receipt, before it is validated like any other. A
+// shorthand submission names a template and has the fields that differ from it, every field it has replacing the
+// template's, items as a whole:
+//
+//	{"template": "6f1c...", "purchaseDate": "2022-01-01", "purchaseTime": "13:01"}
+//
+// Without a total, the total is the sum of the item prices. Submissions without a template are returned as they are.
+func expandTemplate(b []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil || fields["template"] == nil {
+		return b, nil
+	}
+	var id string
+	if err := json.Unmarshal(fields["template"], &id); err != nil {
+		return nil, err
+	}
+	t, ok := receiptTemplates.get(id)
+	if !ok {
+		return nil, errUnknownTemplate
+	}
+
+	receipt := map[string]any{"retailer": t.Retailer, "items": t.Items}
+	if t.StoreLocation != nil {
+		receipt["storeLocation"] = t.StoreLocation
+	}
+	if t.PaymentMethod != "" {
+		receipt["paymentMethod"] = t.PaymentMethod
+	}
+	for field, value := range fields {
+		if field != "template" {
+			receipt[field] = value
+		}
+	}
+	if _, ok := receipt["total"]; !ok {
+		var items []ItemDTO
+		if b, err := json.Marshal(receipt["items"]); err == nil && json.Unmarshal(b, &items) == nil {
+			if total, ok := sumPrices(items); ok {
+				receipt["total"] = total
+			}
+		}
+	}
+	return json.Marshal(receipt)
+}
+
+// sumPrices adds up the prices of items in cents, false if one isn't a valid price; validation reports those.
+func sumPrices(items []ItemDTO) (string, bool) {
+	var cents int64
+	for _, item := range items {
+		price, err := strconv.ParseFloat(item.Price, 64)
+		if err != nil || price < 0 {
+			return "", false
+		}
+		cents += int64(price*100 + 0.5)
+	}
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100), true
+}
+
+// createTemplate serves POST /receipt-templates, returning the template with its ID.
+func createTemplate(w http.ResponseWriter, r *http.Request) {
+	var t ReceiptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "The template is invalid.", http.StatusBadRequest)
+		return
+	}
+	normalizeTemplate(&t)
+	if err := t.Validate(); err != nil {
+		http.Error(w, "The template is invalid: "+err.Error()+".", http.StatusBadRequest)
+		return
+	}
+	t.ID, t.CreatedAt = uuid.New().String(), time.Now().UTC()
+	receiptTemplates.add(t)
+	logger.Debug("Created receipt template", zap.String("templateID", t.ID), zap.String("retailer", t.Retailer))
+
+	writeTemplate(w, http.StatusCreated, t)
+}
+
+// normalizeTemplate trims the template's retailer and item descriptions, so receipts expanded from it don't come with
+// whitespace warnings.
+func normalizeTemplate(t *ReceiptTemplate) {
+	t.Retailer = strings.TrimSpace(t.Retailer)
+	for i := range t.Items {
+		t.Items[i].ShortDescription = strings.TrimSpace(t.Items[i].ShortDescription)
+	}
+}
+
+// getTemplate serves GET /receipt-templates/{id}.
+func getTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := receiptTemplates.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "No receipt template found for that ID.", http.StatusNotFound)
+		return
+	}
+	writeTemplate(w, http.StatusOK, t)
+}
+
+// deleteTemplate serves DELETE /receipt-templates/{id}. Receipts submitted from it are kept.
+func deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if !receiptTemplates.remove(mux.Vars(r)["id"]) {
+		http.Error(w, "No receipt template found for that ID.", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTemplate(w http.ResponseWriter, status int, t ReceiptTemplate) {
+	jsonResponse, err := json.Marshal(t)
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
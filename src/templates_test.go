@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReceiptTemplates(t *testing.T) {
+	router := setup()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipt-templates", strings.NewReader(`{
+		"retailer": " M&M Corner Market ",
+		"items": [{"shortDescription": "Gatorade", "price": "2.25"}, {"shortDescription": "Gatorade", "price": "2.25"}],
+		"paymentMethod": "cash"
+	}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST /receipt-templates = %v %s, want 201", rr.Code, rr.Body)
+	}
+	var template ReceiptTemplate
+	json.Unmarshal(rr.Body.Bytes(), &template)
+	if template.ID == "" || template.Retailer != "M&M Corner Market" {
+		t.Fatalf("created template = %+v, want an ID and the retailer trimmed", template)
+	}
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTotal  string
+		wantItems  int
+	}{
+		{name: "date and time only", body: `{"template": "` + template.ID + `", "purchaseDate": "2022-03-20", "purchaseTime": "14:33"}`, wantStatus: http.StatusOK, wantTotal: "4.50", wantItems: 2},
+		{name: "items replaced", body: `{"template": "` + template.ID + `", "purchaseDate": "2022-03-20", "purchaseTime": "14:33", "items": [{"shortDescription": "Dasani", "price": "1.40"}]}`, wantStatus: http.StatusOK, wantTotal: "1.40", wantItems: 1},
+		{name: "total given", body: `{"template": "` + template.ID + `", "purchaseDate": "2022-03-20", "purchaseTime": "14:33", "total": "5.00"}`, wantStatus: http.StatusOK, wantTotal: "5.00", wantItems: 2},
+		{name: "validated like a full receipt", body: `{"template": "` + template.ID + `", "purchaseDate": "2022-03-20"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown template", body: `{"template": "nope", "purchaseDate": "2022-03-20", "purchaseTime": "14:33"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(tc.body)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("POST /receipts/process = %v %s, want %v", rr.Code, rr.Body, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			rec, err := receiptStore.Get(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored ReceiptDTO
+			json.Unmarshal(rec.Receipt, &stored)
+			if stored.Retailer != "M&M Corner Market" || stored.PaymentMethod != "cash" || stored.Total != tc.wantTotal || len(stored.Items) != tc.wantItems {
+				t.Errorf("stored receipt = %+v, want the template's fields with total %v and %v items", stored, tc.wantTotal, tc.wantItems)
+			}
+		})
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/receipt-templates/"+template.ID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /receipt-templates/%v = %v, want 204", template.ID, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipt-templates/"+template.ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET /receipt-templates/%v after deleting it = %v, want 404", template.ID, rr.Code)
+	}
+}
+
+func TestCreateTemplateInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{name: "no retailer", body: `{"items": [{"shortDescription": "Gatorade", "price": "2.25"}]}`},
+		{name: "invalid item", body: `{"retailer": "Target", "items": [{"shortDescription": "Gatorade", "price": "2.2"}]}`},
+		{name: "unknown payment method", body: `{"retailer": "Target", "paymentMethod": "barter"}`},
+		{name: "not JSON", body: `retailer=Target`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipt-templates", strings.NewReader(tc.body)))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("POST /receipt-templates = %v %s, want 400", rr.Code, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ThrottleConfig caps the receipts an account can earn points for per day (UTC). Beyond the cap receipts are still
+// accepted but earn zero points in the default "zeroPoints" mode, the way the loyalty program handles excess
+// submissions, or rejected with 429 in "reject" mode. 0 means no cap.
+//
+// DailyReceiptsPerRetailer and RetailerQuotas cap the receipts an account can submit from a single retailer per day,
+// against receipt farming at one store. RetailerQuotas overrides the default for the retailers it lists, by name as
+// normalizeRetailer sees it. Receipts over a retailer quota are always rejected.
+type ThrottleConfig struct {
+	DailyReceiptsPerAccount  int            `json:"dailyReceiptsPerAccount"`
+	Mode                     string         `json:"mode"`
+	DailyReceiptsPerRetailer int            `json:"dailyReceiptsPerRetailer"`
+	RetailerQuotas           map[string]int `json:"retailerQuotas"`
+}
+
+func (c ThrottleConfig) Validate() error {
+	if c.DailyReceiptsPerAccount < 0 {
+		return fmt.Errorf("throttle: dailyReceiptsPerAccount must not be negative")
+	}
+	if c.Mode != "" && c.Mode != "zeroPoints" && c.Mode != "reject" {
+		return fmt.Errorf("throttle: mode must be \"zeroPoints\" or \"reject\", got %q", c.Mode)
+	}
+	if c.DailyReceiptsPerRetailer < 0 {
+		return fmt.Errorf("throttle: dailyReceiptsPerRetailer must not be negative")
+	}
+	seen := map[string]string{}
+	for retailer, quota := range c.RetailerQuotas {
+		name := normalizeRetailer(retailer)
+		if name == "" || quota < 0 {
+			return fmt.Errorf("throttle: retailerQuotas needs retailer names and quotas that aren't negative, got %q: %d", retailer, quota)
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("throttle: retailerQuotas lists %q and %q, which are the same retailer", other, retailer)
+		}
+		seen[name] = retailer
+	}
+	return nil
+}
+
+// retailerQuota returns the daily quota for the normalized retailer name, 0 meaning none.
+func (c ThrottleConfig) retailerQuota(name string) int {
+	for retailer, quota := range c.RetailerQuotas {
+		if normalizeRetailer(retailer) == name {
+			return quota
+		}
+	}
+	return c.DailyReceiptsPerRetailer
+}
+
+// normalizeRetailer reduces a retailer name to lowercase letters and digits separated by single spaces, so
+// "M&M Corner Market", "m&m corner market" and "M & M  Corner-Market" are the same retailer.
+func normalizeRetailer(retailer string) string {
+	words := strings.FieldsFunc(strings.ToLower(retailer), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+var errDailyLimitReached = errors.New("the account has reached its daily receipt limit")
+
+// retailerQuotaError is returned for receipts over a retailer quota.
+type retailerQuotaError struct {
+	retailer string
+	quota    int
+}
+
+func (e retailerQuotaError) Error() string {
+	return fmt.Sprintf("the account has reached its daily limit of %d receipts from %s", e.quota, e.retailer)
+}
+
+// dailyCounter counts receipts per key for the current day only, in the timezone of the account the key is for (see
+// accountNow). A key's count starts over with its day, and days that are over everywhere are dropped.
+type dailyCounter struct {
+	mu     sync.Mutex
+	counts map[string]dayCount
+	pruned string
+}
+
+type dayCount struct {
+	day string
+	n   int
+}
+
+var receiptCounter *dailyCounter
+
+func newDailyCounter() *dailyCounter {
+	return &dailyCounter{counts: map[string]dayCount{}}
+}
+
+// current returns the count of key for the day of now, dropping the counts of days over in every timezone once a UTC
+// day. It must be called with mu held.
+func (c *dailyCounter) current(key string, now time.Time) dayCount {
+	if today := now.UTC().Format("2006-01-02"); today != c.pruned {
+		c.pruned = today
+		// no timezone is more than a day off UTC.
+		over := now.UTC().AddDate(0, 0, -2).Format("2006-01-02")
+		for k, count := range c.counts {
+			if count.day <= over {
+				delete(c.counts, k)
+			}
+		}
+	}
+	day := now.Format("2006-01-02")
+	if count := c.counts[key]; count.day == day {
+		return count
+	}
+	return dayCount{day: day}
+}
+
+// take counts a receipt for account unless it already reached limit.
+func (c *dailyCounter) take(account string, limit int, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := c.current(account, now)
+	if count.n >= limit {
+		return false
+	}
+	count.n++
+	c.counts[account] = count
+	return true
+}
+
+// release gives back a receipt counted by take that didn't go through after all.
+func (c *dailyCounter) release(account string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if count := c.current(account, now); count.n > 0 {
+		count.n--
+		c.counts[account] = count
+	}
+}
+
+func (c *dailyCounter) reached(account string, limit int, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current(account, now).n >= limit
+}
+
+// throttleReceipt applies the daily cap to a receipt about to be submitted for account. counted means it took up one
+// of the account's receipts for today.
+func throttleReceipt(account string) (counted, throttled bool, err error) {
+	c := currentConfig().Throttle
+	if c.DailyReceiptsPerAccount == 0 {
+		return false, false, nil
+	}
+	if receiptCounter.take(pointsLedger.Resolve(account), c.DailyReceiptsPerAccount, accountNow(account)) {
+		return true, false, nil
+	}
+	if c.Mode == "reject" {
+		return false, false, errDailyLimitReached
+	}
+	return false, true, nil
+}
+
+// retailerCounter counts receipts per account and normalized retailer, keyed by account and retailer separated by a
+// newline, which neither can contain.
+var retailerCounter *dailyCounter
+
+func retailerCounterKey(account, retailer string) string {
+	return pointsLedger.Resolve(account) + "\n" + normalizeRetailer(retailer)
+}
+
+// takeRetailerQuota counts a receipt from retailer against the account's quota for it. counted means it has to be
+// released if the receipt isn't stored after all.
+func takeRetailerQuota(account, retailer string) (counted bool, err error) {
+	quota := currentConfig().Throttle.retailerQuota(normalizeRetailer(retailer))
+	if quota == 0 {
+		return false, nil
+	}
+	if !retailerCounter.take(retailerCounterKey(account, retailer), quota, accountNow(account)) {
+		return false, retailerQuotaError{retailer: retailer, quota: quota}
+	}
+	return true, nil
+}
+
+// dailyCapRule is added to a score breakdown when the account is over its daily cap, cancelling out the other rules.
+func dailyCapRule(limit, points int) RuleResult {
+	return RuleResult{
+		Rule:        "dailyReceiptCap",
+		Description: fmt.Sprintf("Receipts over the daily limit of %d per account earn no points.", limit),
+		Points:      -points,
+	}
+}
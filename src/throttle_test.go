@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestDailyCounter(t *testing.T) {
+	c := newDailyCounter()
+	day := time.Date(2022, 1, 1, 23, 0, 0, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false} {
+		if got := c.take("alice", 2, day); got != want {
+			t.Errorf("take() #%d = %v, want %v", i, got, want)
+		}
+	}
+	c.release("alice", day)
+	if !c.take("alice", 2, day) {
+		t.Errorf("take() after release = false, want true")
+	}
+	if !c.take("alice", 2, day.Add(2*time.Hour)) {
+		t.Errorf("take() on the next day = false, want true")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	testCases := []struct {
+		name          string
+		mode          string
+		wantStatus    int
+		wantThrottled bool
+	}{
+		{name: "zero points", mode: "", wantStatus: http.StatusOK, wantThrottled: true},
+		{name: "reject", mode: "reject", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setup()
+			live := cfg
+			live.Throttle = ThrottleConfig{DailyReceiptsPerAccount: 2, Mode: tc.mode}
+			liveConfig.Store(&live)
+			receipt := receipttest.New().Build()
+
+			earned := submitForAccount(t, router, "alice", receipt) + submitForAccount(t, router, "alice", receipt)
+			// other accounts and receipts without an account aren't affected.
+			submitForAccount(t, router, "bob", receipt)
+
+			score := httptest.NewRequest("POST", "/receipts/score", bytes.NewReader(receipt.JSON()))
+			score.Header.Set("X-Account-ID", "alice")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, score)
+			var preview scoreResponse
+			json.Unmarshal(rr.Body.Bytes(), &preview)
+			if preview.Points != 0 || !preview.Throttled || preview.Breakdown[len(preview.Breakdown)-1].Rule != "dailyReceiptCap" {
+				t.Errorf("score = %+v, want zero points with the daily cap in the breakdown", preview)
+			}
+
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON()))
+			req.Header.Set("X-Account-ID", "alice")
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if _, balance := getBalanceOf(t, router, "alice"); balance != earned {
+				t.Errorf("balance = %v, want %v", balance, earned)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				ID        string `json:"id"`
+				Throttled bool   `json:"throttled"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if !resp.Throttled {
+				t.Errorf("response %s doesn't say the receipt was throttled", rr.Body)
+			}
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+resp.ID+"/points", nil))
+			if rr.Body.String() != `{"points":0}` {
+				t.Errorf("points = %s, want 0", rr.Body)
+			}
+			if got := len(pointsLedger.Receipts("alice")); got != 3 {
+				t.Errorf("account has %v receipts, want the throttled one too", got)
+			}
+		})
+	}
+}
+
+func TestNormalizeRetailer(t *testing.T) {
+	testCases := []struct {
+		retailer string
+		want     string
+	}{
+		{retailer: "M&M Corner Market", want: "m m corner market"},
+		{retailer: "  M & M  Corner-Market ", want: "m m corner market"},
+		{retailer: "7-Eleven", want: "7 eleven"},
+		{retailer: "&-", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.retailer, func(t *testing.T) {
+			if got := normalizeRetailer(tc.retailer); got != tc.want {
+				t.Errorf("normalizeRetailer(%q) = %q, want %q", tc.retailer, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetailerQuotas(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Throttle = ThrottleConfig{DailyReceiptsPerRetailer: 2, RetailerQuotas: map[string]int{"M&M Corner Market": 1}}
+	liveConfig.Store(&live)
+
+	submit := func(account, retailer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipttest.New().Retailer(retailer).Build().JSON()))
+		req.Header.Set("X-Account-ID", account)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name       string
+		account    string
+		retailer   string
+		wantStatus int
+	}{
+		{name: "first from Target", account: "alice", retailer: "Target", wantStatus: http.StatusOK},
+		{name: "second from Target", account: "alice", retailer: "target", wantStatus: http.StatusOK},
+		{name: "over the default quota", account: "alice", retailer: "TARGET", wantStatus: http.StatusTooManyRequests},
+		{name: "other retailer", account: "alice", retailer: "Walgreens", wantStatus: http.StatusOK},
+		{name: "other account", account: "bob", retailer: "Target", wantStatus: http.StatusOK},
+		{name: "first from a retailer with its own quota", account: "alice", retailer: "M&M Corner Market", wantStatus: http.StatusOK},
+		{name: "over its own quota", account: "alice", retailer: "M & M Corner-Market", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := submit(tc.account, tc.retailer)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if rr.Code == http.StatusTooManyRequests && !strings.Contains(rr.Body.String(), "receipts from "+tc.retailer) {
+				t.Errorf("response %q doesn't name the retailer", rr.Body)
+			}
+		})
+	}
+}
+
+func TestThrottleConfigRetailerQuotas(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ThrottleConfig
+		wantErr bool
+	}{
+		{name: "valid", config: ThrottleConfig{DailyReceiptsPerRetailer: 3, RetailerQuotas: map[string]int{"Target": 5}}},
+		{name: "negative default", config: ThrottleConfig{DailyReceiptsPerRetailer: -1}, wantErr: true},
+		{name: "negative quota", config: ThrottleConfig{RetailerQuotas: map[string]int{"Target": -1}}, wantErr: true},
+		{name: "empty name", config: ThrottleConfig{RetailerQuotas: map[string]int{"--": 1}}, wantErr: true},
+		{name: "same retailer twice", config: ThrottleConfig{RetailerQuotas: map[string]int{"Target": 1, "TARGET": 2}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MDanialSaleem/fcpc/store"
+)
+
+// TransactionNumberConfig sets the format transaction numbers (the unique code many retailers print on receipts,
+// often as a barcode) must have: Retailers by name as normalizeRetailer sees it, Default for everyone else.
+type TransactionNumberConfig struct {
+	Default   TransactionNumberFormat            `json:"default"`
+	Retailers map[string]TransactionNumberFormat `json:"retailers"`
+}
+
+// TransactionNumberFormat is a regular expression the whole number must match and the check digit scheme of its
+// last digit: "luhn" (card style), "mod10" (UPC and EAN barcodes) or "" for none.
+type TransactionNumberFormat struct {
+	Pattern  string `json:"pattern"`
+	Checksum string `json:"checksum"`
+
+	pattern *regexp.Regexp
+}
+
+// defaultTransactionNumberPattern applies when neither the retailer nor the default format has a pattern.
+var defaultTransactionNumberPattern = regexp.MustCompile(`^[A-Za-z0-9-]{4,64}$`)
+
+var checksums = map[string]func(digits string) bool{
+	"":      func(string) bool { return true },
+	"luhn":  luhnValid,
+	"mod10": mod10Valid,
+}
+
+func (f *TransactionNumberFormat) compile(name string) error {
+	if _, ok := checksums[f.Checksum]; !ok {
+		return fmt.Errorf("transactionNumbers: %v: checksum must be \"luhn\", \"mod10\" or empty, got %q", name, f.Checksum)
+	}
+	if f.Pattern == "" {
+		f.pattern = defaultTransactionNumberPattern
+		return nil
+	}
+	pattern, err := regexp.Compile(f.Pattern)
+	if err != nil {
+		return fmt.Errorf("transactionNumbers: %v: %w", name, err)
+	}
+	f.pattern = pattern
+	return nil
+}
+
+// Validate checks the config and compiles its patterns, so it has to run before the config is used.
+func (c *TransactionNumberConfig) Validate() error {
+	if err := c.Default.compile("default"); err != nil {
+		return err
+	}
+	normalized := make(map[string]TransactionNumberFormat, len(c.Retailers))
+	for retailer, format := range c.Retailers {
+		name := normalizeRetailer(retailer)
+		if name == "" {
+			return fmt.Errorf("transactionNumbers: retailers needs retailer names, got %q", retailer)
+		}
+		if _, ok := normalized[name]; ok {
+			return fmt.Errorf("transactionNumbers: retailers lists %q more than once", name)
+		}
+		if err := format.compile(retailer); err != nil {
+			return err
+		}
+		normalized[name] = format
+	}
+	c.Retailers = normalized
+	return nil
+}
+
+// check reports what is wrong with number as a transaction number of retailer, if anything.
+func (c TransactionNumberConfig) check(retailer, number string) error {
+	format, ok := c.Retailers[normalizeRetailer(retailer)]
+	if !ok {
+		format = c.Default
+	}
+	pattern := format.pattern
+	if pattern == nil {
+		// a config that never went through Validate, e.g. the zero value.
+		pattern = defaultTransactionNumberPattern
+	}
+	if !pattern.MatchString(number) {
+		return fmt.Errorf("doesn't have the format of %s transaction numbers", retailer)
+	}
+	if !checksums[format.Checksum](number) {
+		return fmt.Errorf("has an invalid %s check digit", format.Checksum)
+	}
+	return nil
+}
+
+// digitsOf returns the digits of s, ignoring hyphens and spaces, or false if it has anything else.
+func digitsOf(s string) ([]int, bool) {
+	var digits []int
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, int(r-'0'))
+		case r == '-' || r == ' ':
+		default:
+			return nil, false
+		}
+	}
+	return digits, len(digits) > 1
+}
+
+// luhnValid checks the Luhn check digit: from the right, every second digit is doubled (minus 9 above 9) and the sum
+// must be a multiple of 10.
+func luhnValid(s string) bool {
+	digits, ok := digitsOf(s)
+	if !ok {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// mod10Valid checks the GS1 check digit of UPC and EAN codes: from the right and without the check digit, digits are
+// weighted 3, 1, 3, ... and the check digit tops the sum up to a multiple of 10.
+func mod10Valid(s string) bool {
+	digits, ok := digitsOf(s)
+	if !ok {
+		return false
+	}
+	sum := 0
+	for i, d := range slices.Backward(digits[:len(digits)-1]) {
+		weight := 1
+		if (len(digits)-2-i)%2 == 0 {
+			weight = 3
+		}
+		sum += d * weight
+	}
+	return (10-sum%10)%10 == digits[len(digits)-1]
+}
+
+// duplicateTransactionError is returned for a receipt whose transaction number was submitted before for the same
+// retailer.
+type duplicateTransactionError struct {
+	existing string
+}
+
+func (e duplicateTransactionError) Error() string {
+	return "a receipt with this transaction number was already submitted as " + e.existing
+}
+
+// transactionIndex maps transaction numbers to the receipts submitted with them, per retailer. It lives in memory
+// and is rebuilt from the store on startup, so with several replicas a duplicate sent to another replica is only
+// caught after a restart.
+type transactionIndex struct {
+	mu sync.Mutex
+	// number -> normalized retailer -> receipt ID.
+	receipts map[string]map[string]string
+}
+
+var transactions *transactionIndex
+
+func newTransactionIndex() *transactionIndex {
+	return &transactionIndex{receipts: map[string]map[string]string{}}
+}
+
+// normalizeTransactionNumber makes numbers printed with or without separators, or in another case, the same.
+func normalizeTransactionNumber(number string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(number))
+}
+
+// claim records id as the receipt of number at retailer, unless another receipt has it already, whose ID it returns.
+func (t *transactionIndex) claim(retailer, number, id string) (string, bool) {
+	number, retailer = normalizeTransactionNumber(number), normalizeRetailer(retailer)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.receipts[number][retailer]; ok {
+		return existing, false
+	}
+	if t.receipts[number] == nil {
+		t.receipts[number] = map[string]string{}
+	}
+	t.receipts[number][retailer] = id
+	return "", true
+}
+
+// release forgets a claim whose receipt wasn't stored after all.
+func (t *transactionIndex) release(retailer, number, id string) {
+	number, retailer = normalizeTransactionNumber(number), normalizeRetailer(retailer)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.receipts[number][retailer] == id {
+		delete(t.receipts[number], retailer)
+	}
+}
+
+// lookup returns the IDs of the receipts with number, of any retailer, sorted.
+func (t *transactionIndex) lookup(number string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Sorted(maps.Values(t.receipts[normalizeTransactionNumber(number)]))
+}
+
+// loadTransactionIndex rebuilds the index from every stored receipt with a transaction number.
+func loadTransactionIndex(ctx context.Context, s store.Store) (*transactionIndex, error) {
+	index := newTransactionIndex()
+	err := s.Scan(ctx, func(rec store.Record) error {
+		var receipt struct {
+			Retailer          string `json:"retailer"`
+			TransactionNumber string `json:"transactionNumber"`
+		}
+		if err := json.Unmarshal(rec.Receipt, &receipt); err != nil {
+			return fmt.Errorf("receipt %s: %w", rec.ID, err)
+		}
+		if receipt.TransactionNumber != "" {
+			index.claim(receipt.Retailer, receipt.TransactionNumber, rec.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// claimTransaction claims the transaction number of receipt, if it has one, for the receipt id. The claim of a
+// receipt that is gone, erased or past retention, is taken over, so the receipt can be submitted again.
+func claimTransaction(ctx context.Context, receipt Receipt, id string) error {
+	if receipt.TransactionNumber == "" {
+		return nil
+	}
+	for {
+		existing, ok := transactions.claim(receipt.Retailer, receipt.TransactionNumber, id)
+		if ok {
+			return nil
+		}
+		_, err := receiptStore.Get(ctx, existing)
+		if err == nil {
+			return duplicateTransactionError{existing: existing}
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		transactions.release(receipt.Retailer, receipt.TransactionNumber, existing)
+	}
+}
+
+// receiptsWithTransaction loads the stored receipts with the transaction number and returns the page of them opts asks
+// for. There are only ever a few per number, so they are sorted here rather than by the store.
+func receiptsWithTransaction(ctx context.Context, number string, opts store.ListOptions) ([]store.Record, error) {
+	var records []store.Record
+	for _, id := range transactions.lookup(number) {
+		rec, err := receiptStore.Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return store.Page(records, opts), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestChecksums(t *testing.T) {
+	testCases := []struct {
+		name     string
+		checksum func(string) bool
+		number   string
+		want     bool
+	}{
+		{name: "luhn", checksum: luhnValid, number: "79927398713", want: true},
+		{name: "luhn with hyphens", checksum: luhnValid, number: "7992-7398-713", want: true},
+		{name: "luhn wrong check digit", checksum: luhnValid, number: "79927398710", want: false},
+		{name: "luhn letters", checksum: luhnValid, number: "7992A398713", want: false},
+		{name: "ean-13", checksum: mod10Valid, number: "4006381333931", want: true},
+		{name: "upc-a", checksum: mod10Valid, number: "036000291452", want: true},
+		{name: "ean-13 wrong check digit", checksum: mod10Valid, number: "4006381333932", want: false},
+		{name: "single digit", checksum: mod10Valid, number: "0", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.checksum(tc.number); got != tc.want {
+				t.Errorf("checksum(%q) = %v, want %v", tc.number, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransactionNumberConfig(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  TransactionNumberConfig
+		wantErr bool
+	}{
+		{name: "empty", config: TransactionNumberConfig{}},
+		{name: "valid", config: TransactionNumberConfig{Default: TransactionNumberFormat{Checksum: "luhn"}, Retailers: map[string]TransactionNumberFormat{"Target": {Pattern: `^\d{13}$`, Checksum: "mod10"}}}},
+		{name: "unknown checksum", config: TransactionNumberConfig{Default: TransactionNumberFormat{Checksum: "crc32"}}, wantErr: true},
+		{name: "invalid pattern", config: TransactionNumberConfig{Retailers: map[string]TransactionNumberFormat{"Target": {Pattern: `^(`}}}, wantErr: true},
+		{name: "same retailer twice", config: TransactionNumberConfig{Retailers: map[string]TransactionNumberFormat{"Target": {}, "TARGET": {}}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransactionNumberValidation(t *testing.T) {
+	setup()
+	live := cfg
+	live.TransactionNumbers = TransactionNumberConfig{Retailers: map[string]TransactionNumberFormat{"Target": {Pattern: `^\d{13}$`, Checksum: "mod10"}}}
+	if err := live.TransactionNumbers.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	liveConfig.Store(&live)
+
+	testCases := []struct {
+		name    string
+		receipt receipttest.Receipt
+		wantErr bool
+	}{
+		{name: "none", receipt: receipttest.New().Build()},
+		{name: "retailer format", receipt: receipttest.New().TransactionNumber("4006381333931").Build()},
+		{name: "wrong check digit", receipt: receipttest.New().TransactionNumber("4006381333932").Build(), wantErr: true},
+		{name: "wrong format", receipt: receipttest.New().TransactionNumber("T-1234").Build(), wantErr: true},
+		{name: "default format", receipt: receipttest.New().Retailer("Walgreens").TransactionNumber("T-1234").Build()},
+		{name: "invalid default format", receipt: receipttest.New().Retailer("Walgreens").TransactionNumber("T 12/34").Build(), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var receipt Receipt
+			err := json.Unmarshal(tc.receipt.JSON(), &receipt)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDuplicateTransactionNumbers(t *testing.T) {
+	router := setup()
+
+	submit := func(receipt receipttest.Receipt) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON())))
+		return rr
+	}
+
+	first := submit(receipttest.New().TransactionNumber("TX-1001").Build())
+	if first.Code != http.StatusOK {
+		t.Fatalf("first submission returned %v: %s", first.Code, first.Body)
+	}
+	var firstID struct{ ID string }
+	json.Unmarshal(first.Body.Bytes(), &firstID)
+
+	testCases := []struct {
+		name       string
+		receipt    receipttest.Receipt
+		wantStatus int
+	}{
+		{name: "same number", receipt: receipttest.New().TransactionNumber("TX-1001").Build(), wantStatus: http.StatusConflict},
+		{name: "same number written differently", receipt: receipttest.New().Retailer("TARGET").TransactionNumber("tx1001").Build(), wantStatus: http.StatusConflict},
+		{name: "same number at another retailer", receipt: receipttest.New().Retailer("Walgreens").TransactionNumber("TX-1001").Build(), wantStatus: http.StatusOK},
+		{name: "other number", receipt: receipttest.New().TransactionNumber("TX-1002").Build(), wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := submit(tc.receipt); rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+		})
+	}
+
+	t.Run("search", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts?transactionNumber=tx-1001", nil))
+		var resp struct{ Receipts []struct{ ID string } }
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusOK || len(resp.Receipts) != 2 {
+			t.Errorf("search returned %v with %d receipts, want 2: %s", rr.Code, len(resp.Receipts), rr.Body)
+		}
+	})
+
+	t.Run("rebuilt from the store", func(t *testing.T) {
+		index, err := loadTransactionIndex(context.Background(), receiptStore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := index.claim("Target", "TX-1001", "other"); ok {
+			t.Error("the rebuilt index lost the transaction number of the first receipt")
+		}
+	})
+
+	t.Run("deleted receipt", func(t *testing.T) {
+		if err := receiptStore.Delete(context.Background(), firstID.ID); err != nil {
+			t.Fatal(err)
+		}
+		if rr := submit(receipttest.New().TransactionNumber("TX-1001").Build()); rr.Code != http.StatusOK {
+			t.Errorf("resubmitting after the receipt was deleted returned %v: %s", rr.Code, rr.Body)
+		}
+	})
+}
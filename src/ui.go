@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// uiFiles is the dashboard, plain HTML/JS talking to the JSON API so it needs no build step.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func registerUI(router *mux.Router) {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic("failed to load embedded ui: " + err.Error())
+	}
+	router.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently)).Methods("GET")
+	router.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", http.FileServer(http.FS(files)))).Methods("GET")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/store"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	apiKeyRequestsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_api_key_requests_total",
+		Help: "Requests made with an API key, by key and result (ok, client_error or server_error).",
+	}, []string{"key", "result"})
+
+	apiKeyPointsTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "fcpc_api_key_points_issued_total",
+		Help: "Points awarded for receipts submitted with an API key, by key.",
+	}, []string{"key"})
+)
+
+// Usage is what an API key did over some period. ErrorRate is the share of requests that got a 4xx or 5xx.
+type Usage struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"clientErrors"`
+	ServerErrors int64   `json:"serverErrors"`
+	ErrorRate    float64 `json:"errorRate"`
+	PointsIssued int64   `json:"pointsIssued"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.ClientErrors += o.ClientErrors
+	u.ServerErrors += o.ServerErrors
+	u.PointsIssued += o.PointsIssued
+	if u.Requests > 0 {
+		u.ErrorRate = float64(u.ClientErrors+u.ServerErrors) / float64(u.Requests)
+	}
+}
+
+// DailyUsage is the usage of a single UTC day.
+type DailyUsage struct {
+	Date string `json:"date"`
+	Usage
+}
+
+// UsageReport is the response of GET /admin/keys/{key}/usage. Days without any usage are left out of Days.
+type UsageReport struct {
+	Key   string       `json:"key"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Total Usage        `json:"total"`
+	Days  []DailyUsage `json:"days"`
+}
+
+// usageTracker keeps per key, per UTC day counters. Like the ledger it lives in memory, so a restart starts over;
+// the daily summaries in the logs are the durable record.
+type usageTracker struct {
+	mu   sync.Mutex
+	days map[string]map[string]*Usage // key ID -> date -> usage
+	// summarized is the last day a summary was logged for.
+	summarized string
+}
+
+var keyUsage = newUsageTracker()
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{days: map[string]map[string]*Usage{}, summarized: time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)}
+}
+
+func (t *usageTracker) add(id string, at time.Time, delta Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byDay := t.days[id]
+	if byDay == nil {
+		byDay = map[string]*Usage{}
+		t.days[id] = byDay
+	}
+	date := at.UTC().Format(time.DateOnly)
+	if byDay[date] == nil {
+		byDay[date] = &Usage{}
+	}
+	byDay[date].add(delta)
+}
+
+func (t *usageTracker) recordRequest(id string, status int) {
+	delta, result := Usage{Requests: 1}, "ok"
+	switch {
+	case status >= 500:
+		delta.ServerErrors, result = 1, "server_error"
+	case status >= 400:
+		delta.ClientErrors, result = 1, "client_error"
+	}
+	t.add(id, time.Now(), delta)
+	apiKeyRequestsTotal.WithLabelValues(id, result).Inc()
+}
+
+func (t *usageTracker) recordPoints(id string, points int64) {
+	t.add(id, time.Now(), Usage{PointsIssued: points})
+	apiKeyPointsTotal.WithLabelValues(id).Add(float64(points))
+}
+
+func (t *usageTracker) known(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.days[id]
+	return ok
+}
+
+// report sums up the usage of id between from and to, both inclusive dates.
+func (t *usageTracker) report(id string, from, to time.Time) UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := UsageReport{Key: id, From: from.Format(time.DateOnly), To: to.Format(time.DateOnly), Days: []DailyUsage{}}
+	for _, date := range slices.Sorted(maps.Keys(t.days[id])) {
+		if date < report.From || date > report.To {
+			continue
+		}
+		day := DailyUsage{Date: date}
+		day.add(*t.days[id][date])
+		report.Days = append(report.Days, day)
+		report.Total.add(*t.days[id][date])
+	}
+	return report
+}
+
+// summarize logs one line per key for every day that has fully passed since the last summary.
+func (t *usageTracker) summarize(now time.Time) {
+	yesterday := now.UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range slices.Sorted(maps.Keys(t.days)) {
+		for _, date := range slices.Sorted(maps.Keys(t.days[id])) {
+			if date <= t.summarized || date > yesterday {
+				continue
+			}
+			u := t.days[id][date]
+			logger.Info("Daily API key usage", zap.String("key", id), zap.String("date", date), zap.Int64("requests", u.Requests),
+				zap.Int64("clientErrors", u.ClientErrors), zap.Int64("serverErrors", u.ServerErrors),
+				zap.Float64("errorRate", u.ErrorRate), zap.Int64("pointsIssued", u.PointsIssued))
+		}
+	}
+	if yesterday > t.summarized {
+		t.summarized = yesterday
+	}
+}
+
+// expire drops the days before cutoff and returns how many per key days that was.
+func (t *usageTracker) expire(cutoff time.Time, dryRun bool) int {
+	before := cutoff.UTC().Format(time.DateOnly)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expired := 0
+	for id, byDay := range t.days {
+		for date := range byDay {
+			if date >= before {
+				continue
+			}
+			expired++
+			if !dryRun {
+				delete(byDay, date)
+			}
+		}
+		if len(byDay) == 0 && !dryRun {
+			delete(t.days, id)
+		}
+	}
+	return expired
+}
+
+func expireKeyUsage(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	return keyUsage.expire(cutoff, dryRun), nil
+}
+
+// startUsageSummaries checks every hour whether a day has passed and logs its usage summaries if so.
+func startUsageSummaries(ctx context.Context) {
+	go runPeriodically(ctx, time.Hour, func(ctx context.Context) {
+		keyUsage.summarize(time.Now())
+	})
+}
+
+const maxUsagePeriodDays = 366
+
+// parseUsagePeriod turns a period into an inclusive range of UTC dates. It is either the last N days ("7d", today
+// included), a month ("2022-01"), a day ("2022-01-31") or a range of days ("2022-01-01..2022-01-15").
+func parseUsagePeriod(period string, now time.Time) (time.Time, time.Time, bool) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	if days, ok := strings.CutSuffix(period, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 || n > maxUsagePeriodDays {
+			return time.Time{}, time.Time{}, false
+		}
+		return today.AddDate(0, 0, 1-n), today, true
+	}
+	if month, err := time.Parse("2006-01", period); err == nil {
+		return month, month.AddDate(0, 1, -1), true
+	}
+	first, last, isRange := strings.Cut(period, "..")
+	if !isRange {
+		last = first
+	}
+	from, err := time.Parse(time.DateOnly, first)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	to, err := time.Parse(time.DateOnly, last)
+	if err != nil || to.Before(from) || to.Sub(from) >= maxUsagePeriodDays*24*time.Hour {
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// getKeyUsage serves GET /admin/keys/{key}/usage?period=, the last 30 days by default. Static keys that were removed
+// from the config can still be looked up as long as their usage is kept.
+func getKeyUsage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["key"]
+	if _, configured := currentConfig().Auth.APIKeys[id]; !configured && !keyUsage.known(id) {
+		managed := false
+		if ks, ok := receiptStore.(store.KeyStore); ok {
+			_, err := ks.GetKey(r.Context(), id)
+			if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+				logger.Error("Failed to look up API key", zap.String("key", id), zap.Error(err))
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			managed = err == nil
+		}
+		if !managed {
+			http.Error(w, "No API key found for that ID.", http.StatusNotFound)
+			return
+		}
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "30d"
+	}
+	from, to, ok := parseUsagePeriod(period, time.Now())
+	if !ok {
+		http.Error(w, "The period must be a number of days like 7d, a month like 2022-01, a day like 2022-01-31 or a range like 2022-01-01..2022-01-15, at most 366 days.", http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(keyUsage.report(id, from, to))
+	if err != nil {
+		logger.Error("Failed to marshal response", zap.Error(err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestParseUsagePeriod(t *testing.T) {
+	now := time.Date(2022, 3, 10, 15, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		period   string
+		wantFrom string
+		wantTo   string
+		wantOK   bool
+	}{
+		{period: "1d", wantFrom: "2022-03-10", wantTo: "2022-03-10", wantOK: true},
+		{period: "7d", wantFrom: "2022-03-04", wantTo: "2022-03-10", wantOK: true},
+		{period: "2022-02", wantFrom: "2022-02-01", wantTo: "2022-02-28", wantOK: true},
+		{period: "2022-01-31", wantFrom: "2022-01-31", wantTo: "2022-01-31", wantOK: true},
+		{period: "2022-01-01..2022-01-15", wantFrom: "2022-01-01", wantTo: "2022-01-15", wantOK: true},
+		{period: "0d"},
+		{period: "400d"},
+		{period: "2022-01-15..2022-01-01"},
+		{period: "2021-01-01..2022-12-31"},
+		{period: "last week"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.period, func(t *testing.T) {
+			from, to, ok := parseUsagePeriod(tc.period, now)
+			if ok != tc.wantOK {
+				t.Fatalf("parseUsagePeriod(%q) ok = %v, want %v", tc.period, ok, tc.wantOK)
+			}
+			if ok && (from.Format(time.DateOnly) != tc.wantFrom || to.Format(time.DateOnly) != tc.wantTo) {
+				t.Errorf("parseUsagePeriod(%q) = %v..%v, want %v..%v", tc.period, from, to, tc.wantFrom, tc.wantTo)
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	router := setup()
+	receipt := receipttest.New().Build()
+	keys := map[string]string{"acme": "acme-secret-0123456789"}
+
+	testCases := []struct {
+		name       string
+		required   bool
+		key        string
+		wantStatus int
+	}{
+		{name: "anonymous", wantStatus: http.StatusOK},
+		{name: "valid key", key: "acme-secret-0123456789", wantStatus: http.StatusOK},
+		{name: "invalid key", key: "acme-secret", wantStatus: http.StatusUnauthorized},
+		{name: "required without key", required: true, wantStatus: http.StatusUnauthorized},
+		{name: "required with key", required: true, key: "acme-secret-0123456789", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := cfg
+			live.Auth = AuthConfig{Required: tc.required, APIKeys: keys}
+			liveConfig.Store(&live)
+
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON()))
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Auth = AuthConfig{APIKeys: map[string]string{"acme": "acme-secret-0123456789", "idle": "idle-secret-0123456789", "test-admin": testAdminSecret}, AdminKeys: []string{"test-admin"}}
+	liveConfig.Store(&live)
+
+	send := func(method, path string, body []byte) {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("X-API-Key", "acme-secret-0123456789")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	receipt := receipttest.New().Build()
+	send("POST", "/receipts/process", receipt.JSON())
+	send("POST", "/receipts/process", receipt.JSON())
+	send("GET", "/receipts/nope/points", nil)
+	// anonymous requests aren't attributed to anyone.
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON())))
+
+	var scored Receipt
+	json.Unmarshal(receipt.JSON(), &scored)
+	points := 2 * int64(scored.CalculatePoints())
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       Usage
+	}{
+		{name: "default period", path: "/admin/keys/acme/usage", wantStatus: http.StatusOK, want: Usage{Requests: 3, ClientErrors: 1, ErrorRate: 1.0 / 3, PointsIssued: points}},
+		{name: "today", path: "/admin/keys/acme/usage?period=1d", wantStatus: http.StatusOK, want: Usage{Requests: 3, ClientErrors: 1, ErrorRate: 1.0 / 3, PointsIssued: points}},
+		{name: "past period", path: "/admin/keys/acme/usage?period=2022-01", wantStatus: http.StatusOK},
+		{name: "configured but unused", path: "/admin/keys/idle/usage", wantStatus: http.StatusOK},
+		{name: "unknown key", path: "/admin/keys/nobody/usage", wantStatus: http.StatusNotFound},
+		{name: "invalid period", path: "/admin/keys/acme/usage?period=forever", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var report UsageReport
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if report.Total != tc.want {
+				t.Errorf("total usage = %+v, want %+v", report.Total, tc.want)
+			}
+		})
+	}
+}
+
+func TestUsageExpiry(t *testing.T) {
+	setup()
+	day := func(d int) time.Time { return time.Date(2022, 1, d, 12, 0, 0, 0, time.UTC) }
+	keyUsage.add("acme", day(1), Usage{Requests: 1})
+	keyUsage.add("acme", day(2), Usage{Requests: 1})
+	keyUsage.add("old", day(1), Usage{Requests: 1})
+
+	if got := keyUsage.expire(day(2), true); got != 2 {
+		t.Errorf("dry run expired %v days, want 2", got)
+	}
+	if got := keyUsage.expire(day(2), false); got != 2 {
+		t.Errorf("expired %v days, want 2", got)
+	}
+	if keyUsage.known("old") {
+		t.Error("key without usage left is still known")
+	}
+	if got := keyUsage.report("acme", day(1), day(2)).Total.Requests; got != 1 {
+		t.Errorf("acme has %v requests left, want 1", got)
+	}
+}
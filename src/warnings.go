@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// Warning is something about an accepted receipt that was fixed up or looks off, so partners can clean up their data
+// without their receipts being turned away.
+type Warning struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationConfig tunes checks beyond the format of the fields. TotalCheck compares the total with the sum of the
+// item prices: "strict" rejects receipts where they differ, "lenient" accepts them off by a cent with a warning. It is
+// off by default, receipts with tax or discounts don't add up.
+type ValidationConfig struct {
+	TotalCheck string `json:"totalCheck"`
+}
+
+func (c ValidationConfig) Validate() error {
+	if c.TotalCheck != "" && c.TotalCheck != "strict" && c.TotalCheck != "lenient" {
+		return fmt.Errorf("validation: unknown totalCheck %q, must be \"strict\" or \"lenient\"", c.TotalCheck)
+	}
+	return nil
+}
+
+// whitespaceWarnings warns about a retailer or item description with leading or trailing whitespace. The receipt is
+// accepted and stored as submitted, the points rules ignore the whitespace anyway.
+func (r ReceiptDTO) whitespaceWarnings() []Warning {
+	var warnings []Warning
+	check := func(field, s string) {
+		if strings.TrimSpace(s) != s {
+			warnings = append(warnings, Warning{Field: field, Message: "has leading or trailing whitespace"})
+		}
+	}
+	check("retailer", r.Retailer)
+	for i, item := range r.Items {
+		check("items."+strconv.Itoa(i)+".shortDescription", item.ShortDescription)
+	}
+	return warnings
+}
+
+// checkTotal compares the total with the sum of the item prices under the configured TotalCheck.
+func checkTotal(c ValidationConfig, items []Item, total float64) (*Warning, error) {
+	if c.TotalCheck == "" {
+		return nil, nil
+	}
+	var sum int64
+	for _, item := range items {
+		sum += cents(item.Price)
+	}
+	diff := cents(total) - sum
+	if diff == 0 {
+		return nil, nil
+	}
+	message := fmt.Sprintf("%d.%02d off the sum of the item prices, %d.%02d", abs(diff)/100, abs(diff)%100, sum/100, sum%100)
+	if c.TotalCheck == "lenient" && abs(diff) <= 1 {
+		return &Warning{Field: "total", Message: "is " + message}, nil
+	}
+	return nil, validation.NewError("validation_total_mismatch", "is "+message)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
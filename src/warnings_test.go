@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+)
+
+func TestCheckTotal(t *testing.T) {
+	items := []Item{{ShortDescription: "Gatorade", Price: 2.25}, {ShortDescription: "Gatorade", Price: 2.25}}
+	testCases := []struct {
+		name        string
+		totalCheck  string
+		total       float64
+		wantWarning string
+		wantErr     string
+	}{
+		{name: "off", totalCheck: "", total: 10.00},
+		{name: "strict, adds up", totalCheck: "strict", total: 4.50},
+		{name: "strict, a cent off", totalCheck: "strict", total: 4.51, wantErr: "is 0.01 off the sum of the item prices, 4.50"},
+		{name: "lenient, a cent off", totalCheck: "lenient", total: 4.49, wantWarning: "is 0.01 off the sum of the item prices, 4.50"},
+		{name: "lenient, two cents off", totalCheck: "lenient", total: 4.52, wantErr: "is 0.02 off the sum of the item prices, 4.50"},
+		{name: "lenient, dollars off", totalCheck: "lenient", total: 14.50, wantErr: "is 10.00 off the sum of the item prices, 4.50"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			warning, err := checkTotal(ValidationConfig{TotalCheck: tc.totalCheck}, items, tc.total)
+			if gotErr := errString(err); gotErr != tc.wantErr {
+				t.Errorf("checkTotal() error = %q, want %q", gotErr, tc.wantErr)
+			}
+			gotWarning := ""
+			if warning != nil {
+				gotWarning = warning.Message
+			}
+			if gotWarning != tc.wantWarning {
+				t.Errorf("checkTotal() warning = %q, want %q", gotWarning, tc.wantWarning)
+			}
+		})
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestValidationWarnings(t *testing.T) {
+	router := setup()
+	live := cfg
+	live.Validation = ValidationConfig{TotalCheck: "lenient"}
+	liveConfig.Store(&live)
+
+	receipt := receipttest.New().Retailer(" Target ").Item("Gatorade", "2.25").Item("  Gatorade", "2.25").Total("4.51").Build()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(receipt.JSON())))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("process status = %v, want 200: %s", rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		ID       string    `json:"id"`
+		Warnings []Warning `json:"warnings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	wantFields := []string{"retailer", "items.1.shortDescription", "total"}
+	if len(resp.Warnings) != len(wantFields) {
+		t.Fatalf("warnings = %+v, want them for %v", resp.Warnings, wantFields)
+	}
+	for i, field := range wantFields {
+		if resp.Warnings[i].Field != field {
+			t.Errorf("warnings[%d] = %+v, want one for %v", i, resp.Warnings[i], field)
+		}
+	}
+
+	stored, _, err := loadReceipt(t.Context(), resp.ID)
+	if err != nil {
+		t.Fatalf("loadReceipt() error = %v", err)
+	}
+	if stored.Retailer != " Target " {
+		t.Errorf("stored retailer = %q, want it as submitted", stored.Retailer)
+	}
+
+	// whitespace alone is a warning too, not a reason to turn the receipt away.
+	blank := receipttest.New().Item("   ", "2.25").Build()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewReader(blank.JSON())))
+	if rr.Code != http.StatusOK || !bytes.Contains(rr.Body.Bytes(), []byte("items.0.shortDescription")) {
+		t.Errorf("process = %v %s, want 200 with a warning for the description", rr.Code, rr.Body)
+	}
+
+	clean := receipttest.New().Item("Gatorade", "2.25").Build()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/score", bytes.NewReader(clean.JSON())))
+	if rr.Code != http.StatusOK || bytes.Contains(rr.Body.Bytes(), []byte("warnings")) {
+		t.Errorf("score = %v %s, want 200 without warnings", rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWasmMemoryMB = 4
+	defaultWasmTimeout  = 10 * time.Millisecond
+	// wasmPageSize is the size of a WebAssembly memory page.
+	wasmPageSize = 64 << 10
+)
+
+// WasmRuleConfig makes a scoring rule of a WebAssembly module, so rules can be deployed without a new build. The
+// module runs sandboxed: it can't import anything, gets at most MaxMemoryMB of memory (4 by default) and Timeout (10ms
+// by default) per receipt.
+//
+// The host API is two exported functions and the exported memory. alloc(size i32) i32 returns where in memory to put
+// the input, size bytes of JSON with the "receipt" as the API returns it and the rule's "points" from the rules config.
+// points(ptr i32, len i32) i32 returns what the rule awards the receipt, before the multiplier. Every receipt is scored
+// by a new instance, so nothing carries over from one receipt to the next.
+type WasmRuleConfig struct {
+	Path        string   `json:"path"`
+	Description string   `json:"description"`
+	MaxMemoryMB int      `json:"maxMemoryMB"`
+	Timeout     Duration `json:"timeout"`
+}
+
+func (c WasmRuleConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("wasm: path is required")
+	}
+	if c.MaxMemoryMB < 0 || c.MaxMemoryMB > 4096 {
+		return errors.New("wasm: maxMemoryMB must be between 0 and 4096")
+	}
+	if c.Timeout < 0 {
+		return errors.New("wasm: timeout must not be negative")
+	}
+	if _, err := loadWasmModule(c); err != nil {
+		return fmt.Errorf("wasm: %w", err)
+	}
+	return nil
+}
+
+func (c WasmRuleConfig) maxMemoryMB() int {
+	if c.MaxMemoryMB == 0 {
+		return defaultWasmMemoryMB
+	}
+	return c.MaxMemoryMB
+}
+
+func (c WasmRuleConfig) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultWasmTimeout
+	}
+	return time.Duration(c.Timeout)
+}
+
+var wasmRuleFailuresTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name: "fcpc_wasm_rule_failures_total",
+	Help: "Receipts a WebAssembly rule failed to score, awarding no points, by rule.",
+}, []string{"rule"})
+
+// wasmModule is a compiled rule module with the runtime enforcing its limits.
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+}
+
+// wasmModules caches the compiled modules by config. A module is only read and compiled once per path, so a changed
+// rule needs to be deployed under a new path.
+var wasmModules = struct {
+	mu      sync.Mutex
+	modules map[WasmRuleConfig]*wasmModule
+}{modules: map[WasmRuleConfig]*wasmModule{}}
+
+// loadWasmModule compiles the module c points to, or returns it from the cache, and checks that it implements the
+// host API.
+func loadWasmModule(c WasmRuleConfig) (*wasmModule, error) {
+	wasmModules.mu.Lock()
+	defer wasmModules.mu.Unlock()
+	if m, ok := wasmModules.modules[c]; ok {
+		return m, nil
+	}
+
+	code, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(c.maxMemoryMB()*(1<<20)/wasmPageSize)).
+		WithCloseOnContextDone(true))
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err == nil {
+		err = checkWasmHostAPI(compiled)
+	}
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("%s: %w", c.Path, err)
+	}
+
+	m := &wasmModule{runtime: runtime, compiled: compiled, timeout: c.timeout()}
+	wasmModules.modules[c] = m
+	return m, nil
+}
+
+func checkWasmHostAPI(compiled wazero.CompiledModule) error {
+	if imports := compiled.ImportedFunctions(); len(imports) > 0 {
+		module, name, _ := imports[0].Import()
+		return fmt.Errorf("imports %s.%s, rules can't import anything", module, name)
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return errors.New("doesn't export its memory")
+	}
+	i32 := api.ValueTypeI32
+	want := map[string][2][]api.ValueType{
+		"alloc":  {{i32}, {i32}},
+		"points": {{i32, i32}, {i32}},
+	}
+	exported := compiled.ExportedFunctions()
+	for name, signature := range want {
+		f, ok := exported[name]
+		if !ok || !slices.Equal(f.ParamTypes(), signature[0]) || !slices.Equal(f.ResultTypes(), signature[1]) {
+			return fmt.Errorf("doesn't export %s%s", name, map[string]string{"alloc": "(i32) i32", "points": "(i32, i32) i32"}[name])
+		}
+	}
+	return nil
+}
+
+// wasmInput is what a rule module gets for a receipt.
+type wasmInput struct {
+	Receipt ReceiptDTO     `json:"receipt"`
+	Points  map[string]int `json:"points,omitempty"`
+}
+
+// points runs the module on input in a new instance.
+func (m *wasmModule) points(input []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	// an empty name lets instances of the same module run side by side.
+	instance, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return 0, err
+	}
+	defer instance.Close(ctx)
+
+	results, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return 0, fmt.Errorf("alloc: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !instance.Memory().Write(ptr, input) {
+		return 0, fmt.Errorf("alloc returned %d, out of memory", ptr)
+	}
+	results, err = instance.ExportedFunction("points").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return 0, fmt.Errorf("points: %w", err)
+	}
+	points := int32(results[0])
+	if points < 0 {
+		return 0, fmt.Errorf("points returned %d", points)
+	}
+	return int(points), nil
+}
+
+// wasmRule is the scoring rule of the WebAssembly rule name. A module that fails, times out or can't be loaded awards
+// nothing, and the failure is logged and counted.
+func wasmRule(name string, c WasmRuleConfig) scoringRule {
+	return scoringRule{name: name, description: c.Description, configured: func(r *Receipt, settings RuleConfig) int {
+		points, err := runWasmRule(c, r, settings)
+		if err != nil {
+			wasmRuleFailuresTotal.WithLabelValues(name).Inc()
+			logger.Warn("WebAssembly rule failed", zap.String("rule", name), zap.Error(err))
+			return 0
+		}
+		return points
+	}}
+}
+
+func runWasmRule(c WasmRuleConfig, r *Receipt, settings RuleConfig) (int, error) {
+	m, err := loadWasmModule(c)
+	if err != nil {
+		return 0, err
+	}
+	input, err := json.Marshal(wasmInput{Receipt: r.ToDTO(), Points: settings.Points})
+	if err != nil {
+		return 0, err
+	}
+	return m.points(input)
+}
+
+// scoringRules are the rules to score with under rules: pointRules, then its WebAssembly rules by name.
+func scoringRules(rules RulesConfig) []scoringRule {
+	var names []string
+	for name, rule := range rules {
+		if rule.Wasm != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return pointRules
+	}
+	slices.Sort(names)
+	scoring := slices.Clone(pointRules)
+	for _, name := range names {
+		scoring = append(scoring, wasmRule(name, *rules[name].Wasm))
+	}
+	return scoring
+}
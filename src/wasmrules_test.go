@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MDanialSaleem/fcpc/receipttest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// wasmRuleModule writes a module implementing the host API, with memoryPages pages of memory, an alloc that always
+// returns 1024 and points as the body of points, and returns its path.
+func wasmRuleModule(t *testing.T, memoryPages byte, points ...byte) string {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	body := func(code ...byte) []byte {
+		return append([]byte{byte(len(code) + 2), 0}, append(code, 0x0b)...)
+	}
+	module := []byte{0, 'a', 's', 'm', 1, 0, 0, 0}
+	module = append(module, section(1, 2, 0x60, 1, 0x7f, 1, 0x7f, 0x60, 2, 0x7f, 0x7f, 1, 0x7f)...)
+	module = append(module, section(3, 2, 0, 1)...)
+	module = append(module, section(5, 1, 0, memoryPages)...)
+	module = append(module, section(7, 3,
+		6, 'm', 'e', 'm', 'o', 'r', 'y', 2, 0,
+		5, 'a', 'l', 'l', 'o', 'c', 0, 0,
+		6, 'p', 'o', 'i', 'n', 't', 's', 0, 1)...)
+	code := append([]byte{2}, body(0x41, 0x80, 0x08)...) // i32.const 1024
+	module = append(module, section(10, append(code, body(points...)...)...)...)
+
+	path := filepath.Join(t.TempDir(), "rule.wasm")
+	if err := os.WriteFile(path, module, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var (
+	wasmConstant  = []byte{0x41, 0x07}                               // i32.const 7
+	wasmFirstByte = []byte{0x20, 0x00, 0x2d, 0x00, 0x00}             // i32.load8_u of the input's first byte
+	wasmEndless   = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00} // loop br 0 end, i32.const 0
+	wasmNegative  = []byte{0x41, 0x7f}                               // i32.const -1
+)
+
+func TestWasmRules(t *testing.T) {
+	setup()
+	testCases := []struct {
+		name        string
+		rule        RuleConfig
+		want        int
+		wantFailure bool
+	}{
+		{name: "constant", rule: RuleConfig{Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)}}, want: 7},
+		{name: "multiplied", rule: RuleConfig{Multiplier: 2, Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)}}, want: 14},
+		{name: "reads the input", rule: RuleConfig{Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmFirstByte...)}}, want: '{'},
+		{name: "runs too long", rule: RuleConfig{Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmEndless...), Timeout: Duration(5 * time.Millisecond)}}, wantFailure: true},
+		{name: "negative points", rule: RuleConfig{Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmNegative...)}}, wantFailure: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := RulesConfig{"partnerBonus": tc.rule}
+			if err := rules.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			var receipt Receipt
+			if err := json.Unmarshal(receipttest.New().Build().JSON(), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			failures := testutil.ToFloat64(wasmRuleFailuresTotal.WithLabelValues("partnerBonus"))
+
+			results := receipt.Score(rules)
+			if last := results[len(results)-1]; last.Rule != "partnerBonus" || last.Points != tc.want {
+				t.Errorf("last rule scored %s with %d points, want partnerBonus with %d", last.Rule, last.Points, tc.want)
+			}
+			if got := testutil.ToFloat64(wasmRuleFailuresTotal.WithLabelValues("partnerBonus")) - failures; (got == 1) != tc.wantFailure {
+				t.Errorf("counted %v failures, want a failure: %v", got, tc.wantFailure)
+			}
+		})
+	}
+}
+
+func TestWasmRulesConfigValidate(t *testing.T) {
+	notAModule := filepath.Join(t.TempDir(), "rule.wasm")
+	os.WriteFile(notAModule, []byte("not a module"), 0o644)
+
+	testCases := []struct {
+		name    string
+		rules   RulesConfig
+		wantErr bool
+	}{
+		{name: "valid", rules: RulesConfig{"partnerBonus": {Points: map[string]int{"gold": 5}, Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)}}}},
+		{name: "built-in rule", rules: RulesConfig{"oddDay": {Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)}}}, wantErr: true},
+		{name: "no path", rules: RulesConfig{"partnerBonus": {Wasm: &WasmRuleConfig{}}}, wantErr: true},
+		{name: "missing file", rules: RulesConfig{"partnerBonus": {Wasm: &WasmRuleConfig{Path: filepath.Join(t.TempDir(), "missing.wasm")}}}, wantErr: true},
+		{name: "not a module", rules: RulesConfig{"partnerBonus": {Wasm: &WasmRuleConfig{Path: notAModule}}}, wantErr: true},
+		{name: "more memory than allowed", rules: RulesConfig{"partnerBonus": {Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 32, wasmConstant...), MaxMemoryMB: 1}}}, wantErr: true},
+		{name: "module per region", rules: RulesConfig{"partnerBonus": {
+			Wasm:    &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)},
+			Regions: map[string]RuleConfig{"west": {Wasm: &WasmRuleConfig{Path: wasmRuleModule(t, 1, wasmConstant...)}}},
+		}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rules.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}